@@ -17,16 +17,30 @@
 #include <arpa/inet.h>
 #include <errno.h>
 #include <fcntl.h>
+#include <linux/filter.h>
+#include <linux/if_ether.h>
+#include <linux/if_link.h>
+#include <linux/if_packet.h>
+#include <linux/mount.h>
+#include <linux/perf_event.h>
+#include <linux/seccomp.h>
 #include <netinet/in.h>
+#include <netinet/tcp.h>
+#include <poll.h>
+#include <sched.h>
 #include <stdio.h>
 #include <sys/ioctl.h>
 #include <sys/mman.h>
+#include <sys/prctl.h>
 #include <sys/socket.h>
 #include <sys/syscall.h>
+#include <sys/wait.h>
 #include <unistd.h>
 
+#include <algorithm>
 #include <string>
 #include <unordered_set>
+#include <vector>
 
 #include "absl/container/flat_hash_set.h"
 #include "absl/strings/escaping.h"
@@ -42,17 +56,57 @@
 #define KCOV_TRACE_CMP 1
 
 using ebpf_fuzzer::ExecutionRequest;
+using ebpf_fuzzer::BatchEntry;
+using ebpf_fuzzer::BatchRequest;
+using ebpf_fuzzer::BatchResult;
+using ebpf_fuzzer::CgroupRequest;
 using ebpf_fuzzer::ExecutionResult;
 using ebpf_fuzzer::MapElements;
+using ebpf_fuzzer::ProgramInfo;
+using ebpf_fuzzer::RingbufRecord;
+using ebpf_fuzzer::RingbufRecords;
+using ebpf_fuzzer::SeccompRequest;
+using ebpf_fuzzer::SeccompResult;
+using ebpf_fuzzer::SocketFilterRequest;
+using ebpf_fuzzer::SocketFilterResult;
+using ebpf_fuzzer::TestRunRequest;
+using ebpf_fuzzer::TriggerSocket;
+using ebpf_fuzzer::XdpRequest;
 using ebpf_fuzzer::ValidationResult;
 
 namespace ebpf_ffi {
 
-// This constant was determined arbitrarily, the number of 0's has incremented
-// when the size was no longer enough for the verifier logs.
-constexpr size_t kLogBuffSize = 100000000;
+// The verifier log buffer starts small and doubles every time the kernel
+// fails a load with ENOSPC because the log did not fit, up to
+// kMaxLogBuffSize. The maximum was determined arbitrarily, the number of 0's
+// has incremented when the size was no longer enough for the verifier logs.
+constexpr size_t kInitialLogBuffSize = 1 << 16;
+constexpr size_t kMaxLogBuffSize = 100000000;
+
+// Room left after the packet of a BPF_PROG_TEST_RUN for the program to grow
+// it.
+constexpr size_t kTestRunSlack = 4096;
+
+// The BTF log is much shorter than the verifier one, it stops at the first
+// error.
+constexpr size_t kBtfLogBuffSize = 1 << 20;
+
+// Sizes of struct bpf_func_info and struct bpf_line_info.
+constexpr uint32_t kFuncInfoSize = 8;
+constexpr uint32_t kLineInfoSize = 16;
+
+// BPF tokens, added in 6.9, are newer than the uapi headers buzzer is usually
+// built against: BPF_TOKEN_CREATE, BPF_F_TOKEN_FD and the offset of
+// prog_token_fd in the BPF_PROG_LOAD attributes.
+constexpr int kBpfTokenCreate = 36;
+constexpr uint32_t kBpfFTokenFd = 1U << 16;
+constexpr size_t kProgTokenFdOffset = 144;
 }  // namespace ebpf_ffi
 
+// BPF token the programs loaded by the calling thread go through, -1 if none,
+// see ffi_set_thread_bpf_token.
+static thread_local int thread_bpf_token = -1;
+
 bpf_result serialize_proto(const google::protobuf::Message &proto) {
   std::string proto_encoded;
   absl::Base64Escape(proto.SerializeAsString(), &proto_encoded);
@@ -107,9 +161,17 @@ void get_coverage_and_free_resources(struct coverage_data *cstruct,
   munmap(cstruct->coverage_buffer, cstruct->coverage_size * sizeof(uint64_t));
 }
 
-struct bpf_result ffi_load_bpf_program(void *prog_buff, size_t size,
-                                       int coverage_enabled,
-                                       uint64_t coverage_size) {
+// Loads the program of |prog_buff| as |prog_type|, with |prog_flags| and the
+// BTF of |btf|, if not null, and builds the ValidationResult. If coverage is
+// enabled it is collected over both the BTF load and the program load. A BTF
+// blob the kernel refuses is reported like a verifier rejection, with the BTF
+// log as verifier log.
+static struct bpf_result load_with_coverage(void *prog_buff, size_t size,
+                                            const struct btf_data *btf,
+                                            int prog_type,
+                                            uint32_t prog_flags,
+                                            int coverage_enabled,
+                                            uint64_t coverage_size) {
   std::string verifier_log, error_message;
   struct coverage_data cover;
   memset(&cover, 0, sizeof(struct coverage_data));
@@ -117,14 +179,37 @@ struct bpf_result ffi_load_bpf_program(void *prog_buff, size_t size,
   cover.coverage_size = coverage_size;
   if (coverage_enabled) enable_coverage(&cover);
 
-  int program_fd =
-      load_bpf_program(prog_buff, size, &verifier_log, &error_message);
+  int program_fd = -1;
+  int load_errno = 0;
+  if (btf == nullptr) {
+    program_fd = load_bpf_program(prog_buff, size, &verifier_log,
+                                  &error_message, 2, nullptr, prog_type,
+                                  prog_flags);
+    load_errno = program_fd < 0 ? errno : 0;
+  } else {
+    int btf_fd =
+        load_btf(btf->blob, btf->blob_size, &verifier_log, &error_message);
+    load_errno = btf_fd < 0 ? errno : 0;
+    if (btf_fd < 0) {
+      error_message = "BTF: " + error_message;
+    } else {
+      struct btf_data loaded = *btf;
+      loaded.btf_fd = btf_fd;
+      program_fd = load_bpf_program(prog_buff, size, &verifier_log,
+                                    &error_message, 2, &loaded, prog_type,
+                                    prog_flags);
+      load_errno = program_fd < 0 ? errno : 0;
+      // The program keeps its own reference to the BTF.
+      close(btf_fd);
+    }
+  }
 
   ValidationResult vres;
   if (coverage_enabled) get_coverage_and_free_resources(&cover, &vres);
 
   // Start building the validation result proto.
   vres.set_verifier_log(verifier_log);
+  vres.set_verifier_log_truncated(load_errno == ENOSPC);
   vres.set_program_fd(program_fd);
 
   if (cover.fd != -1) {
@@ -138,6 +223,7 @@ struct bpf_result ffi_load_bpf_program(void *prog_buff, size_t size,
   if (program_fd < 0) {
     // Return why we failed to load the program.
     vres.set_bpf_error(error_message);
+    vres.set_bpf_errno(load_errno);
     vres.set_is_valid(false);
   } else {
     vres.set_is_valid(true);
@@ -146,33 +232,150 @@ struct bpf_result ffi_load_bpf_program(void *prog_buff, size_t size,
   return serialize_proto(vres);
 }
 
-int load_bpf_program(void *prog_buff, size_t prog_size,
-                     std::string *verifier_log, std::string *error) {
-  struct bpf_insn *insn;
+struct bpf_result ffi_load_bpf_program(void *prog_buff, size_t size,
+                                       int coverage_enabled,
+                                       uint64_t coverage_size) {
+  return load_with_coverage(prog_buff, size, nullptr,
+                            BPF_PROG_TYPE_SOCKET_FILTER, 0, coverage_enabled,
+                            coverage_size);
+}
+
+struct bpf_result ffi_load_bpf_program_of_type(void *prog_buff, size_t size,
+                                               int prog_type,
+                                               uint32_t prog_flags,
+                                               int coverage_enabled,
+                                               uint64_t coverage_size) {
+  return load_with_coverage(prog_buff, size, nullptr, prog_type, prog_flags,
+                            coverage_enabled, coverage_size);
+}
+
+struct bpf_result ffi_load_bpf_program_with_btf(
+    void *prog_buff, size_t size, void *btf, size_t btf_size, void *func_info,
+    uint32_t func_info_cnt, void *line_info, uint32_t line_info_cnt,
+    int coverage_enabled, uint64_t coverage_size) {
+  struct btf_data data = {.blob = btf,
+                          .blob_size = btf_size,
+                          .btf_fd = -1,
+                          .func_info = func_info,
+                          .func_info_cnt = func_info_cnt,
+                          .line_info = line_info,
+                          .line_info_cnt = line_info_cnt};
+  return load_with_coverage(prog_buff, size, &data,
+                            BPF_PROG_TYPE_SOCKET_FILTER, 0, coverage_enabled,
+                            coverage_size);
+}
+
+int load_btf(void *btf, size_t btf_size, std::string *log,
+             std::string *error) {
   union bpf_attr attr = {};
+  char *log_buf = (char *)calloc(ebpf_ffi::kBtfLogBuffSize, 1);
+  attr.btf = (uint64_t)btf;
+  attr.btf_size = btf_size;
+  attr.btf_log_buf = (uint64_t)log_buf;
+  attr.btf_log_size = ebpf_ffi::kBtfLogBuffSize;
+  attr.btf_log_level = 1;
 
-  // For the verifier log.
-  unsigned char *log_buf = (unsigned char *)malloc(ebpf_ffi::kLogBuffSize);
-  memset(log_buf, 0, ebpf_ffi::kLogBuffSize);
+  int btf_fd = syscall(SYS_bpf, BPF_BTF_LOAD, &attr, sizeof(attr));
+  int load_errno = errno;
+  if (btf_fd < 0) {
+    *error = strerror(load_errno);
+  }
+  *log = std::string(log_buf, strlen(log_buf));
+  free(log_buf);
+  errno = load_errno;
+  return btf_fd;
+}
 
-  insn = (struct bpf_insn *)prog_buff;
-  attr.prog_type = BPF_PROG_TYPE_SOCKET_FILTER;
-  attr.insns = (uint64_t)insn;
-  attr.insn_cnt = (prog_size * sizeof(uint64_t)) / (sizeof(struct bpf_insn));
-  attr.license = (uint64_t) "GPL";
-  attr.log_size = ebpf_ffi::kLogBuffSize;
-  attr.log_buf = (uint64_t)log_buf;
-  attr.log_level = 2;
+struct bpf_result ffi_load_bpf_program_with_log_level(void *prog_buff,
+                                                      size_t size,
+                                                      uint32_t log_level) {
+  std::string verifier_log, error_message;
+  int program_fd = load_bpf_program(prog_buff, size, &verifier_log,
+                                    &error_message, log_level);
+  int load_errno = program_fd < 0 ? errno : 0;
 
-  int program_fd = syscall(SYS_bpf, BPF_PROG_LOAD, &attr, sizeof(attr));
+  ValidationResult vres;
+  vres.set_verifier_log(verifier_log);
+  vres.set_verifier_log_truncated(load_errno == ENOSPC);
+  vres.set_program_fd(program_fd);
+  vres.set_did_collect_coverage(false);
   if (program_fd < 0) {
-    *error = strerror(errno);
+    vres.set_bpf_error(error_message);
+    vres.set_bpf_errno(load_errno);
+    vres.set_is_valid(false);
+  } else {
+    vres.set_is_valid(true);
   }
+  return serialize_proto(vres);
+}
 
-  *verifier_log =
-      std::string((const char *)log_buf, strlen((const char *)log_buf));
+// Issues BPF_PROG_LOAD with |attr| through the BPF token of the calling
+// thread, if it has one.
+static int prog_load(union bpf_attr *attr) {
+  if (thread_bpf_token < 0) {
+    return syscall(SYS_bpf, BPF_PROG_LOAD, attr, sizeof(*attr));
+  }
+  alignas(8) char buffer[std::max(sizeof(*attr),
+                                  ebpf_ffi::kProgTokenFdOffset +
+                                      sizeof(int32_t))] = {};
+  memcpy(buffer, attr, sizeof(*attr));
+  uint32_t prog_flags = attr->prog_flags | ebpf_ffi::kBpfFTokenFd;
+  memcpy(buffer + offsetof(union bpf_attr, prog_flags), &prog_flags,
+         sizeof(prog_flags));
+  int32_t token_fd = thread_bpf_token;
+  memcpy(buffer + ebpf_ffi::kProgTokenFdOffset, &token_fd, sizeof(token_fd));
+  return syscall(SYS_bpf, BPF_PROG_LOAD, buffer, sizeof(buffer));
+}
 
-  free(log_buf);
+int load_bpf_program(void *prog_buff, size_t prog_size,
+                     std::string *verifier_log, std::string *error,
+                     uint32_t log_level, const struct btf_data *btf,
+                     int prog_type, uint32_t prog_flags) {
+  // The kernel refuses a log buffer without a log level.
+  size_t log_size = log_level != 0 ? ebpf_ffi::kInitialLogBuffSize : 0;
+  std::vector<char> log_buf;
+  int program_fd, load_errno;
+  while (true) {
+    union bpf_attr attr = {};
+    attr.prog_type = prog_type;
+    attr.prog_flags = prog_flags;
+    attr.insns = (uint64_t)prog_buff;
+    attr.insn_cnt = (prog_size * sizeof(uint64_t)) / (sizeof(struct bpf_insn));
+    attr.license = (uint64_t) "GPL";
+    log_buf.assign(log_size + 1, 0);
+    if (log_size != 0) {
+      attr.log_size = log_size;
+      attr.log_buf = (uint64_t)log_buf.data();
+      attr.log_level = log_level;
+    }
+    if (btf != nullptr) {
+      attr.prog_btf_fd = btf->btf_fd;
+      attr.func_info = (uint64_t)btf->func_info;
+      attr.func_info_rec_size = ebpf_ffi::kFuncInfoSize;
+      attr.func_info_cnt = btf->func_info_cnt;
+      attr.line_info = (uint64_t)btf->line_info;
+      attr.line_info_rec_size = ebpf_ffi::kLineInfoSize;
+      attr.line_info_cnt = btf->line_info_cnt;
+    }
+
+    program_fd = prog_load(&attr);
+    load_errno = errno;
+    if (program_fd >= 0 || load_errno != ENOSPC || log_size == 0 ||
+        log_size >= ebpf_ffi::kMaxLogBuffSize) {
+      break;
+    }
+    // The log did not fit, the verifier has to run again to fill a bigger
+    // buffer.
+    log_size = std::min(log_size * 2, ebpf_ffi::kMaxLogBuffSize);
+  }
+  if (program_fd < 0) {
+    *error = strerror(load_errno);
+  }
+
+  *verifier_log = std::string(log_buf.data(), strlen(log_buf.data()));
+
+  // Callers tell transient failures apart from verifier rejections with it.
+  errno = load_errno;
   return program_fd;
 }
 
@@ -191,6 +394,97 @@ struct bpf_result ffi_get_map_elements(int map_fd, uint64_t map_size) {
   return serialize_proto(res);
 }
 
+struct bpf_result ffi_get_map_value(int map_fd, uint32_t key, uint64_t words) {
+  MapElements res;
+  std::vector<uint64_t> value;
+  std::string error_message;
+  if (!get_map_value(map_fd, key, words, &value, &error_message)) {
+    res.set_error_message(error_message);
+    return serialize_proto(res);
+  }
+  auto proto_elements = res.mutable_elements();
+  proto_elements->Add(value.begin(), value.end());
+  return serialize_proto(res);
+}
+
+struct bpf_result ffi_consume_ringbuf(int map_fd, uint64_t size) {
+  RingbufRecords res;
+  std::vector<struct ringbuf_record> records;
+  std::string error_message;
+  if (!consume_ringbuf(map_fd, size, &records, &error_message)) {
+    res.set_error_message(error_message);
+    return serialize_proto(res);
+  }
+  for (const auto &record : records) {
+    RingbufRecord *proto_record = res.add_records();
+    proto_record->set_data(record.data);
+    proto_record->set_discarded(record.discarded);
+  }
+  return serialize_proto(res);
+}
+
+bool consume_ringbuf(int map_fd, size_t size,
+                     std::vector<struct ringbuf_record> *res,
+                     std::string *error) {
+  size_t page_size = sysconf(_SC_PAGESIZE);
+  // The consumer position is on the first page, the only one user space can
+  // write to, the producer position on the second one, followed by the data
+  // area mapped twice in a row so records that wrap around can be read in
+  // one go.
+  void *consumer = mmap(nullptr, page_size, PROT_READ | PROT_WRITE, MAP_SHARED,
+                        map_fd, 0);
+  if (consumer == MAP_FAILED) {
+    *error = strerror(errno);
+    return false;
+  }
+  size_t producer_size = page_size + 2 * size;
+  void *producer =
+      mmap(nullptr, producer_size, PROT_READ, MAP_SHARED, map_fd, page_size);
+  if (producer == MAP_FAILED) {
+    *error = strerror(errno);
+    munmap(consumer, page_size);
+    return false;
+  }
+
+  uint64_t *consumer_pos = reinterpret_cast<uint64_t *>(consumer);
+  uint64_t *producer_pos = reinterpret_cast<uint64_t *>(producer);
+  uint8_t *data = reinterpret_cast<uint8_t *>(producer) + page_size;
+  uint64_t cons_pos = __atomic_load_n(consumer_pos, __ATOMIC_ACQUIRE);
+  uint64_t prod_pos = __atomic_load_n(producer_pos, __ATOMIC_ACQUIRE);
+  while (cons_pos < prod_pos) {
+    uint32_t *header =
+        reinterpret_cast<uint32_t *>(data + (cons_pos & (size - 1)));
+    uint32_t len = __atomic_load_n(header, __ATOMIC_ACQUIRE);
+    // Reserved but not submitted nor discarded yet.
+    if (len & BPF_RINGBUF_BUSY_BIT) break;
+
+    bool discarded = len & BPF_RINGBUF_DISCARD_BIT;
+    len &= ~(BPF_RINGBUF_BUSY_BIT | BPF_RINGBUF_DISCARD_BIT);
+    // The header is written by the program under test, a record must fit
+    // in what the producer submitted and in the data area, the copy would
+    // read past the mapping otherwise.
+    uint64_t available = prod_pos - cons_pos;
+    if (BPF_RINGBUF_HDR_SZ + static_cast<uint64_t>(len) > available ||
+        BPF_RINGBUF_HDR_SZ + static_cast<uint64_t>(len) > size) {
+      *error = "ring buffer record of " + std::to_string(len) +
+               " bytes at position " + std::to_string(cons_pos) +
+               " exceeds the data area";
+      munmap(producer, producer_size);
+      munmap(consumer, page_size);
+      return false;
+    }
+    const char *record = reinterpret_cast<const char *>(header) +
+                         BPF_RINGBUF_HDR_SZ;
+    res->push_back({std::string(record, len), discarded});
+    cons_pos += (len + BPF_RINGBUF_HDR_SZ + 7) & ~7ULL;
+    __atomic_store_n(consumer_pos, cons_pos, __ATOMIC_RELEASE);
+  }
+
+  munmap(producer, producer_size);
+  munmap(consumer, page_size);
+  return true;
+}
+
 bool get_map_elements(int map_fd, size_t map_size, std::vector<uint64_t> *res,
                       std::string *error) {
   for (uint64_t key = 0; key < map_size; key++) {
@@ -209,12 +503,29 @@ bool get_map_elements(int map_fd, size_t map_size, std::vector<uint64_t> *res,
   return true;
 }
 
+bool get_map_value(int map_fd, uint32_t key, size_t words,
+                   std::vector<uint64_t> *res, std::string *error) {
+  std::vector<uint64_t> value(words);
+  union bpf_attr lookup_map = {.map_fd = static_cast<uint32_t>(map_fd),
+                               .key = reinterpret_cast<uint64_t>(&key),
+                               .value = reinterpret_cast<uint64_t>(value.data())};
+  if (syscall(SYS_bpf, BPF_MAP_LOOKUP_ELEM, &lookup_map, sizeof(lookup_map)) <
+      0) {
+    *error = strerror(errno);
+    return false;
+  }
+  *res = std::move(value);
+  return true;
+}
+
 int bpf_create_map(enum bpf_map_type map_type, unsigned int key_size,
-                   unsigned int value_size, unsigned int max_entries) {
+                   unsigned int value_size, unsigned int max_entries,
+                   uint32_t map_flags) {
   union bpf_attr attr = {.map_type = map_type,
                          .key_size = key_size,
                          .value_size = value_size,
-                         .max_entries = max_entries};
+                         .max_entries = max_entries,
+                         .map_flags = map_flags};
 
   return syscall(SYS_bpf, BPF_MAP_CREATE, &attr, sizeof(attr));
 }
@@ -224,6 +535,55 @@ int ffi_create_bpf_map(size_t size) {
                         size);
 }
 
+int ffi_create_hash_map(int map_type, size_t max_entries, uint32_t flags) {
+  return bpf_create_map(static_cast<enum bpf_map_type>(map_type),
+                        sizeof(uint32_t), sizeof(uint64_t), max_entries, flags);
+}
+
+int ffi_create_map(int map_type, uint32_t key_size, uint32_t value_size,
+                   size_t max_entries, uint32_t flags) {
+  return bpf_create_map(static_cast<enum bpf_map_type>(map_type), key_size,
+                        value_size, max_entries, flags);
+}
+
+int ffi_create_map_of_maps(int map_type, int inner_map_fd, size_t max_entries,
+                           uint32_t flags) {
+  union bpf_attr attr = {};
+  attr.map_type = map_type;
+  attr.key_size = sizeof(uint32_t);
+  // The values are map fds when updated from user space.
+  attr.value_size = sizeof(uint32_t);
+  attr.max_entries = max_entries;
+  attr.map_flags = flags;
+  attr.inner_map_fd = inner_map_fd;
+  return syscall(SYS_bpf, BPF_MAP_CREATE, &attr, sizeof(attr));
+}
+
+int ffi_create_map_with_btf(int map_type, uint32_t key_size,
+                            uint32_t value_size, size_t max_entries,
+                            uint32_t flags, void *btf, size_t btf_size,
+                            uint32_t key_type_id, uint32_t value_type_id) {
+  std::string log, error;
+  int btf_fd = load_btf(btf, btf_size, &log, &error);
+  if (btf_fd < 0) return -1;
+
+  union bpf_attr attr = {};
+  attr.map_type = map_type;
+  attr.key_size = key_size;
+  attr.value_size = value_size;
+  attr.max_entries = max_entries;
+  attr.map_flags = flags;
+  attr.btf_fd = btf_fd;
+  attr.btf_key_type_id = key_type_id;
+  attr.btf_value_type_id = value_type_id;
+  int map_fd = syscall(SYS_bpf, BPF_MAP_CREATE, &attr, sizeof(attr));
+  int map_errno = errno;
+  // The map keeps its own reference to the BTF.
+  close(btf_fd);
+  errno = map_errno;
+  return map_fd;
+}
+
 bool execute_error(std::string *error_message, const char *strerr,
                    int *sockets) {
   if (sockets != nullptr) {
@@ -264,7 +624,16 @@ struct bpf_result ffi_execute_bpf_program(void *serialized_proto,
   }
 
   std::string error_message;
-  if (!execute_bpf_program(prog_fd, data, data_size, &error_message)) {
+  if (!execution_request.tracepoint().empty()) {
+    if (!execute_tracepoint_program(prog_fd, execution_request.tracepoint(),
+                                    &error_message)) {
+      return return_error(error_message, &execution_result);
+    }
+    execution_result.set_did_succeed(true);
+    return serialize_proto(execution_result);
+  }
+  if (!execute_on_socket(prog_fd, execution_request.socket(), data, data_size,
+                         execution_request.fragments(), &error_message)) {
     return return_error(error_message, &execution_result);
   }
 
@@ -272,8 +641,230 @@ struct bpf_result ffi_execute_bpf_program(void *serialized_proto,
   return serialize_proto(execution_result);
 }
 
+// Runs |prog_fd| with BPF_PROG_TEST_RUN on the data and context of
+// |request|, whose prog_fd is ignored.
+static void test_run_bpf_program(int prog_fd, const TestRunRequest &request,
+                                 ExecutionResult *result) {
+  const std::string &data_in = request.data_in();
+  const std::string &ctx_in = request.ctx_in();
+  // Programs can grow the packet, e.g. with bpf_skb_change_tail, leave them
+  // some room so the kernel does not fail the run with ENOSPC.
+  std::vector<char> data_out(data_in.size() + ebpf_ffi::kTestRunSlack);
+
+  union bpf_attr attr = {};
+  attr.test.prog_fd = static_cast<uint32_t>(prog_fd);
+  // BPF_PROG_TYPE_SYSCALL programs only run on a context, the kernel refuses
+  // any packet buffer.
+  if (!data_in.empty()) {
+    attr.test.data_in = reinterpret_cast<uint64_t>(data_in.data());
+    attr.test.data_size_in = data_in.size();
+    attr.test.data_out = reinterpret_cast<uint64_t>(data_out.data());
+    attr.test.data_size_out = data_out.size();
+  }
+  if (!ctx_in.empty()) {
+    attr.test.ctx_in = reinterpret_cast<uint64_t>(ctx_in.data());
+    attr.test.ctx_size_in = ctx_in.size();
+  }
+  attr.test.repeat = request.repeat();
+  if (syscall(SYS_bpf, BPF_PROG_TEST_RUN, &attr, sizeof(attr)) != 0) {
+    result->set_did_succeed(false);
+    result->set_error_message(strerror(errno));
+    return;
+  }
+
+  result->set_did_succeed(true);
+  result->set_retval(attr.test.retval);
+  result->set_duration_ns(attr.test.duration);
+  result->set_data_out(data_out.data(),
+                       std::min<size_t>(attr.test.data_size_out,
+                                        data_out.size()));
+}
+
+struct bpf_result ffi_test_run_bpf_program(void *serialized_proto,
+                                           size_t length) {
+  ExecutionResult execution_result;
+
+  std::string serialized_proto_string(
+      reinterpret_cast<const char *>(serialized_proto), length);
+  TestRunRequest request;
+  if (!request.ParseFromString(serialized_proto_string)) {
+    return return_error("Could not parse TestRunRequest proto",
+                        &execution_result);
+  }
+
+  test_run_bpf_program(request.prog_fd(), request, &execution_result);
+  return serialize_proto(execution_result);
+}
+
+struct bpf_result ffi_run_batch(void *serialized_proto, size_t length) {
+  BatchResult batch_result;
+
+  std::string serialized_proto_string(
+      reinterpret_cast<const char *>(serialized_proto), length);
+  BatchRequest request;
+  if (!request.ParseFromString(serialized_proto_string)) {
+    batch_result.set_error_message("Could not parse BatchRequest proto");
+    return serialize_proto(batch_result);
+  }
+
+  for (const auto &program : request.programs()) {
+    BatchEntry *entry = batch_result.add_entries();
+    ValidationResult *vres = entry->mutable_validation();
+    std::string verifier_log, error_message;
+    // The kernel only reads the instructions.
+    void *prog_buff = const_cast<uint64_t *>(program.instructions().data());
+    int program_fd = load_bpf_program(prog_buff, program.instructions_size(),
+                                      &verifier_log, &error_message, 2,
+                                      nullptr, program.prog_type());
+    int load_errno = program_fd < 0 ? errno : 0;
+
+    vres->set_verifier_log(verifier_log);
+    vres->set_verifier_log_truncated(load_errno == ENOSPC);
+    vres->set_program_fd(program_fd);
+    vres->set_did_collect_coverage(false);
+    if (program_fd < 0) {
+      vres->set_bpf_error(error_message);
+      vres->set_bpf_errno(load_errno);
+      vres->set_is_valid(false);
+      continue;
+    }
+    vres->set_is_valid(true);
+    test_run_bpf_program(program_fd, request.test_run(),
+                         entry->mutable_execution());
+    close(program_fd);
+  }
+  return serialize_proto(batch_result);
+}
+
+// Writes |input| to |fd| in |fragments| writes of about the same size, the
+// last one gets the remainder.
+static bool write_fragments(int fd, const uint8_t *input, int input_length,
+                            int fragments, std::string *error_message) {
+  fragments = std::max(1, std::min(fragments, input_length));
+  int offset = 0;
+  for (int i = 0; i < fragments; i++) {
+    int length = input_length / fragments;
+    if (i == fragments - 1) {
+      length = input_length - offset;
+    }
+    if (send(fd, input + offset, length, 0) != length) {
+      *error_message = "Could not write all data to socket";
+      return false;
+    }
+    offset += length;
+  }
+  return true;
+}
+
 bool execute_bpf_program(int prog_fd, uint8_t *input, int input_length,
                          std::string *error_message) {
+  return execute_on_socket(prog_fd, ebpf_fuzzer::UnixDgram, input,
+                           input_length, 1, error_message);
+}
+
+// Fills |addr| with the loopback address of |family|, port 0, and returns its
+// size.
+static socklen_t loopback_address(int family, struct sockaddr_storage *addr) {
+  memset(addr, 0, sizeof(*addr));
+  if (family == AF_INET6) {
+    struct sockaddr_in6 *in6 = reinterpret_cast<struct sockaddr_in6 *>(addr);
+    in6->sin6_family = AF_INET6;
+    in6->sin6_addr = in6addr_loopback;
+    return sizeof(*in6);
+  }
+  struct sockaddr_in *in = reinterpret_cast<struct sockaddr_in *>(addr);
+  in->sin_family = AF_INET;
+  in->sin_addr.s_addr = htonl(INADDR_LOOPBACK);
+  return sizeof(*in);
+}
+
+// Attaches |prog_fd| to a socket of |type|, SOCK_DGRAM or SOCK_STREAM, bound
+// to the loopback address of |family| and sends |input| to it from another
+// one. For TCP the program is attached to the accepted socket, it only runs
+// on the data segments.
+static bool execute_on_inet_socket(int prog_fd, int family, int type,
+                                   const uint8_t *input, int input_length,
+                                   int fragments,
+                                   std::string *error_message) {
+  // The receiver, the sender and, for TCP, the accepted socket.
+  int fds[3] = {-1, -1, -1};
+  auto close_all = [&fds]() {
+    for (int fd : fds) {
+      if (fd >= 0) {
+        close(fd);
+      }
+    }
+  };
+  auto fail = [&close_all, error_message](const std::string &error) {
+    *error_message = error;
+    close_all();
+    return false;
+  };
+
+  struct sockaddr_storage addr;
+  socklen_t addr_len = loopback_address(family, &addr);
+  fds[0] = socket(family, type | SOCK_CLOEXEC, 0);
+  if (fds[0] < 0 ||
+      bind(fds[0], reinterpret_cast<struct sockaddr *>(&addr), addr_len) !=
+          0 ||
+      getsockname(fds[0], reinterpret_cast<struct sockaddr *>(&addr),
+                  &addr_len) != 0) {
+    return fail(strerror(errno));
+  }
+  if (type == SOCK_STREAM && listen(fds[0], 1) != 0) {
+    return fail(strerror(errno));
+  }
+  fds[1] = socket(family, type | SOCK_CLOEXEC, 0);
+  if (fds[1] < 0 ||
+      connect(fds[1], reinterpret_cast<struct sockaddr *>(&addr), addr_len) !=
+          0) {
+    return fail(strerror(errno));
+  }
+
+  int receiver = fds[0];
+  if (type == SOCK_STREAM) {
+    fds[2] = accept4(fds[0], nullptr, nullptr, SOCK_CLOEXEC);
+    if (fds[2] < 0) {
+      return fail(strerror(errno));
+    }
+    receiver = fds[2];
+    // Every fragment is sent in its own segment.
+    int one = 1;
+    setsockopt(fds[1], IPPROTO_TCP, TCP_NODELAY, &one, sizeof(one));
+  }
+  if (setsockopt(receiver, SOL_SOCKET, SO_ATTACH_BPF, &prog_fd,
+                 sizeof(prog_fd)) != 0) {
+    return fail(strerror(errno));
+  }
+
+  std::string write_error;
+  if (!write_fragments(fds[1], input, input_length, fragments, &write_error)) {
+    return fail(write_error);
+  }
+  close_all();
+  return true;
+}
+
+bool execute_on_socket(int prog_fd, int trigger_socket,
+                       uint8_t *input, int input_length, int fragments,
+                       std::string *error_message) {
+  switch (static_cast<TriggerSocket>(trigger_socket)) {
+    case ebpf_fuzzer::UdpIpv4:
+      return execute_on_inet_socket(prog_fd, AF_INET, SOCK_DGRAM, input,
+                                    input_length, fragments, error_message);
+    case ebpf_fuzzer::UdpIpv6:
+      return execute_on_inet_socket(prog_fd, AF_INET6, SOCK_DGRAM, input,
+                                    input_length, fragments, error_message);
+    case ebpf_fuzzer::TcpIpv4:
+      return execute_on_inet_socket(prog_fd, AF_INET, SOCK_STREAM, input,
+                                    input_length, fragments, error_message);
+    case ebpf_fuzzer::TcpIpv6:
+      return execute_on_inet_socket(prog_fd, AF_INET6, SOCK_STREAM, input,
+                                    input_length, fragments, error_message);
+    default:
+      break;
+  }
+
   int socks[2] = {};
   if (socketpair(AF_UNIX, SOCK_DGRAM, 0, socks) != 0) {
     return execute_error(error_message, strerror(errno), NULL);
@@ -284,9 +875,10 @@ bool execute_bpf_program(int prog_fd, uint8_t *input, int input_length,
     return execute_error(error_message, strerror(errno), socks);
   }
 
-  if (write(socks[1], input, input_length) != input_length) {
-    return execute_error(error_message, "Could not write all data to socket",
-                         socks);
+  std::string write_error;
+  if (!write_fragments(socks[1], input, input_length, fragments,
+                       &write_error)) {
+    return execute_error(error_message, write_error.c_str(), socks);
   }
 
   close(socks[0]);
@@ -294,6 +886,62 @@ bool execute_bpf_program(int prog_fd, uint8_t *input, int input_length,
   return true;
 }
 
+// Returns the id of |tracepoint|, e.g. syscalls/sys_enter_getppid, read from
+// tracefs, -1 if it can't be found.
+static int tracepoint_id(const std::string &tracepoint) {
+  for (const char *events :
+       {"/sys/kernel/tracing/events/", "/sys/kernel/debug/tracing/events/"}) {
+    std::string path = events + tracepoint + "/id";
+    FILE *f = fopen(path.c_str(), "r");
+    if (f == nullptr) {
+      continue;
+    }
+    int id = -1;
+    if (fscanf(f, "%d", &id) != 1) {
+      id = -1;
+    }
+    fclose(f);
+    if (id >= 0) {
+      return id;
+    }
+  }
+  return -1;
+}
+
+bool execute_tracepoint_program(int prog_fd, const std::string &tracepoint,
+                                std::string *error_message) {
+  int id = tracepoint_id(tracepoint);
+  if (id < 0) {
+    *error_message = "Could not find tracepoint " + tracepoint;
+    return false;
+  }
+
+  struct perf_event_attr attr = {};
+  attr.type = PERF_TYPE_TRACEPOINT;
+  attr.size = sizeof(attr);
+  attr.config = id;
+  attr.sample_period = 1;
+  attr.wakeup_events = 1;
+  // pid 0 and cpu -1 only trace the calling thread, the program does not
+  // run for the rest of the system.
+  int event_fd = syscall(SYS_perf_event_open, &attr, 0, -1, -1,
+                         PERF_FLAG_FD_CLOEXEC);
+  if (event_fd < 0) {
+    *error_message = strerror(errno);
+    return false;
+  }
+  if (ioctl(event_fd, PERF_EVENT_IOC_SET_BPF, prog_fd) != 0 ||
+      ioctl(event_fd, PERF_EVENT_IOC_ENABLE, 0) != 0) {
+    *error_message = strerror(errno);
+    close(event_fd);
+    return false;
+  }
+  syscall(SYS_getppid);
+  ioctl(event_fd, PERF_EVENT_IOC_DISABLE, 0);
+  close(event_fd);
+  return true;
+}
+
 void ffi_close_fd(int prog_fd) { close(prog_fd); }
 
 int ffi_update_map_element(int map_fd, int key, uint64_t value) {
@@ -305,3 +953,719 @@ int ffi_update_map_element(int map_fd, int key, uint64_t value) {
   };
   return syscall(SYS_bpf, BPF_MAP_UPDATE_ELEM, &attr, sizeof(attr));
 }
+
+int ffi_update_map_of_maps(int map_fd, int key, int inner_map_fd) {
+  uint32_t value = static_cast<uint32_t>(inner_map_fd);
+  union bpf_attr attr = {};
+  attr.map_fd = static_cast<uint32_t>(map_fd);
+  attr.key = reinterpret_cast<uint64_t>(&key);
+  attr.value = reinterpret_cast<uint64_t>(&value);
+  return syscall(SYS_bpf, BPF_MAP_UPDATE_ELEM, &attr, sizeof(attr));
+}
+
+int ffi_delete_map_element(int map_fd, int key) {
+  union bpf_attr attr = {};
+  attr.map_fd = static_cast<uint32_t>(map_fd);
+  attr.key = reinterpret_cast<uint64_t>(&key);
+  return syscall(SYS_bpf, BPF_MAP_DELETE_ELEM, &attr, sizeof(attr));
+}
+
+int ffi_clear_map(int map_fd) {
+  int deleted = 0;
+  uint32_t key = 0;
+  union bpf_attr attr = {};
+  attr.map_fd = static_cast<uint32_t>(map_fd);
+  // Without a key GET_NEXT_KEY returns the first one, deleting it every time
+  // walks the whole map.
+  attr.key = 0;
+  attr.next_key = reinterpret_cast<uint64_t>(&key);
+  while (syscall(SYS_bpf, BPF_MAP_GET_NEXT_KEY, &attr, sizeof(attr)) == 0) {
+    if (ffi_delete_map_element(map_fd, static_cast<int>(key)) < 0) {
+      return -1;
+    }
+    deleted++;
+  }
+  return errno == ENOENT ? deleted : -1;
+}
+
+int ffi_freeze_map(int map_fd) {
+  union bpf_attr attr = {};
+  attr.map_fd = static_cast<uint32_t>(map_fd);
+  return syscall(SYS_bpf, BPF_MAP_FREEZE, &attr, sizeof(attr));
+}
+
+struct bpf_result ffi_get_program_info(int prog_fd) {
+  ProgramInfo res;
+  std::vector<uint64_t> xlated;
+  std::string error_message;
+  if (!get_xlated_program(prog_fd, &xlated, &error_message)) {
+    res.set_error_message(error_message);
+    return serialize_proto(res);
+  }
+  auto proto_instructions = res.mutable_xlated_instructions();
+  proto_instructions->Add(xlated.begin(), xlated.end());
+  return serialize_proto(res);
+}
+
+int ffi_get_pinned_object(const char *path) {
+  union bpf_attr attr = {};
+  attr.pathname = reinterpret_cast<uint64_t>(path);
+  return syscall(SYS_bpf, BPF_OBJ_GET, &attr, sizeof(attr));
+}
+
+int ffi_pin_object(int fd, const char *path) {
+  union bpf_attr attr = {};
+  attr.bpf_fd = static_cast<uint32_t>(fd);
+  attr.pathname = reinterpret_cast<uint64_t>(path);
+  return syscall(SYS_bpf, BPF_OBJ_PIN, &attr, sizeof(attr));
+}
+
+bool get_xlated_program(int prog_fd, std::vector<uint64_t> *res,
+                        std::string *error) {
+  struct bpf_prog_info info = {};
+  union bpf_attr attr = {};
+  attr.info.bpf_fd = prog_fd;
+  attr.info.info_len = sizeof(info);
+  attr.info.info = reinterpret_cast<uint64_t>(&info);
+
+  // The first call only tells us how big the xlated program is.
+  if (syscall(SYS_bpf, BPF_OBJ_GET_INFO_BY_FD, &attr, sizeof(attr)) < 0) {
+    *error = strerror(errno);
+    return false;
+  }
+
+  uint32_t xlated_len = info.xlated_prog_len;
+  res->resize(xlated_len / sizeof(uint64_t));
+  memset(&info, 0, sizeof(info));
+  info.xlated_prog_len = xlated_len;
+  info.xlated_prog_insns = reinterpret_cast<uint64_t>(res->data());
+  attr.info.info_len = sizeof(info);
+
+  if (syscall(SYS_bpf, BPF_OBJ_GET_INFO_BY_FD, &attr, sizeof(attr)) < 0) {
+    *error = strerror(errno);
+    return false;
+  }
+  return true;
+}
+
+// Decodes the cBPF instructions in |encoded| into |filter| and returns the
+// program pointing to them.
+struct sock_fprog decode_classic_filter(
+    const google::protobuf::RepeatedField<uint64_t> &encoded,
+    std::vector<struct sock_filter> *filter) {
+  for (uint64_t e : encoded) {
+    struct sock_filter insn = {};
+    insn.code = static_cast<uint16_t>(e);
+    insn.jt = static_cast<uint8_t>(e >> 16);
+    insn.jf = static_cast<uint8_t>(e >> 24);
+    insn.k = static_cast<uint32_t>(e >> 32);
+    filter->push_back(insn);
+  }
+  struct sock_fprog fprog = {};
+  fprog.len = static_cast<unsigned short>(filter->size());
+  fprog.filter = filter->data();
+  return fprog;
+}
+
+// Outcome of a seccomp run, written by the child into memory shared with the
+// parent. Once the filter is installed every system call the child makes is
+// filtered, so it cannot report anything through a pipe or its exit code.
+struct seccomp_outcome {
+  int install_errno;
+  int filter_installed;
+  int did_syscall;
+  long syscall_return;
+};
+
+struct bpf_result ffi_run_seccomp_filter(void *serialized_proto,
+                                         size_t length) {
+  SeccompResult result;
+  std::string serialized_proto_string(
+      reinterpret_cast<const char *>(serialized_proto), length);
+  SeccompRequest request;
+  if (!request.ParseFromString(serialized_proto_string)) {
+    result.set_error_message("Could not parse SeccompRequest proto");
+    return serialize_proto(result);
+  }
+
+  // Everything the child needs is prepared here, after fork it can only
+  // use async-signal-safe functions.
+  std::vector<struct sock_filter> filter;
+  struct sock_fprog fprog = decode_classic_filter(request.filter(), &filter);
+  uint64_t args[6] = {};
+  for (int i = 0; i < request.syscall_args_size() && i < 6; i++) {
+    args[i] = request.syscall_args(i);
+  }
+  long syscall_nr = request.syscall_nr();
+
+  void *shared = mmap(nullptr, sizeof(struct seccomp_outcome),
+                      PROT_READ | PROT_WRITE, MAP_SHARED | MAP_ANONYMOUS, -1, 0);
+  if (shared == MAP_FAILED) {
+    result.set_error_message(strerror(errno));
+    return serialize_proto(result);
+  }
+  volatile struct seccomp_outcome *outcome =
+      static_cast<struct seccomp_outcome *>(shared);
+  memset(shared, 0, sizeof(struct seccomp_outcome));
+
+  pid_t pid = fork();
+  if (pid < 0) {
+    result.set_error_message(strerror(errno));
+    munmap(shared, sizeof(struct seccomp_outcome));
+    return serialize_proto(result);
+  }
+  if (pid == 0) {
+    if (prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) != 0 ||
+        syscall(__NR_seccomp, SECCOMP_SET_MODE_FILTER, 0, &fprog) != 0) {
+      outcome->install_errno = errno;
+      _exit(0);
+    }
+    outcome->filter_installed = 1;
+    long ret = syscall(syscall_nr, args[0], args[1], args[2], args[3],
+                       args[4], args[5]);
+    outcome->syscall_return = ret == -1 ? -errno : ret;
+    outcome->did_syscall = 1;
+    // The filter might not allow exit_group either, in that case the child
+    // still dies, just not with a clean exit status.
+    _exit(0);
+  }
+
+  int status = 0;
+  if (waitpid(pid, &status, 0) < 0) {
+    result.set_error_message(strerror(errno));
+    munmap(shared, sizeof(struct seccomp_outcome));
+    return serialize_proto(result);
+  }
+  result.set_filter_installed(outcome->filter_installed);
+  if (!outcome->filter_installed) {
+    result.set_error_message(strerror(outcome->install_errno));
+  }
+  result.set_did_syscall(outcome->did_syscall);
+  result.set_syscall_return(outcome->syscall_return);
+  if (WIFSIGNALED(status)) {
+    result.set_term_signal(WTERMSIG(status));
+  }
+  munmap(shared, sizeof(struct seccomp_outcome));
+  return serialize_proto(result);
+}
+
+struct bpf_result ffi_run_socket_filter(void *serialized_proto, size_t length) {
+  SocketFilterResult result;
+  std::string serialized_proto_string(
+      reinterpret_cast<const char *>(serialized_proto), length);
+  SocketFilterRequest request;
+  if (!request.ParseFromString(serialized_proto_string)) {
+    result.set_error_message("Could not parse SocketFilterRequest proto");
+    return serialize_proto(result);
+  }
+
+  std::vector<struct sock_filter> filter;
+  struct sock_fprog fprog = decode_classic_filter(request.filter(), &filter);
+
+  int socks[2] = {};
+  if (socketpair(AF_UNIX, SOCK_DGRAM, 0, socks) != 0) {
+    result.set_error_message(strerror(errno));
+    return serialize_proto(result);
+  }
+  int attached;
+  if (request.prog_fd() != 0) {
+    int prog_fd = request.prog_fd();
+    attached = setsockopt(socks[0], SOL_SOCKET, SO_ATTACH_BPF, &prog_fd,
+                          sizeof(prog_fd));
+  } else {
+    attached = setsockopt(socks[0], SOL_SOCKET, SO_ATTACH_FILTER, &fprog,
+                          sizeof(fprog));
+  }
+  if (attached != 0) {
+    result.set_error_message(strerror(errno));
+    close(socks[0]);
+    close(socks[1]);
+    return serialize_proto(result);
+  }
+  result.set_filter_attached(true);
+
+  const std::string &packet = request.packet();
+  if (write(socks[1], packet.data(), packet.size()) !=
+      static_cast<ssize_t>(packet.size())) {
+    result.set_error_message("Could not write all data to socket");
+    close(socks[0]);
+    close(socks[1]);
+    return serialize_proto(result);
+  }
+
+  // Packets the filter drops are silently discarded, there is nothing to
+  // receive in that case.
+  std::vector<char> buffer(packet.size() + 1);
+  ssize_t received =
+      recv(socks[0], buffer.data(), buffer.size(), MSG_DONTWAIT | MSG_TRUNC);
+  if (received >= 0) {
+    result.set_received(true);
+    result.set_received_length(received);
+  } else if (errno != EAGAIN) {
+    result.set_error_message(strerror(errno));
+  }
+  close(socks[0]);
+  close(socks[1]);
+  return serialize_proto(result);
+}
+
+// Opens a packet socket bound to |ifindex| that only receives the packets
+// coming in on the interface, the ones sent through it are ignored.
+static int open_packet_socket(int ifindex) {
+  int fd = socket(AF_PACKET, SOCK_RAW | SOCK_NONBLOCK | SOCK_CLOEXEC,
+                  htons(ETH_P_ALL));
+  if (fd < 0) {
+    return -1;
+  }
+  struct sockaddr_ll addr = {};
+  addr.sll_family = AF_PACKET;
+  addr.sll_protocol = htons(ETH_P_ALL);
+  addr.sll_ifindex = ifindex;
+  int one = 1;
+  if (bind(fd, reinterpret_cast<struct sockaddr *>(&addr), sizeof(addr)) !=
+          0 ||
+      setsockopt(fd, SOL_PACKET, PACKET_IGNORE_OUTGOING, &one, sizeof(one)) !=
+          0) {
+    int bind_errno = errno;
+    close(fd);
+    errno = bind_errno;
+    return -1;
+  }
+  // Drop what the socket received from the other interfaces before it was
+  // bound.
+  char drain[ETH_FRAME_LEN];
+  while (recv(fd, drain, sizeof(drain), 0) >= 0) {
+  }
+  return fd;
+}
+
+// Attaches the program of |request| to its interface, injects the packet on
+// the peer and waits for it to show up on either end.
+static bool run_xdp(const XdpRequest &request, ExecutionResult *result,
+                    std::string *error_message) {
+  union bpf_attr attr = {};
+  attr.link_create.prog_fd = static_cast<uint32_t>(request.prog_fd());
+  attr.link_create.target_ifindex = request.ifindex();
+  attr.link_create.attach_type = BPF_XDP;
+  attr.link_create.flags =
+      request.native() ? XDP_FLAGS_DRV_MODE : XDP_FLAGS_SKB_MODE;
+  int link_fd = syscall(SYS_bpf, BPF_LINK_CREATE, &attr, sizeof(attr));
+  if (link_fd < 0) {
+    *error_message = strerror(errno);
+    return false;
+  }
+
+  // The packets passed show up on the interface, the ones transmitted
+  // come back on the peer, which the packet is injected from.
+  struct pollfd fds[2] = {};
+  fds[0].fd = open_packet_socket(request.ifindex());
+  fds[1].fd = open_packet_socket(request.peer_ifindex());
+  fds[0].events = fds[1].events = POLLIN;
+  auto close_all = [&fds, link_fd]() {
+    for (const struct pollfd &pfd : fds) {
+      if (pfd.fd >= 0) {
+        close(pfd.fd);
+      }
+    }
+    close(link_fd);
+  };
+  if (fds[0].fd < 0 || fds[1].fd < 0) {
+    *error_message = strerror(errno);
+    close_all();
+    return false;
+  }
+
+  const std::string &packet = request.packet();
+  struct sockaddr_ll addr = {};
+  addr.sll_family = AF_PACKET;
+  addr.sll_ifindex = request.peer_ifindex();
+  addr.sll_halen = ETH_ALEN;
+  if (sendto(fds[1].fd, packet.data(), packet.size(), 0,
+             reinterpret_cast<struct sockaddr *>(&addr), sizeof(addr)) !=
+      static_cast<ssize_t>(packet.size())) {
+    *error_message = "Could not inject the packet";
+    close_all();
+    return false;
+  }
+
+  result->set_did_succeed(true);
+  result->set_retval(XDP_DROP);
+  if (poll(fds, 2, request.timeout_ms()) > 0) {
+    int fd = fds[0].fd;
+    result->set_retval(XDP_PASS);
+    if ((fds[0].revents & POLLIN) == 0) {
+      fd = fds[1].fd;
+      result->set_retval(XDP_TX);
+    }
+    // The program can grow the packet.
+    std::vector<char> buffer(packet.size() + ebpf_ffi::kTestRunSlack);
+    ssize_t received = recv(fd, buffer.data(), buffer.size(), 0);
+    if (received >= 0) {
+      result->set_data_out(buffer.data(), received);
+    }
+  }
+  close_all();
+  return true;
+}
+
+struct bpf_result ffi_run_xdp(void *serialized_proto, size_t length) {
+  ExecutionResult execution_result;
+
+  std::string serialized_proto_string(
+      reinterpret_cast<const char *>(serialized_proto), length);
+  XdpRequest request;
+  if (!request.ParseFromString(serialized_proto_string)) {
+    return return_error("Could not parse XdpRequest proto", &execution_result);
+  }
+
+  std::string error_message;
+  if (!run_xdp(request, &execution_result, &error_message)) {
+    return return_error(error_message, &execution_result);
+  }
+  return serialize_proto(execution_result);
+}
+
+// Exit statuses of the process send_cgroup_traffic forks.
+constexpr int kCgroupTrafficPassed = 0;
+constexpr int kCgroupTrafficBlocked = 1;
+constexpr int kCgroupTrafficFailed = 2;
+
+// Forks a process that moves itself into the cgroup whose cgroup.procs file
+// is |procs_fd| and sends |packet| to itself over UDP on the loopback
+// interface. Returns 1 if the packet got through, 0 if a program of the
+// cgroup blocked it or one of the sockets and -1 if the traffic could not be
+// sent.
+static int send_cgroup_traffic(int procs_fd, const std::string &packet,
+                               std::string *error_message) {
+  pid_t pid = fork();
+  if (pid < 0) {
+    *error_message = strerror(errno);
+    return -1;
+  }
+  if (pid == 0) {
+    // Only async-signal-safe functions from here on. The programs refuse
+    // sockets and packets with EPERM.
+    auto blocked_or_failed = []() {
+      _exit(errno == EPERM ? kCgroupTrafficBlocked : kCgroupTrafficFailed);
+    };
+    if (write(procs_fd, "0", 1) != 1) {
+      _exit(kCgroupTrafficFailed);
+    }
+    struct sockaddr_in addr = {};
+    addr.sin_family = AF_INET;
+    addr.sin_addr.s_addr = htonl(INADDR_LOOPBACK);
+    socklen_t addr_len = sizeof(addr);
+    int receiver = socket(AF_INET, SOCK_DGRAM, 0);
+    if (receiver < 0) {
+      blocked_or_failed();
+    }
+    if (bind(receiver, reinterpret_cast<struct sockaddr *>(&addr),
+             addr_len) != 0 ||
+        getsockname(receiver, reinterpret_cast<struct sockaddr *>(&addr),
+                    &addr_len) != 0) {
+      _exit(kCgroupTrafficFailed);
+    }
+    int sender = socket(AF_INET, SOCK_DGRAM, 0);
+    if (sender < 0 ||
+        sendto(sender, packet.data(), packet.size(), 0,
+               reinterpret_cast<struct sockaddr *>(&addr), addr_len) < 0) {
+      blocked_or_failed();
+    }
+    // Packets dropped on ingress are silently discarded.
+    char buffer[1];
+    if (recv(receiver, buffer, sizeof(buffer), MSG_DONTWAIT | MSG_TRUNC) < 0) {
+      _exit(errno == EAGAIN ? kCgroupTrafficBlocked : kCgroupTrafficFailed);
+    }
+    _exit(kCgroupTrafficPassed);
+  }
+
+  int status;
+  if (waitpid(pid, &status, 0) != pid || !WIFEXITED(status) ||
+      WEXITSTATUS(status) == kCgroupTrafficFailed) {
+    *error_message = "Could not send traffic from the cgroup";
+    return -1;
+  }
+  return WEXITSTATUS(status) == kCgroupTrafficPassed ? 1 : 0;
+}
+
+// Attaches |prog_fd| to the cgroup |cgroup_fd| next to the programs already
+// there, in place of |replace_fd| if it is not -1.
+static int cgroup_attach(int cgroup_fd, int prog_fd, int attach_type,
+                         int replace_fd) {
+  union bpf_attr attr = {};
+  attr.target_fd = cgroup_fd;
+  attr.attach_bpf_fd = prog_fd;
+  attr.attach_type = attach_type;
+  attr.attach_flags = BPF_F_ALLOW_MULTI;
+  if (replace_fd >= 0) {
+    attr.attach_flags |= BPF_F_REPLACE;
+    attr.replace_bpf_fd = replace_fd;
+  }
+  return syscall(SYS_bpf, BPF_PROG_ATTACH, &attr, sizeof(attr));
+}
+
+static int cgroup_detach(int cgroup_fd, int prog_fd, int attach_type) {
+  union bpf_attr attr = {};
+  attr.target_fd = cgroup_fd;
+  attr.attach_bpf_fd = prog_fd;
+  attr.attach_type = attach_type;
+  return syscall(SYS_bpf, BPF_PROG_DETACH, &attr, sizeof(attr));
+}
+
+// Loads a program of |prog_type| that lets everything through.
+static int load_cgroup_filler(int prog_type, std::string *error_message) {
+  struct bpf_insn insns[2] = {};
+  insns[0].code = BPF_ALU64 | BPF_MOV | BPF_K;
+  insns[0].imm = 1;
+  insns[1].code = BPF_JMP | BPF_EXIT;
+  std::string verifier_log;
+  return load_bpf_program(insns, 2, &verifier_log, error_message, 0, nullptr,
+                          prog_type);
+}
+
+// Runs the attach, replace and detach cycles of |request|, see
+// CgroupRequest.
+static bool run_cgroup(const CgroupRequest &request, ExecutionResult *result,
+                       std::string *error_message) {
+  int prog_fd = static_cast<int>(request.prog_fd());
+  int attach_type = request.attach_type();
+  // The cgroup, its cgroup.procs file and the two fillers.
+  int fds[4] = {-1, -1, -1, -1};
+  auto close_all = [&fds, prog_fd, attach_type]() {
+    // Detaching what was not attached fails harmlessly.
+    if (fds[0] >= 0) {
+      cgroup_detach(fds[0], prog_fd, attach_type);
+      cgroup_detach(fds[0], fds[2], attach_type);
+      cgroup_detach(fds[0], fds[3], attach_type);
+    }
+    for (int fd : fds) {
+      if (fd >= 0) {
+        close(fd);
+      }
+    }
+  };
+  auto fail = [&close_all, error_message](const std::string &error) {
+    if (error_message->empty()) {
+      *error_message = error;
+    }
+    close_all();
+    return false;
+  };
+
+  const std::string &path = request.cgroup_path();
+  fds[0] = open(path.c_str(), O_RDONLY | O_DIRECTORY | O_CLOEXEC);
+  if (fds[0] < 0) {
+    return fail(strerror(errno));
+  }
+  fds[1] = open((path + "/cgroup.procs").c_str(), O_WRONLY | O_CLOEXEC);
+  if (fds[1] < 0) {
+    return fail(strerror(errno));
+  }
+  for (int i = 2; i < 4; i++) {
+    fds[i] = load_cgroup_filler(request.prog_type(), error_message);
+    if (fds[i] < 0) {
+      return fail("Could not load a filler program");
+    }
+  }
+
+  int passed = 0;
+  uint32_t cycles = std::max(request.cycles(), 1u);
+  for (uint32_t i = 0; i < cycles; i++) {
+    // The program runs after a filler in the effective array, then takes
+    // the place of the other filler it was replaced with.
+    if (cgroup_attach(fds[0], fds[2], attach_type, -1) != 0 ||
+        cgroup_attach(fds[0], prog_fd, attach_type, -1) != 0) {
+      return fail(strerror(errno));
+    }
+    if ((passed = send_cgroup_traffic(fds[1], request.packet(),
+                                      error_message)) < 0) {
+      return fail(*error_message);
+    }
+    if (cgroup_attach(fds[0], fds[3], attach_type, prog_fd) != 0 ||
+        cgroup_attach(fds[0], prog_fd, attach_type, fds[3]) != 0) {
+      return fail(strerror(errno));
+    }
+    if ((passed = send_cgroup_traffic(fds[1], request.packet(),
+                                      error_message)) < 0) {
+      return fail(*error_message);
+    }
+    if (cgroup_detach(fds[0], prog_fd, attach_type) != 0 ||
+        cgroup_detach(fds[0], fds[2], attach_type) != 0) {
+      return fail(strerror(errno));
+    }
+  }
+
+  result->set_did_succeed(true);
+  result->set_retval(passed);
+  close_all();
+  return true;
+}
+
+struct bpf_result ffi_run_cgroup(void *serialized_proto, size_t length) {
+  ExecutionResult execution_result;
+
+  std::string serialized_proto_string(
+      reinterpret_cast<const char *>(serialized_proto), length);
+  CgroupRequest request;
+  if (!request.ParseFromString(serialized_proto_string)) {
+    return return_error("Could not parse CgroupRequest proto",
+                        &execution_result);
+  }
+
+  std::string error_message;
+  if (!run_cgroup(request, &execution_result, &error_message)) {
+    return return_error(error_message, &execution_result);
+  }
+  return serialize_proto(execution_result);
+}
+
+// Sends |fd| over the unix socket |sock|.
+static bool send_fd(int sock, int fd) {
+  char data = 0;
+  struct iovec iov = {&data, sizeof(data)};
+  alignas(struct cmsghdr) char control[CMSG_SPACE(sizeof(int))] = {};
+  struct msghdr msg = {};
+  msg.msg_iov = &iov;
+  msg.msg_iovlen = 1;
+  msg.msg_control = control;
+  msg.msg_controllen = sizeof(control);
+  struct cmsghdr *cmsg = CMSG_FIRSTHDR(&msg);
+  cmsg->cmsg_level = SOL_SOCKET;
+  cmsg->cmsg_type = SCM_RIGHTS;
+  cmsg->cmsg_len = CMSG_LEN(sizeof(int));
+  memcpy(CMSG_DATA(cmsg), &fd, sizeof(int));
+  return sendmsg(sock, &msg, 0) == sizeof(data);
+}
+
+// Receives an fd sent with send_fd over |sock|, returns -1 on error.
+static int recv_fd(int sock) {
+  char data;
+  struct iovec iov = {&data, sizeof(data)};
+  alignas(struct cmsghdr) char control[CMSG_SPACE(sizeof(int))] = {};
+  struct msghdr msg = {};
+  msg.msg_iov = &iov;
+  msg.msg_iovlen = 1;
+  msg.msg_control = control;
+  msg.msg_controllen = sizeof(control);
+  if (recvmsg(sock, &msg, 0) != sizeof(data)) {
+    return -1;
+  }
+  struct cmsghdr *cmsg = CMSG_FIRSTHDR(&msg);
+  if (cmsg == nullptr || cmsg->cmsg_type != SCM_RIGHTS) {
+    errno = EPROTO;
+    return -1;
+  }
+  int fd;
+  memcpy(&fd, CMSG_DATA(cmsg), sizeof(int));
+  return fd;
+}
+
+// Writes |contents| to the file at |path|.
+static bool write_file(const char *path, const char *contents) {
+  int fd = open(path, O_WRONLY | O_CLOEXEC);
+  if (fd < 0) {
+    return false;
+  }
+  ssize_t length = strlen(contents);
+  bool ok = write(fd, contents, length) == length;
+  close(fd);
+  return ok;
+}
+
+// Body of the child of ffi_create_bpf_token, it moves to new user and mount
+// namespaces, where it is root, sends a bpffs context to the parent over
+// |sock| to be configured and gets back the mount it creates the token from.
+// Only async-signal-safe functions are used.
+static int bpf_token_child(int sock) {
+  if (unshare(CLONE_NEWUSER | CLONE_NEWNS) != 0 ||
+      !write_file("/proc/self/setgroups", "deny") ||
+      !write_file("/proc/self/uid_map", "0 0 1") ||
+      !write_file("/proc/self/gid_map", "0 0 1")) {
+    return errno;
+  }
+  int fs_fd = syscall(SYS_fsopen, "bpf", 0);
+  if (fs_fd < 0 || !send_fd(sock, fs_fd)) {
+    return errno;
+  }
+  int mnt_fd = recv_fd(sock);
+  if (mnt_fd < 0) {
+    return errno;
+  }
+  union bpf_attr attr = {};
+  // struct { __u32 flags; __u32 bpffs_fd; } token_create.
+  uint32_t token_create[2] = {0, static_cast<uint32_t>(mnt_fd)};
+  memcpy(&attr, token_create, sizeof(token_create));
+  int token_fd = syscall(SYS_bpf, ebpf_ffi::kBpfTokenCreate, &attr,
+                         sizeof(attr));
+  if (token_fd < 0 || !send_fd(sock, token_fd)) {
+    return errno;
+  }
+  return 0;
+}
+
+// Delegates every command, map, program and attach type to the bpffs context
+// |fs_fd| and mounts it, returns the mount fd or -1.
+static int mount_delegating_bpffs(int fs_fd) {
+  for (const char *option : {"delegate_cmds", "delegate_maps",
+                             "delegate_progs", "delegate_attachs"}) {
+    if (syscall(SYS_fsconfig, fs_fd, FSCONFIG_SET_STRING, option, "any", 0) !=
+        0) {
+      return -1;
+    }
+  }
+  if (syscall(SYS_fsconfig, fs_fd, FSCONFIG_CMD_CREATE, nullptr, nullptr, 0) !=
+      0) {
+    return -1;
+  }
+  return syscall(SYS_fsmount, fs_fd, 0, 0);
+}
+
+int ffi_create_bpf_token() {
+  int sockets[2];
+  if (socketpair(AF_UNIX, SOCK_STREAM | SOCK_CLOEXEC, 0, sockets) != 0) {
+    return -errno;
+  }
+  pid_t pid = fork();
+  if (pid < 0) {
+    int fork_errno = errno;
+    close(sockets[0]);
+    close(sockets[1]);
+    return -fork_errno;
+  }
+  if (pid == 0) {
+    close(sockets[0]);
+    _exit(bpf_token_child(sockets[1]));
+  }
+  close(sockets[1]);
+
+  // Only a process privileged in the initial user namespace can delegate,
+  // the child then creates the token in its own namespace, which the token
+  // is tied to.
+  int token_fd = -1, token_errno = 0;
+  int fs_fd = recv_fd(sockets[0]);
+  if (fs_fd >= 0) {
+    int mnt_fd = mount_delegating_bpffs(fs_fd);
+    if (mnt_fd >= 0 && send_fd(sockets[0], mnt_fd)) {
+      token_fd = recv_fd(sockets[0]);
+    }
+    token_errno = errno;
+    if (mnt_fd >= 0) {
+      close(mnt_fd);
+    }
+    close(fs_fd);
+  }
+  close(sockets[0]);
+
+  int status;
+  if (waitpid(pid, &status, 0) == pid && WIFEXITED(status) &&
+      WEXITSTATUS(status) != 0) {
+    // The child exits with the errno of the step that failed.
+    token_errno = WEXITSTATUS(status);
+  }
+  if (token_fd < 0) {
+    return token_errno != 0 ? -token_errno : -EPROTO;
+  }
+  return token_fd;
+}
+
+void ffi_set_thread_bpf_token(int token_fd) { thread_bpf_token = token_fd; }
@@ -39,9 +39,60 @@ struct bpf_result ffi_load_bpf_program(void *prog_buff, size_t size,
                                        int coverage_enabled,
                                        uint64_t coverage_size);
 
+// Like ffi_load_bpf_program but the program is loaded with the BTF blob |btf|
+// of |btf_size| bytes and the struct bpf_func_info and struct bpf_line_info
+// records of |func_info| and |line_info|. The BTF is loaded first, if the
+// kernel refuses it the program is not loaded and the ValidationResult has
+// the BTF log and error.
+struct bpf_result ffi_load_bpf_program_with_btf(
+    void *prog_buff, size_t size, void *btf, size_t btf_size, void *func_info,
+    uint32_t func_info_cnt, void *line_info, uint32_t line_info_cnt,
+    int coverage_enabled, uint64_t coverage_size);
+
+// Like ffi_load_bpf_program but the program is loaded as |prog_type|, e.g.
+// BPF_PROG_TYPE_SCHED_CLS, instead of a socket filter, with the BPF_F_*
+// |prog_flags|, e.g. BPF_F_SLEEPABLE.
+struct bpf_result ffi_load_bpf_program_of_type(void *prog_buff, size_t size,
+                                               int prog_type,
+                                               uint32_t prog_flags,
+                                               int coverage_enabled,
+                                               uint64_t coverage_size);
+
+// Like ffi_load_bpf_program but without coverage and with the verifier log
+// at |log_level|, a combination of BPF_LOG_LEVEL1, BPF_LOG_LEVEL2 and
+// BPF_LOG_STATS. 0 loads the program without a log.
+struct bpf_result ffi_load_bpf_program_with_log_level(void *prog_buff,
+                                                      size_t size,
+                                                      uint32_t log_level);
+
 // Creates an ebpf map, returns the file descriptor to it.
 int ffi_create_bpf_map(size_t size);
 
+// Creates a hash map of type |map_type|, e.g. BPF_MAP_TYPE_LRU_HASH, with a 4
+// byte key and an 8 byte value. Returns the file descriptor to it.
+int ffi_create_hash_map(int map_type, size_t max_entries, uint32_t flags);
+
+// Creates a map of type |map_type| with keys of |key_size| bytes and values
+// of |value_size| bytes, e.g. a BPF_MAP_TYPE_RINGBUF where both are 0.
+// Returns the file descriptor to it.
+int ffi_create_map(int map_type, uint32_t key_size, uint32_t value_size,
+                   size_t max_entries, uint32_t flags);
+
+// Same as ffi_create_map but the map is created with the BTF blob |btf|,
+// whose types |key_type_id| and |value_type_id| describe the keys and values,
+// e.g. to have a struct bpf_spin_lock in the values. Returns the file
+// descriptor to it.
+int ffi_create_map_with_btf(int map_type, uint32_t key_size,
+                            uint32_t value_size, size_t max_entries,
+                            uint32_t flags, void *btf, size_t btf_size,
+                            uint32_t key_type_id, uint32_t value_type_id);
+
+// Creates a map of maps of type |map_type|, BPF_MAP_TYPE_ARRAY_OF_MAPS or
+// BPF_MAP_TYPE_HASH_OF_MAPS, with 4 byte keys. Its values are maps like
+// |inner_map_fd|. Returns the file descriptor to it.
+int ffi_create_map_of_maps(int map_type, int inner_map_fd, size_t max_entries,
+                           uint32_t flags);
+
 // Closes the given file descriptor, this is to free up resources.
 void ffi_close_fd(int fd);
 
@@ -50,25 +101,145 @@ void ffi_close_fd(int fd);
 struct bpf_result ffi_execute_bpf_program(void *serialized_proto,
                                           size_t length);
 
+// Runs the specified ebpf program with BPF_PROG_TEST_RUN on the given data.
+// Serialized proto is of type TestRunRequest, return value is of type
+// ExecutionResult.
+struct bpf_result ffi_test_run_bpf_program(void *serialized_proto,
+                                           size_t length);
+
+// Loads the programs of a BatchRequest one after the other and test runs the
+// ones the verifier accepts, without collecting coverage. Serialized proto is
+// of type BatchRequest, return value is of type BatchResult.
+struct bpf_result ffi_run_batch(void *serialized_proto, size_t length);
+
 // Retrieves the elements of the specified map_fd, return value is of type
 // MapElements.
 struct bpf_result ffi_get_map_elements(int map_fd, uint64_t map_size);
 
+// Retrieves the value of the element |key| of |map_fd|, which is |words| 64
+// bit words long, return value is of type MapElements with one element per
+// word.
+struct bpf_result ffi_get_map_value(int map_fd, uint32_t key, uint64_t words);
+
+// Reads the records of the ring buffer |map_fd|, whose data area has
+// |size| bytes, that were committed since the last call and hands the space
+// back to the kernel. Returns a serialized RingbufRecords proto.
+struct bpf_result ffi_consume_ringbuf(int map_fd, uint64_t size);
+
 // Sets the value at key |key| in the map described by |map_fd| to |value|.
 int ffi_update_map_element(int map_fd, int key, uint64_t value);
+
+// Sets the value at key |key| in the map of maps described by |map_fd| to the
+// map |inner_map_fd|.
+int ffi_update_map_of_maps(int map_fd, int key, int inner_map_fd);
+
+// Deletes the element at key |key| from the hash map described by |map_fd|.
+int ffi_delete_map_element(int map_fd, int key);
+
+// Deletes every element of the hash map described by |map_fd|, returns how
+// many were deleted or -1.
+int ffi_clear_map(int map_fd);
+
+// Freezes the map described by |map_fd|, after this call succeeds the map
+// can no longer be modified from user space.
+int ffi_freeze_map(int map_fd);
+
+// Retrieves information about the program described by |prog_fd|, return
+// value is of type ProgramInfo.
+struct bpf_result ffi_get_program_info(int prog_fd);
+
+// Opens the eBPF object pinned at |path| in a bpffs, returns its fd or -1.
+int ffi_get_pinned_object(const char *path);
+
+// Pins the eBPF object |fd| at |path| in a bpffs, returns -1 on error.
+int ffi_pin_object(int fd, const char *path);
+
+// Installs a cBPF seccomp filter in a forked child which then issues a system
+// call. Serialized proto is of type SeccompRequest, return value is of type
+// SeccompResult.
+struct bpf_result ffi_run_seccomp_filter(void *serialized_proto,
+                                         size_t length);
+
+// Attaches a cBPF filter to a socket and sends a packet through it.
+// Serialized proto is of type SocketFilterRequest, return value is of type
+// SocketFilterResult.
+struct bpf_result ffi_run_socket_filter(void *serialized_proto, size_t length);
+
+// Attaches an XDP program to an interface and injects a packet on its peer.
+// Serialized proto is of type XdpRequest, return value is of type
+// ExecutionResult.
+struct bpf_result ffi_run_xdp(void *serialized_proto, size_t length);
+
+// Attaches a cgroup program to a cgroup, replaces and detaches it in cycles
+// and sends traffic from inside the cgroup in between. Serialized proto is of
+// type CgroupRequest, return value is of type ExecutionResult.
+struct bpf_result ffi_run_cgroup(void *serialized_proto, size_t length);
+
+// Creates a BPF token, 6.9+, from a bpffs delegating everything, mounted in
+// a new user namespace. Returns the token fd or -errno.
+int ffi_create_bpf_token();
+
+// Makes the programs loaded by the calling thread go through the BPF token
+// |token_fd|, -1 loads them without a token.
+void ffi_set_thread_bpf_token(int token_fd);
 }
 
+// BTF a program is loaded with, |btf_fd| is the one of |blob| once loaded.
+struct btf_data {
+  void *blob;
+  size_t blob_size;
+  int btf_fd;
+  void *func_info;
+  uint32_t func_info_cnt;
+  void *line_info;
+  uint32_t line_info_cnt;
+};
+
+// A record read from a ring buffer by consume_ringbuf.
+struct ringbuf_record {
+  std::string data;
+  bool discarded;
+};
+
+// Loads the BTF blob |btf| of |btf_size| bytes and returns its file
+// descriptor, |log| receives the BTF log. On failure errno is the one set by
+// bpf().
+int load_btf(void *btf, size_t btf_size, std::string *log, std::string *error);
+
 // Actual implementation of load program. The split between ffi and
 // implementation is done so the impl code can be shared with other parts of the
-// codebase also written in C++.
+// codebase also written in C++. On failure errno is the one set by bpf(), it
+// is ENOSPC if the program is rejected because the verifier log did not fit
+// in the biggest buffer.
 int load_bpf_program(void *prog_buff, size_t prog_size,
-                     std::string *verifier_log, std::string *error);
+                     std::string *verifier_log, std::string *error,
+                     uint32_t log_level = 2,
+                     const struct btf_data *btf = nullptr,
+                     int prog_type = BPF_PROG_TYPE_SOCKET_FILTER,
+                     uint32_t prog_flags = 0);
 bool get_map_elements(int map_fd, size_t map_size, std::vector<uint64_t> *res,
                       std::string *error);
+bool get_map_value(int map_fd, uint32_t key, size_t words,
+                   std::vector<uint64_t> *res, std::string *error);
+bool consume_ringbuf(int map_fd, size_t size,
+                     std::vector<struct ringbuf_record> *res,
+                     std::string *error);
 int bpf_create_map(enum bpf_map_type map_type, unsigned int key_size,
-                   unsigned int value_size, unsigned int max_entries);
+                   unsigned int value_size, unsigned int max_entries,
+                   uint32_t map_flags = 0);
 bool execute_bpf_program(int prog_fd, uint8_t *input, int input_length,
                          std::string *error_message);
+// Like execute_bpf_program but |input| is sent through |trigger_socket|, one
+// of the ebpf_fuzzer::TriggerSocket values, in |fragments| writes.
+bool execute_on_socket(int prog_fd, int trigger_socket,
+                       uint8_t *input, int input_length, int fragments,
+                       std::string *error_message);
+// Attaches |prog_fd| to |tracepoint| for the calling thread and calls getppid,
+// the tracepoint has to fire on it.
+bool execute_tracepoint_program(int prog_fd, const std::string &tracepoint,
+                                std::string *error_message);
+bool get_xlated_program(int prog_fd, std::vector<uint64_t> *res,
+                        std::string *error);
 
 struct coverage_data {
   int fd;
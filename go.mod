@@ -6,8 +6,13 @@ require (
 	github.com/go-echarts/go-echarts/v2 v2.3.3
 	github.com/golang/protobuf v1.5.4
 	github.com/google/safehtml v0.0.2
+	google.golang.org/grpc v1.64.0
 )
 
 require (
-	golang.org/x/text v0.3.3 // indirect
+	golang.org/x/net v0.22.0 // indirect
+	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240318140521-94a12d6c2237 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
 )
@@ -16,13 +16,29 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
+	"net"
+	"os"
 	"os/exec"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"buzzer/pkg/corpus/corpus"
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/setup/setup"
 	"buzzer/pkg/strategies/strategies"
 	"buzzer/pkg/units/units"
+	cpb "buzzer/proto/corpus_go_proto"
 )
 
 // Flags that the binary can accept.
@@ -30,37 +46,530 @@ var (
 	coverageBufferSize = flag.Uint64("coverage_buffer_size", 64<<20, "Size of the buffer passed to kcov to get coverage addresses, the higher the number, the slower coverage collection will be")
 	metricsThreshold   = flag.Int("metrics_threshold", 200, "Collect detailed metrics (coverage) every `metrics_threshold` validated programs")
 	strategyName       = flag.String("strategy", "playground", "Strategy to use for fuzzing")
+	campaignPhases     = flag.String("campaign_phases", "", "Comma separated strategy:duration phases, e.g. playground:1h,coverage_based:4h, run one after the other instead of the strategy flag. Statistics are printed at the end of every phase")
 	vmLinuxPath        = flag.String("vmlinux_path", "/root/vmlinux", "Path to the linux image that will be passed to addr2line to get coverage info")
 	sourceFilesPath    = flag.String("src_path", "/root/sourceFiles", "The fuzzer will look for source files to visualize the coverage at this path")
 	metricsServerAddr  = flag.String("metrics_server_addr", "0.0.0.0", "Address that the metrics server will listen to at")
 	metricsServerPort  = flag.Uint("metrics_server_port", 8080, "Port that the metrics server will listen to at")
+	consoleMarkers     = flag.Bool("console_markers", false, "Print every program on the console before loading it, so tools/vm_manager knows which one crashed the kernel")
+	controlServiceAddr = flag.String("control_service_addr", "", "Address, e.g. 127.0.0.1:8090, to serve the gRPC control service at, it lets an orchestrator run programs, read the stats, pull the findings and push corpus entries. Addresses without a host, e.g. :8090, are on the loopback interface. It requires control_service_token_file or control_service_client_ca. Disabled if empty")
+	controlToken       = flag.String("control_service_token_file", "", "File with the token the clients of the control service must send in the authorization metadata of their calls, as \"Bearer <token>\"")
+	controlCert        = flag.String("control_service_cert", "", "Certificate, in PEM, the control service is served over TLS with")
+	controlKey         = flag.String("control_service_key", "", "Key, in PEM, of control_service_cert")
+	controlClientCA    = flag.String("control_service_client_ca", "", "CA certificates, in PEM, the clients of the control service must present a certificate signed by. Requires control_service_cert")
+	concurrentExecs    = flag.Int("concurrent_executions", 0, "Execute every accepted program from this many threads at the same time, while mutating its maps, before the regular execution")
+	minimizeFindings   = flag.Bool("minimize_findings", false, "Shrink programs that produce unexpected results and write an additional minimized PoC")
+	elfRepros          = flag.Bool("elf_repros", false, "Also write the programs of the findings as object files that bpftool and libbpf can load")
+	syzRepros          = flag.Bool("syz_repros", false, "Also write the programs of the findings as syzkaller programs, to triage them with syz-repro or convert them with syz-prog2c")
+	artifactDir        = flag.String("artifact_dir", "", "Write a directory for every finding in this one, with its program as a text proto, disassembly, bytecode and C PoC, the complete verifier log, the kernel log, the seed and metadata JSON. Disabled if empty")
+	reduceGuards       = flag.Bool("reduce_guards", false, "Re-submit accepted programs with each guard removed to find the ones the verifier requires, reporting suspicious acceptances and stripping unneeded guards from reproducers")
+	referenceLeaks     = flag.Bool("reference_leak_oracle", false, "Report the accepted programs that obviously leak a reference acquired from a helper, e.g. a socket from sk_lookup_tcp that is never released")
+	findingHookCmd     = flag.String("finding_hook", "", "Executable to run for every finding, it receives the paths of the reproducer files as arguments and the finding description in the BUZZER_FINDING environment variable")
+	telemetryEndpoint  = flag.String("telemetry_endpoint", "", "Opt-in: URL that will periodically receive anonymized aggregate campaign statistics as JSON, empty disables telemetry")
+	telemetryInterval  = flag.Duration("telemetry_interval", 10*time.Minute, "How often statistics are pushed to telemetry_endpoint")
+	corpusPath         = flag.String("corpus_path", "", "Append every generated program, with its verdict and execution result, to this corpus file")
+	replayCorpusPath   = flag.String("replay_corpus", "", "Instead of fuzzing, replay the programs of this corpus file and report the ones whose results changed")
+	decisionLogPath    = flag.String("decision_log", "", "Record every program the kernel sees, in order, with its results, the decisions of the strategy that led to it and the eBPF sysctls, to this file so the campaign can be replayed exactly with replay_decision_log. The file is replaced")
+	decisionLogWindow  = flag.Duration("decision_log_window", time.Hour, "Start a new decision_log segment once the current one is this old, the previous one is kept with a .prev suffix. 0 records the whole campaign in one segment")
+	replayDecisionLog  = flag.String("replay_decision_log", "", "Instead of fuzzing, set the sysctls recorded in this decision log and send its programs to the kernel again in order, reporting the ones whose results changed and the kernel splats")
+	replayTiming       = flag.Bool("replay_timing", false, "Keep the time between the programs of replay_decision_log as recorded instead of replaying them as fast as possible")
+	seed               = flag.Int64("seed", 0, "Seed for the random number generator, runs with the same seed and strategy generate the same programs as long as the kernel responds the same way. 0 picks a seed based on the current time")
+	mutationSeeds      = flag.String("mutation_seeds", "", "Corpus file whose valid programs are the initial population of the mutation_based strategy")
+	maxFruitless       = flag.Int("max_fruitless_mutations", 0, "Retire the programs of the mutation_based population once this many of their mutations in a row did not reach new coverage. 0 retires them after a fixed number of mutations")
+	corpusArchive      = flag.String("corpus_archive", "", "Append the programs the mutation_based strategy retires from its population, with how many times they were mutated, to this corpus file. It can be passed to mutation_seeds later")
+	pinnedSeeds        = flag.String("pinned_seeds", "", "Comma separated bpffs paths of pinned programs, their xlated instructions are added to the initial population of the mutation_based strategy")
+	elfSeeds           = flag.String("elf_seeds", "", "Comma separated paths of BPF object files, e.g. built with clang, their socket filter and tc programs are added to the initial population of the mutation_based strategy, which loads all its programs as tc programs if there are any")
+	verifierReloads    = flag.Int("verifier_reload_count", 0, "Load every accepted program this many additional times and report any difference in the verifier output as a finding, 0 disables the check")
+	logLevelDiff       = flag.Bool("log_level_differential", false, "Load every program again at other verifier log levels, with and without statistics, and report the programs whose verdict, xlated instructions, statistics or error change")
+	transientRetries   = flag.Int("transient_retries", 5, "How many times a program whose load fails transiently, e.g. with EAGAIN or ENOMEM, is loaded again before it is dropped")
+	mapDeltas          = flag.Bool("map_deltas", false, "Snapshot the maps of the strategies around every execution, record the elements that changed and report the changes the strategy did not expect")
+	hangTimeout        = flag.Duration("hang_timeout", 0, "Interrupt the loads and executions that take longer than this and report their programs as hangs, exiting if they do not return. 0 disables the watchdog")
+	triggers           = flag.String("triggers", "", "Comma separated sockets, among unix, udp, udp6, tcp and tcp6, the socket filter programs are executed through with random payloads, one picked at random for every execution. A socket can be followed by the number of packets, or TCP segments, the payload is split into, e.g. udp,tcp6:4")
+	xdpVeth            = flag.String("xdp_veth", "", "Prefix of the veth pairs, one per worker, created to execute XDP programs on, e.g. <name>0_0 and <name>0_1 for the first worker. Programs are attached to the _1 end and their packet is injected on the _0 end, the action they took is told by where it shows up. XDP programs, e.g. the ones of the xdp strategy, are test run if empty")
+	xdpNative          = flag.Bool("xdp_native", false, "Attach XDP programs to the veth driver instead of in generic mode")
+	cgroupName         = flag.String("cgroup", "", "Name of a cgroup, created at the top of the cgroup v2 hierarchy, to execute the CGROUP_SKB and CGROUP_SOCK programs in. Every worker has a child cgroup of its own, w0, w1 and so on. Programs are attached, replaced and detached in cycles and a process in the cgroup sends a UDP packet through them in between. CGROUP_SKB programs are test run if empty")
+	cgroupCycles       = flag.Int("cgroup_cycles", units.DefaultCgroupCycles, "Attach, replace and detach cycles of every execution of a cgroup program")
+	valueTraces        = flag.Bool("value_traces", false, "Execute the programs of the findings of executions again, instrumented to record the value of every register they write, and report the values with the findings")
+	helperCoverage     = flag.Bool("helper_coverage", false, "Execute the programs that call helpers no program called so far again, instrumented to tell which calls returned, and track the helpers called at runtime in the stats")
+	targetHelpers      = flag.Bool("target_uncalled_helpers", false, "Favor the helpers no program called so far in the random helper calls, implies helper_coverage")
+	maxExecutions      = flag.Int("max_executions", 0, "Stop once the workers executed this many programs, 0 for no limit")
+	maxRuntime         = flag.Duration("max_runtime", 0, "Stop after running this long, 0 for no limit")
+	maxFindings        = flag.Int("max_findings", 0, "Stop once this many findings were reported, 0 for no limit")
+	stopOnFinding      = flag.Bool("stop_on_first_finding", false, "Stop once the first finding is reported, same as max_findings=1")
+	checkpointPath     = flag.String("checkpoint", "", "File the state of the campaign, the random number generator, the coverage and the statistics and population of every worker, is periodically saved to")
+	checkpointInterval = flag.Duration("checkpoint_interval", 10*time.Minute, "How often the checkpoint is saved")
+	resume             = flag.Bool("resume", false, "Resume the campaign from the checkpoint file instead of starting a new one")
+	transientBackoff   = flag.Duration("transient_backoff", 10*time.Millisecond, "Wait before the first retry of a transient load failure, it doubles with every subsequent retry")
+	memlockLimit       = flag.Uint64("memlock_limit", 0, "RLIMIT_MEMLOCK in bytes the fuzzer runs under, low values exercise allocation failures on kernels before 5.11. 0 lifts the limit")
+	cgroupMemoryMax    = flag.Uint64("cgroup_memory_max", 0, "Run the fuzzer in a new cgroup whose memory.max is this many bytes, low values exercise allocation failures on kernels 5.11 and later. 0 keeps the current cgroup")
+	mapKeyPatterns     = flag.String("map_key_patterns", "", "Comma separated patterns, among dense, sparse and colliding, the hash maps of the map_key_space strategy are populated with. All of them by default")
+	mapPinDir          = flag.String("map_pin_dir", "", "bpffs directory the map_of_maps strategy pins its maps in, reusing the ones a previous run pinned there. Only the first worker pins its maps")
+	loopMaxTripCount   = flag.Int("loop_max_trip_count", 0, "Highest trip count of the loops of the bounded_loops strategy, 64 by default")
+	loopMaxDepth       = flag.Int("loop_max_depth", 0, "How deep the loops of the bounded_loops strategy are nested, from 1 to 3, 3 by default")
+	pairedEbpf         = flag.Bool("paired_ebpf", false, "Also attach the eBPF translation of every socket_filter filter natively, report the filters whose two versions keep different parts of the packet and write a PoC for each version")
+	bugReport          = flag.Bool("bug_report", false, "Write a ready to send bug report for every finding with the kernel version, config highlights, disassembly, C reproducer and an excerpt of the verifier log. It is passed to finding_hook along with the reproducers")
+	testRun            = flag.Bool("test_run", false, "Execute accepted programs with BPF_PROG_TEST_RUN instead of sending a packet through a socket they are attached to, which gives deterministic input and the value the programs return. Replayed corpora must have been recorded with it too")
+	testRunData        = flag.String("test_run_data", "", "Hex encoded packet, at least an ethernet header long, programs are test run on. 64 bytes of 0xaa by default")
+	testRunCtx         = flag.String("test_run_ctx", "", "Hex encoded context, e.g. a struct __sk_buff, programs are test run with. None by default")
+	testRunRepeat      = flag.Uint("test_run_repeat", 1, "How many times the kernel runs the program for every test run, the reported duration is their average")
+	allowInsns         = flag.String("allow_insns", "", "Comma separated instruction mnemonics, e.g. div,mod,atomic_*, that eBPF programs are restricted to, on top of exit, mov, call and lddw that every program needs. Strategies avoid generating others and programs using them are dropped before verification, fuzzing stops if every program is. All instructions by default")
+	blockInsns         = flag.String("block_insns", "", "Comma separated instruction mnemonics, e.g. div,mod,atomic_*, that eBPF programs must not use. Strategies avoid generating them and programs using them are dropped before verification")
+	fuzzConfig         = flag.String("config", "", "Path of a FuzzConfig in text format, see proto/config.proto, with the relative weights of the instruction classes, helpers, registers and immediate ranges of the random instructions")
+	batchSize          = flag.Int("batch_size", 1, "Number of programs loaded and test run per call into the kernel, for the strategies that support it, e.g. gadget_chains. Batched programs are always test run, without coverage and without the checks that load them again, and only when every program is privileged")
+	dryRun             = flag.Bool("dry_run", false, "Generate programs and print their disassembly, encoding and proto without loading them, the maps of the strategy are emulated in memory")
+	dryRunPrograms     = flag.Int("dry_run_programs", 10, "Number of programs generated by dry_run, 0 generates programs until the strategy is done")
+	arch               = flag.String("arch", "", "Architecture whose JIT programs are generated for, one of x86_64, arm64, riscv64 and s390x. Strategies avoid the instructions it does not translate on the running kernel, findings and corpus entries are still tagged with the architecture buzzer runs on. That architecture by default")
+	kernelRelease      = flag.String("kernel_release", "", "Kernel release programs are generated for, e.g. 5.10, when it is not the running one. Before 5.12, the atomic instructions are only generated in their legacy BPF_XADD form. The running kernel release by default")
+	triageDir          = flag.String("triage_dir", "", "Group findings by signature (oracle, strategy, verifier error, kernel splat function, description and execution outcome) and keep the reproducers of the first finding of every signature in a subdirectory of this directory, later findings with the same signature are only counted")
+	numWorkers         = flag.Int("workers", 1, "Number of fuzzing workers running in parallel, each with its own instance of the strategies. They share the programs that reach new coverage and report each finding once. mutation_seeds, pinned_seeds and elf_seeds only seed the first worker, seed does not make runs with several workers reproducible")
+	kernelLog          = flag.Bool("kernel_log", true, "Follow the kernel log, /dev/kmsg, and report the KASAN, UBSAN, WARN and BUG splats logged while loading or executing a program as findings of that program")
+	negativeSuite      = flag.Bool("negative_suite", true, "Before fuzzing, check that the kernel refuses every invalid mode, size and class combination of load and store instructions and report the ones it accepts")
+	logLevel           = flag.String("log_level", "debug", "Lowest level of the lines printed on the console, among debug, the progress lines of the strategies, info, the messages about every program, warning, the errors the fuzzer recovers from, and report, the findings and the periodic stats")
+	quiet              = flag.Bool("quiet", false, "Only print the findings and the periodic stats on the console, same as log_level=report")
+	logRate            = flag.Int("log_rate", 0, "Print at most this many lines below the report level on the console every second, the others are dropped and counted. 0 for no limit")
+	logFile            = flag.String("log_file", "", "Write every line of output, whatever the log level and rate, timestamped and tagged with its level to this file")
+	logFileMaxSize     = flag.Int64("log_file_max_size", 64<<20, "Size in bytes past which log_file is rotated, 0 never rotates it")
+	logFileCount       = flag.Int("log_file_count", 5, "How many rotated log files, with a .1, .2, etc suffix, are kept")
+	loadPrivileges     = flag.String("privileges", "privileged", "Comma separated privileges, among privileged, unprivileged and token, programs are loaded with, one is picked at random for every program. unprivileged drops the capabilities that make programs privileged, so the stricter verifier paths, e.g. the Spectre mitigations, are fuzzed. token drops them too but loads programs through a BPF token delegated from a user namespace, on kernels 6.9 and later")
+	statsInterval      = flag.Duration("stats_interval", time.Minute, "How often a line with the stats of the campaign is reported on the console, 0 disables it")
+	rejectionHistogram = flag.Int("rejection_histogram", 0, "Number of most frequent verifier error classes, the messages with their registers, offsets and other numbers masked, reported with their share of the rejections after every stats line. 0 disables the histogram")
 )
 
-var (
-	strats = []units.Strategy{
+// newStrategies creates the available strategies. Some of them consume
+// random numbers when created, so this must happen after the seed is set.
+func newStrategies() []units.Strategy {
+	return []units.Strategy{
 		strategies.NewPointerArithmeticStrategy(),
 		strategies.NewPlaygroundStrategy(),
 		strategies.NewCoverageBasedStrategy(),
+		strategies.NewMapRaceStrategy(),
+		strategies.NewMapKeySpaceStrategy(),
+		strategies.NewAluOverflowStrategy(),
+		strategies.NewMutationBasedStrategy(),
+		strategies.NewStackConfusionStrategy(),
+		strategies.NewSpillFillStrategy(),
+		strategies.NewAluSanitationStrategy(),
+		strategies.NewSeccompFilterStrategy(),
+		strategies.NewSocketFilterStrategy(),
+		strategies.NewHelperCallsStrategy(),
+		strategies.NewHelperChainsStrategy(),
+		strategies.NewGadgetChainsStrategy(),
+		strategies.NewJoinPointsStrategy(),
+		strategies.NewProbeReadsStrategy(),
+		strategies.NewBTFMutationStrategy(),
+		strategies.NewSpinLockPairsStrategy(),
+		strategies.NewRingbufStrategy(),
+		strategies.NewMapOfMapsStrategy(),
+		strategies.NewBoundedLoopsStrategy(),
+		strategies.NewCallbacksStrategy(),
+		strategies.NewReferenceTrackingStrategy(),
+		strategies.NewNegativeFuzzingStrategy(),
+		strategies.NewMemoryRegionsStrategy(),
+		strategies.NewAluExitCodeStrategy(),
+		strategies.NewRegisterPressureStrategy(),
+		strategies.NewCgroupStrategy(),
+		strategies.NewSleepableStrategy(),
+		strategies.NewTimersStrategy(),
+		strategies.NewKfuncsStrategy(),
+		strategies.NewXdpStrategy(),
 	}
-)
+}
 
-func main() {
-	flag.Parse()
-	var strategy units.Strategy = nil
+// restore undoes the changes `restorers` made to the host, in reverse order.
+func restore(restorers []setup.Restorer) {
+	for i := len(restorers) - 1; i >= 0; i-- {
+		if err := restorers[i].Restore(); err != nil {
+			logging.Warningf("failed to restore the host setup: %v\n", err)
+		}
+	}
+}
+
+// restoreOnSignal restores `restorers` and exits when the fuzzer is
+// interrupted. The returned function restores them when the fuzzer is done,
+// whichever comes first restores them once and the other one waits for it.
+func restoreOnSignal(restorers []setup.Restorer) func() {
+	var once sync.Once
+	restoreOnce := func() {
+		once.Do(func() { restore(restorers) })
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		restoreOnce()
+		os.Exit(1)
+	}()
+	return restoreOnce
+}
+
+// setupLogging makes the output of the fuzzer go through a logger configured
+// by the flags. The returned function closes the log file.
+func setupLogging() (func(), error) {
+	level, err := logging.ParseLevel(*logLevel)
+	if err != nil {
+		return nil, err
+	}
+	if *quiet {
+		level = logging.Report
+	}
+	if level == logging.Debug && *logRate == 0 && *logFile == "" {
+		// Nothing to filter or log, the default logger prints everything.
+		return func() {}, nil
+	}
+	var file *logging.RotatingFile
+	if *logFile != "" {
+		file, err = logging.OpenRotatingFile(*logFile, *logFileMaxSize, *logFileCount)
+		if err != nil {
+			return nil, err
+		}
+	}
+	logging.SetDefault(logging.NewLogger(os.Stdout, level, *logRate, file))
+	return func() {
+		if file != nil {
+			file.Close()
+		}
+	}, nil
+}
+
+// loopbackByDefault returns `addr`, on the loopback interface if it has no
+// host, e.g. ":8090".
+func loopbackByDefault(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || host != "" {
+		return addr
+	}
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+// reportStats reports the stats of `metricsUnit` every `interval`, followed
+// by the `top` most frequent classes of verifier errors if it is positive.
+func reportStats(metricsUnit *units.Metrics, interval time.Duration, top int) {
+	for range time.Tick(interval) {
+		logging.Reportf("%s\n", metricsUnit.StatsLine())
+		if top <= 0 {
+			continue
+		}
+		if histogram := metricsUnit.RejectionHistogram(top); histogram != "" {
+			logging.Reportf("%s", histogram)
+		}
+	}
+}
+
+// workerStrategies are the strategies a worker uses.
+type workerStrategies struct {
+	// strategy is the strategy the worker starts with.
+	strategy units.Strategy
+	phases   []units.CampaignPhase
+
+	// selected are all the strategies the worker will use, for the flags
+	// that configure one of them.
+	selected []units.Strategy
+}
+
+// newWorkerStrategies creates the strategies and picks the ones the flags
+// select for a worker. Returns nil if the strategy flag is invalid.
+func newWorkerStrategies() *workerStrategies {
+	strats := newStrategies()
+	ws := &workerStrategies{}
 	for _, s := range strats {
 		if s.Name() == *strategyName {
-			strategy = s
+			ws.strategy = s
 			break
 		}
 	}
-	if strategy == nil {
+	if *campaignPhases != "" {
+		var err error
+		ws.phases, err = units.ParseCampaignPhases(*campaignPhases, strats)
+		if err != nil {
+			log.Fatalf("invalid campaign_phases: %v", err)
+		}
+		ws.strategy = ws.phases[0].Strategy
+	}
+	if ws.strategy == nil {
+		return nil
+	}
+	ws.selected = []units.Strategy{ws.strategy}
+	if len(ws.phases) > 0 {
+		ws.selected = nil
+		for _, phase := range ws.phases {
+			ws.selected = append(ws.selected, phase.Strategy)
+		}
+	}
+	return ws
+}
+
+// configureStrategies applies the flags that configure a strategy to
+// `selected`. The seeds of the mutation_based strategy are only added if
+// `seeds` is true, other workers get them through the shared corpus. Retired
+// programs go to `archive`, which can be nil.
+func configureStrategies(selected []units.Strategy, seeds bool, archive *corpus.Writer) {
+	if seeds && *mutationSeeds != "" {
+		mb, ok := selectedStrategy[*strategies.MutationBased](selected)
+		if !ok {
+			log.Fatalf("mutation_seeds requires the mutation_based strategy")
+		}
+		entries, err := corpus.Load(*mutationSeeds)
+		if err != nil {
+			log.Fatalf("failed to load mutation seeds: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.GetIsValid() {
+				mb.AddSeeds(entry.GetProgram())
+			}
+		}
+	}
+	if seeds && *pinnedSeeds != "" {
+		mb, ok := selectedStrategy[*strategies.MutationBased](selected)
+		if !ok {
+			log.Fatalf("pinned_seeds requires the mutation_based strategy")
+		}
+		for _, path := range strings.Split(*pinnedSeeds, ",") {
+			prog, err := units.LoadPinnedProgram(&units.FFI{}, path)
+			if err != nil {
+				log.Fatalf("failed to load pinned seed: %v", err)
+			}
+			mb.AddSeeds(prog)
+		}
+	}
+	if *elfSeeds != "" {
+		mb, ok := selectedStrategy[*strategies.MutationBased](selected)
+		if !ok {
+			log.Fatalf("elf_seeds requires the mutation_based strategy")
+		}
+		// Only the first worker gets the seeds, but every worker
+		// loads its programs with their type: the programs derived
+		// from them are shared.
+		ffi := &units.FFI{}
+		for _, path := range strings.Split(*elfSeeds, ",") {
+			progs, mapFds, err := units.LoadELF(ffi, path)
+			if err != nil {
+				log.Fatalf("failed to load elf seed: %v", err)
+			}
+			for _, prog := range progs {
+				if prog.ProgramType == units.ProgTypeSchedCls {
+					mb.SetProgramType(units.ProgTypeSchedCls)
+				}
+				if seeds {
+					mb.AddSeeds(prog.Program)
+				}
+			}
+			// The strategy points the map loads of the seeds to
+			// its own map.
+			for _, fd := range mapFds {
+				ffi.CloseFD(fd)
+			}
+		}
+	}
+	if *maxFruitless != 0 || archive != nil {
+		mb, ok := selectedStrategy[*strategies.MutationBased](selected)
+		if !ok {
+			log.Fatalf("max_fruitless_mutations and corpus_archive require the mutation_based strategy")
+		}
+		mb.SetRetirement(*maxFruitless, archive)
+	}
+	if *mapKeyPatterns != "" {
+		ks, ok := selectedStrategy[*strategies.MapKeySpace](selected)
+		if !ok {
+			log.Fatalf("map_key_patterns requires the map_key_space strategy")
+		}
+		var patterns []units.KeyPattern
+		for _, name := range strings.Split(*mapKeyPatterns, ",") {
+			pattern, err := units.ParseKeyPattern(name)
+			if err != nil {
+				log.Fatalf("invalid map_key_patterns: %v", err)
+			}
+			patterns = append(patterns, pattern)
+		}
+		ks.SetKeyPatterns(patterns...)
+	}
+	if seeds && *mapPinDir != "" {
+		mm, ok := selectedStrategy[*strategies.MapOfMaps](selected)
+		if !ok {
+			log.Fatalf("map_pin_dir requires the map_of_maps strategy")
+		}
+		mm.SetPinDir(*mapPinDir)
+	}
+	if *loopMaxTripCount != 0 || *loopMaxDepth != 0 {
+		bl, ok := selectedStrategy[*strategies.BoundedLoops](selected)
+		if !ok {
+			log.Fatalf("loop_max_trip_count and loop_max_depth require the bounded_loops strategy")
+		}
+		if *loopMaxTripCount < 0 || *loopMaxDepth < 0 || *loopMaxDepth > 3 {
+			log.Fatalf("loop_max_trip_count must be positive and loop_max_depth between 1 and 3")
+		}
+		bl.SetLoopLimits(int32(*loopMaxTripCount), *loopMaxDepth)
+	}
+	if *pairedEbpf {
+		sf, ok := selectedStrategy[*strategies.SocketFilter](selected)
+		if !ok {
+			log.Fatalf("paired_ebpf requires the socket_filter strategy")
+		}
+		sf.EnablePairedEbpf()
+	}
+}
+
+// selectedStrategy returns the first of `selected` that is a T.
+func selectedStrategy[T any](selected []units.Strategy) (T, bool) {
+	for _, s := range selected {
+		if t, ok := s.(T); ok {
+			return t, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// runCommand runs the subcommand in `args`, followed by its arguments:
+//
+//	decode <program>: prints the program, in any format ebpf.ReadProgram
+//	reads, as a text proto followed by its disassembly.
+//	encode <program> <output>: converts the program to the format of the
+//	output file, e.g. a text proto edited by hand to a .json PoC.
+//
+// The replay command needs the kernel and the flags of the fuzzer, it is
+// parsed by parseReplay and run by runFuzzer instead.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "decode":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: buzzer decode <program>")
+		}
+		program, err := ebpf.ReadProgram(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Print(ebpf.ToTextProto(program))
+		listing, err := ebpf.Disassemble(program)
+		if err != nil {
+			return err
+		}
+		fmt.Print(listing)
+		return nil
+	case "encode":
+		if len(args) != 3 {
+			return fmt.Errorf("usage: buzzer encode <program> <output>")
+		}
+		program, err := ebpf.ReadProgram(args[1])
+		if err != nil {
+			return err
+		}
+		if _, err := ebpf.EncodeInstructions(program); err != nil {
+			return fmt.Errorf("%s does not encode to eBPF: %w", args[1], err)
+		}
+		return ebpf.WriteProgram(args[2], program)
+	}
+	return fmt.Errorf("unknown command %q, want decode, encode or replay", args[0])
+}
+
+// defaultReplayRuns is how many times the replay command loads the program
+// if it is not told otherwise.
+const defaultReplayRuns = 10
+
+// replayRequest is a program to replay and how many times.
+type replayRequest struct {
+	entry *cpb.CorpusEntry
+	runs  int
+}
+
+// parseReplay parses the arguments of the replay command,
+//
+//	replay <program> [runs]: loads the program, in any format
+//	ebpf.ReadProgram reads, or the entry <index> of a corpus file given
+//	as <corpus>#<index>, `runs` times and executes it every time the
+//	verifier accepts it, then prints the results. The program is loaded as
+//	the strategy flag says, e.g. BPF_PROG_TYPE_SCHED_CLS for spin_lock.
+func parseReplay(args []string) (*replayRequest, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, fmt.Errorf("usage: buzzer replay <program or corpus#index> [runs]")
+	}
+	r := &replayRequest{runs: defaultReplayRuns}
+	if len(args) == 3 {
+		runs, err := strconv.Atoi(args[2])
+		if err != nil || runs < 1 {
+			return nil, fmt.Errorf("invalid number of runs %q", args[2])
+		}
+		r.runs = runs
+	}
+	if path, index, ok := strings.Cut(args[1], "#"); ok {
+		entries, err := corpus.Load(path)
+		if err != nil {
+			return nil, err
+		}
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(entries) {
+			return nil, fmt.Errorf("invalid entry %q, %s has %d entries", index, path, len(entries))
+		}
+		r.entry = entries[i]
+		return r, nil
+	}
+	program, err := ebpf.ReadProgram(args[1])
+	if err != nil {
+		return nil, err
+	}
+	r.entry = &cpb.CorpusEntry{Program: program}
+	return r, nil
+}
+
+func main() {
+	flag.Parse()
+	var replay *replayRequest
+	if flag.NArg() > 0 && flag.Arg(0) == "replay" {
+		r, err := parseReplay(flag.Args())
+		if err != nil {
+			log.Fatal(err)
+		}
+		replay = r
+	} else if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	stopLogging, err := setupLogging()
+	if err != nil {
+		log.Fatalf("failed to set up logging: %v", err)
+	}
+	if *seed != 0 {
+		rand.SetSharedSeed(*seed)
+	}
+	var checkpoint *cpb.Checkpoint
+	if *resume {
+		if *checkpointPath == "" {
+			log.Fatalf("resume requires a checkpoint file")
+		}
+		c, err := units.LoadCheckpoint(*checkpointPath)
+		if err != nil {
+			log.Fatalf("failed to load the checkpoint: %v", err)
+		}
+		checkpoint = c
+		rand.RestoreSharedState(checkpoint.GetSeed(), checkpoint.GetRngDraws())
+		logging.Infof("resuming from the checkpoint of %v\n", time.Unix(0, checkpoint.GetTimeUnixNano()))
+	}
+	logging.Infof("using seed %d\n", rand.SharedSeed())
+
+	ws := newWorkerStrategies()
+	if ws == nil {
 		fmt.Printf("Invalid strategy name %s, available strategies are: \n", *strategyName)
-		for _, s := range strats {
+		for _, s := range newStrategies() {
 			fmt.Printf("\t - %s\n", s.Name())
 		}
 		return
 	}
-	fmt.Printf("using strategy %s\n", strategy.Name())
+	if *numWorkers < 1 {
+		log.Fatalf("workers must be at least 1, got %d", *numWorkers)
+	}
+	strategy := ws.strategy
+	var archive *corpus.Writer
+	if *corpusArchive != "" {
+		w, err := corpus.OpenWriter(*corpusArchive)
+		if err != nil {
+			log.Fatalf("failed to open corpus archive: %v", err)
+		}
+		defer w.Close()
+		archive = w
+	}
+	configureStrategies(ws.selected, true, archive)
+	logging.Infof("using strategy %s\n", strategy.Name())
 	coverageManager := units.NewCoverageManager(func(inputString string) (string, error) {
 		cmd := exec.Command("/usr/bin/addr2line", "-e", *vmLinuxPath)
 		w, err := cmd.StdinPipe()
@@ -72,17 +581,361 @@ func main() {
 		outBytes, err := cmd.Output()
 		return string(outBytes), err
 	})
+	if checkpoint != nil {
+		if err := units.RestoreCoverage(checkpoint, coverageManager); err != nil {
+			log.Fatalf("failed to restore the coverage: %v", err)
+		}
+	}
 
-	controlUnit := units.Control{}
+	controlUnit := units.Control{
+		VerifierReloadCount:  *verifierReloads,
+		LogLevelDifferential: *logLevelDiff,
+		ConcurrentExecutions: *concurrentExecs,
+		MinimizeFindings:     *minimizeFindings,
+		ELFRepros:            *elfRepros,
+		SyzRepros:            *syzRepros,
+		ArtifactDir:          *artifactDir,
+		ConsoleMarkers:       *consoleMarkers,
+		ReduceGuards:         *reduceGuards,
+		ReferenceLeaks:       *referenceLeaks,
+		TransientRetries:     *transientRetries,
+		TransientBackoff:     *transientBackoff,
+		FixedMemoryLimits:    *memlockLimit != 0 || *cgroupMemoryMax != 0,
+		NegativeSuite:        *negativeSuite,
+		TestRun:              *testRun,
+		TestRunRepeat:        uint32(*testRunRepeat),
+		BatchSize:            *batchSize,
+		HangTimeout:          *hangTimeout,
+		MapDeltas:            *mapDeltas,
+		ValueTraces:          *valueTraces,
+		HelperCoverage:       *helperCoverage || *targetHelpers,
+	}
+	if *allowInsns != "" || *blockInsns != "" {
+		filter, err := ebpf.NewInstructionFilter(*allowInsns, *blockInsns)
+		if err != nil {
+			log.Fatalf("invalid allow_insns or block_insns: %v", err)
+		}
+		ebpf.SetInstructionFilter(filter)
+		controlUnit.InstructionFilter = filter
+	}
+	if *fuzzConfig != "" {
+		weights, err := ebpf.LoadWeights(*fuzzConfig)
+		if err != nil {
+			log.Fatalf("invalid config: %v", err)
+		}
+		ebpf.SetWeights(weights)
+	}
+	kernel, kernelErr := units.CurrentKernelInfo()
+	release := kernel.Release
+	if *kernelRelease != "" {
+		release = *kernelRelease
+	}
+	controlUnit.Arch = ebpf.HostArch(release)
+	if *arch != "" {
+		a, err := ebpf.LookupArch(*arch, release)
+		if err != nil {
+			log.Fatalf("invalid arch: %v", err)
+		}
+		controlUnit.Arch = a
+	}
+	ebpf.SetArch(controlUnit.Arch)
+	if *dryRun {
+		if err := controlUnit.Init(&units.FFI{Maps: units.NewFakeMaps()}, nil, strategy); err != nil {
+			log.Fatalf("failed to init control unit: %v", err)
+		}
+		if err := controlUnit.DryRun(os.Stdout, *dryRunPrograms); err != nil {
+			log.Fatalf("dry run failed: %v", err)
+		}
+		return
+	}
+	if *testRunData != "" {
+		data, err := units.ParseTestRunData(*testRunData)
+		if err != nil {
+			log.Fatalf("invalid test_run_data: %v", err)
+		}
+		controlUnit.TestRunData = data
+	}
+	if *testRunCtx != "" {
+		ctx, err := hex.DecodeString(*testRunCtx)
+		if err != nil {
+			log.Fatalf("invalid test_run_ctx: %v", err)
+		}
+		controlUnit.TestRunCtx = ctx
+	}
+	if *bugReport {
+		if kernelErr != nil {
+			logging.Warningf("Bug reports will not include config highlights: %v\n", kernelErr)
+		}
+		controlUnit.FindingHooks = append(controlUnit.FindingHooks, &units.BugReportHook{Kernel: kernel})
+	}
+	if *triageDir != "" {
+		controlUnit.Triage = units.NewTriage(*triageDir)
+	}
+	if *kernelLog {
+		kl, err := units.OpenKernelLog(units.DefaultKernelLogPath)
+		if err != nil {
+			logging.Warningf("Kernel splats will not be reported: %v\n", err)
+		} else {
+			defer kl.Close()
+			controlUnit.KernelLog = kl
+		}
+	}
+	if *triggers != "" {
+		t, err := units.ParseTriggers(*triggers)
+		if err != nil {
+			log.Fatalf("invalid triggers: %v", err)
+		}
+		controlUnit.Triggers = t
+	}
+	privileges, err := units.ParseLoadPrivileges(*loadPrivileges)
+	if err != nil {
+		log.Fatalf("invalid privileges: %v", err)
+	}
+	controlUnit.Privileges = privileges
+	if slices.Contains(privileges, units.LoadWithToken) {
+		token, err := (&units.FFI{}).CreateBpfToken()
+		if err != nil {
+			log.Fatalf("failed to create a BPF token, it requires a 6.9 or later kernel: %v", err)
+		}
+		controlUnit.BpfToken = token
+	}
+	if *checkpointPath != "" {
+		controlUnit.Checkpoints = units.NewCheckpointer(*checkpointPath, *checkpointInterval, coverageManager)
+	}
+	if *findingHookCmd != "" {
+		controlUnit.FindingHooks = append(controlUnit.FindingHooks, &units.CommandHook{Path: *findingHookCmd})
+	}
 	metricsUnit := units.NewMetricsUnit(*metricsThreshold, *coverageBufferSize, *vmLinuxPath, *sourceFilesPath, *metricsServerAddr, uint16(*metricsServerPort), coverageManager)
+	if *targetHelpers {
+		ebpf.FavorHelpers(func(id int32) bool { return !metricsUnit.HelperCalled(id) })
+	}
+
+	if *statsInterval > 0 {
+		go reportStats(metricsUnit, *statsInterval, *rejectionHistogram)
+	}
+	if *telemetryEndpoint != "" {
+		units.NewTelemetryExporter(*telemetryEndpoint, *telemetryInterval, strategy.Name(), metricsUnit).Start()
+	}
 
 	if err := controlUnit.Init(&units.FFI{
 		MetricsUnit: metricsUnit,
 	}, coverageManager, strategy); err != nil {
 		log.Fatalf("failed to init control unit: %v", err)
 	}
+	if *maxExecutions < 0 || *maxFindings < 0 || *maxRuntime < 0 {
+		log.Fatalf("run limits can't be negative")
+	}
+	findingLimit := *maxFindings
+	if *stopOnFinding {
+		findingLimit = 1
+	}
+	controlUnit.Limits = units.NewRunLimits(*maxExecutions, findingLimit, *maxRuntime)
+	workers := []*units.Control{&controlUnit}
+	phases := [][]units.CampaignPhase{ws.phases}
+	if *numWorkers > 1 {
+		controlUnit.Shared = units.NewSharedCorpus()
+	}
+	if *controlServiceAddr != "" {
+		// The pushed programs reach the strategies through the shared
+		// corpus, even with a single worker.
+		if controlUnit.Shared == nil {
+			controlUnit.Shared = units.NewSharedCorpus()
+		}
+		service := units.NewControlService(&units.FFI{
+			MetricsUnit: metricsUnit,
+		}, controlUnit.Shared)
+		if *controlToken != "" {
+			token, err := os.ReadFile(*controlToken)
+			if err != nil {
+				log.Fatalf("failed to read the control service token: %v", err)
+			}
+			service.Token = strings.TrimSpace(string(token))
+		}
+		if *controlCert != "" {
+			config, err := units.LoadControlServiceTLS(*controlCert, *controlKey, *controlClientCA)
+			if err != nil {
+				log.Fatalf("failed to load the control service certificates: %v", err)
+			}
+			service.TLS = config
+		} else if *controlClientCA != "" {
+			log.Fatalf("control_service_client_ca requires control_service_cert")
+		}
+		if service.Token == "" && *controlClientCA == "" {
+			log.Fatalf("the control service runs any program, it requires control_service_token_file or control_service_client_ca")
+		}
+		controlUnit.FindingHooks = append(controlUnit.FindingHooks, service)
+		lis, err := net.Listen("tcp", loopbackByDefault(*controlServiceAddr))
+		if err != nil {
+			log.Fatalf("failed to listen for the control service: %v", err)
+		}
+		go func() {
+			if err := service.Serve(lis); err != nil {
+				logging.Warningf("Control service stopped: %v\n", err)
+			}
+		}()
+	}
+	for i := 1; i < *numWorkers; i++ {
+		// Every worker has the configuration of the first one but its
+		// own strategies and FFI. The negative suite only runs once.
+		worker := controlUnit
+		worker.Worker = i
+		worker.NegativeSuite = false
+		wws := newWorkerStrategies()
+		configureStrategies(wws.selected, false, archive)
+		if err := worker.Init(&units.FFI{
+			MetricsUnit: metricsUnit,
+		}, coverageManager, wws.strategy); err != nil {
+			log.Fatalf("failed to init control unit of worker %d: %v", i, err)
+		}
+		workers = append(workers, &worker)
+		phases = append(phases, wws.phases)
+	}
+	if checkpoint != nil {
+		for _, worker := range workers {
+			worker.Resume(checkpoint)
+		}
+	}
 
-	if err := controlUnit.RunFuzzer(); err != nil {
-		log.Fatalf("failed to init control unit: %v", err)
+	memorySetup, err := setup.ConfigureMemory(setup.MemoryConfig{
+		MemlockLimit:    *memlockLimit,
+		CgroupMemoryMax: *cgroupMemoryMax,
+	})
+	if err != nil {
+		memorySetup.Restore()
+		log.Fatalf("failed to configure memory limits: %v", err)
+	}
+	restorers := []setup.Restorer{memorySetup}
+	if *xdpVeth != "" {
+		// Every worker gets a veth pair of its own, the interfaces take a
+		// single XDP program and the packets of the others would be seen
+		// otherwise.
+		for _, worker := range workers {
+			name := fmt.Sprintf("%s%d_", *xdpVeth, worker.Worker)
+			vethSetup, err := setup.CreateVethPair(name+"1", name+"0")
+			restorers = append(restorers, vethSetup)
+			if err != nil {
+				restore(restorers)
+				log.Fatalf("failed to create the XDP veth pair of worker %d: %v", worker.Worker, err)
+			}
+			xdp, err := units.NewXdpHarness(name+"1", name+"0", *xdpNative)
+			if err != nil {
+				restore(restorers)
+				log.Fatalf("failed to set up the XDP harness: %v", err)
+			}
+			worker.Xdp = xdp
+		}
+	}
+	if *cgroupName != "" {
+		cgroupSetup, err := setup.CreateCgroup("", *cgroupName)
+		restorers = append(restorers, cgroupSetup)
+		if err != nil {
+			restore(restorers)
+			log.Fatalf("failed to create the cgroup: %v", err)
+		}
+		// Every worker gets a child cgroup of its own, the programs of the
+		// others would judge its traffic otherwise.
+		for _, worker := range workers {
+			workerSetup, err := setup.CreateCgroup(cgroupSetup.Path, fmt.Sprintf("w%d", worker.Worker))
+			restorers = append(restorers, workerSetup)
+			if err != nil {
+				restore(restorers)
+				log.Fatalf("failed to create the cgroup of worker %d: %v", worker.Worker, err)
+			}
+			cgroup, err := units.NewCgroupHarness(workerSetup.Path, *cgroupCycles)
+			if err != nil {
+				restore(restorers)
+				log.Fatalf("failed to set up the cgroup harness: %v", err)
+			}
+			worker.Cgroup = cgroup
+		}
+	}
+	if us, ok := selectedStrategy[units.UnprivilegedStrategy](ws.selected); ok {
+		unprivilegedSetup, err := setup.AllowUnprivilegedBpf()
+		if err != nil {
+			restore(restorers)
+			log.Fatalf("strategy %s requires unprivileged eBPF: %v", us.Name(), err)
+		}
+		restorers = append(restorers, unprivilegedSetup)
+	} else if slices.Contains(privileges, units.LoadUnprivileged) {
+		unprivilegedSetup, err := setup.AllowUnprivilegedBpf()
+		if err != nil {
+			restore(restorers)
+			log.Fatalf("unprivileged loads require unprivileged eBPF: %v", err)
+		}
+		restorers = append(restorers, unprivilegedSetup)
+	}
+	restoreOnce := restoreOnSignal(restorers)
+	err = runFuzzer(workers, phases, replay)
+	restoreOnce()
+	if replay == nil && *replayCorpusPath == "" && *replayDecisionLog == "" {
+		logging.Reportf("%s\n", controlUnit.Limits.Summary())
+	}
+	stopLogging()
+	if err != nil {
+		log.Fatal(err)
 	}
 }
+
+// runFuzzer replays with the first of `workers` or fuzzes with all of them in
+// parallel according to the flags and the replay command, if `replay` is not
+// nil. Each worker goes through its `phases`, if there are any.
+func runFuzzer(workers []*units.Control, phases [][]units.CampaignPhase, replay *replayRequest) error {
+	if replay != nil {
+		runs, err := workers[0].ReplayProgram(replay.entry, replay.runs)
+		units.WriteReplay(os.Stdout, runs)
+		if err != nil {
+			return fmt.Errorf("failed to replay program: %w", err)
+		}
+		return nil
+	}
+
+	if *replayCorpusPath != "" {
+		if _, err := workers[0].ReplayCorpus(*replayCorpusPath); err != nil {
+			return fmt.Errorf("failed to replay corpus: %w", err)
+		}
+		return nil
+	}
+
+	if *replayDecisionLog != "" {
+		if _, err := workers[0].ReplayDecisionLog(*replayDecisionLog, *replayTiming); err != nil {
+			return fmt.Errorf("failed to replay decision log: %w", err)
+		}
+		return nil
+	}
+
+	if *decisionLogPath != "" {
+		dl, err := units.OpenDecisionLog(*decisionLogPath, *decisionLogWindow)
+		if err != nil {
+			return fmt.Errorf("failed to open decision log: %w", err)
+		}
+		defer dl.Close()
+		for _, worker := range workers {
+			worker.DecisionLog = dl
+		}
+	}
+
+	if *corpusPath != "" {
+		w, err := corpus.OpenWriter(*corpusPath)
+		if err != nil {
+			return fmt.Errorf("failed to open corpus: %w", err)
+		}
+		defer w.Close()
+		for _, worker := range workers {
+			worker.Corpus = w
+		}
+	}
+
+	return units.RunWorkers(workers, func(controlUnit *units.Control) error {
+		if workerPhases := phases[controlUnit.Worker]; len(workerPhases) > 0 {
+			if _, err := controlUnit.RunCampaign(workerPhases); err != nil {
+				return fmt.Errorf("campaign failed: %w", err)
+			}
+			return nil
+		}
+
+		if err := controlUnit.RunFuzzer(); err != nil {
+			return fmt.Errorf("failed to init control unit: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,226 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package btf encodes the BPF Type Format, the debug information programs can
+// be loaded with, see Documentation/bpf/btf.rst in the kernel tree.
+package btf
+
+import (
+	"encoding/binary"
+)
+
+const (
+	// Magic is the first field of the BTF header.
+	Magic = 0xeb9f
+
+	// Version is the only version of the format.
+	Version = 1
+
+	// HeaderSize is the size of struct btf_header.
+	HeaderSize = 24
+)
+
+// Kind is the kind of a BTF type, BTF_KIND_* of include/uapi/linux/btf.h.
+type Kind uint32
+
+const (
+	KindInt       Kind = 1
+	KindPtr       Kind = 2
+	KindArray     Kind = 3
+	KindStruct    Kind = 4
+	KindUnion     Kind = 5
+	KindEnum      Kind = 6
+	KindFwd       Kind = 7
+	KindTypedef   Kind = 8
+	KindVolatile  Kind = 9
+	KindConst     Kind = 10
+	KindRestrict  Kind = 11
+	KindFunc      Kind = 12
+	KindFuncProto Kind = 13
+	KindVar       Kind = 14
+	KindDatasec   Kind = 15
+	KindFloat     Kind = 16
+	KindDeclTag   Kind = 17
+	KindTypeTag   Kind = 18
+	KindEnum64    Kind = 19
+)
+
+// Encodings of the BTF_KIND_INT types.
+const (
+	IntSigned = 1 << 0
+	IntChar   = 1 << 1
+	IntBool   = 1 << 2
+)
+
+// Linkage is the linkage of a BTF_KIND_FUNC type.
+type Linkage uint32
+
+const (
+	LinkageStatic Linkage = 0
+	LinkageGlobal Linkage = 1
+	LinkageExtern Linkage = 2
+)
+
+// VarLinkage is the linkage of a BTF_KIND_VAR type.
+type VarLinkage uint32
+
+const (
+	VarStatic          VarLinkage = 0
+	VarGlobalAllocated VarLinkage = 1
+)
+
+// TypeID identifies a type in a BTF blob, 0 is void.
+type TypeID uint32
+
+// Void is the type every BTF blob has without declaring it.
+const Void TypeID = 0
+
+// Param is a parameter of a BTF_KIND_FUNC_PROTO type.
+type Param struct {
+	Name string
+	Type TypeID
+}
+
+// Member is a member of a BTF_KIND_STRUCT type, Offset is in bits.
+type Member struct {
+	Name   string
+	Type   TypeID
+	Offset uint32
+}
+
+// Builder accumulates types and their names to encode them as a BTF blob.
+type Builder struct {
+	types   []uint32
+	nextID  TypeID
+	strings []byte
+	offsets map[string]uint32
+}
+
+// NewBuilder returns a builder without any type.
+func NewBuilder() *Builder {
+	return &Builder{
+		nextID: 1,
+		// The string at offset 0 is the empty one.
+		strings: []byte{0},
+		offsets: map[string]uint32{"": 0},
+	}
+}
+
+// String adds `s` to the string section, if it is not already there, and
+// returns its offset.
+func (b *Builder) String(s string) uint32 {
+	if offset, ok := b.offsets[s]; ok {
+		return offset
+	}
+	offset := uint32(len(b.strings))
+	b.strings = append(append(b.strings, s...), 0)
+	b.offsets[s] = offset
+	return offset
+}
+
+// typeInfo returns the info field of struct btf_type.
+func typeInfo(kind Kind, vlen uint32, kindFlag bool) uint32 {
+	info := uint32(kind)<<24 | vlen&0xffff
+	if kindFlag {
+		info |= 1 << 31
+	}
+	return info
+}
+
+// addType appends a type made of the struct btf_type fields followed by the
+// `extra` words of its kind.
+func (b *Builder) addType(nameOff, info, sizeOrType uint32, extra ...uint32) TypeID {
+	b.types = append(b.types, nameOff, info, sizeOrType)
+	b.types = append(b.types, extra...)
+	id := b.nextID
+	b.nextID++
+	return id
+}
+
+// Int adds an integer of `size` bytes with the `encoding`, a combination of
+// the Int* flags.
+func (b *Builder) Int(name string, size uint32, encoding uint32) TypeID {
+	return b.addType(b.String(name), typeInfo(KindInt, 0, false), size, encoding<<24|8*size)
+}
+
+// Pointer adds a pointer to `target`.
+func (b *Builder) Pointer(target TypeID) TypeID {
+	return b.addType(0, typeInfo(KindPtr, 0, false), uint32(target))
+}
+
+// Struct adds a struct of `size` bytes made of `members`.
+func (b *Builder) Struct(name string, size uint32, members ...Member) TypeID {
+	var extra []uint32
+	for _, member := range members {
+		extra = append(extra, b.String(member.Name), uint32(member.Type), member.Offset)
+	}
+	return b.addType(b.String(name), typeInfo(KindStruct, uint32(len(members)), false), size, extra...)
+}
+
+// Array adds an array of `nelems` elements of type `elem`, indexed by the
+// integer `index`.
+func (b *Builder) Array(elem, index TypeID, nelems uint32) TypeID {
+	return b.addType(0, typeInfo(KindArray, 0, false), 0, uint32(elem), uint32(index), nelems)
+}
+
+// Var adds a variable named `name` of type `typ`.
+func (b *Builder) Var(name string, typ TypeID, linkage VarLinkage) TypeID {
+	return b.addType(b.String(name), typeInfo(KindVar, 0, false), uint32(typ), uint32(linkage))
+}
+
+// Datasec adds the section `name` of `size` bytes that holds `vars`, which
+// must be sorted by offset.
+func (b *Builder) Datasec(name string, size uint32, vars ...SecInfo) TypeID {
+	var extra []uint32
+	for _, v := range vars {
+		extra = append(extra, uint32(v.Type), v.Offset, v.Size)
+	}
+	return b.addType(b.String(name), typeInfo(KindDatasec, uint32(len(vars)), false), size, extra...)
+}
+
+// FuncProto adds a function prototype returning `ret`.
+func (b *Builder) FuncProto(ret TypeID, params ...Param) TypeID {
+	var extra []uint32
+	for _, param := range params {
+		extra = append(extra, b.String(param.Name), uint32(param.Type))
+	}
+	return b.addType(0, typeInfo(KindFuncProto, uint32(len(params)), false), uint32(ret), extra...)
+}
+
+// Func adds a function named `name` with the prototype `proto`.
+func (b *Builder) Func(name string, proto TypeID, linkage Linkage) TypeID {
+	return b.addType(b.String(name), typeInfo(KindFunc, uint32(linkage), false), uint32(proto))
+}
+
+// DeclTag adds the tag `name` to `target`, or to its parameter or member
+// `component`, -1 for the whole of `target`.
+func (b *Builder) DeclTag(name string, target TypeID, component int32) TypeID {
+	return b.addType(b.String(name), typeInfo(KindDeclTag, 0, false), uint32(target), uint32(component))
+}
+
+// Encode returns the BTF blob with the types added so far, in the byte order
+// of the host like the kernel expects.
+func (b *Builder) Encode() []byte {
+	typeLen := uint32(len(b.types) * 4)
+	blob := make([]byte, 0, HeaderSize+int(typeLen)+len(b.strings))
+	blob = binary.NativeEndian.AppendUint16(blob, Magic)
+	blob = append(blob, Version, 0)
+	for _, field := range []uint32{HeaderSize, 0, typeLen, typeLen, uint32(len(b.strings))} {
+		blob = binary.NativeEndian.AppendUint32(blob, field)
+	}
+	for _, word := range b.types {
+		blob = binary.NativeEndian.AppendUint32(blob, word)
+	}
+	return append(blob, b.strings...)
+}
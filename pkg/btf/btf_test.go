@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btf
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestBuilder(t *testing.T) {
+	b := NewBuilder()
+	intType := b.Int("int", 4, IntSigned)
+	ptr := b.Pointer(Void)
+	proto := b.FuncProto(intType, Param{Name: "ctx", Type: ptr})
+	fn := b.Func("main", proto, LinkageGlobal)
+	if !reflect.DeepEqual([]TypeID{intType, ptr, proto, fn}, []TypeID{1, 2, 3, 4}) {
+		t.Errorf("type ids = %v, want [1 2 3 4]", []TypeID{intType, ptr, proto, fn})
+	}
+	if got := b.String("int"); got != 1 {
+		t.Errorf("String() of an existing string = %d, want 1", got)
+	}
+
+	blob := b.Encode()
+	if got := binary.NativeEndian.Uint16(blob); got != Magic {
+		t.Errorf("magic = %#x, want %#x", got, Magic)
+	}
+	word := func(offset int) uint32 { return binary.NativeEndian.Uint32(blob[offset:]) }
+	// int: 4 words, ptr: 3, func_proto with one param: 5, func: 3.
+	typeLen := uint32(4 * (4 + 3 + 5 + 3))
+	strings := "\x00int\x00ctx\x00main\x00"
+	header := []uint32{word(4), word(8), word(12), word(16), word(20)}
+	if want := []uint32{HeaderSize, 0, typeLen, typeLen, uint32(len(strings))}; !reflect.DeepEqual(header, want) {
+		t.Errorf("header = %v, want %v", header, want)
+	}
+	if got := string(blob[HeaderSize+typeLen:]); got != strings {
+		t.Errorf("string section = %q, want %q", got, strings)
+	}
+
+	// The int is 32 bits and signed, the func has the global linkage.
+	if got := word(HeaderSize + 12); got != IntSigned<<24|32 {
+		t.Errorf("int encoding = %#x, want %#x", got, IntSigned<<24|32)
+	}
+	funcInfo := word(HeaderSize + 4*(4+3+5) + 4)
+	if funcInfo != uint32(KindFunc)<<24|uint32(LinkageGlobal) {
+		t.Errorf("func info = %#x, want kind %d with global linkage", funcInfo, KindFunc)
+	}
+}
+
+func TestStruct(t *testing.T) {
+	m := SpinLockMap()
+	word := func(offset int) uint32 { return binary.NativeEndian.Uint32(m.BTF[offset:]) }
+	// unsigned int: 4 words, bpf_spin_lock with one member: 6, value with
+	// two members: 9.
+	if got, want := word(12), uint32(4*(4+6+9)); got != want {
+		t.Fatalf("type section length = %d, want %d", got, want)
+	}
+	value := HeaderSize + 4*(4+6)
+	if got, want := word(value+4), uint32(KindStruct)<<24|2; got != want {
+		t.Errorf("value info = %#x, want %#x", got, want)
+	}
+	if got := word(value + 8); got != SpinLockValueSize {
+		t.Errorf("value size = %d, want %d", got, SpinLockValueSize)
+	}
+	// Type, then offset in bits, of each member.
+	members := []uint32{word(value + 16), word(value + 20), word(value + 28), word(value + 32)}
+	if want := []uint32{2, 8 * SpinLockOffset, 1, 8 * SpinLockDataOffset}; !reflect.DeepEqual(members, want) {
+		t.Errorf("members = %v, want %v", members, want)
+	}
+	if m.KeyType != 1 || m.ValueType != 3 {
+		t.Errorf("key and value types = %d and %d, want 1 and 3", m.KeyType, m.ValueType)
+	}
+}
+
+func TestTimerMap(t *testing.T) {
+	m := TimerMap()
+	word := func(offset int) uint32 { return binary.NativeEndian.Uint32(m.BTF[offset:]) }
+	// The two ints: 4 words each, the array: 6, bpf_timer with one member:
+	// 6, value with two members: 9.
+	if got, want := word(12), uint32(4*(4+4+6+6+9)); got != want {
+		t.Fatalf("type section length = %d, want %d", got, want)
+	}
+	timer := HeaderSize + 4*(4+4+6)
+	if got := word(timer + 8); got != 16 {
+		t.Errorf("bpf_timer size = %d, want 16", got)
+	}
+	value := timer + 4*6
+	if got := word(value + 8); got != TimerValueSize {
+		t.Errorf("value size = %d, want %d", got, TimerValueSize)
+	}
+	members := []uint32{word(value + 16), word(value + 20), word(value + 28), word(value + 32)}
+	if want := []uint32{4, 8 * TimerOffset, 1, 8 * TimerDataOffset}; !reflect.DeepEqual(members, want) {
+		t.Errorf("members = %v, want %v", members, want)
+	}
+	if m.KeyType != 1 || m.ValueType != 5 {
+		t.Errorf("key and value types = %d and %d, want 1 and 5", m.KeyType, m.ValueType)
+	}
+}
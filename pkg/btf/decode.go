@@ -0,0 +1,202 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// Type is a decoded BTF type. Only the fields of its Kind are set.
+type Type struct {
+	Kind Kind
+	Name string
+
+	// SizeOrType is the size of the ints, structs, unions, enums and
+	// datasecs and the type the other kinds refer to, e.g. the target of
+	// a pointer.
+	SizeOrType uint32
+
+	// Members of a struct or a union.
+	Members []Member
+
+	// Params of a function prototype, SizeOrType is what it returns.
+	Params []Param
+
+	// Elem and Nelems describe an array.
+	Elem   TypeID
+	Nelems uint32
+
+	// Vars of a datasec.
+	Vars []SecInfo
+}
+
+// SecInfo is a variable of a BTF_KIND_DATASEC type, Offset and Size are in
+// bytes.
+type SecInfo struct {
+	Type   TypeID
+	Offset uint32
+	Size   uint32
+}
+
+// Types are the types of a BTF blob by id, the first one is Void.
+type Types []*Type
+
+// Decode returns the types of the BTF blob `blob`, e.g. the .BTF section of
+// an object file. The byte order is the one of the magic.
+func Decode(blob []byte) (Types, error) {
+	if len(blob) < HeaderSize {
+		return nil, fmt.Errorf("btf blob of %d bytes is shorter than its header", len(blob))
+	}
+	var order binary.ByteOrder
+	switch {
+	case binary.LittleEndian.Uint16(blob) == Magic:
+		order = binary.LittleEndian
+	case binary.BigEndian.Uint16(blob) == Magic:
+		order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("bad btf magic %#x", binary.LittleEndian.Uint16(blob))
+	}
+	hdrLen := order.Uint32(blob[4:])
+	typeOff, typeLen := order.Uint32(blob[8:]), order.Uint32(blob[12:])
+	strOff, strLen := order.Uint32(blob[16:]), order.Uint32(blob[20:])
+	if uint64(hdrLen)+uint64(typeOff)+uint64(typeLen) > uint64(len(blob)) || uint64(hdrLen)+uint64(strOff)+uint64(strLen) > uint64(len(blob)) {
+		return nil, fmt.Errorf("btf sections are out of the blob")
+	}
+	data := blob[hdrLen+typeOff : hdrLen+typeOff+typeLen]
+	strs := blob[hdrLen+strOff : hdrLen+strOff+strLen]
+	name := func(offset uint32) string {
+		if offset >= uint32(len(strs)) {
+			return ""
+		}
+		s := strs[offset:]
+		if end := bytes.IndexByte(s, 0); end >= 0 {
+			s = s[:end]
+		}
+		return string(s)
+	}
+
+	types := Types{{}}
+	for off := 0; off < len(data); {
+		if off+12 > len(data) {
+			return nil, fmt.Errorf("type %d is truncated", len(types))
+		}
+		word := func(i int) uint32 { return order.Uint32(data[off+12+4*i:]) }
+		info := order.Uint32(data[off+4:])
+		t := &Type{
+			Kind:       Kind(info >> 24 & 0x1f),
+			Name:       name(order.Uint32(data[off:])),
+			SizeOrType: order.Uint32(data[off+8:]),
+		}
+		vlen := int(info & 0xffff)
+
+		// The number of words following struct btf_type.
+		var extra int
+		switch t.Kind {
+		case KindInt, KindVar, KindDeclTag:
+			extra = 1
+		case KindArray:
+			extra = 3
+		case KindStruct, KindUnion, KindDatasec, KindEnum64:
+			extra = 3 * vlen
+		case KindEnum, KindFuncProto:
+			extra = 2 * vlen
+		case KindPtr, KindFwd, KindTypedef, KindVolatile, KindConst, KindRestrict, KindFunc, KindFloat, KindTypeTag:
+		default:
+			return nil, fmt.Errorf("type %d has the unknown kind %d", len(types), t.Kind)
+		}
+		if off+12+4*extra > len(data) {
+			return nil, fmt.Errorf("type %d is truncated", len(types))
+		}
+
+		switch t.Kind {
+		case KindArray:
+			t.Elem, t.Nelems = TypeID(word(0)), word(2)
+		case KindStruct, KindUnion:
+			for i := 0; i < vlen; i++ {
+				t.Members = append(t.Members, Member{Name: name(word(3 * i)), Type: TypeID(word(3*i + 1)), Offset: word(3*i + 2)})
+			}
+		case KindDatasec:
+			for i := 0; i < vlen; i++ {
+				t.Vars = append(t.Vars, SecInfo{Type: TypeID(word(3 * i)), Offset: word(3*i + 1), Size: word(3*i + 2)})
+			}
+		case KindFuncProto:
+			for i := 0; i < vlen; i++ {
+				t.Params = append(t.Params, Param{Name: name(word(2 * i)), Type: TypeID(word(2*i + 1))})
+			}
+		}
+		types = append(types, t)
+		off += 12 + 4*extra
+	}
+	return types, nil
+}
+
+// Type returns the type `id`, nil if there is none.
+func (ts Types) Type(id TypeID) *Type {
+	if int(id) >= len(ts) {
+		return nil
+	}
+	return ts[id]
+}
+
+// Resolve returns the type `id` refers to once the typedefs and the
+// qualifiers are skipped.
+func (ts Types) Resolve(id TypeID) *Type {
+	// Bounded in case of a loop.
+	for i := 0; i < len(ts); i++ {
+		t := ts.Type(id)
+		if t == nil {
+			return nil
+		}
+		switch t.Kind {
+		case KindTypedef, KindVolatile, KindConst, KindRestrict, KindTypeTag:
+			id = TypeID(t.SizeOrType)
+		default:
+			return t
+		}
+	}
+	return nil
+}
+
+// Size returns the size in bytes of the type `id`, false if it has no size,
+// e.g. void or a function.
+func (ts Types) Size(id TypeID) (uint32, bool) {
+	t := ts.Resolve(id)
+	if t == nil {
+		return 0, false
+	}
+	switch t.Kind {
+	case KindInt, KindStruct, KindUnion, KindEnum, KindEnum64, KindDatasec, KindFloat:
+		return t.SizeOrType, true
+	case KindPtr:
+		return 8, true
+	case KindArray:
+		size, ok := ts.Size(t.Elem)
+		return size * t.Nelems, ok
+	}
+	return 0, false
+}
+
+// Datasec returns the datasec named `name`, e.g. ".maps", nil if there is
+// none.
+func (ts Types) Datasec(name string) *Type {
+	for _, t := range ts {
+		if t.Kind == KindDatasec && t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
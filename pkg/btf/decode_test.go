@@ -0,0 +1,81 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	b := NewBuilder()
+	u32 := b.Int("unsigned int", 4, 0)
+	ptr := b.Pointer(u32)
+	value := b.Struct("value", 16, Member{Name: "a", Type: u32}, Member{Name: "p", Type: ptr, Offset: 64})
+	proto := b.FuncProto(u32, Param{Name: "ctx", Type: ptr})
+	b.Func("main", proto, LinkageGlobal)
+	array := b.Array(u32, u32, 3)
+	v := b.Var("v", array, VarGlobalAllocated)
+	b.Datasec(".data", 12, SecInfo{Type: v, Size: 12})
+
+	types, err := Decode(b.Encode())
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	if len(types) != 9 {
+		t.Fatalf("Decode() = %d types, want void and 8", len(types))
+	}
+	if got := types.Type(value); got.Kind != KindStruct || got.Name != "value" || got.SizeOrType != 16 {
+		t.Errorf("struct = %+v, want the 16 bytes value", got)
+	}
+	want := []Member{{Name: "a", Type: u32}, {Name: "p", Type: ptr, Offset: 64}}
+	if got := types.Type(value).Members; !reflect.DeepEqual(got, want) {
+		t.Errorf("members = %v, want %v", got, want)
+	}
+	if got := types.Type(ptr); got.Kind != KindPtr || TypeID(got.SizeOrType) != u32 {
+		t.Errorf("pointer = %+v, want a pointer to %d", got, u32)
+	}
+	if got := types.Type(array); got.Kind != KindArray || got.Elem != u32 || got.Nelems != 3 {
+		t.Errorf("array = %+v, want 3 elements of type %d", got, u32)
+	}
+	datasec := types.Datasec(".data")
+	if want := []SecInfo{{Type: v, Size: 12}}; datasec == nil || !reflect.DeepEqual(datasec.Vars, want) {
+		t.Errorf("Datasec() = %+v, want the vars %v", datasec, want)
+	}
+	if want := []Param{{Name: "ctx", Type: ptr}}; !reflect.DeepEqual(types.Type(proto).Params, want) {
+		t.Errorf("params = %v, want %v", types.Type(proto).Params, want)
+	}
+	if got := types.Type(v); got.Kind != KindVar || got.Name != "v" || TypeID(got.SizeOrType) != array {
+		t.Errorf("var = %+v, want v of type %d", got, array)
+	}
+	for _, c := range []struct {
+		id   TypeID
+		size uint32
+		ok   bool
+	}{{u32, 4, true}, {ptr, 8, true}, {value, 16, true}, {array, 12, true}, {proto, 0, false}, {Void, 0, false}} {
+		if size, ok := types.Size(c.id); size != c.size || ok != c.ok {
+			t.Errorf("Size(%d) = %d, %v, want %d, %v", c.id, size, ok, c.size, c.ok)
+		}
+	}
+
+	if _, err := Decode([]byte{1, 2, 3}); err == nil {
+		t.Errorf("Decode() of a truncated header succeeded")
+	}
+	blob := b.Encode()
+	blob[0] = 0
+	if _, err := Decode(blob); err == nil {
+		t.Errorf("Decode() with a bad magic succeeded")
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btf
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+const (
+	// VmlinuxPath is where the kernel exposes its own BTF.
+	VmlinuxPath = "/sys/kernel/btf/vmlinux"
+
+	// KfuncTag is the declaration tag pahole gives the functions the kernel
+	// exports as kfuncs, only recent kernels and pahole versions have it.
+	KfuncTag = "bpf_kfunc"
+
+	// maxKfuncArgs is the number of registers arguments are passed in.
+	maxKfuncArgs = 5
+)
+
+// KfuncArg is what the verifier expects in a register passed to a kfunc, as
+// told by the type and the name of the parameter, see
+// Documentation/bpf/kfuncs.rst in the kernel tree.
+type KfuncArg int
+
+const (
+	// KfuncArgScalar any scalar.
+	KfuncArgScalar KfuncArg = iota
+	// KfuncArgConst a known scalar, the name of the parameter ends with
+	// __k or __szk.
+	KfuncArgConst
+	// KfuncArgMem a pointer to memory whose size is the next argument, a
+	// KfuncArgSize or a KfuncArgConst.
+	KfuncArgMem
+	// KfuncArgSize the size of the memory passed in the previous argument,
+	// the name of the parameter ends with __sz.
+	KfuncArgSize
+	// KfuncArgPtr any other pointer, e.g. to the context or to a kernel
+	// object.
+	KfuncArgPtr
+	// KfuncArgIgnored the verifier doesn't look at the argument, the name
+	// of the parameter ends with __ign.
+	KfuncArgIgnored
+)
+
+// Kfunc is a kernel function programs can call, ID is its BTF id in
+// vmlinux.
+type Kfunc struct {
+	Name string
+	ID   TypeID
+	Args []KfuncArg
+}
+
+// LoadVmlinux returns the types of the running kernel.
+func LoadVmlinux() (Types, error) {
+	blob, err := os.ReadFile(VmlinuxPath)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(blob)
+}
+
+// Kfuncs returns the functions of `ts` tagged with KfuncTag and the ones
+// named `names`, for the kernels without the tags. The functions whose
+// arguments can't be passed in registers are left out.
+func (ts Types) Kfuncs(names []string) []*Kfunc {
+	ids := []TypeID{}
+	for id, t := range ts {
+		if t.Kind == KindDeclTag && t.Name == KfuncTag {
+			ids = append(ids, TypeID(t.SizeOrType))
+		} else if t.Kind == KindFunc && slices.Contains(names, t.Name) {
+			ids = append(ids, TypeID(id))
+		}
+	}
+
+	res := []*Kfunc{}
+	seen := make(map[TypeID]bool)
+	for _, id := range ids {
+		f := ts.Type(id)
+		if seen[id] || f == nil || f.Kind != KindFunc {
+			continue
+		}
+		seen[id] = true
+		proto := ts.Type(TypeID(f.SizeOrType))
+		if proto == nil || proto.Kind != KindFuncProto {
+			continue
+		}
+		if args, ok := ts.kfuncArgs(proto.Params); ok {
+			res = append(res, &Kfunc{Name: f.Name, ID: id, Args: args})
+		}
+	}
+	return res
+}
+
+// kfuncArgs returns what the verifier expects for each of `params`, false if
+// one of them can't be passed in a register, e.g. a struct passed by value.
+func (ts Types) kfuncArgs(params []Param) ([]KfuncArg, bool) {
+	if len(params) > maxKfuncArgs {
+		return nil, false
+	}
+	args := []KfuncArg{}
+	for i, p := range params {
+		t := ts.Resolve(p.Type)
+		switch {
+		case t == nil:
+			return nil, false
+		case strings.HasSuffix(p.Name, "__ign"):
+			args = append(args, KfuncArgIgnored)
+		case strings.HasSuffix(p.Name, "__k") || strings.HasSuffix(p.Name, "__szk"):
+			args = append(args, KfuncArgConst)
+		case strings.HasSuffix(p.Name, "__sz"):
+			args = append(args, KfuncArgSize)
+		case t.Kind == KindPtr:
+			if i+1 < len(params) && (strings.HasSuffix(params[i+1].Name, "__sz") || strings.HasSuffix(params[i+1].Name, "__szk")) {
+				args = append(args, KfuncArgMem)
+			} else {
+				args = append(args, KfuncArgPtr)
+			}
+		case t.Kind == KindInt || t.Kind == KindEnum || t.Kind == KindEnum64:
+			args = append(args, KfuncArgScalar)
+		default:
+			// Void, which ends the variadic functions, and the structs
+			// passed by value.
+			return nil, false
+		}
+	}
+	return args, true
+}
@@ -0,0 +1,68 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btf
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKfuncs(t *testing.T) {
+	b := NewBuilder()
+	u32 := b.Int("unsigned int", 4, 0)
+	ptr := b.Pointer(Void)
+	value := b.Struct("value", 8)
+
+	// Tagged.
+	memProto := b.FuncProto(u32, Param{Name: "p", Type: ptr}, Param{Name: "p__sz", Type: u32}, Param{Name: "flags", Type: u32})
+	mem := b.Func("bpf_mem", memProto, LinkageGlobal)
+	b.DeclTag(KfuncTag, mem, -1)
+	castProto := b.FuncProto(ptr, Param{Name: "obj__ign", Type: ptr}, Param{Name: "btf_id__k", Type: u32})
+	cast := b.Func("bpf_cast", castProto, LinkageGlobal)
+	b.DeclTag(KfuncTag, cast, -1)
+	// Named.
+	lockProto := b.FuncProto(Void)
+	lock := b.Func("bpf_lock", lockProto, LinkageGlobal)
+	// Neither tagged nor named.
+	b.Func("bpf_other", lockProto, LinkageGlobal)
+	// A struct passed by value.
+	byValueProto := b.FuncProto(Void, Param{Name: "v", Type: value})
+	byValue := b.Func("bpf_by_value", byValueProto, LinkageGlobal)
+	b.DeclTag(KfuncTag, byValue, -1)
+	// Too many arguments.
+	params := []Param{}
+	for i := 0; i < maxKfuncArgs+1; i++ {
+		params = append(params, Param{Name: "a", Type: u32})
+	}
+	wide := b.Func("bpf_wide", b.FuncProto(Void, params...), LinkageGlobal)
+	b.DeclTag(KfuncTag, wide, -1)
+
+	types, err := Decode(b.Encode())
+	if err != nil {
+		t.Fatalf("Decode() failed: %v", err)
+	}
+	got := types.Kfuncs([]string{"bpf_lock", "bpf_missing"})
+	want := []*Kfunc{
+		{Name: "bpf_mem", ID: mem, Args: []KfuncArg{KfuncArgMem, KfuncArgSize, KfuncArgScalar}},
+		{Name: "bpf_cast", ID: cast, Args: []KfuncArg{KfuncArgIgnored, KfuncArgConst}},
+		{Name: "bpf_lock", ID: lock, Args: []KfuncArg{}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		for _, k := range got {
+			t.Logf("got %+v", k)
+		}
+		t.Errorf("Kfuncs() = %d kfuncs, want %d", len(got), len(want))
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btf
+
+const (
+	// SpinLockValueSize is the size of the values of the SpinLockMap maps:
+	// a struct bpf_spin_lock at SpinLockOffset followed by a 32 bit
+	// counter at SpinLockDataOffset.
+	SpinLockValueSize  = 8
+	SpinLockOffset     = 0
+	SpinLockDataOffset = 4
+
+	// TimerValueSize is the size of the values of the TimerMap maps: a
+	// struct bpf_timer at TimerOffset followed by a 32 bit counter at
+	// TimerDataOffset.
+	TimerValueSize  = 24
+	TimerOffset     = 0
+	TimerDataOffset = 16
+)
+
+// Map is the BTF a map is created with, it describes its keys and values.
+type Map struct {
+	BTF       []byte
+	KeyType   TypeID
+	ValueType TypeID
+}
+
+// SpinLockMap returns the BTF of an array map whose values are
+//
+//	struct value {
+//		struct bpf_spin_lock lock;
+//		unsigned int data;
+//	};
+//
+// which is what programs need to call bpf_spin_lock on map values.
+func SpinLockMap() *Map {
+	b := NewBuilder()
+	u32 := b.Int("unsigned int", 4, 0)
+	lock := b.Struct("bpf_spin_lock", 4, Member{Name: "val", Type: u32})
+	value := b.Struct("value", SpinLockValueSize,
+		Member{Name: "lock", Type: lock, Offset: 8 * SpinLockOffset},
+		Member{Name: "data", Type: u32, Offset: 8 * SpinLockDataOffset})
+	return &Map{BTF: b.Encode(), KeyType: u32, ValueType: value}
+}
+
+// TimerMap returns the BTF of an array map whose values are
+//
+//	struct value {
+//		struct bpf_timer timer;
+//		unsigned int data;
+//	};
+//
+// which is what programs need to call the bpf_timer_* helpers on map values.
+// The verifier only looks at the name and the size of struct bpf_timer.
+func TimerMap() *Map {
+	b := NewBuilder()
+	u32 := b.Int("unsigned int", 4, 0)
+	u64 := b.Int("unsigned long long", 8, 0)
+	opaque := b.Array(u64, u32, 2)
+	timer := b.Struct("bpf_timer", 16, Member{Name: "__opaque", Type: opaque})
+	value := b.Struct("value", TimerValueSize,
+		Member{Name: "timer", Type: timer, Offset: 8 * TimerOffset},
+		Member{Name: "data", Type: u32, Offset: 8 * TimerDataOffset})
+	return &Map{BTF: b.Encode(), KeyType: u32, ValueType: value}
+}
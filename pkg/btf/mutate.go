@@ -0,0 +1,181 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btf
+
+import (
+	"encoding/binary"
+
+	"buzzer/pkg/rand"
+)
+
+// Highest BTF_KIND_* the mutations use, a few past the last one the kernel
+// knows.
+const maxMutatedKind = 24
+
+// mutation is a change Mutate can make to a program's BTF.
+type mutation struct {
+	name  string
+	apply func(p *Program)
+}
+
+var mutations = []mutation{
+	{"flip_bit", flipBit},
+	{"header_field", mutateHeaderField},
+	{"type_word", mutateTypeWord},
+	{"string_byte", mutateStringByte},
+	{"resize", resize},
+	{"func_info", mutateFuncInfo},
+	{"line_info", mutateLineInfo},
+}
+
+// Mutate applies between 1 and `max` random mutations to the BTF blob and
+// the func and line info of `p` and returns their names, in order.
+func Mutate(p *Program, max int) []string {
+	var names []string
+	for i := rand.SharedRNG.RandRange(1, uint64(max)); i > 0; i-- {
+		m := mutations[rand.SharedRNG.RandRange(0, uint64(len(mutations)-1))]
+		m.apply(p)
+		names = append(names, m.name)
+	}
+	return names
+}
+
+// interestingValue returns a value that is likely to hit an edge case of the
+// checks of a field that currently holds `current`.
+func interestingValue(current uint32, blobLen int) uint32 {
+	values := []uint32{0, 1, 0xffff, 0x7fffffff, 0x80000000, 0xffffffff, current + 1, current - 1, uint32(blobLen), uint32(rand.SharedRNG.RandInt())}
+	return values[rand.SharedRNG.RandRange(0, uint64(len(values)-1))]
+}
+
+// word returns the 32 bit word at `offset` of the blob.
+func word(blob []byte, offset int) uint32 {
+	return binary.NativeEndian.Uint32(blob[offset:])
+}
+
+func setWord(blob []byte, offset int, value uint32) {
+	binary.NativeEndian.PutUint32(blob[offset:], value)
+}
+
+// randomByte returns the index of a random byte of the blob in [from, to),
+// or -1 if the range is empty.
+func randomByte(blob []byte, from, to int) int {
+	to = min(to, len(blob))
+	if from >= to {
+		return -1
+	}
+	return from + int(rand.SharedRNG.RandRange(0, uint64(to-from-1)))
+}
+
+func flipBit(p *Program) {
+	if i := randomByte(p.BTF, 0, len(p.BTF)); i >= 0 {
+		p.BTF[i] ^= 1 << rand.SharedRNG.RandRange(0, 7)
+	}
+}
+
+// mutateHeaderField changes the magic, version and flags or one of the
+// lengths and offsets of the header.
+func mutateHeaderField(p *Program) {
+	if len(p.BTF) < HeaderSize {
+		return
+	}
+	offset := 4 * int(rand.SharedRNG.RandRange(0, HeaderSize/4-1))
+	setWord(p.BTF, offset, interestingValue(word(p.BTF, offset), len(p.BTF)))
+}
+
+// mutateTypeWord changes a word of the type section, half of the time to the
+// info field of a random kind.
+func mutateTypeWord(p *Program) {
+	if len(p.BTF) < HeaderSize+4 {
+		return
+	}
+	typeLen := int(min(word(p.BTF, 12), uint32(len(p.BTF)-HeaderSize)))
+	if typeLen < 4 {
+		return
+	}
+	offset := HeaderSize + 4*int(rand.SharedRNG.RandRange(0, uint64(typeLen/4-1)))
+	value := interestingValue(word(p.BTF, offset), len(p.BTF))
+	if rand.SharedRNG.OneOf(2) {
+		kind := Kind(rand.SharedRNG.RandRange(0, maxMutatedKind))
+		value = typeInfo(kind, uint32(rand.SharedRNG.RandRange(0, 4)), rand.SharedRNG.OneOf(4))
+	}
+	setWord(p.BTF, offset, value)
+}
+
+// mutateStringByte overwrites a byte of the string section, which can drop
+// a terminator or make a name an invalid identifier.
+func mutateStringByte(p *Program) {
+	if len(p.BTF) < HeaderSize {
+		return
+	}
+	strOff := HeaderSize + int(word(p.BTF, 16))
+	if i := randomByte(p.BTF, strOff, len(p.BTF)); i >= 0 {
+		p.BTF[i] = []byte{0, 'A', '.', '-', 0xff}[rand.SharedRNG.RandRange(0, 4)]
+	}
+}
+
+// resize truncates the blob or appends random bytes to it, without updating
+// the header.
+func resize(p *Program) {
+	if rand.SharedRNG.OneOf(2) && len(p.BTF) > 0 {
+		p.BTF = p.BTF[:rand.SharedRNG.RandRange(0, uint64(len(p.BTF)-1))]
+		return
+	}
+	for i := rand.SharedRNG.RandRange(1, 16); i > 0; i-- {
+		p.BTF = append(p.BTF, byte(rand.SharedRNG.RandInt()))
+	}
+}
+
+// mutateFuncInfo changes a field of a func info record, or drops or
+// duplicates one.
+func mutateFuncInfo(p *Program) {
+	if len(p.FuncInfo) == 0 {
+		return
+	}
+	i := int(rand.SharedRNG.RandRange(0, uint64(len(p.FuncInfo)-1)))
+	switch rand.SharedRNG.RandRange(0, 3) {
+	case 0:
+		p.FuncInfo[i].InsnOff = interestingValue(p.FuncInfo[i].InsnOff, len(p.BTF))
+	case 1:
+		p.FuncInfo[i].TypeID = TypeID(interestingValue(uint32(p.FuncInfo[i].TypeID), len(p.BTF)))
+	case 2:
+		p.FuncInfo = append(p.FuncInfo[:i], p.FuncInfo[i+1:]...)
+	default:
+		p.FuncInfo = append(p.FuncInfo[:i+1], p.FuncInfo[i:]...)
+	}
+}
+
+// mutateLineInfo changes a field of a line info record, or drops or
+// duplicates one.
+func mutateLineInfo(p *Program) {
+	if len(p.LineInfo) == 0 {
+		return
+	}
+	i := int(rand.SharedRNG.RandRange(0, uint64(len(p.LineInfo)-1)))
+	li := &p.LineInfo[i]
+	switch rand.SharedRNG.RandRange(0, 5) {
+	case 0:
+		li.InsnOff = interestingValue(li.InsnOff, len(p.BTF))
+	case 1:
+		li.FileNameOff = interestingValue(li.FileNameOff, len(p.BTF))
+	case 2:
+		li.LineOff = interestingValue(li.LineOff, len(p.BTF))
+	case 3:
+		li.LineCol = interestingValue(li.LineCol, len(p.BTF))
+	case 4:
+		p.LineInfo = append(p.LineInfo[:i], p.LineInfo[i+1:]...)
+	default:
+		p.LineInfo = append(p.LineInfo[:i+1], p.LineInfo[i:]...)
+	}
+}
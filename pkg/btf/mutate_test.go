@@ -0,0 +1,54 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestMutate(t *testing.T) {
+	prog := &pb.Program{Instructions: []*pb.Instruction{Mov64(R0, 0), Exit()}}
+	unchanged := 0
+	for i := 0; i < 1000; i++ {
+		original, err := ForProgram(prog)
+		if err != nil {
+			t.Fatalf("ForProgram() = %v, want nil error", err)
+		}
+		mutated, _ := ForProgram(prog)
+		names := Mutate(mutated, 4)
+		if len(names) < 1 || len(names) > 4 {
+			t.Fatalf("Mutate() applied %d mutations, want between 1 and 4", len(names))
+		}
+		if bytes.Equal(original.BTF, mutated.BTF) && reflect.DeepEqual(original.FuncInfo, mutated.FuncInfo) && reflect.DeepEqual(original.LineInfo, mutated.LineInfo) {
+			unchanged++
+		}
+	}
+	// Some mutations can be no-ops, e.g. replacing a string terminator with
+	// another one, but only rarely.
+	if unchanged > 100 {
+		t.Errorf("%d of 1000 mutated programs were unchanged", unchanged)
+	}
+
+	// Mutations must cope with blobs that were already truncated.
+	empty := &Program{}
+	for i := 0; i < 100; i++ {
+		Mutate(empty, 4)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"buzzer/pkg/ebpf/ebpf"
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+const (
+	// FuncInfoSize and LineInfoSize are the sizes of struct bpf_func_info
+	// and struct bpf_line_info.
+	FuncInfoSize = 8
+	LineInfoSize = 16
+
+	// SourceFile is the file name of the line info, its lines are the
+	// disassembly of the instructions.
+	SourceFile = "buzzer.c"
+)
+
+// FuncInfo is a struct bpf_func_info, it gives the BTF_KIND_FUNC type of the
+// function starting at the instruction InsnOff.
+type FuncInfo struct {
+	InsnOff uint32
+	TypeID  TypeID
+}
+
+// LineInfo is a struct bpf_line_info, it maps the instruction InsnOff to a
+// line of source. The offsets are in the string section of the BTF blob.
+type LineInfo struct {
+	InsnOff     uint32
+	FileNameOff uint32
+	LineOff     uint32
+	LineCol     uint32
+}
+
+// Program is what a program is loaded with to describe it with BTF.
+type Program struct {
+	BTF      []byte
+	FuncInfo []FuncInfo
+	LineInfo []LineInfo
+}
+
+// EncodeFuncInfo returns the records of FuncInfo as the kernel expects them.
+func (p *Program) EncodeFuncInfo() []byte {
+	var res []byte
+	for _, fi := range p.FuncInfo {
+		res = binary.NativeEndian.AppendUint32(res, fi.InsnOff)
+		res = binary.NativeEndian.AppendUint32(res, uint32(fi.TypeID))
+	}
+	return res
+}
+
+// EncodeLineInfo returns the records of LineInfo as the kernel expects them.
+func (p *Program) EncodeLineInfo() []byte {
+	var res []byte
+	for _, li := range p.LineInfo {
+		for _, field := range []uint32{li.InsnOff, li.FileNameOff, li.LineOff, li.LineCol} {
+			res = binary.NativeEndian.AppendUint32(res, field)
+		}
+	}
+	return res
+}
+
+// DecodeFuncInfo returns the records of `data`, the output of
+// EncodeFuncInfo. A trailing partial record is ignored.
+func DecodeFuncInfo(data []byte) []FuncInfo {
+	var res []FuncInfo
+	for ; len(data) >= FuncInfoSize; data = data[FuncInfoSize:] {
+		res = append(res, FuncInfo{
+			InsnOff: binary.NativeEndian.Uint32(data),
+			TypeID:  TypeID(binary.NativeEndian.Uint32(data[4:])),
+		})
+	}
+	return res
+}
+
+// DecodeLineInfo returns the records of `data`, the output of
+// EncodeLineInfo. A trailing partial record is ignored.
+func DecodeLineInfo(data []byte) []LineInfo {
+	var res []LineInfo
+	for ; len(data) >= LineInfoSize; data = data[LineInfoSize:] {
+		res = append(res, LineInfo{
+			InsnOff:     binary.NativeEndian.Uint32(data),
+			FileNameOff: binary.NativeEndian.Uint32(data[4:]),
+			LineOff:     binary.NativeEndian.Uint32(data[8:]),
+			LineCol:     binary.NativeEndian.Uint32(data[12:]),
+		})
+	}
+	return res
+}
+
+// lineCol returns the line_col field of struct bpf_line_info.
+func lineCol(line, col uint32) uint32 {
+	return line<<10 | col&0x3ff
+}
+
+// subprogStarts returns the instruction offsets, counted in 64 bit slots,
+// where the functions of `prog` start: 0 and the targets of the calls to
+// other functions of the program and of the loads of callbacks, in order.
+func subprogStarts(prog *pb.Program) []uint32 {
+	starts := map[uint32]bool{0: true}
+	slot := 0
+	for _, insn := range prog.GetInstructions() {
+		if ebpf.Mnemonic(insn) == "call" && insn.SrcReg == ebpf.PseudoCall || ebpf.Mnemonic(insn) == "lddw" && insn.SrcReg == ebpf.PseudoFunc {
+			starts[uint32(slot+1+int(insn.Immediate))] = true
+		}
+		slot += ebpf.InstructionWidth(insn)
+	}
+	var res []uint32
+	for start := range starts {
+		res = append(res, start)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// ForProgram returns minimal but valid BTF for `prog`: every function is an
+// `int (void *ctx)` and every instruction has a line whose text is its
+// disassembly, so the verifier log shows it.
+func ForProgram(prog *pb.Program) (*Program, error) {
+	b := NewBuilder()
+	intType := b.Int("int", 4, IntSigned)
+	proto := b.FuncProto(intType, Param{Name: "ctx", Type: b.Pointer(Void)})
+
+	res := &Program{}
+	for i, start := range subprogStarts(prog) {
+		name := "main"
+		if i > 0 {
+			name = fmt.Sprintf("subprog_%d", start)
+		}
+		res.FuncInfo = append(res.FuncInfo, FuncInfo{InsnOff: start, TypeID: b.Func(name, proto, LinkageStatic)})
+	}
+
+	file := b.String(SourceFile)
+	slot := 0
+	for i, insn := range prog.GetInstructions() {
+		line, err := ebpf.DisassembleInstruction(insn)
+		if err != nil {
+			return nil, fmt.Errorf("instruction %d: %w", i, err)
+		}
+		res.LineInfo = append(res.LineInfo, LineInfo{
+			InsnOff:     uint32(slot),
+			FileNameOff: file,
+			LineOff:     b.String(line),
+			LineCol:     lineCol(uint32(i+1), 1),
+		})
+		slot += ebpf.InstructionWidth(insn)
+	}
+	res.BTF = b.Encode()
+	return res, nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package btf
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// stringAt returns the string at `offset` of the string section of `blob`.
+func stringAt(blob []byte, offset uint32) string {
+	strOff := HeaderSize + binary.NativeEndian.Uint32(blob[16:])
+	s := string(blob[strOff+offset:])
+	return s[:strings.IndexByte(s, 0)]
+}
+
+func TestForProgram(t *testing.T) {
+	// main: r0 = map fd (2 slots), call subprog, exit. subprog: r0 = 0, exit.
+	prog := &pb.Program{Instructions: []*pb.Instruction{
+		LdMapByFd(R0, 3),
+		CallLocal(1),
+		Exit(),
+		Mov64(R0, 0),
+		Exit(),
+	}}
+	res, err := ForProgram(prog)
+	if err != nil {
+		t.Fatalf("ForProgram() = %v, want nil error", err)
+	}
+
+	var funcStarts []uint32
+	for _, fi := range res.FuncInfo {
+		funcStarts = append(funcStarts, fi.InsnOff)
+	}
+	if want := []uint32{0, 4}; !reflect.DeepEqual(funcStarts, want) {
+		t.Errorf("func info offsets = %v, want %v", funcStarts, want)
+	}
+
+	var lineStarts []uint32
+	for _, li := range res.LineInfo {
+		lineStarts = append(lineStarts, li.InsnOff)
+		if got := stringAt(res.BTF, li.FileNameOff); got != SourceFile {
+			t.Errorf("file of line %d = %q, want %q", li.LineCol>>10, got, SourceFile)
+		}
+	}
+	// The second slot of the map load has no line.
+	if want := []uint32{0, 2, 3, 4, 5}; !reflect.DeepEqual(lineStarts, want) {
+		t.Errorf("line info offsets = %v, want %v", lineStarts, want)
+	}
+	if got := stringAt(res.BTF, res.LineInfo[4].LineOff); got != "exit" {
+		t.Errorf("text of the last line = %q, want %q", got, "exit")
+	}
+
+	if got := len(res.EncodeFuncInfo()); got != 2*FuncInfoSize {
+		t.Errorf("len(EncodeFuncInfo()) = %d, want %d", got, 2*FuncInfoSize)
+	}
+	if got := len(res.EncodeLineInfo()); got != 5*LineInfoSize {
+		t.Errorf("len(EncodeLineInfo()) = %d, want %d", got, 5*LineInfoSize)
+	}
+	if got := DecodeFuncInfo(res.EncodeFuncInfo()); !reflect.DeepEqual(got, res.FuncInfo) {
+		t.Errorf("DecodeFuncInfo() = %v, want %v", got, res.FuncInfo)
+	}
+	if got := DecodeLineInfo(res.EncodeLineInfo()); !reflect.DeepEqual(got, res.LineInfo) {
+		t.Errorf("DecodeLineInfo() = %v, want %v", got, res.LineInfo)
+	}
+}
+
+func TestForProgramCallbacks(t *testing.T) {
+	// main: r2 = callback (2 slots), call bpf_loop, exit. callback: r0 = 0,
+	// exit.
+	prog := &pb.Program{Instructions: []*pb.Instruction{
+		LdFunc(R2, 3),
+		Call(Loop),
+		Exit(),
+		Mov64(R0, 0),
+		Exit(),
+	}}
+	res, err := ForProgram(prog)
+	if err != nil {
+		t.Fatalf("ForProgram() = %v, want nil error", err)
+	}
+	var funcStarts []uint32
+	for _, fi := range res.FuncInfo {
+		funcStarts = append(funcStarts, fi.InsnOff)
+	}
+	if want := []uint32{0, 4}; !reflect.DeepEqual(funcStarts, want) {
+		t.Errorf("func info offsets = %v, want %v", funcStarts, want)
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+func newAluInstruction[T Src](op uint16, src T) Instruction {
+	source, k := sourceOperand(src)
+	return Instruction{Code: ClassAlu | op | source, K: k}
+}
+
+// Add computes A += src.
+func Add[T Src](src T) Instruction {
+	return newAluInstruction(AluAdd, src)
+}
+
+// Sub computes A -= src.
+func Sub[T Src](src T) Instruction {
+	return newAluInstruction(AluSub, src)
+}
+
+// Mul computes A *= src.
+func Mul[T Src](src T) Instruction {
+	return newAluInstruction(AluMul, src)
+}
+
+// Div computes A /= src, division by zero makes the program return 0.
+func Div[T Src](src T) Instruction {
+	return newAluInstruction(AluDiv, src)
+}
+
+// Mod computes A %= src, modulo zero makes the program return 0.
+func Mod[T Src](src T) Instruction {
+	return newAluInstruction(AluMod, src)
+}
+
+// And computes A &= src.
+func And[T Src](src T) Instruction {
+	return newAluInstruction(AluAnd, src)
+}
+
+// Or computes A |= src.
+func Or[T Src](src T) Instruction {
+	return newAluInstruction(AluOr, src)
+}
+
+// Xor computes A ^= src.
+func Xor[T Src](src T) Instruction {
+	return newAluInstruction(AluXor, src)
+}
+
+// Lsh computes A <<= src.
+func Lsh[T Src](src T) Instruction {
+	return newAluInstruction(AluLsh, src)
+}
+
+// Rsh computes A >>= src.
+func Rsh[T Src](src T) Instruction {
+	return newAluInstruction(AluRsh, src)
+}
+
+// Neg computes A = -A.
+func Neg() Instruction {
+	return Instruction{Code: ClassAlu | AluNeg}
+}
+
+// AluOperations are the operation codes of every ALU instruction.
+var AluOperations = []uint16{
+	AluAdd, AluSub, AluMul, AluDiv, AluOr, AluAnd, AluLsh, AluRsh, AluNeg, AluMod, AluXor,
+}
+
+// AluInstruction returns the ALU instruction for `op` and `src`, the
+// source is ignored for AluNeg.
+func AluInstruction[T Src](op uint16, src T) Instruction {
+	if op == AluNeg {
+		return Neg()
+	}
+	return newAluInstruction(op, src)
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"testing"
+)
+
+func TestAluInstructionEncoding(t *testing.T) {
+	tests := []struct {
+		testName string
+		insn     Instruction
+		want     uint64
+	}{
+		// Expected values follow BPF_STMT(BPF_ALU | op | src, k).
+		{testName: "Add K", insn: Add(5), want: 0x0000000500000004},
+		{testName: "Add X", insn: Add(X), want: 0x000000000000000c},
+		{testName: "Sub K", insn: Sub(uint32(0xffffffff)), want: 0xffffffff00000014},
+		{testName: "Sub X", insn: Sub(X), want: 0x000000000000001c},
+		{testName: "Mul K", insn: Mul(3), want: 0x0000000300000024},
+		{testName: "Mul X", insn: Mul(X), want: 0x000000000000002c},
+		{testName: "Div K", insn: Div(7), want: 0x0000000700000034},
+		{testName: "Div X", insn: Div(X), want: 0x000000000000003c},
+		{testName: "Or K", insn: Or(1), want: 0x0000000100000044},
+		{testName: "Or X", insn: Or(X), want: 0x000000000000004c},
+		{testName: "And K", insn: And(0xff), want: 0x000000ff00000054},
+		{testName: "And X", insn: And(X), want: 0x000000000000005c},
+		{testName: "Lsh K", insn: Lsh(31), want: 0x0000001f00000064},
+		{testName: "Lsh X", insn: Lsh(X), want: 0x000000000000006c},
+		{testName: "Rsh K", insn: Rsh(1), want: 0x0000000100000074},
+		{testName: "Rsh X", insn: Rsh(X), want: 0x000000000000007c},
+		{testName: "Neg", insn: Neg(), want: 0x0000000000000084},
+		{testName: "Mod K", insn: Mod(int32(-1)), want: 0xffffffff00000094},
+		{testName: "Mod X", insn: Mod(X), want: 0x000000000000009c},
+		{testName: "Xor K", insn: Xor(2), want: 0x00000002000000a4},
+		{testName: "Xor X", insn: Xor(X), want: 0x00000000000000ac},
+		{testName: "Generic Neg ignores the source", insn: AluInstruction(AluNeg, 9), want: 0x0000000000000084},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := tc.insn.Encode(); got != tc.want {
+				t.Errorf("Encode() = %#016x, want %#016x", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestMiscInstructionEncoding(t *testing.T) {
+	tests := []struct {
+		testName string
+		insn     Instruction
+		want     uint64
+	}{
+		{testName: "Load A", insn: LdImm(42), want: 0x0000002a00000000},
+		{testName: "Load X", insn: LdxImm(42), want: 0x0000002a00000001},
+		{testName: "Tax", insn: Tax(), want: 0x0000000000000007},
+		{testName: "Txa", insn: Txa(), want: 0x0000000000000087},
+		{testName: "Return K", insn: Ret(0xffff), want: 0x0000ffff00000006},
+		{testName: "Return A", insn: Ret(A), want: 0x0000000000000016},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := tc.insn.Encode(); got != tc.want {
+				t.Errorf("Encode() = %#016x, want %#016x", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"buzzer/pkg/logging/logging"
+)
+
+var (
+	EmptyProgram   = errors.New("cBPF programs need at least one instruction")
+	ProgramTooLong = fmt.Errorf("cBPF programs cannot have more than %d instructions", MaxInstructions)
+	UnknownPocKind = errors.New("unknown PoC kind")
+)
+
+// PocKind selects how the generated PoC uses the program.
+type PocKind int
+
+const (
+	// SocketFilterPoc attaches the program to a socket with
+	// SO_ATTACH_FILTER and sends a packet through it.
+	SocketFilterPoc PocKind = iota
+
+	// SeccompPoc installs the program as the seccomp filter of the process
+	// and then issues a system call.
+	SeccompPoc
+)
+
+const cPocHeader = `// Reproducer generated by buzzer.
+//
+// Build with: gcc -o poc poc.c
+#include <errno.h>
+#include <linux/filter.h>
+#include <linux/seccomp.h>
+#include <stdio.h>
+#include <string.h>
+#include <sys/mman.h>
+#include <sys/prctl.h>
+#include <sys/socket.h>
+#include <sys/syscall.h>
+#include <sys/wait.h>
+#include <unistd.h>
+
+int main(void) {
+`
+
+const cPocSocketFilter = `
+  int socks[2];
+  if (socketpair(AF_UNIX, SOCK_DGRAM, 0, socks) != 0) {
+    perror("socketpair");
+    return 1;
+  }
+  if (setsockopt(socks[0], SOL_SOCKET, SO_ATTACH_FILTER, &fprog,
+                 sizeof(fprog)) < 0) {
+    perror("SO_ATTACH_FILTER");
+    return 1;
+  }
+  const unsigned char input[] = {INPUT};
+  if (write(socks[1], input, sizeof(input)) != sizeof(input)) {
+    perror("write");
+    return 1;
+  }
+  char output[sizeof(input)];
+  ssize_t received = recv(socks[0], output, sizeof(output), MSG_DONTWAIT);
+  printf("filter kept %zd of %zu bytes\n", received, sizeof(input));
+  return 0;
+}
+`
+
+// The filter is installed in a child process. Every system call made after
+// that is filtered, so the child reports back through shared memory.
+const cPocSeccomp = `
+  volatile long *outcome = mmap(NULL, 2 * sizeof(long), PROT_READ | PROT_WRITE,
+                                MAP_SHARED | MAP_ANONYMOUS, -1, 0);
+  if (outcome == MAP_FAILED) {
+    perror("mmap");
+    return 1;
+  }
+  outcome[0] = 0;
+  pid_t pid = fork();
+  if (pid < 0) {
+    perror("fork");
+    return 1;
+  }
+  if (pid == 0) {
+    if (prctl(PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0) != 0) {
+      perror("PR_SET_NO_NEW_PRIVS");
+      _exit(1);
+    }
+    if (syscall(__NR_seccomp, SECCOMP_SET_MODE_FILTER, 0, &fprog) != 0) {
+      perror("SECCOMP_SET_MODE_FILTER");
+      _exit(1);
+    }
+    long ret = syscall(__NR_getppid);
+    outcome[1] = ret == -1 ? -errno : ret;
+    outcome[0] = 1;
+    _exit(0);
+  }
+  int status = 0;
+  waitpid(pid, &status, 0);
+  if (outcome[0]) {
+    printf("getppid returned %ld, parent pid is %d\n", outcome[1], getpid());
+  } else {
+    printf("getppid did not return\n");
+  }
+  if (WIFSIGNALED(status)) {
+    printf("child killed by signal %d\n", WTERMSIG(status));
+  }
+  return 0;
+}
+`
+
+// defaultSocketFilterInput is the packet sent by CPocSource.
+var defaultSocketFilterInput = []byte("buzzer\x00")
+
+// socketFilterTrigger returns the code that attaches the filter and then
+// sends `packet` through the socket.
+func socketFilterTrigger(packet []byte) string {
+	bytes := make([]string, 0, len(packet))
+	for _, b := range packet {
+		bytes = append(bytes, fmt.Sprintf("0x%02x", b))
+	}
+	return strings.Replace(cPocSocketFilter, "{INPUT}", "{"+strings.Join(bytes, ", ")+"}", 1)
+}
+
+// seccompTrigger returns the code that installs the filter and then issues
+// getppid with `args`, which the filter can inspect.
+func seccompTrigger(args [6]uint64) string {
+	var b strings.Builder
+	for _, arg := range args {
+		fmt.Fprintf(&b, ", 0x%xul", arg)
+	}
+	return strings.Replace(cPocSeccomp, "__NR_getppid)", "__NR_getppid"+b.String()+")", 1)
+}
+
+// cPocSource returns the declaration of `program` followed by `trigger`.
+func cPocSource(program []Instruction, trigger string) (string, error) {
+	if len(program) == 0 {
+		return "", EmptyProgram
+	}
+	if len(program) > MaxInstructions {
+		return "", ProgramTooLong
+	}
+
+	var b strings.Builder
+	b.WriteString(cPocHeader)
+	b.WriteString("  struct sock_filter filter[] = {\n")
+	for i, insn := range program {
+		fmt.Fprintf(&b, "      {.code = 0x%02x, .jt = %d, .jf = %d, .k = 0x%x}, /* %d */\n", insn.Code, insn.Jt, insn.Jf, insn.K, i)
+	}
+	b.WriteString("  };\n")
+	b.WriteString("  struct sock_fprog fprog = {\n")
+	b.WriteString("      .len = sizeof(filter) / sizeof(filter[0]),\n")
+	b.WriteString("      .filter = filter,\n")
+	b.WriteString("  };\n")
+	b.WriteString(trigger)
+	return b.String(), nil
+}
+
+// CPocSource returns the source of a standalone C program that loads
+// `program` either as a socket filter or as a seccomp filter, depending on
+// `kind`, and then triggers it.
+func CPocSource(program []Instruction, kind PocKind) (string, error) {
+	switch kind {
+	case SocketFilterPoc:
+		return cPocSource(program, socketFilterTrigger(defaultSocketFilterInput))
+	case SeccompPoc:
+		return cPocSource(program, seccompTrigger([6]uint64{}))
+	}
+	return "", UnknownPocKind
+}
+
+// SocketFilterCPocSource is like CPocSource with SocketFilterPoc, but the
+// packet sent through the socket is `packet`.
+func SocketFilterCPocSource(program []Instruction, packet []byte) (string, error) {
+	return cPocSource(program, socketFilterTrigger(packet))
+}
+
+// SeccompCPocSource is like CPocSource with SeccompPoc, but the arguments
+// of the system call issued after installing the filter are `args` instead
+// of zeros.
+func SeccompCPocSource(program []Instruction, args [6]uint64) (string, error) {
+	return cPocSource(program, seccompTrigger(args))
+}
+
+// writeCPoc writes `source` to a temporary file and returns its path.
+func writeCPoc(source string) (string, error) {
+	f, err := os.CreateTemp("", "cbpf-poc-*.c")
+	if err != nil {
+		return "", err
+	}
+
+	logging.Infof("Writing C PoC %q.\n", f.Name())
+	_, err = f.Write([]byte(source))
+	return f.Name(), errors.Join(err, f.Close())
+}
+
+// GenerateCPoc writes the output of CPocSource to a temporary file and
+// returns its path.
+func GenerateCPoc(program []Instruction, kind PocKind) (string, error) {
+	source, err := CPocSource(program, kind)
+	if err != nil {
+		return "", err
+	}
+	return writeCPoc(source)
+}
+
+// GenerateSocketFilterCPoc writes the output of SocketFilterCPocSource to a
+// temporary file and returns its path.
+func GenerateSocketFilterCPoc(program []Instruction, packet []byte) (string, error) {
+	source, err := SocketFilterCPocSource(program, packet)
+	if err != nil {
+		return "", err
+	}
+	return writeCPoc(source)
+}
+
+// GenerateSeccompCPoc writes the output of SeccompCPocSource to a temporary
+// file and returns its path.
+func GenerateSeccompCPoc(program []Instruction, args [6]uint64) (string, error) {
+	source, err := SeccompCPocSource(program, args)
+	if err != nil {
+		return "", err
+	}
+	return writeCPoc(source)
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCPocSource(t *testing.T) {
+	program := []Instruction{LdImm(1), Add(X), Ret(A)}
+	tests := []struct {
+		testName string
+		kind     PocKind
+		want     []string
+	}{
+		{
+			testName: "Socket filter",
+			kind:     SocketFilterPoc,
+			want:     []string{"SO_ATTACH_FILTER", "recv("},
+		},
+		{
+			testName: "Seccomp",
+			kind:     SeccompPoc,
+			want:     []string{"PR_SET_NO_NEW_PRIVS", "SECCOMP_SET_MODE_FILTER"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			source, err := CPocSource(program, tc.kind)
+			if err != nil {
+				t.Fatalf("CPocSource() = %v, want nil error", err)
+			}
+			want := append([]string{
+				"{.code = 0x00, .jt = 0, .jf = 0, .k = 0x1}, /* 0 */",
+				"{.code = 0x0c, .jt = 0, .jf = 0, .k = 0x0}, /* 1 */",
+				"{.code = 0x16, .jt = 0, .jf = 0, .k = 0x0}, /* 2 */",
+			}, tc.want...)
+			for _, w := range want {
+				if !strings.Contains(source, w) {
+					t.Errorf("CPocSource() output does not contain %q", w)
+				}
+			}
+		})
+	}
+}
+
+func TestSocketFilterCPocSource(t *testing.T) {
+	source, err := SocketFilterCPocSource([]Instruction{Ret(A)}, []byte{0x45, 0x00, 0xff})
+	if err != nil {
+		t.Fatalf("SocketFilterCPocSource() = %v, want nil error", err)
+	}
+	want := "const unsigned char input[] = {0x45, 0x00, 0xff};"
+	if !strings.Contains(source, want) {
+		t.Errorf("SocketFilterCPocSource() output does not contain %q", want)
+	}
+}
+
+func TestSeccompCPocSource(t *testing.T) {
+	source, err := SeccompCPocSource([]Instruction{Ret(SeccompRetAllow)}, [6]uint64{1, 2, 3, 4, 5, 0xffffffffffffffff})
+	if err != nil {
+		t.Fatalf("SeccompCPocSource() = %v, want nil error", err)
+	}
+	want := "syscall(__NR_getppid, 0x1ul, 0x2ul, 0x3ul, 0x4ul, 0x5ul, 0xfffffffffffffffful)"
+	if !strings.Contains(source, want) {
+		t.Errorf("SeccompCPocSource() output does not contain %q", want)
+	}
+}
+
+func TestCPocSourceErrors(t *testing.T) {
+	if _, err := CPocSource(nil, SocketFilterPoc); err != EmptyProgram {
+		t.Errorf("CPocSource(nil) = %v, want %v", err, EmptyProgram)
+	}
+	if _, err := CPocSource(make([]Instruction, MaxInstructions+1), SocketFilterPoc); err != ProgramTooLong {
+		t.Errorf("CPocSource() of a long program = %v, want %v", err, ProgramTooLong)
+	}
+	if _, err := CPocSource([]Instruction{Ret(0)}, PocKind(-1)); err != UnknownPocKind {
+		t.Errorf("CPocSource() with a bad kind = %v, want %v", err, UnknownPocKind)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package cbpf contains the builders and encoding of classic BPF (cBPF)
+// programs, the instruction set used by socket filters and seccomp.
+package cbpf
+
+// Instruction classes, the 3 least significant bits of the opcode.
+const (
+	ClassLd   = 0x00
+	ClassLdx  = 0x01
+	ClassSt   = 0x02
+	ClassStx  = 0x03
+	ClassAlu  = 0x04
+	ClassJmp  = 0x05
+	ClassRet  = 0x06
+	ClassMisc = 0x07
+)
+
+// Operand sizes of the load instructions.
+const (
+	SizeW = 0x00
+	SizeH = 0x08
+	SizeB = 0x10
+)
+
+// Addressing modes of the load instructions.
+const (
+	ModeImm = 0x00
+	ModeAbs = 0x20
+	ModeInd = 0x40
+	ModeMem = 0x60
+	ModeLen = 0x80
+	ModeMsh = 0xa0
+)
+
+// ALU operation codes.
+const (
+	AluAdd = 0x00
+	AluSub = 0x10
+	AluMul = 0x20
+	AluDiv = 0x30
+	AluOr  = 0x40
+	AluAnd = 0x50
+	AluLsh = 0x60
+	AluRsh = 0x70
+	AluNeg = 0x80
+	AluMod = 0x90
+	AluXor = 0xa0
+)
+
+// Jump operation codes. Offsets are unsigned, classic BPF can only jump
+// forward.
+const (
+	JmpJA   = 0x00
+	JmpJEQ  = 0x10
+	JmpJGT  = 0x20
+	JmpJGE  = 0x30
+	JmpJSET = 0x40
+)
+
+// Source operands of the ALU and jump instructions: the constant K or the
+// index register X.
+const (
+	SrcK = 0x00
+	SrcX = 0x08
+)
+
+// Return value sources, the constant K or the accumulator A.
+const (
+	RetK = 0x00
+	RetA = 0x10
+)
+
+// Operations of the misc class.
+const (
+	MiscTax = 0x00
+	MiscTxa = 0x80
+)
+
+// Reg is one of the two cBPF registers.
+type Reg int
+
+const (
+	// A is the accumulator, destination of every ALU operation.
+	A Reg = iota
+
+	// X is the index register, it can be used as the source of ALU
+	// operations instead of the constant K.
+	X
+)
+
+// MaxInstructions is BPF_MAXINSNS, the maximum length of a cBPF program.
+const MaxInstructions = 4096
+
+// ScratchSlots is BPF_MEMWORDS, the number of 32 bit words of scratch memory
+// available to St, Stx, LdMem and LdxMem.
+const ScratchSlots = 16
@@ -0,0 +1,180 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+// Instruction is a cBPF instruction, it mirrors struct sock_filter.
+type Instruction struct {
+	Code uint16
+
+	// Jt and Jf are the offsets of conditional jumps when the condition is
+	// true and false respectively.
+	Jt uint8
+	Jf uint8
+
+	K uint32
+}
+
+// Src is either the register X or a constant to use as K.
+type Src interface {
+	Reg | uint32 | int32 | int
+}
+
+// sourceOperand returns the source bit and the constant K for `src`.
+func sourceOperand[T Src](src T) (uint16, uint32) {
+	switch s := any(src).(type) {
+	case Reg:
+		return SrcX, 0
+	case uint32:
+		return SrcK, s
+	case int32:
+		return SrcK, uint32(s)
+	default:
+		return SrcK, uint32(any(src).(int))
+	}
+}
+
+// Encode returns the 64 bit representation of the instruction, as laid out
+// in memory by struct sock_filter on a little endian machine.
+func (i Instruction) Encode() uint64 {
+	return uint64(i.Code) | uint64(i.Jt)<<16 | uint64(i.Jf)<<24 | uint64(i.K)<<32
+}
+
+// Class returns the instruction class of the opcode.
+func (i Instruction) Class() uint16 {
+	return i.Code & 0x07
+}
+
+// Size returns the operand size of load instructions.
+func (i Instruction) Size() uint16 {
+	return i.Code & 0x18
+}
+
+// Mode returns the addressing mode of load instructions.
+func (i Instruction) Mode() uint16 {
+	return i.Code & 0xe0
+}
+
+// Operation returns the operation code of ALU and jump instructions.
+func (i Instruction) Operation() uint16 {
+	return i.Code & 0xf0
+}
+
+// Source returns the source operand bit of ALU and jump instructions.
+func (i Instruction) Source() uint16 {
+	return i.Code & 0x08
+}
+
+// DecodeInstruction is the inverse of Encode.
+func DecodeInstruction(encoded uint64) Instruction {
+	return Instruction{
+		Code: uint16(encoded),
+		Jt:   uint8(encoded >> 16),
+		Jf:   uint8(encoded >> 24),
+		K:    uint32(encoded >> 32),
+	}
+}
+
+// EncodeInstructions encodes a whole program.
+func EncodeInstructions(program []Instruction) []uint64 {
+	result := make([]uint64, 0, len(program))
+	for _, insn := range program {
+		result = append(result, insn.Encode())
+	}
+	return result
+}
+
+// DecodeInstructions decodes a whole program.
+func DecodeInstructions(encoded []uint64) []Instruction {
+	result := make([]Instruction, 0, len(encoded))
+	for _, e := range encoded {
+		result = append(result, DecodeInstruction(e))
+	}
+	return result
+}
+
+// LdImm loads the constant `k` into A.
+func LdImm(k uint32) Instruction {
+	return Instruction{Code: ClassLd | ModeImm, K: k}
+}
+
+// LdxImm loads the constant `k` into X.
+func LdxImm(k uint32) Instruction {
+	return Instruction{Code: ClassLdx | ModeImm, K: k}
+}
+
+// LdAbs loads `size` bytes at offset `k` of the input into A.
+func LdAbs(size uint16, k uint32) Instruction {
+	return Instruction{Code: ClassLd | size | ModeAbs, K: k}
+}
+
+// LdInd loads `size` bytes at offset X + `k` of the input into A.
+func LdInd(size uint16, k uint32) Instruction {
+	return Instruction{Code: ClassLd | size | ModeInd, K: k}
+}
+
+// LdLen loads the length of the input into A.
+func LdLen() Instruction {
+	return Instruction{Code: ClassLd | SizeW | ModeLen}
+}
+
+// LdxLen loads the length of the input into X.
+func LdxLen() Instruction {
+	return Instruction{Code: ClassLdx | SizeW | ModeLen}
+}
+
+// LdxMsh loads 4 * (P[k] & 0xf) into X, the length of the IPv4 header
+// starting at byte `k` of the input.
+func LdxMsh(k uint32) Instruction {
+	return Instruction{Code: ClassLdx | SizeB | ModeMsh, K: k}
+}
+
+// LdMem loads the scratch memory slot `k` into A.
+func LdMem(k uint32) Instruction {
+	return Instruction{Code: ClassLd | ModeMem, K: k}
+}
+
+// LdxMem loads the scratch memory slot `k` into X.
+func LdxMem(k uint32) Instruction {
+	return Instruction{Code: ClassLdx | ModeMem, K: k}
+}
+
+// St stores A into the scratch memory slot `k`.
+func St(k uint32) Instruction {
+	return Instruction{Code: ClassSt, K: k}
+}
+
+// Stx stores X into the scratch memory slot `k`.
+func Stx(k uint32) Instruction {
+	return Instruction{Code: ClassStx, K: k}
+}
+
+// Tax copies A into X.
+func Tax() Instruction {
+	return Instruction{Code: ClassMisc | MiscTax}
+}
+
+// Txa copies X into A.
+func Txa() Instruction {
+	return Instruction{Code: ClassMisc | MiscTxa}
+}
+
+// Ret ends the program returning either A or a constant.
+func Ret[T Reg | uint32 | int](src T) Instruction {
+	if _, ok := any(src).(Reg); ok {
+		return Instruction{Code: ClassRet | RetA}
+	}
+	_, k := sourceOperand(src)
+	return Instruction{Code: ClassRet | RetK, K: k}
+}
@@ -0,0 +1,247 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	NoReturn = errors.New("program ends without a return instruction")
+)
+
+// UnsupportedInstruction is returned by Run for the instructions it cannot
+// interpret.
+type UnsupportedInstruction struct {
+	Index       int
+	Instruction Instruction
+}
+
+func (e *UnsupportedInstruction) Error() string {
+	return fmt.Sprintf("unsupported instruction %d: code %#02x", e.Index, e.Instruction.Code)
+}
+
+// load reads `size` bytes at `offset` of `data`, `ok` is false if the read is
+// out of bounds. Negative offsets, as a 32 bit signed integer, must be
+// rejected before calling it.
+func load(data []byte, order binary.ByteOrder, size uint16, offset uint32) (value uint32, ok bool) {
+	end := uint64(offset)
+	switch size {
+	case SizeW:
+		end += 4
+	case SizeH:
+		end += 2
+	case SizeB:
+		end += 1
+	default:
+		return 0, false
+	}
+	if end > uint64(len(data)) {
+		return 0, false
+	}
+	switch size {
+	case SizeW:
+		return order.Uint32(data[offset:]), true
+	case SizeH:
+		return uint32(order.Uint16(data[offset:])), true
+	default:
+		return uint32(data[offset]), true
+	}
+}
+
+// alu applies the ALU operation `op` to `a` and `src`, `ok` is false on
+// division by zero.
+func alu(op uint16, a, src uint32) (result uint32, ok bool) {
+	switch op {
+	case AluAdd:
+		return a + src, true
+	case AluSub:
+		return a - src, true
+	case AluMul:
+		return a * src, true
+	case AluDiv:
+		if src == 0 {
+			return 0, false
+		}
+		return a / src, true
+	case AluMod:
+		if src == 0 {
+			return 0, false
+		}
+		return a % src, true
+	case AluOr:
+		return a | src, true
+	case AluAnd:
+		return a & src, true
+	case AluXor:
+		return a ^ src, true
+	// Like the kernel interpreter and JITs, only the 5 low bits of the
+	// shift amount are used.
+	case AluLsh:
+		return a << (src & 31), true
+	case AluRsh:
+		return a >> (src & 31), true
+	case AluNeg:
+		return -a, true
+	}
+	return 0, false
+}
+
+// compare evaluates the condition of the jump operation `op`, `ok` is false
+// for unknown operations.
+func compare(op uint16, a, src uint32) (taken bool, ok bool) {
+	switch op {
+	case JmpJEQ:
+		return a == src, true
+	case JmpJGT:
+		return a > src, true
+	case JmpJGE:
+		return a >= src, true
+	case JmpJSET:
+		return a&src != 0, true
+	}
+	return false, false
+}
+
+// Run interprets `program` the way the kernel does for an input `data`
+// whose multi byte words are in `order`: network order for socket filters,
+// native order for seccomp. It returns the value of the return instruction
+// the program reaches.
+//
+// Like in the kernel, out of bounds loads and divisions by zero make the
+// program return 0. Jumps that land outside of the program return a
+// JumpOutOfBounds error. Loads at negative offsets, which the kernel
+// resolves relative to the headers of the packet, are not supported.
+func Run(program []Instruction, data []byte, order binary.ByteOrder) (uint32, error) {
+	var a, x uint32
+	var mem [ScratchSlots]uint32
+	for i := 0; i < len(program); i++ {
+		insn := program[i]
+		unsupported := &UnsupportedInstruction{Index: i, Instruction: insn}
+		switch insn.Class() {
+		case ClassLd:
+			switch insn.Mode() {
+			case ModeImm:
+				a = insn.K
+			case ModeAbs, ModeInd:
+				offset := insn.K
+				if insn.Mode() == ModeInd {
+					offset += x
+				}
+				if int32(offset) < 0 {
+					return 0, unsupported
+				}
+				v, ok := load(data, order, insn.Size(), offset)
+				if !ok {
+					return 0, nil
+				}
+				a = v
+			case ModeMem:
+				if insn.K >= ScratchSlots {
+					return 0, unsupported
+				}
+				a = mem[insn.K]
+			case ModeLen:
+				a = uint32(len(data))
+			default:
+				return 0, unsupported
+			}
+		case ClassLdx:
+			switch insn.Mode() {
+			case ModeImm:
+				x = insn.K
+			case ModeMem:
+				if insn.K >= ScratchSlots {
+					return 0, unsupported
+				}
+				x = mem[insn.K]
+			case ModeLen:
+				x = uint32(len(data))
+			case ModeMsh:
+				if int32(insn.K) < 0 {
+					return 0, unsupported
+				}
+				v, ok := load(data, order, SizeB, insn.K)
+				if !ok {
+					return 0, nil
+				}
+				x = 4 * (v & 0xf)
+			default:
+				return 0, unsupported
+			}
+		case ClassSt, ClassStx:
+			if insn.K >= ScratchSlots {
+				return 0, unsupported
+			}
+			if insn.Class() == ClassSt {
+				mem[insn.K] = a
+			} else {
+				mem[insn.K] = x
+			}
+		case ClassAlu:
+			src := insn.K
+			if insn.Source() == SrcX {
+				src = x
+			}
+			v, ok := alu(insn.Operation(), a, src)
+			if !ok {
+				if insn.Operation() == AluDiv || insn.Operation() == AluMod {
+					return 0, nil
+				}
+				return 0, unsupported
+			}
+			a = v
+		case ClassJmp:
+			targets := JumpTargets(program, i)
+			target := targets[0]
+			if insn.IsConditionalJump() {
+				src := insn.K
+				if insn.Source() == SrcX {
+					src = x
+				}
+				taken, ok := compare(insn.Operation(), a, src)
+				if !ok {
+					return 0, unsupported
+				}
+				if !taken {
+					target = targets[1]
+				}
+			}
+			if target >= int64(len(program)) {
+				return 0, &JumpOutOfBounds{Index: i, Target: target}
+			}
+			i = int(target) - 1
+		case ClassRet:
+			if insn.Code&0x18 == RetA {
+				return a, nil
+			}
+			return insn.K, nil
+		case ClassMisc:
+			switch insn.Code & 0xf8 {
+			case MiscTax:
+				x = a
+			case MiscTxa:
+				a = x
+			default:
+				return 0, unsupported
+			}
+		default:
+			return 0, unsupported
+		}
+	}
+	return 0, NoReturn
+}
@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	data := []byte{0x01, 0x02, 0x03, 0x04, 0x05}
+	tests := []struct {
+		testName string
+		program  []Instruction
+		order    binary.ByteOrder
+		want     uint32
+	}{
+		{testName: "Return constant", program: []Instruction{Ret(7)}, want: 7},
+		{testName: "Add and subtract", program: []Instruction{LdImm(10), LdxImm(3), Add(X), Sub(1), Ret(A)}, want: 12},
+		{testName: "Wrap around", program: []Instruction{LdImm(0xffffffff), Add(2), Ret(A)}, want: 1},
+		{testName: "Negate", program: []Instruction{LdImm(1), Neg(), Ret(A)}, want: 0xffffffff},
+		{testName: "Shift amount is masked", program: []Instruction{LdImm(1), LdxImm(33), Lsh(X), Ret(A)}, want: 2},
+		{testName: "Division by X zero returns 0", program: []Instruction{LdImm(5), LdxImm(0), Div(X), Ret(9)}, want: 0},
+		{testName: "Modulo", program: []Instruction{LdImm(17), Mod(5), Ret(A)}, want: 2},
+		{testName: "Scratch memory", program: []Instruction{LdImm(4), St(3), LdImm(0), LdxMem(3), Txa(), Ret(A)}, want: 4},
+		{testName: "Tax", program: []Instruction{LdImm(6), Tax(), LdImm(1), Stx(0), LdMem(0), Ret(A)}, want: 6},
+		{testName: "Big endian word", program: []Instruction{LdAbs(SizeW, 0), Ret(A)}, order: binary.BigEndian, want: 0x01020304},
+		{testName: "Little endian half", program: []Instruction{LdAbs(SizeH, 1), Ret(A)}, order: binary.LittleEndian, want: 0x0302},
+		{testName: "Byte", program: []Instruction{LdAbs(SizeB, 4), Ret(A)}, want: 0x05},
+		{testName: "Out of bounds load returns 0", program: []Instruction{LdAbs(SizeW, 2), Ret(9)}, want: 0},
+		{testName: "Ja", program: []Instruction{Ja(1), Ret(1), Ret(2)}, want: 2},
+		{testName: "Jeq taken", program: []Instruction{LdImm(3), Jeq(3, 1, 0), Ret(1), Ret(2)}, want: 2},
+		{testName: "Jgt not taken", program: []Instruction{LdImm(3), Jgt(3, 1, 0), Ret(1), Ret(2)}, want: 1},
+		{testName: "Jge X", program: []Instruction{LdImm(3), LdxImm(3), Jge(X, 0, 1), Ret(1), Ret(2)}, want: 1},
+		{testName: "Jset", program: []Instruction{LdImm(6), Jset(1, 1, 2), Ret(1), Ret(2), Ret(3)}, want: 3},
+		{testName: "Length", program: []Instruction{LdLen(), Ret(A)}, want: 5},
+		{testName: "X length", program: []Instruction{LdxLen(), Txa(), Ret(A)}, want: 5},
+		{testName: "Indirect load", program: []Instruction{LdxImm(1), LdInd(SizeH, 2), Ret(A)}, want: 0x0405},
+		{testName: "Out of bounds indirect load returns 0", program: []Instruction{LdxImm(4), LdInd(SizeB, 1), Ret(9)}, want: 0},
+		{testName: "IPv4 header length", program: []Instruction{LdxMsh(4), Txa(), Ret(A)}, want: 20},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			order := tc.order
+			if order == nil {
+				order = binary.BigEndian
+			}
+			got, err := Run(tc.program, data, order)
+			if err != nil {
+				t.Fatalf("Run() = %v, want nil error", err)
+			}
+			if got != tc.want {
+				t.Errorf("Run() = %#x, want %#x", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	if _, err := Run([]Instruction{LdImm(1)}, nil, binary.BigEndian); err != NoReturn {
+		t.Errorf("Run() without return = %v, want %v", err, NoReturn)
+	}
+	var outOfBounds *JumpOutOfBounds
+	if _, err := Run([]Instruction{LdImm(0), Jeq(0, 0, 5), Ret(A)}, nil, binary.BigEndian); err != nil {
+		t.Errorf("Run() of a taken in bounds jump = %v, want nil error", err)
+	}
+	if _, err := Run([]Instruction{LdImm(1), Jeq(0, 0, 5), Ret(A)}, nil, binary.BigEndian); !errors.As(err, &outOfBounds) {
+		t.Errorf("Run() of an out of bounds jump = %v, want JumpOutOfBounds", err)
+	}
+	var unsupported *UnsupportedInstruction
+	if _, err := Run([]Instruction{LdxImm(0xffffffff), LdInd(SizeB, 0), Ret(A)}, nil, binary.BigEndian); !errors.As(err, &unsupported) || unsupported.Index != 1 {
+		t.Errorf("Run() with a negative offset = %v, want unsupported instruction 1", err)
+	}
+	if _, err := Run([]Instruction{LdImm(1), St(ScratchSlots), Ret(A)}, nil, binary.BigEndian); !errors.As(err, &unsupported) || unsupported.Index != 1 {
+		t.Errorf("Run() with a bad scratch slot = %v, want unsupported instruction 1", err)
+	}
+}
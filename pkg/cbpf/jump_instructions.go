@@ -0,0 +1,64 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+func newJmpInstruction[T Src](op uint16, src T, jt, jf uint8) Instruction {
+	source, k := sourceOperand(src)
+	return Instruction{Code: ClassJmp | op | source, Jt: jt, Jf: jf, K: k}
+}
+
+// Ja unconditionally skips the next `k` instructions.
+func Ja(k uint32) Instruction {
+	return Instruction{Code: ClassJmp | JmpJA, K: k}
+}
+
+// Jeq skips `jt` instructions if A == src, `jf` otherwise.
+func Jeq[T Src](src T, jt, jf uint8) Instruction {
+	return newJmpInstruction(JmpJEQ, src, jt, jf)
+}
+
+// Jgt skips `jt` instructions if A > src, `jf` otherwise.
+func Jgt[T Src](src T, jt, jf uint8) Instruction {
+	return newJmpInstruction(JmpJGT, src, jt, jf)
+}
+
+// Jge skips `jt` instructions if A >= src, `jf` otherwise.
+func Jge[T Src](src T, jt, jf uint8) Instruction {
+	return newJmpInstruction(JmpJGE, src, jt, jf)
+}
+
+// Jset skips `jt` instructions if A & src != 0, `jf` otherwise.
+func Jset[T Src](src T, jt, jf uint8) Instruction {
+	return newJmpInstruction(JmpJSET, src, jt, jf)
+}
+
+// ConditionalJumpOperations are the operation codes of every conditional
+// jump.
+var ConditionalJumpOperations = []uint16{JmpJEQ, JmpJGT, JmpJGE, JmpJSET}
+
+// JmpInstruction returns the conditional jump for `op` and `src`.
+func JmpInstruction[T Src](op uint16, src T, jt, jf uint8) Instruction {
+	return newJmpInstruction(op, src, jt, jf)
+}
+
+// IsJump returns true for both conditional and unconditional jumps.
+func (i Instruction) IsJump() bool {
+	return i.Class() == ClassJmp
+}
+
+// IsConditionalJump returns true for the jumps that use Jt and Jf.
+func (i Instruction) IsConditionalJump() bool {
+	return i.IsJump() && i.Operation() != JmpJA
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"testing"
+)
+
+func TestJmpInstructionEncoding(t *testing.T) {
+	tests := []struct {
+		testName string
+		insn     Instruction
+		want     uint64
+	}{
+		// Expected values follow BPF_JUMP(BPF_JMP | op | src, k, jt, jf).
+		{testName: "Ja", insn: Ja(3), want: 0x0000000300000005},
+		{testName: "Jeq K", insn: Jeq(5, 1, 2), want: 0x0000000502010015},
+		{testName: "Jeq X", insn: Jeq(X, 0, 7), want: 0x000000000700001d},
+		{testName: "Jgt K", insn: Jgt(uint32(0xffffffff), 255, 0), want: 0xffffffff00ff0025},
+		{testName: "Jgt X", insn: Jgt(X, 1, 1), want: 0x000000000101002d},
+		{testName: "Jge K", insn: Jge(9, 0, 0), want: 0x0000000900000035},
+		{testName: "Jge X", insn: Jge(X, 3, 0), want: 0x000000000003003d},
+		{testName: "Jset K", insn: Jset(0x80, 2, 0), want: 0x0000008000020045},
+		{testName: "Jset X", insn: Jset(X, 0, 4), want: 0x000000000400004d},
+		{testName: "Generic Jeq", insn: JmpInstruction(JmpJEQ, 5, 1, 2), want: 0x0000000502010015},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := tc.insn.Encode(); got != tc.want {
+				t.Errorf("Encode() = %#016x, want %#016x", got, tc.want)
+			}
+			if got := DecodeInstruction(tc.want); got != tc.insn {
+				t.Errorf("DecodeInstruction(%#016x) = %+v, want %+v", tc.want, got, tc.insn)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"encoding/binary"
+)
+
+// Actions a seccomp filter can return, the high 16 bits of the return value.
+const (
+	SeccompRetKillProcess = 0x80000000
+	SeccompRetKillThread  = 0x00000000
+	SeccompRetTrap        = 0x00030000
+	SeccompRetErrno       = 0x00050000
+	SeccompRetUserNotif   = 0x7fc00000
+	SeccompRetTrace       = 0x7ff00000
+	SeccompRetLog         = 0x7ffc0000
+	SeccompRetAllow       = 0x7fff0000
+
+	// SeccompRetActionFull masks the action out of a return value.
+	SeccompRetActionFull = 0xffff0000
+
+	// SeccompRetData masks the data passed along with the action, e.g. the
+	// errno of SeccompRetErrno.
+	SeccompRetData = 0x0000ffff
+)
+
+// SeccompActions are every action the kernel knows about.
+var SeccompActions = []uint32{
+	SeccompRetKillProcess,
+	SeccompRetKillThread,
+	SeccompRetTrap,
+	SeccompRetErrno,
+	SeccompRetUserNotif,
+	SeccompRetTrace,
+	SeccompRetLog,
+	SeccompRetAllow,
+}
+
+// SeccompAction returns the action the kernel takes when a filter returns
+// `ret`. Unknown actions are handled as SeccompRetKillProcess.
+func SeccompAction(ret uint32) uint32 {
+	action := ret & SeccompRetActionFull
+	for _, known := range SeccompActions {
+		if action == known {
+			return action
+		}
+	}
+	return SeccompRetKillProcess
+}
+
+// SeccompDataSize is the size of struct seccomp_data, the input of seccomp
+// filters. Loads must be 4 byte aligned words within it.
+const SeccompDataSize = 64
+
+// Offsets of the fields of struct seccomp_data.
+const (
+	SeccompDataNr                 = 0
+	SeccompDataArch               = 4
+	SeccompDataInstructionPointer = 8
+	SeccompDataArgs               = 16
+)
+
+// SeccompData mirrors struct seccomp_data, what the filter sees of a system
+// call.
+type SeccompData struct {
+	Nr                 int32
+	Arch               uint32
+	InstructionPointer uint64
+	Args               [6]uint64
+}
+
+// Bytes returns `sd` the way it is laid out in memory by the kernel, so it can
+// be used as the input of Run along with binary.NativeEndian.
+func (sd *SeccompData) Bytes() []byte {
+	data := make([]byte, 0, SeccompDataSize)
+	data = binary.NativeEndian.AppendUint32(data, uint32(sd.Nr))
+	data = binary.NativeEndian.AppendUint32(data, sd.Arch)
+	data = binary.NativeEndian.AppendUint64(data, sd.InstructionPointer)
+	for _, arg := range sd.Args {
+		data = binary.NativeEndian.AppendUint64(data, arg)
+	}
+	return data
+}
@@ -0,0 +1,76 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestSeccompAction(t *testing.T) {
+	tests := []struct {
+		testName string
+		ret      uint32
+		want     uint32
+	}{
+		{testName: "Allow", ret: SeccompRetAllow, want: SeccompRetAllow},
+		{testName: "Errno keeps the action only", ret: SeccompRetErrno | 13, want: SeccompRetErrno},
+		{testName: "Zero kills the thread", ret: 5, want: SeccompRetKillThread},
+		{testName: "Unknown action kills the process", ret: 0x12340000, want: SeccompRetKillProcess},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := SeccompAction(tc.ret); got != tc.want {
+				t.Errorf("SeccompAction(%#x) = %#x, want %#x", tc.ret, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSeccompDataLayout(t *testing.T) {
+	sd := &SeccompData{Nr: 39, Arch: 0xc000003e, InstructionPointer: 0x1000, Args: [6]uint64{1, 2, 3, 4, 5, 0xaabbccdd11223344}}
+	data := sd.Bytes()
+	if len(data) != SeccompDataSize {
+		t.Fatalf("len(Bytes()) = %d, want %d", len(data), SeccompDataSize)
+	}
+
+	// Filters read the low half of 64 bit arguments at the lower address
+	// on little endian machines and at the higher one on big endian ones.
+	lowArg5 := uint32(SeccompDataArgs + 5*8)
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		lowArg5 += 4
+	}
+	tests := []struct {
+		testName string
+		offset   uint32
+		want     uint32
+	}{
+		{testName: "Nr", offset: SeccompDataNr, want: 39},
+		{testName: "Arch", offset: SeccompDataArch, want: 0xc000003e},
+		{testName: "Low half of the last argument", offset: lowArg5, want: 0x11223344},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := Run([]Instruction{LdAbs(SizeW, tc.offset), Ret(A)}, data, binary.NativeEndian)
+			if err != nil {
+				t.Fatalf("Run() = %v, want nil error", err)
+			}
+			if got != tc.want {
+				t.Errorf("Run() = %#x, want %#x", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"errors"
+	"math"
+
+	"buzzer/pkg/ebpf/ebpf"
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+var (
+	JumpTooFar = errors.New("jump does not fit in the 16 bit offset of eBPF jumps")
+)
+
+// Registers of the eBPF translation, the same ones bpf_convert_filter uses
+// when the kernel migrates classic filters.
+const (
+	ebpfA   = pb.Reg_R0
+	ebpfX   = pb.Reg_R7
+	ebpfTmp = pb.Reg_R2
+	ebpfCtx = pb.Reg_R6
+)
+
+// skfAdOff is SKF_AD_OFF, packet loads at or past it read ancillary data
+// instead of the contents of the packet, eBPF has no equivalent for those.
+const skfAdOff = 0xfffff000
+
+// skbLenOffset is the offset of the len field of struct __sk_buff, the
+// context of eBPF socket filters.
+const skbLenOffset = 0
+
+// scratchOffset returns the offset from the frame pointer of the stack slot
+// holding the scratch memory word `k`.
+func scratchOffset(k uint32) int16 {
+	return -int16(4 * (ScratchSlots - k))
+}
+
+// jumpOffset returns the offset of the eBPF jump at position `slot` of the
+// translation of an instruction, so it lands on the translation of the
+// instruction `target` of the classic program.
+type jumpOffset func(slot int, target int64) (int16, error)
+
+// translateAlu returns the 32 bit eBPF equivalent of the ALU operation `op`
+// on A, `ok` is false for unknown operations.
+func translateAlu[T ebpf.Src](op uint16, src T) (insn *pb.Instruction, ok bool) {
+	switch op {
+	case AluAdd:
+		return ebpf.Add(ebpfA, src), true
+	case AluSub:
+		return ebpf.Sub(ebpfA, src), true
+	case AluMul:
+		return ebpf.Mul(ebpfA, src), true
+	case AluDiv:
+		return ebpf.Div(ebpfA, src), true
+	case AluMod:
+		return ebpf.Mod(ebpfA, src), true
+	case AluOr:
+		return ebpf.Or(ebpfA, src), true
+	case AluAnd:
+		return ebpf.And(ebpfA, src), true
+	case AluXor:
+		return ebpf.Xor(ebpfA, src), true
+	case AluLsh:
+		return ebpf.Lsh(ebpfA, src), true
+	case AluRsh:
+		return ebpf.Rsh(ebpfA, src), true
+	case AluNeg:
+		return ebpf.Neg(ebpfA, 0), true
+	}
+	return nil, false
+}
+
+// translateJump returns the 32 bit eBPF equivalent of the conditional jump
+// operation `op`, `ok` is false for unknown operations.
+func translateJump[T ebpf.Src](op uint16, src T, offset int16) (insn *pb.Instruction, ok bool) {
+	switch op {
+	case JmpJEQ:
+		return ebpf.JmpEQ32(ebpfA, src, offset), true
+	case JmpJGT:
+		return ebpf.JmpGT32(ebpfA, src, offset), true
+	case JmpJGE:
+		return ebpf.JmpGE32(ebpfA, src, offset), true
+	case JmpJSET:
+		return ebpf.JmpSET32(ebpfA, src, offset), true
+	}
+	return nil, false
+}
+
+// translatePacketLoad returns the eBPF packet load of `size` bytes at `k`,
+// plus X in BPF_IND mode, `ok` is false for unknown sizes.
+func translatePacketLoad(mode, size uint16, k uint32) (insn *pb.Instruction, ok bool) {
+	imm := int32(k)
+	switch {
+	case mode == ModeAbs && size == SizeW:
+		return ebpf.LdAbsW(imm), true
+	case mode == ModeAbs && size == SizeH:
+		return ebpf.LdAbsH(imm), true
+	case mode == ModeAbs && size == SizeB:
+		return ebpf.LdAbsB(imm), true
+	case mode == ModeInd && size == SizeW:
+		return ebpf.LdIndW(ebpfX, imm), true
+	case mode == ModeInd && size == SizeH:
+		return ebpf.LdIndH(ebpfX, imm), true
+	case mode == ModeInd && size == SizeB:
+		return ebpf.LdIndB(ebpfX, imm), true
+	}
+	return nil, false
+}
+
+// translateInstruction returns the eBPF instructions equivalent to the
+// instruction at `index` of `program`.
+func translateInstruction(program []Instruction, index int, jumpTo jumpOffset) ([]*pb.Instruction, error) {
+	insn := program[index]
+	unsupported := &UnsupportedInstruction{Index: index, Instruction: insn}
+	switch insn.Class() {
+	case ClassLd:
+		switch insn.Mode() {
+		case ModeImm:
+			return []*pb.Instruction{ebpf.Mov(ebpfA, int32(insn.K))}, nil
+		case ModeAbs, ModeInd:
+			if insn.Mode() == ModeAbs && insn.K >= skfAdOff {
+				return nil, unsupported
+			}
+			load, ok := translatePacketLoad(insn.Mode(), insn.Size(), insn.K)
+			if !ok {
+				return nil, unsupported
+			}
+			return []*pb.Instruction{load}, nil
+		case ModeMem:
+			if insn.K >= ScratchSlots {
+				return nil, unsupported
+			}
+			return []*pb.Instruction{ebpf.LdW(ebpfA, pb.Reg_R10, scratchOffset(insn.K))}, nil
+		case ModeLen:
+			return []*pb.Instruction{ebpf.LdW(ebpfA, ebpfCtx, skbLenOffset)}, nil
+		}
+	case ClassLdx:
+		switch insn.Mode() {
+		case ModeImm:
+			return []*pb.Instruction{ebpf.Mov(ebpfX, int32(insn.K))}, nil
+		case ModeMem:
+			if insn.K >= ScratchSlots {
+				return nil, unsupported
+			}
+			return []*pb.Instruction{ebpf.LdW(ebpfX, pb.Reg_R10, scratchOffset(insn.K))}, nil
+		case ModeLen:
+			return []*pb.Instruction{ebpf.LdW(ebpfX, ebpfCtx, skbLenOffset)}, nil
+		case ModeMsh:
+			// The packet load goes through A, which is saved in X
+			// meanwhile. Temporary registers do not survive packet
+			// loads.
+			return []*pb.Instruction{
+				ebpf.Mov64(ebpfX, ebpfA),
+				ebpf.LdAbsB(int32(insn.K)),
+				ebpf.And(ebpfA, 0xf),
+				ebpf.Lsh(ebpfA, 2),
+				ebpf.Mov64(ebpfTmp, ebpfX),
+				ebpf.Mov64(ebpfX, ebpfA),
+				ebpf.Mov64(ebpfA, ebpfTmp),
+			}, nil
+		}
+	case ClassSt, ClassStx:
+		if insn.K >= ScratchSlots {
+			return nil, unsupported
+		}
+		src := ebpfA
+		if insn.Class() == ClassStx {
+			src = ebpfX
+		}
+		return []*pb.Instruction{ebpf.StW(pb.Reg_R10, src, scratchOffset(insn.K))}, nil
+	case ClassAlu:
+		var op *pb.Instruction
+		var ok bool
+		if insn.Source() == SrcX {
+			op, ok = translateAlu(insn.Operation(), ebpfX)
+		} else {
+			op, ok = translateAlu(insn.Operation(), int32(insn.K))
+		}
+		if !ok {
+			return nil, unsupported
+		}
+		if insn.Operation() != AluDiv && insn.Operation() != AluMod {
+			return []*pb.Instruction{op}, nil
+		}
+		// Classic filters return 0 on division by zero while eBPF
+		// carries on with a 0 quotient, the check is explicit.
+		if insn.Source() == SrcX {
+			return []*pb.Instruction{
+				ebpf.JmpNE32(ebpfX, 0, 2),
+				ebpf.Mov(ebpfA, 0),
+				ebpf.Exit(),
+				op,
+			}, nil
+		}
+		if insn.K == 0 {
+			return []*pb.Instruction{ebpf.Mov(ebpfA, 0), ebpf.Exit()}, nil
+		}
+		return []*pb.Instruction{op}, nil
+	case ClassJmp:
+		targets := JumpTargets(program, index)
+		offset, err := jumpTo(0, targets[0])
+		if err != nil {
+			return nil, err
+		}
+		if !insn.IsConditionalJump() {
+			return []*pb.Instruction{ebpf.Jmp(offset)}, nil
+		}
+		var jmp *pb.Instruction
+		var ok bool
+		if insn.Source() == SrcX {
+			jmp, ok = translateJump(insn.Operation(), ebpfX, offset)
+		} else {
+			jmp, ok = translateJump(insn.Operation(), int32(insn.K), offset)
+		}
+		if !ok {
+			return nil, unsupported
+		}
+		if insn.Jf == 0 {
+			return []*pb.Instruction{jmp}, nil
+		}
+		offset, err = jumpTo(1, targets[1])
+		if err != nil {
+			return nil, err
+		}
+		return []*pb.Instruction{jmp, ebpf.Jmp(offset)}, nil
+	case ClassRet:
+		if insn.Code&0x18 == RetA {
+			return []*pb.Instruction{ebpf.Exit()}, nil
+		}
+		return []*pb.Instruction{ebpf.Mov(ebpfA, int32(insn.K)), ebpf.Exit()}, nil
+	case ClassMisc:
+		switch insn.Code & 0xf8 {
+		case MiscTax:
+			return []*pb.Instruction{ebpf.Mov(ebpfX, ebpfA)}, nil
+		case MiscTxa:
+			return []*pb.Instruction{ebpf.Mov(ebpfA, ebpfX)}, nil
+		}
+	}
+	return nil, unsupported
+}
+
+// reachableInstructions returns which instructions of `program`, whose jumps
+// must be in bounds, can be reached from the first one.
+func reachableInstructions(program []Instruction) []bool {
+	reachable := make([]bool, len(program))
+	reachable[0] = true
+	// Jumps only go forward, a single pass is enough.
+	for i, insn := range program {
+		if !reachable[i] {
+			continue
+		}
+		switch {
+		case insn.IsJump():
+			for _, target := range JumpTargets(program, i) {
+				reachable[target] = true
+			}
+		case insn.Class() != ClassRet && i+1 < len(program):
+			reachable[i+1] = true
+		}
+	}
+	return reachable
+}
+
+// ToEbpf translates `program` into an eBPF socket filter that keeps the same
+// part of every packet, the way the kernel does when it migrates classic
+// filters: A lives in R0, X in R7, the scratch memory in the stack and the
+// packet loads become BPF_ABS and BPF_IND instructions.
+//
+// Unreachable instructions are left out as the eBPF verifier refuses dead
+// code. Loads of ancillary data, at SKF_AD_OFF and beyond, cannot be
+// translated and return an UnsupportedInstruction error.
+func ToEbpf(program []Instruction) (*pb.Program, error) {
+	if len(program) == 0 {
+		return nil, EmptyProgram
+	}
+	if len(program) > MaxInstructions {
+		return nil, ProgramTooLong
+	}
+	if err := CheckJumps(program); err != nil {
+		return nil, err
+	}
+	reachable := reachableInstructions(program)
+	if last := len(program) - 1; reachable[last] && program[last].Class() != ClassRet {
+		return nil, NoReturn
+	}
+
+	// Classic filters start with A and X set to 0 and the eBPF packet
+	// loads need the context in R6.
+	prologue := []*pb.Instruction{
+		ebpf.Mov(ebpfA, 0),
+		ebpf.Mov(ebpfX, 0),
+		ebpf.Mov64(ebpfCtx, pb.Reg_R1),
+	}
+
+	// The length of each translation does not depend on the jump offsets,
+	// a first pass finds where each one of them starts.
+	starts := make([]int, len(program))
+	next := len(prologue)
+	placeholder := func(int, int64) (int16, error) { return 0, nil }
+	for i := range program {
+		starts[i] = next
+		if !reachable[i] {
+			continue
+		}
+		translation, err := translateInstruction(program, i, placeholder)
+		if err != nil {
+			return nil, err
+		}
+		next += len(translation)
+	}
+
+	result := &pb.Program{Instructions: prologue}
+	for i := range program {
+		if !reachable[i] {
+			continue
+		}
+		jumpTo := func(slot int, target int64) (int16, error) {
+			offset := starts[target] - (starts[i] + slot) - 1
+			if offset > math.MaxInt16 {
+				return 0, JumpTooFar
+			}
+			return int16(offset), nil
+		}
+		translation, err := translateInstruction(program, i, jumpTo)
+		if err != nil {
+			return nil, err
+		}
+		result.Instructions = append(result.Instructions, translation...)
+	}
+	return result, nil
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"errors"
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+)
+
+func TestToEbpf(t *testing.T) {
+	tests := []struct {
+		testName string
+		program  []Instruction
+		want     string
+	}{
+		{
+			testName: "Conditional jump",
+			program: []Instruction{
+				LdAbs(SizeH, 12),
+				Jeq(0x800, 0, 1),
+				Ret(0xffff),
+				Ret(0),
+			},
+			want: `   0: (b4) w0 = 0
+   1: (b4) w7 = 0
+   2: (bf) r6 = r1
+   3: (28) r0 = *(u16 *)skb[12]
+   4: (16) if w0 == 0x800 goto pc+1
+   5: (05) goto pc+2
+   6: (b4) w0 = 65535
+   7: (95) exit
+   8: (b4) w0 = 0
+   9: (95) exit
+`,
+		},
+		{
+			testName: "Scratch memory and division",
+			program: []Instruction{
+				LdxMsh(0),
+				St(3),
+				LdMem(3),
+				Div(X),
+				Ret(A),
+			},
+			want: `   0: (b4) w0 = 0
+   1: (b4) w7 = 0
+   2: (bf) r6 = r1
+   3: (bf) r7 = r0
+   4: (30) r0 = *(u8 *)skb[0]
+   5: (54) w0 &= 15
+   6: (64) w0 <<= 2
+   7: (bf) r2 = r7
+   8: (bf) r7 = r0
+   9: (bf) r0 = r2
+  10: (63) *(u32 *)(r10 -52) = r0
+  11: (61) r0 = *(u32 *)(r10 -52)
+  12: (56) if w7 != 0x0 goto pc+2
+  13: (b4) w0 = 0
+  14: (95) exit
+  15: (3c) w0 /= w7
+  16: (95) exit
+`,
+		},
+		{
+			testName: "Unreachable instructions",
+			program: []Instruction{
+				LdLen(),
+				Ja(1),
+				Ret(1),
+				Jset(X, 1, 0),
+				Ret(2),
+				Ret(A),
+			},
+			want: `   0: (b4) w0 = 0
+   1: (b4) w7 = 0
+   2: (bf) r6 = r1
+   3: (61) r0 = *(u32 *)(r6 +0)
+   4: (05) goto pc+0
+   5: (4e) if w0 & w7 goto pc+2
+   6: (b4) w0 = 2
+   7: (95) exit
+   8: (95) exit
+`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := ToEbpf(tc.program)
+			if err != nil {
+				t.Fatalf("ToEbpf() = %v, want nil error", err)
+			}
+			listing, err := ebpf.Disassemble(got)
+			if err != nil {
+				t.Fatalf("Disassemble() = %v, want nil error", err)
+			}
+			if listing != tc.want {
+				t.Errorf("ToEbpf() =\n%s\nwant\n%s", listing, tc.want)
+			}
+		})
+	}
+}
+
+func TestToEbpfErrors(t *testing.T) {
+	var outOfBounds *JumpOutOfBounds
+	var unsupported *UnsupportedInstruction
+	tests := []struct {
+		testName string
+		program  []Instruction
+		wantErr  any
+	}{
+		{
+			testName: "Jump out of bounds",
+			program:  []Instruction{Ja(1), Ret(0)},
+			wantErr:  &outOfBounds,
+		},
+		{
+			testName: "Ancillary data load",
+			program:  []Instruction{LdAbs(SizeW, 0xfffff000), Ret(A)},
+			wantErr:  &unsupported,
+		},
+		{
+			testName: "No return",
+			program:  []Instruction{LdImm(1), Tax()},
+			wantErr:  &NoReturn,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			_, err := ToEbpf(tc.program)
+			if target, ok := tc.wantErr.(*error); ok {
+				if !errors.Is(err, *target) {
+					t.Errorf("ToEbpf() = %v, want %v", err, *target)
+				}
+				return
+			}
+			if !errors.As(err, tc.wantErr) {
+				t.Errorf("ToEbpf() = %v, want an error of type %T", err, tc.wantErr)
+			}
+		})
+	}
+}
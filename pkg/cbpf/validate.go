@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+)
+
+var (
+	NotAJump      = errors.New("instruction is not a jump")
+	CannotViolate = errors.New("jump cannot be made to violate the bounds this way")
+)
+
+// JumpOutOfBounds is returned for jumps that do not land on an instruction
+// of the program.
+type JumpOutOfBounds struct {
+	Index int
+
+	// Target is the index the jump lands on, computed without the 32 bit
+	// wrap around.
+	Target int64
+}
+
+func (e *JumpOutOfBounds) Error() string {
+	return fmt.Sprintf("jump at instruction %d lands on %d, outside of the program", e.Index, e.Target)
+}
+
+// JumpTargets returns the indexes of the instructions the jump at `index`
+// can land on: the target of Ja, or the targets when the condition is true
+// and false for the rest.
+func JumpTargets(program []Instruction, index int) []int64 {
+	insn := program[index]
+	next := int64(index) + 1
+	if !insn.IsJump() {
+		return nil
+	}
+	if !insn.IsConditionalJump() {
+		return []int64{next + int64(insn.K)}
+	}
+	return []int64{next + int64(insn.Jt), next + int64(insn.Jf)}
+}
+
+// CheckJumps enforces the same rules about jumps as the classic checker of
+// the kernel, bpf_check_classic: every jump must land on an instruction
+// after it. Offsets are unsigned so jumps can only go forward, as long as
+// the offset of Ja is not big enough to wrap around.
+func CheckJumps(program []Instruction) error {
+	for i := range program {
+		for _, target := range JumpTargets(program, i) {
+			if target >= int64(len(program)) {
+				return &JumpOutOfBounds{Index: i, Target: target}
+			}
+		}
+	}
+	return nil
+}
+
+// JumpViolation is a way of breaking the bounds of a jump.
+type JumpViolation int
+
+const (
+	// PastEnd makes the jump land right after the last instruction. For
+	// conditional jumps it is the true branch that is broken.
+	PastEnd JumpViolation = iota
+
+	// Backward makes the offset of Ja wrap around in 32 bit arithmetic
+	// so the jump lands on the first instruction, creating a loop.
+	Backward
+)
+
+// ViolateJump returns a copy of `program` where the jump at `index` breaks
+// the bounds the way `violation` says, for negative tests of the classic
+// checker.
+func ViolateJump(program []Instruction, index int, violation JumpViolation) ([]Instruction, error) {
+	insn := program[index]
+	if !insn.IsJump() {
+		return nil, NotAJump
+	}
+	distance := len(program) - index - 1
+	switch {
+	case violation == PastEnd && insn.IsConditionalJump():
+		if distance > 0xff {
+			return nil, CannotViolate
+		}
+		insn.Jt = uint8(distance)
+	case violation == PastEnd:
+		insn.K = uint32(distance)
+	case violation == Backward && !insn.IsConditionalJump():
+		insn.K = ^uint32(index)
+	default:
+		return nil, CannotViolate
+	}
+	result := slices.Clone(program)
+	result[index] = insn
+	return result, nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cbpf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckJumps(t *testing.T) {
+	tests := []struct {
+		testName  string
+		program   []Instruction
+		badIndex  int
+		badTarget int64
+	}{
+		{testName: "No jumps", program: []Instruction{LdImm(1), Ret(A)}, badIndex: -1},
+		{testName: "Jumps to the last instruction", program: []Instruction{Ja(1), Jeq(0, 0, 0), Ret(A)}, badIndex: -1},
+		{testName: "Ja past the end", program: []Instruction{Ja(1), Ret(A)}, badIndex: 0, badTarget: 2},
+		{testName: "False branch past the end", program: []Instruction{LdImm(0), Jeq(0, 0, 1), Ret(A)}, badIndex: 1, badTarget: 3},
+		{testName: "Ja does not wrap around", program: []Instruction{Ret(0), Ja(0xffffffff), Ret(A)}, badIndex: 1, badTarget: 0x100000001},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			err := CheckJumps(tc.program)
+			if tc.badIndex < 0 {
+				if err != nil {
+					t.Errorf("CheckJumps() = %v, want nil error", err)
+				}
+				return
+			}
+			var outOfBounds *JumpOutOfBounds
+			if !errors.As(err, &outOfBounds) || outOfBounds.Index != tc.badIndex || outOfBounds.Target != tc.badTarget {
+				t.Errorf("CheckJumps() = %v, want jump %d out of bounds to %d", err, tc.badIndex, tc.badTarget)
+			}
+		})
+	}
+}
+
+func TestViolateJump(t *testing.T) {
+	program := []Instruction{LdImm(0), Jeq(0, 0, 0), Ja(0), Ret(A)}
+	tests := []struct {
+		testName  string
+		index     int
+		violation JumpViolation
+		wantErr   error
+	}{
+		{testName: "Conditional past the end", index: 1, violation: PastEnd},
+		{testName: "Ja past the end", index: 2, violation: PastEnd},
+		{testName: "Ja backward", index: 2, violation: Backward},
+		{testName: "Conditional cannot go backward", index: 1, violation: Backward, wantErr: CannotViolate},
+		{testName: "Not a jump", index: 0, violation: PastEnd, wantErr: NotAJump},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := ViolateJump(program, tc.index, tc.violation)
+			if err != tc.wantErr {
+				t.Fatalf("ViolateJump() = %v, want %v", err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if CheckJumps(program) != nil {
+				t.Fatalf("ViolateJump() modified its input")
+			}
+			if err := CheckJumps(got); err == nil {
+				t.Errorf("CheckJumps() of the violated program = nil, want an error")
+			}
+		})
+	}
+
+	// The kernel computes the target of Ja in 32 bits, the backward
+	// violation lands on the first instruction.
+	got, _ := ViolateJump(program, 2, Backward)
+	if target := uint32(2 + 1 + got[2].K); target != 0 {
+		t.Errorf("32 bit target of the backward jump = %d, want 0", target)
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package corpus
+
+import (
+	"encoding/base64"
+	"strings"
+
+	cpb "buzzer/proto/corpus_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+// ConsoleMarker starts the console lines that carry an entry, the fuzzer
+// prints one before loading every program so whoever watches the console of
+// the machine, e.g. the VM manager, knows which program was running when the
+// kernel crashed.
+const ConsoleMarker = "BUZZER_PROGRAM "
+
+// FormatConsoleLine returns the console line that carries `entry`, without
+// the trailing newline.
+func FormatConsoleLine(entry *cpb.CorpusEntry) (string, error) {
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	return ConsoleMarker + base64.StdEncoding.EncodeToString(data), nil
+}
+
+// ParseConsoleLine returns the entry carried by the console `line`, which
+// can be prefixed by whatever the console adds, e.g. a timestamp. Returns
+// false if the line does not carry an entry or the entry is corrupted.
+func ParseConsoleLine(line string) (*cpb.CorpusEntry, bool) {
+	_, encoded, found := strings.Cut(line, ConsoleMarker)
+	if !found {
+		return nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, false
+	}
+	entry := &cpb.CorpusEntry{}
+	if err := proto.Unmarshal(data, entry); err != nil {
+		return nil, false
+	}
+	return entry, true
+}
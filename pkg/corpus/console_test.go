@@ -0,0 +1,51 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package corpus
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestConsoleLine(t *testing.T) {
+	want := testEntries()[0]
+	line, err := FormatConsoleLine(want)
+	if err != nil {
+		t.Fatalf("FormatConsoleLine() failed: %v", err)
+	}
+
+	tests := []struct {
+		testName string
+		line     string
+		wantOk   bool
+	}{
+		{"Line as printed", line, true},
+		{"Line with a timestamp and a carriage return", "[   12.345678] " + line + "\r", true},
+		{"Line without a marker", "[   12.345678] BUG: KASAN: slab-out-of-bounds", false},
+		{"Truncated line", line[:len(line)-3], false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, ok := ParseConsoleLine(tc.line)
+			if ok != tc.wantOk {
+				t.Fatalf("ParseConsoleLine() = %v, want %v", ok, tc.wantOk)
+			}
+			if ok && !proto.Equal(got, want) {
+				t.Errorf("ParseConsoleLine() = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package corpus stores generated programs, and what the kernel did with
+// them, in files that can later be used to replay the programs.
+//
+// A corpus file is a stream of CorpusEntry protos, each one prefixed by its
+// size encoded as a varint.
+package corpus
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	cpb "buzzer/proto/corpus_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// Upper bound on the size of a single entry, anything bigger means
+	// the file is corrupted.
+	maxEntrySize = 64 << 20
+)
+
+var (
+	EntryTooBig = errors.New("corpus entry is too big, the file might be corrupted")
+)
+
+// Writer appends entries to a corpus stream. It can be shared by several
+// goroutines.
+type Writer struct {
+	mu     sync.Mutex
+	w      *bufio.Writer
+	closer io.Closer
+}
+
+// NewWriter returns a Writer that writes to `w`.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriter(w)}
+}
+
+// OpenWriter returns a Writer that appends to the corpus file at `path`,
+// creating it if needed.
+func OpenWriter(path string) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: bufio.NewWriter(f), closer: f}, nil
+}
+
+// CreateWriter returns a Writer that writes to a new corpus file at `path`,
+// replacing the file that might be there.
+func CreateWriter(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: bufio.NewWriter(f), closer: f}, nil
+}
+
+// Write appends `entry` to the stream. Entries are flushed right away so a
+// crash of the fuzzer doesn't lose the programs that led to it. Other
+// streams, e.g. decision logs, use the same format with other messages.
+func (cw *Writer) Write(entry proto.Message) error {
+	data, err := proto.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	size := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(size, uint64(len(data)))
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	if _, err := cw.w.Write(size[:n]); err != nil {
+		return err
+	}
+	if _, err := cw.w.Write(data); err != nil {
+		return err
+	}
+	return cw.w.Flush()
+}
+
+// Close flushes the pending data and closes the underlying file, if the
+// Writer was created with OpenWriter.
+func (cw *Writer) Close() error {
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	err := cw.w.Flush()
+	if cw.closer != nil {
+		err = errors.Join(err, cw.closer.Close())
+	}
+	return err
+}
+
+// Reader reads entries from a corpus stream.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a Reader that reads from `r`.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next entry of the stream, or io.EOF when there are no
+// more entries.
+func (cr *Reader) Next() (*cpb.CorpusEntry, error) {
+	entry := &cpb.CorpusEntry{}
+	if err := cr.NextMessage(entry); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// NextMessage reads the next entry of the stream into `m`, for streams of
+// other messages than CorpusEntry. Returns io.EOF when there are no more
+// entries.
+func (cr *Reader) NextMessage(m proto.Message) error {
+	size, err := binary.ReadUvarint(cr.r)
+	if err != nil {
+		// A clean EOF can only happen before the size of an entry.
+		return err
+	}
+	if size > maxEntrySize {
+		return EntryTooBig
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(cr.r, data); err != nil {
+		return fmt.Errorf("truncated corpus entry: %w", err)
+	}
+	return proto.Unmarshal(data, m)
+}
+
+// Load reads all the entries of the corpus file at `path`.
+func Load(path string) ([]*cpb.CorpusEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []*cpb.CorpusEntry{}
+	r := NewReader(f)
+	for {
+		entry, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package corpus
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+
+	cpb "buzzer/proto/corpus_go_proto"
+	pb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+func testEntries() []*cpb.CorpusEntry {
+	return []*cpb.CorpusEntry{
+		{
+			Program: &pb.Program{
+				Instructions: []*pb.Instruction{
+					{DstReg: pb.Reg_R0, Immediate: 42},
+					{DstReg: pb.Reg_R1, SrcReg: pb.Reg_R10, Offset: -8},
+				},
+			},
+			Seed:     1234,
+			Strategy: "playground",
+			IsValid:  true,
+			ExecutionResult: &fpb.ExecutionResult{
+				DidSucceed: true,
+			},
+		},
+		{
+			Seed:     1234,
+			Strategy: "playground",
+			BpfError: "permission denied",
+		},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	entries := testEntries()
+	for _, entry := range entries {
+		if err := w.Write(entry); err != nil {
+			t.Fatalf("Write() = %v, want nil error", err)
+		}
+	}
+
+	r := NewReader(&buf)
+	for i, want := range entries {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("Next() = %v, want nil error", err)
+		}
+		if !proto.Equal(got, want) {
+			t.Errorf("entry %d = %v, want %v", i, got, want)
+		}
+	}
+	if _, err := r.Next(); !errors.Is(err, io.EOF) {
+		t.Errorf("Next() at the end of the stream = %v, want io.EOF", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corpus")
+	entries := testEntries()
+
+	// Entries written by different writers are appended to the same file.
+	for _, entry := range entries {
+		w, err := OpenWriter(path)
+		if err != nil {
+			t.Fatalf("OpenWriter() = %v, want nil error", err)
+		}
+		if err := w.Write(entry); err != nil {
+			t.Fatalf("Write() = %v, want nil error", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() = %v, want nil error", err)
+		}
+	}
+
+	got, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() = %v, want nil error", err)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("Load() returned %d entries, want %d", len(got), len(entries))
+	}
+	for i := range entries {
+		if !proto.Equal(got[i], entries[i]) {
+			t.Errorf("entry %d = %v, want %v", i, got[i], entries[i])
+		}
+	}
+}
+
+func TestTruncatedEntry(t *testing.T) {
+	var buf bytes.Buffer
+	if err := NewWriter(&buf).Write(testEntries()[0]); err != nil {
+		t.Fatalf("Write() = %v, want nil error", err)
+	}
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-1])
+	if _, err := NewReader(truncated).Next(); err == nil {
+		t.Errorf("Next() on a truncated entry did not return an error")
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+	"math/bits"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// EvaluateAlu runs `program` in Go and returns the value R0 holds when it
+// exits. Only straight line programs of ALU instructions and 64 bit
+// immediate loads that end with their only exit are supported, which is
+// enough to tell if the interpreter or the JIT miscomputed one of them.
+// Reading a register before writing it is an error, R1 and R10 hold
+// pointers.
+func EvaluateAlu(program *pb.Program) (uint64, error) {
+	var regs [R10 + 1]uint64
+	var written [R10 + 1]bool
+	read := func(index int, reg pb.Reg) (uint64, error) {
+		if reg > R9 || !written[reg] {
+			return 0, fmt.Errorf("instruction %d reads %v before it is written", index, reg)
+		}
+		return regs[reg], nil
+	}
+
+	for i, insn := range program.Instructions {
+		switch {
+		case insn.GetJmpOpcode().GetOperationCode() == pb.JmpOperationCode_JmpExit && insn.GetJmpOpcode().GetInstructionClass() == pb.InsClass_InsClassJmp:
+			if i != len(program.Instructions)-1 {
+				return 0, fmt.Errorf("instruction %d exits before the end of the program", i)
+			}
+			return read(i, R0)
+		case isWideLoad(insn) && insn.SrcReg == R0:
+			if insn.DstReg > R9 {
+				return 0, fmt.Errorf("instruction %d writes %v", i, insn.DstReg)
+			}
+			regs[insn.DstReg] = uint64(uint32(insn.Immediate)) | uint64(insn.GetPseudoValue().GetImmediate())<<32
+			written[insn.DstReg] = true
+		case insn.GetAluOpcode() != nil:
+			if insn.DstReg > R9 {
+				return 0, fmt.Errorf("instruction %d writes %v", i, insn.DstReg)
+			}
+			op := insn.GetAluOpcode()
+			var src uint64
+			if op.Source == pb.SrcOperand_RegSrc && op.OperationCode != pb.AluOperationCode_AluEnd {
+				var err error
+				if src, err = read(i, insn.SrcReg); err != nil {
+					return 0, err
+				}
+			} else {
+				src = uint64(int64(insn.Immediate))
+			}
+			var dst uint64
+			if op.OperationCode != pb.AluOperationCode_AluMov {
+				var err error
+				if dst, err = read(i, insn.DstReg); err != nil {
+					return 0, err
+				}
+			}
+			result, err := evaluateAluOperation(insn, dst, src)
+			if err != nil {
+				return 0, fmt.Errorf("instruction %d: %v", i, err)
+			}
+			regs[insn.DstReg] = result
+			written[insn.DstReg] = true
+		default:
+			return 0, fmt.Errorf("instruction %d is not an ALU instruction", i)
+		}
+	}
+	return 0, fmt.Errorf("the program does not exit")
+}
+
+// evaluateAluOperation returns the result of the ALU instruction `insn` on
+// the value of its destination `dst` and of its source `src`, the immediate
+// sign extended to 64 bits if it has no source register.
+func evaluateAluOperation(insn *pb.Instruction, dst, src uint64) (uint64, error) {
+	op := insn.GetAluOpcode()
+	is64 := op.InstructionClass == pb.InsClass_InsClassAlu64
+	signed := IsSignedAluInstruction(insn)
+	if insn.Offset != 0 && !signed {
+		return 0, fmt.Errorf("unsupported offset %d", insn.Offset)
+	}
+
+	if op.OperationCode == pb.AluOperationCode_AluEnd {
+		return evaluateByteSwap(op, dst, insn.Immediate)
+	}
+
+	if !is64 {
+		// 32 bit operations only see the lower halves and zero extend
+		// their result.
+		result, err := evaluateAlu32(op.OperationCode, signed, uint32(dst), uint32(src))
+		return uint64(result), err
+	}
+	switch op.OperationCode {
+	case pb.AluOperationCode_AluAdd:
+		return dst + src, nil
+	case pb.AluOperationCode_AluSub:
+		return dst - src, nil
+	case pb.AluOperationCode_AluMul:
+		return dst * src, nil
+	case pb.AluOperationCode_AluDiv:
+		if src == 0 {
+			return 0, nil
+		}
+		if signed {
+			return uint64(int64(dst) / int64(src)), nil
+		}
+		return dst / src, nil
+	case pb.AluOperationCode_AluMod:
+		if src == 0 {
+			return dst, nil
+		}
+		if signed {
+			return uint64(int64(dst) % int64(src)), nil
+		}
+		return dst % src, nil
+	case pb.AluOperationCode_AluOr:
+		return dst | src, nil
+	case pb.AluOperationCode_AluAnd:
+		return dst & src, nil
+	case pb.AluOperationCode_AluXor:
+		return dst ^ src, nil
+	case pb.AluOperationCode_AluLsh:
+		return dst << (src & 63), nil
+	case pb.AluOperationCode_AluRsh:
+		return dst >> (src & 63), nil
+	case pb.AluOperationCode_AluArsh:
+		return uint64(int64(dst) >> (src & 63)), nil
+	case pb.AluOperationCode_AluNeg:
+		return -dst, nil
+	case pb.AluOperationCode_AluMov:
+		return src, nil
+	}
+	return 0, fmt.Errorf("unsupported operation %v", op.OperationCode)
+}
+
+// evaluateAlu32 is evaluateAluOperation for the 32 bit operations.
+func evaluateAlu32(op pb.AluOperationCode, signed bool, dst, src uint32) (uint32, error) {
+	switch op {
+	case pb.AluOperationCode_AluAdd:
+		return dst + src, nil
+	case pb.AluOperationCode_AluSub:
+		return dst - src, nil
+	case pb.AluOperationCode_AluMul:
+		return dst * src, nil
+	case pb.AluOperationCode_AluDiv:
+		if src == 0 {
+			return 0, nil
+		}
+		if signed {
+			return uint32(int32(dst) / int32(src)), nil
+		}
+		return dst / src, nil
+	case pb.AluOperationCode_AluMod:
+		if src == 0 {
+			return dst, nil
+		}
+		if signed {
+			return uint32(int32(dst) % int32(src)), nil
+		}
+		return dst % src, nil
+	case pb.AluOperationCode_AluOr:
+		return dst | src, nil
+	case pb.AluOperationCode_AluAnd:
+		return dst & src, nil
+	case pb.AluOperationCode_AluXor:
+		return dst ^ src, nil
+	case pb.AluOperationCode_AluLsh:
+		return dst << (src & 31), nil
+	case pb.AluOperationCode_AluRsh:
+		return dst >> (src & 31), nil
+	case pb.AluOperationCode_AluArsh:
+		return uint32(int32(dst) >> (src & 31)), nil
+	case pb.AluOperationCode_AluNeg:
+		return -dst, nil
+	case pb.AluOperationCode_AluMov:
+		return src, nil
+	}
+	return 0, fmt.Errorf("unsupported operation %v", op)
+}
+
+// evaluateByteSwap returns the result of the BPF_END instruction of opcode
+// `op` on the lower `width` bits of `dst`, converted from the byte order of
+// the host. The upper bits are zeroed.
+func evaluateByteSwap(op *pb.AluOpcode, dst uint64, width int32) (uint64, error) {
+	swap := op.InstructionClass == pb.InsClass_InsClassAlu64
+	if !swap {
+		bigEndian := op.Source == pb.SrcOperand_RegSrc
+		swap = bigEndian != (HostEndianness() == BigEndian)
+	}
+	switch width {
+	case 16:
+		if swap {
+			return uint64(bits.ReverseBytes16(uint16(dst))), nil
+		}
+		return uint64(uint16(dst)), nil
+	case 32:
+		if swap {
+			return uint64(bits.ReverseBytes32(uint32(dst))), nil
+		}
+		return uint64(uint32(dst)), nil
+	case 64:
+		if swap {
+			return bits.ReverseBytes64(dst), nil
+		}
+		return dst, nil
+	}
+	return 0, fmt.Errorf("unsupported byte swap width %d", width)
+}
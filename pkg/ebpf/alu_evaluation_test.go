@@ -0,0 +1,149 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"math"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestEvaluateAlu(t *testing.T) {
+	le := HostEndianness() == LittleEndian
+	tests := []struct {
+		testName string
+		insn     []*pb.Instruction
+		want     uint64
+	}{
+		{
+			testName: "Immediates are sign extended",
+			insn:     []*pb.Instruction{Mov64(R0, -1), Add64(R0, 2)},
+			want:     1,
+		},
+		{
+			testName: "32 bit operations zero extend",
+			insn:     []*pb.Instruction{Mov64(R0, -1), Add(R0, 1)},
+			want:     0,
+		},
+		{
+			testName: "Wide immediate",
+			insn:     []*pb.Instruction{Mov64(R3, 0x1122334455667788), Mov64(R0, R3)},
+			want:     0x1122334455667788,
+		},
+		{
+			testName: "Division by zero",
+			insn:     []*pb.Instruction{Mov64(R0, 10), Mov64(R1, 0), Div64(R0, R1)},
+			want:     0,
+		},
+		{
+			testName: "Modulo by zero",
+			insn:     []*pb.Instruction{Mov64(R0, -10), Mov64(R2, 0), Mod(R0, R2)},
+			want:     uint64(uint32(0xfffffff6)),
+		},
+		{
+			testName: "Signed division overflow",
+			insn:     []*pb.Instruction{Mov64(R0, math.MinInt32), SDiv(R0, -1)},
+			want:     0x80000000,
+		},
+		{
+			testName: "Signed modulo",
+			insn:     []*pb.Instruction{Mov64(R0, -7), SMod64(R0, 3)},
+			want:     math.MaxUint64,
+		},
+		{
+			testName: "Shifts are masked",
+			insn:     []*pb.Instruction{Mov64(R0, 1), Mov64(R4, 65), Lsh64(R0, R4)},
+			want:     2,
+		},
+		{
+			testName: "Arithmetic shift",
+			insn:     []*pb.Instruction{Mov64(R0, -16), Arsh(R0, 2)},
+			want:     uint64(uint32(0xfffffffc)),
+		},
+		{
+			testName: "Negation",
+			insn:     []*pb.Instruction{Mov64(R0, 5), Neg64(R0, 0)},
+			want:     math.MaxUint64 - 4,
+		},
+		{
+			testName: "Bswap",
+			insn:     []*pb.Instruction{Mov64(R0, 0x11223344), Bswap(R0, 16)},
+			want:     0x4433,
+		},
+		{
+			testName: "ToBe",
+			insn:     []*pb.Instruction{Mov64(R0, 0x11223344), ToBe(R0, 32)},
+			want:     map[bool]uint64{true: 0x44332211, false: 0x11223344}[le],
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			prog := &pb.Program{Instructions: append(tc.insn, Exit())}
+			got, err := EvaluateAlu(prog)
+			if err != nil {
+				t.Fatalf("EvaluateAlu() = %v, want nil error", err)
+			}
+			if got != tc.want {
+				t.Errorf("EvaluateAlu() = %#x, want %#x", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestEvaluateAluUnsupported(t *testing.T) {
+	tests := []struct {
+		testName string
+		insn     []*pb.Instruction
+	}{
+		{
+			testName: "Uninitialized register",
+			insn:     []*pb.Instruction{Mov64(R0, 1), Add64(R0, R2), Exit()},
+		},
+		{
+			testName: "Context pointer",
+			insn:     []*pb.Instruction{Mov64(R0, R1), Exit()},
+		},
+		{
+			testName: "Frame pointer",
+			insn:     []*pb.Instruction{Mov64(R0, R10), Exit()},
+		},
+		{
+			testName: "Memory access",
+			insn:     []*pb.Instruction{LdDW(R0, R10, -8), Exit()},
+		},
+		{
+			testName: "Jump",
+			insn:     []*pb.Instruction{Mov64(R0, 0), JmpEQ(R0, 0, 0), Exit()},
+		},
+		{
+			testName: "Early exit",
+			insn:     []*pb.Instruction{Mov64(R0, 0), Exit(), Mov64(R0, 1), Exit()},
+		},
+		{
+			testName: "Missing exit",
+			insn:     []*pb.Instruction{Mov64(R0, 0)},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if _, err := EvaluateAlu(&pb.Program{Instructions: tc.insn}); err == nil {
+				t.Errorf("EvaluateAlu() = nil error, want an error")
+			}
+		})
+	}
+}
@@ -16,6 +16,8 @@ package ebpf
 
 import (
 	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+	"strings"
 )
 
 func newAluInstruction[T Src](oc pb.AluOperationCode, insclass pb.InsClass, dst pb.Reg, src T) *pb.Instruction {
@@ -221,6 +223,47 @@ func Mod[T Src](dstReg pb.Reg, src T) *pb.Instruction {
 	return newAluInstruction(pb.AluOperationCode_AluMod, pb.InsClass_InsClassAlu, dstReg, src)
 }
 
+// newSignedAluInstruction creates the signed variant of a division or
+// modulo, introduced by the v4 ISA, which is selected by an offset of 1.
+func newSignedAluInstruction[T Src](oc pb.AluOperationCode, insclass pb.InsClass, dstReg pb.Reg, src T) *pb.Instruction {
+	insn := newAluInstruction(oc, insclass, dstReg, src)
+	insn.Offset = 1
+	return insn
+}
+
+// SDiv64 Creates a new 64 bit signed Div instruction that is either imm or
+// reg depending on the data type of src
+func SDiv64[T Src](dstReg pb.Reg, src T) *pb.Instruction {
+	return newSignedAluInstruction(pb.AluOperationCode_AluDiv, pb.InsClass_InsClassAlu64, dstReg, src)
+}
+
+// SDiv Creates a new 32 bit signed Div instruction that is either imm or reg
+// depending on the data type of src
+func SDiv[T Src](dstReg pb.Reg, src T) *pb.Instruction {
+	return newSignedAluInstruction(pb.AluOperationCode_AluDiv, pb.InsClass_InsClassAlu, dstReg, src)
+}
+
+// SMod64 Creates a new 64 bit signed Mod instruction that is either imm or
+// reg depending on the data type of src
+func SMod64[T Src](dstReg pb.Reg, src T) *pb.Instruction {
+	return newSignedAluInstruction(pb.AluOperationCode_AluMod, pb.InsClass_InsClassAlu64, dstReg, src)
+}
+
+// SMod Creates a new 32 bit signed Mod instruction that is either imm or reg
+// depending on the data type of src
+func SMod[T Src](dstReg pb.Reg, src T) *pb.Instruction {
+	return newSignedAluInstruction(pb.AluOperationCode_AluMod, pb.InsClass_InsClassAlu, dstReg, src)
+}
+
+// IsSignedAluInstruction returns true if `i` is a signed division or modulo.
+func IsSignedAluInstruction(i *pb.Instruction) bool {
+	op := i.GetAluOpcode()
+	if op == nil || i.Offset != 1 {
+		return false
+	}
+	return op.OperationCode == pb.AluOperationCode_AluDiv || op.OperationCode == pb.AluOperationCode_AluMod
+}
+
 // Xor64 Creates a new 64 bit Xor instruction that is either imm or reg depending
 // on the data type of src
 func Xor64[T Src](dstReg pb.Reg, src T) *pb.Instruction {
@@ -274,3 +317,58 @@ func End64[T Src](dstReg pb.Reg, src T) *pb.Instruction {
 func End[T Src](dstReg pb.Reg, src T) *pb.Instruction {
 	return newAluInstruction(pb.AluOperationCode_AluEnd, pb.InsClass_InsClassAlu, dstReg, src)
 }
+
+// newEndInstruction creates a byte swap instruction on the lower `width`
+// bits of dstReg, the source bit selects the byte order and the source
+// register is unused.
+func newEndInstruction(insclass pb.InsClass, order pb.SrcOperand, dstReg pb.Reg, width int32) *pb.Instruction {
+	insn := newAluInstruction(pb.AluOperationCode_AluEnd, insclass, dstReg, width)
+	insn.GetAluOpcode().Source = order
+	return insn
+}
+
+// ToLe Creates a new BPF_END instruction that converts the lower `width` bits
+// of dstReg, 16, 32 or 64, to little endian and zeroes the upper ones.
+func ToLe(dstReg pb.Reg, width int32) *pb.Instruction {
+	return newEndInstruction(pb.InsClass_InsClassAlu, pb.SrcOperand_Immediate, dstReg, width)
+}
+
+// ToBe Creates a new BPF_END instruction that converts the lower `width` bits
+// of dstReg, 16, 32 or 64, to big endian and zeroes the upper ones.
+func ToBe(dstReg pb.Reg, width int32) *pb.Instruction {
+	return newEndInstruction(pb.InsClass_InsClassAlu, pb.SrcOperand_RegSrc, dstReg, width)
+}
+
+// Bswap Creates a new unconditional byte swap of the lower `width` bits of
+// dstReg, 16, 32 or 64, introduced by the v4 ISA as BPF_ALU64 BPF_END.
+func Bswap(dstReg pb.Reg, width int32) *pb.Instruction {
+	return newEndInstruction(pb.InsClass_InsClassAlu64, pb.SrcOperand_Immediate, dstReg, width)
+}
+
+// NameForAluInstruction returns the name of the builder that creates `i`,
+// e.g. Add64 or ToBe, followed by the width for byte swaps. It returns an
+// empty string if `i` is not an ALU instruction.
+func NameForAluInstruction(i *pb.Instruction) string {
+	op := i.GetAluOpcode()
+	if op == nil {
+		return ""
+	}
+	if op.OperationCode == pb.AluOperationCode_AluEnd {
+		name := "ToLe"
+		switch {
+		case op.InstructionClass == pb.InsClass_InsClassAlu64:
+			name = "Bswap"
+		case op.Source == pb.SrcOperand_RegSrc:
+			name = "ToBe"
+		}
+		return fmt.Sprintf("%s%d", name, i.Immediate)
+	}
+	name := strings.TrimPrefix(op.OperationCode.String(), "Alu")
+	if IsSignedAluInstruction(i) {
+		name = "S" + name
+	}
+	if op.InstructionClass == pb.InsClass_InsClassAlu64 {
+		name += "64"
+	}
+	return name
+}
@@ -376,6 +376,65 @@ func TestAluInstructionGenerationAndEncoding(t *testing.T) {
 			wantOperationCode:    pb.AluOperationCode_AluEnd,
 			wantEncoding:         []uint64{0xffff0001000009d4},
 		},
+		{
+			testName:             "Encoding ToLe",
+			instruction:          ToLe(testDstReg, 16),
+			wantDstReg:           testDstReg,
+			wantImm:              16,
+			wantInstructionClass: pb.InsClass_InsClassAlu,
+			wantSrcReg:           pb.Reg_R0,
+			wantSrc:              pb.SrcOperand_Immediate,
+			wantOffset:           0,
+			wantOperationCode:    pb.AluOperationCode_AluEnd,
+			wantEncoding:         []uint64{0x00000010000009d4},
+		},
+		{
+			testName:             "Encoding ToBe",
+			instruction:          ToBe(testDstReg, 32),
+			wantDstReg:           testDstReg,
+			wantImm:              32,
+			wantInstructionClass: pb.InsClass_InsClassAlu,
+			wantSrcReg:           pb.Reg_R0,
+			wantSrc:              pb.SrcOperand_RegSrc,
+			wantOffset:           0,
+			wantOperationCode:    pb.AluOperationCode_AluEnd,
+			wantEncoding:         []uint64{0x00000020000009dc},
+		},
+		{
+			testName:             "Encoding Bswap",
+			instruction:          Bswap(testDstReg, 64),
+			wantDstReg:           testDstReg,
+			wantImm:              64,
+			wantInstructionClass: pb.InsClass_InsClassAlu64,
+			wantSrcReg:           pb.Reg_R0,
+			wantSrc:              pb.SrcOperand_Immediate,
+			wantOffset:           0,
+			wantOperationCode:    pb.AluOperationCode_AluEnd,
+			wantEncoding:         []uint64{0x00000040000009d7},
+		},
+		{
+			testName:             "Encoding SDiv64 with register value as source",
+			instruction:          SDiv64(testDstReg, testSrcReg),
+			wantDstReg:           testDstReg,
+			wantSrcReg:           testSrcReg,
+			wantSrc:              pb.SrcOperand_RegSrc,
+			wantOffset:           1,
+			wantOperationCode:    pb.AluOperationCode_AluDiv,
+			wantInstructionClass: pb.InsClass_InsClassAlu64,
+			wantEncoding:         []uint64{0x1793f},
+		},
+		{
+			testName:             "Encoding SMod32 with immediate value as source",
+			instruction:          SMod(testDstReg, testImm),
+			wantDstReg:           testDstReg,
+			wantImm:              testImm,
+			wantSrcReg:           pb.Reg_R0,
+			wantSrc:              pb.SrcOperand_Immediate,
+			wantOffset:           1,
+			wantOperationCode:    pb.AluOperationCode_AluMod,
+			wantInstructionClass: pb.InsClass_InsClassAlu,
+			wantEncoding:         []uint64{0xffff000100010994},
+		},
 		{
 			testName:             "Encoding Add64 with register value as source",
 			instruction:          Add64(testDstReg, testSrcReg),
@@ -727,7 +786,7 @@ func TestAluInstructionGenerationAndEncoding(t *testing.T) {
 				t.Fatalf("operationCode = %d, want %d", opcode.OperationCode, tc.wantOperationCode)
 			}
 
-			encodingArray, err := encodeInstruction(instruction)
+			encodingArray, err := encodeInstruction(instruction, LittleEndian)
 			if err != nil {
 				t.Fatalf("unexpected error when ecoding: %v", err)
 			}
@@ -796,7 +855,7 @@ func TestMov64(t *testing.T) {
 			t.Fatalf("operationCode = %d, want %d", opcode.Mode, pb.StLdMode_StLdModeIMM)
 		}
 
-		encodingArray, err := encodeInstruction(instruction)
+		encodingArray, err := encodeInstruction(instruction, LittleEndian)
 		if err != nil {
 			t.Fatalf("unexpected error when ecoding: %v", err)
 		}
@@ -846,7 +905,7 @@ func TestMov64(t *testing.T) {
 			t.Fatalf("operationCode = %d, want %d", opcode.OperationCode, pb.AluOperationCode_AluMov)
 		}
 
-		encodingArray, err := encodeInstruction(instruction)
+		encodingArray, err := encodeInstruction(instruction, LittleEndian)
 		if err != nil {
 			t.Fatalf("unexpected error when ecoding: %v", err)
 		}
@@ -855,3 +914,28 @@ func TestMov64(t *testing.T) {
 		}
 	})
 }
+
+func TestNameForAluInstruction(t *testing.T) {
+	tests := []struct {
+		testName    string
+		instruction *pb.Instruction
+		want        string
+	}{
+		{"64 bit operation", Add64(R1, R2), "Add64"},
+		{"32 bit operation", Xor(R1, 3), "Xor"},
+		{"Little endian", ToLe(R1, 16), "ToLe16"},
+		{"Big endian", ToBe(R1, 32), "ToBe32"},
+		{"Byte swap", Bswap(R1, 64), "Bswap64"},
+		{"Signed division", SDiv64(R1, R2), "SDiv64"},
+		{"Signed modulo", SMod(R1, 3), "SMod"},
+		{"Not an ALU instruction", Exit(), ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := NameForAluInstruction(tc.instruction); got != tc.want {
+				t.Errorf("NameForAluInstruction() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// Arch is the profile of the JIT of a CPU architecture: what it translates
+// and how. The same program can be miscompiled by one JIT and not by the
+// others, findings are tagged with the architecture they were found on so
+// their corpus can be replayed on the other ones.
+type Arch struct {
+	// Name is the name the kernel uses for the architecture, e.g. "x86_64".
+	Name string
+
+	// IsaV4Since and AtomicsSince are the first kernel releases whose JIT
+	// supports the v4 ISA, see IsIsaV4Instruction, and the atomic operations
+	// other than atomic_add. Older JITs reject the programs using them or
+	// leave them to the interpreter.
+	IsaV4Since   string
+	AtomicsSince string
+
+	// Release is the kernel release programs are generated for, e.g.
+	// "6.1.0-13-amd64". Every feature is considered available if it is empty
+	// or can't be parsed.
+	Release string
+}
+
+// atomicOpsSince is the first kernel release whose verifier accepts the
+// BPF_ATOMIC operations. Older ones only know the legacy BPF_XADD, see Xadd,
+// whatever the architecture.
+const atomicOpsSince = "5.12"
+
+// arches are the profiles of the architectures buzzer knows the JIT of.
+var arches = []Arch{
+	{Name: "x86_64", IsaV4Since: "6.6", AtomicsSince: "5.12"},
+	{Name: "arm64", IsaV4Since: "6.7", AtomicsSince: "6.0"},
+	{Name: "riscv64", IsaV4Since: "6.7", AtomicsSince: "5.13"},
+	{Name: "s390x", IsaV4Since: "6.7", AtomicsSince: "5.13"},
+}
+
+// goArches maps the GOARCH of the architectures with a profile to their
+// name.
+var goArches = map[string]string{
+	"amd64":   "x86_64",
+	"arm64":   "arm64",
+	"riscv64": "riscv64",
+	"s390x":   "s390x",
+}
+
+// ArchNames returns the names of the architectures LookupArch knows.
+func ArchNames() []string {
+	var names []string
+	for _, a := range arches {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+// LookupArch returns the profile of the architecture `name`, e.g. "arm64",
+// for the kernel release `release`.
+func LookupArch(name, release string) (*Arch, error) {
+	for _, a := range arches {
+		if a.Name == name {
+			a.Release = release
+			return &a, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown architecture %q, want one of %s", name, strings.Join(ArchNames(), ", "))
+}
+
+// HostArch returns the profile of the architecture buzzer runs on for the
+// kernel release `release`. Architectures without a profile get one that
+// supports every instruction.
+func HostArch(release string) *Arch {
+	if a, err := LookupArch(goArches[runtime.GOARCH], release); err == nil {
+		return a
+	}
+	return &Arch{Name: runtime.GOARCH, Release: release}
+}
+
+// parseRelease returns the major and minor versions of the kernel release
+// `release`, e.g. 6 and 1 for "6.1.0-13-amd64".
+func parseRelease(release string) (int, int, bool) {
+	fields := strings.SplitN(release, ".", 3)
+	if len(fields) < 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	// The minor version can be followed by a suffix, e.g. "6.9-rc1".
+	digits := fields[1]
+	if end := strings.IndexFunc(digits, func(r rune) bool { return r < '0' || r > '9' }); end >= 0 {
+		digits = digits[:end]
+	}
+	minor, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// since returns true if the release programs are generated for is
+// `first` or a later one.
+func (a *Arch) since(first string) bool {
+	major, minor, ok := parseRelease(a.Release)
+	if !ok || first == "" {
+		return true
+	}
+	firstMajor, firstMinor, _ := parseRelease(first)
+	return major > firstMajor || major == firstMajor && minor >= firstMinor
+}
+
+// Supports returns true if the JIT of the architecture translates `insn`. A
+// nil profile supports every instruction.
+func (a *Arch) Supports(insn *pb.Instruction) bool {
+	if a == nil {
+		return true
+	}
+	if IsIsaV4Instruction(insn) && !a.since(a.IsaV4Since) {
+		return false
+	}
+	if name := Mnemonic(insn); strings.HasPrefix(name, "atomic_") && name != "atomic_add" && (!a.since(a.AtomicsSince) || a.XaddOnly()) {
+		return false
+	}
+	return true
+}
+
+// XaddOnly returns true if the kernel programs are generated for predates
+// BPF_ATOMIC, so atomic_add in its legacy BPF_XADD form is the only atomic
+// operation its verifier accepts. A nil profile targets the latest kernel.
+func (a *Arch) XaddOnly() bool {
+	return a != nil && !a.since(atomicOpsSince)
+}
+
+// FirstUnsupported returns the index of the first instruction of `prog` the
+// JIT does not translate, or -1 if it translates all of them.
+func (a *Arch) FirstUnsupported(prog *pb.Program) int {
+	for i, insn := range prog.GetInstructions() {
+		if !a.Supports(insn) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a *Arch) String() string {
+	if a.Release == "" {
+		return a.Name
+	}
+	return a.Name + " " + a.Release
+}
+
+// activeArch is the architecture the random instruction generators target.
+var activeArch *Arch
+
+// SetArch makes the random instruction generators avoid the instructions the
+// JIT of `a` does not translate. nil targets every instruction.
+func SetArch(a *Arch) {
+	activeArch = a
+}
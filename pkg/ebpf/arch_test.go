@@ -0,0 +1,81 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestArchSupports(t *testing.T) {
+	fetchOr := newAtomicInstruction(R10, R1, pb.StLdSize_StLdSizeDW, -8, int32(pb.AluOperationCode_AluOr)|atomicFetch)
+	tests := []struct {
+		testName    string
+		arch        string
+		release     string
+		instruction *pb.Instruction
+		want        bool
+	}{
+		{"Signed division on x86_64 6.6", "x86_64", "6.6.0", SDiv64(R1, R2), true},
+		{"Signed division on arm64 6.6", "arm64", "6.6.0", SDiv64(R1, R2), false},
+		{"Signed division on arm64 6.10", "arm64", "6.10.0-rc1", SDiv64(R1, R2), true},
+		{"Atomic fetch or on arm64 5.15", "arm64", "5.15.0-91-generic", fetchOr, false},
+		{"Atomic add on arm64 5.15", "arm64", "5.15.0-91-generic", MemAdd64(R10, R1, -8), true},
+		{"Atomic fetch or on s390x 5.15", "s390x", "5.15.0", fetchOr, true},
+		{"Atomic or on x86_64 5.10", "x86_64", "5.10.0", MemOr64(R10, R1, -8), false},
+		{"Xadd on x86_64 5.10", "x86_64", "5.10.0", Xadd64(R10, R1, -8), true},
+		{"Atomic or on x86_64 5.12", "x86_64", "5.12.0", MemOr64(R10, R1, -8), true},
+		{"Unknown release", "riscv64", "unknown", SDiv64(R1, R2), true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			a, err := LookupArch(tc.arch, tc.release)
+			if err != nil {
+				t.Fatalf("LookupArch() failed: %v", err)
+			}
+			if got := a.Supports(tc.instruction); got != tc.want {
+				t.Errorf("Supports() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	old := &Arch{Name: "mips", Release: "5.4.0"}
+	if !old.XaddOnly() {
+		t.Errorf("XaddOnly() of a profile for 5.4 = false, want true")
+	}
+	if _, err := LookupArch("mips", ""); err == nil {
+		t.Errorf("LookupArch() of an unknown architecture succeeded")
+	}
+	var a *Arch
+	if got := a.FirstUnsupported(&pb.Program{Instructions: []*pb.Instruction{SDiv64(R1, R2)}}); got != -1 {
+		t.Errorf("FirstUnsupported() of a nil profile = %d, want -1", got)
+	}
+}
+
+func TestRandomAtomicInstructionBefore512(t *testing.T) {
+	a, err := LookupArch("x86_64", "5.10.0")
+	if err != nil {
+		t.Fatalf("LookupArch() failed: %v", err)
+	}
+	SetArch(a)
+	defer SetArch(nil)
+	for i := 0; i < 100; i++ {
+		insn := RandomAtomicInstruction()
+		if name := Mnemonic(insn); name != "atomic_add" {
+			t.Fatalf("RandomAtomicInstruction() for 5.10 = %s, want atomic_add", name)
+		}
+	}
+}
@@ -0,0 +1,264 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"buzzer/pkg/logging/logging"
+	pb "buzzer/proto/ebpf_go_proto"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// Opcode of BPF_LD | BPF_IMM | BPF_DW, the first half of a wide load.
+	ldImm64Opcode = 0x18
+
+	// Value of src_reg that turns a wide load into a map fd load.
+	pseudoMapFd = 1
+
+	// Number of elements of the maps whose size was not provided.
+	defaultPocMapSize = 1
+)
+
+// cPocIsaV4Note is added to the header of the PoCs of programs that use v4
+// ISA instructions.
+const cPocIsaV4Note = "// The program uses v4 ISA instructions, it requires Linux 6.6 or later.\n"
+
+const cPocHeader = `// Reproducer generated by buzzer.
+//
+// Build with: gcc -o poc poc.c
+#include <linux/bpf.h>
+#include <stdint.h>
+#include <stdio.h>
+#include <string.h>
+#include <sys/socket.h>
+#include <sys/syscall.h>
+#include <unistd.h>
+
+// Wide load of a 64 bit constant, it takes two instructions.
+#define BPF_LD_IMM64(DST, IMM)                                       \
+  {.code = BPF_LD | BPF_DW | BPF_IMM, .dst_reg = DST,                 \
+   .imm = (int32_t)(uint32_t)(IMM)},                                   \
+  {.imm = (int32_t)(uint32_t)((uint64_t)(IMM) >> 32)}
+
+static int bpf(int cmd, union bpf_attr *attr) {
+  return syscall(__NR_bpf, cmd, attr, sizeof(*attr));
+}
+
+static int create_map(uint32_t max_entries) {
+  union bpf_attr attr;
+  memset(&attr, 0, sizeof(attr));
+  attr.map_type = BPF_MAP_TYPE_ARRAY;
+  attr.key_size = sizeof(uint32_t);
+  attr.value_size = sizeof(uint64_t);
+  attr.max_entries = max_entries;
+  return bpf(BPF_MAP_CREATE, &attr);
+}
+
+static void dump_map(int map_fd, uint32_t max_entries) {
+  for (uint32_t key = 0; key < max_entries; key++) {
+    uint64_t value = 0;
+    union bpf_attr attr;
+    memset(&attr, 0, sizeof(attr));
+    attr.map_fd = map_fd;
+    attr.key = (uint64_t)&key;
+    attr.value = (uint64_t)&value;
+    if (bpf(BPF_MAP_LOOKUP_ELEM, &attr) == 0) {
+      printf("map %d[%u] = 0x%llx\n", map_fd, key, (unsigned long long)value);
+    }
+  }
+}
+
+static char verifier_log[1 << 20];
+
+int main(void) {
+`
+
+const cPocFooter = `
+  union bpf_attr attr;
+  memset(&attr, 0, sizeof(attr));
+  attr.prog_type = BPF_PROG_TYPE_SOCKET_FILTER;
+  attr.insns = (uint64_t)prog;
+  attr.insn_cnt = sizeof(prog) / sizeof(prog[0]);
+  attr.license = (uint64_t) "GPL";
+  attr.log_buf = (uint64_t)verifier_log;
+  attr.log_size = sizeof(verifier_log);
+  attr.log_level = 1;
+  int prog_fd = bpf(BPF_PROG_LOAD, &attr);
+  printf("%s\n", verifier_log);
+  if (prog_fd < 0) {
+    perror("BPF_PROG_LOAD");
+    return 1;
+  }
+
+  int socks[2];
+  if (socketpair(AF_UNIX, SOCK_DGRAM, 0, socks) != 0) {
+    perror("socketpair");
+    return 1;
+  }
+  if (setsockopt(socks[0], SOL_SOCKET, SO_ATTACH_BPF, &prog_fd,
+                 sizeof(prog_fd)) < 0) {
+    perror("setsockopt");
+    return 1;
+  }
+  const unsigned char input[] = {INPUT};
+  if (write(socks[1], input, sizeof(input)) != sizeof(input)) {
+    perror("write");
+    return 1;
+  }
+  char output[sizeof(input)];
+  ssize_t received = recv(socks[0], output, sizeof(output), MSG_DONTWAIT);
+  printf("filter kept %zd of %zu bytes\n", received, sizeof(input));
+`
+
+// defaultPocInput is the packet sent by CPocSource.
+var defaultPocInput = []byte("buzzer\x00")
+
+// pocFooter returns the code that loads the program, attaches it and then
+// sends `packet` through the socket.
+func pocFooter(packet []byte) string {
+	bytes := make([]string, 0, len(packet))
+	for _, b := range packet {
+		bytes = append(bytes, fmt.Sprintf("0x%02x", b))
+	}
+	return strings.Replace(cPocFooter, "{INPUT}", "{"+strings.Join(bytes, ", ")+"}", 1)
+}
+
+// CPocSource returns the source of a standalone C program that loads
+// `program` as a socket filter, attaches it to a socket, triggers it and then
+// prints the contents of its maps.
+//
+// Every map fd referenced by the program is replaced by a new array map,
+// `mapSizes` gives the number of elements of each one of them indexed by the
+// fd the fuzzer used.
+func CPocSource(program *pb.Program, mapSizes map[int]uint64) (string, error) {
+	return SocketFilterCPocSource(program, mapSizes, defaultPocInput)
+}
+
+// SocketFilterCPocSource is like CPocSource, but the packet sent through the
+// socket is `packet`.
+func SocketFilterCPocSource(program *pb.Program, mapSizes map[int]uint64, packet []byte) (string, error) {
+	encoded, err := EncodeInstructionsFor(program, LittleEndian)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	header := cPocHeader
+	if UsesIsaV4(program) {
+		header = strings.Replace(header, "//\n", cPocIsaV4Note+"//\n", 1)
+	}
+	b.WriteString(header)
+	b.WriteString("  struct bpf_insn prog[] = {\n")
+
+	// Map fds in order of appearance and the slots that load them.
+	mapFds := []int{}
+	mapIndex := make(map[int]int)
+	mapLoads := [][2]int{}
+	for slot := 0; slot < len(encoded); slot++ {
+		insn := encoded[slot]
+		code := uint8(insn)
+		dst := uint8(insn>>8) & 0x0f
+		src := uint8(insn>>12) & 0x0f
+		off := int16(insn >> 16)
+		imm := int32(insn >> 32)
+		if code == ldImm64Opcode && src == 0 && slot+1 < len(encoded) {
+			value := uint64(uint32(imm)) | encoded[slot+1]&0xffffffff00000000
+			fmt.Fprintf(&b, "      BPF_LD_IMM64(%d, 0x%x), /* %d */\n", dst, value, slot)
+			slot++
+			continue
+		}
+		fmt.Fprintf(&b, "      {.code = 0x%02x, .dst_reg = %d, .src_reg = %d, .off = %d, .imm = %d}, /* %d */\n", code, dst, src, off, imm, slot)
+
+		if code == ldImm64Opcode && src == pseudoMapFd {
+			fd := int(imm)
+			if _, ok := mapIndex[fd]; !ok {
+				mapIndex[fd] = len(mapFds)
+				mapFds = append(mapFds, fd)
+			}
+			mapLoads = append(mapLoads, [2]int{slot, mapIndex[fd]})
+		}
+	}
+	b.WriteString("  };\n")
+
+	if len(mapFds) != 0 {
+		fmt.Fprintf(&b, "\n  int map_fds[%d];\n", len(mapFds))
+		fmt.Fprintf(&b, "  uint32_t map_sizes[%d] = {", len(mapFds))
+		for i, fd := range mapFds {
+			size, ok := mapSizes[fd]
+			if !ok {
+				size = defaultPocMapSize
+			}
+			if i != 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%d", size)
+		}
+		b.WriteString("};\n")
+		fmt.Fprintf(&b, "  for (int i = 0; i < %d; i++) {\n", len(mapFds))
+		b.WriteString("    map_fds[i] = create_map(map_sizes[i]);\n")
+		b.WriteString("    if (map_fds[i] < 0) {\n")
+		b.WriteString("      perror(\"BPF_MAP_CREATE\");\n")
+		b.WriteString("      return 1;\n")
+		b.WriteString("    }\n")
+		b.WriteString("  }\n")
+		for _, load := range mapLoads {
+			fmt.Fprintf(&b, "  prog[%d].imm = map_fds[%d];\n", load[0], load[1])
+		}
+	}
+
+	b.WriteString(pocFooter(packet))
+	if len(mapFds) != 0 {
+		fmt.Fprintf(&b, "\n  for (int i = 0; i < %d; i++) {\n", len(mapFds))
+		b.WriteString("    dump_map(map_fds[i], map_sizes[i]);\n")
+		b.WriteString("  }\n")
+	}
+	b.WriteString("  return 0;\n}\n")
+	return b.String(), nil
+}
+
+// GenerateCPoc writes the output of CPocSource to a temporary file and
+// returns its path.
+func GenerateCPoc(program *pb.Program, mapSizes map[int]uint64) (string, error) {
+	source, err := CPocSource(program, mapSizes)
+	if err != nil {
+		return "", err
+	}
+	return writeCPoc(source)
+}
+
+// GenerateSocketFilterCPoc writes the output of SocketFilterCPocSource to a
+// temporary file and returns its path.
+func GenerateSocketFilterCPoc(program *pb.Program, mapSizes map[int]uint64, packet []byte) (string, error) {
+	source, err := SocketFilterCPocSource(program, mapSizes, packet)
+	if err != nil {
+		return "", err
+	}
+	return writeCPoc(source)
+}
+
+// writeCPoc writes `source` to a temporary file and returns its path.
+func writeCPoc(source string) (string, error) {
+	f, err := os.CreateTemp("", "ebpf-poc-*.c")
+	if err != nil {
+		return "", err
+	}
+
+	logging.Infof("Writing C PoC %q.\n", f.Name())
+	_, err = f.Write([]byte(source))
+	return f.Name(), errors.Join(err, f.Close())
+}
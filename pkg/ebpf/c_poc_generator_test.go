@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"strings"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestCPocSource(t *testing.T) {
+	program := &pb.Program{
+		Instructions: []*pb.Instruction{
+			LdMapByFd(R1, 7),
+			Mov64(R0, 0),
+			LdMapByFd(R2, 7),
+			LdImm64(R3, 0x1122334455667788),
+			Exit(),
+		},
+	}
+
+	source, err := CPocSource(program, map[int]uint64{7: 4})
+	if err != nil {
+		t.Fatalf("CPocSource() = %v, want nil error", err)
+	}
+
+	for _, want := range []string{
+		"int map_fds[1];",
+		"uint32_t map_sizes[1] = {4};",
+		"prog[0].imm = map_fds[0];",
+		"prog[3].imm = map_fds[0];",
+		"{.code = 0xb7, .dst_reg = 0, .src_reg = 0, .off = 0, .imm = 0}, /* 2 */",
+		"BPF_LD_IMM64(3, 0x1122334455667788), /* 5 */",
+		"{.code = 0x95, .dst_reg = 0, .src_reg = 0, .off = 0, .imm = 0}, /* 7 */",
+		"BPF_PROG_TYPE_SOCKET_FILTER",
+		"SO_ATTACH_BPF",
+	} {
+		if !strings.Contains(source, want) {
+			t.Errorf("CPocSource() output does not contain %q", want)
+		}
+	}
+}
+
+func TestCPocSourceWithoutMaps(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{Mov64(R0, 0), Exit()}}
+
+	source, err := CPocSource(program, nil)
+	if err != nil {
+		t.Fatalf("CPocSource() = %v, want nil error", err)
+	}
+	if strings.Contains(source, "map_fds") {
+		t.Errorf("CPocSource() output creates maps for a program without maps")
+	}
+}
+
+func TestSocketFilterCPocSource(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{Mov64(R0, 0), Exit()}}
+
+	source, err := SocketFilterCPocSource(program, nil, []byte{0xde, 0xad, 0x01})
+	if err != nil {
+		t.Fatalf("SocketFilterCPocSource() = %v, want nil error", err)
+	}
+	if want := "const unsigned char input[] = {0xde, 0xad, 0x01};"; !strings.Contains(source, want) {
+		t.Errorf("SocketFilterCPocSource() output does not contain %q", want)
+	}
+}
+
+func TestCPocSourceIsaV4Note(t *testing.T) {
+	for _, tc := range []struct {
+		testName string
+		program  []*pb.Instruction
+		want     bool
+	}{
+		{"Classic instructions", []*pb.Instruction{Mov64(R0, 0), Exit()}, false},
+		{"Signed division", []*pb.Instruction{Mov64(R0, 7), SDiv64(R0, -2), Exit()}, true},
+	} {
+		t.Run(tc.testName, func(t *testing.T) {
+			source, err := CPocSource(&pb.Program{Instructions: tc.program}, nil)
+			if err != nil {
+				t.Fatalf("CPocSource() = %v, want nil error", err)
+			}
+			if got := strings.Contains(source, cPocIsaV4Note); got != tc.want {
+				t.Errorf("CPocSource() output contains the v4 ISA note = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// CallbackParam is what the verifier puts in a register of a callback when
+// the helper calls it.
+type CallbackParam int
+
+const (
+	// ParamScalar is a number, e.g. the index of the iteration.
+	ParamScalar CallbackParam = iota
+	// ParamMapPtr is the map the helper works on.
+	ParamMapPtr
+	// ParamMapKey is a read only pointer to the key of a map element.
+	ParamMapKey
+	// ParamMapValue is a pointer to the value of a map element.
+	ParamMapValue
+	// ParamCtx is the pointer, to the stack of the caller or 0, passed to
+	// the helper for the callback.
+	ParamCtx
+)
+
+// CallbackPrototype describes a helper that takes a callback, a static
+// function of the program the helper calls, and how the callback is called.
+// The callback must be described by the BTF the program is loaded with, see
+// btf.ForProgram.
+type CallbackPrototype struct {
+	// HelperID is the helper taking the callback, its ArgPtrToFunc argument.
+	HelperID int32
+
+	// Params are the registers, from R1, the callback is called with. The
+	// others are not initialized.
+	Params []CallbackParam
+
+	// MaxReturn is the highest value the callback can return, from 0. Only
+	// bpf_loop and bpf_for_each_map_elem look at it: 1 stops the iterations.
+	MaxReturn int32
+}
+
+// CallbackPrototypes contains the helpers taking a callback buzzer knows
+// about.
+var CallbackPrototypes = []*CallbackPrototype{
+	{HelperID: Loop, Params: []CallbackParam{ParamScalar, ParamCtx}, MaxReturn: 1},
+	{HelperID: ForEachMapElem, Params: []CallbackParam{ParamMapPtr, ParamMapKey, ParamMapValue, ParamCtx}, MaxReturn: 1},
+	{HelperID: TimerSetCallback, Params: []CallbackParam{ParamMapPtr, ParamMapKey, ParamMapValue}, MaxReturn: 0},
+}
+
+// CallbackPrototypeByID returns the prototype of the callback of the helper
+// `id` or nil if the helper doesn't take one.
+func CallbackPrototypeByID(id int32) *CallbackPrototype {
+	for _, cp := range CallbackPrototypes {
+		if cp.HelperID == id {
+			return cp
+		}
+	}
+	return nil
+}
+
+// Helper returns the prototype of the helper taking the callback.
+func (cp *CallbackPrototype) Helper() *HelperPrototype {
+	return HelperPrototypeByID(cp.HelperID)
+}
+
+// ParamReg returns the register holding the first parameter `param` of the
+// callback and true, or false if the callback has none.
+func (cp *CallbackPrototype) ParamReg(param CallbackParam) (pb.Reg, bool) {
+	for i, p := range cp.Params {
+		if p == param {
+			return R1 + pb.Reg(i), true
+		}
+	}
+	return 0, false
+}
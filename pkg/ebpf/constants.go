@@ -23,7 +23,11 @@ const (
 )
 
 const (
-	PseudoMapFD = pb.Reg_R1
+	PseudoMapFD     = pb.Reg_R1
+	PseudoMapValue  = pb.Reg_R2
+	PseudoCall      = pb.Reg_R1
+	PseudoKfuncCall = pb.Reg_R2
+	PseudoFunc      = pb.Reg_R4
 )
 
 const (
@@ -44,5 +48,41 @@ const (
 	// ebpf helper function codes
 	// MapLookup Map Lookup helper function.
 	MapLookup            = 0x01
+	MapUpdate            = 0x02
+	MapDelete            = 0x03
+	ProbeRead            = 0x04
+	KtimeGetNs           = 0x05
+	GetPrandomU32        = 0x07
+	GetSmpProcessorId    = 0x08
+	TailCall             = 0x0c
+	SkbLoadBytes         = 0x1a
+	GetCurrentTask       = 0x23
+	GetNumaNodeId        = 0x2a
+	ProbeReadStr         = 0x2d
+	GetSocketCookie      = 0x2e
+	GetSocketUid         = 0x2f
 	SkbLoadBytesRelative = 0x44
+	SkLookupTcp          = 0x54
+	SkLookupUdp          = 0x55
+	SkRelease            = 0x56
+	SpinLock             = 0x5d
+	SpinUnlock           = 0x5e
+	SkcLookupTcp         = 0x63
+	ProbeReadUser        = 0x70
+	ProbeReadKernel      = 0x71
+	ProbeReadUserStr     = 0x72
+	ProbeReadKernelStr   = 0x73
+	Jiffies64            = 0x76
+	KtimeGetBootNs       = 0x7d
+	RingbufOutput        = 0x82
+	RingbufReserve       = 0x83
+	RingbufSubmit        = 0x84
+	RingbufDiscard       = 0x85
+	CopyFromUser         = 0x94
+	ForEachMapElem       = 0xa4
+	TimerInit            = 0xa9
+	TimerSetCallback     = 0xaa
+	TimerStart           = 0xab
+	TimerCancel          = 0xac
+	Loop                 = 0xb5
 )
@@ -0,0 +1,118 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+)
+
+var (
+	TruncatedWideInstruction = fmt.Errorf("Wide instruction without a second half")
+)
+
+// wideLoadOpcode is the opcode of the only instruction that takes two slots,
+// the 64 bit immediate load.
+const wideLoadOpcode = uint8(pb.StLdMode_StLdModeIMM) | uint8(pb.StLdSize_StLdSizeDW) | uint8(pb.InsClass_InsClassLd)
+
+// DecodeInstructions transforms ebpf bytecode back to a program, it is the
+// inverse of EncodeInstructions. The bytecode can come from the kernel, e.g.
+// the xlated instructions of a loaded program.
+func DecodeInstructions(encoded []uint64) (*pb.Program, error) {
+	return DecodeInstructionsFor(encoded, HostEndianness())
+}
+
+// DecodeInstructionsFor is DecodeInstructions for bytecode of a machine of
+// endianness `e`, the inverse of EncodeInstructionsFor.
+func DecodeInstructionsFor(encoded []uint64, e Endianness) (*pb.Program, error) {
+	slot := func(i int) uint64 {
+		if e == BigEndian {
+			return fromBigEndian(encoded[i])
+		}
+		return encoded[i]
+	}
+	program := &pb.Program{}
+	for i := 0; i < len(encoded); i++ {
+		insn := decodeInstruction(slot(i))
+		if uint8(slot(i)) == wideLoadOpcode {
+			if i+1 >= len(encoded) {
+				return nil, fmt.Errorf("%w at slot %d", TruncatedWideInstruction, i)
+			}
+			i++
+			insn.PseudoInstruction = &pb.Instruction_PseudoValue{
+				PseudoValue: decodeInstruction(slot(i)),
+			}
+		}
+		program.Instructions = append(program.Instructions, insn)
+	}
+	return program, nil
+}
+
+// DecodeInstruction transforms a single slot of ebpf bytecode for the kernel
+// of the host back to its instruction, the inverse of encoding it alone. The
+// 64 bit immediate load takes two slots, decode it with DecodeInstructions.
+func DecodeInstruction(encoding uint64) (*pb.Instruction, error) {
+	if HostEndianness() == BigEndian {
+		encoding = fromBigEndian(encoding)
+	}
+	if uint8(encoding) == wideLoadOpcode {
+		return nil, TruncatedWideInstruction
+	}
+	return decodeInstruction(encoding), nil
+}
+
+// decodeInstruction decodes a single little-endian slot, see
+// encodeInstruction.
+func decodeInstruction(encoding uint64) *pb.Instruction {
+	opcode := uint8(encoding)
+	insClass := pb.InsClass(opcode & 0x07)
+
+	i := &pb.Instruction{
+		DstReg:    pb.Reg((encoding >> 8) & 0x0F),
+		SrcReg:    pb.Reg((encoding >> 12) & 0x0F),
+		Offset:    int32(int16(encoding >> 16)),
+		Immediate: int32(encoding >> 32),
+		PseudoInstruction: &pb.Instruction_Empty{
+			Empty: &pb.Empty{},
+		},
+	}
+	switch insClass {
+	case pb.InsClass_InsClassAlu, pb.InsClass_InsClassAlu64:
+		i.Opcode = &pb.Instruction_AluOpcode{
+			AluOpcode: &pb.AluOpcode{
+				OperationCode:    pb.AluOperationCode(opcode & 0xF0),
+				Source:           pb.SrcOperand(opcode & 0x08),
+				InstructionClass: insClass,
+			},
+		}
+	case pb.InsClass_InsClassJmp, pb.InsClass_InsClassJmp32:
+		i.Opcode = &pb.Instruction_JmpOpcode{
+			JmpOpcode: &pb.JmpOpcode{
+				OperationCode:    pb.JmpOperationCode(opcode & 0xF0),
+				Source:           pb.SrcOperand(opcode & 0x08),
+				InstructionClass: insClass,
+			},
+		}
+	default:
+		i.Opcode = &pb.Instruction_MemOpcode{
+			MemOpcode: &pb.MemOpcode{
+				Mode:             pb.StLdMode(opcode & 0xE0),
+				Size:             pb.StLdSize(opcode & 0x18),
+				InstructionClass: insClass,
+			},
+		}
+	}
+	return i
+}
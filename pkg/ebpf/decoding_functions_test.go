@@ -0,0 +1,159 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+// TestDecodeGoldenEncodings checks that the encodings of the golden file
+// decode back to their instructions.
+func TestDecodeGoldenEncodings(t *testing.T) {
+	for name, golden := range readGoldenEncodings(t) {
+		t.Run(name, func(t *testing.T) {
+			got, err := DecodeInstructions(golden.Encoding)
+			if err != nil {
+				t.Fatalf("DecodeInstructions() = %v, want nil error", err)
+			}
+			if len(got.Instructions) != 1 || !protobuf.Equal(got.Instructions[0], golden.Instruction) {
+				t.Errorf("DecodeInstructions() = %v, want %v", got.Instructions, golden.Instruction)
+			}
+		})
+	}
+}
+
+func TestDecodeInstructions(t *testing.T) {
+	tests := []struct {
+		testName string
+		program  []*pb.Instruction
+	}{
+		{
+			testName: "Map lookup",
+			program: []*pb.Instruction{
+				StW(R10, 0, -4),
+				LdMapByFd(R1, 3),
+				Mov64(R2, R10),
+				Add64(R2, -4),
+				Call(MapLookup),
+				JmpNE(R0, 0, 1),
+				Exit(),
+				LdDW(R1, R0, 0),
+				Exit(),
+			},
+		},
+		{
+			testName: "Wide immediate and negative offsets",
+			program: []*pb.Instruction{
+				Mov64(R1, int64(-0x1122334455667788)),
+				StDW(R10, R1, -512),
+				Jmp(-3),
+				Mov(R0, 0),
+				Exit(),
+			},
+		},
+	}
+
+	for _, c := range tests {
+		want := &pb.Program{Instructions: c.program}
+		encoded, err := EncodeInstructions(want)
+		if err != nil {
+			t.Fatalf("%s: EncodeInstructions() = %v", c.testName, err)
+		}
+		got, err := DecodeInstructions(encoded)
+		if err != nil {
+			t.Errorf("%s: DecodeInstructions() = %v, want nil error", c.testName, err)
+			continue
+		}
+		if !protobuf.Equal(got, want) {
+			t.Errorf("%s: DecodeInstructions() = %v, want %v", c.testName, got, want)
+		}
+	}
+}
+
+func TestDecodeTruncatedWideInstruction(t *testing.T) {
+	encoded, err := EncodeInstructions(&pb.Program{Instructions: []*pb.Instruction{LdMapByFd(R1, 3)}})
+	if err != nil {
+		t.Fatalf("EncodeInstructions() = %v", err)
+	}
+	if _, err := DecodeInstructions(encoded[:1]); !errors.Is(err, TruncatedWideInstruction) {
+		t.Errorf("DecodeInstructions() = %v, want %v", err, TruncatedWideInstruction)
+	}
+}
+
+// TestEncodeDecodeRoundTrip checks that every slot decodes to an instruction
+// that encodes back to it, the decoder and the encoder pack the fields of the
+// slot in the same places.
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100000; i++ {
+		encoding := r.Uint64()
+		insn, err := DecodeInstruction(encoding)
+		if uint8(encoding) == wideLoadOpcode {
+			if !errors.Is(err, TruncatedWideInstruction) {
+				t.Fatalf("DecodeInstruction(%#016x) = %v, want %v", encoding, err, TruncatedWideInstruction)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("DecodeInstruction(%#016x) = %v, want nil error", encoding, err)
+		}
+		got, err := EncodeInstructions(&pb.Program{Instructions: []*pb.Instruction{insn}})
+		if err != nil {
+			t.Fatalf("EncodeInstructions(DecodeInstruction(%#016x)) = %v, want nil error", encoding, err)
+		}
+		if len(got) != 1 || got[0] != encoding {
+			t.Fatalf("EncodeInstructions(DecodeInstruction(%#016x)) = %#016x, want the same slot back", encoding, got)
+		}
+	}
+}
+
+// TestEncodeDecodeProgramRoundTrip is TestEncodeDecodeRoundTrip for pairs of
+// slots, which can hold a 64 bit immediate load, laid out for either
+// endianness.
+func TestEncodeDecodeProgramRoundTrip(t *testing.T) {
+	for _, e := range []Endianness{LittleEndian, BigEndian} {
+		r := rand.New(rand.NewSource(1))
+		for i := 0; i < 10000; i++ {
+			// Half of the pairs are 64 bit immediate loads, the others
+			// can't end with the first half of one.
+			first, second := r.Uint64(), r.Uint64()
+			if i%2 == 0 {
+				first = first&^0xff | uint64(wideLoadOpcode)
+			} else if uint8(second) == wideLoadOpcode {
+				second ^= 1
+			}
+			encoded := []uint64{first, second}
+			if e == BigEndian {
+				encoded = []uint64{toBigEndian(encoded[0]), toBigEndian(encoded[1])}
+			}
+			prog, err := DecodeInstructionsFor(encoded, e)
+			if err != nil {
+				t.Fatalf("DecodeInstructionsFor(%#016x, %v) = %v, want nil error", encoded, e, err)
+			}
+			got, err := EncodeInstructionsFor(prog, e)
+			if err != nil {
+				t.Fatalf("EncodeInstructionsFor(DecodeInstructionsFor(%#016x, %v)) = %v, want nil error", encoded, e, err)
+			}
+			if len(got) != 2 || got[0] != encoded[0] || got[1] != encoded[1] {
+				t.Fatalf("EncodeInstructionsFor(DecodeInstructionsFor(%#016x, %v)) = %#016x, want the same slots back", encoded, e, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,270 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+	"strings"
+)
+
+// The strings below follow kernel/bpf/disasm.c, which is what both the
+// verifier log and `bpftool prog dump xlated` use.
+var aluOpStrings = map[pb.AluOperationCode]string{
+	pb.AluOperationCode_AluAdd:  "+=",
+	pb.AluOperationCode_AluSub:  "-=",
+	pb.AluOperationCode_AluMul:  "*=",
+	pb.AluOperationCode_AluDiv:  "/=",
+	pb.AluOperationCode_AluOr:   "|=",
+	pb.AluOperationCode_AluAnd:  "&=",
+	pb.AluOperationCode_AluLsh:  "<<=",
+	pb.AluOperationCode_AluRsh:  ">>=",
+	pb.AluOperationCode_AluMod:  "%=",
+	pb.AluOperationCode_AluXor:  "^=",
+	pb.AluOperationCode_AluMov:  "=",
+	pb.AluOperationCode_AluArsh: "s>>=",
+}
+
+// signedAluOpStrings are the operations whose signed variant is selected by
+// an offset of 1.
+var signedAluOpStrings = map[pb.AluOperationCode]string{
+	pb.AluOperationCode_AluDiv: "s/=",
+	pb.AluOperationCode_AluMod: "s%=",
+}
+
+var atomicOpStrings = map[int32]string{
+	int32(pb.AluOperationCode_AluAdd): "add",
+	int32(pb.AluOperationCode_AluAnd): "and",
+	int32(pb.AluOperationCode_AluOr):  "or",
+	int32(pb.AluOperationCode_AluXor): "xor",
+}
+
+var jmpOpStrings = map[pb.JmpOperationCode]string{
+	pb.JmpOperationCode_JmpJEQ:  "==",
+	pb.JmpOperationCode_JmpJGT:  ">",
+	pb.JmpOperationCode_JmpJGE:  ">=",
+	pb.JmpOperationCode_JmpJSET: "&",
+	pb.JmpOperationCode_JmpJNE:  "!=",
+	pb.JmpOperationCode_JmpJSGT: "s>",
+	pb.JmpOperationCode_JmpJSGE: "s>=",
+	pb.JmpOperationCode_JmpJLT:  "<",
+	pb.JmpOperationCode_JmpJLE:  "<=",
+	pb.JmpOperationCode_JmpJSLT: "s<",
+	pb.JmpOperationCode_JmpJSLE: "s<=",
+}
+
+var sizeStrings = map[pb.StLdSize]string{
+	pb.StLdSize_StLdSizeB:  "u8",
+	pb.StLdSize_StLdSizeH:  "u16",
+	pb.StLdSize_StLdSizeW:  "u32",
+	pb.StLdSize_StLdSizeDW: "u64",
+}
+
+var signedSizeStrings = map[pb.StLdSize]string{
+	pb.StLdSize_StLdSizeB:  "s8",
+	pb.StLdSize_StLdSizeH:  "s16",
+	pb.StLdSize_StLdSizeW:  "s32",
+	pb.StLdSize_StLdSizeDW: "s64",
+}
+
+const (
+	// Atomic operations that are not plain ALU operations, see
+	// include/uapi/linux/bpf.h.
+	atomicFetch   = 0x01
+	atomicXchg    = 0xe0 | atomicFetch
+	atomicCmpXchg = 0xf0 | atomicFetch
+)
+
+// regName returns the name of `reg` as a 64 bit (rX) or 32 bit (wX) register.
+func regName(reg pb.Reg, is64 bool) string {
+	if is64 {
+		return fmt.Sprintf("r%d", reg)
+	}
+	return fmt.Sprintf("w%d", reg)
+}
+
+func disassembleAlu(i *pb.Instruction, op *pb.AluOpcode) (string, error) {
+	is64 := op.InstructionClass == pb.InsClass_InsClassAlu64
+	dst := regName(i.DstReg, is64)
+	switch op.OperationCode {
+	case pb.AluOperationCode_AluNeg:
+		return fmt.Sprintf("%s = -%s", dst, dst), nil
+	case pb.AluOperationCode_AluEnd:
+		if is64 {
+			return fmt.Sprintf("r%d = bswap%d r%d", i.DstReg, i.Immediate, i.DstReg), nil
+		}
+		endianness := "le"
+		if op.Source == pb.SrcOperand_RegSrc {
+			endianness = "be"
+		}
+		return fmt.Sprintf("r%d = %s%d r%d", i.DstReg, endianness, i.Immediate, i.DstReg), nil
+	}
+
+	opString, ok := aluOpStrings[op.OperationCode]
+	if IsSignedAluInstruction(i) {
+		opString = signedAluOpStrings[op.OperationCode]
+	}
+	if !ok {
+		return "", fmt.Errorf("unknown alu operation %v", op.OperationCode)
+	}
+	if op.Source == pb.SrcOperand_RegSrc {
+		return fmt.Sprintf("%s %s %s", dst, opString, regName(i.SrcReg, is64)), nil
+	}
+	return fmt.Sprintf("%s %s %d", dst, opString, i.Immediate), nil
+}
+
+func disassembleJmp(i *pb.Instruction, op *pb.JmpOpcode) (string, error) {
+	switch op.OperationCode {
+	case pb.JmpOperationCode_JmpCALL:
+		if i.SrcReg == PseudoCall {
+			return fmt.Sprintf("call pc%+d", i.Immediate), nil
+		}
+		if i.SrcReg == PseudoKfuncCall {
+			return fmt.Sprintf("call kfunc#%d", i.Immediate), nil
+		}
+		name := "unknown"
+		if hp := HelperPrototypeByID(i.Immediate); hp != nil {
+			name = "bpf_" + hp.Name
+		}
+		return fmt.Sprintf("call %s#%d", name, i.Immediate), nil
+	case pb.JmpOperationCode_JmpExit:
+		return "exit", nil
+	case pb.JmpOperationCode_JmpJA:
+		if IsLongJump(i) {
+			return fmt.Sprintf("gotol pc%+d", i.Immediate), nil
+		}
+		return fmt.Sprintf("goto pc%+d", i.Offset), nil
+	}
+
+	opString, ok := jmpOpStrings[op.OperationCode]
+	if !ok {
+		return "", fmt.Errorf("unknown jmp operation %v", op.OperationCode)
+	}
+	is64 := op.InstructionClass == pb.InsClass_InsClassJmp
+	dst := regName(i.DstReg, is64)
+	if op.Source == pb.SrcOperand_RegSrc {
+		return fmt.Sprintf("if %s %s %s goto pc%+d", dst, opString, regName(i.SrcReg, is64), i.Offset), nil
+	}
+	return fmt.Sprintf("if %s %s 0x%x goto pc%+d", dst, opString, uint32(i.Immediate), i.Offset), nil
+}
+
+func disassembleAtomic(i *pb.Instruction, op *pb.MemOpcode) (string, error) {
+	is64 := op.Size == pb.StLdSize_StLdSizeDW
+	suffix := ""
+	if is64 {
+		suffix = "64"
+	}
+	mem := fmt.Sprintf("(%s *)(r%d %+d)", sizeStrings[op.Size], i.DstReg, i.Offset)
+	src := regName(i.SrcReg, is64)
+
+	switch i.Immediate {
+	case atomicXchg:
+		return fmt.Sprintf("%s = atomic%s_xchg(%s, %s)", src, suffix, mem, src), nil
+	case atomicCmpXchg:
+		return fmt.Sprintf("%s = atomic%s_cmpxchg(%s, %s, %s)", regName(R0, is64), suffix, mem, regName(R0, is64), src), nil
+	}
+
+	opString, ok := atomicOpStrings[i.Immediate&^atomicFetch]
+	if !ok {
+		return "", fmt.Errorf("unknown atomic operation 0x%x", i.Immediate)
+	}
+	if i.Immediate&atomicFetch != 0 {
+		return fmt.Sprintf("%s = atomic%s_fetch_%s(%s, %s)", src, suffix, opString, mem, src), nil
+	}
+	return fmt.Sprintf("lock *%s %s %s", mem, aluOpStrings[pb.AluOperationCode(i.Immediate)], src), nil
+}
+
+func disassembleMem(i *pb.Instruction, op *pb.MemOpcode) (string, error) {
+	size := sizeStrings[op.Size]
+	switch op.InstructionClass {
+	case pb.InsClass_InsClassLd:
+		switch op.Mode {
+		case pb.StLdMode_StLdModeIMM:
+			pseudo, ok := i.PseudoInstruction.(*pb.Instruction_PseudoValue)
+			if !ok {
+				return "", fmt.Errorf("wide load without a second half")
+			}
+			if i.SrcReg == PseudoMapFD {
+				return fmt.Sprintf("r%d = map[fd:%d]", i.DstReg, i.Immediate), nil
+			}
+			if i.SrcReg == PseudoMapValue {
+				return fmt.Sprintf("r%d = map[fd:%d][0]%+d", i.DstReg, i.Immediate, pseudo.PseudoValue.Immediate), nil
+			}
+			if i.SrcReg == PseudoFunc {
+				return fmt.Sprintf("r%d = func[%+d]", i.DstReg, i.Immediate), nil
+			}
+			imm := uint64(uint32(i.Immediate)) | uint64(uint32(pseudo.PseudoValue.Immediate))<<32
+			return fmt.Sprintf("r%d = 0x%x", i.DstReg, imm), nil
+		case pb.StLdMode_StLdModeABS:
+			return fmt.Sprintf("r0 = *(%s *)skb[%d]", size, i.Immediate), nil
+		case pb.StLdMode_StLdModeIND:
+			return fmt.Sprintf("r0 = *(%s *)skb[r%d + %d]", size, i.SrcReg, i.Immediate), nil
+		}
+	case pb.InsClass_InsClassLdx:
+		if op.Mode == pb.StLdMode_StLdModeMEMSX {
+			size = signedSizeStrings[op.Size]
+		}
+		return fmt.Sprintf("r%d = *(%s *)(r%d %+d)", i.DstReg, size, i.SrcReg, i.Offset), nil
+	case pb.InsClass_InsClassSt:
+		return fmt.Sprintf("*(%s *)(r%d %+d) = %d", size, i.DstReg, i.Offset, i.Immediate), nil
+	case pb.InsClass_InsClassStx:
+		if op.Mode == pb.StLdMode_StLdModeATOMIC {
+			return disassembleAtomic(i, op)
+		}
+		return fmt.Sprintf("*(%s *)(r%d %+d) = r%d", size, i.DstReg, i.Offset, i.SrcReg), nil
+	}
+	return "", fmt.Errorf("unknown memory instruction mode %v class %v", op.Mode, op.InstructionClass)
+}
+
+// DisassembleInstruction returns the textual representation of `i`, without
+// the instruction index and opcode prefix.
+func DisassembleInstruction(i *pb.Instruction) (string, error) {
+	switch c := i.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		return disassembleAlu(i, c.AluOpcode)
+	case *pb.Instruction_JmpOpcode:
+		return disassembleJmp(i, c.JmpOpcode)
+	case *pb.Instruction_MemOpcode:
+		return disassembleMem(i, c.MemOpcode)
+	default:
+		return "", UnknownOpcodeType
+	}
+}
+
+// Disassemble returns a human readable listing of `program` in the same
+// format as `bpftool prog dump xlated`, e.g.
+//
+//	0: (b7) r0 = 0
+//	1: (79) r1 = *(u64 *)(r10 -8)
+//
+// Instructions are numbered by their position in the encoded program, so
+// wide instructions take two indexes like they do in the verifier log.
+func Disassemble(program *pb.Program) (string, error) {
+	var b strings.Builder
+	index := 0
+	for _, insn := range program.Instructions {
+		encoding, err := encodeInstruction(insn, LittleEndian)
+		if err != nil {
+			return "", err
+		}
+		text, err := DisassembleInstruction(insn)
+		if err != nil {
+			return "", fmt.Errorf("instruction %d: %w", index, err)
+		}
+		fmt.Fprintf(&b, "%4d: (%02x) %s\n", index, uint8(encoding[0]), text)
+		index += len(encoding)
+	}
+	return b.String(), nil
+}
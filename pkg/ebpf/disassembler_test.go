@@ -0,0 +1,87 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestDisassembleInstruction(t *testing.T) {
+	tests := []struct {
+		testName    string
+		instruction *pb.Instruction
+		want        string
+	}{
+		{"Mov64 imm", Mov64(R0, 0), "r0 = 0"},
+		{"Add64 reg", Add64(R1, R2), "r1 += r2"},
+		{"Alu32 imm", Sub(R3, -5), "w3 -= -5"},
+		{"Arsh64", Arsh64(R4, 3), "r4 s>>= 3"},
+		{"Neg64", Neg64(R5, 0), "r5 = -r5"},
+		{"To little endian", ToLe(R1, 16), "r1 = le16 r1"},
+		{"To big endian", ToBe(R1, 32), "r1 = be32 r1"},
+		{"Byte swap", Bswap(R1, 64), "r1 = bswap64 r1"},
+		{"Signed division", SDiv64(R1, R2), "r1 s/= r2"},
+		{"Signed modulo", SMod(R1, -3), "w1 s%= -3"},
+		{"Sign extending load", LdSXH(R1, R10, -2), "r1 = *(s16 *)(r10 -2)"},
+		{"Load", LdDW(R1, R10, -8), "r1 = *(u64 *)(r10 -8)"},
+		{"Store imm", StW(R10, 4, -4), "*(u32 *)(r10 -4) = 4"},
+		{"Store reg", StDW(R10, R1, -16), "*(u64 *)(r10 -16) = r1"},
+		{"Atomic add", MemAdd64(R1, R2, 0), "lock *(u64 *)(r1 +0) += r2"},
+		{"Map fd", LdMapByFd(R1, 7), "r1 = map[fd:7]"},
+		{"Map value", LdMapValue(R2, 7, 16), "r2 = map[fd:7][0]+16"},
+		{"Packet load abs", LdAbsH(12), "r0 = *(u16 *)skb[12]"},
+		{"Packet load ind", LdIndB(R7, 9), "r0 = *(u8 *)skb[r7 + 9]"},
+		{"Jump imm", JmpEQ(R0, 0, 3), "if r0 == 0x0 goto pc+3"},
+		{"Jump32 reg", JmpSGT32(R1, R2, -2), "if w1 s> w2 goto pc-2"},
+		{"Goto", Jmp(1), "goto pc+1"},
+		{"Long goto", Gotol(-40000), "gotol pc-40000"},
+		{"Helper call", Call(MapLookup), "call bpf_map_lookup_elem#1"},
+		{"Kfunc call", CallKfunc(1234), "call kfunc#1234"},
+		{"Exit", Exit(), "exit"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := DisassembleInstruction(tc.instruction)
+			if err != nil {
+				t.Fatalf("DisassembleInstruction() = %v, want nil error", err)
+			}
+			if got != tc.want {
+				t.Errorf("DisassembleInstruction() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	program := &pb.Program{
+		Instructions: []*pb.Instruction{
+			LdMapByFd(R1, 3),
+			Mov64(R0, 0),
+			Exit(),
+		},
+	}
+	want := "   0: (18) r1 = map[fd:3]\n   2: (b7) r0 = 0\n   3: (95) exit\n"
+
+	got, err := Disassemble(program)
+	if err != nil {
+		t.Fatalf("Disassemble() = %v, want nil error", err)
+	}
+	if got != want {
+		t.Errorf("Disassemble() = %q, want %q", got, want)
+	}
+}
@@ -58,11 +58,27 @@ func encodeMemOpcode(op *pb.MemOpcode) (uint8, error) {
 	return opcode, nil
 }
 
-// EncodeInstructions transforms the given array to ebpf bytecode.
+// EncodeInstructions transforms the given array to ebpf bytecode for the
+// kernel of the host. Jumps whose offsets don't fit in 16 bits are promoted
+// to long jumps first.
 func EncodeInstructions(program *pb.Program) ([]uint64, error) {
+	return EncodeInstructionsFor(program, HostEndianness())
+}
+
+// EncodeInstructionsFor is EncodeInstructions for a machine of endianness
+// `e`. The slots are meant to be stored in the byte order of `e`, tools that
+// pick the fields of little-endian slots apart, e.g. the object file writer,
+// encode for LittleEndian regardless of the host.
+func EncodeInstructionsFor(program *pb.Program, e Endianness) ([]uint64, error) {
+	if hasFarJumps(program) {
+		var err error
+		if program, err = PromoteLongJumps(program); err != nil {
+			return nil, err
+		}
+	}
 	result := []uint64{}
 	for _, instruction := range program.Instructions {
-		encoding, err := encodeInstruction(instruction)
+		encoding, err := encodeInstruction(instruction, e)
 		if err != nil {
 			return nil, err
 		}
@@ -72,8 +88,8 @@ func EncodeInstructions(program *pb.Program) ([]uint64, error) {
 }
 
 // To understand what each part of the encoding mean, please refer to
-// http://shortn/_mFOBeQLg2s.
-func encodeInstruction(i *pb.Instruction) ([]uint64, error) {
+// http://shortn/_mFOBeQLg2s. The slots are laid out for endianness `e`.
+func encodeInstruction(i *pb.Instruction, e Endianness) ([]uint64, error) {
 	encoding := uint64(0)
 
 	opcode := uint8(0)
@@ -121,12 +137,16 @@ func encodeInstruction(i *pb.Instruction) ([]uint64, error) {
 
 	encoding |= (uint64(i.Immediate) << 32)
 
+	if e == BigEndian {
+		encoding = toBigEndian(encoding)
+	}
+
 	result := []uint64{encoding}
 	switch p := i.PseudoInstruction.(type) {
 	// For instructions requiring wide encoding, like 64-bit immediates, we
 	// use PseudoValue
 	case *pb.Instruction_PseudoValue:
-		resultPseudoValue, err := encodeInstruction(p.PseudoValue)
+		resultPseudoValue, err := encodeInstruction(p.PseudoValue, e)
 		if err != nil {
 			return nil, err
 		}
@@ -137,10 +157,8 @@ func encodeInstruction(i *pb.Instruction) ([]uint64, error) {
 
 // GetBpfFuncName returns the C macro name of the provided bpf helper function.
 func GetBpfFuncName(funcNumber int32) string {
-	switch funcNumber {
-	case MapLookup:
-		return "BPF_FUNC_map_lookup_elem"
-	default:
-		return "unknown"
+	if hp := HelperPrototypeByID(funcNumber); hp != nil {
+		return "BPF_FUNC_" + hp.Name
 	}
+	return "unknown"
 }
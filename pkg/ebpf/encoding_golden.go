@@ -0,0 +1,247 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/golang/protobuf/jsonpb"
+)
+
+// GoldenEncoding is an instruction along with the slots it is expected to be
+// encoded into.
+type GoldenEncoding struct {
+	Name        string
+	Instruction *pb.Instruction
+	Encoding    []uint64
+}
+
+// goldenEncodingJSON is the on disk format of a GoldenEncoding, the encoding
+// is kept as hex strings so it is readable and safe from float conversions.
+type goldenEncodingJSON struct {
+	Name        string          `json:"name"`
+	Instruction json.RawMessage `json:"instruction"`
+	Encoding    []string        `json:"encoding"`
+}
+
+// goldenBuilders returns one instruction per builder and source operand
+// type, named after the builder.
+func goldenBuilders() []GoldenEncoding {
+	cases := []GoldenEncoding{
+		{Name: "Add64 imm", Instruction: Add64(R1, -7)},
+		{Name: "Add64 reg", Instruction: Add64(R1, R2)},
+		{Name: "Add imm", Instruction: Add(R1, 7)},
+		{Name: "Add reg", Instruction: Add(R1, R2)},
+		{Name: "Sub64 imm", Instruction: Sub64(R3, 0x7fffffff)},
+		{Name: "Sub64 reg", Instruction: Sub64(R3, R4)},
+		{Name: "Sub imm", Instruction: Sub(R3, -1)},
+		{Name: "Sub reg", Instruction: Sub(R3, R4)},
+		{Name: "Mul64 imm", Instruction: Mul64(R5, 3)},
+		{Name: "Mul64 reg", Instruction: Mul64(R5, R6)},
+		{Name: "Mul imm", Instruction: Mul(R5, 3)},
+		{Name: "Mul reg", Instruction: Mul(R5, R6)},
+		{Name: "Div64 imm", Instruction: Div64(R7, 2)},
+		{Name: "Div64 reg", Instruction: Div64(R7, R8)},
+		{Name: "Div imm", Instruction: Div(R7, 2)},
+		{Name: "Div reg", Instruction: Div(R7, R8)},
+		{Name: "Or64 imm", Instruction: Or64(R9, 0x100)},
+		{Name: "Or64 reg", Instruction: Or64(R9, R0)},
+		{Name: "Or imm", Instruction: Or(R9, 0x100)},
+		{Name: "Or reg", Instruction: Or(R9, R0)},
+		{Name: "And64 imm", Instruction: And64(R0, 0xff)},
+		{Name: "And64 reg", Instruction: And64(R0, R1)},
+		{Name: "And imm", Instruction: And(R0, 0xff)},
+		{Name: "And reg", Instruction: And(R0, R1)},
+		{Name: "Lsh64 imm", Instruction: Lsh64(R2, 63)},
+		{Name: "Lsh64 reg", Instruction: Lsh64(R2, R3)},
+		{Name: "Lsh imm", Instruction: Lsh(R2, 31)},
+		{Name: "Lsh reg", Instruction: Lsh(R2, R3)},
+		{Name: "Rsh64 imm", Instruction: Rsh64(R4, 1)},
+		{Name: "Rsh64 reg", Instruction: Rsh64(R4, R5)},
+		{Name: "Rsh imm", Instruction: Rsh(R4, 1)},
+		{Name: "Rsh reg", Instruction: Rsh(R4, R5)},
+		{Name: "Neg64", Instruction: Neg64(R6, 0)},
+		{Name: "Neg", Instruction: Neg(R6, 0)},
+		{Name: "Mod64 imm", Instruction: Mod64(R7, 10)},
+		{Name: "Mod64 reg", Instruction: Mod64(R7, R8)},
+		{Name: "Mod imm", Instruction: Mod(R7, 10)},
+		{Name: "Mod reg", Instruction: Mod(R7, R8)},
+		{Name: "SDiv64 imm", Instruction: SDiv64(R7, -2)},
+		{Name: "SDiv64 reg", Instruction: SDiv64(R7, R8)},
+		{Name: "SDiv imm", Instruction: SDiv(R7, -2)},
+		{Name: "SDiv reg", Instruction: SDiv(R7, R8)},
+		{Name: "SMod64 imm", Instruction: SMod64(R7, -10)},
+		{Name: "SMod64 reg", Instruction: SMod64(R7, R8)},
+		{Name: "SMod imm", Instruction: SMod(R7, -10)},
+		{Name: "SMod reg", Instruction: SMod(R7, R8)},
+		{Name: "Xor64 imm", Instruction: Xor64(R8, -0x80000000)},
+		{Name: "Xor64 reg", Instruction: Xor64(R8, R9)},
+		{Name: "Xor imm", Instruction: Xor(R8, 1)},
+		{Name: "Xor reg", Instruction: Xor(R8, R9)},
+		{Name: "Mov64 imm", Instruction: Mov64(R9, 42)},
+		{Name: "Mov64 reg", Instruction: Mov64(R9, R10)},
+		{Name: "Mov64 wide imm", Instruction: Mov64(R9, int64(0x1122334455667788))},
+		{Name: "Mov imm", Instruction: Mov(R9, 42)},
+		{Name: "Mov reg", Instruction: Mov(R9, R1)},
+		{Name: "Arsh64 imm", Instruction: Arsh64(R1, 5)},
+		{Name: "Arsh64 reg", Instruction: Arsh64(R1, R2)},
+		{Name: "Arsh imm", Instruction: Arsh(R1, 5)},
+		{Name: "Arsh reg", Instruction: Arsh(R1, R2)},
+		{Name: "End64", Instruction: End64(R3, 64)},
+		{Name: "End", Instruction: End(R3, 16)},
+		{Name: "ToLe", Instruction: ToLe(R3, 16)},
+		{Name: "ToBe", Instruction: ToBe(R3, 32)},
+		{Name: "Bswap", Instruction: Bswap(R3, 64)},
+
+		{Name: "Jmp", Instruction: Jmp(-3)},
+		{Name: "Gotol", Instruction: Gotol(-40000)},
+		{Name: "Call", Instruction: Call(MapLookup)},
+		{Name: "CallLocal", Instruction: CallLocal(5)},
+		{Name: "CallKfunc", Instruction: CallKfunc(12345)},
+		{Name: "Exit", Instruction: Exit()},
+
+		{Name: "StDW imm", Instruction: StDW(R10, 0xcafe, -8)},
+		{Name: "StDW reg", Instruction: StDW(R10, R1, -8)},
+		{Name: "StW imm", Instruction: StW(R10, -1, -4)},
+		{Name: "StW reg", Instruction: StW(R10, R1, -4)},
+		{Name: "StH imm", Instruction: StH(R0, 0x1234, 2)},
+		{Name: "StH reg", Instruction: StH(R0, R1, 2)},
+		{Name: "StB imm", Instruction: StB(R0, 0x12, 1)},
+		{Name: "StB reg", Instruction: StB(R0, R1, 1)},
+		{Name: "LdDW", Instruction: LdDW(R1, R10, -8)},
+		{Name: "LdW", Instruction: LdW(R1, R10, -4)},
+		{Name: "LdH", Instruction: LdH(R1, R0, 2)},
+		{Name: "LdB", Instruction: LdB(R1, R0, 1)},
+		{Name: "LdSXW", Instruction: LdSXW(R1, R10, -4)},
+		{Name: "LdSXH", Instruction: LdSXH(R1, R0, 2)},
+		{Name: "LdSXB", Instruction: LdSXB(R1, R0, 1)},
+		{Name: "LdMapByFd", Instruction: LdMapByFd(R1, 3)},
+		{Name: "LdMapValue", Instruction: LdMapValue(R1, 3, 16)},
+		{Name: "LdFunc", Instruction: LdFunc(R2, 4)},
+		{Name: "LdImm64", Instruction: LdImm64(R2, 0xfedcba9876543210)},
+		{Name: "LdAbsW", Instruction: LdAbsW(14)},
+		{Name: "LdAbsH", Instruction: LdAbsH(12)},
+		{Name: "LdAbsB", Instruction: LdAbsB(23)},
+		{Name: "LdIndW", Instruction: LdIndW(R7, 14)},
+		{Name: "LdIndH", Instruction: LdIndH(R7, -2)},
+		{Name: "LdIndB", Instruction: LdIndB(R7, 9)},
+
+		{Name: "Xadd64", Instruction: Xadd64(R0, R1, 8)},
+		{Name: "Xadd", Instruction: Xadd(R0, R1, 4)},
+		{Name: "MemAdd64", Instruction: MemAdd64(R0, R1, 8)},
+		{Name: "MemAdd", Instruction: MemAdd(R0, R1, 4)},
+		{Name: "MemOr64", Instruction: MemOr64(R0, R1, 8)},
+		{Name: "MemOr", Instruction: MemOr(R0, R1, 4)},
+		{Name: "MemAnd64", Instruction: MemAnd64(R0, R1, 8)},
+		{Name: "MemAnd", Instruction: MemAnd(R0, R1, 4)},
+		{Name: "MemXor64", Instruction: MemXor64(R0, R1, 8)},
+		{Name: "MemXor", Instruction: MemXor(R0, R1, 4)},
+	}
+
+	type jmpBuilder struct {
+		name  string
+		imm   func(pb.Reg, int32, int16) *pb.Instruction
+		reg   func(pb.Reg, pb.Reg, int16) *pb.Instruction
+		imm32 func(pb.Reg, int32, int16) *pb.Instruction
+		reg32 func(pb.Reg, pb.Reg, int16) *pb.Instruction
+	}
+	jumps := []jmpBuilder{
+		{"JmpEQ", JmpEQ[int32], JmpEQ[pb.Reg], JmpEQ32[int32], JmpEQ32[pb.Reg]},
+		{"JmpGT", JmpGT[int32], JmpGT[pb.Reg], JmpGT32[int32], JmpGT32[pb.Reg]},
+		{"JmpGE", JmpGE[int32], JmpGE[pb.Reg], JmpGE32[int32], JmpGE32[pb.Reg]},
+		{"JmpSET", JmpSET[int32], JmpSET[pb.Reg], JmpSET32[int32], JmpSET32[pb.Reg]},
+		{"JmpNE", JmpNE[int32], JmpNE[pb.Reg], JmpNE32[int32], JmpNE32[pb.Reg]},
+		{"JmpSGT", JmpSGT[int32], JmpSGT[pb.Reg], JmpSGT32[int32], JmpSGT32[pb.Reg]},
+		{"JmpSGE", JmpSGE[int32], JmpSGE[pb.Reg], JmpSGE32[int32], JmpSGE32[pb.Reg]},
+		{"JmpLT", JmpLT[int32], JmpLT[pb.Reg], JmpLT32[int32], JmpLT32[pb.Reg]},
+		{"JmpLE", JmpLE[int32], JmpLE[pb.Reg], JmpLE32[int32], JmpLE32[pb.Reg]},
+		{"JmpSLT", JmpSLT[int32], JmpSLT[pb.Reg], JmpSLT32[int32], JmpSLT32[pb.Reg]},
+		{"JmpSLE", JmpSLE[int32], JmpSLE[pb.Reg], JmpSLE32[int32], JmpSLE32[pb.Reg]},
+	}
+	for _, j := range jumps {
+		cases = append(cases,
+			GoldenEncoding{Name: j.name + " imm", Instruction: j.imm(R1, -2, 5)},
+			GoldenEncoding{Name: j.name + " reg", Instruction: j.reg(R1, R2, -5)},
+			GoldenEncoding{Name: j.name + "32 imm", Instruction: j.imm32(R1, 0x7fffffff, 1)},
+			GoldenEncoding{Name: j.name + "32 reg", Instruction: j.reg32(R1, R2, 0)},
+		)
+	}
+	return cases
+}
+
+// GoldenEncodings returns an instruction for every builder of this package
+// along with its encoding according to the current encoder.
+func GoldenEncodings() ([]GoldenEncoding, error) {
+	cases := goldenBuilders()
+	for i := range cases {
+		encoding, err := EncodeInstructionsFor(&pb.Program{Instructions: []*pb.Instruction{cases[i].Instruction}}, LittleEndian)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", cases[i].Name, err)
+		}
+		cases[i].Encoding = encoding
+	}
+	return cases, nil
+}
+
+// WriteGoldenEncodings writes `cases` to `w` as JSON.
+func WriteGoldenEncodings(w io.Writer, cases []GoldenEncoding) error {
+	m := jsonpb.Marshaler{}
+	entries := []goldenEncodingJSON{}
+	for _, c := range cases {
+		insn, err := m.MarshalToString(c.Instruction)
+		if err != nil {
+			return err
+		}
+		entry := goldenEncodingJSON{Name: c.Name, Instruction: json.RawMessage(insn)}
+		for _, slot := range c.Encoding {
+			entry.Encoding = append(entry.Encoding, fmt.Sprintf("0x%016x", slot))
+		}
+		entries = append(entries, entry)
+	}
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ReadGoldenEncodings parses the output of WriteGoldenEncodings.
+func ReadGoldenEncodings(r io.Reader) ([]GoldenEncoding, error) {
+	entries := []goldenEncodingJSON{}
+	if err := json.NewDecoder(r).Decode(&entries); err != nil {
+		return nil, err
+	}
+	cases := []GoldenEncoding{}
+	for _, entry := range entries {
+		c := GoldenEncoding{Name: entry.Name, Instruction: &pb.Instruction{}}
+		if err := jsonpb.UnmarshalString(string(entry.Instruction), c.Instruction); err != nil {
+			return nil, fmt.Errorf("%s: %v", entry.Name, err)
+		}
+		for _, slot := range entry.Encoding {
+			v, err := strconv.ParseUint(slot, 0, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", entry.Name, err)
+			}
+			c.Encoding = append(c.Encoding, v)
+		}
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
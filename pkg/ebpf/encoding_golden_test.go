@@ -0,0 +1,81 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"os"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+const goldenEncodingsPath = "testdata/encoding_golden.json"
+
+func readGoldenEncodings(t *testing.T) map[string]GoldenEncoding {
+	t.Helper()
+	f, err := os.Open(goldenEncodingsPath)
+	if err != nil {
+		t.Fatalf("os.Open(%q) = %v", goldenEncodingsPath, err)
+	}
+	defer f.Close()
+	cases, err := ReadGoldenEncodings(f)
+	if err != nil {
+		t.Fatalf("ReadGoldenEncodings() = %v", err)
+	}
+	result := make(map[string]GoldenEncoding)
+	for _, c := range cases {
+		result[c.Name] = c
+	}
+	return result
+}
+
+// TestGoldenEncodings checks that the instructions of the golden file still
+// encode to the same slots.
+func TestGoldenEncodings(t *testing.T) {
+	for name, golden := range readGoldenEncodings(t) {
+		t.Run(name, func(t *testing.T) {
+			got, err := EncodeInstructions(&pb.Program{Instructions: []*pb.Instruction{golden.Instruction}})
+			if err != nil {
+				t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+			}
+			if len(got) != len(golden.Encoding) {
+				t.Fatalf("EncodeInstructions() = %#x, want %#x", got, golden.Encoding)
+			}
+			for i := range got {
+				if got[i] != golden.Encoding[i] {
+					t.Errorf("EncodeInstructions() = %#x, want %#x", got, golden.Encoding)
+				}
+			}
+		})
+	}
+}
+
+// TestGoldenBuilders checks that every builder is covered by the golden file
+// and still produces the same instruction.
+func TestGoldenBuilders(t *testing.T) {
+	golden := readGoldenEncodings(t)
+	for _, c := range goldenBuilders() {
+		t.Run(c.Name, func(t *testing.T) {
+			want, ok := golden[c.Name]
+			if !ok {
+				t.Fatalf("%q is missing from %s, regenerate it with //tools/encoding_golden", c.Name, goldenEncodingsPath)
+			}
+			if !protobuf.Equal(c.Instruction, want.Instruction) {
+				t.Errorf("%s = %v, want %v", c.Name, c.Instruction, want.Instruction)
+			}
+		})
+	}
+}
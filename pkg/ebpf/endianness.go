@@ -0,0 +1,87 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"encoding/binary"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// Endianness is the byte order of the machine bytecode is encoded for. It
+// decides where the fields of struct bpf_insn land in an encoded slot: on
+// big-endian machines, e.g. s390x, the destination register is the high
+// nibble of the registers byte and the offset and immediate are stored most
+// significant byte first.
+type Endianness int
+
+const (
+	LittleEndian Endianness = iota
+	BigEndian
+)
+
+// HostEndianness returns the byte order of the machine buzzer runs on, the
+// one of the bytecode loaded in its kernel.
+func HostEndianness() Endianness {
+	if binary.NativeEndian.Uint16([]byte{0x12, 0x34}) == 0x1234 {
+		return BigEndian
+	}
+	return LittleEndian
+}
+
+// ByteOrder returns the byte order the slots encoded for `e` are stored in.
+func (e Endianness) ByteOrder() binary.ByteOrder {
+	if e == BigEndian {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+func (e Endianness) String() string {
+	if e == BigEndian {
+		return "big-endian"
+	}
+	return "little-endian"
+}
+
+// toBigEndian converts the little-endian slot `slot` to the slot which, once
+// stored in big-endian order, has the same instruction.
+func toBigEndian(slot uint64) uint64 {
+	code, dst, src := uint8(slot), uint8(slot>>8)&0x0F, uint8(slot>>12)&0x0F
+	off, imm := uint16(slot>>16), uint32(slot>>32)
+	return uint64(code)<<56 | uint64(dst<<4|src)<<48 | uint64(off)<<32 | uint64(imm)
+}
+
+// fromBigEndian is the inverse of toBigEndian.
+func fromBigEndian(slot uint64) uint64 {
+	code, dst, src := uint8(slot>>56), uint8(slot>>52)&0x0F, uint8(slot>>48)&0x0F
+	off, imm := uint16(slot>>32), uint32(slot)
+	return uint64(code) | uint64(src<<4|dst)<<8 | uint64(off)<<16 | uint64(imm)<<32
+}
+
+// EncodeBytecode returns the bytes of the struct bpf_insn array of `program`
+// on a machine of endianness `e`, e.g. to write the bytecode of a program
+// for an s390x machine from an x86 one.
+func EncodeBytecode(program *pb.Program, e Endianness) ([]byte, error) {
+	slots, err := EncodeInstructionsFor(program, e)
+	if err != nil {
+		return nil, err
+	}
+	bytecode := make([]byte, 8*len(slots))
+	for i, slot := range slots {
+		e.ByteOrder().PutUint64(bytecode[8*i:], slot)
+	}
+	return bytecode, nil
+}
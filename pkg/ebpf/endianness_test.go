@@ -0,0 +1,102 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"bytes"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestEncodeBytecode(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{
+		LdDW(R1, R10, -8),
+		Mov64(R0, 0x12345678),
+		LdMapByFd(R2, 3),
+	}}
+	tests := []struct {
+		testName   string
+		endianness Endianness
+		want       []byte
+	}{
+		{
+			testName:   "Little-endian",
+			endianness: LittleEndian,
+			want: []byte{
+				0x79, 0xa1, 0xf8, 0xff, 0x00, 0x00, 0x00, 0x00,
+				0xb7, 0x00, 0x00, 0x00, 0x78, 0x56, 0x34, 0x12,
+				0x18, 0x12, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+		},
+		{
+			testName:   "Big-endian",
+			endianness: BigEndian,
+			want: []byte{
+				0x79, 0x1a, 0xff, 0xf8, 0x00, 0x00, 0x00, 0x00,
+				0xb7, 0x00, 0x00, 0x00, 0x12, 0x34, 0x56, 0x78,
+				0x18, 0x21, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := EncodeBytecode(program, tc.endianness)
+			if err != nil {
+				t.Fatalf("EncodeBytecode() failed: %v", err)
+			}
+			if !bytes.Equal(got, tc.want) {
+				t.Errorf("EncodeBytecode() = % x, want % x", got, tc.want)
+			}
+
+			slots, err := EncodeInstructionsFor(program, tc.endianness)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := DecodeInstructionsFor(slots, tc.endianness)
+			if err != nil {
+				t.Fatalf("DecodeInstructionsFor() failed: %v", err)
+			}
+			if !proto.Equal(decoded, program) {
+				t.Errorf("DecodeInstructionsFor() = %v, want %v", decoded, program)
+			}
+		})
+	}
+}
+
+func TestHostEndianness(t *testing.T) {
+	// The slots of the host are stored in its byte order, wherever buzzer
+	// runs.
+	program := &pb.Program{Instructions: []*pb.Instruction{Mov64(R0, 0x12345678), Exit()}}
+	slots, err := EncodeInstructions(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := EncodeBytecode(program, HostEndianness())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, 8*len(slots))
+	for i, slot := range slots {
+		HostEndianness().ByteOrder().PutUint64(got[8*i:], slot)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("host slots = % x, want % x", got, want)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// gadgetSpillOffset is the stack slot the gadgets spill to, relative to R10.
+const gadgetSpillOffset = -8
+
+// GadgetEnvironment is the state of the program where a gadget is
+// instantiated.
+type GadgetEnvironment struct {
+	// ValueReg holds a pointer to a map value of ValueSize bytes that was
+	// already checked against null, the gadgets never write the register.
+	// It must be one of R6-R9, some gadgets call helpers.
+	ValueReg  pb.Reg
+	ValueSize int32
+}
+
+// Gadget is a short instruction pattern known to exercise the corner cases
+// of the verifier, e.g. checking the bounds of an index before using it to
+// access memory. Every instantiation picks different parameters, mostly
+// correct but sometimes off by one or missing a check.
+type Gadget struct {
+	Name string
+
+	build func(env *GadgetEnvironment) []*pb.Instruction
+}
+
+// Instantiate returns the instructions of the gadget for `env` with random
+// parameters. They clobber R0-R5 and the top 8 bytes of the stack, and fall
+// through to the next instruction.
+func (g *Gadget) Instantiate(env *GadgetEnvironment) ([]*pb.Instruction, error) {
+	if env.ValueSize < 8 {
+		return nil, fmt.Errorf("map values of %d bytes are too small for the gadget %s", env.ValueSize, g.Name)
+	}
+	return InstructionSequence(g.build(env)...)
+}
+
+// Gadgets contains the patterns buzzer can instantiate.
+var Gadgets = []*Gadget{
+	{
+		Name:  "bounds_check_then_access",
+		build: boundsCheckThenAccess,
+	},
+	{
+		Name:  "spill_corrupt_fill",
+		build: spillCorruptFill,
+	},
+	{
+		Name:  "sign_extend_then_index",
+		build: signExtendThenIndex,
+	},
+	{
+		Name:  "unknown_scalar_then_index",
+		build: unknownScalarThenIndex,
+	},
+}
+
+// UnknownScalarHelpers are the helpers whose results the verifier can't
+// know anything about, they depend on the time or on a random generator.
+var UnknownScalarHelpers = []int32{
+	GetPrandomU32,
+	KtimeGetNs,
+	Jiffies64,
+}
+
+// UnknownScalar returns a call to a random helper of UnknownScalarHelpers
+// and moves its result to `dst`. The call clobbers R0-R5, `dst` is usually
+// one of R6-R9 for the value to outlive the next call.
+func UnknownScalar(dst pb.Reg) []*pb.Instruction {
+	helper := UnknownScalarHelpers[rand.SharedRNG.RandRange(0, uint64(len(UnknownScalarHelpers)-1))]
+	insn := []*pb.Instruction{Call(helper)}
+	if dst != R0 {
+		insn = append(insn, Mov64(dst, R0))
+	}
+	return insn
+}
+
+// RandomGadget instantiates a random gadget.
+func RandomGadget(env *GadgetEnvironment) ([]*pb.Instruction, error) {
+	g := Gadgets[rand.SharedRNG.RandRange(0, uint64(len(Gadgets)-1))]
+	return g.Instantiate(env)
+}
+
+// gadgetLoad returns a load of `size` bytes.
+func gadgetLoad(size int32, dst, src pb.Reg, offset int16) *pb.Instruction {
+	switch size {
+	case 1:
+		return LdB(dst, src, offset)
+	case 2:
+		return LdH(dst, src, offset)
+	case 4:
+		return LdW(dst, src, offset)
+	default:
+		return LdDW(dst, src, offset)
+	}
+}
+
+// gadgetAccessSize returns a random access size in bytes.
+func gadgetAccessSize() int32 {
+	return []int32{1, 2, 4, 8}[rand.SharedRNG.RandRange(0, 3)]
+}
+
+// boundsCheckThenAccess reads an index from the map value, checks it
+// against the size of the value and uses it to access the value. The check
+// is signed one out of four times, which lets negative indexes through.
+func boundsCheckThenAccess(env *GadgetEnvironment) []*pb.Instruction {
+	size := gadgetAccessSize()
+	limit := templateParam(0, env.ValueSize-size)
+	insn := []*pb.Instruction{
+		LdDW(R1, env.ValueReg, int16(templateParam(0, env.ValueSize-8))),
+		nil, // if r1 > limit goto out
+		Mov64(R2, env.ValueReg),
+		Add64(R2, R1),
+		gadgetLoad(size, R3, R2, 0),
+	}
+	if rand.SharedRNG.OneOf(4) {
+		insn[1] = JmpSGT(R1, limit, skipToEnd(insn, 1))
+	} else {
+		insn[1] = JmpGT(R1, limit, skipToEnd(insn, 1))
+	}
+	return insn
+}
+
+// spillCorruptFill spills the map value pointer to the stack, sometimes
+// overwrites part of the slot, fills it back and dereferences it. A partial
+// overwrite or a narrower fill turns the pointer into a scalar.
+func spillCorruptFill(env *GadgetEnvironment) []*pb.Instruction {
+	insn := []*pb.Instruction{
+		StDW(R10, env.ValueReg, gadgetSpillOffset),
+	}
+	if rand.SharedRNG.OneOf(4) {
+		offset := gadgetSpillOffset + int16(rand.SharedRNG.RandRange(0, 7))
+		insn = append(insn, StB(R10, int32(rand.SharedRNG.RandInt()), offset))
+	}
+	if rand.SharedRNG.OneOf(4) {
+		insn = append(insn, LdW(R1, R10, gadgetSpillOffset))
+	} else {
+		insn = append(insn, LdDW(R1, R10, gadgetSpillOffset))
+	}
+	size := gadgetAccessSize()
+	return append(insn, gadgetLoad(size, R2, R1, int16(templateParam(0, env.ValueSize-size))))
+}
+
+// signExtendThenIndex reads a narrow value from the map value, sign extends
+// it with a pair of shifts and uses it as an index after a signed bounds
+// check. The check of the lower bound is missing one out of four times.
+func signExtendThenIndex(env *GadgetEnvironment) []*pb.Instruction {
+	size := []int32{1, 2, 4}[rand.SharedRNG.RandRange(0, 2)]
+	shift := 64 - 8*size
+	insn := []*pb.Instruction{
+		gadgetLoad(size, R1, env.ValueReg, int16(templateParam(0, env.ValueSize-size))),
+		Lsh64(R1, shift),
+		Arsh64(R1, shift),
+		nil, // if r1 s> limit goto out
+	}
+	upper := len(insn) - 1
+	lower := -1
+	if !rand.SharedRNG.OneOf(4) {
+		lower = len(insn)
+		insn = append(insn, nil) // if r1 s< 0 goto out
+	}
+	insn = append(insn,
+		Mov64(R2, env.ValueReg),
+		Add64(R2, R1),
+		LdB(R3, R2, 0),
+	)
+	// The later jump goes first so skipToEnd never sees a placeholder.
+	if lower >= 0 {
+		insn[lower] = JmpSLT(R1, int32(0), skipToEnd(insn, lower))
+	}
+	insn[upper] = JmpSGT(R1, templateParam(0, env.ValueSize-1), skipToEnd(insn, upper))
+	return insn
+}
+
+// unknownScalarThenIndex gets a value the verifier knows nothing about from
+// a helper, sometimes narrows it with a mask, branches on it and uses it to
+// index the map value. Three out of four times the branch is an unsigned
+// bounds check, otherwise it is a comparison that doesn't bound the value,
+// e.g. a signed one or only on the lower 32 bits.
+func unknownScalarThenIndex(env *GadgetEnvironment) []*pb.Instruction {
+	insn := UnknownScalar(R1)
+	if rand.SharedRNG.OneOf(2) {
+		insn = append(insn, And64(R1, int32(1)<<rand.SharedRNG.RandRange(0, 31)-1))
+	}
+	size := gadgetAccessSize()
+	limit := templateParam(0, env.ValueSize-size)
+	check := len(insn)
+	insn = append(insn,
+		nil, // if r1 > limit goto out
+		Mov64(R2, env.ValueReg),
+		Add64(R2, R1),
+		gadgetLoad(size, R3, R2, 0),
+	)
+	skip := skipToEnd(insn, check)
+	if rand.SharedRNG.OneOf(4) {
+		insn[check] = []*pb.Instruction{
+			JmpSGT(R1, limit, skip),
+			JmpGT32(R1, limit, skip),
+			JmpNE(R1, limit, skip),
+			JmpLT(R1, limit, skip),
+		}[rand.SharedRNG.RandRange(0, 3)]
+	} else {
+		insn[check] = JmpGT(R1, limit, skip)
+	}
+	return insn
+}
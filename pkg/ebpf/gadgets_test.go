@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// writesRegister returns true if an instruction of `insn` writes `reg`.
+func writesRegister(insn []*pb.Instruction, reg pb.Reg) bool {
+	for _, i := range insn {
+		writes := i.GetAluOpcode() != nil || i.GetMemOpcode().GetInstructionClass() == pb.InsClass_InsClassLdx
+		if writes && i.DstReg == reg {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGadgets(t *testing.T) {
+	env := &GadgetEnvironment{ValueReg: R6, ValueSize: 64}
+	for _, g := range Gadgets {
+		t.Run(g.Name, func(t *testing.T) {
+			// Parameters are random, try a few instantiations.
+			for i := 0; i < 100; i++ {
+				insn, err := g.Instantiate(env)
+				if err != nil {
+					t.Fatalf("Instantiate() = %v, want nil error", err)
+				}
+				if _, err := EncodeInstructions(&pb.Program{Instructions: insn}); err != nil {
+					t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+				}
+				if !checkJumpTargets(insn) {
+					t.Fatalf("jump outside of the gadget in %v", insn)
+				}
+				if writesRegister(insn, env.ValueReg) {
+					t.Fatalf("gadget overwrites the value pointer in %v", insn)
+				}
+			}
+		})
+	}
+}
+
+func TestRandomGadget(t *testing.T) {
+	if _, err := RandomGadget(&GadgetEnvironment{ValueReg: R6, ValueSize: 64}); err != nil {
+		t.Errorf("RandomGadget() = %v, want nil error", err)
+	}
+	if _, err := RandomGadget(&GadgetEnvironment{ValueReg: R6, ValueSize: 4}); err == nil {
+		t.Errorf("RandomGadget() with 4 byte values did not return an error")
+	}
+}
+
+func TestUnknownScalar(t *testing.T) {
+	for _, dst := range []pb.Reg{R0, R7} {
+		insn := UnknownScalar(dst)
+		helper := insn[0].GetImmediate()
+		found := false
+		for _, h := range UnknownScalarHelpers {
+			found = found || h == helper
+		}
+		if !found || insn[0].GetJmpOpcode().GetOperationCode() != pb.JmpOperationCode_JmpCALL {
+			t.Errorf("UnknownScalar(%v) starts with %v, want a call to one of %v", dst, insn[0], UnknownScalarHelpers)
+		}
+		if dst != R0 && !writesRegister(insn, dst) {
+			t.Errorf("UnknownScalar(%v) = %v, does not write the register", dst, insn)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+)
+
+// HelperArgType describes what the verifier expects to find in a register
+// passed as argument to a helper function. It mirrors a subset of
+// `enum bpf_arg_type` in include/linux/bpf.h.
+type HelperArgType int
+
+const (
+	// ArgAnything accepts any initialized value.
+	ArgAnything HelperArgType = iota
+	// ArgConstMapPtr a pointer to a map, as loaded by LdMapByFd.
+	ArgConstMapPtr
+	// ArgPtrToMapKey a pointer to initialized memory of the map key size.
+	ArgPtrToMapKey
+	// ArgPtrToMapValue a pointer to initialized memory of the map value size.
+	ArgPtrToMapValue
+	// ArgPtrToMem a pointer to initialized memory, the size is given by the
+	// next argument.
+	ArgPtrToMem
+	// ArgPtrToUninitMem a pointer to memory the helper writes to, the size is
+	// given by the next argument.
+	ArgPtrToUninitMem
+	// ArgConstSize the size of the memory passed in the previous argument,
+	// must be greater than zero.
+	ArgConstSize
+	// ArgConstSizeOrZero same as ArgConstSize but zero is allowed.
+	ArgConstSizeOrZero
+	// ArgPtrToCtx a pointer to the program context.
+	ArgPtrToCtx
+	// ArgConstProgArrayPtr a pointer to a map of programs, only built by the
+	// helper chain templates, see HelperTemplate.
+	ArgConstProgArrayPtr
+	// ArgConstRingbufPtr a pointer to a ring buffer map, only built by the
+	// helper chain templates.
+	ArgConstRingbufPtr
+	// ArgPtrToRingbufRecord a record returned by ringbuf_reserve, only built
+	// by the helper chain templates.
+	ArgPtrToRingbufRecord
+	// ArgPtrToSpinLock a struct bpf_spin_lock in a map value, only built by
+	// the spin_lock strategy.
+	ArgPtrToSpinLock
+	// ArgPtrToSockTuple a struct bpf_sock_tuple the socket lookups read, the
+	// size is given by the next argument, only built by the
+	// reference_tracking strategy.
+	ArgPtrToSockTuple
+	// ArgPtrToSocket a socket returned by one of the lookups, only built by
+	// the reference_tracking strategy.
+	ArgPtrToSocket
+	// ArgPtrToFunc a callback, a function of the program loaded by LdFunc,
+	// see CallbackPrototype. Only built by the callbacks strategy.
+	ArgPtrToFunc
+	// ArgPtrToStackOrNull a pointer to the stack, or 0, the helper passes
+	// to the callback.
+	ArgPtrToStackOrNull
+	// ArgPtrToTimer a struct bpf_timer in a map value, only built by the
+	// timers strategy.
+	ArgPtrToTimer
+	// ArgUnsafePtr an address the helper reads with a probe, e.g. a kernel
+	// pointer, faults are reported by the helper instead of the verifier.
+	// Only built by the probe_read and sleepable strategies, the helpers
+	// taking it are not available to socket filters.
+	ArgUnsafePtr
+)
+
+// HelperPrototype describes a helper function and the arguments it takes.
+type HelperPrototype struct {
+	Name string
+	ID   int32
+	Args []HelperArgType
+
+	// Sleepable helpers may sleep, only programs loaded with
+	// BPF_F_SLEEPABLE can call them.
+	Sleepable bool
+}
+
+// NeedsMap returns true if a map is required to call the helper.
+func (hp *HelperPrototype) NeedsMap() bool {
+	for _, arg := range hp.Args {
+		if arg == ArgConstMapPtr {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsTemplate returns true if only a helper chain template, or a dedicated
+// strategy, can build the arguments of the helper.
+func (hp *HelperPrototype) NeedsTemplate() bool {
+	for _, arg := range hp.Args {
+		if arg == ArgConstProgArrayPtr || arg == ArgConstRingbufPtr || arg == ArgPtrToRingbufRecord || arg == ArgPtrToSpinLock ||
+			arg == ArgPtrToSockTuple || arg == ArgPtrToSocket || arg == ArgPtrToFunc || arg == ArgPtrToTimer || arg == ArgUnsafePtr {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedsCtx returns true if the program context is required to call the
+// helper.
+func (hp *HelperPrototype) NeedsCtx() bool {
+	for _, arg := range hp.Args {
+		if arg == ArgPtrToCtx {
+			return true
+		}
+	}
+	return false
+}
+
+// HelperPrototypes contains the helpers buzzer knows how to call. The ones
+// that don't need a template are available to socket filter programs, the
+// others may need another program type, e.g. spin_lock and the socket
+// lookups need a BPF_PROG_TYPE_SCHED_CLS program.
+var HelperPrototypes = []*HelperPrototype{
+	{Name: "map_lookup_elem", ID: MapLookup, Args: []HelperArgType{ArgConstMapPtr, ArgPtrToMapKey}},
+	{Name: "map_update_elem", ID: MapUpdate, Args: []HelperArgType{ArgConstMapPtr, ArgPtrToMapKey, ArgPtrToMapValue, ArgAnything}},
+	{Name: "map_delete_elem", ID: MapDelete, Args: []HelperArgType{ArgConstMapPtr, ArgPtrToMapKey}},
+	{Name: "ktime_get_ns", ID: KtimeGetNs},
+	{Name: "get_prandom_u32", ID: GetPrandomU32},
+	{Name: "get_smp_processor_id", ID: GetSmpProcessorId},
+	{Name: "skb_load_bytes", ID: SkbLoadBytes, Args: []HelperArgType{ArgPtrToCtx, ArgAnything, ArgPtrToUninitMem, ArgConstSize}},
+	{Name: "get_numa_node_id", ID: GetNumaNodeId},
+	{Name: "get_socket_cookie", ID: GetSocketCookie, Args: []HelperArgType{ArgPtrToCtx}},
+	{Name: "get_socket_uid", ID: GetSocketUid, Args: []HelperArgType{ArgPtrToCtx}},
+	{Name: "skb_load_bytes_relative", ID: SkbLoadBytesRelative, Args: []HelperArgType{ArgPtrToCtx, ArgAnything, ArgPtrToUninitMem, ArgConstSize, ArgAnything}},
+	{Name: "sk_lookup_tcp", ID: SkLookupTcp, Args: []HelperArgType{ArgPtrToCtx, ArgPtrToSockTuple, ArgConstSize, ArgAnything, ArgAnything}},
+	{Name: "sk_lookup_udp", ID: SkLookupUdp, Args: []HelperArgType{ArgPtrToCtx, ArgPtrToSockTuple, ArgConstSize, ArgAnything, ArgAnything}},
+	{Name: "sk_release", ID: SkRelease, Args: []HelperArgType{ArgPtrToSocket}},
+	{Name: "spin_lock", ID: SpinLock, Args: []HelperArgType{ArgPtrToSpinLock}},
+	{Name: "spin_unlock", ID: SpinUnlock, Args: []HelperArgType{ArgPtrToSpinLock}},
+	{Name: "skc_lookup_tcp", ID: SkcLookupTcp, Args: []HelperArgType{ArgPtrToCtx, ArgPtrToSockTuple, ArgConstSize, ArgAnything, ArgAnything}},
+	{Name: "jiffies64", ID: Jiffies64},
+	{Name: "ktime_get_boot_ns", ID: KtimeGetBootNs},
+	{Name: "tail_call", ID: TailCall, Args: []HelperArgType{ArgPtrToCtx, ArgConstProgArrayPtr, ArgAnything}},
+	{Name: "ringbuf_output", ID: RingbufOutput, Args: []HelperArgType{ArgConstRingbufPtr, ArgPtrToMem, ArgConstSizeOrZero, ArgAnything}},
+	{Name: "ringbuf_reserve", ID: RingbufReserve, Args: []HelperArgType{ArgConstRingbufPtr, ArgAnything, ArgAnything}},
+	{Name: "ringbuf_submit", ID: RingbufSubmit, Args: []HelperArgType{ArgPtrToRingbufRecord, ArgAnything}},
+	{Name: "ringbuf_discard", ID: RingbufDiscard, Args: []HelperArgType{ArgPtrToRingbufRecord, ArgAnything}},
+	{Name: "for_each_map_elem", ID: ForEachMapElem, Args: []HelperArgType{ArgConstMapPtr, ArgPtrToFunc, ArgPtrToStackOrNull, ArgAnything}},
+	{Name: "timer_init", ID: TimerInit, Args: []HelperArgType{ArgPtrToTimer, ArgConstMapPtr, ArgAnything}},
+	{Name: "timer_set_callback", ID: TimerSetCallback, Args: []HelperArgType{ArgPtrToTimer, ArgPtrToFunc}},
+	{Name: "timer_start", ID: TimerStart, Args: []HelperArgType{ArgPtrToTimer, ArgAnything, ArgAnything}},
+	{Name: "timer_cancel", ID: TimerCancel, Args: []HelperArgType{ArgPtrToTimer}},
+	{Name: "loop", ID: Loop, Args: []HelperArgType{ArgAnything, ArgPtrToFunc, ArgPtrToStackOrNull, ArgAnything}},
+	{Name: "probe_read", ID: ProbeRead, Args: []HelperArgType{ArgPtrToUninitMem, ArgConstSizeOrZero, ArgUnsafePtr}},
+	{Name: "probe_read_str", ID: ProbeReadStr, Args: []HelperArgType{ArgPtrToUninitMem, ArgConstSizeOrZero, ArgUnsafePtr}},
+	{Name: "probe_read_user", ID: ProbeReadUser, Args: []HelperArgType{ArgPtrToUninitMem, ArgConstSizeOrZero, ArgUnsafePtr}},
+	{Name: "probe_read_kernel", ID: ProbeReadKernel, Args: []HelperArgType{ArgPtrToUninitMem, ArgConstSizeOrZero, ArgUnsafePtr}},
+	{Name: "probe_read_user_str", ID: ProbeReadUserStr, Args: []HelperArgType{ArgPtrToUninitMem, ArgConstSizeOrZero, ArgUnsafePtr}},
+	{Name: "probe_read_kernel_str", ID: ProbeReadKernelStr, Args: []HelperArgType{ArgPtrToUninitMem, ArgConstSizeOrZero, ArgUnsafePtr}},
+	{Name: "copy_from_user", ID: CopyFromUser, Args: []HelperArgType{ArgPtrToUninitMem, ArgConstSizeOrZero, ArgUnsafePtr}, Sleepable: true},
+}
+
+// HelperPrototypeByID returns the prototype of the helper `id` or nil if
+// buzzer doesn't know about it.
+func HelperPrototypeByID(id int32) *HelperPrototype {
+	for _, hp := range HelperPrototypes {
+		if hp.ID == id {
+			return hp
+		}
+	}
+	return nil
+}
+
+// Stack layout used to place the helper arguments, all offsets are relative
+// to R10.
+const (
+	helperKeyOffset   = -8
+	helperValueOffset = -16
+	helperMemOffset   = -64
+	helperMaxMemSize  = 48
+)
+
+// CallEnvironment describes the state of the program at the point where a
+// helper call is made, this is needed to build arguments that satisfy the
+// helper prototype.
+type CallEnvironment struct {
+	// HasCtx indicates if CtxReg holds the pointer to the program context.
+	HasCtx bool
+	CtxReg pb.Reg
+
+	// MapFd is the map used for map arguments, -1 if there is none. Maps
+	// are expected to have a 4 byte key and an 8 byte value, like the ones
+	// created by FFI.CreateMapArray.
+	MapFd int
+}
+
+// CanCall returns true if the environment has everything needed to call the
+// helper.
+func (env *CallEnvironment) CanCall(hp *HelperPrototype) bool {
+	if hp.NeedsTemplate() {
+		return false
+	}
+	if hp.NeedsMap() && env.MapFd < 0 {
+		return false
+	}
+	if hp.NeedsCtx() && !env.HasCtx {
+		return false
+	}
+	return true
+}
+
+// argumentInstructions returns the instructions that load a value satisfying
+// `argType` into `reg`. If `violate` is true the value breaks the contract
+// in a subtle way instead.
+//
+// memSize is the size of the last memory argument, it is used to build the
+// size arguments that follow it.
+func (env *CallEnvironment) argumentInstructions(argType HelperArgType, reg pb.Reg, memSize int32, violate bool) []*pb.Instruction {
+	switch argType {
+	case ArgConstMapPtr:
+		if violate {
+			// The raw fd is a scalar, not a map pointer.
+			return []*pb.Instruction{Mov64(reg, int32(env.MapFd))}
+		}
+		return []*pb.Instruction{LdMapByFd(reg, env.MapFd)}
+	case ArgPtrToMapKey, ArgPtrToMapValue:
+		offset := int16(helperKeyOffset)
+		store := StW(R10, int32(rand.SharedRNG.RandInt()), offset)
+		if argType == ArgPtrToMapValue {
+			offset = helperValueOffset
+			store = StDW(R10, int32(rand.SharedRNG.RandInt()), offset)
+		}
+		if violate {
+			// Point to a stack slot that was never written.
+			return []*pb.Instruction{Mov64(reg, R10), Add64(reg, int32(-256))}
+		}
+		return []*pb.Instruction{store, Mov64(reg, R10), Add64(reg, int32(offset))}
+	case ArgPtrToMem, ArgPtrToUninitMem:
+		insn := []*pb.Instruction{}
+		if argType == ArgPtrToMem {
+			for offset := int16(helperMemOffset); offset < helperMemOffset+helperMaxMemSize; offset += 8 {
+				insn = append(insn, StDW(R10, int32(0), offset))
+			}
+		}
+		if violate {
+			// Point above the top of the stack frame.
+			return append(insn, Mov64(reg, R10), Add64(reg, int32(8)))
+		}
+		return append(insn, Mov64(reg, R10), Add64(reg, int32(helperMemOffset)))
+	case ArgConstSize, ArgConstSizeOrZero:
+		if violate {
+			switch rand.SharedRNG.RandRange(0, 2) {
+			case 0:
+				return []*pb.Instruction{Mov64(reg, int32(-1))}
+			case 1:
+				// Bigger than the distance from the buffer to the top of
+				// the stack frame.
+				return []*pb.Instruction{Mov64(reg, int32(-helperMemOffset)+memSize)}
+			default:
+				if argType == ArgConstSize {
+					return []*pb.Instruction{Mov64(reg, int32(0))}
+				}
+				return []*pb.Instruction{Mov64(reg, int32(-1))}
+			}
+		}
+		return []*pb.Instruction{Mov64(reg, memSize)}
+	case ArgPtrToCtx:
+		if violate {
+			return []*pb.Instruction{Mov64(reg, R10)}
+		}
+		return []*pb.Instruction{Mov64(reg, env.CtxReg)}
+	default:
+		return []*pb.Instruction{Mov64(reg, int32(rand.SharedRNG.RandInt()))}
+	}
+}
+
+// HelperCall builds a call site for `hp`: the instructions that set up R1-R5
+// according to the helper prototype followed by the call itself. If
+// `violate` is true, one of the arguments (if any can be violated) will
+// break the contract of the prototype slightly, e.g. an off by one size or a
+// scalar where a pointer is expected.
+func HelperCall(hp *HelperPrototype, env *CallEnvironment, violate bool) ([]*pb.Instruction, error) {
+	if !env.CanCall(hp) {
+		return nil, fmt.Errorf("environment cannot satisfy the arguments of %s", hp.Name)
+	}
+
+	violated := -1
+	if violate {
+		candidates := []int{}
+		for i, arg := range hp.Args {
+			if arg != ArgAnything {
+				candidates = append(candidates, i)
+			}
+		}
+		if len(candidates) != 0 {
+			violated = candidates[rand.SharedRNG.RandRange(0, uint64(len(candidates)-1))]
+		}
+	}
+
+	insn := []*pb.Instruction{}
+	memSize := int32(rand.SharedRNG.RandRange(1, helperMaxMemSize))
+	for i, arg := range hp.Args {
+		reg := pb.Reg(int(R1) + i)
+		insn = append(insn, env.argumentInstructions(arg, reg, memSize, i == violated)...)
+	}
+	insn = append(insn, Call(hp.ID))
+	return InstructionSequence(insn...)
+}
+
+// RandomHelperCall picks a random helper that can be called in `env` and
+// builds a call site for it. One out of four call sites will deliberately
+// violate the helper contract.
+func RandomHelperCall(env *CallEnvironment) ([]*pb.Instruction, error) {
+	candidates := []*HelperPrototype{}
+	for _, hp := range HelperPrototypes {
+		if env.CanCall(hp) {
+			candidates = append(candidates, hp)
+		}
+	}
+	return HelperCall(pickHelper(candidates), env, rand.SharedRNG.OneOf(4))
+}
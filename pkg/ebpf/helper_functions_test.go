@@ -0,0 +1,109 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestHelperCall(t *testing.T) {
+	mapEnv := &CallEnvironment{MapFd: 3}
+	ctxEnv := &CallEnvironment{HasCtx: true, CtxReg: R6, MapFd: -1}
+	tests := []struct {
+		testName  string
+		helper    int32
+		env       *CallEnvironment
+		violate   bool
+		wantFirst *pb.Instruction
+		wantErr   bool
+	}{
+		{
+			testName:  "Map lookup loads the map pointer in R1",
+			helper:    MapLookup,
+			env:       mapEnv,
+			wantFirst: LdMapByFd(R1, 3),
+		},
+		{
+			testName:  "Ctx helper gets the ctx register",
+			helper:    GetSocketCookie,
+			env:       ctxEnv,
+			wantFirst: Mov64(R1, R6),
+		},
+		{
+			testName:  "Violated ctx helper gets the stack pointer",
+			helper:    GetSocketCookie,
+			env:       ctxEnv,
+			violate:   true,
+			wantFirst: Mov64(R1, R10),
+		},
+		{
+			testName: "Ctx helper without ctx fails",
+			helper:   GetSocketCookie,
+			env:      mapEnv,
+			wantErr:  true,
+		},
+		{
+			testName: "Map helper without map fails",
+			helper:   MapUpdate,
+			env:      ctxEnv,
+			wantErr:  true,
+		},
+		{
+			testName: "Probe read is left to the probe_read strategy",
+			helper:   ProbeReadKernel,
+			env:      ctxEnv,
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			hp := HelperPrototypeByID(tc.helper)
+			if hp == nil {
+				t.Fatalf("HelperPrototypeByID(%d) = nil", tc.helper)
+			}
+			insn, err := HelperCall(hp, tc.env, tc.violate)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("HelperCall(%s) did not return an error", hp.Name)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("HelperCall(%s) = %v, want nil error", hp.Name, err)
+			}
+
+			if !protobuf.Equal(insn[len(insn)-1], Call(tc.helper)) {
+				t.Errorf("last instruction = %v, want %v", insn[len(insn)-1], Call(tc.helper))
+			}
+
+			if tc.wantFirst != nil && !protobuf.Equal(insn[0], tc.wantFirst) {
+				t.Errorf("first instruction = %v, want %v", insn[0], tc.wantFirst)
+			}
+		})
+	}
+}
+
+func TestGetBpfFuncName(t *testing.T) {
+	if got := GetBpfFuncName(MapLookup); got != "BPF_FUNC_map_lookup_elem" {
+		t.Errorf("GetBpfFuncName(MapLookup) = %q, want %q", got, "BPF_FUNC_map_lookup_elem")
+	}
+	if got := GetBpfFuncName(-1); got != "unknown" {
+		t.Errorf("GetBpfFuncName(-1) = %q, want %q", got, "unknown")
+	}
+}
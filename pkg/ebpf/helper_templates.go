@@ -0,0 +1,279 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// Flags of the ring buffer helpers.
+const (
+	RingbufNoWakeup    = 1
+	RingbufForceWakeup = 2
+)
+
+// templateRecordReg is where the helper chain templates keep values across
+// helper calls, the calls clobber R0-R5.
+const templateRecordReg = R8
+
+// Stack layout of the helper chain templates, offsets are relative to R10.
+const (
+	templateKeyOffset    = -4
+	templatePacketOffset = -16
+	templateValueOffset  = -24
+	templateBufferOffset = -64
+	templateBufferSize   = 40
+)
+
+// TemplateEnvironment is the state of the program where a helper chain
+// template is instantiated. The context must be in a register the helper
+// calls preserve, e.g. R6, and the maps missing from the environment are -1.
+type TemplateEnvironment struct {
+	CallEnvironment
+
+	// ProgArrayFd is a map of programs, BPF_MAP_TYPE_PROG_ARRAY, with
+	// ProgArraySize entries.
+	ProgArrayFd   int
+	ProgArraySize uint32
+
+	// RingbufFd is a ring buffer map, BPF_MAP_TYPE_RINGBUF, whose data
+	// area has RingbufSize bytes.
+	RingbufFd   int
+	RingbufSize uint32
+}
+
+// HelperTemplate is a sequence of helper calls modeling an idiom of real
+// world programs, e.g. parsing a packet to look up a map and tail call a
+// program. Every instantiation picks different parameters, like offsets and
+// sizes, mostly plausible but sometimes at or past their limits.
+type HelperTemplate struct {
+	Name string
+
+	// Needs returns true if the template can be instantiated in `env`.
+	Needs func(env *TemplateEnvironment) bool
+
+	build func(env *TemplateEnvironment) []*pb.Instruction
+}
+
+// Instantiate returns the instructions of the template for `env` with random
+// parameters. They clobber R0-R5 and R8 and the top 64 bytes of the
+// stack, and fall through to the next instruction.
+func (ht *HelperTemplate) Instantiate(env *TemplateEnvironment) ([]*pb.Instruction, error) {
+	if !ht.Needs(env) {
+		return nil, fmt.Errorf("environment cannot satisfy the template %s", ht.Name)
+	}
+	return InstructionSequence(ht.build(env)...)
+}
+
+// HelperTemplates contains the idioms buzzer can instantiate.
+var HelperTemplates = []*HelperTemplate{
+	{
+		Name:  "parse_lookup_tail_call",
+		Needs: func(env *TemplateEnvironment) bool { return env.HasCtx && env.MapFd >= 0 && env.ProgArrayFd >= 0 },
+		build: parseLookupTailCall,
+	},
+	{
+		Name:  "lookup_or_init_counter",
+		Needs: func(env *TemplateEnvironment) bool { return env.MapFd >= 0 },
+		build: lookupOrInitCounter,
+	},
+	{
+		Name:  "ringbuf_reserve_submit",
+		Needs: func(env *TemplateEnvironment) bool { return env.RingbufFd >= 0 },
+		build: ringbufReserveSubmit,
+	},
+	{
+		Name:  "ringbuf_output",
+		Needs: func(env *TemplateEnvironment) bool { return env.RingbufFd >= 0 },
+		build: ringbufOutput,
+	},
+}
+
+// RandomHelperTemplate instantiates a random template among the ones `env`
+// can satisfy.
+func RandomHelperTemplate(env *TemplateEnvironment) ([]*pb.Instruction, error) {
+	candidates := []*HelperTemplate{}
+	for _, ht := range HelperTemplates {
+		if ht.Needs(env) {
+			candidates = append(candidates, ht)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("environment cannot satisfy any template")
+	}
+	ht := candidates[rand.SharedRNG.RandRange(0, uint64(len(candidates)-1))]
+	return ht.Instantiate(env)
+}
+
+// templateParam returns a random value in [min, max], or one just past
+// either limit one out of ten times.
+func templateParam(min, max int32) int32 {
+	if rand.SharedRNG.OneOf(10) {
+		if rand.SharedRNG.OneOf(2) {
+			return min - 1
+		}
+		return max + 1
+	}
+	return min + int32(rand.SharedRNG.RandRange(0, uint64(max-min)))
+}
+
+// indexMask returns a mask that keeps an index below `size`, rounded up to a
+// power of two so it is sometimes too big.
+func indexMask(size uint32) int32 {
+	mask := int32(1)
+	for uint32(mask) < size {
+		mask <<= 1
+	}
+	return mask - 1
+}
+
+// skipToEnd returns the offset of a jump at index `from` of `insn` to the
+// end of the sequence.
+func skipToEnd(insn []*pb.Instruction, from int) int16 {
+	slots := 0
+	for _, i := range insn[from+1:] {
+		slots += InstructionWidth(i)
+	}
+	return int16(slots)
+}
+
+// parseLookupTailCall loads a few bytes of the packet, uses them as the key
+// of a map lookup and tail calls the program whose index is in the value.
+func parseLookupTailCall(env *TemplateEnvironment) []*pb.Instruction {
+	insn := []*pb.Instruction{
+		StDW(R10, int32(0), templatePacketOffset),
+		Mov64(R1, env.CtxReg),
+		Mov64(R2, templateParam(0, 64)),
+		Mov64(R3, R10),
+		Add64(R3, int32(templatePacketOffset)),
+		Mov64(R4, templateParam(1, 8)),
+		Call(SkbLoadBytes),
+	}
+	bail := len(insn)
+	insn = append(insn,
+		nil, // if r0 != 0 goto out
+		LdW(R1, R10, templatePacketOffset),
+		And64(R1, indexMask(uint32(templateParam(1, 16)))),
+		StW(R10, R1, templateKeyOffset),
+		LdMapByFd(R1, env.MapFd),
+		Mov64(R2, R10),
+		Add64(R2, int32(templateKeyOffset)),
+		Call(MapLookup),
+	)
+	missing := len(insn)
+	insn = append(insn,
+		nil, // if r0 == 0 goto out
+		LdDW(R3, R0, 0),
+		And64(R3, indexMask(env.ProgArraySize)),
+		Mov64(R1, env.CtxReg),
+		LdMapByFd(R2, env.ProgArrayFd),
+		Call(TailCall),
+	)
+	// A tail call only returns when it fails. The later jump goes first so
+	// skipToEnd never sees a placeholder.
+	insn[missing] = JmpEQ(R0, 0, skipToEnd(insn, missing))
+	insn[bail] = JmpNE(R0, 0, skipToEnd(insn, bail))
+	return insn
+}
+
+// lookupOrInitCounter atomically increments a counter in a map, creating it
+// if it doesn't exist yet.
+func lookupOrInitCounter(env *TemplateEnvironment) []*pb.Instruction {
+	insn := []*pb.Instruction{
+		StW(R10, templateParam(0, 16), templateKeyOffset),
+		LdMapByFd(R1, env.MapFd),
+		Mov64(R2, R10),
+		Add64(R2, int32(templateKeyOffset)),
+		Call(MapLookup),
+		JmpEQ(R0, 0, 3),
+		Mov64(R1, templateParam(1, 1)),
+		MemAdd64(R0, R1, 0),
+		nil, // goto out
+	}
+	skip := len(insn) - 1
+	insn = append(insn,
+		StDW(R10, int32(rand.SharedRNG.RandInt()), templateValueOffset),
+		LdMapByFd(R1, env.MapFd),
+		Mov64(R2, R10),
+		Add64(R2, int32(templateKeyOffset)),
+		Mov64(R3, R10),
+		Add64(R3, int32(templateValueOffset)),
+		// BPF_ANY, BPF_NOEXIST or BPF_EXIST.
+		Mov64(R4, templateParam(0, 2)),
+		Call(MapUpdate),
+	)
+	insn[skip] = Jmp(skipToEnd(insn, skip))
+	return insn
+}
+
+// ringbufFlags returns random flags for the ring buffer helpers.
+func ringbufFlags() int32 {
+	return []int32{0, RingbufNoWakeup, RingbufForceWakeup, RingbufNoWakeup | RingbufForceWakeup}[rand.SharedRNG.RandRange(0, 3)]
+}
+
+// ringbufReserveSubmit reserves a record in the ring buffer, fills it and
+// then submits or discards it.
+func ringbufReserveSubmit(env *TemplateEnvironment) []*pb.Instruction {
+	size := templateParam(1, int32(env.RingbufSize/8))
+	insn := []*pb.Instruction{
+		LdMapByFd(R1, env.RingbufFd),
+		Mov64(R2, size),
+		Mov64(R3, int32(0)),
+		Call(RingbufReserve),
+	}
+	bail := len(insn)
+	insn = append(insn,
+		nil, // if r0 == 0 goto out
+		Mov64(templateRecordReg, R0),
+	)
+	for i := rand.SharedRNG.RandRange(1, 4); i > 0 && size > 0; i-- {
+		offset := int16(templateParam(0, size-1))
+		if offset+8 <= int16(size) && rand.SharedRNG.OneOf(2) {
+			insn = append(insn, StDW(templateRecordReg, int32(rand.SharedRNG.RandInt()), offset))
+		} else {
+			insn = append(insn, StB(templateRecordReg, int32(rand.SharedRNG.RandInt()), offset))
+		}
+	}
+	release := int32(RingbufSubmit)
+	if rand.SharedRNG.OneOf(3) {
+		release = RingbufDiscard
+	}
+	insn = append(insn,
+		Mov64(R1, templateRecordReg),
+		Mov64(R2, ringbufFlags()),
+		Call(release),
+	)
+	insn[bail] = JmpEQ(R0, 0, skipToEnd(insn, bail))
+	return insn
+}
+
+// ringbufOutput copies a buffer of the stack to the ring buffer.
+func ringbufOutput(env *TemplateEnvironment) []*pb.Instruction {
+	insn := []*pb.Instruction{}
+	for offset := int16(templateBufferOffset); offset < templateBufferOffset+templateBufferSize; offset += 8 {
+		insn = append(insn, StDW(R10, int32(rand.SharedRNG.RandInt()), offset))
+	}
+	return append(insn,
+		LdMapByFd(R1, env.RingbufFd),
+		Mov64(R2, R10),
+		Add64(R2, int32(templateBufferOffset)),
+		Mov64(R3, templateParam(0, templateBufferSize)),
+		Mov64(R4, ringbufFlags()),
+		Call(RingbufOutput),
+	)
+}
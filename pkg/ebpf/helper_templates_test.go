@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"reflect"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// calledHelpers returns the helpers `insn` calls, in order.
+func calledHelpers(insn []*pb.Instruction) []int32 {
+	var res []int32
+	for _, i := range insn {
+		if Mnemonic(i) == "call" {
+			res = append(res, i.Immediate)
+		}
+	}
+	return res
+}
+
+// checkJumpTargets returns false if a jump of `insn` lands outside of the
+// sequence, its end excluded, or in the middle of a wide instruction.
+func checkJumpTargets(insn []*pb.Instruction) bool {
+	// The instruction that follows the sequence is a valid target.
+	program := &pb.Program{Instructions: append(append([]*pb.Instruction{}, insn...), Exit())}
+	for i, in := range insn {
+		if !IsRelativeJump(in) {
+			continue
+		}
+		if _, ok := JumpTarget(program, i); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestHelperTemplates(t *testing.T) {
+	env := &TemplateEnvironment{
+		CallEnvironment: CallEnvironment{HasCtx: true, CtxReg: R6, MapFd: 3},
+		ProgArrayFd:     4,
+		ProgArraySize:   4,
+		RingbufFd:       5,
+		RingbufSize:     4096,
+	}
+	tests := []struct {
+		testName    string
+		template    string
+		wantHelpers [][]int32
+	}{
+		{
+			testName:    "Packet parsing ends in a tail call",
+			template:    "parse_lookup_tail_call",
+			wantHelpers: [][]int32{{SkbLoadBytes, MapLookup, TailCall}},
+		},
+		{
+			testName:    "Counter is incremented or created",
+			template:    "lookup_or_init_counter",
+			wantHelpers: [][]int32{{MapLookup, MapUpdate}},
+		},
+		{
+			testName:    "Ring buffer record is submitted or discarded",
+			template:    "ringbuf_reserve_submit",
+			wantHelpers: [][]int32{{RingbufReserve, RingbufSubmit}, {RingbufReserve, RingbufDiscard}},
+		},
+		{
+			testName:    "Stack buffer is copied to the ring buffer",
+			template:    "ringbuf_output",
+			wantHelpers: [][]int32{{RingbufOutput}},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			var ht *HelperTemplate
+			for _, candidate := range HelperTemplates {
+				if candidate.Name == tc.template {
+					ht = candidate
+				}
+			}
+			if ht == nil {
+				t.Fatalf("template %s not found", tc.template)
+			}
+
+			// Parameters are random, try a few instantiations.
+			for i := 0; i < 100; i++ {
+				insn, err := ht.Instantiate(env)
+				if err != nil {
+					t.Fatalf("Instantiate() = %v, want nil error", err)
+				}
+				if _, err := EncodeInstructions(&pb.Program{Instructions: insn}); err != nil {
+					t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+				}
+				if !checkJumpTargets(insn) {
+					t.Fatalf("jump outside of the template in %v", insn)
+				}
+				got := calledHelpers(insn)
+				found := false
+				for _, want := range tc.wantHelpers {
+					found = found || reflect.DeepEqual(got, want)
+				}
+				if !found {
+					t.Fatalf("called helpers = %v, want one of %v", got, tc.wantHelpers)
+				}
+			}
+		})
+	}
+}
+
+func TestRandomHelperTemplate(t *testing.T) {
+	mapOnly := &TemplateEnvironment{
+		CallEnvironment: CallEnvironment{MapFd: 3},
+		ProgArrayFd:     -1,
+		RingbufFd:       -1,
+	}
+	for i := 0; i < 20; i++ {
+		insn, err := RandomHelperTemplate(mapOnly)
+		if err != nil {
+			t.Fatalf("RandomHelperTemplate() = %v, want nil error", err)
+		}
+		if got, want := calledHelpers(insn), []int32{MapLookup, MapUpdate}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("called helpers = %v, want %v", got, want)
+		}
+	}
+
+	empty := &TemplateEnvironment{CallEnvironment: CallEnvironment{MapFd: -1}, ProgArrayFd: -1, RingbufFd: -1}
+	if _, err := RandomHelperTemplate(empty); err == nil {
+		t.Errorf("RandomHelperTemplate() without maps did not return an error")
+	}
+}
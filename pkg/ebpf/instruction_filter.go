@@ -0,0 +1,252 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// How many times the random instruction generators try to produce an
+// instruction the active filter allows before giving up.
+const maxFilteredAttempts = 1024
+
+var aluMnemonics = map[pb.AluOperationCode]string{
+	pb.AluOperationCode_AluAdd:  "add",
+	pb.AluOperationCode_AluSub:  "sub",
+	pb.AluOperationCode_AluMul:  "mul",
+	pb.AluOperationCode_AluDiv:  "div",
+	pb.AluOperationCode_AluOr:   "or",
+	pb.AluOperationCode_AluAnd:  "and",
+	pb.AluOperationCode_AluLsh:  "lsh",
+	pb.AluOperationCode_AluRsh:  "rsh",
+	pb.AluOperationCode_AluNeg:  "neg",
+	pb.AluOperationCode_AluMod:  "mod",
+	pb.AluOperationCode_AluXor:  "xor",
+	pb.AluOperationCode_AluMov:  "mov",
+	pb.AluOperationCode_AluArsh: "arsh",
+	pb.AluOperationCode_AluEnd:  "end",
+}
+
+var jmpMnemonics = map[pb.JmpOperationCode]string{
+	pb.JmpOperationCode_JmpJA:   "ja",
+	pb.JmpOperationCode_JmpJEQ:  "jeq",
+	pb.JmpOperationCode_JmpJGT:  "jgt",
+	pb.JmpOperationCode_JmpJGE:  "jge",
+	pb.JmpOperationCode_JmpJSET: "jset",
+	pb.JmpOperationCode_JmpJNE:  "jne",
+	pb.JmpOperationCode_JmpJSGT: "jsgt",
+	pb.JmpOperationCode_JmpJSGE: "jsge",
+	pb.JmpOperationCode_JmpCALL: "call",
+	pb.JmpOperationCode_JmpExit: "exit",
+	pb.JmpOperationCode_JmpJLT:  "jlt",
+	pb.JmpOperationCode_JmpJLE:  "jle",
+	pb.JmpOperationCode_JmpJSLT: "jslt",
+	pb.JmpOperationCode_JmpJSLE: "jsle",
+}
+
+// memMnemonics are the names of the load and store instructions that are
+// not atomic.
+var memMnemonics = []string{"lddw", "ld_abs", "ld_ind", "ldx", "ldsx", "st", "stx"}
+
+// Mnemonics returns every name Mnemonic can return.
+func Mnemonics() []string {
+	var names []string
+	for _, name := range aluMnemonics {
+		names = append(names, name)
+	}
+	names = append(names, "sdiv", "smod")
+	for _, name := range jmpMnemonics {
+		names = append(names, name)
+	}
+	names = append(names, memMnemonics...)
+	for _, name := range atomicOpStrings {
+		names = append(names, "atomic_"+name, "atomic_fetch_"+name)
+	}
+	return append(names, "atomic_xchg", "atomic_cmpxchg")
+}
+
+// Mnemonic returns the name of the operation of `insn`, regardless of its
+// width and source, e.g. "add", "sdiv", "jeq", "ldx" or "atomic_fetch_or".
+// Instruction filters match the names.
+func Mnemonic(insn *pb.Instruction) string {
+	switch op := insn.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		if IsSignedAluInstruction(insn) {
+			return "s" + aluMnemonics[op.AluOpcode.OperationCode]
+		}
+		return aluMnemonics[op.AluOpcode.OperationCode]
+	case *pb.Instruction_JmpOpcode:
+		return jmpMnemonics[op.JmpOpcode.OperationCode]
+	case *pb.Instruction_MemOpcode:
+		return memMnemonic(insn, op.MemOpcode)
+	}
+	return ""
+}
+
+func memMnemonic(insn *pb.Instruction, op *pb.MemOpcode) string {
+	switch op.Mode {
+	case pb.StLdMode_StLdModeATOMIC:
+		switch insn.Immediate {
+		case atomicXchg:
+			return "atomic_xchg"
+		case atomicCmpXchg:
+			return "atomic_cmpxchg"
+		}
+		name := "atomic_" + atomicOpStrings[insn.Immediate&^atomicFetch]
+		if insn.Immediate&atomicFetch != 0 {
+			name = "atomic_fetch_" + atomicOpStrings[insn.Immediate&^atomicFetch]
+		}
+		return name
+	case pb.StLdMode_StLdModeIMM:
+		return "lddw"
+	case pb.StLdMode_StLdModeABS:
+		return "ld_abs"
+	case pb.StLdMode_StLdModeIND:
+		return "ld_ind"
+	case pb.StLdMode_StLdModeMEMSX:
+		return "ldsx"
+	}
+	switch op.InstructionClass {
+	case pb.InsClass_InsClassSt:
+		return "st"
+	case pb.InsClass_InsClassStx:
+		return "stx"
+	}
+	return "ldx"
+}
+
+// structuralMnemonics are the instructions every strategy needs to build a
+// program: to set up registers, call helpers, load maps and return. The
+// allow-list only keeps the random instruction generators from using them,
+// programs are only checked for them against the block-list, see
+// FirstBlocked.
+var structuralMnemonics = []string{"exit", "mov", "call", "lddw"}
+
+// InstructionFilter restricts the instructions programs can use by their
+// mnemonic, see Mnemonic.
+type InstructionFilter struct {
+	allow []string
+	block []string
+}
+
+// parsePatterns splits the comma separated glob `patterns`, e.g.
+// "div,mod,atomic_*", and checks that each of them matches an instruction.
+func parsePatterns(patterns string) ([]string, error) {
+	if patterns == "" {
+		return nil, nil
+	}
+	names := Mnemonics()
+	var res []string
+	for _, pattern := range strings.Split(patterns, ",") {
+		pattern = strings.TrimSpace(pattern)
+		matched := false
+		for _, name := range names {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, err
+			}
+			matched = matched || ok
+		}
+		if !matched {
+			return nil, fmt.Errorf("%q does not match any instruction", pattern)
+		}
+		res = append(res, pattern)
+	}
+	return res, nil
+}
+
+// NewInstructionFilter returns a filter that allows the instructions matching
+// one of the patterns of `allow`, or all of them if it is empty, and do not
+// match any of the patterns of `block`. Both are comma separated globs, e.g.
+// "div,mod,atomic_*".
+func NewInstructionFilter(allow, block string) (*InstructionFilter, error) {
+	f := &InstructionFilter{}
+	var err error
+	if f.allow, err = parsePatterns(allow); err != nil {
+		return nil, err
+	}
+	if f.block, err = parsePatterns(block); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// matchesAny returns true if `name` matches one of `patterns`.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Allows returns true if the filter lets programs use `insn`. A nil filter
+// allows every instruction.
+func (f *InstructionFilter) Allows(insn *pb.Instruction) bool {
+	if f == nil {
+		return true
+	}
+	name := Mnemonic(insn)
+	if len(f.allow) > 0 && !matchesAny(f.allow, name) {
+		return false
+	}
+	return !matchesAny(f.block, name)
+}
+
+// FirstBlocked returns the index of the first instruction of `prog` the
+// filter does not allow, or -1 if it allows all of them. The structural
+// instructions, see structuralMnemonics, are allowed unless they are blocked.
+func (f *InstructionFilter) FirstBlocked(prog *pb.Program) int {
+	if f == nil {
+		return -1
+	}
+	for i, insn := range prog.GetInstructions() {
+		if f.Allows(insn) {
+			continue
+		}
+		if name := Mnemonic(insn); slices.Contains(structuralMnemonics, name) && !matchesAny(f.block, name) {
+			continue
+		}
+		return i
+	}
+	return -1
+}
+
+// activeFilter is the filter the random instruction generators follow.
+var activeFilter *InstructionFilter
+
+// SetInstructionFilter makes the random instruction generators, e.g.
+// RandomAluInstruction, avoid the instructions `f` does not allow. nil
+// removes the filter.
+func SetInstructionFilter(f *InstructionFilter) {
+	activeFilter = f
+}
+
+// filtered calls `generate` until it returns an instruction the active filter
+// allows and the JIT of the active architecture translates. If it doesn't
+// after maxFilteredAttempts, the last instruction is returned anyway.
+func filtered(generate func() *pb.Instruction) *pb.Instruction {
+	insn := generate()
+	for i := 1; i < maxFilteredAttempts && !(activeFilter.Allows(insn) && activeArch.Supports(insn)); i++ {
+		insn = generate()
+	}
+	return insn
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestMnemonic(t *testing.T) {
+	tests := []struct {
+		testName    string
+		instruction *pb.Instruction
+		want        string
+	}{
+		{"64 bit addition", Add64(R1, R2), "add"},
+		{"32 bit addition", Add(R1, 3), "add"},
+		{"Signed modulo", SMod64(R1, R2), "smod"},
+		{"Conditional jump", JmpSGT32(R1, 0, 1), "jsgt"},
+		{"Call", Call(MapLookup), "call"},
+		{"Map load", LdMapByFd(R1, 3), "lddw"},
+		{"Packet load", LdAbsW(0), "ld_abs"},
+		{"Sign extending load", LdSXB(R1, R2, 0), "ldsx"},
+		{"Load", LdDW(R1, R10, -8), "ldx"},
+		{"Store immediate", StW(R10, 1, -4), "st"},
+		{"Store register", StDW(R10, R1, -8), "stx"},
+		{"Atomic add", MemAdd64(R10, R1, -8), "atomic_add"},
+		{"Atomic fetch or", newAtomicInstruction(R10, R1, pb.StLdSize_StLdSizeDW, -8, int32(pb.AluOperationCode_AluOr)|atomicFetch), "atomic_fetch_or"},
+		{"Atomic exchange", newAtomicInstruction(R10, R1, pb.StLdSize_StLdSizeDW, -8, atomicXchg), "atomic_xchg"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := Mnemonic(tc.instruction); got != tc.want {
+				t.Errorf("Mnemonic() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestInstructionFilter(t *testing.T) {
+	tests := []struct {
+		testName    string
+		allow       string
+		block       string
+		instruction *pb.Instruction
+		want        bool
+		wantErr     bool
+	}{
+		{"No patterns", "", "", Div64(R1, R2), true, false},
+		{"Blocked", "", "div,mod", Div64(R1, R2), false, false},
+		{"Blocked by a glob", "", "atomic_*", MemXor64(R10, R1, -8), false, false},
+		{"Not allowed", "add,exit", "", Sub64(R1, 1), false, false},
+		{"Allowed", "add,exit", "", Add(R1, 1), true, false},
+		{"Allowed but blocked", "atomic_*", "atomic_xor", MemXor64(R10, R1, -8), false, false},
+		{"Unknown mnemonic", "", "divide", nil, false, true},
+		{"Malformed glob", "[", "", nil, false, true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			f, err := NewInstructionFilter(tc.allow, tc.block)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("NewInstructionFilter(%q, %q) error = %v, want error %v", tc.allow, tc.block, err, tc.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got := f.Allows(tc.instruction); got != tc.want {
+				t.Errorf("Allows() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFirstBlocked(t *testing.T) {
+	prog := &pb.Program{Instructions: []*pb.Instruction{Mov64(R0, 0), LdMapByFd(R1, 3), Call(1), Div64(R0, 2), Exit()}}
+	tests := []struct {
+		testName string
+		allow    string
+		block    string
+		want     int
+	}{
+		{"Structural instructions are always allowed", "div,mod,atomic_*", "", -1},
+		{"Not allowed", "mod", "", 3},
+		{"Structural instruction blocked", "div", "call", 2},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			f, err := NewInstructionFilter(tc.allow, tc.block)
+			if err != nil {
+				t.Fatalf("NewInstructionFilter(%q, %q) = %v", tc.allow, tc.block, err)
+			}
+			if got := f.FirstBlocked(prog); got != tc.want {
+				t.Errorf("FirstBlocked() = %d, want %d", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestFilteredGenerators(t *testing.T) {
+	f, err := NewInstructionFilter("jeq,xor", "")
+	if err != nil {
+		t.Fatalf("NewInstructionFilter() = %v", err)
+	}
+	SetInstructionFilter(f)
+	defer SetInstructionFilter(nil)
+
+	for i := 0; i < 100; i++ {
+		if insn := RandomJmpInstruction(10); Mnemonic(insn) != "jeq" {
+			t.Fatalf("RandomJmpInstruction() = %s, want a jeq", Mnemonic(insn))
+		}
+		if insn := RandomAluInstruction(); Mnemonic(insn) != "xor" {
+			t.Fatalf("RandomAluInstruction() = %s, want a xor", Mnemonic(insn))
+		}
+	}
+}
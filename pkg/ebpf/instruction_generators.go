@@ -15,6 +15,8 @@
 package ebpf
 
 import (
+	"math"
+
 	"buzzer/pkg/rand"
 	pb "buzzer/proto/ebpf_go_proto"
 )
@@ -22,14 +24,13 @@ import (
 // GenerateRandomAluInstruction provides a random ALU operation with either
 // IMM or Reg src that will be applied to a random dst reg.
 func RandomAluInstruction() *pb.Instruction {
+	return filtered(randomAluInstruction)
+}
+
+func randomAluInstruction() *pb.Instruction {
 	op := RandomAluOp()
 	dstReg := RandomRegister()
-	var insClass pb.InsClass
-	if rand.SharedRNG.RandRange(0, 1) == 0 {
-		insClass = pb.InsClass_InsClassAlu
-	} else {
-		insClass = pb.InsClass_InsClassAlu64
-	}
+	insClass := pickClass(pb.InsClass_InsClassAlu, pb.InsClass_InsClassAlu64)
 
 	// Toss another coin to decide if we are going to do an imm alu
 	// operation or one that uses a src register.
@@ -47,6 +48,12 @@ func RandomAluInstruction() *pb.Instruction {
 // offset of at most `maxOffset` this is to minimize the possibility of a jmp
 // out of the bounds of a program.
 func RandomJmpInstruction(maxOffset uint64) *pb.Instruction {
+	return filtered(func() *pb.Instruction {
+		return randomJmpInstruction(maxOffset)
+	})
+}
+
+func randomJmpInstruction(maxOffset uint64) *pb.Instruction {
 	var op pb.JmpOperationCode
 
 	// Exit, Call or JA operations require special parameters (e.g an offset
@@ -58,17 +65,15 @@ func RandomJmpInstruction(maxOffset uint64) *pb.Instruction {
 		}
 	}
 
-	var insClass pb.InsClass
-	if rand.SharedRNG.OneOf(2) {
-		insClass = pb.InsClass_InsClassJmp32
-	} else {
-		insClass = pb.InsClass_InsClassJmp
-	}
+	insClass := pickClass(pb.InsClass_InsClassJmp32, pb.InsClass_InsClassJmp)
 
 	dstReg := RandomRegister()
 	offset := int16(rand.SharedRNG.RandRange(1, maxOffset))
 	if rand.SharedRNG.OneOf(2) {
-		src := int32(rand.SharedRNG.RandRange(0, 0xffffffff))
+		src := randomImmediate(rand.SharedRNG.RandRange(0, 0xffffffff))
+		if rand.SharedRNG.OneOf(4) {
+			src = SignBoundaryImmediates[rand.SharedRNG.RandRange(0, uint64(len(SignBoundaryImmediates)-1))]
+		}
 		return newJmpInstruction(op, insClass, dstReg, src, offset)
 	} else {
 		src := RandomRegister()
@@ -76,6 +81,16 @@ func RandomJmpInstruction(maxOffset uint64) *pb.Instruction {
 	}
 }
 
+// SignBoundaryImmediates are the immediates next to the boundaries where
+// signed and unsigned comparisons of 32 and 64 bit values disagree, e.g.
+// 0x80000000 is the smallest 32 bit signed value but a large unsigned one.
+// One out of eight random conditional jumps compares with one of them.
+var SignBoundaryImmediates = []int32{
+	0, -1, 1,
+	math.MaxInt32, math.MaxInt32 - 1,
+	math.MinInt32, math.MinInt32 + 1,
+}
+
 // RandomSize is a helper function to be used in the RandomMemInstruction
 // functions. The result of this function should be one of the recognized
 // operation sizes of ebpf (https://www.kernel.org/doc/html/v5.18/bpf/instruction-set.html#:~:text=The%20size%20modifier%20is%20one%20of%3A)
@@ -130,6 +145,25 @@ func RandomOffset(s pb.StLdSize) int16 {
 
 // Returns a random store or load instruction to the stack.
 func RandomMemInstruction() *pb.Instruction {
+	return filtered(randomMemInstruction)
+}
+
+func randomMemInstruction() *pb.Instruction {
+	if activeWeights != nil {
+		// Stores of a register and atomic operations share their class.
+		switch pickClass(pb.InsClass_InsClassLdx, pb.InsClass_InsClassSt, pb.InsClass_InsClassStx) {
+		case pb.InsClass_InsClassLdx:
+			return RandomLoadInstruction()
+		case pb.InsClass_InsClassSt:
+			return randomStoreImmInstruction()
+		default:
+			if rand.SharedRNG.OneOf(2) {
+				return randomStoreRegInstruction()
+			}
+			return RandomAtomicInstruction()
+		}
+	}
+
 	t := rand.SharedRNG.RandInt() % 3
 	switch t {
 	case 0:
@@ -150,6 +184,13 @@ func RandomAtomicInstruction() *pb.Instruction {
 	}
 	size := validSizes[rand.SharedRNG.RandInt()%2]
 	offset := RandomOffset(size)
+	if activeArch.XaddOnly() {
+		// Older verifiers reject the whole program on any other operation.
+		if size == pb.StLdSize_StLdSizeDW {
+			return Xadd64(R10, src, offset)
+		}
+		return Xadd(R10, src, offset)
+	}
 	validOperations := []pb.AluOperationCode{
 		pb.AluOperationCode_AluAdd,
 		pb.AluOperationCode_AluAnd,
@@ -161,17 +202,23 @@ func RandomAtomicInstruction() *pb.Instruction {
 }
 
 func RandomStoreInstruction() *pb.Instruction {
-	size := RandomSize()
-	offset := RandomOffset(size)
-
 	// Decide if we are doing a Store from a register or a constant.
 	if rand.SharedRNG.OneOf(2) {
-		// Constant
-		imm := int32(rand.SharedRNG.RandInt())
-		return newStoreOperation(size, R10, imm, offset)
+		return randomStoreImmInstruction()
 	}
+	return randomStoreRegInstruction()
+}
 
-	// Register
+func randomStoreImmInstruction() *pb.Instruction {
+	size := RandomSize()
+	offset := RandomOffset(size)
+	imm := randomImmediate(rand.SharedRNG.RandInt())
+	return newStoreOperation(size, R10, imm, offset)
+}
+
+func randomStoreRegInstruction() *pb.Instruction {
+	size := RandomSize()
+	offset := RandomOffset(size)
 	src := RandomRegister()
 	return newStoreOperation(size, R10, src, offset)
 }
@@ -180,6 +227,9 @@ func RandomLoadInstruction() *pb.Instruction {
 	size := RandomSize()
 	offset := RandomOffset(size)
 	dst := RandomRegister()
+	if size != pb.StLdSize_StLdSizeDW && rand.SharedRNG.OneOf(4) {
+		return newSignExtendLoadOperation(size, dst, R10, offset)
+	}
 	return newLoadOperation(size, dst, R10, offset)
 }
 
@@ -192,7 +242,13 @@ func RandomJumpOp() pb.JmpOperationCode {
 func RandomAluOp() pb.AluOperationCode {
 	// Shift by 4 bits because we need to respect the ebpf encoding:
 	// https://docs.kernel.org/bpf/instruction-set.html#id6
-	return pb.AluOperationCode(rand.SharedRNG.RandRange(0x00, 0x0c) << 4)
+	return pb.AluOperationCode(rand.SharedRNG.RandRange(0x00, 0x0d) << 4)
+}
+
+// randomEndWidth returns one of the widths byte swap instructions accept.
+func randomEndWidth() int32 {
+	widths := []int32{16, 32, 64}
+	return widths[rand.SharedRNG.RandRange(0, uint64(len(widths)-1))]
 }
 
 // IsConditional determines if the operator is not an Exit, Call or JA
@@ -203,11 +259,18 @@ func IsConditional(op pb.JmpOperationCode) bool {
 
 // RandomRegister returns a random register from R0 to R9.
 func RandomRegister() pb.Reg {
+	if activeWeights != nil {
+		weights := make([]uint32, R9+1)
+		for reg := R0; reg <= R9; reg++ {
+			weights[reg] = activeWeights.register(reg)
+		}
+		return pb.Reg(weightedIndex(weights))
+	}
 	return pb.Reg(rand.SharedRNG.RandRange(0, 9))
 }
 
 func generateImmAluInstruction(op pb.AluOperationCode, insClass pb.InsClass, dstReg pb.Reg) *pb.Instruction {
-	value := int32(rand.SharedRNG.RandRange(0, 0xFFFFFFFF))
+	value := randomImmediate(rand.SharedRNG.RandRange(0, 0xFFFFFFFF))
 	switch op {
 	case pb.AluOperationCode_AluRsh, pb.AluOperationCode_AluLsh, pb.AluOperationCode_AluArsh:
 		var maxShift = int32(64)
@@ -220,9 +283,24 @@ func generateImmAluInstruction(op pb.AluOperationCode, insClass pb.InsClass, dst
 		}
 	case pb.AluOperationCode_AluNeg:
 		value = 0
+	case pb.AluOperationCode_AluEnd:
+		if insClass == pb.InsClass_InsClassAlu64 {
+			return Bswap(dstReg, randomEndWidth())
+		}
+		return ToLe(dstReg, randomEndWidth())
 	}
 
-	return newAluInstruction(op, insClass, dstReg, value)
+	return maybeSigned(newAluInstruction(op, insClass, dstReg, value))
+}
+
+// maybeSigned turns half of the divisions and modulos into their signed
+// variant.
+func maybeSigned(i *pb.Instruction) *pb.Instruction {
+	op := i.GetAluOpcode().OperationCode
+	if (op == pb.AluOperationCode_AluDiv || op == pb.AluOperationCode_AluMod) && rand.SharedRNG.OneOf(2) {
+		i.Offset = 1
+	}
+	return i
 }
 
 func generateRegAluInstruction(op pb.AluOperationCode, insClass pb.InsClass, dstReg pb.Reg) *pb.Instruction {
@@ -232,5 +310,14 @@ func generateRegAluInstruction(op pb.AluOperationCode, insClass pb.InsClass, dst
 		op = pb.AluOperationCode(rand.SharedRNG.RandRange(0x00, 0x0c) << 4)
 	}
 
-	return newAluInstruction(op, insClass, dstReg, srcReg)
+	// Byte swaps have no source register, the source bit selects big
+	// endian instead.
+	if op == pb.AluOperationCode_AluEnd {
+		if insClass == pb.InsClass_InsClassAlu64 {
+			return Bswap(dstReg, randomEndWidth())
+		}
+		return ToBe(dstReg, randomEndWidth())
+	}
+
+	return maybeSigned(newAluInstruction(op, insClass, dstReg, srcReg))
 }
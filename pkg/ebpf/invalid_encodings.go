@@ -0,0 +1,338 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+	"strings"
+)
+
+// InvalidEncoding is a program the kernel must refuse because of a single
+// instruction with an invalid encoding.
+type InvalidEncoding struct {
+	// Name describes the invalid instruction.
+	Name string
+
+	// Index is the position of the invalid instruction in Program.
+	Index int
+
+	Program *pb.Program
+}
+
+var (
+	memoryClasses = []pb.InsClass{
+		pb.InsClass_InsClassLd,
+		pb.InsClass_InsClassLdx,
+		pb.InsClass_InsClassSt,
+		pb.InsClass_InsClassStx,
+	}
+
+	memorySizes = []pb.StLdSize{
+		pb.StLdSize_StLdSizeW,
+		pb.StLdSize_StLdSizeH,
+		pb.StLdSize_StLdSizeB,
+		pb.StLdSize_StLdSizeDW,
+	}
+)
+
+// isValidMemoryOpcode returns true if the kernel accepts load and store
+// instructions with the given mode, size and class. It follows the opcode
+// table of kernel/bpf/core.c and the checks of the verifier for user
+// programs.
+func isValidMemoryOpcode(mode pb.StLdMode, size pb.StLdSize, class pb.InsClass) bool {
+	switch class {
+	case pb.InsClass_InsClassLd:
+		if mode == pb.StLdMode_StLdModeIMM {
+			return size == pb.StLdSize_StLdSizeDW
+		}
+		return (mode == pb.StLdMode_StLdModeABS || mode == pb.StLdMode_StLdModeIND) && size != pb.StLdSize_StLdSizeDW
+	case pb.InsClass_InsClassLdx:
+		return mode == pb.StLdMode_StLdModeMEM || (mode == pb.StLdMode_StLdModeMEMSX && size != pb.StLdSize_StLdSizeDW)
+	case pb.InsClass_InsClassSt:
+		return mode == pb.StLdMode_StLdModeMEM
+	case pb.InsClass_InsClassStx:
+		if mode == pb.StLdMode_StLdModeATOMIC {
+			// Atomic additions only exist for words and double words.
+			return size == pb.StLdSize_StLdSizeW || size == pb.StLdSize_StLdSizeDW
+		}
+		return mode == pb.StLdMode_StLdModeMEM
+	}
+	return false
+}
+
+// newMemInstruction returns a load or store with an arbitrary mode, size and
+// class, which the builders of this package don't allow.
+func newMemInstruction(mode pb.StLdMode, size pb.StLdSize, class pb.InsClass, dst, src pb.Reg, offset int16) *pb.Instruction {
+	return &pb.Instruction{
+		Opcode: &pb.Instruction_MemOpcode{
+			MemOpcode: &pb.MemOpcode{
+				Mode:             mode,
+				Size:             size,
+				InstructionClass: class,
+			},
+		},
+		DstReg:    dst,
+		SrcReg:    src,
+		Offset:    int32(offset),
+		Immediate: UnusedField,
+		PseudoInstruction: &pb.Instruction_Empty{
+			Empty: &pb.Empty{},
+		},
+	}
+}
+
+// newInvalidEncoding wraps `insn` in a program that is valid otherwise: R1
+// is zero, the program context is in R6 and the stack slot at R10-8 is
+// initialized. The program returns 0 right after `insn`, an invalid exit
+// instruction is the last instruction of the program.
+func newInvalidEncoding(name string, insn *pb.Instruction) InvalidEncoding {
+	program := &pb.Program{
+		Instructions: []*pb.Instruction{
+			Mov64(R6, R1),
+			Mov64(R1, 0),
+			StDW(R10, 0, -8),
+		},
+	}
+	if insn.GetJmpOpcode().GetOperationCode() == pb.JmpOperationCode_JmpExit {
+		program.Instructions = append(program.Instructions, Mov64(R0, 0), insn)
+		return InvalidEncoding{Name: name, Index: len(program.Instructions) - 1, Program: program}
+	}
+	program.Instructions = append(program.Instructions, insn, Mov64(R0, 0), Exit())
+	return InvalidEncoding{Name: name, Index: len(program.Instructions) - 3, Program: program}
+}
+
+// memoryEncodingName returns a readable name for a load or store encoding,
+// e.g. "0x99 Ldx MEMSX DW".
+func memoryEncodingName(insn *pb.Instruction) string {
+	op := insn.GetMemOpcode()
+	encoding, _ := encodeMemOpcode(op)
+	mode := strings.TrimPrefix(op.Mode.String(), "StLdMode")
+	size := strings.TrimPrefix(op.Size.String(), "StLdSize")
+	class := strings.TrimPrefix(op.InstructionClass.String(), "InsClass")
+	return fmt.Sprintf("0x%02x %s %s %s", encoding, class, mode, size)
+}
+
+// InvalidMemoryEncodings returns a program for every combination of mode,
+// size and class of load and store instructions the kernel must refuse. The
+// rest of each program is valid: the operands of the invalid instruction
+// point to an initialized stack slot and the program context is in R6 for
+// packet loads.
+func InvalidMemoryEncodings() []InvalidEncoding {
+	var result []InvalidEncoding
+	for _, class := range memoryClasses {
+		// The mode takes the 3 most significant bits of the opcode, this
+		// includes the values that are not defined.
+		for mode := 0; mode < 0x100; mode += 0x20 {
+			for _, size := range memorySizes {
+				if isValidMemoryOpcode(pb.StLdMode(mode), size, class) {
+					continue
+				}
+				var insn *pb.Instruction
+				switch class {
+				case pb.InsClass_InsClassLd, pb.InsClass_InsClassLdx:
+					insn = newMemInstruction(pb.StLdMode(mode), size, class, R2, R10, -8)
+				default:
+					insn = newMemInstruction(pb.StLdMode(mode), size, class, R10, R1, -8)
+				}
+				result = append(result, newInvalidEncoding(memoryEncodingName(insn), insn))
+			}
+		}
+	}
+	return result
+}
+
+// encodedOpcode returns the opcode byte `insn` encodes to.
+func encodedOpcode(insn *pb.Instruction) uint8 {
+	encoding, _ := encodeInstruction(insn, LittleEndian)
+	return uint8(encoding[0] & 0xff)
+}
+
+// randomNonZero returns a random value in [1, max].
+func randomNonZero(max uint64) uint64 {
+	return rand.SharedRNG.RandRange(1, max)
+}
+
+// randomInvalidRegister returns one of the registers that fit in the 4 bits
+// of the encoding but don't exist, R11 to R15.
+func randomInvalidRegister() pb.Reg {
+	return pb.Reg(rand.SharedRNG.RandRange(uint64(R10)+1, 0x0f))
+}
+
+// reservedAluOperation returns an ALU instruction with one of the operation
+// codes no operation uses, 0xe0 and 0xf0.
+func reservedAluOperation() (string, *pb.Instruction) {
+	insn := Add64(R1, R1)
+	if rand.SharedRNG.OneOf(2) {
+		insn = Add(R1, 1)
+	}
+	insn.GetAluOpcode().OperationCode = pb.AluOperationCode(rand.SharedRNG.RandRange(0xe, 0xf) << 4)
+	return fmt.Sprintf("0x%02x reserved ALU operation", encodedOpcode(insn)), insn
+}
+
+// reservedJmpOperation returns a jump instruction with one of the operation
+// codes no jump uses, 0xe0 and 0xf0, or a call or exit of the 32 bit jump
+// class.
+func reservedJmpOperation() (string, *pb.Instruction) {
+	var insn *pb.Instruction
+	switch rand.SharedRNG.RandRange(0, 2) {
+	case 0:
+		insn = JmpEQ(R1, 0, 0)
+		if rand.SharedRNG.OneOf(2) {
+			insn = JmpEQ32(R1, R1, 0)
+		}
+		insn.GetJmpOpcode().OperationCode = pb.JmpOperationCode(rand.SharedRNG.RandRange(0xe, 0xf) << 4)
+	case 1:
+		insn = Call(GetPrandomU32)
+		insn.GetJmpOpcode().InstructionClass = pb.InsClass_InsClassJmp32
+	default:
+		insn = Exit()
+		insn.GetJmpOpcode().InstructionClass = pb.InsClass_InsClassJmp32
+	}
+	return fmt.Sprintf("0x%02x reserved jump operation", encodedOpcode(insn)), insn
+}
+
+// aluReservedFields returns an ALU instruction with a field its operation
+// does not use set: the immediate of a register operation, the source
+// register of an immediate operation or the offset, which only signed
+// divisions and sign extending moves use.
+func aluReservedFields() (string, *pb.Instruction) {
+	operations := []pb.AluOperationCode{
+		pb.AluOperationCode_AluAdd,
+		pb.AluOperationCode_AluSub,
+		pb.AluOperationCode_AluMul,
+		pb.AluOperationCode_AluOr,
+		pb.AluOperationCode_AluAnd,
+		pb.AluOperationCode_AluXor,
+		pb.AluOperationCode_AluLsh,
+		pb.AluOperationCode_AluRsh,
+		pb.AluOperationCode_AluArsh,
+	}
+	var insn *pb.Instruction
+	var field string
+	switch rand.SharedRNG.RandRange(0, 2) {
+	case 0:
+		insn = Add64(R1, R1)
+		insn.Immediate = int32(randomNonZero(0xffffffff))
+		field = "immediate"
+	case 1:
+		insn = Add64(R1, 1)
+		insn.SrcReg = pb.Reg(randomNonZero(uint64(R10)))
+		field = "src_reg"
+	default:
+		insn = Add64(R1, R1)
+		insn.Offset = int32(randomNonZero(0x7fff))
+		field = "offset"
+	}
+	insn.GetAluOpcode().OperationCode = operations[rand.SharedRNG.RandRange(0, uint64(len(operations)-1))]
+	if rand.SharedRNG.OneOf(2) {
+		insn.GetAluOpcode().InstructionClass = pb.InsClass_InsClassAlu
+	}
+	return fmt.Sprintf("0x%02x ALU operation with reserved %s", encodedOpcode(insn), field), insn
+}
+
+// exitReservedFields returns an exit instruction with one of the unused
+// fields the verifier checks set. The offset is not checked.
+func exitReservedFields() (string, *pb.Instruction) {
+	insn := Exit()
+	var field string
+	switch rand.SharedRNG.RandRange(0, 3) {
+	case 0:
+		insn.DstReg = pb.Reg(randomNonZero(uint64(R10)))
+		field = "dst_reg"
+	case 1:
+		insn.SrcReg = pb.Reg(randomNonZero(uint64(R10)))
+		field = "src_reg"
+	case 2:
+		insn.Immediate = int32(randomNonZero(0xffffffff))
+		field = "immediate"
+	default:
+		insn.GetJmpOpcode().Source = pb.SrcOperand_RegSrc
+		field = "source"
+	}
+	return fmt.Sprintf("0x%02x exit with reserved %s", encodedOpcode(insn), field), insn
+}
+
+// wideLoadReservedFields returns a 64 bit immediate load with a source
+// register that is not one of the pseudo values of the kernel or a second
+// half that has something else than the upper 32 bits of the constant set.
+func wideLoadReservedFields() (string, *pb.Instruction) {
+	insn := LdImm64(R1, rand.SharedRNG.RandInt())
+	pseudo := insn.GetPseudoValue()
+	var field string
+	switch rand.SharedRNG.RandRange(0, 4) {
+	case 0:
+		// BPF_PSEUDO_MAP_IDX_VALUE, 6, is the last pseudo value.
+		insn.SrcReg = pb.Reg(rand.SharedRNG.RandRange(7, 0x0f))
+		field = "pseudo value"
+	case 1:
+		pseudo.DstReg = pb.Reg(randomNonZero(uint64(R10)))
+		field = "second half dst_reg"
+	case 2:
+		pseudo.SrcReg = pb.Reg(randomNonZero(uint64(R10)))
+		field = "second half src_reg"
+	case 3:
+		pseudo.Offset = int32(randomNonZero(0x7fff))
+		field = "second half offset"
+	default:
+		pseudo.GetMemOpcode().Mode = pb.StLdMode(randomNonZero(7) << 5)
+		field = "second half opcode"
+	}
+	return fmt.Sprintf("0x%02x 64 bit load with reserved %s", encodedOpcode(insn), field), insn
+}
+
+// invalidRegister returns an instruction that uses one of the registers
+// that don't exist, or writes to the read only frame pointer.
+func invalidRegister() (string, *pb.Instruction) {
+	switch rand.SharedRNG.RandRange(0, 3) {
+	case 0:
+		reg := randomInvalidRegister()
+		return fmt.Sprintf("mov to r%d", reg), Mov64(reg, 0)
+	case 1:
+		reg := randomInvalidRegister()
+		return fmt.Sprintf("mov from r%d", reg), Mov64(R1, reg)
+	case 2:
+		reg := randomInvalidRegister()
+		return fmt.Sprintf("load from r%d", reg), LdDW(R2, reg, -8)
+	default:
+		return "mov to the frame pointer", Mov64(R10, 0)
+	}
+}
+
+// invalidInstructionGenerators return an instruction with an invalid
+// encoding along with a description of what is wrong with it.
+var invalidInstructionGenerators = []func() (string, *pb.Instruction){
+	reservedAluOperation,
+	reservedJmpOperation,
+	aluReservedFields,
+	exitReservedFields,
+	wideLoadReservedFields,
+	invalidRegister,
+}
+
+// RandomInvalidEncoding returns a program the kernel must refuse because of a
+// single random instruction with an invalid encoding: reserved opcode bits
+// or fields set, an invalid combination of mode, size and class of a load or
+// store, or a register that does not exist. Like with
+// InvalidMemoryEncodings, the rest of the program is valid.
+func RandomInvalidEncoding() InvalidEncoding {
+	i := rand.SharedRNG.RandRange(0, uint64(len(invalidInstructionGenerators)))
+	if i == uint64(len(invalidInstructionGenerators)) {
+		memory := InvalidMemoryEncodings()
+		return memory[rand.SharedRNG.RandRange(0, uint64(len(memory)-1))]
+	}
+	return newInvalidEncoding(invalidInstructionGenerators[i]())
+}
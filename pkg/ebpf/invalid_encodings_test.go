@@ -0,0 +1,144 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func invalidOpcodes(t *testing.T) map[uint8]string {
+	t.Helper()
+	opcodes := make(map[uint8]string)
+	for _, invalid := range InvalidMemoryEncodings() {
+		encoding, err := encodeInstruction(invalid.Program.Instructions[invalid.Index], LittleEndian)
+		if err != nil {
+			t.Fatalf("could not encode %s: %v", invalid.Name, err)
+		}
+		opcode := uint8(encoding[0] & 0xff)
+		if _, ok := opcodes[opcode]; ok {
+			t.Errorf("opcode 0x%02x is in the suite more than once", opcode)
+		}
+		opcodes[opcode] = invalid.Name
+	}
+	return opcodes
+}
+
+func TestInvalidMemoryEncodings(t *testing.T) {
+	opcodes := invalidOpcodes(t)
+	// 8 modes times 4 sizes times 4 classes, minus the 24 valid ones.
+	if len(opcodes) != 104 {
+		t.Errorf("len(InvalidMemoryEncodings()) = %d, want 104", len(opcodes))
+	}
+
+	tests := []struct {
+		testName    string
+		instruction *pb.Instruction
+		opcode      uint8
+		wantInvalid bool
+	}{
+		{testName: "Load double word", instruction: LdDW(R1, R10, -8), wantInvalid: false},
+		{testName: "Sign extending load", instruction: LdSXW(R1, R10, -8), wantInvalid: false},
+		{testName: "Store byte", instruction: StB(R10, R1, -8), wantInvalid: false},
+		{testName: "Packet load", instruction: LdAbsW(0), wantInvalid: false},
+		{testName: "Sign extending double word load", opcode: 0x99, wantInvalid: true},
+		{testName: "Packet load of a double word", opcode: 0x38, wantInvalid: true},
+		{testName: "Atomic byte", opcode: 0xd3, wantInvalid: true},
+		{testName: "Undefined mode", opcode: 0xa1, wantInvalid: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			opcode := tc.opcode
+			if tc.instruction != nil {
+				encoding, err := encodeInstruction(tc.instruction, LittleEndian)
+				if err != nil {
+					t.Fatalf("could not encode instruction: %v", err)
+				}
+				opcode = uint8(encoding[0] & 0xff)
+			}
+			if _, got := opcodes[opcode]; got != tc.wantInvalid {
+				t.Errorf("opcode 0x%02x in the suite = %v, want %v", opcode, got, tc.wantInvalid)
+			}
+		})
+	}
+}
+
+func TestNewInvalidEncoding(t *testing.T) {
+	tests := []struct {
+		testName    string
+		instruction *pb.Instruction
+		wantIndex   int
+		wantLength  int
+	}{
+		{testName: "Invalid instruction", instruction: Mov64(pb.Reg(12), 0), wantIndex: 3, wantLength: 6},
+		{testName: "Invalid exit", instruction: &pb.Instruction{Opcode: Exit().Opcode, Immediate: 1}, wantIndex: 4, wantLength: 5},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			invalid := newInvalidEncoding("test", tc.instruction)
+			if invalid.Index != tc.wantIndex {
+				t.Errorf("Index = %d, want %d", invalid.Index, tc.wantIndex)
+			}
+			if got := len(invalid.Program.Instructions); got != tc.wantLength {
+				t.Errorf("len(Program.Instructions) = %d, want %d", got, tc.wantLength)
+			}
+			if invalid.Program.Instructions[invalid.Index] != tc.instruction {
+				t.Errorf("Program.Instructions[%d] is not the invalid instruction", invalid.Index)
+			}
+		})
+	}
+}
+
+func TestRandomInvalidEncoding(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		invalid := RandomInvalidEncoding()
+		if invalid.Name == "" {
+			t.Fatalf("RandomInvalidEncoding() returned an encoding without a name")
+		}
+		if invalid.Index < 0 || invalid.Index >= len(invalid.Program.Instructions) {
+			t.Fatalf("%s: Index = %d, out of the program", invalid.Name, invalid.Index)
+		}
+		if _, err := EncodeInstructions(invalid.Program); err != nil {
+			t.Fatalf("%s: could not encode the program: %v", invalid.Name, err)
+		}
+
+		// Everything but the invalid instruction must be well formed.
+		fixed := &pb.Program{}
+		for j, insn := range invalid.Program.Instructions {
+			if j == invalid.Index {
+				insn = Mov64(R1, 0)
+			}
+			fixed.Instructions = append(fixed.Instructions, insn)
+		}
+		if err := Validate(fixed); err != nil {
+			t.Fatalf("%s: the rest of the program is malformed: %v", invalid.Name, err)
+		}
+	}
+}
+
+func TestExitReservedFields(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		name, insn := exitReservedFields()
+		if insn.Offset != 0 {
+			t.Fatalf("%s: offset %d set, the verifier does not check it", name, insn.Offset)
+		}
+		if insn.DstReg == R0 && insn.SrcReg == R0 && insn.Immediate == 0 && insn.GetJmpOpcode().Source == pb.SrcOperand_Immediate {
+			t.Fatalf("%s: no reserved field set", name)
+		}
+	}
+}
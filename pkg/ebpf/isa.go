@@ -0,0 +1,47 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// IsIsaV4Instruction returns true if `i` was introduced by the v4 ISA (cpuv4
+// in LLVM), which kernels before 6.6 refuse: signed divisions and modulos,
+// sign extending loads, unconditional byte swaps and long jumps, including
+// the jumps that are promoted to long jumps once encoded.
+func IsIsaV4Instruction(i *pb.Instruction) bool {
+	if IsSignedAluInstruction(i) || IsLongJump(i) || isFarJump(i) {
+		return true
+	}
+	if op := i.GetAluOpcode(); op != nil {
+		return op.OperationCode == pb.AluOperationCode_AluEnd && op.InstructionClass == pb.InsClass_InsClassAlu64
+	}
+	if op := i.GetMemOpcode(); op != nil {
+		return op.Mode == pb.StLdMode_StLdModeMEMSX
+	}
+	return false
+}
+
+// UsesIsaV4 returns true if any instruction of `program` was introduced by
+// the v4 ISA.
+func UsesIsaV4(program *pb.Program) bool {
+	for _, insn := range program.Instructions {
+		if IsIsaV4Instruction(insn) {
+			return true
+		}
+	}
+	return false
+}
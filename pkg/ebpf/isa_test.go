@@ -0,0 +1,49 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestIsIsaV4Instruction(t *testing.T) {
+	tests := []struct {
+		testName    string
+		instruction *pb.Instruction
+		want        bool
+	}{
+		{"Unsigned division", Div64(R1, R2), false},
+		{"Signed division", SDiv64(R1, R2), true},
+		{"Signed modulo", SMod(R1, 3), true},
+		{"Load", LdW(R1, R10, -4), false},
+		{"Sign extending load", LdSXB(R1, R10, -1), true},
+		{"To big endian", ToBe(R1, 32), false},
+		{"Byte swap", Bswap(R1, 64), true},
+		{"Goto", Jmp(1), false},
+		{"Long goto", Gotol(1), true},
+		{"Goto out of short range", &pb.Instruction{Opcode: Jmp(0).Opcode, Offset: 40000}, true},
+		{"Jump32", JmpEQ32(R1, 0, 1), false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := IsIsaV4Instruction(tc.instruction); got != tc.want {
+				t.Errorf("IsIsaV4Instruction() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -63,6 +63,19 @@ func Jmp(offset int16) *pb.Instruction {
 	return newJmpInstruction(pb.JmpOperationCode_JmpJA, pb.InsClass_InsClassJmp, pb.Reg_R0, int32(UnusedField), offset)
 }
 
+// Gotol represents an inconditional jump of `offset` instructions with a 32
+// bit offset, introduced by the v4 ISA as BPF_JMP32 BPF_JA. The offset is
+// stored in the immediate instead of the offset field.
+func Gotol(offset int32) *pb.Instruction {
+	return newJmpInstruction(pb.JmpOperationCode_JmpJA, pb.InsClass_InsClassJmp32, pb.Reg_R0, offset, int16(UnusedField))
+}
+
+// IsLongJump returns true if `i` is a Gotol.
+func IsLongJump(i *pb.Instruction) bool {
+	op := i.GetJmpOpcode()
+	return op != nil && op.OperationCode == pb.JmpOperationCode_JmpJA && op.InstructionClass == pb.InsClass_InsClassJmp32
+}
+
 func JmpEQ[T Src](dstReg pb.Reg, src T, offset int16) *pb.Instruction {
 	return newJmpInstruction(pb.JmpOperationCode_JmpJEQ, pb.InsClass_InsClassJmp, dstReg, src, offset)
 }
@@ -123,6 +136,22 @@ func Call(functionValue int32) *pb.Instruction {
 	return newJmpInstruction(pb.JmpOperationCode_JmpCALL, pb.InsClass_InsClassJmp, pb.Reg_R0, functionValue, int16(UnusedField))
 }
 
+// CallLocal calls the function of the program, a bpf-to-bpf call, that
+// starts `offset` instructions after the call.
+func CallLocal(offset int32) *pb.Instruction {
+	insn := Call(offset)
+	insn.SrcReg = PseudoCall
+	return insn
+}
+
+// CallKfunc calls the kernel function, a kfunc, whose BTF id in vmlinux is
+// `btfID`.
+func CallKfunc(btfID int32) *pb.Instruction {
+	insn := Call(btfID)
+	insn.SrcReg = PseudoKfuncCall
+	return insn
+}
+
 // LdMapElement loads a map element ptr to R0.
 // It does the following operations:
 // - Set R1 to the pointer of the target map.
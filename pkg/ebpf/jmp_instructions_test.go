@@ -52,6 +52,17 @@ func TestJmpOperationCorrectEncoding(t *testing.T) {
 			wantOffset:           42,
 			wantEncoding:         []uint64{0x2a0005},
 		},
+		{
+			testName:             "Encoding Gotol",
+			instruction:          Gotol(70000),
+			wantDstReg:           UnusedField,
+			wantImm:              70000,
+			wantOperationCode:    pb.JmpOperationCode_JmpJA,
+			wantSrc:              pb.SrcOperand_Immediate,
+			wantInstructionClass: pb.InsClass_InsClassJmp32,
+			wantOffset:           UnusedField,
+			wantEncoding:         []uint64{0x1117000000006},
+		},
 		{
 			testName:             "Encoding Exit",
 			instruction:          Exit(),
@@ -184,6 +195,50 @@ func TestJmpOperationCorrectEncoding(t *testing.T) {
 			wantOffset:           testOffset,
 			wantEncoding:         []uint64{0x2a000a09c5},
 		},
+		{
+			testName:             "Encoding JSGT32 at the sign boundary",
+			instruction:          JmpSGT32(testDstReg, int32(0x7fffffff), testOffset),
+			wantDstReg:           testDstReg,
+			wantImm:              0x7fffffff,
+			wantOperationCode:    pb.JmpOperationCode_JmpJSGT,
+			wantSrc:              pb.SrcOperand_Immediate,
+			wantInstructionClass: pb.InsClass_InsClassJmp32,
+			wantOffset:           testOffset,
+			wantEncoding:         []uint64{0x7fffffff000a0966},
+		},
+		{
+			testName:             "Encoding JSGE32 at the sign boundary",
+			instruction:          JmpSGE32(testDstReg, int32(-0x80000000), testOffset),
+			wantDstReg:           testDstReg,
+			wantImm:              -0x80000000,
+			wantOperationCode:    pb.JmpOperationCode_JmpJSGE,
+			wantSrc:              pb.SrcOperand_Immediate,
+			wantInstructionClass: pb.InsClass_InsClassJmp32,
+			wantOffset:           testOffset,
+			wantEncoding:         []uint64{0x80000000000a0976},
+		},
+		{
+			testName:             "Encoding JSLT32 at the sign boundary",
+			instruction:          JmpSLT32(testDstReg, int32(0x7fffffff), testOffset),
+			wantDstReg:           testDstReg,
+			wantImm:              0x7fffffff,
+			wantOperationCode:    pb.JmpOperationCode_JmpJSLT,
+			wantSrc:              pb.SrcOperand_Immediate,
+			wantInstructionClass: pb.InsClass_InsClassJmp32,
+			wantOffset:           testOffset,
+			wantEncoding:         []uint64{0x7fffffff000a09c6},
+		},
+		{
+			testName:             "Encoding JSLE32 at the sign boundary",
+			instruction:          JmpSLE32(testDstReg, int32(-0x80000000), testOffset),
+			wantDstReg:           testDstReg,
+			wantImm:              -0x80000000,
+			wantOperationCode:    pb.JmpOperationCode_JmpJSLE,
+			wantSrc:              pb.SrcOperand_Immediate,
+			wantInstructionClass: pb.InsClass_InsClassJmp32,
+			wantOffset:           testOffset,
+			wantEncoding:         []uint64{0x80000000000a09d6},
+		},
 		{
 			testName:             "Encoding JEQ with source register",
 			instruction:          JmpEQ(testDstReg, testSrcReg, testOffset),
@@ -348,7 +403,7 @@ func TestJmpOperationCorrectEncoding(t *testing.T) {
 				t.Fatalf("operationCode = %d, want %d", opcode.OperationCode, tc.wantOperationCode)
 			}
 
-			encodingArray, err := encodeInstruction(instruction)
+			encodingArray, err := encodeInstruction(instruction, LittleEndian)
 			if err != nil {
 				t.Fatalf("unexpected error when ecoding: %v", err)
 			}
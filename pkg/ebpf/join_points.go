@@ -0,0 +1,129 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+const (
+	// Maximum nesting of the regions of RandomJoinPoints, the branches of
+	// the regions nested deeper are basic blocks.
+	maxJoinPointDepth = 3
+
+	// Maximum number of conditional jumps that share a tail.
+	maxSharedTailJumps = 4
+)
+
+// joinPointGenerator appends the regions of RandomJoinPoints to a builder.
+type joinPointGenerator struct {
+	b     *ProgramBuilder
+	block func() []*pb.Instruction
+
+	// The labels are named after the instruction the generator started
+	// at and a counter, to not collide with the other labels of b.
+	start  int
+	labels int
+}
+
+// RandomJoinPoints appends `regions` random regions to `b` whose branches
+// rejoin at a shared continuation instead of forming a tree, so the verifier
+// explores the same instructions with different states and has to prune
+// them, see is_state_visited. A region is one of:
+//
+//   - a basic block,
+//   - a triangle, a conditional jump over a branch,
+//   - a diamond, a conditional jump to one of two branches that both
+//     continue after the second one,
+//   - a shared tail, several conditional jumps from a sequence of blocks to
+//     its end,
+//   - dead code, a branch behind a condition the verifier can decide, that
+//     it must never explore; it dereferences a scalar.
+//
+// Branches are basic blocks or nested regions. `block` returns the
+// instructions of a basic block, without jumps, e.g. random ALU
+// instructions. The conditions test R0-R9, which must be initialized, and
+// the last region continues after the instructions appended to `b` next.
+func RandomJoinPoints(b *ProgramBuilder, regions int, block func() []*pb.Instruction) *ProgramBuilder {
+	g := &joinPointGenerator{b: b, block: block, start: len(b.instructions)}
+	for i := 0; i < regions; i++ {
+		g.region(0)
+	}
+	return b
+}
+
+// label returns a new label name.
+func (g *joinPointGenerator) label(kind string) string {
+	g.labels++
+	return fmt.Sprintf("%s_%d_%d", kind, g.start, g.labels)
+}
+
+// jumpTo appends a random conditional jump to `target`.
+func (g *joinPointGenerator) jumpTo(target string) {
+	g.b.fixups[len(g.b.instructions)] = target
+	g.b.Insn(RandomJmpInstruction(1))
+}
+
+// branch appends a basic block or, above the maximum depth, a nested
+// region.
+func (g *joinPointGenerator) branch(depth int) {
+	if depth < maxJoinPointDepth && rand.SharedRNG.OneOf(3) {
+		g.region(depth + 1)
+		return
+	}
+	g.b.Insn(g.block()...)
+}
+
+func (g *joinPointGenerator) region(depth int) {
+	switch rand.SharedRNG.RandRange(0, 4) {
+	case 0:
+		g.b.Insn(g.block()...)
+	case 1:
+		join := g.label("join")
+		g.jumpTo(join)
+		g.branch(depth)
+		g.b.Label(join)
+	case 2:
+		otherwise, join := g.label("else"), g.label("join")
+		g.jumpTo(otherwise)
+		g.branch(depth)
+		g.b.Ja(join)
+		g.b.Label(otherwise)
+		g.branch(depth)
+		g.b.Label(join)
+	case 3:
+		tail := g.label("tail")
+		for i := rand.SharedRNG.RandRange(1, maxSharedTailJumps); i > 0; i-- {
+			g.branch(depth)
+			g.jumpTo(tail)
+		}
+		g.branch(depth)
+		g.b.Label(tail)
+	default:
+		// The register is known to hold k when the condition is
+		// evaluated, the load through it is never reached.
+		live := g.label("live")
+		reg := RandomRegister()
+		k := int32(rand.SharedRNG.RandInt())
+		g.b.Mov64(reg, k)
+		g.b.JmpEQ(reg, k, live)
+		g.b.Insn(g.block()...)
+		g.b.LdDW(reg, reg, 0)
+		g.b.Label(live)
+	}
+}
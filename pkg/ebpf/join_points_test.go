@@ -0,0 +1,71 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestRandomJoinPoints(t *testing.T) {
+	block := func() []*pb.Instruction {
+		return []*pb.Instruction{RandomAluInstruction(), RandomAluInstruction()}
+	}
+	joins := 0
+	for i := 0; i < 200; i++ {
+		b := NewProgramBuilder()
+		for reg := R0; reg <= R9; reg++ {
+			b.Mov64(reg, 0)
+		}
+		prog, err := RandomJoinPoints(b, 4, block).Mov64(R0, 0).Exit().Build()
+		if err != nil {
+			t.Fatalf("Build() = %v", err)
+		}
+		if _, err := EncodeInstructions(prog); err != nil {
+			t.Fatalf("EncodeInstructions() = %v", err)
+		}
+
+		// Predecessors of every instruction, falling through included.
+		predecessors := make([]int, len(prog.Instructions))
+		for index, insn := range prog.Instructions {
+			if index+1 < len(prog.Instructions) && !isUnconditionalJump(insn) {
+				predecessors[index+1]++
+			}
+			if !IsRelativeJump(insn) {
+				continue
+			}
+			target, ok := JumpTarget(prog, index)
+			if !ok || target <= index {
+				t.Fatalf("jump %d of program %d lands on %d, want a later instruction", index, i, target)
+			}
+			predecessors[target]++
+		}
+		for _, p := range predecessors {
+			if p > 1 {
+				joins++
+			}
+		}
+	}
+	if joins == 0 {
+		t.Errorf("RandomJoinPoints() never generated a join point")
+	}
+}
+
+// isUnconditionalJump returns true if `insn` never falls through.
+func isUnconditionalJump(insn *pb.Instruction) bool {
+	jmp, ok := insn.Opcode.(*pb.Instruction_JmpOpcode)
+	return ok && jmp.JmpOpcode.OperationCode == pb.JmpOperationCode_JmpJA
+}
@@ -0,0 +1,97 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// LoopOperation is a bounded loop: a backward jump taken while a counter,
+// going down from TripCount to 0, is positive. Kernels since 5.3 accept them
+// as long as the verifier, walking every iteration, proves the counter
+// reaches 0 within its complexity limit. The body always runs at least once.
+type LoopOperation struct {
+	// Counter is the register the loop counter is held in.
+	Counter pb.Reg
+
+	// Spilled keeps the counter in the stack slot at StackOffset from R10
+	// between iterations, Counter only holds it while it is decremented.
+	// The verifier has to carry its bounds through the spill and the fill.
+	Spilled     bool
+	StackOffset int16
+
+	TripCount int32
+
+	// Body is run at every iteration, it must not touch the counter.
+	Body []*pb.Instruction
+
+	// Inner, if set, is a loop nested in this one, after Body. It must use
+	// another counter and stack slot.
+	Inner *LoopOperation
+
+	// Unbounded makes the loop never update its counter, the verifier must
+	// reject it.
+	Unbounded bool
+}
+
+// Emit appends the loop to `b`, the names of its labels start with `name`.
+func (l *LoopOperation) Emit(b *ProgramBuilder, name string) *ProgramBuilder {
+	b.Mov64(l.Counter, l.TripCount)
+	if l.Spilled {
+		b.StDW(R10, l.Counter, l.StackOffset)
+	}
+	b.Label(name + "_head").Insn(l.Body...)
+	if l.Inner != nil {
+		l.Inner.Emit(b, name+"_inner")
+	}
+	if l.Spilled {
+		b.LdDW(l.Counter, R10, l.StackOffset)
+	}
+	if !l.Unbounded {
+		b.Sub64(l.Counter, 1)
+	}
+	if l.Spilled {
+		b.StDW(R10, l.Counter, l.StackOffset)
+	}
+	return b.JmpSGT(l.Counter, 0, name+"_head")
+}
+
+// Iterations returns how many times the body of the innermost loop runs, -1
+// if one of the loops is unbounded.
+func (l *LoopOperation) Iterations() int64 {
+	if l.Unbounded {
+		return -1
+	}
+	trips := int64(l.TripCount)
+	if trips < 1 {
+		trips = 1
+	}
+	if l.Inner == nil {
+		return trips
+	}
+	inner := l.Inner.Iterations()
+	if inner < 0 {
+		return -1
+	}
+	return trips * inner
+}
+
+// Depth returns how many loops are nested, including this one.
+func (l *LoopOperation) Depth() int {
+	if l.Inner == nil {
+		return 1
+	}
+	return 1 + l.Inner.Depth()
+}
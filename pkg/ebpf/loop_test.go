@@ -0,0 +1,79 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestLoopOperation(t *testing.T) {
+	tests := []struct {
+		testName       string
+		loop           *LoopOperation
+		want           *ProgramBuilder
+		wantIterations int64
+	}{
+		{
+			testName: "Counter in a register",
+			loop:     &LoopOperation{Counter: R7, TripCount: 3, Body: []*pb.Instruction{Add64(R6, 1)}},
+			want: NewProgramBuilder().
+				Mov64(R7, 3).
+				Label("head").Add64(R6, 1).Sub64(R7, 1).JmpSGT(R7, 0, "head"),
+			wantIterations: 3,
+		},
+		{
+			testName: "Nested loop with a spilled counter",
+			loop: &LoopOperation{Counter: R7, TripCount: 4, Inner: &LoopOperation{
+				Counter: R8, Spilled: true, StackOffset: -8, TripCount: 5, Body: []*pb.Instruction{Add64(R6, 1)},
+			}},
+			want: NewProgramBuilder().
+				Mov64(R7, 4).
+				Label("head").
+				Mov64(R8, 5).StDW(R10, R8, -8).
+				Label("inner").Add64(R6, 1).LdDW(R8, R10, -8).Sub64(R8, 1).StDW(R10, R8, -8).JmpSGT(R8, 0, "inner").
+				Sub64(R7, 1).JmpSGT(R7, 0, "head"),
+			wantIterations: 20,
+		},
+		{
+			testName: "Unbounded loop",
+			loop:     &LoopOperation{Counter: R7, TripCount: 2, Unbounded: true},
+			want: NewProgramBuilder().
+				Mov64(R7, 2).
+				Label("head").JmpSGT(R7, 0, "head"),
+			wantIterations: -1,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := tc.loop.Emit(NewProgramBuilder(), "loop").Exit().Build()
+			if err != nil {
+				t.Fatalf("Emit() failed: %v", err)
+			}
+			want, err := tc.want.Exit().Build()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !protobuf.Equal(got, want) {
+				t.Errorf("Emit() = %v, want %v", got.Instructions, want.Instructions)
+			}
+			if got := tc.loop.Iterations(); got != tc.wantIterations {
+				t.Errorf("Iterations() = %d, want %d", got, tc.wantIterations)
+			}
+		})
+	}
+}
@@ -0,0 +1,368 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// MemoryRegion is where the pointer of a memory access comes from.
+type MemoryRegion int
+
+const (
+	RegionStack MemoryRegion = iota
+	RegionCtx
+	RegionPacket
+	RegionMapValue
+)
+
+func (r MemoryRegion) String() string {
+	switch r {
+	case RegionStack:
+		return "stack"
+	case RegionCtx:
+		return "ctx"
+	case RegionPacket:
+		return "packet"
+	case RegionMapValue:
+		return "map value"
+	}
+	return fmt.Sprintf("MemoryRegion(%d)", int(r))
+}
+
+// Offsets of the fields of struct __sk_buff the memory accesses use.
+const (
+	skbMarkOffset         = 8
+	skbQueueMappingOffset = 12
+	skbPriorityOffset     = 32
+	skbTcIndexOffset      = 44
+	skbCbOffset           = 48
+	skbTcClassidOffset    = 72
+	skbDataOffset         = 76
+	skbDataEndOffset      = 80
+
+	// Far past the end of the structure, whatever the kernel version.
+	skbOutOfBoundsOffset = 1024
+)
+
+const (
+	// Largest constant offset of the packet accesses, and mask of the
+	// variable one.
+	memoryAccessMaxPktOffset = 64
+	memoryAccessPktMask      = 0x3f
+
+	// Register the stores and atomic operations take their value from.
+	memoryAccessScratchReg = R5
+)
+
+// skbField is a 32 bit field of struct __sk_buff.
+type skbField struct {
+	offset int16
+
+	// writable is true if BPF_PROG_TYPE_SCHED_CLS programs can write the
+	// field.
+	writable bool
+}
+
+// skbFields are the 32 bit fields of struct __sk_buff that
+// BPF_PROG_TYPE_SCHED_CLS programs can read, in order. The packet pointers
+// are left out, only full loads of them are allowed.
+var skbFields = []skbField{
+	{offset: 0},
+	{offset: 4},
+	{offset: skbMarkOffset, writable: true},
+	{offset: skbQueueMappingOffset, writable: true},
+	{offset: 16},
+	{offset: 20},
+	{offset: 24},
+	{offset: 28},
+	{offset: skbPriorityOffset, writable: true},
+	{offset: 36},
+	{offset: 40},
+	{offset: skbTcIndexOffset, writable: true},
+	{offset: skbCbOffset, writable: true},
+	{offset: skbCbOffset + 4, writable: true},
+	{offset: skbCbOffset + 8, writable: true},
+	{offset: skbCbOffset + 12, writable: true},
+	{offset: skbCbOffset + 16, writable: true},
+	{offset: 68},
+	{offset: skbTcClassidOffset, writable: true},
+	{offset: 84},
+}
+
+// MemoryEnvironment is the state of the program where a memory access is
+// generated.
+type MemoryEnvironment struct {
+	// HasCtx indicates if CtxReg holds the struct __sk_buff of a
+	// BPF_PROG_TYPE_SCHED_CLS program. The context and the packet are only
+	// accessed then.
+	HasCtx bool
+	CtxReg pb.Reg
+
+	// ValueReg holds a pointer to a map value of ValueSize bytes that was
+	// already checked against null. Map values are only accessed if
+	// ValueSize is at least 8.
+	ValueReg  pb.Reg
+	ValueSize int32
+}
+
+// MemoryAccess is a load or a store through a pointer to a region, along
+// with the instructions that derive the pointer and check its bounds.
+type MemoryAccess struct {
+	Region       MemoryRegion
+	Instructions []*pb.Instruction
+
+	// Violation describes what makes the verifier reject the access, e.g.
+	// a bounds check that is off by one, empty if the access is valid.
+	Violation string
+}
+
+// memAccessKind is the instruction that accesses the memory.
+type memAccessKind int
+
+const (
+	accessLoad memAccessKind = iota
+	accessStore
+	accessStoreImm
+	accessAtomic
+)
+
+// sizeOfBytes returns the StLdSize of an access of `size` bytes.
+func sizeOfBytes(size int32) pb.StLdSize {
+	switch size {
+	case 1:
+		return pb.StLdSize_StLdSizeB
+	case 2:
+		return pb.StLdSize_StLdSizeH
+	case 4:
+		return pb.StLdSize_StLdSizeW
+	default:
+		return pb.StLdSize_StLdSizeDW
+	}
+}
+
+// memAccess returns the instructions that access `size` bytes at `base` +
+// `offset`. Loads go to R0, stores and atomic operations write a random
+// value, from memoryAccessScratchReg unless it is an immediate store.
+// Atomic operations only exist for 4 and 8 bytes, smaller ones are widened.
+func memAccess(kind memAccessKind, size int32, base pb.Reg, offset int16) []*pb.Instruction {
+	value := int32(rand.SharedRNG.RandInt())
+	switch kind {
+	case accessLoad:
+		return []*pb.Instruction{gadgetLoad(size, R0, base, offset)}
+	case accessStoreImm:
+		return []*pb.Instruction{newStoreOperation(sizeOfBytes(size), base, value, offset)}
+	case accessStore:
+		return []*pb.Instruction{
+			Mov64(memoryAccessScratchReg, value),
+			newStoreOperation(sizeOfBytes(size), base, memoryAccessScratchReg, offset),
+		}
+	default:
+		atomicSize := pb.StLdSize_StLdSizeW
+		if size == 8 {
+			atomicSize = pb.StLdSize_StLdSizeDW
+		}
+		operations := []pb.AluOperationCode{
+			pb.AluOperationCode_AluAdd,
+			pb.AluOperationCode_AluAnd,
+			pb.AluOperationCode_AluOr,
+			pb.AluOperationCode_AluXor,
+		}
+		operation := operations[rand.SharedRNG.RandRange(0, uint64(len(operations)-1))]
+		return []*pb.Instruction{
+			Mov64(memoryAccessScratchReg, value),
+			newAtomicInstruction(base, memoryAccessScratchReg, atomicSize, offset, int32(operation)),
+		}
+	}
+}
+
+// randomAccessKind returns a random load, store or atomic operation.
+func randomAccessKind() memAccessKind {
+	return memAccessKind(rand.SharedRNG.RandRange(0, uint64(accessAtomic)))
+}
+
+// RandomMemoryAccess returns a load or store through a pointer to a random
+// region `env` provides: the stack, the context, the packet or a map value.
+// One out of eight accesses breaks a rule of the region on purpose, e.g.
+// misses the end of the packet by a byte, see MemoryAccess.Violation. The
+// instructions clobber R0-R5 and fall through to the next instruction.
+func RandomMemoryAccess(env *MemoryEnvironment) (*MemoryAccess, error) {
+	regions := []MemoryRegion{RegionStack}
+	if env.HasCtx {
+		regions = append(regions, RegionCtx, RegionPacket)
+	}
+	if env.ValueSize >= 8 {
+		regions = append(regions, RegionMapValue)
+	}
+	access := &MemoryAccess{Region: regions[rand.SharedRNG.RandRange(0, uint64(len(regions)-1))]}
+	violate := rand.SharedRNG.OneOf(8)
+	var insn []*pb.Instruction
+	switch access.Region {
+	case RegionCtx:
+		insn, access.Violation = ctxAccess(env, violate)
+	case RegionPacket:
+		insn, access.Violation = packetAccess(env, violate)
+	case RegionMapValue:
+		insn, access.Violation = mapValueAccess(env, violate)
+	default:
+		size := gadgetAccessSize()
+		insn = memAccess(randomAccessKind(), size, R10, RandomOffset(sizeOfBytes(size)))
+	}
+	var err error
+	access.Instructions, err = InstructionSequence(insn...)
+	if err != nil {
+		return nil, err
+	}
+	return access, nil
+}
+
+// randomSkbField returns a random field of struct __sk_buff, only among
+// the writable ones if `writable` is set.
+func randomSkbField(writable bool) skbField {
+	var candidates []skbField
+	for _, f := range skbFields {
+		if f.writable || !writable {
+			candidates = append(candidates, f)
+		}
+	}
+	return candidates[rand.SharedRNG.RandRange(0, uint64(len(candidates)-1))]
+}
+
+// ctxAccess loads a field of the context, sometimes only part of it, or
+// stores to a writable field. The context only takes full stores from a
+// register.
+func ctxAccess(env *MemoryEnvironment, violate bool) ([]*pb.Instruction, string) {
+	if !violate {
+		if rand.SharedRNG.OneOf(2) {
+			return memAccess(accessStore, 4, env.CtxReg, randomSkbField(true).offset), ""
+		}
+		size := []int32{1, 2, 4}[rand.SharedRNG.RandRange(0, 2)]
+		offset := randomSkbField(false).offset + int16(int32(rand.SharedRNG.RandRange(0, uint64(4/size-1)))*size)
+		return memAccess(accessLoad, size, env.CtxReg, offset), ""
+	}
+
+	switch rand.SharedRNG.RandRange(0, 5) {
+	case 0:
+		return memAccess(accessStoreImm, 4, env.CtxReg, randomSkbField(true).offset), "immediate store to the context"
+	case 1:
+		return memAccess(accessAtomic, 4, env.CtxReg, randomSkbField(true).offset), "atomic operation on the context"
+	case 2:
+		var readOnly []skbField
+		for _, f := range skbFields {
+			if !f.writable {
+				readOnly = append(readOnly, f)
+			}
+		}
+		f := readOnly[rand.SharedRNG.RandRange(0, uint64(len(readOnly)-1))]
+		return memAccess(accessStore, 4, env.CtxReg, f.offset), "store to a read only field of the context"
+	case 3:
+		// The fields of the control block can be accessed with any
+		// size, the others are only stored to whole.
+		offsets := []int16{skbMarkOffset, skbPriorityOffset, skbTcIndexOffset}
+		offset := offsets[rand.SharedRNG.RandRange(0, uint64(len(offsets)-1))]
+		return memAccess(accessStore, 2, env.CtxReg, offset), "narrow store to the context"
+	case 4:
+		return memAccess(accessLoad, 4, env.CtxReg, randomSkbField(false).offset+2), "misaligned load from the context"
+	default:
+		return memAccess(accessLoad, 4, env.CtxReg, skbOutOfBoundsOffset), "load past the end of the context"
+	}
+}
+
+// packetAccess loads the packet pointers from the context, sometimes moves
+// the start by a bounded amount read from the map value or the packet
+// length, checks the access against the end of the packet and accesses the
+// packet. Atomic operations are not allowed on the packet.
+func packetAccess(env *MemoryEnvironment, violate bool) ([]*pb.Instruction, string) {
+	size := gadgetAccessSize()
+	offset := int16(rand.SharedRNG.RandRange(0, memoryAccessMaxPktOffset))
+	insn := []*pb.Instruction{
+		LdW(R2, env.CtxReg, skbDataOffset),
+		LdW(R3, env.CtxReg, skbDataEndOffset),
+	}
+	if rand.SharedRNG.OneOf(2) {
+		insn = append(insn,
+			LdW(R1, env.CtxReg, 0),
+			And64(R1, memoryAccessPktMask),
+			Add64(R2, R1),
+		)
+	}
+
+	kind := randomAccessKind()
+	for kind == accessAtomic {
+		kind = randomAccessKind()
+	}
+	checked := int32(offset) + size
+	violation := ""
+	if violate {
+		if rand.SharedRNG.OneOf(2) {
+			checked--
+			violation = "packet access past the bounds check"
+		} else {
+			kind = accessAtomic
+			violation = "atomic operation on the packet"
+		}
+	}
+
+	access := memAccess(kind, size, R2, offset)
+	insn = append(insn,
+		Mov64(R4, R2),
+		Add64(R4, checked),
+		nil, // if r4 > r3 goto out
+	)
+	check := len(insn) - 1
+	insn = append(insn, access...)
+	insn[check] = JmpGT(R4, R3, skipToEnd(insn, check))
+	return insn, violation
+}
+
+// mapValueAccess accesses the map value at a constant offset or at an
+// index read from the value and masked to stay in bounds. The accesses are
+// aligned, atomic operations require it.
+func mapValueAccess(env *MemoryEnvironment, violate bool) ([]*pb.Instruction, string) {
+	kind := randomAccessKind()
+	size := gadgetAccessSize()
+	if kind == accessAtomic && size < 4 {
+		size = 4
+	}
+	last := (env.ValueSize - size) &^ (size - 1)
+
+	if rand.SharedRNG.OneOf(2) {
+		offset := int32(rand.SharedRNG.RandRange(0, uint64(last/size))) * size
+		violation := ""
+		if violate {
+			offset = last + size
+			violation = "map value access past the end of the value"
+		}
+		return memAccess(kind, size, env.ValueReg, int16(offset)), violation
+	}
+
+	// Masking with a multiple of the size keeps the index aligned.
+	mask := last
+	violation := ""
+	if violate {
+		mask = last + size
+		violation = "map value access with an index masked past the end of the value"
+	}
+	insn := []*pb.Instruction{
+		LdB(R1, env.ValueReg, 0),
+		And64(R1, mask),
+		Mov64(R2, env.ValueReg),
+		Add64(R2, R1),
+	}
+	return append(insn, memAccess(kind, size, R2, 0)...), violation
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestRandomMemoryAccess(t *testing.T) {
+	tests := []struct {
+		testName    string
+		env         *MemoryEnvironment
+		wantRegions []MemoryRegion
+	}{
+		{
+			testName:    "Stack only",
+			env:         &MemoryEnvironment{},
+			wantRegions: []MemoryRegion{RegionStack},
+		},
+		{
+			testName:    "Every region",
+			env:         &MemoryEnvironment{HasCtx: true, CtxReg: R6, ValueReg: R7, ValueSize: 64},
+			wantRegions: []MemoryRegion{RegionStack, RegionCtx, RegionPacket, RegionMapValue},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			seen := make(map[MemoryRegion]bool)
+			violations := 0
+			for i := 0; i < 500; i++ {
+				access, err := RandomMemoryAccess(tc.env)
+				if err != nil {
+					t.Fatalf("RandomMemoryAccess() = %v, want nil error", err)
+				}
+				seen[access.Region] = true
+				if access.Violation != "" {
+					violations++
+					if access.Region == RegionStack {
+						t.Fatalf("stack access with a violation: %s", access.Violation)
+					}
+				}
+				insn := access.Instructions
+				if !checkJumpTargets(insn) {
+					t.Fatalf("jump outside of the %v access in %v", access.Region, insn)
+				}
+				prog := &pb.Program{Instructions: append(insn, Mov64(R0, 0), Exit())}
+				if err := Validate(prog); err != nil {
+					t.Fatalf("malformed %v access: %v", access.Region, err)
+				}
+				for reg := R6; reg <= R10; reg++ {
+					if writesRegister(insn, reg) {
+						t.Fatalf("%v access writes %v in %v", access.Region, reg, insn)
+					}
+				}
+			}
+			for _, region := range tc.wantRegions {
+				if !seen[region] {
+					t.Errorf("no access to the %v in 500 tries", region)
+				}
+			}
+			if len(tc.wantRegions) > 1 && violations == 0 {
+				t.Errorf("no violation in 500 tries")
+			}
+		})
+	}
+}
+
+func TestMapValueAccessBounds(t *testing.T) {
+	env := &MemoryEnvironment{ValueReg: R7, ValueSize: 64}
+	for i := 0; i < 500; i++ {
+		insn, violation := mapValueAccess(env, false)
+		if violation != "" {
+			t.Fatalf("mapValueAccess() without violating = %q", violation)
+		}
+		access := insn[len(insn)-1]
+		size := int32(8)
+		switch access.GetMemOpcode().GetSize() {
+		case pb.StLdSize_StLdSizeB:
+			size = 1
+		case pb.StLdSize_StLdSizeH:
+			size = 2
+		case pb.StLdSize_StLdSizeW:
+			size = 4
+		}
+		// The variable index is masked with at most the last aligned
+		// offset.
+		offset := access.Offset
+		if insn[0].GetMemOpcode() != nil && len(insn) > 2 && insn[1].GetAluOpcode().GetOperationCode() == pb.AluOperationCode_AluAnd {
+			offset += insn[1].Immediate
+		}
+		if offset%size != 0 || offset+size > env.ValueSize {
+			t.Fatalf("access of %d bytes at %d of a %d byte value in %v", size, offset, env.ValueSize, insn)
+		}
+	}
+}
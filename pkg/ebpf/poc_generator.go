@@ -15,33 +15,27 @@
 package ebpf
 
 import (
+	"buzzer/pkg/logging/logging"
 	pb "buzzer/proto/ebpf_go_proto"
 	"errors"
-	"fmt"
-	jsonpb "github.com/golang/protobuf/jsonpb"
 	"os"
 )
 
 // GeneratePoc generates a c program that can be used to reproduce fuzzer
-// test cases.
-func GeneratePoc(program *pb.Program) error {
-	m := &jsonpb.Marshaler{
-		OrigName:     true,
-		EnumsAsInts:  false,
-		EmitDefaults: true,
-		Indent:       "   ",
-	}
-	textpbData, err := m.MarshalToString(program)
+// test cases. Returns the path of the generated file, which ReadProgram can
+// read back.
+func GeneratePoc(program *pb.Program) (string, error) {
+	textpbData, err := ToJSON(program)
 	if err != nil {
-		return err
+		return "", err
 	}
 	f, err := os.CreateTemp("", "ebpf-poc-*.json")
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	fmt.Printf("Writing eBPF PoC %q.\n", f.Name())
+	logging.Infof("Writing eBPF PoC %q.\n", f.Name())
 	_, err = f.Write([]byte(textpbData))
-	return errors.Join(err, f.Close())
+	return f.Name(), errors.Join(err, f.Close())
 
 }
@@ -0,0 +1,456 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// ProgramBuilder writes programs by hand, e.g. known regressions in tests,
+// one instruction per call:
+//
+//	prog, err := NewProgramBuilder().
+//		Mov64(R0, 0).
+//		JmpGT(R1, 10, "out").
+//		Mov64(R0, 1).
+//		Label("out").
+//		Exit().
+//		Build()
+//
+// Source operands are registers or immediates, like for the instruction
+// constructors, see Src. Jump targets are either a relative offset, in
+// instructions as encoded, or the name of a label placed anywhere in the
+// program. Mistakes, e.g. an unknown label, are reported by Build.
+type ProgramBuilder struct {
+	instructions []*pb.Instruction
+	labels       map[string]int
+
+	// Instructions whose target is a label, by index.
+	fixups map[int]string
+
+	err error
+}
+
+// NewProgramBuilder returns a builder of an empty program.
+func NewProgramBuilder() *ProgramBuilder {
+	return &ProgramBuilder{
+		labels: make(map[string]int),
+		fixups: make(map[int]string),
+	}
+}
+
+// fail records the first mistake of the program.
+func (b *ProgramBuilder) fail(err error) *ProgramBuilder {
+	if b.err == nil {
+		b.err = fmt.Errorf("instruction %d: %w", len(b.instructions), err)
+	}
+	return b
+}
+
+// Insn appends `instructions`, as built by the instruction constructors, to
+// the program.
+func (b *ProgramBuilder) Insn(instructions ...*pb.Instruction) *ProgramBuilder {
+	for _, insn := range instructions {
+		if insn == nil {
+			return b.fail(fmt.Errorf("nil instruction"))
+		}
+		b.instructions = append(b.instructions, insn)
+	}
+	return b
+}
+
+// Label names the next instruction so jumps can target it.
+func (b *ProgramBuilder) Label(name string) *ProgramBuilder {
+	if _, ok := b.labels[name]; ok {
+		return b.fail(fmt.Errorf("label %q is defined twice", name))
+	}
+	b.labels[name] = len(b.instructions)
+	return b
+}
+
+func (b *ProgramBuilder) alu(oc pb.AluOperationCode, class pb.InsClass, dst pb.Reg, src any) *ProgramBuilder {
+	switch s := src.(type) {
+	case pb.Reg:
+		return b.Insn(newAluInstruction(oc, class, dst, s))
+	case int:
+		return b.Insn(newAluInstruction(oc, class, dst, s))
+	case int32:
+		return b.Insn(newAluInstruction(oc, class, dst, s))
+	case int64:
+		return b.Insn(newAluInstruction(oc, class, dst, s))
+	}
+	return b.fail(fmt.Errorf("invalid source operand %v (%T)", src, src))
+}
+
+// jump appends the jump `oc` to `target`, an offset or a label.
+func (b *ProgramBuilder) jump(oc pb.JmpOperationCode, class pb.InsClass, dst pb.Reg, src any, target any) *ProgramBuilder {
+	var offset int16
+	switch t := target.(type) {
+	case int:
+		offset = int16(t)
+		if int(offset) != t {
+			return b.fail(fmt.Errorf("jump offset %d does not fit in 16 bits, use a label", t))
+		}
+	case int16:
+		offset = t
+	case string:
+		b.fixups[len(b.instructions)] = t
+	default:
+		return b.fail(fmt.Errorf("invalid jump target %v (%T)", target, target))
+	}
+
+	switch s := src.(type) {
+	case pb.Reg:
+		return b.Insn(newJmpInstruction(oc, class, dst, s, offset))
+	case int:
+		return b.Insn(newJmpInstruction(oc, class, dst, s, offset))
+	case int32:
+		return b.Insn(newJmpInstruction(oc, class, dst, s, offset))
+	case int64:
+		return b.Insn(newJmpInstruction(oc, class, dst, s, offset))
+	}
+	delete(b.fixups, len(b.instructions))
+	return b.fail(fmt.Errorf("invalid source operand %v (%T)", src, src))
+}
+
+func (b *ProgramBuilder) store(size pb.StLdSize, dst pb.Reg, src any, offset int16) *ProgramBuilder {
+	switch s := src.(type) {
+	case pb.Reg:
+		return b.Insn(newStoreOperation(size, dst, s, offset))
+	case int:
+		return b.Insn(newStoreOperation(size, dst, s, offset))
+	case int32:
+		return b.Insn(newStoreOperation(size, dst, s, offset))
+	case int64:
+		return b.Insn(newStoreOperation(size, dst, s, offset))
+	}
+	return b.fail(fmt.Errorf("invalid source operand %v (%T)", src, src))
+}
+
+func (b *ProgramBuilder) Add64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluAdd, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Add(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluAdd, pb.InsClass_InsClassAlu, dst, src)
+}
+
+func (b *ProgramBuilder) Sub64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluSub, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Sub(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluSub, pb.InsClass_InsClassAlu, dst, src)
+}
+
+func (b *ProgramBuilder) Mul64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluMul, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Mul(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluMul, pb.InsClass_InsClassAlu, dst, src)
+}
+
+func (b *ProgramBuilder) Div64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluDiv, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Div(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluDiv, pb.InsClass_InsClassAlu, dst, src)
+}
+
+func (b *ProgramBuilder) Or64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluOr, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Or(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluOr, pb.InsClass_InsClassAlu, dst, src)
+}
+
+func (b *ProgramBuilder) And64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluAnd, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) And(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluAnd, pb.InsClass_InsClassAlu, dst, src)
+}
+
+func (b *ProgramBuilder) Lsh64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluLsh, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Lsh(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluLsh, pb.InsClass_InsClassAlu, dst, src)
+}
+
+func (b *ProgramBuilder) Rsh64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluRsh, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Rsh(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluRsh, pb.InsClass_InsClassAlu, dst, src)
+}
+
+func (b *ProgramBuilder) Neg64(dst pb.Reg) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluNeg, pb.InsClass_InsClassAlu64, dst, 0)
+}
+
+func (b *ProgramBuilder) Neg(dst pb.Reg) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluNeg, pb.InsClass_InsClassAlu, dst, 0)
+}
+
+func (b *ProgramBuilder) Mod64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluMod, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Mod(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluMod, pb.InsClass_InsClassAlu, dst, src)
+}
+
+func (b *ProgramBuilder) Xor64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluXor, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Xor(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluXor, pb.InsClass_InsClassAlu, dst, src)
+}
+
+// Mov64 moves `src` to `dst`, int64 immediates are loaded with the wide
+// BPF_LD | BPF_IMM | BPF_DW instruction.
+func (b *ProgramBuilder) Mov64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluMov, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Mov(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluMov, pb.InsClass_InsClassAlu, dst, src)
+}
+
+func (b *ProgramBuilder) Arsh64(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluArsh, pb.InsClass_InsClassAlu64, dst, src)
+}
+
+func (b *ProgramBuilder) Arsh(dst pb.Reg, src any) *ProgramBuilder {
+	return b.alu(pb.AluOperationCode_AluArsh, pb.InsClass_InsClassAlu, dst, src)
+}
+
+// Ja jumps to `target` unconditionally.
+func (b *ProgramBuilder) Ja(target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJA, pb.InsClass_InsClassJmp, R0, int32(UnusedField), target)
+}
+
+func (b *ProgramBuilder) JmpEQ(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJEQ, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpEQ32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJEQ, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpGT(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJGT, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpGT32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJGT, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpGE(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJGE, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpGE32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJGE, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpSET(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJSET, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpSET32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJSET, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpNE(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJNE, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpNE32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJNE, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpSGT(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJSGT, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpSGT32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJSGT, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpSGE(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJSGE, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpSGE32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJSGE, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpLT(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJLT, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpLT32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJLT, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpLE(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJLE, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpLE32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJLE, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpSLT(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJSLT, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpSLT32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJSLT, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpSLE(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJSLE, pb.InsClass_InsClassJmp, dst, src, target)
+}
+
+func (b *ProgramBuilder) JmpSLE32(dst pb.Reg, src any, target any) *ProgramBuilder {
+	return b.jump(pb.JmpOperationCode_JmpJSLE, pb.InsClass_InsClassJmp32, dst, src, target)
+}
+
+// Call calls the helper `id`, e.g. MapLookup.
+func (b *ProgramBuilder) Call(id int32) *ProgramBuilder {
+	return b.Insn(Call(id))
+}
+
+// CallKfunc calls the kfunc whose BTF id in vmlinux is `btfID`.
+func (b *ProgramBuilder) CallKfunc(btfID int32) *ProgramBuilder {
+	return b.Insn(CallKfunc(btfID))
+}
+
+// CallLocal calls the function of the program at `target`, an offset or a
+// label.
+func (b *ProgramBuilder) CallLocal(target any) *ProgramBuilder {
+	switch t := target.(type) {
+	case int:
+		return b.Insn(CallLocal(int32(t)))
+	case int32:
+		return b.Insn(CallLocal(t))
+	case string:
+		b.fixups[len(b.instructions)] = t
+		return b.Insn(CallLocal(0))
+	}
+	return b.fail(fmt.Errorf("invalid call target %v (%T)", target, target))
+}
+
+// LdFunc loads the address of the function of the program at `target`, an
+// offset or a label, into `dst`.
+func (b *ProgramBuilder) LdFunc(dst pb.Reg, target any) *ProgramBuilder {
+	switch t := target.(type) {
+	case int:
+		return b.Insn(LdFunc(dst, int32(t)))
+	case int32:
+		return b.Insn(LdFunc(dst, t))
+	case string:
+		b.fixups[len(b.instructions)] = t
+		return b.Insn(LdFunc(dst, 0))
+	}
+	return b.fail(fmt.Errorf("invalid function %v (%T)", target, target))
+}
+
+func (b *ProgramBuilder) Exit() *ProgramBuilder {
+	return b.Insn(Exit())
+}
+
+func (b *ProgramBuilder) LdDW(dst pb.Reg, src pb.Reg, offset int16) *ProgramBuilder {
+	return b.Insn(LdDW(dst, src, offset))
+}
+
+func (b *ProgramBuilder) LdW(dst pb.Reg, src pb.Reg, offset int16) *ProgramBuilder {
+	return b.Insn(LdW(dst, src, offset))
+}
+
+func (b *ProgramBuilder) LdH(dst pb.Reg, src pb.Reg, offset int16) *ProgramBuilder {
+	return b.Insn(LdH(dst, src, offset))
+}
+
+func (b *ProgramBuilder) LdB(dst pb.Reg, src pb.Reg, offset int16) *ProgramBuilder {
+	return b.Insn(LdB(dst, src, offset))
+}
+
+func (b *ProgramBuilder) StDW(dst pb.Reg, src any, offset int16) *ProgramBuilder {
+	return b.store(pb.StLdSize_StLdSizeDW, dst, src, offset)
+}
+
+func (b *ProgramBuilder) StW(dst pb.Reg, src any, offset int16) *ProgramBuilder {
+	return b.store(pb.StLdSize_StLdSizeW, dst, src, offset)
+}
+
+func (b *ProgramBuilder) StH(dst pb.Reg, src any, offset int16) *ProgramBuilder {
+	return b.store(pb.StLdSize_StLdSizeH, dst, src, offset)
+}
+
+func (b *ProgramBuilder) StB(dst pb.Reg, src any, offset int16) *ProgramBuilder {
+	return b.store(pb.StLdSize_StLdSizeB, dst, src, offset)
+}
+
+// LdImm64 loads the 64 bit constant `value` in `dst`.
+func (b *ProgramBuilder) LdImm64(dst pb.Reg, value uint64) *ProgramBuilder {
+	return b.Insn(LdImm64(dst, value))
+}
+
+// LdMapByFd loads the pointer to the map `fd` in `dst`.
+func (b *ProgramBuilder) LdMapByFd(dst pb.Reg, fd int) *ProgramBuilder {
+	return b.Insn(LdMapByFd(dst, fd))
+}
+
+// Build resolves the labels and returns the program, or the first mistake
+// made while writing it. The builder must not be used afterwards.
+func (b *ProgramBuilder) Build() (*pb.Program, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	program := &pb.Program{Instructions: b.instructions}
+	slots := instructionSlots(program)
+	for index, label := range b.fixups {
+		target, ok := b.labels[label]
+		if !ok {
+			return nil, fmt.Errorf("instruction %d: undefined label %q", index, label)
+		}
+		if target == len(b.instructions) {
+			return nil, fmt.Errorf("instruction %d: label %q is past the last instruction", index, label)
+		}
+		insn := b.instructions[index]
+		if !IsRelativeJump(insn) {
+			// Local calls keep the offset in the immediate.
+			insn.Immediate = int32(slots[target] - slots[index] - 1)
+			continue
+		}
+		if err := SetJumpTarget(program, index, target); err != nil {
+			return nil, err
+		}
+	}
+	return program, nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestProgramBuilder(t *testing.T) {
+	tests := []struct {
+		testName string
+		builder  *ProgramBuilder
+		want     []*pb.Instruction
+		wantErr  bool
+	}{
+		{
+			testName: "Operands and offsets",
+			builder: NewProgramBuilder().
+				Mov64(R0, 0).Add(R0, R1).StDW(R10, int32(7), -8).LdW(R2, R10, -8).
+				JmpGT32(R2, R0, 1).Neg64(R0).Exit(),
+			want: []*pb.Instruction{
+				Mov64(R0, 0), Add(R0, R1), StDW(R10, int32(7), -8), LdW(R2, R10, -8),
+				JmpGT32(R2, R0, 1), Neg64(R0, 0), Exit(),
+			},
+		},
+		{
+			testName: "Forward and backward labels",
+			builder: NewProgramBuilder().
+				Label("start").Mov64(R0, 0).JmpEQ(R1, 0, "out").Ja("start").
+				Label("out").Exit(),
+			want: []*pb.Instruction{
+				Mov64(R0, 0), JmpEQ(R1, 0, 1), Jmp(-3), Exit(),
+			},
+		},
+		{
+			testName: "Label across a wide instruction",
+			builder: NewProgramBuilder().
+				JmpNE(R1, 0, "out").LdMapByFd(R1, 3).Mov64(R0, int64(1)<<40).
+				Label("out").Exit(),
+			want: []*pb.Instruction{
+				JmpNE(R1, 0, 4), LdMapByFd(R1, 3), Mov64(R0, int64(1)<<40), Exit(),
+			},
+		},
+		{
+			testName: "Diamond with a shared tail",
+			builder: NewProgramBuilder().
+				JmpEQ(R1, 0, "else").Mov64(R0, 1).Ja("tail").
+				Label("else").Mov64(R0, int64(2)).
+				Label("tail").JmpEQ(R0, 1, "out").Add64(R0, 1).
+				Label("out").Exit(),
+			want: []*pb.Instruction{
+				JmpEQ(R1, 0, 2), Mov64(R0, 1), Jmp(2), Mov64(R0, int64(2)),
+				JmpEQ(R0, 1, 1), Add64(R0, 1), Exit(),
+			},
+		},
+		{
+			testName: "Local call to a label",
+			builder: NewProgramBuilder().
+				CallLocal("f").Exit().
+				Label("f").Mov64(R0, 1).Exit(),
+			want: []*pb.Instruction{
+				CallLocal(1), Exit(), Mov64(R0, 1), Exit(),
+			},
+		},
+		{
+			testName: "Callback at a label",
+			builder: NewProgramBuilder().
+				LdFunc(R2, "cb").Call(Loop).Exit().
+				Label("cb").Mov64(R0, 0).Exit(),
+			want: []*pb.Instruction{
+				LdFunc(R2, 3), Call(Loop), Exit(), Mov64(R0, 0), Exit(),
+			},
+		},
+		{
+			testName: "Undefined label",
+			builder:  NewProgramBuilder().Ja("nowhere").Exit(),
+			wantErr:  true,
+		},
+		{
+			testName: "Label defined twice",
+			builder:  NewProgramBuilder().Label("a").Mov64(R0, 0).Label("a").Exit(),
+			wantErr:  true,
+		},
+		{
+			testName: "Label past the last instruction",
+			builder:  NewProgramBuilder().Ja("end").Exit().Label("end"),
+			wantErr:  true,
+		},
+		{
+			testName: "Invalid source operand",
+			builder:  NewProgramBuilder().Mov64(R0, "0").Exit(),
+			wantErr:  true,
+		},
+		{
+			testName: "Offset out of range",
+			builder:  NewProgramBuilder().Ja(1 << 16).Exit(),
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := tc.builder.Build()
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("Build() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Build() failed: %v", err)
+			}
+			if !protobuf.Equal(got, &pb.Program{Instructions: tc.want}) {
+				t.Errorf("Build() = %v, want %v", got.Instructions, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+	"math"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// InstructionWidth returns how many 64 bit slots `i` takes once encoded.
+func InstructionWidth(i *pb.Instruction) int {
+	if _, ok := i.PseudoInstruction.(*pb.Instruction_PseudoValue); ok {
+		return 2
+	}
+	return 1
+}
+
+// IsRelativeJump returns true if the offset of `i` is a jump target.
+func IsRelativeJump(i *pb.Instruction) bool {
+	jmp, ok := i.Opcode.(*pb.Instruction_JmpOpcode)
+	if !ok {
+		return false
+	}
+	op := jmp.JmpOpcode.OperationCode
+	return op != pb.JmpOperationCode_JmpCALL && op != pb.JmpOperationCode_JmpExit
+}
+
+// jumpOffset returns the offset of the relative jump `i`, which long jumps
+// keep in the immediate.
+func jumpOffset(i *pb.Instruction) int {
+	if IsLongJump(i) {
+		return int(i.Immediate)
+	}
+	return int(i.Offset)
+}
+
+// setJumpOffset changes the offset of the relative jump `i`, returns false if
+// it does not fit in 32 bits. Offsets of short jumps that don't fit in 16 bits
+// are kept as they are, the jump is promoted when the program is encoded, see
+// PromoteLongJumps.
+func setJumpOffset(i *pb.Instruction, offset int) bool {
+	if offset < math.MinInt32 || offset > math.MaxInt32 {
+		return false
+	}
+	if IsLongJump(i) {
+		i.Immediate = int32(offset)
+	} else {
+		i.Offset = int32(offset)
+	}
+	return true
+}
+
+// isPcRelative returns true if `i` refers to another instruction by its
+// distance in slots: relative jumps, calls of subprograms, see CallLocal,
+// and loads of the address of a callback, see LdFunc.
+func isPcRelative(i *pb.Instruction) bool {
+	return IsRelativeJump(i) || isPseudoCall(i) || isWideLoad(i) && i.SrcReg == PseudoFunc
+}
+
+// pcOffset returns the distance to the instruction `i` refers to, see
+// isPcRelative.
+func pcOffset(i *pb.Instruction) int {
+	if IsRelativeJump(i) {
+		return jumpOffset(i)
+	}
+	return int(i.Immediate)
+}
+
+// setPcOffset changes the distance to the instruction `i` refers to, see
+// setJumpOffset.
+func setPcOffset(i *pb.Instruction, offset int) bool {
+	if IsRelativeJump(i) {
+		return setJumpOffset(i, offset)
+	}
+	if offset < math.MinInt32 || offset > math.MaxInt32 {
+		return false
+	}
+	i.Immediate = int32(offset)
+	return true
+}
+
+// isFarJump returns true if `i` is a short relative jump whose offset does
+// not fit in 16 bits.
+func isFarJump(i *pb.Instruction) bool {
+	return IsRelativeJump(i) && !IsLongJump(i) && (i.Offset < math.MinInt16 || i.Offset > math.MaxInt16)
+}
+
+// hasFarJumps returns true if any jump of `program` must be promoted before
+// the program is encoded.
+func hasFarJumps(program *pb.Program) bool {
+	for _, insn := range program.Instructions {
+		if isFarJump(insn) {
+			return true
+		}
+	}
+	return false
+}
+
+// PromoteLongJumps returns a copy of `program` where the jumps whose offsets
+// don't fit in 16 bits are turned into long jumps. An unconditional jump
+// becomes a gotol, a conditional jump is redirected to a gotol placed right
+// after it:
+//
+//	if cond goto +1
+//	goto +1
+//	gotol target
+//
+// Promotions make the program longer, which can push other jumps out of
+// range, so offsets are computed again until no more jumps are promoted. The
+// calls of subprograms and the loads of callbacks follow their targets too.
+func PromoteLongJumps(program *pb.Program) (*pb.Program, error) {
+	// Jump targets are tracked by instruction index, they stay the same
+	// while the slots move around.
+	slots := instructionSlots(program)
+	targets := make(map[int]int)
+	for i, insn := range program.Instructions {
+		if !isPcRelative(insn) {
+			continue
+		}
+		target := slots[i] + 1 + pcOffset(insn)
+		index := -1
+		for j, slot := range slots {
+			if slot == target {
+				index = j
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("jump at instruction %d does not land on an instruction", i)
+		}
+		targets[i] = index
+	}
+
+	promoted := make([]bool, len(program.Instructions))
+	newSlots := make([]int, len(program.Instructions)+1)
+	for changed := true; changed; {
+		changed = false
+		slot := 0
+		for i, insn := range program.Instructions {
+			newSlots[i] = slot
+			switch {
+			case !promoted[i]:
+				slot += InstructionWidth(insn)
+			case insn.GetJmpOpcode().OperationCode == pb.JmpOperationCode_JmpJA:
+				slot++
+			default:
+				slot += 3
+			}
+		}
+		newSlots[len(program.Instructions)] = slot
+
+		for i, target := range targets {
+			if promoted[i] || !IsRelativeJump(program.Instructions[i]) || IsLongJump(program.Instructions[i]) {
+				continue
+			}
+			offset := newSlots[target] - newSlots[i] - 1
+			if offset < math.MinInt16 || offset > math.MaxInt16 {
+				promoted[i] = true
+				changed = true
+			}
+		}
+	}
+
+	result := &pb.Program{}
+	for i, insn := range program.Instructions {
+		target, ok := targets[i]
+		if !ok {
+			result.Instructions = append(result.Instructions, proto.Clone(insn).(*pb.Instruction))
+			continue
+		}
+		if !promoted[i] {
+			insn = proto.Clone(insn).(*pb.Instruction)
+			if !setPcOffset(insn, newSlots[target]-newSlots[i]-1) {
+				return nil, fmt.Errorf("jump at instruction %d cannot reach its target", i)
+			}
+			result.Instructions = append(result.Instructions, insn)
+			continue
+		}
+
+		// The gotol is the last instruction of the promotion.
+		gotolSlot := newSlots[i+1] - 1
+		offset := newSlots[target] - gotolSlot - 1
+		if offset < math.MinInt32 || offset > math.MaxInt32 {
+			return nil, fmt.Errorf("jump at instruction %d cannot reach its target", i)
+		}
+		if insn.GetJmpOpcode().OperationCode != pb.JmpOperationCode_JmpJA {
+			cond := proto.Clone(insn).(*pb.Instruction)
+			cond.Offset = 1
+			result.Instructions = append(result.Instructions, cond, Jmp(1))
+		}
+		result.Instructions = append(result.Instructions, Gotol(int32(offset)))
+	}
+	return result, nil
+}
+
+// ReplaceInstruction returns a copy of `program` where the instruction at
+// `index` has been replaced by `replacement`, which can be empty to remove the
+// instruction. The offsets of the jumps, calls of subprograms and loads of
+// callbacks crossing the replaced instruction are adjusted so they keep
+// pointing to the same instructions; jumps that targeted
+// the replaced instruction now land on the first replacement instruction, or
+// on the next instruction if there is none.
+func ReplaceInstruction(program *pb.Program, index int, replacement ...*pb.Instruction) (*pb.Program, error) {
+	if index < 0 || index >= len(program.Instructions) {
+		return nil, fmt.Errorf("instruction index %d out of range", index)
+	}
+
+	// Slot where the replaced instruction starts and how many slots it takes.
+	start := 0
+	for _, insn := range program.Instructions[:index] {
+		start += InstructionWidth(insn)
+	}
+	oldWidth := InstructionWidth(program.Instructions[index])
+	newWidth := 0
+	for _, insn := range replacement {
+		newWidth += InstructionWidth(insn)
+	}
+	delta := newWidth - oldWidth
+
+	result := &pb.Program{}
+	slot := 0
+	for i, insn := range program.Instructions {
+		width := InstructionWidth(insn)
+		if i == index {
+			for _, r := range replacement {
+				result.Instructions = append(result.Instructions, proto.Clone(r).(*pb.Instruction))
+			}
+			slot += width
+			continue
+		}
+
+		insn = proto.Clone(insn).(*pb.Instruction)
+		if isPcRelative(insn) {
+			target := slot + 1 + pcOffset(insn)
+			newSlot := slot
+			if slot > start {
+				newSlot += delta
+			}
+			newTarget := target
+			if target >= start+oldWidth {
+				newTarget += delta
+			}
+			if !setPcOffset(insn, newTarget-newSlot-1) {
+				return nil, fmt.Errorf("jump at instruction %d cannot reach its target", i)
+			}
+		}
+		result.Instructions = append(result.Instructions, insn)
+		slot += width
+	}
+	return result, nil
+}
+
+// RemoveInstruction returns a copy of `program` without the instruction at
+// `index`, see ReplaceInstruction.
+func RemoveInstruction(program *pb.Program, index int) (*pb.Program, error) {
+	return ReplaceInstruction(program, index)
+}
+
+// RemapMapFds returns a copy of `program` where the fds of the map loads are
+// replaced according to `fds`. Fds not present in `fds` are left untouched.
+func RemapMapFds(program *pb.Program, fds map[int]int) *pb.Program {
+	result := proto.Clone(program).(*pb.Program)
+	for _, insn := range result.Instructions {
+		mem, ok := insn.Opcode.(*pb.Instruction_MemOpcode)
+		if !ok || mem.MemOpcode.InstructionClass != pb.InsClass_InsClassLd || insn.SrcReg != PseudoMapFD {
+			continue
+		}
+		if fd, ok := fds[int(insn.Immediate)]; ok {
+			insn.Immediate = int32(fd)
+		}
+	}
+	return result
+}
+
+// instructionSlots returns the slot where each instruction of `program`
+// starts, followed by the total number of slots.
+func instructionSlots(program *pb.Program) []int {
+	slots := make([]int, 0, len(program.Instructions)+1)
+	slot := 0
+	for _, insn := range program.Instructions {
+		slots = append(slots, slot)
+		slot += InstructionWidth(insn)
+	}
+	return append(slots, slot)
+}
+
+// JumpTarget returns the index of the instruction the jump at `index` lands
+// on. Returns false if the instruction is not a relative jump or if it lands
+// outside of the program or in the middle of a wide instruction.
+func JumpTarget(program *pb.Program, index int) (int, bool) {
+	if index < 0 || index >= len(program.Instructions) || !IsRelativeJump(program.Instructions[index]) {
+		return 0, false
+	}
+	slots := instructionSlots(program)
+	target := slots[index] + 1 + jumpOffset(program.Instructions[index])
+	for i, slot := range slots[:len(program.Instructions)] {
+		if slot == target {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// SetJumpTarget changes the offset of the jump at `index` so it lands on the
+// instruction at `target`. `program` is modified in place.
+func SetJumpTarget(program *pb.Program, index, target int) error {
+	if index < 0 || index >= len(program.Instructions) || !IsRelativeJump(program.Instructions[index]) {
+		return fmt.Errorf("instruction %d is not a jump", index)
+	}
+	if target < 0 || target >= len(program.Instructions) {
+		return fmt.Errorf("jump target %d out of range", target)
+	}
+	slots := instructionSlots(program)
+	if !setJumpOffset(program.Instructions[index], slots[target]-slots[index]-1) {
+		return fmt.Errorf("jump at instruction %d cannot reach instruction %d", index, target)
+	}
+	return nil
+}
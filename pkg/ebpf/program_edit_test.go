@@ -0,0 +1,291 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestReplaceInstruction(t *testing.T) {
+	tests := []struct {
+		testName    string
+		program     []*pb.Instruction
+		index       int
+		replacement []*pb.Instruction
+		want        []*pb.Instruction
+		wantErr     bool
+	}{
+		{
+			testName: "Forward jump over removed instruction",
+			program:  []*pb.Instruction{JmpEQ(R0, 0, 2), Mov64(R1, 1), Mov64(R2, 2), Exit()},
+			index:    1,
+			want:     []*pb.Instruction{JmpEQ(R0, 0, 1), Mov64(R2, 2), Exit()},
+		},
+		{
+			testName: "Backward jump over removed instruction",
+			program:  []*pb.Instruction{Mov64(R1, 1), Mov64(R2, 2), JmpEQ(R0, 0, -3), Exit()},
+			index:    1,
+			want:     []*pb.Instruction{Mov64(R1, 1), JmpEQ(R0, 0, -2), Exit()},
+		},
+		{
+			testName: "Jump to removed instruction lands on the next one",
+			program:  []*pb.Instruction{JmpEQ(R0, 0, 1), Mov64(R1, 1), Mov64(R2, 2), Exit()},
+			index:    2,
+			want:     []*pb.Instruction{JmpEQ(R0, 0, 1), Mov64(R1, 1), Exit()},
+		},
+		{
+			testName: "Jump not crossing the removed instruction",
+			program:  []*pb.Instruction{Mov64(R1, 1), JmpEQ(R0, 0, 1), Mov64(R2, 2), Exit()},
+			index:    0,
+			want:     []*pb.Instruction{JmpEQ(R0, 0, 1), Mov64(R2, 2), Exit()},
+		},
+		{
+			testName:    "Wide instruction replaced by a mov",
+			program:     []*pb.Instruction{JmpEQ(R0, 0, 2), LdMapByFd(R1, 3), Exit()},
+			index:       1,
+			replacement: []*pb.Instruction{Mov64(R1, 0)},
+			want:        []*pb.Instruction{JmpEQ(R0, 0, 1), Mov64(R1, 0), Exit()},
+		},
+		{
+			testName: "Long jump over removed instruction",
+			program:  []*pb.Instruction{Gotol(2), Mov64(R1, 1), Mov64(R2, 2), Exit()},
+			index:    1,
+			want:     []*pb.Instruction{Gotol(1), Mov64(R2, 2), Exit()},
+		},
+		{
+			testName:    "Call of a subprogram over an insertion",
+			program:     []*pb.Instruction{CallLocal(2), Mov64(R0, 0), Exit(), Mov64(R0, 1), Exit()},
+			index:       1,
+			replacement: []*pb.Instruction{Mov64(R0, 0), Mov64(R1, 0)},
+			want:        []*pb.Instruction{CallLocal(3), Mov64(R0, 0), Mov64(R1, 0), Exit(), Mov64(R0, 1), Exit()},
+		},
+		{
+			testName: "Backward call of a subprogram over a removal",
+			program:  []*pb.Instruction{Mov64(R0, 1), Exit(), Mov64(R1, 1), CallLocal(-4), Exit()},
+			index:    2,
+			want:     []*pb.Instruction{Mov64(R0, 1), Exit(), CallLocal(-3), Exit()},
+		},
+		{
+			testName:    "Callback over an insertion",
+			program:     []*pb.Instruction{LdFunc(R2, 3), Mov64(R0, 0), Exit(), Mov64(R0, 1), Exit()},
+			index:       2,
+			replacement: []*pb.Instruction{Mov64(R1, 0), Exit()},
+			want:        []*pb.Instruction{LdFunc(R2, 4), Mov64(R0, 0), Mov64(R1, 0), Exit(), Mov64(R0, 1), Exit()},
+		},
+		{
+			testName: "Index out of range",
+			program:  []*pb.Instruction{Exit()},
+			index:    1,
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := ReplaceInstruction(&pb.Program{Instructions: tc.program}, tc.index, tc.replacement...)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("ReplaceInstruction did not return an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReplaceInstruction = %v, want nil error", err)
+			}
+			want := &pb.Program{Instructions: tc.want}
+			if !protobuf.Equal(got, want) {
+				t.Errorf("ReplaceInstruction = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestRemapMapFds(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{LdMapByFd(R1, 3), Mov64(R2, 3), LdMapByFd(R3, 4)}}
+	want := &pb.Program{Instructions: []*pb.Instruction{LdMapByFd(R1, 10), Mov64(R2, 3), LdMapByFd(R3, 4)}}
+
+	got := RemapMapFds(program, map[int]int{3: 10})
+	if !protobuf.Equal(got, want) {
+		t.Errorf("RemapMapFds() = %v, want %v", got, want)
+	}
+	if !protobuf.Equal(program.Instructions[0], LdMapByFd(R1, 3)) {
+		t.Errorf("RemapMapFds() modified the original program")
+	}
+}
+
+func TestJumpTarget(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{JmpEQ(R0, 0, 2), LdMapByFd(R1, 3), Mov64(R0, 0), JmpEQ(R0, 0, -3), Exit()}}
+	tests := []struct {
+		testName string
+		index    int
+		want     int
+		wantOk   bool
+	}{
+		{testName: "Jump over wide instruction", index: 0, want: 2, wantOk: true},
+		{testName: "Jump into wide instruction", index: 3, wantOk: false},
+		{testName: "Not a jump", index: 2, wantOk: false},
+		{testName: "Exit", index: 4, wantOk: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, ok := JumpTarget(program, tc.index)
+			if ok != tc.wantOk || (ok && got != tc.want) {
+				t.Errorf("JumpTarget(%d) = %d, %v, want %d, %v", tc.index, got, ok, tc.want, tc.wantOk)
+			}
+		})
+	}
+}
+
+func TestSetJumpTarget(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{Mov64(R0, 0), JmpEQ(R0, 0, 0), LdMapByFd(R1, 3), Exit()}}
+	if err := SetJumpTarget(program, 1, 3); err != nil {
+		t.Fatalf("SetJumpTarget() = %v, want nil error", err)
+	}
+	if got := program.Instructions[1].Offset; got != 2 {
+		t.Errorf("SetJumpTarget() offset = %d, want 2", got)
+	}
+	if err := SetJumpTarget(program, 1, 0); err != nil {
+		t.Fatalf("SetJumpTarget() = %v, want nil error", err)
+	}
+	if got := program.Instructions[1].Offset; got != -2 {
+		t.Errorf("SetJumpTarget() offset = %d, want -2", got)
+	}
+	if err := SetJumpTarget(program, 0, 3); err == nil {
+		t.Errorf("SetJumpTarget() on a mov did not return an error")
+	}
+}
+
+func TestLongJumpTarget(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{Mov64(R0, 0), Gotol(0), LdMapByFd(R1, 3), Exit()}}
+	if err := SetJumpTarget(program, 1, 3); err != nil {
+		t.Fatalf("SetJumpTarget() = %v, want nil error", err)
+	}
+	if got := program.Instructions[1].Immediate; got != 2 {
+		t.Errorf("SetJumpTarget() immediate = %d, want 2", got)
+	}
+	if got, ok := JumpTarget(program, 1); !ok || got != 3 {
+		t.Errorf("JumpTarget(1) = %d, %v, want 3, true", got, ok)
+	}
+}
+
+// farJumpProgram returns a program whose first instructions are `jumps`,
+// followed by `body` movs and an exit.
+func farJumpProgram(body int, jumps ...*pb.Instruction) *pb.Program {
+	program := &pb.Program{Instructions: jumps}
+	for i := 0; i < body; i++ {
+		program.Instructions = append(program.Instructions, Mov64(R1, 1))
+	}
+	program.Instructions = append(program.Instructions, Exit())
+	return program
+}
+
+func TestPromoteLongJumpsMovesSubprograms(t *testing.T) {
+	// The subprogram is after the body, the promotion of the jump pushes it
+	// 2 slots further from the call and the load of its address.
+	program := farJumpProgram(40000, CallLocal(0), LdFunc(R2, 0), JmpEQ(R0, 0, 0))
+	program.Instructions = append(program.Instructions, Mov64(R0, 1), Exit())
+	if err := SetJumpTarget(program, 2, 40003); err != nil {
+		t.Fatalf("SetJumpTarget() = %v, want nil error", err)
+	}
+	// The subprogram is at instruction 40004, slot 40005.
+	program.Instructions[0].Immediate = 40004
+	program.Instructions[1].Immediate = 40003
+
+	got, err := PromoteLongJumps(program)
+	if err != nil {
+		t.Fatalf("PromoteLongJumps() = %v, want nil error", err)
+	}
+	for i, want := range []*pb.Instruction{CallLocal(40006), LdFunc(R2, 40005)} {
+		if !protobuf.Equal(got.Instructions[i], want) {
+			t.Errorf("PromoteLongJumps() instruction %d = %v, want %v", i, got.Instructions[i], want)
+		}
+	}
+}
+
+func TestPromoteLongJumps(t *testing.T) {
+	tests := []struct {
+		testName string
+		program  *pb.Program
+		// Jump targets, as instruction indexes, of the original program.
+		targets map[int]int
+		want    []*pb.Instruction
+	}{
+		{
+			testName: "Jumps in range",
+			program:  farJumpProgram(32766, JmpEQ(R0, 0, 0), Jmp(0)),
+			targets:  map[int]int{0: 32768, 1: 32768},
+			want:     []*pb.Instruction{JmpEQ(R0, 0, 32767), Jmp(32766)},
+		},
+		{
+			testName: "Unconditional jump",
+			program:  farJumpProgram(40000, Jmp(0)),
+			targets:  map[int]int{0: 40001},
+			want:     []*pb.Instruction{Gotol(40000)},
+		},
+		{
+			testName: "Conditional jump",
+			program:  farJumpProgram(40000, JmpEQ(R0, 0, 0)),
+			targets:  map[int]int{0: 40001},
+			want:     []*pb.Instruction{JmpEQ(R0, 0, 1), Jmp(1), Gotol(40000)},
+		},
+		{
+			// Promoting the second jump pushes the target of the first one
+			// out of range.
+			testName: "Cascading promotion",
+			program:  farJumpProgram(40000, JmpEQ(R0, 0, 0), JmpNE(R0, 1, 0)),
+			targets:  map[int]int{0: 32768, 1: 40002},
+			want:     []*pb.Instruction{JmpEQ(R0, 0, 1), Jmp(1), Gotol(32769), JmpNE(R0, 1, 1), Jmp(1), Gotol(40000)},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			for index, target := range tc.targets {
+				if err := SetJumpTarget(tc.program, index, target); err != nil {
+					t.Fatalf("SetJumpTarget(%d, %d) = %v, want nil error", index, target, err)
+				}
+			}
+			got, err := PromoteLongJumps(tc.program)
+			if err != nil {
+				t.Fatalf("PromoteLongJumps() = %v, want nil error", err)
+			}
+			wantLength := len(tc.program.Instructions) - len(tc.targets) + len(tc.want)
+			if len(got.Instructions) != wantLength {
+				t.Fatalf("PromoteLongJumps() has %d instructions, want %d", len(got.Instructions), wantLength)
+			}
+			for i, want := range tc.want {
+				if !protobuf.Equal(got.Instructions[i], want) {
+					t.Errorf("PromoteLongJumps() instruction %d = %v, want %v", i, got.Instructions[i], want)
+				}
+			}
+
+			encoded, err := EncodeInstructions(tc.program)
+			if err != nil {
+				t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+			}
+			wantEncoded, err := EncodeInstructions(got)
+			if err != nil {
+				t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+			}
+			if len(encoded) != len(wantEncoded) || encoded[0] != wantEncoded[0] {
+				t.Errorf("EncodeInstructions() did not promote the far jumps")
+			}
+		})
+	}
+}
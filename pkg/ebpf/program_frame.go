@@ -0,0 +1,101 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+	"fmt"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// isExit returns true if `i` is the exit instruction.
+func isExit(i *pb.Instruction) bool {
+	jmp := i.GetJmpOpcode()
+	return jmp != nil && jmp.OperationCode == pb.JmpOperationCode_JmpExit && jmp.InstructionClass == pb.InsClass_InsClassJmp
+}
+
+// isPseudoCall returns true if `i` calls a subprogram.
+func isPseudoCall(i *pb.Instruction) bool {
+	return i.GetJmpOpcode().GetOperationCode() == pb.JmpOperationCode_JmpCALL && i.SrcReg == PseudoCall
+}
+
+// AssembleProgram returns the program that runs the prologue of `program`,
+// its body and then its epilogue, with neither a prologue nor an epilogue.
+// `program` is returned as is if it has neither.
+//
+// The prologue falls through into the body, it can still exit early, e.g.
+// when a map lookup fails, which skips the rest of the program. When there
+// is an epilogue the body falls through into it and its exits become jumps
+// to it, the epilogue runs with the value the body would have returned in
+// R0 and must end with an exit. The exits of subprograms can't be told from
+// the ones of the body, programs with an epilogue can't call any.
+//
+// Jumps only move within their part of the program, the ones of the body
+// can land right after it, on the epilogue.
+func AssembleProgram(program *pb.Program) (*pb.Program, error) {
+	if len(program.Prologue) == 0 && len(program.Epilogue) == 0 {
+		return program, nil
+	}
+	parts := []struct {
+		name         string
+		instructions []*pb.Instruction
+	}{
+		{"prologue", program.Prologue},
+		{"body", program.Instructions},
+		{"epilogue", program.Epilogue},
+	}
+	for _, part := range parts {
+		p := &pb.Program{Instructions: part.instructions}
+		slots := instructionSlots(p)
+		for i, insn := range part.instructions {
+			if !IsRelativeJump(insn) {
+				continue
+			}
+			if target := slots[i] + 1 + jumpOffset(insn); target < 0 || target > slots[len(slots)-1] {
+				return nil, fmt.Errorf("%s: jump at instruction %d leaves the %s", part.name, i, part.name)
+			}
+		}
+	}
+	if n := len(program.Epilogue); n > 0 && !isExit(program.Epilogue[n-1]) {
+		return nil, fmt.Errorf("epilogue: does not end with an exit")
+	}
+
+	result := &pb.Program{}
+	for _, insn := range program.Prologue {
+		result.Instructions = append(result.Instructions, proto.Clone(insn).(*pb.Instruction))
+	}
+	body := &pb.Program{Instructions: program.Instructions}
+	slots := instructionSlots(body)
+	for i, insn := range program.Instructions {
+		insn = proto.Clone(insn).(*pb.Instruction)
+		if len(program.Epilogue) > 0 {
+			if isPseudoCall(insn) {
+				return nil, fmt.Errorf("body: instruction %d calls a subprogram, the epilogue can't tell its exits apart", i)
+			}
+			if isExit(insn) {
+				insn = Jmp(0)
+				if !setJumpOffset(insn, slots[len(slots)-1]-slots[i]-1) {
+					return nil, fmt.Errorf("body: exit at instruction %d cannot reach the epilogue", i)
+				}
+			}
+		}
+		result.Instructions = append(result.Instructions, insn)
+	}
+	for _, insn := range program.Epilogue {
+		result.Instructions = append(result.Instructions, proto.Clone(insn).(*pb.Instruction))
+	}
+	return result, nil
+}
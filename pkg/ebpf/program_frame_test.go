@@ -0,0 +1,109 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestAssembleProgram(t *testing.T) {
+	tests := []struct {
+		testName string
+		program  *pb.Program
+		want     []*pb.Instruction
+		wantErr  bool
+	}{
+		{
+			testName: "Body only",
+			program:  &pb.Program{Instructions: []*pb.Instruction{Mov64(R0, 0), Exit()}},
+			want:     []*pb.Instruction{Mov64(R0, 0), Exit()},
+		},
+		{
+			testName: "Prologue falls through into the body",
+			program: &pb.Program{
+				Prologue:     []*pb.Instruction{Mov64(R6, R1), JmpEQ(R6, 0, 0)},
+				Instructions: []*pb.Instruction{Mov64(R0, 0), Exit()},
+			},
+			want: []*pb.Instruction{Mov64(R6, R1), JmpEQ(R6, 0, 0), Mov64(R0, 0), Exit()},
+		},
+		{
+			testName: "Exits of the body jump to the epilogue",
+			program: &pb.Program{
+				Instructions: []*pb.Instruction{JmpEQ(R1, 0, 2), Mov64(R0, 1), Exit(), LdMapByFd(R2, 3), Exit()},
+				Epilogue:     []*pb.Instruction{StW(R10, R0, -8), Exit()},
+			},
+			want: []*pb.Instruction{JmpEQ(R1, 0, 2), Mov64(R0, 1), Jmp(3), LdMapByFd(R2, 3), Jmp(0), StW(R10, R0, -8), Exit()},
+		},
+		{
+			testName: "Body falls through into the epilogue",
+			program: &pb.Program{
+				Prologue:     []*pb.Instruction{Mov64(R0, 0)},
+				Instructions: []*pb.Instruction{JmpEQ(R1, 0, 1), Mov64(R0, 1)},
+				Epilogue:     []*pb.Instruction{Exit()},
+			},
+			want: []*pb.Instruction{Mov64(R0, 0), JmpEQ(R1, 0, 1), Mov64(R0, 1), Exit()},
+		},
+		{
+			testName: "Prologue jumps into the body",
+			program: &pb.Program{
+				Prologue:     []*pb.Instruction{JmpEQ(R1, 0, 2), Mov64(R0, 0)},
+				Instructions: []*pb.Instruction{Mov64(R0, 1), Exit()},
+			},
+			wantErr: true,
+		},
+		{
+			testName: "Epilogue without exit",
+			program: &pb.Program{
+				Instructions: []*pb.Instruction{Mov64(R0, 1)},
+				Epilogue:     []*pb.Instruction{Mov64(R0, 0)},
+			},
+			wantErr: true,
+		},
+		{
+			testName: "Subprogram with an epilogue",
+			program: &pb.Program{
+				Instructions: []*pb.Instruction{CallLocal(1), Exit(), Mov64(R0, 0), Exit()},
+				Epilogue:     []*pb.Instruction{Exit()},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			original := protobuf.Clone(tc.program)
+			got, err := AssembleProgram(tc.program)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("AssembleProgram = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AssembleProgram = %v, want nil error", err)
+			}
+			want := &pb.Program{Instructions: tc.want}
+			if !protobuf.Equal(got, want) {
+				t.Errorf("AssembleProgram = %v, want %v", got, want)
+			}
+			if !protobuf.Equal(tc.program, original) {
+				t.Errorf("AssembleProgram modified the original program")
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+)
+
+// ToTextProto returns `program` in the protobuf text format, the easiest to
+// edit by hand.
+func ToTextProto(program *pb.Program) string {
+	return proto.MarshalTextString(program)
+}
+
+// FromTextProto parses a program in the protobuf text format.
+func FromTextProto(text string) (*pb.Program, error) {
+	program := &pb.Program{}
+	if err := proto.UnmarshalText(text, program); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+// ToJSON returns `program` in the JSON format of the PoCs, see GeneratePoc.
+func ToJSON(program *pb.Program) (string, error) {
+	m := &jsonpb.Marshaler{
+		OrigName:     true,
+		EnumsAsInts:  false,
+		EmitDefaults: true,
+		Indent:       "   ",
+	}
+	return m.MarshalToString(program)
+}
+
+// FromJSON parses a program in the JSON format, e.g. a PoC written by
+// GeneratePoc.
+func FromJSON(data string) (*pb.Program, error) {
+	program := &pb.Program{}
+	if err := jsonpb.UnmarshalString(data, program); err != nil {
+		return nil, err
+	}
+	return program, nil
+}
+
+// ReadProgram reads the program in the file at `path`, whose format is told
+// by its extension: JSON for .json, the protobuf text format for .textproto,
+// .txtpb and .pbtxt and the protobuf binary format for anything else.
+func ReadProgram(path string) (*pb.Program, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var program *pb.Program
+	switch filepath.Ext(path) {
+	case ".json":
+		program, err = FromJSON(string(data))
+	case ".textproto", ".txtpb", ".pbtxt":
+		program, err = FromTextProto(string(data))
+	default:
+		program = &pb.Program{}
+		err = proto.Unmarshal(data, program)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return program, nil
+}
+
+// WriteProgram writes `program` to the file at `path`, in the format told by
+// its extension, see ReadProgram.
+func WriteProgram(path string, program *pb.Program) error {
+	var data []byte
+	switch filepath.Ext(path) {
+	case ".json":
+		text, err := ToJSON(program)
+		if err != nil {
+			return err
+		}
+		data = []byte(text)
+	case ".textproto", ".txtpb", ".pbtxt":
+		data = []byte(ToTextProto(program))
+	default:
+		var err error
+		if data, err = proto.Marshal(program); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, data, 0644)
+}
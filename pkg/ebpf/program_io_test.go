@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestProgramFormats(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{
+		LdMapByFd(R1, 3),
+		Mov64(R0, int32(-1)),
+		JmpEQ(R1, 0, 1),
+		Mov64(R0, 0),
+		Exit(),
+	}}
+
+	text := ToTextProto(program)
+	if !strings.Contains(text, "operation_code: JmpJEQ") {
+		t.Errorf("ToTextProto() does not name the opcodes:\n%s", text)
+	}
+	fromText, err := FromTextProto(text)
+	if err != nil {
+		t.Fatalf("FromTextProto() = %v", err)
+	}
+	if !protobuf.Equal(fromText, program) {
+		t.Errorf("FromTextProto(ToTextProto()) = %v, want %v", fromText, program)
+	}
+
+	json, err := ToJSON(program)
+	if err != nil {
+		t.Fatalf("ToJSON() = %v", err)
+	}
+	fromJSON, err := FromJSON(json)
+	if err != nil {
+		t.Fatalf("FromJSON() = %v", err)
+	}
+	if !protobuf.Equal(fromJSON, program) {
+		t.Errorf("FromJSON(ToJSON()) = %v, want %v", fromJSON, program)
+	}
+
+	if _, err := FromTextProto("instructions { bogus: 1 }"); err == nil {
+		t.Errorf("FromTextProto() of an unknown field did not return an error")
+	}
+	if _, err := FromJSON("{"); err == nil {
+		t.Errorf("FromJSON() of truncated JSON did not return an error")
+	}
+}
+
+func TestReadWriteProgram(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{Mov64(R0, 0), Exit()}}
+	dir := t.TempDir()
+	for _, name := range []string{"prog.json", "prog.textproto", "prog.txtpb", "prog.pbtxt", "prog.pb"} {
+		path := filepath.Join(dir, name)
+		if err := WriteProgram(path, program); err != nil {
+			t.Fatalf("WriteProgram(%s) = %v", name, err)
+		}
+		got, err := ReadProgram(path)
+		if err != nil {
+			t.Fatalf("ReadProgram(%s) = %v", name, err)
+		}
+		if !protobuf.Equal(got, program) {
+			t.Errorf("ReadProgram(%s) = %v, want %v", name, got, program)
+		}
+	}
+
+	// The extension tells the format, a JSON program is not a text proto.
+	json, err := ToJSON(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "json.textproto")
+	if err := os.WriteFile(path, []byte(json), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadProgram(path); err == nil {
+		t.Errorf("ReadProgram() of JSON in a .textproto file did not return an error")
+	}
+	if _, err := ReadProgram(filepath.Join(dir, "missing.json")); err == nil {
+		t.Errorf("ReadProgram() of a missing file did not return an error")
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// ReferenceHelper describes a helper that returns a referenced object, or
+// NULL. The verifier tracks the reference until the program passes the
+// object to one of the Releases helpers or finds out it is NULL, it must
+// reject the programs that can exit, or release the object again, before
+// that.
+type ReferenceHelper struct {
+	Name     string
+	Acquire  int32
+	Releases []int32
+}
+
+// ReferenceHelpers contains the acquire and release helpers buzzer models.
+var ReferenceHelpers = []*ReferenceHelper{
+	{Name: "sk_lookup_tcp", Acquire: SkLookupTcp, Releases: []int32{SkRelease}},
+	{Name: "sk_lookup_udp", Acquire: SkLookupUdp, Releases: []int32{SkRelease}},
+	{Name: "skc_lookup_tcp", Acquire: SkcLookupTcp, Releases: []int32{SkRelease}},
+	{Name: "ringbuf_reserve", Acquire: RingbufReserve, Releases: []int32{RingbufSubmit, RingbufDiscard}},
+}
+
+// ReferenceHelperByID returns the model of the acquire helper `id` or nil if
+// the helper does not return referenced objects.
+func ReferenceHelperByID(id int32) *ReferenceHelper {
+	for _, rh := range ReferenceHelpers {
+		if rh.Acquire == id {
+			return rh
+		}
+	}
+	return nil
+}
+
+// isReleaseHelper returns true if the helper `id` releases the object passed
+// in R1.
+func isReleaseHelper(id int32) bool {
+	for _, rh := range ReferenceHelpers {
+		for _, release := range rh.Releases {
+			if release == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+const (
+	// Number of instructions ObviousLeaks goes through, over all paths,
+	// before giving up.
+	leakAnalysisBudget = 1 << 16
+
+	// Maximum number of acquire calls of a program ObviousLeaks analyzes.
+	leakAnalysisMaxAcquires = 64
+)
+
+// leakState is the state of a path of the leak analysis: which reference,
+// identified by the bit of its acquire call, every register holds and which
+// references are not released yet.
+type leakState struct {
+	regs        [11]uint64
+	outstanding uint64
+}
+
+// drop forgets reference `ref`, it was released or found to be NULL.
+func (s *leakState) drop(ref uint64) {
+	s.outstanding &^= ref
+	for r := range s.regs {
+		if s.regs[r] == ref {
+			s.regs[r] = 0
+		}
+	}
+}
+
+// leakAnalysis goes through every path of a program, see ObviousLeaks.
+type leakAnalysis struct {
+	program *pb.Program
+	targets map[int]int
+	bits    map[int]uint64
+	budget  int
+
+	// By acquire bit: the acquire call was reached on a path that is
+	// feasible for sure, the reference was still outstanding at an exit,
+	// and the reference was released or escaped the analysis on some path.
+	feasible uint64
+	leaked   uint64
+	unknown  uint64
+}
+
+// lose marks the references held by `regs` as escaping the analysis.
+func (la *leakAnalysis) lose(state *leakState, regs ...pb.Reg) {
+	for _, r := range regs {
+		la.unknown |= state.regs[r]
+	}
+}
+
+// walk follows the path that reaches instruction `index` with `state`.
+// `feasible` is true if nothing but the outcome of acquire calls decided the
+// branches taken so far, so the verifier must explore the path too. Returns
+// false once the budget runs out or the program has something the analysis
+// does not handle.
+func (la *leakAnalysis) walk(index int, state leakState, feasible bool) bool {
+	for {
+		if index < 0 || index >= len(la.program.Instructions) {
+			return false
+		}
+		la.budget--
+		if la.budget < 0 {
+			return false
+		}
+		insn := la.program.Instructions[index]
+
+		switch op := insn.Opcode.(type) {
+		case *pb.Instruction_JmpOpcode:
+			code := op.JmpOpcode.OperationCode
+			switch code {
+			case pb.JmpOperationCode_JmpExit:
+				la.leaked |= state.outstanding
+				return true
+
+			case pb.JmpOperationCode_JmpCALL:
+				la.call(index, insn, &state, feasible)
+				if insn.SrcReg == 0 && insn.Immediate == TailCall {
+					// Only failed tail calls come back.
+					feasible = false
+				}
+				index++
+				continue
+
+			case pb.JmpOperationCode_JmpJA:
+				target, ok := la.targets[index]
+				if !ok {
+					return false
+				}
+				index = target
+				continue
+			}
+
+			target, ok := la.targets[index]
+			if !ok {
+				return false
+			}
+			ref := state.regs[insn.DstReg]
+			nullCheck := ref != 0 && op.JmpOpcode.InstructionClass == pb.InsClass_InsClassJmp &&
+				op.JmpOpcode.Source == pb.SrcOperand_Immediate && insn.Immediate == 0 &&
+				(code == pb.JmpOperationCode_JmpJEQ || code == pb.JmpOperationCode_JmpJNE)
+			if !nullCheck {
+				la.lose(&state, insn.DstReg)
+				if op.JmpOpcode.Source == pb.SrcOperand_RegSrc {
+					la.lose(&state, insn.SrcReg)
+				}
+				if !la.walk(target, state, false) {
+					return false
+				}
+				index++
+				feasible = false
+				continue
+			}
+
+			// Both outcomes of an acquire call are possible, the
+			// reference is gone on the NULL branch.
+			null := state
+			null.drop(ref)
+			nullIndex, otherIndex := target, index+1
+			if code == pb.JmpOperationCode_JmpJNE {
+				nullIndex, otherIndex = index+1, target
+			}
+			if !la.walk(nullIndex, null, feasible) {
+				return false
+			}
+			index = otherIndex
+			continue
+
+		case *pb.Instruction_AluOpcode:
+			isMov := op.AluOpcode.OperationCode == pb.AluOperationCode_AluMov
+			fromReg := op.AluOpcode.Source == pb.SrcOperand_RegSrc
+			if isMov && fromReg && op.AluOpcode.InstructionClass == pb.InsClass_InsClassAlu64 {
+				state.regs[insn.DstReg] = state.regs[insn.SrcReg]
+				index++
+				continue
+			}
+			// Arithmetic on a reference or writing it somewhere else
+			// than a register copy is beyond the analysis.
+			if !isMov {
+				la.lose(&state, insn.DstReg)
+			}
+			if fromReg {
+				la.lose(&state, insn.SrcReg)
+			}
+			state.regs[insn.DstReg] = 0
+
+		case *pb.Instruction_MemOpcode:
+			switch op.MemOpcode.InstructionClass {
+			case pb.InsClass_InsClassStx:
+				// Spills can be filled back and released.
+				la.lose(&state, insn.SrcReg)
+				if op.MemOpcode.Mode == pb.StLdMode_StLdModeATOMIC {
+					state.regs[insn.SrcReg] = 0
+					state.regs[R0] = 0
+				}
+			case pb.InsClass_InsClassLdx, pb.InsClass_InsClassLd:
+				la.lose(&state, insn.DstReg)
+				state.regs[insn.DstReg] = 0
+				if op.MemOpcode.Mode == pb.StLdMode_StLdModeABS || op.MemOpcode.Mode == pb.StLdMode_StLdModeIND {
+					// Legacy packet loads clobber the caller saved
+					// registers.
+					for r := R0; r <= R5; r++ {
+						state.regs[r] = 0
+					}
+				}
+			}
+		}
+		index++
+	}
+}
+
+// call applies the helper call `insn`, at `index`, to `state`.
+func (la *leakAnalysis) call(index int, insn *pb.Instruction, state *leakState, feasible bool) {
+	if insn.SrcReg != 0 {
+		// Calls of subprograms and kfuncs can do anything with the
+		// references they are passed.
+		la.lose(state, R1, R2, R3, R4, R5)
+	} else if isReleaseHelper(insn.Immediate) && state.regs[R1] != 0 {
+		la.unknown |= state.regs[R1]
+		state.drop(state.regs[R1])
+	} else if bit, ok := la.bits[index]; ok {
+		if state.outstanding&bit != 0 {
+			// Reached again, e.g. in a loop, before the previous
+			// reference was released.
+			la.unknown |= bit
+		}
+		if feasible {
+			la.feasible |= bit
+		}
+		for r := R0; r <= R5; r++ {
+			state.regs[r] = 0
+		}
+		state.regs[R0] = bit
+		state.outstanding |= bit
+		return
+	} else {
+		la.lose(state, R1, R2, R3, R4, R5)
+	}
+	for r := R0; r <= R5; r++ {
+		state.regs[r] = 0
+	}
+}
+
+// ObviousLeaks returns the indexes of the acquire calls of `program`, see
+// ReferenceHelpers, whose reference is obviously leaked: the call is reached
+// whatever the values the program works on, and on every path from it the
+// program exits without releasing the reference or finding out it is NULL.
+// The verifier must reject such programs.
+//
+// The analysis is conservative, references that are spilled, passed to
+// other helpers or subprograms, or released on some path are not reported,
+// and nothing is reported for programs it can't go through, e.g. with too
+// many paths.
+func ObviousLeaks(program *pb.Program) []int {
+	la := &leakAnalysis{
+		program: program,
+		targets: make(map[int]int),
+		bits:    make(map[int]uint64),
+		budget:  leakAnalysisBudget,
+	}
+	var acquires []int
+	for i, insn := range program.Instructions {
+		if target, ok := JumpTarget(program, i); ok {
+			la.targets[i] = target
+		}
+		if insn.GetJmpOpcode().GetOperationCode() == pb.JmpOperationCode_JmpCALL && insn.SrcReg == 0 && ReferenceHelperByID(insn.Immediate) != nil {
+			if len(acquires) == leakAnalysisMaxAcquires {
+				return nil
+			}
+			la.bits[i] = 1 << len(acquires)
+			acquires = append(acquires, i)
+		}
+	}
+	if len(acquires) == 0 || !la.walk(0, leakState{}, true) {
+		return nil
+	}
+
+	var leaks []int
+	for _, i := range acquires {
+		bit := la.bits[i]
+		if la.feasible&bit != 0 && la.leaked&bit != 0 && la.unknown&bit == 0 {
+			leaks = append(leaks, i)
+		}
+	}
+	return leaks
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"reflect"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestObviousLeaks(t *testing.T) {
+	tests := []struct {
+		testName string
+		program  []*pb.Instruction
+		want     []int
+	}{
+		{
+			testName: "Released after the null check",
+			program: []*pb.Instruction{
+				Call(SkLookupTcp), JmpEQ(R0, 0, 2), Mov64(R1, R0), Call(SkRelease),
+				Mov64(R0, 0), Exit(),
+			},
+		},
+		{
+			testName: "Leaked",
+			program: []*pb.Instruction{
+				Call(SkLookupTcp), Mov64(R0, 0), Exit(),
+			},
+			want: []int{0},
+		},
+		{
+			testName: "Leaked after the null check",
+			program: []*pb.Instruction{
+				Call(SkLookupUdp), JmpNE(R0, 0, 1), Exit(), Mov64(R0, 0), Exit(),
+			},
+			want: []int{0},
+		},
+		{
+			testName: "Released through a copy",
+			program: []*pb.Instruction{
+				Call(RingbufReserve), Mov64(R6, R0), JmpEQ(R6, 0, 3), Mov64(R1, R6),
+				Mov64(R2, 0), Call(RingbufSubmit), Mov64(R0, 0), Exit(),
+			},
+		},
+		{
+			testName: "Released on one path only",
+			program: []*pb.Instruction{
+				Call(SkLookupTcp), Mov64(R6, R0), Call(GetPrandomU32), JmpEQ(R0, 0, 3),
+				JmpEQ(R6, 0, 2), Mov64(R1, R6), Call(SkRelease), Mov64(R0, 0), Exit(),
+			},
+		},
+		{
+			testName: "Acquired on a branch that depends on data",
+			program: []*pb.Instruction{
+				Call(GetPrandomU32), JmpEQ(R0, 0, 1), Call(SkLookupTcp), Mov64(R0, 0), Exit(),
+			},
+		},
+		{
+			testName: "Passed to a subprogram",
+			program: []*pb.Instruction{
+				Call(SkLookupTcp), Mov64(R1, R0), CallLocal(2), Mov64(R0, 0), Exit(),
+				Mov64(R0, 0), Exit(),
+			},
+		},
+		{
+			testName: "Only the second reference is leaked",
+			program: []*pb.Instruction{
+				Call(SkLookupTcp), Mov64(R6, R0), Call(SkcLookupTcp), JmpEQ(R6, 0, 2),
+				Mov64(R1, R6), Call(SkRelease), Mov64(R0, 0), Exit(),
+			},
+			want: []int{2},
+		},
+		{
+			testName: "No acquire",
+			program:  []*pb.Instruction{Mov64(R0, 0), Exit()},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got := ObviousLeaks(&pb.Program{Instructions: tc.program})
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ObviousLeaks() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -150,6 +150,54 @@ func LdB(dst pb.Reg, src pb.Reg, offset int16) *pb.Instruction {
 	return newLoadOperation(pb.StLdSize_StLdSizeB, dst, src, offset)
 }
 
+// newSignExtendLoadOperation returns a load of the v4 ISA that sign extends
+// the loaded value to 64 bits instead of zero extending it.
+func newSignExtendLoadOperation(size pb.StLdSize, dst pb.Reg, src pb.Reg, offset int16) *pb.Instruction {
+	insn := newLoadOperation(size, dst, src, offset)
+	insn.GetMemOpcode().Mode = pb.StLdMode_StLdModeMEMSX
+	return insn
+}
+
+// LdSXW Loads 4 byte data from `src` into `dst` and sign extends it
+func LdSXW(dst pb.Reg, src pb.Reg, offset int16) *pb.Instruction {
+	return newSignExtendLoadOperation(pb.StLdSize_StLdSizeW, dst, src, offset)
+}
+
+// LdSXH Loads 2 byte (Half word) data from `src` into `dst` and sign extends
+// it
+func LdSXH(dst pb.Reg, src pb.Reg, offset int16) *pb.Instruction {
+	return newSignExtendLoadOperation(pb.StLdSize_StLdSizeH, dst, src, offset)
+}
+
+// LdSXB Loads 1 byte data from `src` into `dst` and sign extends it
+func LdSXB(dst pb.Reg, src pb.Reg, offset int16) *pb.Instruction {
+	return newSignExtendLoadOperation(pb.StLdSize_StLdSizeB, dst, src, offset)
+}
+
+// LdImm64 loads the 64 bit constant `value` into `dst` with the wide
+// BPF_LD | BPF_IMM | BPF_DW instruction, which takes two slots: the lower 32
+// bits of `value` go in the immediate of the first one and the upper 32 bits
+// in the immediate of the second one.
+func LdImm64(dst pb.Reg, value uint64) *pb.Instruction {
+	pseudoIns := &pb.Instruction{
+		Opcode: &pb.Instruction_MemOpcode{
+			MemOpcode: &pb.MemOpcode{
+				Mode:             0,
+				Size:             0,
+				InstructionClass: 0,
+			},
+		},
+		DstReg:    0,
+		SrcReg:    0,
+		Offset:    0,
+		Immediate: int32(value >> 32),
+		PseudoInstruction: &pb.Instruction_Empty{
+			Empty: &pb.Empty{},
+		},
+	}
+	return newLoadImmOperation(pb.StLdSize_StLdSizeDW, dst, UnusedField, UnusedField, int32(value), pseudoIns)
+}
+
 func LdMapByFd(dst pb.Reg, fd int) *pb.Instruction {
 	pseudoIns := &pb.Instruction{
 		Opcode: &pb.Instruction_MemOpcode{
@@ -170,6 +218,96 @@ func LdMapByFd(dst pb.Reg, fd int) *pb.Instruction {
 	return newLoadImmOperation(pb.StLdSize_StLdSizeDW, dst, PseudoMapFD, UnusedField, int32(fd), pseudoIns)
 }
 
+// LdMapValue loads the address of the byte at `offset` of the value of the
+// map `fd` into `dst`. Only array maps with a single element support it.
+func LdMapValue(dst pb.Reg, fd int, offset int32) *pb.Instruction {
+	insn := LdMapByFd(dst, fd)
+	insn.SrcReg = PseudoMapValue
+	insn.GetPseudoValue().Immediate = offset
+	return insn
+}
+
+// LdFunc loads the address of the function of the program that starts
+// `offset` instructions after the load into `dst`, e.g. to pass it as a
+// callback to a helper like bpf_loop.
+func LdFunc(dst pb.Reg, offset int32) *pb.Instruction {
+	insn := LdMapByFd(dst, int(offset))
+	insn.SrcReg = PseudoFunc
+	return insn
+}
+
+// newPacketLoadOperation returns one of the legacy packet access
+// instructions carried over from classic BPF. They load `size` bytes of the
+// packet at `imm`, plus the value of `src` in BPF_IND mode, into R0.
+func newPacketLoadOperation(mode pb.StLdMode, size pb.StLdSize, src pb.Reg, imm int32) *pb.Instruction {
+	return &pb.Instruction{
+		Opcode: &pb.Instruction_MemOpcode{
+			MemOpcode: &pb.MemOpcode{
+				Mode:             mode,
+				Size:             size,
+				InstructionClass: pb.InsClass_InsClassLd,
+			},
+		},
+		DstReg:    UnusedField,
+		SrcReg:    src,
+		Offset:    UnusedField,
+		Immediate: imm,
+		PseudoInstruction: &pb.Instruction_Empty{
+			Empty: &pb.Empty{},
+		},
+	}
+}
+
+// LdAbsW loads 4 bytes of the packet at offset `imm` into R0, converted from
+// network byte order.
+//
+// Packet loads are only allowed in socket filter programs and need the
+// context in R6. The verifier treats them like a helper call: R1-R5 are
+// clobbered and an out of bounds access makes the program exit returning 0.
+func LdAbsW(imm int32) *pb.Instruction {
+	return newPacketLoadOperation(pb.StLdMode_StLdModeABS, pb.StLdSize_StLdSizeW, UnusedField, imm)
+}
+
+// LdAbsH loads 2 bytes (Half word) of the packet at offset `imm` into R0, see
+// LdAbsW.
+func LdAbsH(imm int32) *pb.Instruction {
+	return newPacketLoadOperation(pb.StLdMode_StLdModeABS, pb.StLdSize_StLdSizeH, UnusedField, imm)
+}
+
+// LdAbsB loads 1 byte of the packet at offset `imm` into R0, see LdAbsW.
+func LdAbsB(imm int32) *pb.Instruction {
+	return newPacketLoadOperation(pb.StLdMode_StLdModeABS, pb.StLdSize_StLdSizeB, UnusedField, imm)
+}
+
+// LdIndW loads 4 bytes of the packet at offset `src` + `imm` into R0, see
+// LdAbsW.
+func LdIndW(src pb.Reg, imm int32) *pb.Instruction {
+	return newPacketLoadOperation(pb.StLdMode_StLdModeIND, pb.StLdSize_StLdSizeW, src, imm)
+}
+
+// LdIndH loads 2 bytes (Half word) of the packet at offset `src` + `imm`
+// into R0, see LdAbsW.
+func LdIndH(src pb.Reg, imm int32) *pb.Instruction {
+	return newPacketLoadOperation(pb.StLdMode_StLdModeIND, pb.StLdSize_StLdSizeH, src, imm)
+}
+
+// LdIndB loads 1 byte of the packet at offset `src` + `imm` into R0, see
+// LdAbsW.
+func LdIndB(src pb.Reg, imm int32) *pb.Instruction {
+	return newPacketLoadOperation(pb.StLdMode_StLdModeIND, pb.StLdSize_StLdSizeB, src, imm)
+}
+
+// IsPacketLoad returns true if `i` is a legacy BPF_ABS or BPF_IND packet
+// load.
+func IsPacketLoad(i *pb.Instruction) bool {
+	mem, ok := i.Opcode.(*pb.Instruction_MemOpcode)
+	if !ok || mem.MemOpcode.InstructionClass != pb.InsClass_InsClassLd {
+		return false
+	}
+	mode := mem.MemOpcode.Mode
+	return mode == pb.StLdMode_StLdModeABS || mode == pb.StLdMode_StLdModeIND
+}
+
 func newAtomicInstruction(dst, src pb.Reg, size pb.StLdSize, offset int16, operation int32) *pb.Instruction {
 	class := pb.InsClass_InsClassStx
 
@@ -195,6 +333,19 @@ func newAtomicInstruction(dst, src pb.Reg, size pb.StLdSize, offset int16, opera
 	}
 }
 
+// Xadd64 returns the legacy BPF_XADD form of MemAdd64, the only atomic
+// operation kernels older than 5.12 accept. BPF_XADD is the BPF_ATOMIC mode
+// with BPF_ADD, without BPF_FETCH, in the immediate, so newer kernels accept
+// it too.
+func Xadd64(dst, src pb.Reg, offset int16) *pb.Instruction {
+	return newAtomicInstruction(dst, src, pb.StLdSize_StLdSizeDW, offset, int32(pb.AluOperationCode_AluAdd))
+}
+
+// Xadd is the 32 bits variant of Xadd64.
+func Xadd(dst, src pb.Reg, offset int16) *pb.Instruction {
+	return newAtomicInstruction(dst, src, pb.StLdSize_StLdSizeW, offset, int32(pb.AluOperationCode_AluAdd))
+}
+
 func MemAdd64(dst, src pb.Reg, offset int16) *pb.Instruction {
 	return newAtomicInstruction(dst, src, pb.StLdSize_StLdSizeDW, offset, int32(pb.AluOperationCode_AluAdd))
 }
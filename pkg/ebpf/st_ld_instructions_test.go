@@ -198,6 +198,78 @@ func TestMemoryInstructionCorrectEncoding(t *testing.T) {
 			wantImm:              42,
 			wantEncoding:         []uint64{0x2a00001918, 0},
 		},
+		{
+			testName:             "Encoding LdAbsW Instruction",
+			instruction:          LdAbsW(testImm),
+			wantMode:             pb.StLdMode_StLdModeABS,
+			wantSize:             pb.StLdSize_StLdSizeW,
+			wantInstructionClass: pb.InsClass_InsClassLd,
+			wantOffset:           0,
+			wantDstReg:           UnusedField,
+			wantSrcReg:           UnusedField,
+			wantImm:              testImm,
+			wantEncoding:         []uint64{0x53900000020},
+		},
+		{
+			testName:             "Encoding LdAbsH Instruction",
+			instruction:          LdAbsH(testImm),
+			wantMode:             pb.StLdMode_StLdModeABS,
+			wantSize:             pb.StLdSize_StLdSizeH,
+			wantInstructionClass: pb.InsClass_InsClassLd,
+			wantOffset:           0,
+			wantDstReg:           UnusedField,
+			wantSrcReg:           UnusedField,
+			wantImm:              testImm,
+			wantEncoding:         []uint64{0x53900000028},
+		},
+		{
+			testName:             "Encoding LdIndB Instruction",
+			instruction:          LdIndB(testDstReg, testImm),
+			wantMode:             pb.StLdMode_StLdModeIND,
+			wantSize:             pb.StLdSize_StLdSizeB,
+			wantInstructionClass: pb.InsClass_InsClassLd,
+			wantOffset:           0,
+			wantDstReg:           UnusedField,
+			wantSrcReg:           testDstReg,
+			wantImm:              testImm,
+			wantEncoding:         []uint64{0x53900009050},
+		},
+		{
+			testName:             "Encoding LdSXW Instruction",
+			instruction:          LdSXW(testDstReg, testSrcReg, testOffset),
+			wantMode:             pb.StLdMode_StLdModeMEMSX,
+			wantSize:             pb.StLdSize_StLdSizeW,
+			wantInstructionClass: pb.InsClass_InsClassLdx,
+			wantOffset:           testOffset,
+			wantDstReg:           testDstReg,
+			wantSrcReg:           testSrcReg,
+			wantImm:              0,
+			wantEncoding:         []uint64{0xfff80981},
+		},
+		{
+			testName:             "Encoding LdSXH Instruction",
+			instruction:          LdSXH(testDstReg, testSrcReg, testOffset),
+			wantMode:             pb.StLdMode_StLdModeMEMSX,
+			wantSize:             pb.StLdSize_StLdSizeH,
+			wantInstructionClass: pb.InsClass_InsClassLdx,
+			wantOffset:           testOffset,
+			wantDstReg:           testDstReg,
+			wantSrcReg:           testSrcReg,
+			wantImm:              0,
+			wantEncoding:         []uint64{0xfff80989},
+		},
+		{
+			testName:             "Encoding LdSXB Instruction",
+			instruction:          LdSXB(testDstReg, testSrcReg, testOffset),
+			wantMode:             pb.StLdMode_StLdModeMEMSX,
+			wantSize:             pb.StLdSize_StLdSizeB,
+			wantInstructionClass: pb.InsClass_InsClassLdx,
+			wantOffset:           testOffset,
+			wantDstReg:           testDstReg,
+			wantSrcReg:           testSrcReg,
+			wantImm:              0,
+			wantEncoding:         []uint64{0xfff80991},
+		},
 	}
 
 	for _, tc := range tests {
@@ -242,7 +314,7 @@ func TestMemoryInstructionCorrectEncoding(t *testing.T) {
 				t.Errorf("instruction.Imm = %d, want = %d", instruction.Immediate, tc.wantImm)
 			}
 
-			encodingArray, err := encodeInstruction(instruction)
+			encodingArray, err := encodeInstruction(instruction, LittleEndian)
 
 			if err != nil {
 				t.Fatalf("unexpected error when ecoding: %v", err)
@@ -254,3 +326,26 @@ func TestMemoryInstructionCorrectEncoding(t *testing.T) {
 		})
 	}
 }
+
+func TestIsPacketLoad(t *testing.T) {
+	tests := []struct {
+		testName    string
+		instruction *pb.Instruction
+		want        bool
+	}{
+		{"LdAbsW", LdAbsW(0), true},
+		{"LdIndH", LdIndH(R6, 2), true},
+		{"Ldx", LdW(R1, R10, -4), false},
+		{"Wide load", LdMapByFd(R1, 3), false},
+		{"Store", StW(R10, 4, -4), false},
+		{"Alu", Mov64(R0, 0), false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := IsPacketLoad(tc.instruction); got != tc.want {
+				t.Errorf("IsPacketLoad() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+)
+
+const (
+	// StackSize is the size in bytes of the stack frame of a program, R10
+	// points right past its end.
+	StackSize = 512
+
+	// StackSlotSize is the size of the slots the verifier tracks register
+	// spills in.
+	StackSlotSize = 8
+)
+
+var (
+	StackAccessOutOfBounds = fmt.Errorf("Stack access out of bounds")
+)
+
+// StackByteKind is what a byte of the stack holds.
+type StackByteKind int
+
+const (
+	// StackUninit bytes were never written.
+	StackUninit StackByteKind = iota
+
+	// StackScalar bytes are part of a scalar, either a spilled register or
+	// an immediate.
+	StackScalar
+
+	// StackPointer bytes are part of a spilled pointer.
+	StackPointer
+)
+
+// StackByte describes a byte of the stack.
+type StackByte struct {
+	Kind StackByteKind
+
+	// Source identifies the value the byte was copied from, it is chosen
+	// by the caller of StackModel.Store.
+	Source int
+
+	// Index is the position of the byte in Source, 0 being the least
+	// significant one.
+	Index int
+}
+
+// StackModel tracks what every byte of the stack of a program holds as
+// stores are generated, so the value a later load reads back is known. The
+// zero value is a stack where nothing was written.
+type StackModel struct {
+	bytes [StackSize]StackByte
+}
+
+// stackIndex returns the index in the model of the byte at `offset` from R10,
+// checking that the `size` bytes starting there are within the frame.
+func stackIndex(offset int16, size int) (int, error) {
+	index := StackSize + int(offset)
+	if index < 0 || size <= 0 || index+size > StackSize {
+		return 0, fmt.Errorf("%w: %d bytes at offset %d", StackAccessOutOfBounds, size, offset)
+	}
+	return index, nil
+}
+
+// IsAlignedStackAccess returns true if an access of `size` bytes at `offset`
+// from R10 is naturally aligned, the verifier refuses the rest.
+func IsAlignedStackAccess(offset int16, size int) bool {
+	return int(offset)%size == 0
+}
+
+// Store records that the `size` least significant bytes of `source` were
+// written at `offset` from R10.
+func (sm *StackModel) Store(offset int16, size int, kind StackByteKind, source int) error {
+	index, err := stackIndex(offset, size)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < size; i++ {
+		sm.bytes[index+i] = StackByte{Kind: kind, Source: source, Index: i}
+	}
+	return nil
+}
+
+// Load returns the bytes a load of `size` bytes at `offset` from R10 reads,
+// the least significant one first.
+func (sm *StackModel) Load(offset int16, size int) ([]StackByte, error) {
+	index, err := stackIndex(offset, size)
+	if err != nil {
+		return nil, err
+	}
+	return append([]StackByte{}, sm.bytes[index:index+size]...), nil
+}
+
+// IsInitialized returns true if all the `size` bytes at `offset` from R10
+// were written.
+func (sm *StackModel) IsInitialized(offset int16, size int) bool {
+	bytes, err := sm.Load(offset, size)
+	if err != nil {
+		return false
+	}
+	for _, b := range bytes {
+		if b.Kind == StackUninit {
+			return false
+		}
+	}
+	return true
+}
+
+// Filled returns the source whose `size` least significant bytes are still
+// at `offset` from R10, in order and without any of them overwritten, i.e.
+// a load there gets back the value that was spilled.
+func (sm *StackModel) Filled(offset int16, size int) (StackByte, bool) {
+	bytes, err := sm.Load(offset, size)
+	if err != nil || bytes[0].Kind == StackUninit {
+		return StackByte{}, false
+	}
+	for i, b := range bytes {
+		if b.Kind != bytes[0].Kind || b.Source != bytes[0].Source || b.Index != i {
+			return StackByte{}, false
+		}
+	}
+	return bytes[0], true
+}
+
+// IsPointerFill returns true if a load of `size` bytes at `offset` from R10
+// gets back a spilled pointer, which requires the whole pointer to be
+// spilled in a single slot.
+func (sm *StackModel) IsPointerFill(offset int16, size int) bool {
+	b, ok := sm.Filled(offset, size)
+	return ok && b.Kind == StackPointer && size == StackSlotSize && IsAlignedStackAccess(offset, StackSlotSize)
+}
@@ -0,0 +1,142 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStackModelStore(t *testing.T) {
+	tests := []struct {
+		testName string
+		offset   int16
+		size     int
+		wantErr  bool
+	}{
+		{testName: "Last slot", offset: -8, size: 8},
+		{testName: "First byte", offset: -StackSize, size: 1},
+		{testName: "Past R10", offset: -4, size: 8, wantErr: true},
+		{testName: "Below the frame", offset: -StackSize - 8, size: 8, wantErr: true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			sm := &StackModel{}
+			err := sm.Store(tc.offset, tc.size, StackScalar, 1)
+			if tc.wantErr {
+				if !errors.Is(err, StackAccessOutOfBounds) {
+					t.Errorf("Store() = %v, want StackAccessOutOfBounds", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Store() = %v, want nil error", err)
+			}
+			if !sm.IsInitialized(tc.offset, tc.size) {
+				t.Errorf("IsInitialized() = false after Store()")
+			}
+		})
+	}
+}
+
+func TestStackModelFill(t *testing.T) {
+	const pointer, scalar = 1, 2
+	tests := []struct {
+		testName        string
+		stores          func(sm *StackModel)
+		offset          int16
+		size            int
+		wantFilled      bool
+		wantPointerFill bool
+	}{
+		{
+			testName:        "Pointer spill",
+			stores:          func(sm *StackModel) { sm.Store(-16, 8, StackPointer, pointer) },
+			offset:          -16,
+			size:            8,
+			wantFilled:      true,
+			wantPointerFill: true,
+		},
+		{
+			testName: "Pointer spill partially overwritten",
+			stores: func(sm *StackModel) {
+				sm.Store(-16, 8, StackPointer, pointer)
+				sm.Store(-12, 1, StackScalar, scalar)
+			},
+			offset: -16,
+			size:   8,
+		},
+		{
+			testName:   "Lower half of a pointer",
+			stores:     func(sm *StackModel) { sm.Store(-16, 8, StackPointer, pointer) },
+			offset:     -16,
+			size:       4,
+			wantFilled: true,
+		},
+		{
+			testName: "Upper half of a scalar",
+			stores:   func(sm *StackModel) { sm.Store(-16, 8, StackScalar, scalar) },
+			offset:   -12,
+			size:     4,
+		},
+		{
+			testName:   "Sub-register spill",
+			stores:     func(sm *StackModel) { sm.Store(-12, 4, StackScalar, scalar) },
+			offset:     -12,
+			size:       4,
+			wantFilled: true,
+		},
+		{
+			testName: "Uninitialized",
+			stores:   func(sm *StackModel) {},
+			offset:   -8,
+			size:     8,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			sm := &StackModel{}
+			tc.stores(sm)
+			if _, got := sm.Filled(tc.offset, tc.size); got != tc.wantFilled {
+				t.Errorf("Filled() = %v, want %v", got, tc.wantFilled)
+			}
+			if got := sm.IsPointerFill(tc.offset, tc.size); got != tc.wantPointerFill {
+				t.Errorf("IsPointerFill() = %v, want %v", got, tc.wantPointerFill)
+			}
+		})
+	}
+}
+
+func TestIsAlignedStackAccess(t *testing.T) {
+	tests := []struct {
+		offset int16
+		size   int
+		want   bool
+	}{
+		{offset: -8, size: 8, want: true},
+		{offset: -12, size: 4, want: true},
+		{offset: -12, size: 8, want: false},
+		{offset: -3, size: 2, want: false},
+		{offset: -3, size: 1, want: true},
+	}
+
+	for _, tc := range tests {
+		if got := IsAlignedStackAccess(tc.offset, tc.size); got != tc.want {
+			t.Errorf("IsAlignedStackAccess(%d, %d) = %v, want %v", tc.offset, tc.size, got, tc.want)
+		}
+	}
+}
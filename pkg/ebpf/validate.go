@@ -0,0 +1,187 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+	"math"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// MalformedInstruction is returned by Validate for an instruction that does
+// not encode to what it describes.
+type MalformedInstruction struct {
+	Index int
+
+	// Field is the field of the instruction that is wrong, e.g. "dst_reg".
+	Field string
+
+	Reason string
+}
+
+func (e *MalformedInstruction) Error() string {
+	return fmt.Sprintf("instruction %d: %s %s", e.Index, e.Field, e.Reason)
+}
+
+// fitsBits returns true if `value` only has bits of `mask` set.
+func fitsBits(value int32, mask int32) bool {
+	return value&^mask == 0
+}
+
+// validateOpcode returns the name of the field of the opcode of `insn` that
+// is out of its bits or does not go with the kind of opcode, empty if there
+// is none.
+func validateOpcode(insn *pb.Instruction) (string, string) {
+	switch op := insn.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		class := op.AluOpcode.InstructionClass
+		if class != pb.InsClass_InsClassAlu && class != pb.InsClass_InsClassAlu64 {
+			return "instruction_class", fmt.Sprintf("%v is not an ALU class", class)
+		}
+		if !fitsBits(int32(op.AluOpcode.OperationCode), 0xf0) {
+			return "operation_code", fmt.Sprintf("%#x does not fit in the operation bits", int32(op.AluOpcode.OperationCode))
+		}
+		if !fitsBits(int32(op.AluOpcode.Source), 0x08) {
+			return "source", fmt.Sprintf("%#x does not fit in the source bit", int32(op.AluOpcode.Source))
+		}
+	case *pb.Instruction_JmpOpcode:
+		class := op.JmpOpcode.InstructionClass
+		if class != pb.InsClass_InsClassJmp && class != pb.InsClass_InsClassJmp32 {
+			return "instruction_class", fmt.Sprintf("%v is not a jump class", class)
+		}
+		if !fitsBits(int32(op.JmpOpcode.OperationCode), 0xf0) {
+			return "operation_code", fmt.Sprintf("%#x does not fit in the operation bits", int32(op.JmpOpcode.OperationCode))
+		}
+		if !fitsBits(int32(op.JmpOpcode.Source), 0x08) {
+			return "source", fmt.Sprintf("%#x does not fit in the source bit", int32(op.JmpOpcode.Source))
+		}
+	case *pb.Instruction_MemOpcode:
+		switch op.MemOpcode.InstructionClass {
+		case pb.InsClass_InsClassLd, pb.InsClass_InsClassLdx, pb.InsClass_InsClassSt, pb.InsClass_InsClassStx:
+		default:
+			return "instruction_class", fmt.Sprintf("%v is not a load or store class", op.MemOpcode.InstructionClass)
+		}
+		if !fitsBits(int32(op.MemOpcode.Size), 0x18) {
+			return "size", fmt.Sprintf("%#x does not fit in the size bits", int32(op.MemOpcode.Size))
+		}
+		if !fitsBits(int32(op.MemOpcode.Mode), 0xe0) {
+			return "mode", fmt.Sprintf("%#x does not fit in the mode bits", int32(op.MemOpcode.Mode))
+		}
+	default:
+		return "opcode", "is missing"
+	}
+	return "", ""
+}
+
+// isWideLoad returns true if `insn` is a 64 bit immediate load, which takes
+// two slots.
+func isWideLoad(insn *pb.Instruction) bool {
+	mem := insn.GetMemOpcode()
+	return mem != nil && mem.InstructionClass == pb.InsClass_InsClassLd &&
+		mem.Mode == pb.StLdMode_StLdModeIMM && mem.Size == pb.StLdSize_StLdSizeDW
+}
+
+// validateInstruction returns the problem of the instruction at `index` of
+// `program` whose slots start at `slots`, nil if it has none.
+func validateInstruction(program *pb.Program, slots []int, index int) *MalformedInstruction {
+	insn := program.Instructions[index]
+	malformed := func(field, format string, args ...any) *MalformedInstruction {
+		return &MalformedInstruction{Index: index, Field: field, Reason: fmt.Sprintf(format, args...)}
+	}
+	if field, reason := validateOpcode(insn); field != "" {
+		return malformed(field, "%s", reason)
+	}
+	if insn.DstReg < R0 || insn.DstReg > R10 {
+		return malformed("dst_reg", "r%d does not exist", insn.DstReg)
+	}
+	// Wide loads and calls use the src register to tell what the immediate
+	// is, the values in use all fit in the register range.
+	if insn.SrcReg < R0 || insn.SrcReg > R10 {
+		return malformed("src_reg", "r%d does not exist", insn.SrcReg)
+	}
+
+	// Short jumps that are too far are promoted when the program is
+	// encoded, the offset of the others must fit in 16 bits.
+	if !IsRelativeJump(insn) && (insn.Offset < math.MinInt16 || insn.Offset > math.MaxInt16) {
+		return malformed("offset", "%d does not fit in 16 bits", insn.Offset)
+	}
+
+	pseudo, hasPseudo := insn.PseudoInstruction.(*pb.Instruction_PseudoValue)
+	if isWideLoad(insn) != hasPseudo {
+		if hasPseudo {
+			return malformed("pseudo_value", "is only allowed on 64 bit immediate loads")
+		}
+		return malformed("pseudo_value", "is missing, 64 bit immediate loads take two slots")
+	}
+	if hasPseudo {
+		second := pseudo.PseudoValue
+		mem := second.GetMemOpcode()
+		if mem == nil || mem.InstructionClass != 0 || mem.Size != 0 || mem.Mode != 0 ||
+			second.DstReg != 0 || second.SrcReg != 0 || second.Offset != 0 ||
+			InstructionWidth(second) != 1 {
+			return malformed("pseudo_value", "must be all zeroes but the immediate")
+		}
+	}
+
+	var target int
+	switch {
+	case IsRelativeJump(insn):
+		target = slots[index] + 1 + jumpOffset(insn)
+	case insn.GetJmpOpcode().GetOperationCode() == pb.JmpOperationCode_JmpCALL && insn.SrcReg == PseudoCall:
+		target = slots[index] + 1 + int(insn.Immediate)
+	case isWideLoad(insn) && insn.SrcReg == PseudoFunc:
+		target = slots[index] + 1 + int(insn.Immediate)
+	default:
+		return nil
+	}
+	for _, slot := range slots[:len(program.Instructions)] {
+		if slot == target {
+			return nil
+		}
+	}
+	field := "offset"
+	if !IsRelativeJump(insn) {
+		field = "immediate"
+	}
+	if target < 0 || target >= slots[len(program.Instructions)] {
+		return malformed(field, "lands on slot %d, outside of the program", target)
+	}
+	return malformed(field, "lands on slot %d, in the middle of a 64 bit immediate load", target)
+}
+
+// Validate checks that `program` encodes to the instructions it describes
+// and returns a *MalformedInstruction for the first one that does not:
+// fields that do not fit their bits or do not go with the opcode, registers
+// that do not exist, broken 64 bit immediate loads and jumps, local calls
+// and function loads that do not land on an instruction. Strategies make
+// such mistakes silently, the encoder would emit corrupt bytecode for them.
+func Validate(program *pb.Program) error {
+	if len(program.GetInstructions()) == 0 {
+		return fmt.Errorf("the program has no instructions")
+	}
+	for i, insn := range program.Instructions {
+		if insn == nil {
+			return &MalformedInstruction{Index: i, Field: "instruction", Reason: "is nil"}
+		}
+	}
+	slots := instructionSlots(program)
+	for i := range program.Instructions {
+		if err := validateInstruction(program, slots, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"errors"
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestValidate(t *testing.T) {
+	wideWithoutPseudo := LdMapByFd(R1, 3)
+	wideWithoutPseudo.PseudoInstruction = &pb.Instruction_Empty{Empty: &pb.Empty{}}
+	brokenSecondHalf := LdMapByFd(R1, 3)
+	brokenSecondHalf.GetPseudoValue().DstReg = R1
+	narrowWithPseudo := Mov64(R0, 0)
+	narrowWithPseudo.PseudoInstruction = LdMapByFd(R1, 3).PseudoInstruction
+	badDst := Mov64(R0, 0)
+	badDst.DstReg = 11
+	badSrc := Mov64(R0, R1)
+	badSrc.SrcReg = 12
+	farStore := StDW(R10, R1, -8)
+	farStore.Offset = 40000
+	aluInJmpClass := Mov64(R0, 0)
+	aluInJmpClass.GetAluOpcode().InstructionClass = pb.InsClass_InsClassJmp
+	badMode := LdDW(R0, R10, -8)
+	badMode.GetMemOpcode().Mode = 0x21
+	noOpcode := Mov64(R0, 0)
+	noOpcode.Opcode = nil
+
+	tests := []struct {
+		testName  string
+		program   []*pb.Instruction
+		wantIndex int
+		wantField string
+	}{
+		{
+			testName:  "Valid program",
+			program:   []*pb.Instruction{LdMapByFd(R1, 3), JmpEQ(R1, 0, 2), LdFunc(R2, 3), CallLocal(2), Mov64(R0, 0), Exit(), Mov64(R0, 0), Exit()},
+			wantIndex: -1,
+		},
+		{"Nil instruction", []*pb.Instruction{nil, Exit()}, 0, "instruction"},
+		{"Missing opcode", []*pb.Instruction{noOpcode, Exit()}, 0, "opcode"},
+		{"ALU opcode in a jump class", []*pb.Instruction{aluInJmpClass, Exit()}, 0, "instruction_class"},
+		{"Mode out of its bits", []*pb.Instruction{badMode, Exit()}, 0, "mode"},
+		{"Dst register out of range", []*pb.Instruction{Mov64(R1, 0), badDst, Exit()}, 1, "dst_reg"},
+		{"Src register out of range", []*pb.Instruction{badSrc, Exit()}, 0, "src_reg"},
+		{"Store offset out of 16 bits", []*pb.Instruction{farStore, Exit()}, 0, "offset"},
+		{"Wide load without its second half", []*pb.Instruction{wideWithoutPseudo, Exit()}, 0, "pseudo_value"},
+		{"Second half with a register", []*pb.Instruction{brokenSecondHalf, Exit()}, 0, "pseudo_value"},
+		{"Second half on a narrow instruction", []*pb.Instruction{narrowWithPseudo, Exit()}, 0, "pseudo_value"},
+		{"Jump past the end", []*pb.Instruction{JmpEQ(R0, 0, 1), Exit()}, 0, "offset"},
+		{"Jump before the start", []*pb.Instruction{Mov64(R0, 0), Jmp(-3), Exit()}, 1, "offset"},
+		{"Jump into a wide load", []*pb.Instruction{Jmp(1), LdMapByFd(R1, 3), Exit()}, 0, "offset"},
+		{"Local call past the end", []*pb.Instruction{CallLocal(1), Exit()}, 0, "immediate"},
+		{"Function load into itself", []*pb.Instruction{LdFunc(R2, 0), Exit()}, 0, "immediate"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			err := Validate(&pb.Program{Instructions: tc.program})
+			if tc.wantIndex < 0 {
+				if err != nil {
+					t.Errorf("Validate() = %v, want nil", err)
+				}
+				return
+			}
+			var malformed *MalformedInstruction
+			if !errors.As(err, &malformed) {
+				t.Fatalf("Validate() = %v, want a MalformedInstruction", err)
+			}
+			if malformed.Index != tc.wantIndex || malformed.Field != tc.wantField {
+				t.Errorf("Validate() = %v, want a problem with the %s of instruction %d", err, tc.wantField, tc.wantIndex)
+			}
+		})
+	}
+
+	if err := Validate(&pb.Program{}); err == nil {
+		t.Errorf("Validate() of an empty program = nil, want an error")
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+// ValueTraceSlotSize is how many bytes of the trace map every trace point
+// takes: the value of its register, then a word set to 1 once the point was
+// reached.
+const ValueTraceSlotSize = 16
+
+// ValueTracePoint is an instruction whose destination register is recorded
+// by the value trace, see InstrumentValueTrace.
+type ValueTracePoint struct {
+	// Index is the index of the instruction in the program that was
+	// instrumented.
+	Index int
+	Reg   pb.Reg
+}
+
+// writtenRegister returns the register the instruction `insn` leaves a new
+// value in, false if it does not write one or if it is an atomic operation,
+// whose fetched value is not worth tracing.
+func writtenRegister(insn *pb.Instruction) (pb.Reg, bool) {
+	switch op := insn.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		return insn.DstReg, true
+	case *pb.Instruction_JmpOpcode:
+		return R0, op.JmpOpcode.OperationCode == pb.JmpOperationCode_JmpCALL
+	case *pb.Instruction_MemOpcode:
+		switch op.MemOpcode.InstructionClass {
+		case pb.InsClass_InsClassLdx:
+			return insn.DstReg, true
+		case pb.InsClass_InsClassLd:
+			if op.MemOpcode.Mode == pb.StLdMode_StLdModeABS || op.MemOpcode.Mode == pb.StLdMode_StLdModeIND {
+				return R0, true
+			}
+			return insn.DstReg, true
+		}
+	}
+	return 0, false
+}
+
+// ValueTracePoints returns a trace point for every instruction of `program`
+// that writes a register.
+func ValueTracePoints(program *pb.Program) []ValueTracePoint {
+	var points []ValueTracePoint
+	for i, insn := range program.Instructions {
+		if reg, ok := writtenRegister(insn); ok {
+			points = append(points, ValueTracePoint{Index: i, Reg: reg})
+		}
+	}
+	return points
+}
+
+// HelperCallPoints returns a trace point for every helper call of `program`,
+// leaving out the calls to the functions of the program and to kfuncs. The
+// traced value is what the helper returned, the point is only reached once
+// the helper did.
+func HelperCallPoints(program *pb.Program) []ValueTracePoint {
+	var points []ValueTracePoint
+	for i, insn := range program.Instructions {
+		if insn.GetJmpOpcode().GetOperationCode() == pb.JmpOperationCode_JmpCALL && insn.SrcReg == R0 {
+			points = append(points, ValueTracePoint{Index: i, Reg: R0})
+		}
+	}
+	return points
+}
+
+// unusedRegister returns a register among R1-R9 that no instruction of
+// `program` reads or writes, false if the program uses all of them.
+func unusedRegister(program *pb.Program) (pb.Reg, bool) {
+	var used [R10 + 1]bool
+	for _, insn := range program.Instructions {
+		for _, reg := range []pb.Reg{insn.DstReg, insn.SrcReg} {
+			if reg <= R10 {
+				used[reg] = true
+			}
+		}
+	}
+	for reg := R9; reg >= R1; reg-- {
+		if !used[reg] {
+			return reg, true
+		}
+	}
+	return 0, false
+}
+
+// InstrumentValueTrace returns a copy of `program` where the instruction of
+// every point of `points` is followed by a store of its register to the
+// trace map `mapFd`, a BPF_MAP_TYPE_ARRAY with a single element of
+// len(points) * ValueTraceSlotSize bytes. Point i stores to the slot at
+// i * ValueTraceSlotSize:
+//
+//	rS = map[fd:mapFd][0]+slot
+//	*(u64 *)(rS +0) = reg
+//	*(u64 *)(rS +8) = 1
+//
+// rS is a register the program does not use, which leaves the state the
+// verifier tracks for the original registers untouched. Jumps keep landing
+// on the same instructions, so the stores only run when the instruction they
+// trace did. The register of a point executed several times, e.g. in a loop,
+// holds its last value.
+func InstrumentValueTrace(program *pb.Program, mapFd int, points []ValueTracePoint) (*pb.Program, error) {
+	scratch, ok := unusedRegister(program)
+	if !ok {
+		return nil, fmt.Errorf("the program uses every register, none is left to address the trace map")
+	}
+	for i := 1; i < len(points); i++ {
+		if points[i].Index <= points[i-1].Index {
+			return nil, fmt.Errorf("trace points must be sorted by instruction and unique")
+		}
+	}
+
+	// Instrumenting from the end keeps the indexes of the points still
+	// pending valid.
+	result := program
+	for i := len(points) - 1; i >= 0; i-- {
+		point := points[i]
+		if point.Index < 0 || point.Index >= len(program.Instructions) {
+			return nil, fmt.Errorf("trace point %d out of range", point.Index)
+		}
+		slot := int32(i * ValueTraceSlotSize)
+		var err error
+		result, err = ReplaceInstruction(result, point.Index,
+			program.Instructions[point.Index],
+			LdMapValue(scratch, mapFd, slot),
+			StDW(scratch, point.Reg, 0),
+			StDW(scratch, 1, 8),
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,122 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"testing"
+
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestValueTracePoints(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{
+		Mov64(R1, 1),
+		StDW(R10, R1, -8),
+		LdDW(R2, R10, -8),
+		JmpEQ(R2, 0, 1),
+		Call(MapLookup),
+		LdAbsH(12),
+		Exit(),
+	}}
+	want := []ValueTracePoint{{0, R1}, {2, R2}, {4, R0}, {5, R0}}
+	got := ValueTracePoints(program)
+	if len(got) != len(want) {
+		t.Fatalf("ValueTracePoints() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ValueTracePoints()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestHelperCallPoints(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{
+		Call(GetPrandomU32),
+		CallLocal(2),
+		Mov64(R0, 0),
+		Exit(),
+		Call(KtimeGetNs),
+		Exit(),
+	}}
+	want := []ValueTracePoint{{0, R0}, {4, R0}}
+	got := HelperCallPoints(program)
+	if len(got) != len(want) {
+		t.Fatalf("HelperCallPoints() = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("HelperCallPoints()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestInstrumentValueTrace(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{
+		Mov64(R0, 0),
+		JmpEQ(R1, 0, 1),
+		Mov64(R0, 1),
+		Exit(),
+	}}
+	points := []ValueTracePoint{{0, R0}, {2, R0}}
+	got, err := InstrumentValueTrace(program, 5, points)
+	if err != nil {
+		t.Fatalf("InstrumentValueTrace() = %v", err)
+	}
+	want := []*pb.Instruction{
+		Mov64(R0, 0),
+		LdMapValue(R9, 5, 0),
+		StDW(R9, R0, 0),
+		StDW(R9, 1, 8),
+		// Taken, the jump skips the second point and its store.
+		JmpEQ(R1, 0, 5),
+		Mov64(R0, 1),
+		LdMapValue(R9, 5, ValueTraceSlotSize),
+		StDW(R9, R0, 0),
+		StDW(R9, 1, 8),
+		Exit(),
+	}
+	if len(got.Instructions) != len(want) {
+		t.Fatalf("InstrumentValueTrace() has %d instructions, want %d", len(got.Instructions), len(want))
+	}
+	for i := range want {
+		if !protobuf.Equal(got.Instructions[i], want[i]) {
+			t.Errorf("instruction %d = %v, want %v", i, got.Instructions[i], want[i])
+		}
+	}
+	if !protobuf.Equal(program.Instructions[1], JmpEQ(R1, 0, 1)) {
+		t.Errorf("InstrumentValueTrace() modified the original program")
+	}
+}
+
+func TestInstrumentValueTraceErrors(t *testing.T) {
+	var allRegisters []*pb.Instruction
+	for reg := R0; reg <= R9; reg++ {
+		allRegisters = append(allRegisters, Mov64(reg, 0))
+	}
+	allRegisters = append(allRegisters, Exit())
+	if _, err := InstrumentValueTrace(&pb.Program{Instructions: allRegisters}, 5, []ValueTracePoint{{0, R0}}); err == nil {
+		t.Errorf("InstrumentValueTrace() of a program using every register did not return an error")
+	}
+
+	program := &pb.Program{Instructions: []*pb.Instruction{Mov64(R0, 0), Mov64(R0, 1), Exit()}}
+	if _, err := InstrumentValueTrace(program, 5, []ValueTracePoint{{1, R0}, {0, R0}}); err == nil {
+		t.Errorf("InstrumentValueTrace() with unsorted points did not return an error")
+	}
+	if _, err := InstrumentValueTrace(program, 5, []ValueTracePoint{{3, R0}}); err == nil {
+		t.Errorf("InstrumentValueTrace() with a point out of range did not return an error")
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"fmt"
+	"os"
+
+	"buzzer/pkg/rand"
+	cfgpb "buzzer/proto/config_go_proto"
+	pb "buzzer/proto/ebpf_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+// Weights are the relative weights of the choices the random instruction
+// generators make, see FuzzConfig in proto/config.proto. A nil *Weights
+// makes every choice uniformly.
+type Weights struct {
+	classes    map[pb.InsClass]uint32
+	helpers    map[int32]uint32
+	registers  map[pb.Reg]uint32
+	immediates []*cfgpb.ImmediateRange
+}
+
+// NewWeights validates `cfg` and returns its weights.
+func NewWeights(cfg *cfgpb.FuzzConfig) (*Weights, error) {
+	w := &Weights{
+		classes:   make(map[pb.InsClass]uint32),
+		helpers:   make(map[int32]uint32),
+		registers: make(map[pb.Reg]uint32),
+	}
+	for _, c := range cfg.GetInstructionClasses() {
+		if c.GetClass() == pb.InsClass_InsClassLd {
+			return nil, fmt.Errorf("wide loads are not generated at random and cannot be weighted")
+		}
+		w.classes[c.GetClass()] = c.GetWeight()
+	}
+	if w.class(pb.InsClass_InsClassAlu)+w.class(pb.InsClass_InsClassAlu64) == 0 {
+		return nil, fmt.Errorf("at least one ALU class must have a weight, random programs are padded with ALU instructions")
+	}
+	for _, h := range cfg.GetHelpers() {
+		if HelperPrototypeByID(h.GetId()) == nil {
+			return nil, fmt.Errorf("unknown helper %d", h.GetId())
+		}
+		w.helpers[h.GetId()] = h.GetWeight()
+	}
+	for _, r := range cfg.GetRegisters() {
+		if r.GetReg() > R9 {
+			return nil, fmt.Errorf("%v cannot be weighted, only R0 to R9 are picked at random", r.GetReg())
+		}
+		w.registers[r.GetReg()] = r.GetWeight()
+	}
+	total := uint32(0)
+	for reg := R0; reg <= R9; reg++ {
+		total += w.register(reg)
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("at least one register must have a weight")
+	}
+	for _, r := range cfg.GetImmediates() {
+		if r.GetMin() > r.GetMax() {
+			return nil, fmt.Errorf("immediate range [%d, %d] is empty", r.GetMin(), r.GetMax())
+		}
+		if r.GetWeight() != 0 {
+			w.immediates = append(w.immediates, r)
+		}
+	}
+	if len(cfg.GetImmediates()) != 0 && len(w.immediates) == 0 {
+		return nil, fmt.Errorf("at least one immediate range must have a weight")
+	}
+	return w, nil
+}
+
+// LoadWeights reads a FuzzConfig in text format from `path` and returns its
+// weights.
+func LoadWeights(path string) (*Weights, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &cfgpb.FuzzConfig{}
+	if err := proto.UnmarshalText(string(data), cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	return NewWeights(cfg)
+}
+
+// weightOf returns the weight of `key` in `m`, entries that are not listed
+// weigh 1.
+func weightOf[K comparable](m map[K]uint32, key K) uint32 {
+	if weight, ok := m[key]; ok {
+		return weight
+	}
+	return 1
+}
+
+func (w *Weights) class(c pb.InsClass) uint32 {
+	return weightOf(w.classes, c)
+}
+
+func (w *Weights) helper(id int32) uint32 {
+	return weightOf(w.helpers, id)
+}
+
+func (w *Weights) register(r pb.Reg) uint32 {
+	return weightOf(w.registers, r)
+}
+
+// weightedIndex returns an index of `weights` with a probability
+// proportional to its weight, or -1 if they are all 0.
+func weightedIndex(weights []uint32) int {
+	total := uint64(0)
+	for _, weight := range weights {
+		total += uint64(weight)
+	}
+	if total == 0 {
+		return -1
+	}
+	v := rand.SharedRNG.RandRange(0, total-1)
+	for i, weight := range weights {
+		if v < uint64(weight) {
+			return i
+		}
+		v -= uint64(weight)
+	}
+	return -1
+}
+
+// activeWeights are the weights the random instruction generators follow.
+var activeWeights *Weights
+
+// SetWeights makes the random instruction generators, e.g.
+// RandomAluInstruction, follow `w`. nil restores the uniform choices.
+func SetWeights(w *Weights) {
+	activeWeights = w
+}
+
+// pickClass returns one of `classes` according to the active weights, or
+// uniformly if there are none. The weights of `classes` can't all be 0.
+func pickClass(classes ...pb.InsClass) pb.InsClass {
+	if activeWeights == nil {
+		return classes[rand.SharedRNG.RandRange(0, uint64(len(classes)-1))]
+	}
+	weights := make([]uint32, len(classes))
+	for i, c := range classes {
+		weights[i] = activeWeights.class(c)
+	}
+	if i := weightedIndex(weights); i >= 0 {
+		return classes[i]
+	}
+	return classes[0]
+}
+
+// RandomInstructionClass returns the class of the next random instruction
+// of a program: InsClassAlu for RandomAluInstruction, InsClassJmp for
+// RandomJmpInstruction or InsClassLdx for RandomMemInstruction, each one
+// weighing as much as the classes it can generate.
+func RandomInstructionClass() pb.InsClass {
+	if activeWeights == nil {
+		return []pb.InsClass{
+			pb.InsClass_InsClassAlu,
+			pb.InsClass_InsClassJmp,
+			pb.InsClass_InsClassLdx,
+		}[rand.SharedRNG.RandInt()%3]
+	}
+	w := activeWeights
+	weights := []uint32{
+		w.class(pb.InsClass_InsClassAlu) + w.class(pb.InsClass_InsClassAlu64),
+		w.class(pb.InsClass_InsClassJmp) + w.class(pb.InsClass_InsClassJmp32),
+		w.class(pb.InsClass_InsClassLdx) + w.class(pb.InsClass_InsClassSt) + w.class(pb.InsClass_InsClassStx),
+	}
+	switch weightedIndex(weights) {
+	case 1:
+		return pb.InsClass_InsClassJmp
+	case 2:
+		return pb.InsClass_InsClassLdx
+	default:
+		return pb.InsClass_InsClassAlu
+	}
+}
+
+// favoredHelper, when set, returns true for the helpers the random helper
+// calls should favor, see FavorHelpers.
+var favoredHelper func(id int32) bool
+
+// FavorHelpers makes the random helper calls pick one of the helpers
+// `favored` returns true for 3 times out of 4, when one of them can be
+// called at all, e.g. to target the helpers that were never called so far.
+// Weights still apply among them, the helpers that weigh 0 are never
+// picked. nil restores the usual choices.
+func FavorHelpers(favored func(id int32) bool) {
+	favoredHelper = favored
+}
+
+// pickHelper returns one of `candidates` according to the active weights,
+// or uniformly if there are none or they all weigh 0. See FavorHelpers for
+// how some of them are favored.
+func pickHelper(candidates []*HelperPrototype) *HelperPrototype {
+	if favoredHelper != nil && !rand.SharedRNG.OneOf(4) {
+		var favored []*HelperPrototype
+		for _, hp := range candidates {
+			if favoredHelper(hp.ID) && (activeWeights == nil || activeWeights.helper(hp.ID) != 0) {
+				favored = append(favored, hp)
+			}
+		}
+		if len(favored) != 0 {
+			candidates = favored
+		}
+	}
+	if activeWeights != nil {
+		weights := make([]uint32, len(candidates))
+		for i, hp := range candidates {
+			weights[i] = activeWeights.helper(hp.ID)
+		}
+		if i := weightedIndex(weights); i >= 0 {
+			return candidates[i]
+		}
+	}
+	return candidates[rand.SharedRNG.RandRange(0, uint64(len(candidates)-1))]
+}
+
+// randomImmediate returns an immediate drawn from the ranges of the active
+// weights, or `fallback` if there are none.
+func randomImmediate(fallback uint64) int32 {
+	if activeWeights == nil || len(activeWeights.immediates) == 0 {
+		return int32(fallback)
+	}
+	weights := make([]uint32, len(activeWeights.immediates))
+	for i, r := range activeWeights.immediates {
+		weights[i] = r.GetWeight()
+	}
+	r := activeWeights.immediates[weightedIndex(weights)]
+	return int32(int64(r.GetMin()) + int64(rand.SharedRNG.RandRange(0, uint64(int64(r.GetMax())-int64(r.GetMin())))))
+}
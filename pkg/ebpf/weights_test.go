@@ -0,0 +1,196 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ebpf
+
+import (
+	"strings"
+	"testing"
+
+	cfgpb "buzzer/proto/config_go_proto"
+	pb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestNewWeights(t *testing.T) {
+	zeroRegisters := []*cfgpb.RegisterWeight{}
+	for reg := R0; reg <= R9; reg++ {
+		zeroRegisters = append(zeroRegisters, &cfgpb.RegisterWeight{Reg: reg})
+	}
+	tests := []struct {
+		testName string
+		cfg      *cfgpb.FuzzConfig
+		wantErr  string
+	}{
+		{
+			testName: "Empty config",
+			cfg:      &cfgpb.FuzzConfig{},
+		},
+		{
+			testName: "Wide loads",
+			cfg: &cfgpb.FuzzConfig{InstructionClasses: []*cfgpb.InstructionClassWeight{
+				{Class: pb.InsClass_InsClassLd, Weight: 1},
+			}},
+			wantErr: "wide loads",
+		},
+		{
+			testName: "No ALU instructions",
+			cfg: &cfgpb.FuzzConfig{InstructionClasses: []*cfgpb.InstructionClassWeight{
+				{Class: pb.InsClass_InsClassAlu},
+				{Class: pb.InsClass_InsClassAlu64},
+			}},
+			wantErr: "ALU class",
+		},
+		{
+			testName: "Unknown helper",
+			cfg:      &cfgpb.FuzzConfig{Helpers: []*cfgpb.HelperWeight{{Id: 100000, Weight: 1}}},
+			wantErr:  "unknown helper",
+		},
+		{
+			testName: "Frame pointer",
+			cfg:      &cfgpb.FuzzConfig{Registers: []*cfgpb.RegisterWeight{{Reg: R10, Weight: 1}}},
+			wantErr:  "R10",
+		},
+		{
+			testName: "No registers",
+			cfg:      &cfgpb.FuzzConfig{Registers: zeroRegisters},
+			wantErr:  "register",
+		},
+		{
+			testName: "Empty immediate range",
+			cfg:      &cfgpb.FuzzConfig{Immediates: []*cfgpb.ImmediateRange{{Min: 1, Max: 0, Weight: 1}}},
+			wantErr:  "empty",
+		},
+		{
+			testName: "No immediate range weighs anything",
+			cfg:      &cfgpb.FuzzConfig{Immediates: []*cfgpb.ImmediateRange{{Min: 0, Max: 1}}},
+			wantErr:  "immediate range",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			_, err := NewWeights(tc.cfg)
+			if tc.wantErr == "" && err != nil {
+				t.Fatalf("NewWeights() = %v, want nil error", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("NewWeights() = %v, want an error containing %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadWeights(t *testing.T) {
+	w, err := LoadWeights("testdata/fuzz_config.textproto")
+	if err != nil {
+		t.Fatalf("LoadWeights() = %v, want nil error", err)
+	}
+	if got := w.class(pb.InsClass_InsClassJmp); got != 4 {
+		t.Errorf("weight of InsClassJmp = %d, want 4", got)
+	}
+	if got := w.class(pb.InsClass_InsClassAlu); got != 1 {
+		t.Errorf("weight of InsClassAlu = %d, want 1", got)
+	}
+	if got := w.helper(MapLookup); got != 10 {
+		t.Errorf("weight of helper %d = %d, want 10", MapLookup, got)
+	}
+	if got := w.register(R0); got != 0 {
+		t.Errorf("weight of R0 = %d, want 0", got)
+	}
+	if got := len(w.immediates); got != 2 {
+		t.Errorf("immediate ranges = %d, want 2", got)
+	}
+}
+
+func TestWeightedGenerators(t *testing.T) {
+	cfg := &cfgpb.FuzzConfig{
+		InstructionClasses: []*cfgpb.InstructionClassWeight{
+			{Class: pb.InsClass_InsClassAlu},
+			{Class: pb.InsClass_InsClassJmp},
+			{Class: pb.InsClass_InsClassJmp32},
+			{Class: pb.InsClass_InsClassLdx},
+			{Class: pb.InsClass_InsClassSt},
+		},
+		Immediates: []*cfgpb.ImmediateRange{{Min: -3, Max: -3, Weight: 1}},
+	}
+	for reg := R0; reg <= R9; reg++ {
+		weight := uint32(0)
+		if reg == R3 {
+			weight = 1
+		}
+		cfg.Registers = append(cfg.Registers, &cfgpb.RegisterWeight{Reg: reg, Weight: weight})
+	}
+	w, err := NewWeights(cfg)
+	if err != nil {
+		t.Fatalf("NewWeights() = %v, want nil error", err)
+	}
+	SetWeights(w)
+	defer SetWeights(nil)
+
+	for i := 0; i < 100; i++ {
+		if got := RandomInstructionClass(); got == pb.InsClass_InsClassJmp {
+			t.Fatalf("RandomInstructionClass() = %v, want no jumps", got)
+		}
+		if got := RandomRegister(); got != R3 {
+			t.Fatalf("RandomRegister() = %v, want R3", got)
+		}
+		if got := randomImmediate(0); got != -3 {
+			t.Fatalf("randomImmediate() = %d, want -3", got)
+		}
+		insn := RandomAluInstruction()
+		if got := insn.GetAluOpcode().GetInstructionClass(); got != pb.InsClass_InsClassAlu64 {
+			t.Fatalf("class of %v = %v, want InsClassAlu64", insn, got)
+		}
+		// Only stores of a register and atomic operations are left.
+		insn = RandomMemInstruction()
+		if got := insn.GetMemOpcode().GetInstructionClass(); got != pb.InsClass_InsClassStx {
+			t.Fatalf("class of %v = %v, want InsClassStx", insn, got)
+		}
+	}
+}
+
+func TestFavorHelpers(t *testing.T) {
+	candidates := []*HelperPrototype{
+		HelperPrototypeByID(MapLookup),
+		HelperPrototypeByID(KtimeGetNs),
+		HelperPrototypeByID(GetPrandomU32),
+	}
+	FavorHelpers(func(id int32) bool { return id == KtimeGetNs })
+	defer FavorHelpers(nil)
+
+	favored := 0
+	for i := 0; i < 1000; i++ {
+		if pickHelper(candidates).ID == KtimeGetNs {
+			favored++
+		}
+	}
+	// 3 out of 4 picks are among the favored helpers, the rest are
+	// uniform.
+	if favored < 750 || favored > 900 {
+		t.Errorf("ktime_get_ns picked %d times out of 1000, want about 833", favored)
+	}
+
+	// A favored helper that weighs 0 is still never picked.
+	w, err := NewWeights(&cfgpb.FuzzConfig{Helpers: []*cfgpb.HelperWeight{{Id: KtimeGetNs, Weight: 0}}})
+	if err != nil {
+		t.Fatalf("NewWeights() = %v, want nil error", err)
+	}
+	SetWeights(w)
+	defer SetWeights(nil)
+	for i := 0; i < 100; i++ {
+		if got := pickHelper(candidates); got.ID == KtimeGetNs {
+			t.Fatalf("pickHelper() = %s, want a helper that weighs more than 0", got.Name)
+		}
+	}
+}
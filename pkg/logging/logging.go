@@ -0,0 +1,186 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package logging filters and routes the output of the fuzzer by level, so
+// that campaigns running thousands of programs a second only print what
+// matters on the console and can keep the full output in a rotating file.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level tells how important a line of output is.
+type Level int
+
+const (
+	// Debug lines are the progress lines strategies rewrite in place.
+	Debug Level = iota
+	// Info lines are the messages about every program, e.g. why it was
+	// dropped.
+	Info
+	// Warning lines are the errors the fuzzer recovers from.
+	Warning
+	// Report lines are the findings and the periodic stats, they are
+	// always printed and never rate limited.
+	Report
+)
+
+var levelNames = []string{"debug", "info", "warning", "report"}
+
+func (l Level) String() string {
+	if l < Debug || l > Report {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level called `name`, one of debug, info, warning
+// and report.
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return Debug, fmt.Errorf("unknown log level %q, must be one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// Logger prints the lines of at least Level on the console, at most Rate of
+// them a second, and writes every line to File.
+type Logger struct {
+	// Level is the lowest level printed on the console.
+	Level Level
+
+	// Rate is how many lines below Report are printed on the console every
+	// second, the rest are dropped and counted. 0 for no limit.
+	Rate int
+
+	// File receives every line, timestamped and tagged with its level,
+	// regardless of Level and Rate. Nil if the output is not logged to a
+	// file.
+	File *RotatingFile
+
+	console io.Writer
+	now     func() time.Time
+
+	mu          sync.Mutex
+	windowStart time.Time
+	printed     int
+	dropped     int
+}
+
+// NewLogger returns a logger printing on `console`.
+func NewLogger(console io.Writer, level Level, rate int, file *RotatingFile) *Logger {
+	return &Logger{
+		Level:   level,
+		Rate:    rate,
+		File:    file,
+		console: console,
+		now:     time.Now,
+	}
+}
+
+// Log prints `text`, one or more lines, at `level`. Lines ending with a
+// carriage return instead of a newline are printed as is on the console, so
+// they keep being rewritten in place.
+func (l *Logger) Log(level Level, text string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if l.File != nil {
+		l.writeFile(now, level, text)
+	}
+	if level < l.Level || !l.allow(now, level) {
+		return
+	}
+	io.WriteString(l.console, text)
+}
+
+// allow returns true if a line at `level` fits in the console rate limit.
+// It starts a new one second window when the current one is over, telling
+// how many lines the previous one dropped.
+func (l *Logger) allow(now time.Time, level Level) bool {
+	if l.Rate <= 0 {
+		return true
+	}
+	if now.Sub(l.windowStart) >= time.Second {
+		if l.dropped > 0 {
+			fmt.Fprintf(l.console, "Dropped %d lines over the log rate of %d a second\n", l.dropped, l.Rate)
+		}
+		l.windowStart = now
+		l.printed = 0
+		l.dropped = 0
+	}
+	if level == Report {
+		return true
+	}
+	if l.printed >= l.Rate {
+		l.dropped++
+		return false
+	}
+	l.printed++
+	return true
+}
+
+// writeFile writes every line of `text` to the log file, prefixed with
+// `now` and `level`.
+func (l *Logger) writeFile(now time.Time, level Level, text string) {
+	var b strings.Builder
+	stamp := now.UTC().Format("2006-01-02T15:04:05.000Z")
+	for _, line := range strings.FieldsFunc(text, func(r rune) bool { return r == '\n' || r == '\r' }) {
+		fmt.Fprintf(&b, "%s %-7s %s\n", stamp, strings.ToUpper(level.String()), strings.TrimRight(line, " "))
+	}
+	if _, err := io.WriteString(l.File, b.String()); err != nil {
+		fmt.Fprintf(l.console, "Log file error: %v\n", err)
+	}
+}
+
+var defaultLogger atomic.Pointer[Logger]
+
+func init() {
+	defaultLogger.Store(NewLogger(os.Stdout, Debug, 0, nil))
+}
+
+// SetDefault makes `l` the logger of the package level functions.
+func SetDefault(l *Logger) {
+	defaultLogger.Store(l)
+}
+
+// Debugf prints a progress line with the default logger, end it with a
+// carriage return to have it rewritten in place.
+func Debugf(format string, args ...any) {
+	defaultLogger.Load().Log(Debug, fmt.Sprintf(format, args...))
+}
+
+// Infof prints a message about a program with the default logger.
+func Infof(format string, args ...any) {
+	defaultLogger.Load().Log(Info, fmt.Sprintf(format, args...))
+}
+
+// Warningf prints an error the fuzzer recovers from with the default logger.
+func Warningf(format string, args ...any) {
+	defaultLogger.Load().Log(Warning, fmt.Sprintf(format, args...))
+}
+
+// Reportf prints a finding or stats line with the default logger.
+func Reportf(format string, args ...any) {
+	defaultLogger.Load().Log(Report, fmt.Sprintf(format, args...))
+}
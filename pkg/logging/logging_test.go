@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	for _, level := range []Level{Debug, Info, Warning, Report} {
+		got, err := ParseLevel(strings.ToUpper(level.String()))
+		if err != nil || got != level {
+			t.Errorf("ParseLevel(%q) = %v, %v, want %v", level, got, err, level)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("ParseLevel(verbose) succeeded, want an error")
+	}
+}
+
+func TestLoggerLevel(t *testing.T) {
+	var console strings.Builder
+	l := NewLogger(&console, Warning, 0, nil)
+	l.Log(Debug, "Generated 10 programs\r")
+	l.Log(Info, "Dropping program\n")
+	l.Log(Warning, "Validation error: EINVAL\n")
+	l.Log(Report, "Finding\n")
+	if want := "Validation error: EINVAL\nFinding\n"; console.String() != want {
+		t.Errorf("console = %q, want %q", console.String(), want)
+	}
+}
+
+func TestLoggerRate(t *testing.T) {
+	var console strings.Builder
+	l := NewLogger(&console, Debug, 2, nil)
+	now := time.Unix(1000, 0)
+	l.now = func() time.Time { return now }
+	for i := 0; i < 5; i++ {
+		l.Log(Info, "line\n")
+	}
+	l.Log(Report, "Finding\n")
+	now = now.Add(time.Second)
+	l.Log(Info, "next\n")
+	want := "line\nline\nFinding\nDropped 3 lines over the log rate of 2 a second\nnext\n"
+	if console.String() != want {
+		t.Errorf("console = %q, want %q", console.String(), want)
+	}
+}
+
+func TestLoggerFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.log")
+	file, err := OpenRotatingFile(path, 0, 0)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error: %v", err)
+	}
+	var console strings.Builder
+	l := NewLogger(&console, Report, 1, file)
+	l.now = func() time.Time { return time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC) }
+	l.Log(Debug, "Generated 10 programs   \r")
+	l.Log(Info, "Dropping program\n")
+	l.Log(Info, "Dropping program\n")
+	if err := file.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if console.String() != "" {
+		t.Errorf("console = %q, want nothing", console.String())
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error: %v", err)
+	}
+	want := "2024-01-02T03:04:05.000Z DEBUG   Generated 10 programs\n" +
+		"2024-01-02T03:04:05.000Z INFO    Dropping program\n" +
+		"2024-01-02T03:04:05.000Z INFO    Dropping program\n"
+	if string(got) != want {
+		t.Errorf("log file = %q, want %q", got, want)
+	}
+}
+
+func TestLeveledFunctions(t *testing.T) {
+	var console strings.Builder
+	SetDefault(NewLogger(&console, Info, 0, nil))
+	defer SetDefault(NewLogger(os.Stdout, Debug, 0, nil))
+	Debugf("Generated %d programs\r", 10)
+	Infof("Dropping program %d\n", 3)
+	Warningf("Validation error: %s\n", "EINVAL")
+	Reportf("Finding\n")
+	if want := "Dropping program 3\nValidation error: EINVAL\nFinding\n"; console.String() != want {
+		t.Errorf("console = %q, want %q", console.String(), want)
+	}
+}
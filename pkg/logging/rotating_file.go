@@ -0,0 +1,112 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// RotatingFile is a log file that is renamed with a .1 suffix once it grows
+// past MaxSize bytes, the previous .1 file becoming .2 and so on, keeping at
+// most MaxFiles of them. It is not safe for concurrent use, Logger
+// serializes its writes.
+type RotatingFile struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+
+	file *os.File
+	size int64
+}
+
+// OpenRotatingFile opens the log file at `path`, appending to it if it
+// exists. A `maxSize` of 0 never rotates it.
+func OpenRotatingFile(path string, maxSize int64, maxFiles int) (*RotatingFile, error) {
+	if maxSize < 0 || maxFiles < 0 {
+		return nil, fmt.Errorf("the maximum size and count of log files can't be negative")
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &RotatingFile{
+		Path:     path,
+		MaxSize:  maxSize,
+		MaxFiles: maxFiles,
+		file:     f,
+		size:     info.Size(),
+	}, nil
+}
+
+// Write appends `p` to the log file, rotating it first if `p` would make it
+// bigger than MaxSize.
+func (rf *RotatingFile) Write(p []byte) (int, error) {
+	if rf.MaxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.MaxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// rotate shifts the rotated files by one, dropping the oldest, and starts a
+// new empty log file.
+func (rf *RotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	if rf.MaxFiles == 0 {
+		if err := os.Remove(rf.Path); err != nil {
+			return err
+		}
+	} else {
+		for i := rf.MaxFiles - 1; i > 0; i-- {
+			err := os.Rename(rf.rotatedPath(i), rf.rotatedPath(i+1))
+			if err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+		if err := os.Rename(rf.Path, rf.rotatedPath(1)); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(rf.Path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	rf.file = f
+	rf.size = 0
+	return nil
+}
+
+// rotatedPath returns the path of the `i`th most recent rotated file.
+func (rf *RotatingFile) rotatedPath(i int) string {
+	return fmt.Sprintf("%s.%d", rf.Path, i)
+}
+
+// Close closes the log file.
+func (rf *RotatingFile) Close() error {
+	return rf.file.Close()
+}
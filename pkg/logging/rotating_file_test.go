@@ -0,0 +1,59 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package logging
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "buzzer.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatalf("WriteFile() error: %v", err)
+	}
+	rf, err := OpenRotatingFile(path, 8, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error: %v", err)
+	}
+	for _, line := range []string{"one\n", "two\n", "three\n", "four\n"} {
+		if _, err := rf.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) error: %v", line, err)
+		}
+	}
+	if err := rf.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	// "old\none\n" fills the first file, then every line starts a new one
+	// and the first file is pushed out by the fourth one.
+	want := map[string]string{
+		path:        "four\n",
+		path + ".1": "three\n",
+		path + ".2": "two\n",
+	}
+	for p, content := range want {
+		got, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error: %v", p, err)
+		}
+		if string(got) != content {
+			t.Errorf("%s = %q, want %q", p, got, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists, want at most 2 rotated files", path)
+	}
+}
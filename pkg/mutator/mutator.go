@@ -0,0 +1,255 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mutator produces variants of existing eBPF programs.
+//
+// Every mutation returns a new program and leaves its input untouched. Jumps
+// keep landing on instructions of the program after any mutation, although
+// that does not mean the verifier will accept the result.
+package mutator
+
+import (
+	"errors"
+
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+
+	"github.com/golang/protobuf/proto"
+)
+
+var (
+	// NoCandidates is returned when a program has no instruction the
+	// requested mutation can be applied to.
+	NoCandidates = errors.New("no instruction can be mutated with this operation")
+)
+
+// Operation is a single kind of mutation.
+type Operation func(program *pb.Program) (*pb.Program, error)
+
+// isPlain returns true for the instructions that can be freely modified:
+// everything except wide instructions, calls and exits.
+func isPlain(i *pb.Instruction) bool {
+	if ebpf.InstructionWidth(i) != 1 {
+		return false
+	}
+	if jmp, ok := i.Opcode.(*pb.Instruction_JmpOpcode); ok {
+		op := jmp.JmpOpcode.OperationCode
+		return op != pb.JmpOperationCode_JmpCALL && op != pb.JmpOperationCode_JmpExit
+	}
+	return true
+}
+
+// usesSrcRegister returns true if the src register of `i` is an operand.
+func usesSrcRegister(i *pb.Instruction) bool {
+	switch op := i.Opcode.(type) {
+	case *pb.Instruction_AluOpcode:
+		return op.AluOpcode.Source == pb.SrcOperand_RegSrc
+	case *pb.Instruction_JmpOpcode:
+		return op.JmpOpcode.Source == pb.SrcOperand_RegSrc
+	case *pb.Instruction_MemOpcode:
+		class := op.MemOpcode.InstructionClass
+		return class == pb.InsClass_InsClassLdx || class == pb.InsClass_InsClassStx
+	}
+	return false
+}
+
+// pickInstruction returns the index of a random instruction of `program` for
+// which `filter` returns true.
+func pickInstruction(program *pb.Program, filter func(*pb.Instruction) bool) (int, error) {
+	candidates := []int{}
+	for i, insn := range program.Instructions {
+		if filter(insn) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		return 0, NoCandidates
+	}
+	return candidates[rand.SharedRNG.RandRange(0, uint64(len(candidates)-1))], nil
+}
+
+// FlipImmediate changes the immediate of a random instruction, either by
+// flipping one of its bits, by nudging it by one or by replacing it with a
+// random value.
+func FlipImmediate(program *pb.Program) (*pb.Program, error) {
+	index, err := pickInstruction(program, func(i *pb.Instruction) bool {
+		return isPlain(i) && !usesSrcRegister(i)
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := proto.Clone(program).(*pb.Program)
+	insn := result.Instructions[index]
+	switch rand.SharedRNG.RandRange(0, 3) {
+	case 0:
+		insn.Immediate ^= 1 << rand.SharedRNG.RandRange(0, 31)
+	case 1:
+		insn.Immediate += 1
+	case 2:
+		insn.Immediate -= 1
+	default:
+		insn.Immediate = int32(rand.SharedRNG.RandInt())
+	}
+	return result, nil
+}
+
+// SwapRegisters swaps the dst and src registers of a random instruction that
+// uses both, or replaces the dst register of an instruction that does not.
+func SwapRegisters(program *pb.Program) (*pb.Program, error) {
+	index, err := pickInstruction(program, isPlain)
+	if err != nil {
+		return nil, err
+	}
+	result := proto.Clone(program).(*pb.Program)
+	insn := result.Instructions[index]
+	if usesSrcRegister(insn) {
+		insn.DstReg, insn.SrcReg = insn.SrcReg, insn.DstReg
+	} else {
+		insn.DstReg = ebpf.RandomRegister()
+	}
+	return result, nil
+}
+
+// ChangeJumpOffset makes a random jump land on a random instruction after
+// it. Only forward targets are picked so no new loops are introduced.
+func ChangeJumpOffset(program *pb.Program) (*pb.Program, error) {
+	last := len(program.Instructions) - 1
+	index, err := pickInstruction(program, ebpf.IsRelativeJump)
+	if err != nil {
+		return nil, err
+	}
+	if index == last {
+		return nil, NoCandidates
+	}
+	result := proto.Clone(program).(*pb.Program)
+	target := int(rand.SharedRNG.RandRange(uint64(index+1), uint64(last)))
+	if err := ebpf.SetJumpTarget(result, index, target); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DuplicateInstruction inserts a copy of a random instruction right after
+// it.
+func DuplicateInstruction(program *pb.Program) (*pb.Program, error) {
+	index, err := pickInstruction(program, isPlain)
+	if err != nil {
+		return nil, err
+	}
+	insn := program.Instructions[index]
+	result, err := ebpf.ReplaceInstruction(program, index, insn, insn)
+	if err != nil {
+		return nil, err
+	}
+
+	// Both copies keep the offset of the original, fix them so they land
+	// on the same instruction it did.
+	if target, ok := ebpf.JumpTarget(program, index); ok {
+		if target > index {
+			target += 1
+		}
+		for _, i := range []int{index, index + 1} {
+			if err := ebpf.SetJumpTarget(result, i, target); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// RemoveInstruction removes a random instruction, programs are never left
+// empty.
+func RemoveInstruction(program *pb.Program) (*pb.Program, error) {
+	if len(program.Instructions) < 2 {
+		return nil, NoCandidates
+	}
+	index, err := pickInstruction(program, isPlain)
+	if err != nil {
+		return nil, err
+	}
+	return ebpf.RemoveInstruction(program, index)
+}
+
+// Splice returns a program made of a random prefix of `a` followed by a
+// random suffix of `b`. Jumps that would land outside of their half are
+// redirected to the first instruction taken from `b`.
+func Splice(a, b *pb.Program) (*pb.Program, error) {
+	if len(a.Instructions) == 0 || len(b.Instructions) == 0 {
+		return nil, NoCandidates
+	}
+	prefixLen := int(rand.SharedRNG.RandRange(0, uint64(len(a.Instructions)-1)))
+	suffixStart := int(rand.SharedRNG.RandRange(0, uint64(len(b.Instructions)-1)))
+
+	// Instruction index each jump should land on in the result.
+	targets := make(map[int]int)
+	result := &pb.Program{}
+	for i, insn := range a.Instructions[:prefixLen] {
+		target, ok := ebpf.JumpTarget(a, i)
+		if ok && target >= prefixLen {
+			target = prefixLen
+		}
+		if ok {
+			targets[i] = target
+		}
+		result.Instructions = append(result.Instructions, proto.Clone(insn).(*pb.Instruction))
+	}
+	for i, insn := range b.Instructions[suffixStart:] {
+		target, ok := ebpf.JumpTarget(b, suffixStart+i)
+		if ok && target < suffixStart {
+			target = suffixStart
+		}
+		if ok {
+			targets[prefixLen+i] = prefixLen + target - suffixStart
+		}
+		result.Instructions = append(result.Instructions, proto.Clone(insn).(*pb.Instruction))
+	}
+
+	for index, target := range targets {
+		if err := ebpf.SetJumpTarget(result, index, target); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+var operations = []Operation{
+	FlipImmediate,
+	SwapRegisters,
+	ChangeJumpOffset,
+	DuplicateInstruction,
+	RemoveInstruction,
+}
+
+// Mutate applies one random mutation to `program`. If `donor` is not nil it
+// can also be spliced with `program`.
+func Mutate(program, donor *pb.Program) (*pb.Program, error) {
+	ops := operations
+	if donor != nil {
+		ops = append(ops[:len(ops):len(ops)], func(p *pb.Program) (*pb.Program, error) {
+			return Splice(p, donor)
+		})
+	}
+
+	// Operations that do not apply to this program are skipped.
+	start := rand.SharedRNG.RandRange(0, uint64(len(ops)-1))
+	for i := range ops {
+		result, err := ops[(int(start)+i)%len(ops)](program)
+		if err == NoCandidates {
+			continue
+		}
+		return result, err
+	}
+	return nil, NoCandidates
+}
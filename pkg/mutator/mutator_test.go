@@ -0,0 +1,135 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mutator
+
+import (
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/rand"
+	pb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func testProgram() *pb.Program {
+	return &pb.Program{Instructions: []*pb.Instruction{
+		ebpf.LdMapByFd(ebpf.R1, 3),
+		ebpf.Mov64(ebpf.R2, 1),
+		ebpf.JmpEQ(ebpf.R2, 0, 2),
+		ebpf.Add64(ebpf.R2, ebpf.R1),
+		ebpf.Sub64(ebpf.R2, 4),
+		ebpf.JmpGT(ebpf.R2, ebpf.R3, 1),
+		ebpf.StDW(ebpf.R10, ebpf.R2, -8),
+		ebpf.Mov64(ebpf.R0, 0),
+		ebpf.Exit(),
+	}}
+}
+
+// checkJumps fails the test if any jump of `program` does not land on one of
+// its instructions.
+func checkJumps(t *testing.T, program *pb.Program) {
+	t.Helper()
+	for i, insn := range program.Instructions {
+		if !ebpf.IsRelativeJump(insn) {
+			continue
+		}
+		if _, ok := ebpf.JumpTarget(program, i); !ok {
+			t.Fatalf("jump at instruction %d lands outside of the program: %v", i, program)
+		}
+	}
+}
+
+func TestOperationsKeepJumpsValid(t *testing.T) {
+	rand.SetSharedSeed(1)
+	tests := []struct {
+		testName  string
+		operation Operation
+	}{
+		{testName: "FlipImmediate", operation: FlipImmediate},
+		{testName: "SwapRegisters", operation: SwapRegisters},
+		{testName: "ChangeJumpOffset", operation: ChangeJumpOffset},
+		{testName: "DuplicateInstruction", operation: DuplicateInstruction},
+		{testName: "RemoveInstruction", operation: RemoveInstruction},
+		{testName: "Splice", operation: func(p *pb.Program) (*pb.Program, error) {
+			return Splice(p, testProgram())
+		}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				original := testProgram()
+				got, err := tc.operation(original)
+				if err != nil {
+					t.Fatalf("%s() = %v, want nil error", tc.testName, err)
+				}
+				checkJumps(t, got)
+				if !protobuf.Equal(original, testProgram()) {
+					t.Fatalf("%s() modified its input", tc.testName)
+				}
+			}
+		})
+	}
+}
+
+func TestMutateChains(t *testing.T) {
+	rand.SetSharedSeed(2)
+	program := testProgram()
+	for i := 0; i < 500; i++ {
+		mutated, err := Mutate(program, testProgram())
+		if err == NoCandidates {
+			program = testProgram()
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Mutate() = %v, want nil error", err)
+		}
+		checkJumps(t, mutated)
+		program = mutated
+	}
+}
+
+func TestDuplicateJump(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{ebpf.JmpEQ(ebpf.R0, 0, 1), ebpf.Mov64(ebpf.R0, 1), ebpf.Exit()}}
+	rand.SetSharedSeed(3)
+	for i := 0; i < 20; i++ {
+		got, err := DuplicateInstruction(program)
+		if err != nil {
+			t.Fatalf("DuplicateInstruction() = %v, want nil error", err)
+		}
+		if len(got.Instructions) != 4 {
+			t.Fatalf("DuplicateInstruction() returned %d instructions, want 4", len(got.Instructions))
+		}
+		if !ebpf.IsRelativeJump(got.Instructions[0]) || !ebpf.IsRelativeJump(got.Instructions[1]) {
+			continue
+		}
+		// Both copies of the jump must land on the exit.
+		for _, index := range []int{0, 1} {
+			if target, _ := ebpf.JumpTarget(got, index); target != 3 {
+				t.Errorf("JumpTarget(%d) = %d, want 3", index, target)
+			}
+		}
+	}
+}
+
+func TestNoCandidates(t *testing.T) {
+	program := &pb.Program{Instructions: []*pb.Instruction{ebpf.Exit()}}
+	if _, err := ChangeJumpOffset(program); err != NoCandidates {
+		t.Errorf("ChangeJumpOffset() = %v, want %v", err, NoCandidates)
+	}
+	if _, err := RemoveInstruction(program); err != NoCandidates {
+		t.Errorf("RemoveInstruction() = %v, want %v", err, NoCandidates)
+	}
+}
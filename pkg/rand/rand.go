@@ -21,6 +21,7 @@ package rand
 
 import (
 	"math/rand"
+	"sync"
 	"time"
 )
 
@@ -43,17 +44,99 @@ var (
 // NumGen provides helper methods for generating random integers. Each instance has its own seed
 // to prevent concurrent VMs from generating the same inputs
 type NumGen struct {
-	r *rand.Rand
+	r   *rand.Rand
+	src *lockedSource
 }
 
-// NewRand generates a new random number generator
+// lockedSource makes a rand.Source safe to use from several goroutines, e.g.
+// the workers of a parallel campaign, without changing the numbers it
+// generates. It counts the numbers it draws from the source, so its state can
+// be restored by drawing as many from a source with the same seed.
+type lockedSource struct {
+	mu    sync.Mutex
+	src   rand.Source
+	draws uint64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draws++
+	return s.src.Int63()
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if src, ok := s.src.(rand.Source64); ok {
+		s.draws++
+		return src.Uint64()
+	}
+	s.draws += 2
+	return uint64(s.src.Int63())>>31 | uint64(s.src.Int63())<<32
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.draws = 0
+	s.src.Seed(seed)
+}
+
+// skip draws `n` numbers from the source and throws them away.
+func (s *lockedSource) skip(n uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ; n > 0; n-- {
+		s.draws++
+		s.src.Int63()
+	}
+}
+
+// NewRand generates a new random number generator, it is safe for concurrent
+// use.
 func NewRand(randSource rand.Source) *NumGen {
+	src := &lockedSource{src: randSource}
 	return &NumGen{
-		r: rand.New(randSource),
+		r:   rand.New(src),
+		src: src,
 	}
 }
 
-var SharedRNG = NewRand(rand.NewSource(time.Now().Unix()))
+var sharedSeed = time.Now().Unix()
+
+var SharedRNG = NewRand(rand.NewSource(sharedSeed))
+
+// SharedSeed returns the seed SharedRNG was initialized with.
+func SharedSeed() int64 {
+	return sharedSeed
+}
+
+// SetSharedSeed reseeds SharedRNG so every number it generates from now on
+// can be reproduced by using the same seed again. It must be called before
+// any program is generated.
+func SetSharedSeed(seed int64) {
+	sharedSeed = seed
+	SharedRNG.src = &lockedSource{src: rand.NewSource(seed)}
+	SharedRNG.r = rand.New(SharedRNG.src)
+}
+
+// SharedState returns the seed of SharedRNG and how many numbers it drew
+// from its source so far.
+func SharedState() (seed int64, draws uint64) {
+	SharedRNG.src.mu.Lock()
+	defer SharedRNG.src.mu.Unlock()
+	return sharedSeed, SharedRNG.src.draws
+}
+
+// RestoreSharedState puts SharedRNG back in the state SharedState returned,
+// so it generates the numbers it would have generated next. It draws and
+// throws away `draws` numbers, which takes a while after long campaigns. It
+// must be called before any program is generated.
+func RestoreSharedState(seed int64, draws uint64) {
+	SetSharedSeed(seed)
+	SharedRNG.src.skip(draws)
+}
 
 // RandRange returns a random 64-bit integer in the range of begin..end
 func (g *NumGen) RandRange(begin, end uint64) uint64 {
@@ -0,0 +1,66 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// CgroupSetup holds the cgroup CreateCgroup created.
+type CgroupSetup struct {
+	// Path is the directory of the cgroup, empty if it was not created.
+	Path string
+}
+
+// CreateCgroup creates the cgroup `name` at the top of the cgroup v2
+// hierarchy mounted at `root`, empty means DefaultCgroupRoot, for the
+// cgroup programs to be attached to. `root` can also be a cgroup of the
+// hierarchy, the new one is created as its child. The returned CgroupSetup must be
+// restored before exiting, also when there is an error.
+func CreateCgroup(root, name string) (*CgroupSetup, error) {
+	cs := &CgroupSetup{}
+	if root == "" {
+		root = DefaultCgroupRoot
+	}
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(root, &fs); err != nil {
+		return cs, err
+	}
+	if fs.Type != cgroup2SuperMagic {
+		return cs, NoCgroupV2
+	}
+	dir := filepath.Join(root, name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return cs, fmt.Errorf("could not create the cgroup %s: %w", name, err)
+	}
+	cs.Path = dir
+	return cs, nil
+}
+
+// Restore removes the cgroup, the programs attached to it are detached
+// with it.
+func (cs *CgroupSetup) Restore() error {
+	if cs.Path == "" {
+		return nil
+	}
+	if err := os.Remove(cs.Path); err != nil {
+		return err
+	}
+	cs.Path = ""
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"testing"
+)
+
+func TestCreateCgroupOutsideCgroupV2(t *testing.T) {
+	cs, err := CreateCgroup(t.TempDir(), "buzzer")
+	if err != NoCgroupV2 {
+		t.Errorf("CreateCgroup() outside a cgroup v2 hierarchy = %v, want NoCgroupV2", err)
+	}
+	if err := cs.Restore(); err != nil {
+		t.Errorf("Restore() of a cgroup that was not created = %v", err)
+	}
+}
+
+func TestCreateChildCgroup(t *testing.T) {
+	parent, err := CreateCgroup("", "buzzer-test")
+	defer parent.Restore()
+	if err != nil {
+		t.Skipf("can't create a cgroup: %v", err)
+	}
+	child, err := CreateCgroup(parent.Path, "w1")
+	if err != nil {
+		t.Fatalf("CreateCgroup() of a child = %v", err)
+	}
+	if want := parent.Path + "/w1"; child.Path != want {
+		t.Errorf("child cgroup at %s, want %s", child.Path, want)
+	}
+	if err := parent.Restore(); err == nil {
+		t.Errorf("Restore() of a cgroup with a child succeeded")
+	}
+	if err := child.Restore(); err != nil {
+		t.Errorf("Restore() of the child = %v", err)
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package setup prepares the host the fuzzer runs on and undoes the changes
+// once it is done.
+//
+// The memory used by eBPF programs and maps is charged to RLIMIT_MEMLOCK on
+// kernels before 5.11 and to the memory cgroup of the loading process after
+// that. Both can be raised so loads don't fail for lack of memory, or
+// lowered on purpose to exercise the allocation failure paths of the kernel.
+package setup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+const (
+	// MemlockRlimit is RLIMIT_MEMLOCK on x86 and arm, the syscall package
+	// does not export it.
+	MemlockRlimit = 8
+
+	// rlimInfinity is RLIM_INFINITY as an unsigned value.
+	rlimInfinity = ^uint64(0)
+
+	// cgroup2SuperMagic is the filesystem type of cgroup v2 mounts.
+	cgroup2SuperMagic = 0x63677270
+
+	// DefaultCgroupRoot is where the cgroup v2 hierarchy is usually
+	// mounted.
+	DefaultCgroupRoot = "/sys/fs/cgroup"
+)
+
+var (
+	NoCgroupV2 = errors.New("no cgroup v2 hierarchy is available")
+)
+
+// MemoryConfig describes the memory limits the fuzzer should run under.
+type MemoryConfig struct {
+	// MemlockLimit is the RLIMIT_MEMLOCK soft limit in bytes, 0 lifts the
+	// limit entirely.
+	MemlockLimit uint64
+
+	// CgroupMemoryMax, if not 0, moves the process into a new cgroup whose
+	// memory.max is this many bytes, eBPF objects are charged to it.
+	CgroupMemoryMax uint64
+
+	// CgroupRoot is where the cgroup v2 hierarchy is mounted, empty means
+	// DefaultCgroupRoot.
+	CgroupRoot string
+}
+
+// MemorySetup holds what ConfigureMemory changed so it can be restored.
+type MemorySetup struct {
+	oldMemlock   syscall.Rlimit
+	memlockSaved bool
+
+	// Cgroup the process was in and the one created for it, relative to
+	// the root, empty if no cgroup was created.
+	cgroupRoot     string
+	originalCgroup string
+	cgroup         string
+}
+
+// RaiseMemlock raises the soft RLIMIT_MEMLOCK to the hard one.
+func RaiseMemlock() error {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(MemlockRlimit, &limit); err != nil {
+		return err
+	}
+	if limit.Cur == limit.Max {
+		return fmt.Errorf("RLIMIT_MEMLOCK is already at its hard limit %d", limit.Max)
+	}
+	limit.Cur = limit.Max
+	return syscall.Setrlimit(MemlockRlimit, &limit)
+}
+
+// setMemlock sets the soft RLIMIT_MEMLOCK to `limit` bytes, or lifts it if
+// `limit` is 0. Lifting it falls back to the hard limit without
+// CAP_SYS_RESOURCE.
+func setMemlock(limit uint64) error {
+	var rlimit syscall.Rlimit
+	if err := syscall.Getrlimit(MemlockRlimit, &rlimit); err != nil {
+		return err
+	}
+	if limit != 0 {
+		rlimit.Cur = min(limit, rlimit.Max)
+		return syscall.Setrlimit(MemlockRlimit, &rlimit)
+	}
+	unlimited := syscall.Rlimit{Cur: rlimInfinity, Max: rlimInfinity}
+	if err := syscall.Setrlimit(MemlockRlimit, &unlimited); err == nil || !errors.Is(err, syscall.EPERM) {
+		return err
+	}
+	rlimit.Cur = rlimit.Max
+	return syscall.Setrlimit(MemlockRlimit, &rlimit)
+}
+
+// parseCgroupPath returns the cgroup v2 path in the contents of
+// /proc/self/cgroup, where it is the entry of hierarchy 0.
+func parseCgroupPath(contents string) (string, error) {
+	for _, line := range strings.Split(contents, "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", NoCgroupV2
+}
+
+// writeCgroupFile writes `value` to the control file `name` of the cgroup at
+// `dir`.
+func writeCgroupFile(dir, name, value string) error {
+	return os.WriteFile(filepath.Join(dir, name), []byte(value), 0644)
+}
+
+// enterCgroup moves the process into a new cgroup, next to the top level
+// ones, limited to `memoryMax` bytes.
+func (ms *MemorySetup) enterCgroup(root string, memoryMax uint64) error {
+	var fs syscall.Statfs_t
+	if err := syscall.Statfs(root, &fs); err != nil {
+		return err
+	}
+	if fs.Type != cgroup2SuperMagic {
+		return NoCgroupV2
+	}
+	contents, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return err
+	}
+	ms.originalCgroup, err = parseCgroupPath(string(contents))
+	if err != nil {
+		return err
+	}
+
+	// Children of the root cgroup are the only ones guaranteed to be able
+	// to get the memory controller without moving other processes.
+	if err := writeCgroupFile(root, "cgroup.subtree_control", "+memory"); err != nil {
+		return fmt.Errorf("could not enable the memory controller: %w", err)
+	}
+	cgroup := fmt.Sprintf("/buzzer-%d", os.Getpid())
+	dir := filepath.Join(root, cgroup)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	ms.cgroupRoot = root
+	ms.cgroup = cgroup
+	if err := writeCgroupFile(dir, "memory.max", strconv.FormatUint(memoryMax, 10)); err != nil {
+		return err
+	}
+	return writeCgroupFile(dir, "cgroup.procs", strconv.Itoa(os.Getpid()))
+}
+
+// ConfigureMemory applies `config` to the process. The returned MemorySetup
+// must be restored before exiting, even when an error is returned.
+func ConfigureMemory(config MemoryConfig) (*MemorySetup, error) {
+	ms := &MemorySetup{}
+	if err := syscall.Getrlimit(MemlockRlimit, &ms.oldMemlock); err != nil {
+		return ms, err
+	}
+	ms.memlockSaved = true
+	if err := setMemlock(config.MemlockLimit); err != nil {
+		return ms, fmt.Errorf("could not set RLIMIT_MEMLOCK: %w", err)
+	}
+
+	if config.CgroupMemoryMax != 0 {
+		root := config.CgroupRoot
+		if root == "" {
+			root = DefaultCgroupRoot
+		}
+		if err := ms.enterCgroup(root, config.CgroupMemoryMax); err != nil {
+			return ms, fmt.Errorf("could not create a memory limited cgroup: %w", err)
+		}
+	}
+	return ms, nil
+}
+
+// Restore moves the process back to its original cgroup, removes the one
+// created for it and restores RLIMIT_MEMLOCK.
+func (ms *MemorySetup) Restore() error {
+	var errs []error
+	if ms.cgroup != "" {
+		original := filepath.Join(ms.cgroupRoot, ms.originalCgroup)
+		if err := writeCgroupFile(original, "cgroup.procs", strconv.Itoa(os.Getpid())); err != nil {
+			errs = append(errs, err)
+		} else if err := os.Remove(filepath.Join(ms.cgroupRoot, ms.cgroup)); err != nil {
+			errs = append(errs, err)
+		}
+		ms.cgroup = ""
+	}
+	if ms.memlockSaved {
+		if err := syscall.Setrlimit(MemlockRlimit, &ms.oldMemlock); err != nil {
+			errs = append(errs, err)
+		}
+		ms.memlockSaved = false
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,85 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+)
+
+func TestParseCgroupPath(t *testing.T) {
+	tests := []struct {
+		testName string
+		contents string
+		want     string
+		wantErr  error
+	}{
+		{
+			testName: "Unified hierarchy",
+			contents: "0::/user.slice/session-1.scope\n",
+			want:     "/user.slice/session-1.scope",
+		},
+		{
+			testName: "Hybrid hierarchy",
+			contents: "12:memory:/user.slice\n1:name=systemd:/user.slice\n0::/init.scope\n",
+			want:     "/init.scope",
+		},
+		{
+			testName: "Legacy hierarchy only",
+			contents: "4:memory:/\n1:name=systemd:/\n",
+			wantErr:  NoCgroupV2,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got, err := parseCgroupPath(tc.contents)
+			if !errors.Is(err, tc.wantErr) || got != tc.want {
+				t.Errorf("parseCgroupPath() = %q, %v, want %q, %v", got, err, tc.want, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfigureMemlock(t *testing.T) {
+	var before syscall.Rlimit
+	if err := syscall.Getrlimit(MemlockRlimit, &before); err != nil {
+		t.Fatalf("Getrlimit() = %v", err)
+	}
+	if before.Max < 1<<16 {
+		t.Skipf("hard RLIMIT_MEMLOCK %d is too low", before.Max)
+	}
+
+	ms, err := ConfigureMemory(MemoryConfig{MemlockLimit: 1 << 16})
+	if err != nil {
+		ms.Restore()
+		t.Fatalf("ConfigureMemory() = %v, want nil error", err)
+	}
+	var during syscall.Rlimit
+	syscall.Getrlimit(MemlockRlimit, &during)
+	if during.Cur != 1<<16 {
+		t.Errorf("RLIMIT_MEMLOCK = %d, want %d", during.Cur, 1<<16)
+	}
+
+	if err := ms.Restore(); err != nil {
+		t.Fatalf("Restore() = %v, want nil error", err)
+	}
+	var after syscall.Rlimit
+	syscall.Getrlimit(MemlockRlimit, &after)
+	if after != before {
+		t.Errorf("RLIMIT_MEMLOCK after Restore() = %+v, want %+v", after, before)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// UnprivilegedBpfSysctl controls whether users without CAP_BPF can
+	// load programs: 0 allows it, 1 forbids it until the next reboot and 2
+	// forbids it until an administrator changes it.
+	UnprivilegedBpfSysctl = "/proc/sys/kernel/unprivileged_bpf_disabled"
+
+	// linuxCapabilityVersion3 is _LINUX_CAPABILITY_VERSION_3, which uses
+	// two 32 bit words per capability set.
+	linuxCapabilityVersion3 = 0x20080522
+
+	capNetAdmin = 12
+	capSysAdmin = 21
+	capPerfmon  = 38
+	capBpf      = 39
+)
+
+var (
+	UnprivilegedBpfLocked = errors.New("unprivileged eBPF is disabled until the next reboot")
+)
+
+// bpfCapabilities are the capabilities that make the verifier treat a
+// program as privileged, e.g. skipping the Spectre mitigations.
+var bpfCapabilities = []int{capNetAdmin, capSysAdmin, capPerfmon, capBpf}
+
+// Restorer undoes a change made to the host or the process.
+type Restorer interface {
+	Restore() error
+}
+
+// UnprivilegedBpfSetup holds the value of UnprivilegedBpfSysctl before
+// AllowUnprivilegedBpf changed it.
+type UnprivilegedBpfSetup struct {
+	old string
+}
+
+// AllowUnprivilegedBpf lets users without CAP_BPF load programs. The returned
+// UnprivilegedBpfSetup must be restored before exiting.
+func AllowUnprivilegedBpf() (*UnprivilegedBpfSetup, error) {
+	contents, err := os.ReadFile(UnprivilegedBpfSysctl)
+	if err != nil {
+		return nil, err
+	}
+	us := &UnprivilegedBpfSetup{old: strings.TrimSpace(string(contents))}
+	switch us.old {
+	case "0":
+		return us, nil
+	case "1":
+		return nil, UnprivilegedBpfLocked
+	}
+	return us, os.WriteFile(UnprivilegedBpfSysctl, []byte("0"), 0644)
+}
+
+// Restore sets UnprivilegedBpfSysctl back to its original value.
+func (us *UnprivilegedBpfSetup) Restore() error {
+	if us.old == "0" {
+		return nil
+	}
+	return os.WriteFile(UnprivilegedBpfSysctl, []byte(us.old), 0644)
+}
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// capget returns the capabilities of the calling thread.
+func capget() ([2]capData, error) {
+	var data [2]capData
+	header := capHeader{version: linuxCapabilityVersion3}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPGET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return data, errno
+	}
+	return data, nil
+}
+
+// capset changes the capabilities of the calling thread.
+func capset(data [2]capData) error {
+	header := capHeader{version: linuxCapabilityVersion3}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET, uintptr(unsafe.Pointer(&header)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// hasCapability returns true if `capability` is in the effective set of
+// `data`.
+func hasCapability(data [2]capData, capability int) bool {
+	return data[capability/32].effective&(1<<(capability%32)) != 0
+}
+
+// WithoutBpfCapabilities runs `f` on a thread whose effective set lacks the
+// capabilities that make programs privileged, programs loaded by `f` are
+// verified as if an unprivileged user loaded them. The capabilities stay in
+// the permitted set and are raised again once `f` returns.
+//
+// If they cannot be raised again the goroutine stays locked to the thread,
+// so no other goroutine runs on it without capabilities.
+func WithoutBpfCapabilities(f func() error) error {
+	runtime.LockOSThread()
+	saved, err := capget()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+	dropped := saved
+	for _, capability := range bpfCapabilities {
+		dropped[capability/32].effective &^= 1 << (capability % 32)
+	}
+	if err := capset(dropped); err != nil {
+		runtime.UnlockOSThread()
+		return err
+	}
+
+	ferr := f()
+	if err := capset(saved); err != nil {
+		return errors.Join(ferr, err)
+	}
+	runtime.UnlockOSThread()
+	return ferr
+}
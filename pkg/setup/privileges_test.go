@@ -0,0 +1,61 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"errors"
+	"runtime"
+	"testing"
+)
+
+func TestWithoutBpfCapabilities(t *testing.T) {
+	// Capabilities are per thread, stay on the same one for the whole
+	// test.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	before, err := capget()
+	if err != nil {
+		t.Fatalf("capget() = %v", err)
+	}
+	if !hasCapability(before, capSysAdmin) {
+		t.Skip("the test needs CAP_SYS_ADMIN")
+	}
+
+	fErr := errors.New("f failed")
+	err = WithoutBpfCapabilities(func() error {
+		during, err := capget()
+		if err != nil {
+			t.Fatalf("capget() = %v", err)
+		}
+		for _, capability := range bpfCapabilities {
+			if hasCapability(during, capability) {
+				t.Errorf("capability %d is still effective", capability)
+			}
+		}
+		return fErr
+	})
+	if !errors.Is(err, fErr) {
+		t.Errorf("WithoutBpfCapabilities() = %v, want the error of f", err)
+	}
+
+	after, err := capget()
+	if err != nil {
+		t.Fatalf("capget() = %v", err)
+	}
+	if after != before {
+		t.Errorf("capabilities after WithoutBpfCapabilities() = %+v, want %+v", after, before)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// BpfSysctls are the sysctls that change how programs are verified, JITed
+// and run.
+var BpfSysctls = []string{
+	UnprivilegedBpfSysctl,
+	"/proc/sys/kernel/bpf_stats_enabled",
+	"/proc/sys/net/core/bpf_jit_enable",
+	"/proc/sys/net/core/bpf_jit_harden",
+	"/proc/sys/net/core/bpf_jit_kallsyms",
+	"/proc/sys/net/core/bpf_jit_limit",
+}
+
+// ReadSysctls returns the values of the sysctls at `paths`, indexed by path.
+// The ones that don't exist on this kernel are left out.
+func ReadSysctls(paths []string) map[string]string {
+	values := make(map[string]string)
+	for _, path := range paths {
+		if contents, err := os.ReadFile(path); err == nil {
+			values[path] = strings.TrimSpace(string(contents))
+		}
+	}
+	return values
+}
+
+// SysctlSetup holds the values of the sysctls SetSysctls changed.
+type SysctlSetup struct {
+	old map[string]string
+}
+
+// SetSysctls writes `values`, indexed by path, to the sysctls whose value
+// differs. UnprivilegedBpfSysctl is never set to 1, which cannot be undone
+// until the next reboot, but to 2 that forbids the same loads. The sysctls
+// that cannot be written, e.g. bpf_jit_enable on kernels whose JIT is always
+// on, are reported in the error and the others are still set. The returned
+// SysctlSetup must be restored before exiting, also when there is an error.
+func SetSysctls(values map[string]string) (*SysctlSetup, error) {
+	ss := &SysctlSetup{old: make(map[string]string)}
+	var paths []string
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var errs []error
+	for _, path := range paths {
+		value := values[path]
+		if path == UnprivilegedBpfSysctl && value == "1" {
+			value = "2"
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		old := strings.TrimSpace(string(contents))
+		if old == value {
+			continue
+		}
+		if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+			errs = append(errs, fmt.Errorf("setting %s to %q: %w", path, value, err))
+			continue
+		}
+		ss.old[path] = old
+	}
+	return ss, errors.Join(errs...)
+}
+
+// Restore sets the sysctls back to the values they had before SetSysctls.
+func (ss *SysctlSetup) Restore() error {
+	var errs []error
+	for path, value := range ss.old {
+		if err := os.WriteFile(path, []byte(value), 0644); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,58 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSetSysctls(t *testing.T) {
+	dir := t.TempDir()
+	jitHarden := filepath.Join(dir, "bpf_jit_harden")
+	jitEnable := filepath.Join(dir, "bpf_jit_enable")
+	missing := filepath.Join(dir, "missing")
+	if err := os.WriteFile(jitHarden, []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(jitEnable, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	paths := []string{jitHarden, jitEnable, missing}
+	if got, want := ReadSysctls(paths), map[string]string{jitHarden: "0", jitEnable: "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadSysctls() = %v, want %v", got, want)
+	}
+
+	ss, err := SetSysctls(map[string]string{jitHarden: "2", jitEnable: "1", missing: "1"})
+	if err == nil {
+		t.Errorf("SetSysctls() with a missing sysctl = nil error, want one")
+	}
+	if got, want := ReadSysctls(paths), map[string]string{jitHarden: "2", jitEnable: "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadSysctls() after SetSysctls() = %v, want %v", got, want)
+	}
+	if want := map[string]string{jitHarden: "0"}; !reflect.DeepEqual(ss.old, want) {
+		t.Errorf("changed sysctls = %v, want %v", ss.old, want)
+	}
+
+	if err := ss.Restore(); err != nil {
+		t.Fatalf("Restore() = %v", err)
+	}
+	if got, want := ReadSysctls(paths), map[string]string{jitHarden: "0", jitEnable: "1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadSysctls() after Restore() = %v, want %v", got, want)
+	}
+}
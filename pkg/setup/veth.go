@@ -0,0 +1,169 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// Netlink attributes of links the syscall package does not export.
+	iflaInfoKind = 1
+	iflaInfoData = 2
+	vethInfoPeer = 1
+)
+
+// VethSetup holds the veth pair CreateVethPair created.
+type VethSetup struct {
+	Name string
+	Peer string
+}
+
+// netlinkAttr returns the netlink attribute of type `attrType` holding
+// `value`, padded to the netlink alignment.
+func netlinkAttr(attrType uint16, value []byte) []byte {
+	length := syscall.SizeofRtAttr + len(value)
+	attr := make([]byte, (length+syscall.RTA_ALIGNTO-1) & ^(syscall.RTA_ALIGNTO-1))
+	binary.NativeEndian.PutUint16(attr, uint16(length))
+	binary.NativeEndian.PutUint16(attr[2:], attrType)
+	copy(attr[syscall.SizeofRtAttr:], value)
+	return attr
+}
+
+// nameAttr returns the IFLA_IFNAME attribute of the link `name`.
+func nameAttr(name string) []byte {
+	return netlinkAttr(syscall.IFLA_IFNAME, append([]byte(name), 0))
+}
+
+// ifInfo returns the bytes of `info`.
+func ifInfo(info syscall.IfInfomsg) []byte {
+	return append([]byte{}, (*[syscall.SizeofIfInfomsg]byte)(unsafe.Pointer(&info))[:]...)
+}
+
+// linkMessage returns the rtnetlink message of type `msgType` about the link
+// `info`, with the attributes `attrs`.
+func linkMessage(msgType uint16, flags uint16, info syscall.IfInfomsg, attrs ...[]byte) []byte {
+	body := ifInfo(info)
+	for _, attr := range attrs {
+		body = append(body, attr...)
+	}
+	msg := make([]byte, syscall.NLMSG_HDRLEN, syscall.NLMSG_HDRLEN+len(body))
+	binary.NativeEndian.PutUint32(msg, uint32(syscall.NLMSG_HDRLEN+len(body)))
+	binary.NativeEndian.PutUint16(msg[4:], msgType)
+	binary.NativeEndian.PutUint16(msg[6:], flags|syscall.NLM_F_REQUEST|syscall.NLM_F_ACK)
+	binary.NativeEndian.PutUint32(msg[8:], 1)
+	return append(msg, body...)
+}
+
+// vethNewLinkMessage returns the message creating the veth pair `name` and
+// `peer`.
+func vethNewLinkMessage(name, peer string) []byte {
+	peerInfo := append(ifInfo(syscall.IfInfomsg{Family: syscall.AF_UNSPEC}), nameAttr(peer)...)
+	linkInfo := append(
+		netlinkAttr(iflaInfoKind, []byte("veth")),
+		netlinkAttr(iflaInfoData, netlinkAttr(vethInfoPeer, peerInfo))...,
+	)
+	return linkMessage(syscall.RTM_NEWLINK, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL,
+		syscall.IfInfomsg{Family: syscall.AF_UNSPEC},
+		nameAttr(name), netlinkAttr(syscall.IFLA_LINKINFO, linkInfo))
+}
+
+// linkUpMessage returns the message bringing the link with index `index`
+// up.
+func linkUpMessage(index int) []byte {
+	return linkMessage(syscall.RTM_NEWLINK, 0, syscall.IfInfomsg{
+		Family: syscall.AF_UNSPEC,
+		Index:  int32(index),
+		Flags:  syscall.IFF_UP,
+		Change: syscall.IFF_UP,
+	})
+}
+
+// linkDelMessage returns the message deleting the link `name`.
+func linkDelMessage(name string) []byte {
+	return linkMessage(syscall.RTM_DELLINK, 0, syscall.IfInfomsg{Family: syscall.AF_UNSPEC}, nameAttr(name))
+}
+
+// routeRequest sends `msg` to the kernel over rtnetlink and returns the error
+// it acknowledged it with.
+func routeRequest(msg []byte) error {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+	if err := syscall.Sendto(fd, msg, 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK}); err != nil {
+		return err
+	}
+	buf := make([]byte, os.Getpagesize())
+	n, _, err := syscall.Recvfrom(fd, buf, 0)
+	if err != nil {
+		return err
+	}
+	replies, err := syscall.ParseNetlinkMessage(buf[:n])
+	if err != nil {
+		return err
+	}
+	for _, reply := range replies {
+		if reply.Header.Type != syscall.NLMSG_ERROR || len(reply.Data) < 4 {
+			continue
+		}
+		if errno := -int32(binary.NativeEndian.Uint32(reply.Data)); errno != 0 {
+			return syscall.Errno(errno)
+		}
+		return nil
+	}
+	return fmt.Errorf("no acknowledgement in the rtnetlink reply")
+}
+
+// CreateVethPair creates the veth pair `name` and `peer` and brings both ends
+// up. IPv6 is disabled on them so the only packets they see are the ones the
+// fuzzer sends. The returned VethSetup must be restored before exiting, also
+// when there is an error.
+func CreateVethPair(name, peer string) (*VethSetup, error) {
+	vs := &VethSetup{}
+	if err := routeRequest(vethNewLinkMessage(name, peer)); err != nil {
+		return vs, fmt.Errorf("could not create the veth pair %s and %s: %w", name, peer, err)
+	}
+	vs.Name = name
+	vs.Peer = peer
+	for _, link := range []string{name, peer} {
+		// Router solicitations and the like would be picked up as
+		// packets the program let through, the sysctl is missing
+		// without IPv6 which is as good.
+		os.WriteFile(fmt.Sprintf("/proc/sys/net/ipv6/conf/%s/disable_ipv6", link), []byte("1"), 0644)
+		iface, err := net.InterfaceByName(link)
+		if err != nil {
+			return vs, err
+		}
+		if err := routeRequest(linkUpMessage(iface.Index)); err != nil {
+			return vs, fmt.Errorf("could not bring %s up: %w", link, err)
+		}
+	}
+	return vs, nil
+}
+
+// Restore deletes the veth pair, removing one end removes the other.
+func (vs *VethSetup) Restore() error {
+	if vs.Name == "" {
+		return nil
+	}
+	return routeRequest(linkDelMessage(vs.Name))
+}
@@ -0,0 +1,92 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package setup
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+)
+
+// parseAttrs returns the netlink attributes in `b` indexed by type.
+func parseAttrs(t *testing.T, b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+	for len(b) >= syscall.SizeofRtAttr {
+		length := int(binary.NativeEndian.Uint16(b))
+		if length < syscall.SizeofRtAttr || length > len(b) {
+			t.Fatalf("attribute of length %d in %d bytes", length, len(b))
+		}
+		attrs[binary.NativeEndian.Uint16(b[2:])] = b[syscall.SizeofRtAttr:length]
+		aligned := (length + syscall.RTA_ALIGNTO - 1) & ^(syscall.RTA_ALIGNTO - 1)
+		b = b[min(aligned, len(b)):]
+	}
+	return attrs
+}
+
+func TestVethNewLinkMessage(t *testing.T) {
+	msgs, err := syscall.ParseNetlinkMessage(vethNewLinkMessage("buzzer0", "buzzer1"))
+	if err != nil {
+		t.Fatalf("ParseNetlinkMessage() = %v", err)
+	}
+	if len(msgs) != 1 {
+		t.Fatalf("vethNewLinkMessage() has %d messages, want 1", len(msgs))
+	}
+	msg := msgs[0]
+	if msg.Header.Type != syscall.RTM_NEWLINK {
+		t.Errorf("message type = %d, want RTM_NEWLINK", msg.Header.Type)
+	}
+	if want := uint16(syscall.NLM_F_REQUEST | syscall.NLM_F_ACK | syscall.NLM_F_CREATE | syscall.NLM_F_EXCL); msg.Header.Flags != want {
+		t.Errorf("message flags = %#x, want %#x", msg.Header.Flags, want)
+	}
+
+	attrs := parseAttrs(t, msg.Data[syscall.SizeofIfInfomsg:])
+	if got := string(attrs[syscall.IFLA_IFNAME]); got != "buzzer0\x00" {
+		t.Errorf("link name = %q, want buzzer0", got)
+	}
+	linkInfo := parseAttrs(t, attrs[syscall.IFLA_LINKINFO])
+	if got := string(linkInfo[iflaInfoKind]); got != "veth" {
+		t.Errorf("link kind = %q, want veth", got)
+	}
+	peer := parseAttrs(t, linkInfo[iflaInfoData])[vethInfoPeer]
+	if len(peer) < syscall.SizeofIfInfomsg {
+		t.Fatalf("peer attribute is %d bytes, want at least %d", len(peer), syscall.SizeofIfInfomsg)
+	}
+	if got := string(parseAttrs(t, peer[syscall.SizeofIfInfomsg:])[syscall.IFLA_IFNAME]); got != "buzzer1\x00" {
+		t.Errorf("peer name = %q, want buzzer1", got)
+	}
+}
+
+func TestLinkUpMessage(t *testing.T) {
+	msgs, err := syscall.ParseNetlinkMessage(linkUpMessage(7))
+	if err != nil || len(msgs) != 1 {
+		t.Fatalf("ParseNetlinkMessage() = %v, %v, want one message", msgs, err)
+	}
+	data := msgs[0].Data
+	if len(data) != syscall.SizeofIfInfomsg {
+		t.Fatalf("message is %d bytes, want a bare ifinfomsg", len(data))
+	}
+	if index := int32(binary.NativeEndian.Uint32(data[4:])); index != 7 {
+		t.Errorf("link index = %d, want 7", index)
+	}
+	if flags := binary.NativeEndian.Uint32(data[8:]); flags != syscall.IFF_UP {
+		t.Errorf("link flags = %#x, want IFF_UP", flags)
+	}
+}
+
+func TestVethSetupRestoreWithoutPair(t *testing.T) {
+	if err := (&VethSetup{}).Restore(); err != nil {
+		t.Errorf("Restore() of a pair that was not created = %v", err)
+	}
+}
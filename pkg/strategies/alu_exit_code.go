@@ -0,0 +1,119 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"math"
+)
+
+// Maximum number of random ALU instructions of a program.
+const aluExitCodeMaxInstructions = 32
+
+func NewAluExitCodeStrategy() *AluExitCode {
+	return &AluExitCode{isFinished: false}
+}
+
+// AluExitCode is a strategy that generates programs made of ALU
+// instructions only: R0-R9 are set to random values, then random ALU
+// instructions work on them and the program returns R0. The control unit
+// computes the value such programs return in Go, see ebpf.EvaluateAlu, and
+// reports those that return another one, catching miscompilations of the
+// JIT.
+//
+// The programs are loaded as BPF_PROG_TYPE_SCHED_CLS, whose executions are
+// test runs that report the return value.
+type AluExitCode struct {
+	isFinished        bool
+	programCount      int
+	validProgramCount int
+}
+
+// randomAluExitCodeValue returns a random 64 bit value, half of the time one
+// that sits at a 32 or 64 bit boundary.
+func randomAluExitCodeValue() int64 {
+	if rand.SharedRNG.OneOf(2) {
+		boundaries := []int64{
+			0, 1, -1,
+			math.MaxInt32, math.MinInt32, math.MaxUint32,
+			math.MaxInt64, math.MinInt64,
+		}
+		return boundaries[rand.SharedRNG.RandRange(0, uint64(len(boundaries)-1))]
+	}
+	return int64(rand.SharedRNG.RandInt())
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (ae *AluExitCode) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	ae.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", ae.programCount, ae.validProgramCount)
+
+	var insn []*epb.Instruction
+	for reg := R0; reg <= R9; reg++ {
+		insn = append(insn, Mov64(reg, randomAluExitCodeValue()))
+	}
+	for i := rand.SharedRNG.RandRange(1, aluExitCodeMaxInstructions); i > 0; i-- {
+		insn = append(insn, RandomAluInstruction())
+	}
+	insn = append(insn, Exit())
+	return &epb.Program{Instructions: insn}, nil
+}
+
+// ProgramType returns the type the programs are loaded as.
+func (ae *AluExitCode) ProgramType() int {
+	return units.ProgTypeSchedCls
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (ae *AluExitCode) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	ae.validProgramCount += 1
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+//
+// The return value is checked by the control unit, see units.OracleExitCode.
+func (ae *AluExitCode) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (ae *AluExitCode) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (ae *AluExitCode) IsFuzzingDone() bool {
+	return ae.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (ae *AluExitCode) Name() string {
+	return "alu_exit_code"
+}
@@ -0,0 +1,34 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+)
+
+func TestAluExitCodeProgramsEvaluate(t *testing.T) {
+	ae := NewAluExitCodeStrategy()
+	for i := 0; i < 100; i++ {
+		prog, err := ae.GenerateProgram(nil)
+		if err != nil {
+			t.Fatalf("GenerateProgram() = %v, want nil error", err)
+		}
+		if _, err := ebpf.EvaluateAlu(prog); err != nil {
+			t.Fatalf("EvaluateAlu() = %v, want nil error for %v", err, prog)
+		}
+	}
+}
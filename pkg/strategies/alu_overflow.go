@@ -0,0 +1,479 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"math"
+)
+
+const (
+	// Map layout: element 0 is the input of the program, element 1 its
+	// output.
+	aluOverflowMapSize = 2
+
+	// Value the output element is reset to before every execution, so it
+	// is possible to tell if the program reached the store.
+	aluOverflowUnset = 0x5a5a5a5a5a5a5a5a
+
+	// Maximum number of operations applied to the input.
+	aluOverflowMaxSteps = 8
+
+	// Register that holds the input and the intermediate results.
+	aluOverflowReg = R6
+
+	// Register the final result is copied to before it is stored. It is
+	// written only once, so every state the verifier log prints for it
+	// describes the final result.
+	aluOverflowResultReg = R9
+)
+
+// Immediates that sit right at, or next to, the 32 and 64 bit boundaries.
+var aluOverflowImmediates = []int32{
+	0, 1, -1, 2, -2,
+	math.MaxInt32, math.MinInt32, math.MaxInt32 - 1, math.MinInt32 + 1,
+	math.MaxInt16, math.MinInt16, math.MaxUint16,
+	0x10000, 0x40000000, -0x40000000,
+}
+
+// aluOverflowStep is either an ALU operation applied to the working
+// register or a conditional jump to the exit of the program that narrows its
+// bounds.
+type aluOverflowStep struct {
+	isCheck bool
+	is64    bool
+	aluOp   epb.AluOperationCode
+	jmpOp   epb.JmpOperationCode
+	imm     int32
+
+	// useSelf makes the ALU operation use the working register as source
+	// instead of the immediate.
+	useSelf bool
+}
+
+// instruction returns the eBPF instruction for the step, checks jump
+// `offset` instructions forward.
+func (s *aluOverflowStep) instruction(offset int16) *epb.Instruction {
+	r := aluOverflowReg
+	if s.isCheck {
+		switch s.jmpOp {
+		case epb.JmpOperationCode_JmpJGT:
+			if s.is64 {
+				return JmpGT(r, s.imm, offset)
+			}
+			return JmpGT32(r, s.imm, offset)
+		case epb.JmpOperationCode_JmpJLT:
+			if s.is64 {
+				return JmpLT(r, s.imm, offset)
+			}
+			return JmpLT32(r, s.imm, offset)
+		case epb.JmpOperationCode_JmpJGE:
+			if s.is64 {
+				return JmpGE(r, s.imm, offset)
+			}
+			return JmpGE32(r, s.imm, offset)
+		case epb.JmpOperationCode_JmpJLE:
+			if s.is64 {
+				return JmpLE(r, s.imm, offset)
+			}
+			return JmpLE32(r, s.imm, offset)
+		case epb.JmpOperationCode_JmpJSGT:
+			if s.is64 {
+				return JmpSGT(r, s.imm, offset)
+			}
+			return JmpSGT32(r, s.imm, offset)
+		case epb.JmpOperationCode_JmpJSGE:
+			if s.is64 {
+				return JmpSGE(r, s.imm, offset)
+			}
+			return JmpSGE32(r, s.imm, offset)
+		case epb.JmpOperationCode_JmpJSLE:
+			if s.is64 {
+				return JmpSLE(r, s.imm, offset)
+			}
+			return JmpSLE32(r, s.imm, offset)
+		default:
+			if s.is64 {
+				return JmpSLT(r, s.imm, offset)
+			}
+			return JmpSLT32(r, s.imm, offset)
+		}
+	}
+
+	switch s.aluOp {
+	case epb.AluOperationCode_AluAdd:
+		if s.useSelf {
+			if s.is64 {
+				return Add64(r, r)
+			}
+			return Add(r, r)
+		}
+		if s.is64 {
+			return Add64(r, s.imm)
+		}
+		return Add(r, s.imm)
+	case epb.AluOperationCode_AluSub:
+		if s.useSelf {
+			if s.is64 {
+				return Sub64(r, r)
+			}
+			return Sub(r, r)
+		}
+		if s.is64 {
+			return Sub64(r, s.imm)
+		}
+		return Sub(r, s.imm)
+	default:
+		if s.useSelf {
+			if s.is64 {
+				return Mul64(r, r)
+			}
+			return Mul(r, r)
+		}
+		if s.is64 {
+			return Mul64(r, s.imm)
+		}
+		return Mul(r, s.imm)
+	}
+}
+
+// apply emulates the step on `v`, returns the new value and true if the
+// program would jump to its exit.
+func (s *aluOverflowStep) apply(v uint64) (uint64, bool) {
+	if s.isCheck {
+		switch s.jmpOp {
+		case epb.JmpOperationCode_JmpJGT:
+			if s.is64 {
+				return v, v > uint64(int64(s.imm))
+			}
+			return v, uint32(v) > uint32(s.imm)
+		case epb.JmpOperationCode_JmpJLT:
+			if s.is64 {
+				return v, v < uint64(int64(s.imm))
+			}
+			return v, uint32(v) < uint32(s.imm)
+		case epb.JmpOperationCode_JmpJGE:
+			if s.is64 {
+				return v, v >= uint64(int64(s.imm))
+			}
+			return v, uint32(v) >= uint32(s.imm)
+		case epb.JmpOperationCode_JmpJLE:
+			if s.is64 {
+				return v, v <= uint64(int64(s.imm))
+			}
+			return v, uint32(v) <= uint32(s.imm)
+		case epb.JmpOperationCode_JmpJSGT:
+			if s.is64 {
+				return v, int64(v) > int64(s.imm)
+			}
+			return v, int32(v) > s.imm
+		case epb.JmpOperationCode_JmpJSGE:
+			if s.is64 {
+				return v, int64(v) >= int64(s.imm)
+			}
+			return v, int32(v) >= s.imm
+		case epb.JmpOperationCode_JmpJSLE:
+			if s.is64 {
+				return v, int64(v) <= int64(s.imm)
+			}
+			return v, int32(v) <= s.imm
+		default:
+			if s.is64 {
+				return v, int64(v) < int64(s.imm)
+			}
+			return v, int32(v) < s.imm
+		}
+	}
+
+	src := uint64(int64(s.imm))
+	if s.useSelf {
+		src = v
+	}
+	var result uint64
+	switch s.aluOp {
+	case epb.AluOperationCode_AluAdd:
+		result = v + src
+	case epb.AluOperationCode_AluSub:
+		result = v - src
+	default:
+		result = v * src
+	}
+	if !s.is64 {
+		// 32 bit operations zero extend their result.
+		result = uint64(uint32(result))
+	}
+	return result, false
+}
+
+func randomAluOverflowImmediate() int32 {
+	if rand.SharedRNG.OneOf(4) {
+		return int32(rand.SharedRNG.RandInt())
+	}
+	return aluOverflowImmediates[rand.SharedRNG.RandRange(0, uint64(len(aluOverflowImmediates)-1))]
+}
+
+func randomAluOverflowStep() aluOverflowStep {
+	step := aluOverflowStep{
+		is64: rand.SharedRNG.OneOf(2),
+		imm:  randomAluOverflowImmediate(),
+	}
+	if rand.SharedRNG.OneOf(4) {
+		step.isCheck = true
+		checks := []epb.JmpOperationCode{
+			epb.JmpOperationCode_JmpJGT,
+			epb.JmpOperationCode_JmpJGE,
+			epb.JmpOperationCode_JmpJLT,
+			epb.JmpOperationCode_JmpJLE,
+			epb.JmpOperationCode_JmpJSGT,
+			epb.JmpOperationCode_JmpJSGE,
+			epb.JmpOperationCode_JmpJSLT,
+			epb.JmpOperationCode_JmpJSLE,
+		}
+		step.jmpOp = checks[rand.SharedRNG.RandRange(0, uint64(len(checks)-1))]
+		return step
+	}
+	ops := []epb.AluOperationCode{
+		epb.AluOperationCode_AluAdd,
+		epb.AluOperationCode_AluSub,
+		epb.AluOperationCode_AluMul,
+	}
+	step.aluOp = ops[rand.SharedRNG.RandRange(0, uint64(len(ops)-1))]
+	step.useSelf = rand.SharedRNG.OneOf(8)
+	return step
+}
+
+func NewAluOverflowStrategy() *AluOverflow {
+	return &AluOverflow{isFinished: false, mapFd: -1}
+}
+
+// AluOverflow is a strategy that reads an unknown value from a map,
+// establishes bounds on it with conditional jumps and then applies add, sub
+// and mul operations with immediates picked to wrap the 32 and 64 bit
+// boundaries.
+//
+// The program is emulated in user space for the input written to the map,
+// the result of the execution must match the emulation and must be within
+// the umin/umax/smin/smax/var_off the verifier tracked for it.
+type AluOverflow struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+
+	// State of the last generated program.
+	lo, hi      int32
+	steps       []aluOverflowStep
+	input       uint64
+	trackedVals []scalarBounds
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (ao *AluOverflow) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	ao.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", ao.programCount, ao.validProgramCount)
+
+	if ao.mapFd < 0 {
+		ao.mapFd = ffi.CreateMapArray(aluOverflowMapSize)
+		if ao.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	// Bounds of the input, compared as unsigned 64 bit values once sign
+	// extended.
+	ao.lo, ao.hi = randomAluOverflowImmediate(), randomAluOverflowImmediate()
+	if uint64(int64(ao.lo)) > uint64(int64(ao.hi)) {
+		ao.lo, ao.hi = ao.hi, ao.lo
+	}
+	ao.steps = []aluOverflowStep{
+		{isCheck: true, is64: true, jmpOp: epb.JmpOperationCode_JmpJGT, imm: ao.hi},
+		{isCheck: true, is64: true, jmpOp: epb.JmpOperationCode_JmpJLT, imm: ao.lo},
+	}
+	stepCount := rand.SharedRNG.RandRange(1, aluOverflowMaxSteps)
+	for i := uint64(0); i < stepCount; i++ {
+		ao.steps = append(ao.steps, randomAluOverflowStep())
+	}
+
+	// R8 holds the map pointer, R6 the input loaded from element 0.
+	header, err := InstructionSequence(
+		LdMapByFd(R8, ao.mapFd),
+		StW(R10, 0, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Mov64(R1, R8),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+		LdDW(aluOverflowReg, R0, 0),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store the result in element 1.
+	footer, err := InstructionSequence(
+		Mov64(aluOverflowResultReg, aluOverflowReg),
+		StW(R10, 1, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Mov64(R1, R8),
+		Call(MapLookup),
+		JmpEQ(R0, 0, 1),
+		StDW(R0, aluOverflowResultReg, 0),
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Checks jump to the `r0 = 0; exit` at the end of the footer.
+	body := []*epb.Instruction{}
+	for i := range ao.steps {
+		remaining := len(ao.steps) - i - 1
+		offset := int16(remaining + len(footer) - 2)
+		body = append(body, ao.steps[i].instruction(offset))
+	}
+
+	insn := append(header, body...)
+	insn = append(insn, footer...)
+	return &epb.Program{Instructions: insn}, nil
+}
+
+// emulate runs the steps on `input`, returns the result and whether the
+// program reaches the store of the result.
+func (ao *AluOverflow) emulate(input uint64) (uint64, bool) {
+	v := input
+	for i := range ao.steps {
+		var exits bool
+		v, exits = ao.steps[i].apply(v)
+		if exits {
+			return v, false
+		}
+	}
+	return v, true
+}
+
+// pickInput returns an input for the program within the established
+// bounds, biased towards the edges.
+func (ao *AluOverflow) pickInput() uint64 {
+	lo, hi := uint64(int64(ao.lo)), uint64(int64(ao.hi))
+	switch rand.SharedRNG.RandRange(0, 2) {
+	case 0:
+		return lo
+	case 1:
+		return hi
+	default:
+		if hi-lo == math.MaxUint64 {
+			return rand.SharedRNG.RandInt()
+		}
+		return lo + rand.SharedRNG.RandInt()%(hi-lo+1)
+	}
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (ao *AluOverflow) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	ao.validProgramCount += 1
+	ao.trackedVals = parseRegisterBounds(verificationResult.VerifierLog, aluOverflowResultReg)
+
+	ao.input = ao.pickInput()
+	if ffi.SetMapElement(ao.mapFd, 0, ao.input) != 0 || ffi.SetMapElement(ao.mapFd, 1, aluOverflowUnset) != 0 {
+		logging.Warningf("could not initialize the map\n")
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (ao *AluOverflow) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	mapElements, err := ffi.GetMapElements(ao.mapFd, aluOverflowMapSize)
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	got := mapElements.Elements[1]
+
+	want, reached := ao.emulate(ao.input)
+	if !reached {
+		if got != aluOverflowUnset {
+			logging.Infof("input %#x should have exited early but stored %#x\n", ao.input, got)
+			return false
+		}
+		return true
+	}
+	if got != want {
+		logging.Infof("input %#x produced %#x, emulation expected %#x\n", ao.input, got, want)
+		return false
+	}
+
+	if len(ao.trackedVals) == 0 {
+		return true
+	}
+	for _, sb := range ao.trackedVals {
+		if sb.contains(got) {
+			return true
+		}
+	}
+	logging.Infof("input %#x produced %#x, outside of every verifier state for R%d:\n", ao.input, got, aluOverflowResultReg)
+	for _, sb := range ao.trackedVals {
+		logging.Infof("\t%s\n", sb)
+	}
+	return false
+}
+
+// Maps returns the map holding the input and the output.
+func (ao *AluOverflow) Maps() map[int]uint64 {
+	return map[int]uint64{ao.mapFd: aluOverflowMapSize}
+}
+
+// ExpectedMapDeltas returns the result the emulation expects in element 1,
+// the input in element 0 must stay unchanged.
+func (ao *AluOverflow) ExpectedMapDeltas() []*fpb.MapElementDelta {
+	want, reached := ao.emulate(ao.input)
+	if !reached {
+		want = aluOverflowUnset
+	}
+	return []*fpb.MapElementDelta{{MapFd: int64(ao.mapFd), Key: 1, After: want}}
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (ao *AluOverflow) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (ao *AluOverflow) IsFuzzingDone() bool {
+	return ao.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (ao *AluOverflow) Name() string {
+	return "alu_overflow"
+}
@@ -0,0 +1,457 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// Map layout: element 0 holds the input the program reads, element 1
+	// receives the value read through the pointer.
+	aluSanitationMapSize = 2
+
+	// Size of the map values, the object a map value pointer points to.
+	aluSanitationValueSize = 8
+
+	// The bytes right below R10 are initialized with known values, reads
+	// elsewhere in the frame can't be checked.
+	aluSanitationRegionSize = 4 * StackSlotSize
+
+	// Offset of the map key on the stack, out of the way of the region.
+	aluSanitationKeyOffset = -aluSanitationRegionSize - StackSlotSize
+
+	// Maximum number of scalars added to or subtracted from the pointer.
+	aluSanitationMaxOperands = 2
+
+	// Maximum number of operations applied to each scalar.
+	aluSanitationMaxSteps = 3
+)
+
+// aluSanitationPointer is the kind of pointer the arithmetic is done on.
+// Socket filters can't access the packet directly, so only map value and
+// stack pointers are available.
+type aluSanitationPointer int
+
+const (
+	mapValuePointer aluSanitationPointer = iota
+	stackPointer
+)
+
+// scalarKnowledge is what the verifier knows about a scalar added to the
+// pointer.
+type scalarKnowledge int
+
+const (
+	// The scalar is a constant, the verifier skips the sanitation.
+	knownScalar scalarKnowledge = iota
+
+	// The scalar is derived from the input after masking it, the verifier
+	// knows its bounds but not its value and has to sanitize the
+	// arithmetic.
+	boundedScalar
+
+	// The scalar is derived from the input without masking it, the
+	// verifier must refuse to access memory through the pointer.
+	unboundedScalar
+)
+
+// aluSanitationStep is an ALU operation applied to a scalar before it is
+// added to the pointer.
+type aluSanitationStep struct {
+	op  epb.AluOperationCode
+	imm int32
+}
+
+// instruction returns the eBPF instruction for the step applied to `dst`.
+func (s aluSanitationStep) instruction(dst epb.Reg) *epb.Instruction {
+	switch s.op {
+	case epb.AluOperationCode_AluAnd:
+		return And64(dst, s.imm)
+	case epb.AluOperationCode_AluAdd:
+		return Add64(dst, s.imm)
+	case epb.AluOperationCode_AluSub:
+		return Sub64(dst, s.imm)
+	case epb.AluOperationCode_AluLsh:
+		return Lsh64(dst, s.imm)
+	case epb.AluOperationCode_AluRsh:
+		return Rsh64(dst, s.imm)
+	default:
+		// A 32 bit move zero extends the lower half of the register.
+		return Mov(dst, dst)
+	}
+}
+
+// apply returns the result of the step on `v`.
+func (s aluSanitationStep) apply(v uint64) uint64 {
+	imm := uint64(int64(s.imm))
+	switch s.op {
+	case epb.AluOperationCode_AluAnd:
+		return v & imm
+	case epb.AluOperationCode_AluAdd:
+		return v + imm
+	case epb.AluOperationCode_AluSub:
+		return v - imm
+	case epb.AluOperationCode_AluLsh:
+		return v << (imm & 63)
+	case epb.AluOperationCode_AluRsh:
+		return v >> (imm & 63)
+	default:
+		return uint64(uint32(v))
+	}
+}
+
+// randomAluSanitationStep returns a step that keeps scalars small and
+// multiples of `align`, masks are left to the caller as they change what the
+// verifier knows.
+func randomAluSanitationStep(align int32) aluSanitationStep {
+	ops := []epb.AluOperationCode{
+		epb.AluOperationCode_AluAdd,
+		epb.AluOperationCode_AluSub,
+		epb.AluOperationCode_AluLsh,
+		epb.AluOperationCode_AluMov,
+	}
+	if align == 1 {
+		ops = append(ops, epb.AluOperationCode_AluRsh)
+	}
+	s := aluSanitationStep{op: ops[rand.SharedRNG.RandRange(0, uint64(len(ops)-1))]}
+	switch s.op {
+	case epb.AluOperationCode_AluLsh, epb.AluOperationCode_AluRsh:
+		s.imm = int32(rand.SharedRNG.RandRange(0, 3))
+	default:
+		s.imm = int32(rand.SharedRNG.RandRange(0, 2*aluSanitationValueSize/uint64(align))) * align
+	}
+	return s
+}
+
+// aluSanitationOperand is a scalar added to, or subtracted from, the
+// pointer.
+type aluSanitationOperand struct {
+	knowledge scalarKnowledge
+	constant  int32
+	steps     []aluSanitationStep
+	subtract  bool
+}
+
+// value returns the scalar once computed from `input`.
+func (o *aluSanitationOperand) value(input uint64) uint64 {
+	v := input
+	if o.knowledge == knownScalar {
+		v = uint64(int64(o.constant))
+	}
+	for _, s := range o.steps {
+		v = s.apply(v)
+	}
+	return v
+}
+
+func NewAluSanitationStrategy() *AluSanitation {
+	return &AluSanitation{isFinished: false, mapFd: -1}
+}
+
+// AluSanitation is a strategy that targets the sanitation of pointer
+// arithmetic done by adjust_ptr_min_max_vals and sanitize_ptr_alu. It adds
+// scalars whose value is known, whose bounds are known or that are unbounded
+// to a map value or stack pointer and then reads through it.
+//
+// Half of the programs are loaded without privileges, the verifier then
+// masks the scalars so speculative execution stays within the bounds it
+// computed. The value read is checked against the one the pointer should
+// point to, which catches both accesses the verifier should have refused
+// and masks that change the result of legitimate arithmetic.
+type AluSanitation struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+
+	// State of the last generated program.
+	pointer      aluSanitationPointer
+	operands     []aluSanitationOperand
+	offset       int16
+	size         int
+	region       [aluSanitationRegionSize]byte
+	unprivileged bool
+	input        uint64
+}
+
+// aluSanitationLoad returns a load of `size` bytes at `offset` from `src`
+// into `dst`.
+func aluSanitationLoad(size int, dst, src epb.Reg, offset int16) *epb.Instruction {
+	switch size {
+	case 1:
+		return LdB(dst, src, offset)
+	case 2:
+		return LdH(dst, src, offset)
+	case 4:
+		return LdW(dst, src, offset)
+	default:
+		return LdDW(dst, src, offset)
+	}
+}
+
+// lookupElement returns the instructions that store a pointer to the map
+// element `key` in `dst`, or exit if the lookup fails.
+func (as *AluSanitation) lookupElement(key int32, dst epb.Reg) ([]*epb.Instruction, error) {
+	return InstructionSequence(
+		StW(R10, key, aluSanitationKeyOffset),
+		LdMapByFd(R1, as.mapFd),
+		Mov64(R2, R10),
+		Add64(R2, aluSanitationKeyOffset),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+		Mov64(dst, R0),
+	)
+}
+
+// randomOperand returns a scalar with random knowledge and operations whose
+// value is a multiple of `align`. The verifier refuses to access memory
+// through a pointer offset by an unbounded scalar, so they are rare.
+func randomOperand(align int32) aluSanitationOperand {
+	o := aluSanitationOperand{
+		knowledge: scalarKnowledge(rand.SharedRNG.RandRange(0, 1)),
+		subtract:  rand.SharedRNG.OneOf(3),
+	}
+	if rand.SharedRNG.OneOf(6) {
+		o.knowledge = unboundedScalar
+	}
+	switch o.knowledge {
+	case knownScalar:
+		o.constant = (int32(rand.SharedRNG.RandRange(0, 2*aluSanitationValueSize/uint64(align))) - aluSanitationValueSize/align) * align
+	case boundedScalar:
+		bits := rand.SharedRNG.RandRange(0, 5)
+		mask := (int32(1)<<bits - 1) &^ (align - 1)
+		o.steps = append(o.steps, aluSanitationStep{op: epb.AluOperationCode_AluAnd, imm: mask})
+	}
+	steps := int(rand.SharedRNG.RandRange(0, aluSanitationMaxSteps-1))
+	for i := 0; i < steps; i++ {
+		o.steps = append(o.steps, randomAluSanitationStep(align))
+	}
+	return o
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (as *AluSanitation) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	as.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", as.programCount, as.validProgramCount)
+
+	if as.mapFd < 0 {
+		as.mapFd = ffi.CreateMapArray(aluSanitationMapSize)
+		if as.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+	as.unprivileged = rand.SharedRNG.OneOf(2)
+
+	// R6 points to element 0, R9 to element 1 and R8 holds the input read
+	// from element 0.
+	insn, err := as.lookupElement(0, R6)
+	if err != nil {
+		return nil, err
+	}
+	result, err := as.lookupElement(1, R9)
+	if err != nil {
+		return nil, err
+	}
+	insn = append(insn, result...)
+	insn = append(insn, LdDW(R8, R6, 0))
+
+	for offset := -aluSanitationRegionSize; offset < 0; offset += StackSlotSize {
+		value := int32(rand.SharedRNG.RandInt())
+		insn = append(insn, StDW(R10, value, int16(offset)))
+		binary.LittleEndian.PutUint64(as.region[aluSanitationRegionSize+offset:], uint64(int64(value)))
+	}
+
+	// R7 is the pointer the arithmetic is done on.
+	as.pointer = aluSanitationPointer(rand.SharedRNG.RandRange(0, 1))
+	if as.pointer == mapValuePointer {
+		insn = append(insn, Mov64(R7, R6))
+	} else {
+		insn = append(insn, Mov64(R7, R10), Add64(R7, -aluSanitationRegionSize/2))
+	}
+
+	// The verifier refuses misaligned accesses through stack pointers
+	// with a variable offset, keep most of them aligned to the size of the
+	// load.
+	sizes := []int{1, 2, 4, 8}
+	as.size = sizes[rand.SharedRNG.RandRange(0, uint64(len(sizes)-1))]
+	align := int32(as.size)
+	if rand.SharedRNG.OneOf(16) {
+		align = 1
+	}
+	as.offset = (int16(rand.SharedRNG.RandRange(0, 2*aluSanitationValueSize/uint64(align))) - int16(aluSanitationValueSize/align)) * int16(align)
+
+	as.operands = nil
+	operands := int(rand.SharedRNG.RandRange(1, aluSanitationMaxOperands))
+	for i := 0; i < operands; i++ {
+		o := randomOperand(align)
+		as.operands = append(as.operands, o)
+		if o.knowledge == knownScalar {
+			insn = append(insn, Mov64(R2, o.constant))
+		} else {
+			insn = append(insn, Mov64(R2, R8))
+		}
+		for _, s := range o.steps {
+			insn = append(insn, s.instruction(R2))
+		}
+		if o.subtract {
+			insn = append(insn, Sub64(R7, R2))
+		} else {
+			insn = append(insn, Add64(R7, R2))
+		}
+	}
+
+	insn = append(insn, aluSanitationLoad(as.size, R3, R7, as.offset), StDW(R9, R3, 0))
+
+	footer, err := InstructionSequence(
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &epb.Program{Instructions: append(insn, footer...)}, nil
+}
+
+// access returns the offset the program reads at, relative to the start of
+// element 0 for map value pointers and to R10 for stack pointers, once the
+// operands are computed from `input`.
+func (as *AluSanitation) access(input uint64) int64 {
+	offset := int64(as.offset)
+	if as.pointer == stackPointer {
+		offset -= aluSanitationRegionSize / 2
+	}
+	for _, o := range as.operands {
+		if o.subtract {
+			offset -= int64(o.value(input))
+		} else {
+			offset += int64(o.value(input))
+		}
+	}
+	return offset
+}
+
+// expectedRead returns the value the program reads for `input`, false if it
+// can't be known. Returns an error if the access is out of the bounds of the
+// object the pointer points to.
+func (as *AluSanitation) expectedRead(input uint64) (uint64, bool, error) {
+	offset := as.access(input)
+	var object []byte
+	var start int64
+	switch as.pointer {
+	case mapValuePointer:
+		object = binary.LittleEndian.AppendUint64(nil, input)
+	case stackPointer:
+		if offset < -StackSize || offset > -int64(as.size) {
+			return 0, false, fmt.Errorf("%d bytes read at offset %d of the stack", as.size, offset)
+		}
+		object = as.region[:]
+		start = -aluSanitationRegionSize
+	}
+
+	index := offset - start
+	// Compared without adding the size so offsets near the limits of int64
+	// don't wrap around.
+	if index < 0 || index > int64(len(object)-as.size) {
+		if as.pointer == stackPointer {
+			// Outside of the initialized region but within the frame.
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("%d bytes read at offset %d of a %d bytes map value", as.size, offset, aluSanitationValueSize)
+	}
+	value := uint64(0)
+	for i := as.size - 1; i >= 0; i-- {
+		value = value<<8 | uint64(object[index+int64(i)])
+	}
+	return value, true, nil
+}
+
+// Unprivileged returns true if the last generated program must be loaded
+// without privileges.
+func (as *AluSanitation) Unprivileged() bool {
+	return as.unprivileged
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (as *AluSanitation) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	as.validProgramCount += 1
+
+	as.input = rand.SharedRNG.RandInt()
+	if ffi.SetMapElement(as.mapFd, 0, as.input) != 0 || ffi.SetMapElement(as.mapFd, 1, 0) != 0 {
+		logging.Warningf("could not initialize the map\n")
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (as *AluSanitation) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	want, known, err := as.expectedRead(as.input)
+	if err != nil {
+		logging.Infof("verifier allowed an out of bounds access (unprivileged: %v): %v\n", as.unprivileged, err)
+		return false
+	}
+	if !known {
+		return true
+	}
+	mapElements, err := ffi.GetMapElements(as.mapFd, aluSanitationMapSize)
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	if got := mapElements.Elements[1]; got != want {
+		logging.Infof("read %#x through the pointer, want %#x (unprivileged: %v)\n", got, want, as.unprivileged)
+		return false
+	}
+	return true
+}
+
+// Maps returns the map holding the input and the value read back.
+func (as *AluSanitation) Maps() map[int]uint64 {
+	return map[int]uint64{as.mapFd: aluSanitationMapSize}
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (as *AluSanitation) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (as *AluSanitation) IsFuzzingDone() bool {
+	return as.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (as *AluSanitation) Name() string {
+	return "alu_sanitation"
+}
@@ -0,0 +1,200 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestExpectedRead(t *testing.T) {
+	mask := func(imm int32) aluSanitationStep {
+		return aluSanitationStep{op: epb.AluOperationCode_AluAnd, imm: imm}
+	}
+	var region [aluSanitationRegionSize]byte
+	for i := range region {
+		region[i] = byte(i)
+	}
+
+	tests := []struct {
+		testName  string
+		pointer   aluSanitationPointer
+		operands  []aluSanitationOperand
+		offset    int16
+		size      int
+		input     uint64
+		want      uint64
+		wantKnown bool
+		wantErr   bool
+	}{
+		{
+			testName:  "Map value read at a constant offset",
+			pointer:   mapValuePointer,
+			operands:  []aluSanitationOperand{{knowledge: knownScalar, constant: 2}},
+			offset:    1,
+			size:      2,
+			input:     0x8877665544332211,
+			want:      0x5544,
+			wantKnown: true,
+		},
+		{
+			testName: "Map value read at a masked input offset",
+			pointer:  mapValuePointer,
+			operands: []aluSanitationOperand{
+				{knowledge: boundedScalar, steps: []aluSanitationStep{mask(3)}},
+			},
+			size:      1,
+			input:     0x8877665544332206,
+			want:      0x33,
+			wantKnown: true,
+		},
+		{
+			testName: "Subtracted scalar",
+			pointer:  mapValuePointer,
+			operands: []aluSanitationOperand{
+				{knowledge: knownScalar, constant: 4, subtract: true},
+			},
+			offset:    4,
+			size:      4,
+			input:     0x8877665544332211,
+			want:      0x44332211,
+			wantKnown: true,
+		},
+		{
+			testName: "Map value read past the end",
+			pointer:  mapValuePointer,
+			operands: []aluSanitationOperand{
+				{knowledge: unboundedScalar},
+			},
+			size:    1,
+			input:   8,
+			wantErr: true,
+		},
+		{
+			testName: "Map value read at the largest offset",
+			pointer:  mapValuePointer,
+			operands: []aluSanitationOperand{
+				{knowledge: unboundedScalar},
+			},
+			size:    2,
+			input:   1<<63 - 1,
+			wantErr: true,
+		},
+		{
+			testName: "Map value read before the start",
+			pointer:  mapValuePointer,
+			operands: []aluSanitationOperand{
+				{knowledge: knownScalar, constant: -1},
+			},
+			size:    1,
+			wantErr: true,
+		},
+		{
+			testName: "Stack read in the region",
+			pointer:  stackPointer,
+			operands: []aluSanitationOperand{
+				{knowledge: boundedScalar, steps: []aluSanitationStep{mask(7), {op: epb.AluOperationCode_AluLsh, imm: 1}}},
+			},
+			size:      2,
+			input:     3,
+			want:      0x1716,
+			wantKnown: true,
+		},
+		{
+			testName: "Stack read below the region",
+			pointer:  stackPointer,
+			operands: []aluSanitationOperand{
+				{knowledge: knownScalar, constant: -aluSanitationRegionSize},
+			},
+			size: 8,
+		},
+		{
+			testName: "Stack read above the frame",
+			pointer:  stackPointer,
+			operands: []aluSanitationOperand{
+				{knowledge: knownScalar, constant: aluSanitationRegionSize / 2},
+			},
+			size:    1,
+			wantErr: true,
+		},
+		{
+			testName: "Truncated scalar",
+			pointer:  stackPointer,
+			operands: []aluSanitationOperand{
+				{knowledge: unboundedScalar, steps: []aluSanitationStep{{op: epb.AluOperationCode_AluMov}}},
+			},
+			size:      1,
+			input:     0xffffffff00000004,
+			want:      0x14,
+			wantKnown: true,
+		},
+	}
+
+	for _, c := range tests {
+		as := &AluSanitation{
+			pointer:  c.pointer,
+			operands: c.operands,
+			offset:   c.offset,
+			size:     c.size,
+			region:   region,
+		}
+		got, known, err := as.expectedRead(c.input)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: expectedRead() error = %v, want error %v", c.testName, err, c.wantErr)
+			continue
+		}
+		if known != c.wantKnown || got != c.want {
+			t.Errorf("%s: expectedRead() = %#x, %v, want %#x, %v", c.testName, got, known, c.want, c.wantKnown)
+		}
+	}
+}
+
+func TestAluSanitationOracle(t *testing.T) {
+	as := NewAluSanitationStrategy()
+	h := units.NewStrategyHarness(as)
+
+	for i := 0; i < 64; i++ {
+		corrupt := i%2 == 1
+		var want uint64
+		var known bool
+		var readErr error
+		res, err := h.Step(units.CannedResponse{
+			Validation: units.VerifierAcceptance(),
+			Execute: func(ffi *units.FFI, prog *epb.Program) *fpb.ExecutionResult {
+				want, known, readErr = as.expectedRead(as.input)
+				read := want
+				if corrupt {
+					read ^= 1
+				}
+				ffi.SetMapElement(as.mapFd, 1, read)
+				return &fpb.ExecutionResult{DidSucceed: true}
+			},
+		})
+		if err != nil {
+			t.Fatalf("Step() = %v", err)
+		}
+		switch {
+		case readErr != nil:
+			if res.Expected {
+				t.Errorf("out of bounds access %v was expected", readErr)
+			}
+		case known && res.Expected == corrupt:
+			t.Errorf("read %#x instead of %#x (corrupted: %v), expected = %v", want^1, want, corrupt, res.Expected)
+		}
+	}
+}
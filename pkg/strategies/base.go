@@ -20,8 +20,9 @@ package strategies
 import (
 	"encoding/binary"
 	"errors"
-	"fmt"
 	"os"
+
+	"buzzer/pkg/logging/logging"
 )
 
 // GeneratorResult holds the state of generated programs that have been verified.
@@ -38,7 +39,7 @@ func WriteLogFile(data []byte) error {
 		return err
 	}
 
-	fmt.Printf("Writing verifier log to %q.\n", f.Name())
+	logging.Infof("Writing verifier log to %q.\n", f.Name())
 	_, err = f.Write(data)
 	return errors.Join(err, f.Close())
 }
@@ -51,7 +52,7 @@ func WriteProgFile(data []uint64) error {
 		return err
 	}
 
-	fmt.Printf("Writing eBPF binary to %q.\n", f.Name())
+	logging.Infof("Writing eBPF binary to %q.\n", f.Name())
 
 	out := []byte{}
 	b := make([]byte, 8)
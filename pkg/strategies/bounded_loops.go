@@ -0,0 +1,240 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"fmt"
+)
+
+const (
+	// Default limits of the generated loops, see SetLoopLimits. Nesting is
+	// also limited by the registers available for the counters.
+	defaultLoopMaxTripCount = 64
+	defaultLoopMaxDepth     = 3
+
+	// R6 counts the iterations of the innermost body, the counters of the
+	// loops, from the outermost one, are R7 to R9 and the stack slots
+	// below R10 when spilled.
+	loopIterationsReg = R6
+	loopFirstCounter  = R7
+
+	// Stack slot of the key of the map the iterations are stored in.
+	loopKeyOffset = -32
+)
+
+func NewBoundedLoopsStrategy() *BoundedLoops {
+	return &BoundedLoops{
+		isFinished:   false,
+		mapFd:        -1,
+		maxTripCount: defaultLoopMaxTripCount,
+		maxDepth:     defaultLoopMaxDepth,
+	}
+}
+
+// BoundedLoops is a strategy that targets the bounded loop support of the
+// verifier, see LoopOperation, and the pruning of the states it explores at
+// every iteration. Programs nest loops with random trip counts, counters in
+// registers or spilled to the stack and bodies that branch, and count the
+// iterations of the innermost loop. Some loops never update their counter
+// and must be rejected.
+//
+// After every execution the count stored in the map must be the product of
+// the trip counts.
+type BoundedLoops struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+
+	maxTripCount int32
+	maxDepth     int
+
+	// Outermost loop of the last generated program.
+	loop *LoopOperation
+}
+
+// SetLoopLimits sets the highest trip count and the deepest nesting of the
+// generated loops. Limits out of range are ignored.
+func (bl *BoundedLoops) SetLoopLimits(maxTripCount int32, maxDepth int) {
+	if maxTripCount > 0 {
+		bl.maxTripCount = maxTripCount
+	}
+	if maxDepth > 0 && maxDepth <= defaultLoopMaxDepth {
+		bl.maxDepth = maxDepth
+	}
+}
+
+// randomLoopBody returns a few instructions that update R2 to R5, some of
+// them only on some iterations so the states of the iterations differ.
+func randomLoopBody() []*epb.Instruction {
+	var body []*epb.Instruction
+	for i := rand.SharedRNG.RandRange(0, 3); i > 0; i-- {
+		reg := epb.Reg(rand.SharedRNG.RandRange(uint64(R2), uint64(R5)))
+		if rand.SharedRNG.OneOf(2) {
+			body = append(body, JmpSET(loopIterationsReg, int32(1)<<rand.SharedRNG.RandRange(0, 3), 1))
+		}
+		body = append(body, Add64(reg, int32(rand.SharedRNG.RandRange(1, 16))))
+	}
+	return body
+}
+
+// randomLoop returns loops nested `depth` times, the outermost one using
+// counter `counter`.
+func (bl *BoundedLoops) randomLoop(counter epb.Reg, depth int) *LoopOperation {
+	loop := &LoopOperation{
+		Counter: counter,
+		Spilled: rand.SharedRNG.OneOf(2),
+		// One slot per counter.
+		StackOffset: -8 * int16(counter-loopFirstCounter+1),
+		Body:        randomLoopBody(),
+	}
+	loop.TripCount = int32(rand.SharedRNG.RandRange(1, uint64(bl.maxTripCount)))
+	if rand.SharedRNG.OneOf(4) {
+		// Mostly short loops, the verifier walks every iteration.
+		loop.TripCount = int32(rand.SharedRNG.RandRange(1, 8))
+	}
+	if depth > 1 {
+		loop.Inner = bl.randomLoop(counter+1, depth-1)
+	} else {
+		loop.Body = append(loop.Body, Add64(loopIterationsReg, 1))
+	}
+	return loop
+}
+
+// program returns the instructions of the last generated program.
+func (bl *BoundedLoops) program() (*epb.Program, error) {
+	b := NewProgramBuilder().Mov64(loopIterationsReg, 0)
+	for reg := R2; reg <= R5; reg++ {
+		b.Mov64(reg, int32(rand.SharedRNG.RandRange(0, 0xff)))
+	}
+	bl.loop.Emit(b, "loop")
+	return b.StW(R10, 0, loopKeyOffset).
+		Mov64(R2, R10).Add64(R2, loopKeyOffset).
+		LdMapByFd(R1, bl.mapFd).
+		Call(MapLookup).
+		JmpEQ(R0, 0, "out").
+		StDW(R0, loopIterationsReg, 0).
+		Label("out").Mov64(R0, 0).Exit().
+		Build()
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (bl *BoundedLoops) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	bl.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", bl.programCount, bl.validProgramCount)
+
+	if bl.mapFd < 0 {
+		bl.mapFd = ffi.CreateMapArray(1)
+		if bl.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	bl.loop = bl.randomLoop(loopFirstCounter, int(rand.SharedRNG.RandRange(1, uint64(bl.maxDepth))))
+	if rand.SharedRNG.OneOf(8) {
+		unbounded := bl.loop
+		for i := rand.SharedRNG.RandRange(0, uint64(bl.loop.Depth()-1)); i > 0; i-- {
+			unbounded = unbounded.Inner
+		}
+		unbounded.Unbounded = true
+	}
+	return bl.program()
+}
+
+// Decisions returns the shape of the loops of the last program for the
+// decision log.
+func (bl *BoundedLoops) Decisions() []string {
+	var decisions []string
+	for loop := bl.loop; loop != nil; loop = loop.Inner {
+		decision := fmt.Sprintf("%d trips", loop.TripCount)
+		if loop.Spilled {
+			decision += ", spilled counter"
+		}
+		if loop.Unbounded {
+			decision += ", unbounded"
+		}
+		decisions = append(decisions, decision)
+	}
+	return decisions
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (bl *BoundedLoops) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	bl.validProgramCount += 1
+	if bl.loop.Iterations() < 0 {
+		logging.Infof("verifier accepted a program with an unbounded loop\n")
+	}
+	if ffi.SetMapElement(bl.mapFd, 0, 0) != 0 {
+		logging.Warningf("could not clear the iteration count\n")
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (bl *BoundedLoops) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	want := bl.loop.Iterations()
+	if want < 0 {
+		// Reaching this point is already a bug.
+		return false
+	}
+	if ffi.Maps != nil {
+		// Fake maps are never written to by programs.
+		return true
+	}
+	mapElements, err := ffi.GetMapElements(bl.mapFd, 1)
+	if err == nil && mapElements.GetErrorMessage() != "" {
+		err = fmt.Errorf("%s", mapElements.GetErrorMessage())
+	}
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	if got := mapElements.GetElements()[0]; got != uint64(want) {
+		logging.Infof("innermost loop ran %d times, want %d\n", got, want)
+		return false
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (bl *BoundedLoops) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (bl *BoundedLoops) IsFuzzingDone() bool {
+	return bl.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (bl *BoundedLoops) Name() string {
+	return "bounded_loops"
+}
@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+)
+
+func TestBoundedLoops(t *testing.T) {
+	ffi := &units.FFI{Maps: units.NewFakeMaps()}
+	bl := NewBoundedLoopsStrategy()
+	bl.SetLoopLimits(10, 2)
+	for i := 0; i < 100; i++ {
+		prog, err := bl.GenerateProgram(ffi)
+		if err != nil {
+			t.Fatalf("GenerateProgram() failed: %v", err)
+		}
+		if depth := bl.loop.Depth(); depth > 2 {
+			t.Fatalf("loops nested %d times, want at most 2", depth)
+		}
+		for loop := bl.loop; loop != nil; loop = loop.Inner {
+			if loop.TripCount < 1 || loop.TripCount > 10 {
+				t.Errorf("trip count %d, want 1 to 10", loop.TripCount)
+			}
+		}
+		// Every loop ends with a jump back to its head.
+		backward := 0
+		for _, insn := range prog.Instructions {
+			if IsRelativeJump(insn) && insn.Offset < 0 {
+				backward++
+			}
+		}
+		if backward != bl.loop.Depth() {
+			t.Errorf("%d backward jumps, want one per loop: %v", backward, prog.Instructions)
+		}
+	}
+}
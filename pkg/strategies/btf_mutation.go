@@ -0,0 +1,148 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"buzzer/pkg/btf/btf"
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"strings"
+)
+
+const (
+	// Maximum number of random ALU instructions of the generated programs.
+	btfMutationMaxBody = 32
+
+	// Maximum number of mutations applied to the BTF of a program.
+	btfMutationMaxMutations = 4
+)
+
+func NewBTFMutationStrategy() *BTFMutation {
+	return &BTFMutation{isFinished: false}
+}
+
+// BTFMutation is a strategy that fuzzes the BTF parser of the kernel and the
+// checks the verifier does on the func and line info of programs. Every
+// program comes with minimal valid BTF, see btf.ForProgram, that is then
+// mutated most of the time. The programs are simple, sometimes with a second
+// function, the BTF is what changes. Bugs show up as kernel splats or as
+// coverage of the parser.
+type BTFMutation struct {
+	isFinished bool
+	programBTF *btf.Program
+
+	// mutations applied to programBTF, empty if it was left valid.
+	mutations []string
+
+	programCount      int
+	btfAcceptedCount  int
+	validProgramCount int
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (bm *BTFMutation) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	bm.programCount += 1
+	logging.Debugf("Generated %d programs, %d with accepted BTF, %d were valid               \r", bm.programCount, bm.btfAcceptedCount, bm.validProgramCount)
+
+	insn := []*epb.Instruction{}
+	for reg := R0; reg <= R9; reg++ {
+		insn = append(insn, Mov64(reg, int32(rand.SharedRNG.RandInt())))
+	}
+	for i := rand.SharedRNG.RandRange(0, btfMutationMaxBody); i > 0; i-- {
+		insn = append(insn, RandomAluInstruction())
+	}
+	subprog := rand.SharedRNG.OneOf(2)
+	if subprog {
+		// Jump over the end of the main function.
+		insn = append(insn, CallLocal(2))
+	}
+	insn = append(insn, Mov64(R0, 0), Exit())
+	if subprog {
+		insn = append(insn, Mov64(R0, int32(rand.SharedRNG.RandInt())), Exit())
+	}
+	prog := &epb.Program{Instructions: insn}
+
+	b, err := btf.ForProgram(prog)
+	if err != nil {
+		return nil, err
+	}
+	bm.mutations = nil
+	// Keep some valid BTF around so the verifier also goes through the
+	// checks that follow a successful parse.
+	if !rand.SharedRNG.OneOf(8) {
+		bm.mutations = btf.Mutate(b, btfMutationMaxMutations)
+	}
+	bm.programBTF = b
+	return prog, nil
+}
+
+// ProgramBTF returns the BTF of the last generated program.
+func (bm *BTFMutation) ProgramBTF() *btf.Program {
+	return bm.programBTF
+}
+
+// Decisions returns the mutations applied to the BTF of the last program
+// for the decision log.
+func (bm *BTFMutation) Decisions() []string {
+	if len(bm.mutations) == 0 {
+		return []string{"valid BTF"}
+	}
+	return bm.mutations
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (bm *BTFMutation) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !strings.HasPrefix(verificationResult.BpfError, "BTF: ") {
+		bm.btfAcceptedCount += 1
+	} else if len(bm.mutations) == 0 {
+		// Not a kernel bug, but every mutated program is then meaningless.
+		logging.Warningf("Unmutated BTF was rejected: %s\n%s\n", verificationResult.BpfError, verificationResult.VerifierLog)
+	}
+	if !verificationResult.IsValid {
+		return false
+	}
+	bm.validProgramCount += 1
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (bm *BTFMutation) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (bm *BTFMutation) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (bm *BTFMutation) IsFuzzingDone() bool {
+	return bm.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (bm *BTFMutation) Name() string {
+	return "btf_mutation"
+}
@@ -0,0 +1,335 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"buzzer/pkg/btf/btf"
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"fmt"
+)
+
+const (
+	// Number of elements of the map bpf_for_each_map_elem iterates over.
+	callbackIterMapSize = 8
+
+	// BPF_MAX_LOOPS, bpf_loop refuses to iterate more.
+	callbackMaxLoops = 1 << 23
+
+	// Errors bpf_loop returns.
+	errE2BIG  = -7
+	errEINVAL = -22
+
+	// The callback counts its calls in the stack slot of the caller passed
+	// as its context, the caller then stores what the helper returned and
+	// the count at index 0 and 1 of the result map.
+	callbackCountOffset = -8
+	callbackKeyOffset   = -12
+	callbackResultReg   = R6
+	callbackCountReg    = R7
+)
+
+// callbackKind is how the generated callback breaks its contract.
+type callbackKind int
+
+const (
+	// The callback follows its prototype. The verifier must accept these
+	// programs and the helper must call the callback as many times as
+	// expected.
+	validCallback callbackKind = iota
+
+	// The other kinds break the prototype, the verifier must reject them.
+
+	// The callback returns a value out of the range the helper accepts.
+	callbackReturnOutOfRange
+	// The callback does not set R0.
+	callbackUninitializedReturn
+	// The callback reads a register the helper does not set.
+	callbackExtraParam
+	// The callback writes past the stack slot of the caller it is given.
+	callbackCtxOutOfBounds
+	// The callback writes to the key of the map element, which is read
+	// only.
+	callbackKeyWrite
+	// The helper is given a number instead of the callback.
+	callbackScalarFunc
+
+	numCallbackKinds
+)
+
+var callbackKindNames = []string{
+	"valid callback",
+	"return out of range",
+	"uninitialized return",
+	"extra parameter",
+	"context out of bounds",
+	"key write",
+	"scalar instead of a callback",
+}
+
+// callbackHelpers are the helpers whose callbacks the strategy generates.
+var callbackHelpers = []int32{Loop, ForEachMapElem}
+
+func NewCallbacksStrategy() *Callbacks {
+	return &Callbacks{isFinished: false, resultFd: -1, iterFd: -1}
+}
+
+// Callbacks is a strategy that targets the verification of the callbacks
+// of helpers like bpf_loop and bpf_for_each_map_elem: the callback is a
+// separate function of the program, loaded with LdFunc, that the verifier
+// checks against the prototype of the helper, see CallbackPrototype. Most
+// callbacks follow the prototype, the others return a value out of range,
+// read registers the helper doesn't set or write where they shouldn't, and
+// must be rejected.
+//
+// The callback counts its calls, after every execution the count and what
+// the helper returned must match the number of iterations the helper was
+// asked for.
+type Callbacks struct {
+	isFinished        bool
+	programCount      int
+	validProgramCount int
+
+	// resultFd is where the programs store their results, iterFd the map
+	// bpf_for_each_map_elem iterates over.
+	resultFd int
+	iterFd   int
+
+	// Helper, kind and parameters of the last generated program and its
+	// BTF.
+	proto      *CallbackPrototype
+	kind       callbackKind
+	loops      int32
+	flags      int32
+	returns    int32
+	programBTF *btf.Program
+}
+
+// randomCallbackKind returns a kind that applies to the callback of `cp`.
+func randomCallbackKind(cp *CallbackPrototype) callbackKind {
+	for {
+		kind := callbackKind(rand.SharedRNG.RandRange(1, uint64(numCallbackKinds-1)))
+		if _, hasKey := cp.ParamReg(ParamMapKey); kind != callbackKeyWrite || hasKey {
+			return kind
+		}
+	}
+}
+
+// callback returns the instructions of the callback, they are only valid
+// for validCallback.
+func (cb *Callbacks) callback() []*epb.Instruction {
+	ctx, _ := cb.proto.ParamReg(ParamCtx)
+	// The callback only has up to 4 parameters, R5 is never set.
+	var insn []*epb.Instruction
+	if cb.kind == callbackExtraParam {
+		insn = append(insn, Mov64(R0, R5))
+	}
+	insn = append(insn,
+		LdDW(R5, ctx, 0),
+		Add64(R5, 1),
+		StDW(ctx, R5, 0))
+	switch cb.kind {
+	case callbackCtxOutOfBounds:
+		insn = append(insn, StDW(ctx, R5, -callbackCountOffset))
+	case callbackKeyWrite:
+		key, _ := cb.proto.ParamReg(ParamMapKey)
+		insn = append(insn, StW(key, 0, 0))
+	}
+	switch cb.kind {
+	case callbackReturnOutOfRange:
+		insn = append(insn, Mov64(R0, int32(rand.SharedRNG.RandRange(uint64(cb.proto.MaxReturn)+1, 0xff))))
+	case callbackUninitializedReturn:
+	default:
+		insn = append(insn, Mov64(R0, cb.returns))
+	}
+	return append(insn, Exit())
+}
+
+// storeResult returns the store of `reg` at index `key` of the result map.
+func (cb *Callbacks) storeResult(b *ProgramBuilder, key int32, reg epb.Reg) *ProgramBuilder {
+	return b.StW(R10, key, callbackKeyOffset).
+		Mov64(R2, R10).Add64(R2, callbackKeyOffset).
+		LdMapByFd(R1, cb.resultFd).
+		Call(MapLookup).
+		JmpEQ(R0, 0, "out").
+		StDW(R0, reg, 0)
+}
+
+// program returns the instructions of the last generated program.
+func (cb *Callbacks) program() (*epb.Program, error) {
+	b := NewProgramBuilder().StDW(R10, 0, callbackCountOffset)
+	switch cb.proto.HelperID {
+	case Loop:
+		b.Mov64(R1, cb.loops)
+	case ForEachMapElem:
+		b.LdMapByFd(R1, cb.iterFd)
+	}
+	if cb.kind == callbackScalarFunc {
+		b.Mov64(R2, 0)
+	} else {
+		b.LdFunc(R2, "callback")
+	}
+	b.Mov64(R3, R10).Add64(R3, callbackCountOffset).
+		Mov64(R4, cb.flags).
+		Call(cb.proto.HelperID).
+		Mov64(callbackResultReg, R0).
+		LdDW(callbackCountReg, R10, callbackCountOffset)
+	cb.storeResult(b, 0, callbackResultReg)
+	cb.storeResult(b, 1, callbackCountReg)
+	return b.Label("out").Mov64(R0, 0).Exit().
+		Label("callback").Insn(cb.callback()...).
+		Build()
+}
+
+// expected returns what the helper of the last program returns and how many
+// times it calls the callback.
+func (cb *Callbacks) expected() (int64, int64) {
+	iterations := int64(cb.loops)
+	switch {
+	case cb.proto.HelperID == ForEachMapElem:
+		iterations = callbackIterMapSize
+	case cb.flags != 0:
+		return errEINVAL, 0
+	case cb.loops > callbackMaxLoops:
+		return errE2BIG, 0
+	}
+	// Returning 1 stops the iterations.
+	if cb.returns == 1 && iterations > 1 {
+		iterations = 1
+	}
+	return iterations, iterations
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (cb *Callbacks) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	cb.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", cb.programCount, cb.validProgramCount)
+
+	if cb.resultFd < 0 {
+		cb.resultFd = ffi.CreateMapArray(2)
+		cb.iterFd = ffi.CreateMapArray(callbackIterMapSize)
+		if cb.resultFd < 0 || cb.iterFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	cb.proto = CallbackPrototypeByID(callbackHelpers[rand.SharedRNG.RandRange(0, uint64(len(callbackHelpers)-1))])
+	// Half of the callbacks are valid so the verifier also goes past the
+	// callback checks.
+	cb.kind = validCallback
+	if rand.SharedRNG.OneOf(2) {
+		cb.kind = randomCallbackKind(cb.proto)
+	}
+	cb.loops = int32(rand.SharedRNG.RandRange(0, 64))
+	if rand.SharedRNG.OneOf(16) {
+		cb.loops = callbackMaxLoops + int32(rand.SharedRNG.RandRange(0, 1))
+	}
+	cb.flags = 0
+	if cb.proto.HelperID == Loop && rand.SharedRNG.OneOf(16) {
+		cb.flags = 1
+	}
+	cb.returns = int32(rand.SharedRNG.RandRange(0, uint64(cb.proto.MaxReturn)))
+
+	prog, err := cb.program()
+	if err != nil {
+		return nil, err
+	}
+	// The verifier wants callbacks to be static functions described by
+	// BTF.
+	cb.programBTF, err = btf.ForProgram(prog)
+	if err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// ProgramBTF returns the BTF of the last generated program.
+func (cb *Callbacks) ProgramBTF() *btf.Program {
+	return cb.programBTF
+}
+
+// Decisions returns the helper and the kind of the last program for the
+// decision log.
+func (cb *Callbacks) Decisions() []string {
+	return []string{cb.proto.Helper().Name, callbackKindNames[cb.kind]}
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (cb *Callbacks) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	cb.validProgramCount += 1
+	if cb.kind != validCallback {
+		logging.Infof("verifier accepted a %s callback with a %s\n", cb.proto.Helper().Name, callbackKindNames[cb.kind])
+	}
+	if ffi.SetMapElement(cb.resultFd, 0, 0) != 0 || ffi.SetMapElement(cb.resultFd, 1, 0) != 0 {
+		logging.Warningf("could not clear the results\n")
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (cb *Callbacks) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if cb.kind != validCallback {
+		// Reaching this point is already a bug.
+		return false
+	}
+	if ffi.Maps != nil {
+		// Fake maps are never written to by programs.
+		return true
+	}
+	mapElements, err := ffi.GetMapElements(cb.resultFd, 2)
+	if err == nil && mapElements.GetErrorMessage() != "" {
+		err = fmt.Errorf("%s", mapElements.GetErrorMessage())
+	}
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	wantResult, wantCount := cb.expected()
+	result, count := int64(mapElements.GetElements()[0]), int64(mapElements.GetElements()[1])
+	if result != wantResult || count != wantCount {
+		logging.Infof("%s returned %d and called the callback %d times, want %d and %d\n", cb.proto.Helper().Name, result, count, wantResult, wantCount)
+		return false
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (cb *Callbacks) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (cb *Callbacks) IsFuzzingDone() bool {
+	return cb.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (cb *Callbacks) Name() string {
+	return "callbacks"
+}
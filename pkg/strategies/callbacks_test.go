@@ -0,0 +1,69 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+)
+
+func TestCallbacksExpected(t *testing.T) {
+	tests := []struct {
+		testName   string
+		helper     int32
+		loops      int32
+		flags      int32
+		returns    int32
+		wantResult int64
+		wantCount  int64
+	}{
+		{"Loop to the end", Loop, 10, 0, 0, 10, 10},
+		{"Loop stopped by the callback", Loop, 10, 0, 1, 1, 1},
+		{"No loop", Loop, 0, 0, 1, 0, 0},
+		{"Loop with flags", Loop, 10, 1, 0, errEINVAL, 0},
+		{"Too many loops", Loop, callbackMaxLoops + 1, 0, 0, errE2BIG, 0},
+		{"Every map element", ForEachMapElem, 0, 0, 0, callbackIterMapSize, callbackIterMapSize},
+		{"First map element", ForEachMapElem, 0, 0, 1, 1, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			cb := &Callbacks{proto: CallbackPrototypeByID(tc.helper), loops: tc.loops, flags: tc.flags, returns: tc.returns}
+			if result, count := cb.expected(); result != tc.wantResult || count != tc.wantCount {
+				t.Errorf("expected() = %d, %d, want %d, %d", result, count, tc.wantResult, tc.wantCount)
+			}
+		})
+	}
+}
+
+func TestCallbacksProgram(t *testing.T) {
+	ffi := &units.FFI{Maps: units.NewFakeMaps()}
+	cb := NewCallbacksStrategy()
+	for i := 0; i < 50; i++ {
+		prog, err := cb.GenerateProgram(ffi)
+		if err != nil {
+			t.Fatalf("GenerateProgram() failed: %v", err)
+		}
+		// main and the callback, unless the helper is given a number.
+		want := 2
+		if cb.kind == callbackScalarFunc {
+			want = 1
+		}
+		if got := len(cb.ProgramBTF().FuncInfo); got != want {
+			t.Errorf("%s program has %d functions, want %d: %v", callbackKindNames[cb.kind], got, want, prog.Instructions)
+		}
+	}
+}
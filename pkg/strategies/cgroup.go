@@ -0,0 +1,200 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"fmt"
+)
+
+// Maximum number of context accesses of a program.
+const cgroupMaxAccesses = 8
+
+// cgroupContextFields are the offsets of the 4 byte fields of the context
+// of each program type the programs read and write, struct __sk_buff for
+// CGROUP_SKB and struct bpf_sock for CGROUP_SOCK.
+var cgroupContextFields = map[int]struct{ readable, writable []int16 }{
+	units.ProgTypeCgroupSkb: {
+		// len, pkt_type, mark, queue_mapping, protocol, priority,
+		// ingress_ifindex, ifindex, cb[0-4], hash, family, remote_ip4,
+		// local_ip4, remote_port and local_port.
+		readable: []int16{0, 4, 8, 12, 16, 32, 36, 40, 48, 52, 56, 60, 64, 68, 88, 92, 96, 132, 136},
+		// mark, priority and cb[0-4].
+		writable: []int16{8, 32, 48, 52, 56, 60, 64},
+	},
+	units.ProgTypeCgroupSock: {
+		// bound_dev_if, family, type, protocol, mark and priority, the
+		// addresses are only there after bind.
+		readable: []int16{0, 4, 8, 12, 16, 20},
+		// mark and priority, binding the socket to a device would cut
+		// it from the loopback interface.
+		writable: []int16{16, 20},
+	},
+}
+
+var cgroupProgramTypeNames = map[int]string{
+	units.ProgTypeCgroupSkb:  "cgroup_skb",
+	units.ProgTypeCgroupSock: "cgroup_sock",
+}
+
+func NewCgroupStrategy() *Cgroup {
+	return &Cgroup{isFinished: false}
+}
+
+// Cgroup is a strategy that generates BPF_PROG_TYPE_CGROUP_SKB and
+// CGROUP_SOCK programs, half of each. They fold random fields of their
+// context into R7, write some of it back to the writable fields and
+// decide whether to let the packet or the socket through from it, a
+// quarter of them let everything through or nothing regardless.
+//
+// The programs are meant for the cgroup harness of the control unit, see
+// units.CgroupHarness, which attaches, replaces and detaches them in a
+// cgroup around traffic. Without it CGROUP_SKB programs are test run and
+// CGROUP_SOCK ones can't be executed.
+type Cgroup struct {
+	isFinished        bool
+	programCount      int
+	validProgramCount int
+
+	// State of the last generated program: its type, the number of
+	// context accesses and, if the verdict does not depend on the
+	// context, the value it returns.
+	progType        int
+	accesses        int
+	constantVerdict bool
+	verdict         int32
+}
+
+// randomCgroupField returns one of `fields` at random.
+func randomCgroupField(fields []int16) int16 {
+	return fields[rand.SharedRNG.RandRange(0, uint64(len(fields)-1))]
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (cg *Cgroup) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	cg.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", cg.programCount, cg.validProgramCount)
+
+	cg.progType = units.ProgTypeCgroupSkb
+	if rand.SharedRNG.OneOf(2) {
+		cg.progType = units.ProgTypeCgroupSock
+	}
+	fields := cgroupContextFields[cg.progType]
+
+	// R6 holds the context and R7 what was read from it.
+	insn := []*epb.Instruction{Mov64(R6, R1), Mov64(R7, 0)}
+	cg.accesses = int(rand.SharedRNG.RandRange(1, cgroupMaxAccesses))
+	for i := 0; i < cg.accesses; i++ {
+		if rand.SharedRNG.OneOf(3) {
+			insn = append(insn, StW(R6, R7, randomCgroupField(fields.writable)))
+			continue
+		}
+		insn = append(insn, LdW(R8, R6, randomCgroupField(fields.readable)))
+		switch rand.SharedRNG.RandRange(0, 2) {
+		case 0:
+			insn = append(insn, Add64(R7, R8))
+		case 1:
+			insn = append(insn, Xor64(R7, R8))
+		default:
+			insn = append(insn, Or64(R7, R8))
+		}
+	}
+
+	// The programs may only return 0 or 1.
+	cg.constantVerdict = rand.SharedRNG.OneOf(4)
+	if cg.constantVerdict {
+		cg.verdict = int32(rand.SharedRNG.RandRange(units.CgroupBlocked, units.CgroupPassed))
+		insn = append(insn, Mov64(R0, cg.verdict))
+	} else {
+		insn = append(insn,
+			Mov64(R0, units.CgroupBlocked),
+			JmpGT(R7, int32(rand.SharedRNG.RandInt()), 1),
+			Mov64(R0, units.CgroupPassed))
+	}
+	insn = append(insn, Exit())
+	return &epb.Program{Instructions: insn}, nil
+}
+
+// ProgramType returns the type the last program is loaded as.
+func (cg *Cgroup) ProgramType() int {
+	return cg.progType
+}
+
+// Decisions returns the type and the verdict of the last program for the
+// decision log.
+func (cg *Cgroup) Decisions() []string {
+	verdict := "verdict from the context"
+	if cg.constantVerdict {
+		verdict = fmt.Sprintf("constant verdict %d", cg.verdict)
+	}
+	return []string{
+		cgroupProgramTypeNames[cg.progType],
+		fmt.Sprintf("%d context accesses", cg.accesses),
+		verdict,
+	}
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (cg *Cgroup) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	cg.validProgramCount += 1
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+//
+// The cgroup harness reports whether the traffic got through, which for
+// programs with a constant verdict is the value they return, as is the
+// return value of a test run. Another outcome means something else than the
+// program decided, e.g. a stale entry of the effective program array left
+// by the replacements.
+func (cg *Cgroup) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if !cg.constantVerdict || !executionResult.GetDidSucceed() {
+		return true
+	}
+	if got := executionResult.GetRetval(); got != uint32(cg.verdict) {
+		logging.Infof("%s program with verdict %d got %d\n", cgroupProgramTypeNames[cg.progType], cg.verdict, got)
+		return false
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (cg *Cgroup) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (cg *Cgroup) IsFuzzingDone() bool {
+	return cg.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (cg *Cgroup) Name() string {
+	return "cgroup"
+}
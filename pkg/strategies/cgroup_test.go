@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"slices"
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestCgroupProgramsAccessTheirContext(t *testing.T) {
+	cg := NewCgroupStrategy()
+	seen := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		prog, err := cg.GenerateProgram(nil)
+		if err != nil {
+			t.Fatalf("GenerateProgram() = %v, want nil error", err)
+		}
+		if _, err := EncodeInstructions(prog); err != nil {
+			t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+		}
+		seen[cg.ProgramType()] = true
+		fields, ok := cgroupContextFields[cg.ProgramType()]
+		if !ok {
+			t.Fatalf("program of type %d, want a cgroup program", cg.ProgramType())
+		}
+		for index, in := range prog.Instructions {
+			switch in.GetMemOpcode().GetInstructionClass() {
+			case epb.InsClass_InsClassLdx:
+				if in.SrcReg != R6 || !slices.Contains(fields.readable, int16(in.Offset)) {
+					t.Fatalf("instruction %d reads outside of the readable fields: %v", index, in)
+				}
+			case epb.InsClass_InsClassStx, epb.InsClass_InsClassSt:
+				if in.DstReg != R6 || !slices.Contains(fields.writable, int16(in.Offset)) {
+					t.Fatalf("instruction %d writes outside of the writable fields: %v", index, in)
+				}
+			}
+		}
+		if last := prog.Instructions[len(prog.Instructions)-1]; last.GetJmpOpcode().GetOperationCode() != epb.JmpOperationCode_JmpExit {
+			t.Fatalf("program ends with %v, want exit", last)
+		}
+	}
+	if !seen[units.ProgTypeCgroupSkb] || !seen[units.ProgTypeCgroupSock] {
+		t.Errorf("generated program types %v, want both CGROUP_SKB and CGROUP_SOCK", seen)
+	}
+}
+
+func TestCgroupOnExecuteDone(t *testing.T) {
+	tests := []struct {
+		testName        string
+		constantVerdict bool
+		verdict         int32
+		execution       *fpb.ExecutionResult
+		want            bool
+	}{
+		{
+			testName:        "Verdict kept",
+			constantVerdict: true,
+			verdict:         units.CgroupBlocked,
+			execution:       &fpb.ExecutionResult{DidSucceed: true, Retval: units.CgroupBlocked},
+			want:            true,
+		},
+		{
+			testName:        "Verdict not kept",
+			constantVerdict: true,
+			verdict:         units.CgroupBlocked,
+			execution:       &fpb.ExecutionResult{DidSucceed: true, Retval: units.CgroupPassed},
+			want:            false,
+		},
+		{
+			testName:        "Failed execution",
+			constantVerdict: true,
+			verdict:         units.CgroupPassed,
+			execution:       &fpb.ExecutionResult{},
+			want:            true,
+		},
+		{
+			testName:  "Verdict from the context",
+			execution: &fpb.ExecutionResult{DidSucceed: true, Retval: units.CgroupPassed},
+			want:      true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			cg := &Cgroup{progType: units.ProgTypeCgroupSkb, constantVerdict: tc.constantVerdict, verdict: tc.verdict}
+			if got := cg.OnExecuteDone(nil, tc.execution); got != tc.want {
+				t.Errorf("OnExecuteDone() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"buzzer/pkg/cbpf/cbpf"
+	"buzzer/pkg/rand"
+)
+
+// randomAluInstruction returns an ALU instruction the classic checker
+// accepts: no division by a zero constant and no constant shift by 32 or
+// more. Seccomp does not allow modulo at all, it is only picked if
+// `allowModulo` is true.
+func randomAluInstruction(allowModulo bool) cbpf.Instruction {
+	op := cbpf.AluOperations[rand.SharedRNG.RandRange(0, uint64(len(cbpf.AluOperations)-1))]
+	for op == cbpf.AluMod && !allowModulo {
+		op = cbpf.AluOperations[rand.SharedRNG.RandRange(0, uint64(len(cbpf.AluOperations)-1))]
+	}
+	if rand.SharedRNG.OneOf(2) {
+		return cbpf.AluInstruction(op, cbpf.X)
+	}
+	k := uint32(rand.SharedRNG.RandInt())
+	switch op {
+	case cbpf.AluDiv, cbpf.AluMod:
+		if k == 0 {
+			k = 1
+		}
+	case cbpf.AluLsh, cbpf.AluRsh:
+		k %= 32
+	}
+	return cbpf.AluInstruction(op, k)
+}
+
+// classicFilterState is what the generators of cBPF instructions need to
+// know about the instructions generated before.
+type classicFilterState struct {
+	// Scratch memory slots written on every path, the classic checker
+	// refuses loads from any other slot.
+	initialized map[uint32]bool
+
+	// Whether there was a jump before, stores that come after it might be
+	// skipped.
+	branched bool
+}
+
+func newClassicFilterState() *classicFilterState {
+	return &classicFilterState{initialized: make(map[uint32]bool)}
+}
+
+// randomStore returns a store of A or X to a random scratch memory slot.
+func (s *classicFilterState) randomStore() cbpf.Instruction {
+	slot := uint32(rand.SharedRNG.RandRange(0, cbpf.ScratchSlots-1))
+	if !s.branched {
+		s.initialized[slot] = true
+	}
+	if rand.SharedRNG.OneOf(2) {
+		return cbpf.St(slot)
+	}
+	return cbpf.Stx(slot)
+}
+
+// randomLoad returns a load into A or X of a random scratch memory slot, `ok`
+// is false if the slot picked was not written on every path.
+func (s *classicFilterState) randomLoad() (insn cbpf.Instruction, ok bool) {
+	slot := uint32(rand.SharedRNG.RandRange(0, cbpf.ScratchSlots-1))
+	if !s.initialized[slot] {
+		return cbpf.Instruction{}, false
+	}
+	if rand.SharedRNG.OneOf(2) {
+		return cbpf.LdMem(slot), true
+	}
+	return cbpf.LdxMem(slot), true
+}
+
+// randomJump returns a random jump that lands at most `remaining`
+// instructions after it.
+func randomJump(remaining int) cbpf.Instruction {
+	maxOffset := uint64(remaining - 1)
+	if rand.SharedRNG.OneOf(4) {
+		return cbpf.Ja(uint32(rand.SharedRNG.RandRange(0, maxOffset)))
+	}
+	maxOffset = min(maxOffset, 0xff)
+	op := cbpf.ConditionalJumpOperations[rand.SharedRNG.RandRange(0, uint64(len(cbpf.ConditionalJumpOperations)-1))]
+	jt := uint8(rand.SharedRNG.RandRange(0, maxOffset))
+	jf := uint8(rand.SharedRNG.RandRange(0, maxOffset))
+	if rand.SharedRNG.OneOf(2) {
+		return cbpf.JmpInstruction(op, cbpf.X, jt, jf)
+	}
+	return cbpf.JmpInstruction(op, uint32(rand.SharedRNG.RandInt()), jt, jf)
+}
@@ -2,12 +2,12 @@ package strategies
 
 import (
 	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
 	"buzzer/pkg/rand"
 	"buzzer/pkg/units/units"
 	epb "buzzer/proto/ebpf_go_proto"
 	fpb "buzzer/proto/ffi_go_proto"
 	"errors"
-	"fmt"
 	protobuf "github.com/golang/protobuf/proto"
 )
 
@@ -20,9 +20,6 @@ const (
 	OPERATION_ADD    = 0
 	OPERATION_MODIFY = 1
 	MAX_PROG_REUSE   = 25
-	ALU_OPERATION    = 0
-	JMP_OPERATION    = 1
-	MEM_OPERATION    = 2
 )
 
 // Factory method to create a new coverage based strategy.
@@ -118,16 +115,13 @@ func duplicateProgram(prog []*epb.Instruction) []*epb.Instruction {
 }
 
 func newRandomInstruction(maxJmp uint64) *epb.Instruction {
-	instructionType := rand.SharedRNG.RandInt() % 3
-	switch instructionType {
-	case ALU_OPERATION:
-		return RandomAluInstruction()
-	case JMP_OPERATION:
+	switch RandomInstructionClass() {
+	case epb.InsClass_InsClassJmp:
 		if maxJmp == 0 {
 			return RandomAluInstruction()
 		}
 		return RandomJmpInstruction(maxJmp)
-	case MEM_OPERATION:
+	case epb.InsClass_InsClassLdx:
 		return RandomMemInstruction()
 	default:
 		return RandomAluInstruction()
@@ -192,7 +186,7 @@ func mutateProgram(prog []*epb.Instruction, headSize int) ([]*epb.Instruction, e
 
 // GenerateProgram should return the instructions to feed the verifier.
 func (cv *CoverageBased) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
-	fmt.Printf("Program count: %d, Valid Programs: %d, Queue len: %d\t\t\r", cv.programCount, cv.validProgramCount, cv.pq.Len())
+	logging.Debugf("Program count: %d, Valid Programs: %d, Queue len: %d\t\t\r", cv.programCount, cv.validProgramCount, cv.pq.Len())
 	cv.programCount = cv.programCount + 1
 
 	// If there are no programs in the queue, reuse the default program.
@@ -246,7 +240,7 @@ func (cv *CoverageBased) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.Va
 	cv.validProgramCount = cv.validProgramCount + 1
 
 	if !verificationResult.DidCollectCoverage {
-		fmt.Printf("Warning: Failed to collect coverage %s\n\t\t\t\t", protobuf.MarshalTextString(verificationResult))
+		logging.Warningf("Failed to collect coverage %s\n\t\t\t\t", protobuf.MarshalTextString(verificationResult))
 		//cv.isFinished = true
 		return false
 	}
@@ -284,7 +278,7 @@ func (cv *CoverageBased) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.Va
 			CoverageSize:      uint64(len(verificationResult.CoverageAddress)),
 			UsageCount:        0,
 		})
-		fmt.Printf("Pushed new program with signature %02x and coverage size: %d, queue length: %d\t\t\t\t\t\n", fingerPrint, len(verificationResult.CoverageAddress), cv.pq.Len())
+		logging.Infof("Pushed new program with signature %02x and coverage size: %d, queue length: %d\t\t\t\t\t\n", fingerPrint, len(verificationResult.CoverageAddress), cv.pq.Len())
 		return true
 	}
 
@@ -301,10 +295,21 @@ func (cv *CoverageBased) OnExecuteDone(ffi *units.FFI, executionResult *fpb.Exec
 	return valid
 }
 
+// SkipMinimization is true, OnVerifyDone rejects the programs loaded
+// without coverage, like the ones loaded to reproduce a finding.
+func (cv *CoverageBased) SkipMinimization() bool {
+	return true
+}
+
+// Maps returns the map the pointer arithmetic footer writes to.
+func (cv *CoverageBased) Maps() map[int]uint64 {
+	return map[int]uint64{cv.mapFd: 1}
+}
+
 // OnError is used to determine if the fuzzer should continue on errors.
 // true represents continue, false represents halt.
 func (cv *CoverageBased) OnError(e error) bool {
-	fmt.Printf("error %v\n", e)
+	logging.Warningf("error %v\n", e)
 	return false
 }
 
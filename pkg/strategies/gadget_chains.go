@@ -0,0 +1,172 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Number of elements of the array map the gadgets access and size of
+	// its values, big enough for the gadgets to index into.
+	gadgetChainsMapSize   = 4
+	gadgetChainsValueSize = 64
+
+	// Maximum number of gadgets and of random ALU instructions between
+	// two of them.
+	gadgetChainsMaxGadgets = 6
+	gadgetChainsMaxFiller  = 8
+
+	// Stack slots of the map key and of the spilled value pointer, below
+	// the one the gadgets use.
+	gadgetChainsKeySlot   = -16
+	gadgetChainsValueSlot = -24
+)
+
+func NewGadgetChainsStrategy() *GadgetChains {
+	return &GadgetChains{isFinished: false, mapFd: -1}
+}
+
+// GadgetChains is a strategy that stitches together the instruction
+// patterns of ebpf.Gadgets, e.g. bounds checks followed by memory accesses
+// or spills that are partially overwritten before the fill, with random ALU
+// instructions in between. Compared to a uniform random instruction soup
+// most of the programs reach the parts of the verifier that track pointers
+// and bounds.
+//
+// All the gadgets work on a map value looked up at the beginning of the
+// program.
+type GadgetChains struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (gc *GadgetChains) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	gc.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", gc.programCount, gc.validProgramCount)
+
+	// The map is shared by all the programs so they can be batched.
+	if gc.mapFd < 0 {
+		gc.mapFd = ffi.CreateMap(units.MapTypeArray, 4, gadgetChainsValueSize, gadgetChainsMapSize, 0)
+		if gc.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+	env := &GadgetEnvironment{ValueReg: R6, ValueSize: gadgetChainsValueSize}
+
+	// The value pointer is spilled and only reloaded into R6 right before
+	// the gadgets, the random ALU instructions in between work on scalars.
+	insn, err := InstructionSequence(
+		StW(R10, int32(rand.SharedRNG.RandRange(0, gadgetChainsMapSize-1)), gadgetChainsKeySlot),
+		LdMapByFd(R1, gc.mapFd),
+		Mov64(R2, R10),
+		Add64(R2, int32(gadgetChainsKeySlot)),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+		StDW(R10, R0, gadgetChainsValueSlot),
+	)
+	if err != nil {
+		return nil, err
+	}
+	gadgets := rand.SharedRNG.RandRange(1, gadgetChainsMaxGadgets)
+	for i := uint64(0); i < gadgets; i++ {
+		// Some of the registers the calls preserve get values the
+		// verifier knows nothing about, the others random constants.
+		unknown := map[epb.Reg]bool{}
+		for reg := R6; reg <= R9; reg++ {
+			if rand.SharedRNG.OneOf(4) {
+				insn = append(insn, UnknownScalar(reg)...)
+				unknown[reg] = true
+			}
+		}
+		for reg := R0; reg <= R9; reg++ {
+			if !unknown[reg] {
+				insn = append(insn, Mov64(reg, int32(rand.SharedRNG.RandInt())))
+			}
+		}
+		for j := rand.SharedRNG.RandRange(0, gadgetChainsMaxFiller); j > 0; j-- {
+			insn = append(insn, RandomAluInstruction())
+		}
+		gadget, err := RandomGadget(env)
+		if err != nil {
+			return nil, err
+		}
+		insn = append(insn, LdDW(R6, R10, gadgetChainsValueSlot))
+		insn = append(insn, gadget...)
+	}
+
+	footer, err := InstructionSequence(
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &epb.Program{Instructions: append(insn, footer...)}, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (gc *GadgetChains) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	gc.validProgramCount += 1
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+//
+// The gadgets have no expected result of their own, bugs show up as kernel
+// splats or through the oracles of the control unit.
+func (gc *GadgetChains) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+// Batchable returns true, the programs only depend on the shared map and
+// the hooks don't read it.
+func (gc *GadgetChains) Batchable() bool {
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (gc *GadgetChains) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (gc *GadgetChains) IsFuzzingDone() bool {
+	return gc.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (gc *GadgetChains) Name() string {
+	return "gadget_chains"
+}
@@ -12,6 +12,10 @@ type CoverageTrace struct {
 	CoverageSignature uint64
 	CoverageSize      uint64
 	UsageCount        int
+
+	// Number of mutations of the program in a row that did not reach new
+	// coverage.
+	FruitlessCount int
 }
 
 // PriorityQueueContainer is an alias of an array of traces.
@@ -72,6 +76,11 @@ func (pq *PriorityQueue) IsEmpty() bool {
 	return pq.Len() == 0
 }
 
+// Traces returns the elements of the queue, in no particular order.
+func (pq *PriorityQueue) Traces() []*CoverageTrace {
+	return append([]*CoverageTrace{}, *pq.pq...)
+}
+
 // NewPriorityQueue is a factory method to create new pqs.
 func NewPriorityQueue() *PriorityQueue {
 	return &PriorityQueue{
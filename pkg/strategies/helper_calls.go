@@ -0,0 +1,146 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Number of elements of the array map passed to the helpers that take
+	// one.
+	helperCallsMapSize = 16
+
+	// Maximum number of call sites and of random ALU instructions before
+	// each of them.
+	helperCallsMaxCalls  = 8
+	helperCallsMaxFiller = 8
+
+	// Stack slot where the context is spilled, below the ones the call
+	// sites use.
+	helperCallsCtxSlot = -72
+)
+
+func NewHelperCallsStrategy() *HelperCalls {
+	return &HelperCalls{isFinished: false, mapFd: -1}
+}
+
+// HelperCalls is a strategy that calls random helpers with arguments set up
+// from their prototypes, see ebpf.RandomHelperCall. One out of four call
+// sites breaks the contract of one argument, so the argument checks of the
+// verifier are fuzzed along with the helpers themselves.
+type HelperCalls struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (hc *HelperCalls) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	hc.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", hc.programCount, hc.validProgramCount)
+
+	if hc.mapFd >= 0 {
+		ffi.CloseFD(hc.mapFd)
+	}
+	hc.mapFd = ffi.CreateMapArray(helperCallsMapSize)
+	if hc.mapFd < 0 {
+		return nil, mapCreationFailed
+	}
+	env := &CallEnvironment{HasCtx: true, CtxReg: R6, MapFd: hc.mapFd}
+
+	// The context is spilled and only reloaded into R6 right before the
+	// call sites, the random ALU instructions in between work on scalars.
+	insn, err := InstructionSequence(
+		StDW(R10, R1, helperCallsCtxSlot),
+	)
+	if err != nil {
+		return nil, err
+	}
+	calls := rand.SharedRNG.RandRange(1, helperCallsMaxCalls)
+	for i := uint64(0); i < calls; i++ {
+		// The helper calls leave R1-R5 uninitialized.
+		for reg := R0; reg <= R9; reg++ {
+			insn = append(insn, Mov64(reg, int32(rand.SharedRNG.RandInt())))
+		}
+		for j := rand.SharedRNG.RandRange(0, helperCallsMaxFiller); j > 0; j-- {
+			insn = append(insn, RandomAluInstruction())
+		}
+		call, err := RandomHelperCall(env)
+		if err != nil {
+			return nil, err
+		}
+		insn = append(insn, LdDW(R6, R10, helperCallsCtxSlot))
+		insn = append(insn, call...)
+	}
+
+	footer, err := InstructionSequence(
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &epb.Program{Instructions: append(insn, footer...)}, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (hc *HelperCalls) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	hc.validProgramCount += 1
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+//
+// The calls have no expected result of their own, bugs show up as kernel
+// splats or through the oracles of the control unit.
+func (hc *HelperCalls) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+// Maps returns the array map passed to the helpers.
+func (hc *HelperCalls) Maps() map[int]uint64 {
+	return map[int]uint64{hc.mapFd: helperCallsMapSize}
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (hc *HelperCalls) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (hc *HelperCalls) IsFuzzingDone() bool {
+	return hc.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (hc *HelperCalls) Name() string {
+	return "helper_calls"
+}
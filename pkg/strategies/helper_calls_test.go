@@ -0,0 +1,50 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestHelperCallsProgram(t *testing.T) {
+	hc := NewHelperCallsStrategy()
+	ffi := &units.FFI{Maps: units.NewFakeMaps()}
+	for i := 0; i < 100; i++ {
+		prog, err := hc.GenerateProgram(ffi)
+		if err != nil {
+			t.Fatalf("GenerateProgram() = %v, want nil error", err)
+		}
+		if err := Validate(prog); err != nil {
+			t.Fatalf("Validate() = %v, want nil error", err)
+		}
+		calls := 0
+		for index, insn := range prog.Instructions {
+			if insn.GetJmpOpcode().GetOperationCode() != epb.JmpOperationCode_JmpCALL {
+				continue
+			}
+			calls++
+			if HelperPrototypeByID(insn.Immediate) == nil {
+				t.Fatalf("instruction %d calls unknown helper %d", index, insn.Immediate)
+			}
+		}
+		if calls == 0 {
+			t.Fatalf("GenerateProgram() = %v, want at least one helper call", prog)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Number of elements of the array map the templates look up.
+	helperChainsMapSize = 16
+
+	// Number of programs the tail calls can pick from, the prog array is
+	// left empty so every tail call fails and falls through.
+	helperChainsProgArraySize = 4
+
+	// Size of the data area of the ring buffer, it has to be a power of two
+	// multiple of the page size.
+	helperChainsRingbufSize = 4096
+
+	// Maximum number of templates and of random ALU instructions between
+	// two of them.
+	helperChainsMaxTemplates = 4
+	helperChainsMaxFiller    = 8
+
+	// Stack slot where the context is spilled, below the ones the
+	// templates use.
+	helperChainsCtxSlot = -72
+)
+
+func NewHelperChainsStrategy() *HelperChains {
+	return &HelperChains{isFinished: false, mapFd: -1, progArrayFd: -1, ringbufFd: -1}
+}
+
+// HelperChains is a strategy that chains helper calls the way production
+// programs do, e.g. parse the packet, look up a map with what was read and
+// tail call the program it points to, or reserve a ring buffer record, fill
+// it and submit it. The chains come from ebpf.HelperTemplates and are
+// instantiated with fuzzed offsets, sizes and flags, with random ALU
+// instructions in between.
+type HelperChains struct {
+	isFinished        bool
+	mapFd             int
+	progArrayFd       int
+	ringbufFd         int
+	programCount      int
+	validProgramCount int
+}
+
+// createMaps replaces the maps of the previous program. The array map is
+// required, the prog array and the ring buffer are left at -1 if the kernel
+// does not support them so only the templates that don't need them are used.
+func (hc *HelperChains) createMaps(ffi *units.FFI) error {
+	for _, fd := range []int{hc.mapFd, hc.progArrayFd, hc.ringbufFd} {
+		if fd >= 0 {
+			ffi.CloseFD(fd)
+		}
+	}
+	hc.mapFd = ffi.CreateMapArray(helperChainsMapSize)
+	if hc.mapFd < 0 {
+		return mapCreationFailed
+	}
+	hc.progArrayFd = ffi.CreateMap(units.MapTypeProgArray, 4, 4, helperChainsProgArraySize, 0)
+	hc.ringbufFd = ffi.CreateMap(units.MapTypeRingbuf, 0, 0, helperChainsRingbufSize, 0)
+	return nil
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (hc *HelperChains) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	hc.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", hc.programCount, hc.validProgramCount)
+
+	if err := hc.createMaps(ffi); err != nil {
+		return nil, err
+	}
+	env := &TemplateEnvironment{
+		CallEnvironment: CallEnvironment{HasCtx: true, CtxReg: R6, MapFd: hc.mapFd},
+		ProgArrayFd:     hc.progArrayFd,
+		ProgArraySize:   helperChainsProgArraySize,
+		RingbufFd:       hc.ringbufFd,
+		RingbufSize:     helperChainsRingbufSize,
+	}
+
+	// The context is spilled and only reloaded into R6 right before the
+	// templates, the random ALU instructions in between work on scalars.
+	insn, err := InstructionSequence(
+		StDW(R10, R1, helperChainsCtxSlot),
+	)
+	if err != nil {
+		return nil, err
+	}
+	templates := rand.SharedRNG.RandRange(1, helperChainsMaxTemplates)
+	for i := uint64(0); i < templates; i++ {
+		// The helper calls leave R1-R5 uninitialized.
+		for reg := R0; reg <= R9; reg++ {
+			insn = append(insn, Mov64(reg, int32(rand.SharedRNG.RandInt())))
+		}
+		for j := rand.SharedRNG.RandRange(0, helperChainsMaxFiller); j > 0; j-- {
+			insn = append(insn, RandomAluInstruction())
+		}
+		chain, err := RandomHelperTemplate(env)
+		if err != nil {
+			return nil, err
+		}
+		insn = append(insn, LdDW(R6, R10, helperChainsCtxSlot))
+		insn = append(insn, chain...)
+	}
+
+	footer, err := InstructionSequence(
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &epb.Program{Instructions: append(insn, footer...)}, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (hc *HelperChains) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	hc.validProgramCount += 1
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+//
+// The chains have no expected result of their own, bugs show up as kernel
+// splats or through the oracles of the control unit.
+func (hc *HelperChains) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+// Maps returns the array map used by the last generated program, the prog
+// array and the ring buffer cannot be read or written like arrays.
+func (hc *HelperChains) Maps() map[int]uint64 {
+	return map[int]uint64{hc.mapFd: helperChainsMapSize}
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (hc *HelperChains) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (hc *HelperChains) IsFuzzingDone() bool {
+	return hc.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (hc *HelperChains) Name() string {
+	return "helper_chains"
+}
@@ -0,0 +1,157 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Map layout: element 0 is written without pointer arithmetic, element
+	// 1 through the register the verifier has to prove is 0.
+	joinPointsMapSize = 2
+
+	// Value written to both elements.
+	joinPointsMagic = 0xCAFE
+
+	// Maximum number of regions and of ALU instructions per basic block.
+	joinPointsMaxRegions = 6
+	joinPointsMaxBlock   = 4
+)
+
+func NewJoinPointsStrategy() *JoinPoints {
+	return &JoinPoints{isFinished: false, mapFd: -1}
+}
+
+// JoinPoints is a strategy that generates programs whose branches rejoin,
+// see ebpf.RandomJoinPoints, so the verifier prunes the states that reach
+// the same instructions. The footer adds one of the registers to a map value
+// pointer before writing through it, which the verifier only allows if it
+// believes the register is 0. Pruning a state it should have explored makes
+// it accept a register that is not, and the write misses its element.
+type JoinPoints struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (jp *JoinPoints) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	jp.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", jp.programCount, jp.validProgramCount)
+
+	if jp.mapFd < 0 {
+		jp.mapFd = ffi.CreateMapArray(joinPointsMapSize)
+		if jp.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	b := NewProgramBuilder()
+	for reg := R0; reg <= R9; reg++ {
+		b.Mov64(reg, int32(rand.SharedRNG.RandInt()))
+	}
+	block := func() []*epb.Instruction {
+		insn := []*epb.Instruction{}
+		for i := rand.SharedRNG.RandRange(1, joinPointsMaxBlock); i > 0; i-- {
+			insn = append(insn, RandomAluInstruction())
+		}
+		return insn
+	}
+	RandomJoinPoints(b, int(rand.SharedRNG.RandRange(1, joinPointsMaxRegions)), block)
+
+	return b.
+		Mov64(R8, RandomRegister()).
+		LdMapByFd(R9, jp.mapFd).
+		StW(R10, int32(0), -4).
+		Mov64(R2, R10).
+		Add64(R2, -4).
+		Mov64(R1, R9).
+		Call(MapLookup).
+		JmpNE(R0, 0, 1).
+		Exit().
+		StDW(R0, int32(joinPointsMagic), 0).
+		StW(R10, int32(1), -4).
+		Mov64(R2, R10).
+		Add64(R2, -4).
+		Mov64(R1, R9).
+		Call(MapLookup).
+		JmpNE(R0, 0, 1).
+		Exit().
+		Add64(R0, R8).
+		StDW(R0, int32(joinPointsMagic), 0).
+		Mov64(R0, 0).
+		Exit().
+		Build()
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (jp *JoinPoints) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	jp.validProgramCount += 1
+	if ffi.SetMapElement(jp.mapFd, 0, 0) != 0 || ffi.SetMapElement(jp.mapFd, 1, 0) != 0 {
+		logging.Warningf("could not initialize the map\n")
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (jp *JoinPoints) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	mapElements, err := ffi.GetMapElements(jp.mapFd, joinPointsMapSize)
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	if got := mapElements.Elements[1]; got != joinPointsMagic {
+		logging.Infof("write through the pruned register missed its element, it holds %#x\n", got)
+		return false
+	}
+	return true
+}
+
+// Maps returns the map the program writes its control and test values to.
+func (jp *JoinPoints) Maps() map[int]uint64 {
+	return map[int]uint64{jp.mapFd: joinPointsMapSize}
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (jp *JoinPoints) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (jp *JoinPoints) IsFuzzingDone() bool {
+	return jp.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (jp *JoinPoints) Name() string {
+	return "join_points"
+}
@@ -0,0 +1,236 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"fmt"
+
+	"buzzer/pkg/btf/btf"
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// The programs pass a zeroed buffer of kfuncBufferSize bytes on the
+	// stack as memory, and the value of a map element as a pointer.
+	kfuncBufferSize   = 64
+	kfuncBufferOffset = -kfuncBufferSize
+	kfuncKeyOffset    = kfuncBufferOffset - 4
+)
+
+// kfuncNames are kfuncs available to every program type, they are looked up
+// by name on the kernels whose BTF doesn't tag the kfuncs.
+var kfuncNames = []string{
+	"bpf_cast_to_kern_ctx",
+	"bpf_rdonly_cast",
+	"bpf_rcu_read_lock",
+	"bpf_rcu_read_unlock",
+	"bpf_dynptr_adjust",
+	"bpf_dynptr_is_null",
+	"bpf_dynptr_is_rdonly",
+	"bpf_dynptr_size",
+	"bpf_dynptr_clone",
+	"bpf_dynptr_slice",
+	"bpf_dynptr_slice_rdwr",
+	"bpf_iter_num_new",
+	"bpf_iter_num_next",
+	"bpf_iter_num_destroy",
+	"bpf_preempt_disable",
+	"bpf_preempt_enable",
+}
+
+var kfuncArgNames = []string{"scalar", "constant", "memory", "size", "pointer", "ignored"}
+
+func NewKfuncsStrategy() *Kfuncs {
+	return &Kfuncs{isFinished: false, mapFd: -1, loadTypes: btf.LoadVmlinux}
+}
+
+// Kfuncs is a strategy for the calls to kernel functions, kfuncs, where most
+// new verifier surface is being added. The kfuncs are found in the BTF of
+// the running kernel, which gives their BTF id and the type of their
+// arguments, and the programs call one of them with arguments of the
+// expected kind: scalars, constants, memory and its size, or pointers to the
+// context, the stack or a map value.
+//
+// Some call sites are mistyped on purpose: a pointer is passed where a
+// scalar is expected or the other way around, and the verifier must reject
+// them.
+type Kfuncs struct {
+	isFinished        bool
+	programCount      int
+	validProgramCount int
+	mapFd             int
+
+	// kfuncs are the kfuncs of the running kernel, loadTypes returns its
+	// types.
+	kfuncs    []*btf.Kfunc
+	loadTypes func() (btf.Types, error)
+
+	// The kfunc the last program calls and the argument mistyped on
+	// purpose, -1 if none.
+	kfunc    *btf.Kfunc
+	mistyped int
+}
+
+// isPointerArg returns true if the verifier expects a pointer for `arg`.
+func isPointerArg(arg btf.KfuncArg) bool {
+	return arg == btf.KfuncArgMem || arg == btf.KfuncArgPtr
+}
+
+// argument returns the instructions that load the argument `i` of the last
+// kfunc into its register. The context is in R6 and the map value in R7.
+func (kf *Kfuncs) argument(i int) []*epb.Instruction {
+	reg := epb.Reg(int(R1) + i)
+	arg := kf.kfunc.Args[i]
+	stack := []*epb.Instruction{Mov64(reg, R10), Add64(reg, kfuncBufferOffset)}
+	if i == kf.mistyped {
+		if isPointerArg(arg) {
+			return []*epb.Instruction{Mov64(reg, int32(rand.SharedRNG.RandRange(1, 0x7fffffff)))}
+		}
+		return stack
+	}
+	switch arg {
+	case btf.KfuncArgScalar:
+		values := []int32{0, 1, -1, 0x7fffffff, int32(rand.SharedRNG.RandRange(0, 0xffffffff))}
+		return []*epb.Instruction{Mov64(reg, values[rand.SharedRNG.RandRange(0, uint64(len(values)-1))])}
+	case btf.KfuncArgConst:
+		return []*epb.Instruction{Mov64(reg, int32(rand.SharedRNG.RandRange(0, 16)))}
+	case btf.KfuncArgMem:
+		return stack
+	case btf.KfuncArgSize:
+		// The size of the buffer, when it follows it, can't be 0.
+		low := uint64(0)
+		if i > 0 && kf.kfunc.Args[i-1] == btf.KfuncArgMem {
+			low = 1
+		}
+		return []*epb.Instruction{Mov64(reg, int32(rand.SharedRNG.RandRange(low, kfuncBufferSize)))}
+	case btf.KfuncArgPtr:
+		switch rand.SharedRNG.RandRange(0, 3) {
+		case 0:
+			return []*epb.Instruction{Mov64(reg, R6)}
+		case 1:
+			return stack
+		case 2:
+			return []*epb.Instruction{Mov64(reg, R7)}
+		}
+	}
+	return []*epb.Instruction{Mov64(reg, 0)}
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (kf *Kfuncs) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	kf.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", kf.programCount, kf.validProgramCount)
+
+	if kf.kfuncs == nil {
+		types, err := kf.loadTypes()
+		if err != nil {
+			return nil, fmt.Errorf("could not load the kernel BTF: %w", err)
+		}
+		if kf.kfuncs = types.Kfuncs(kfuncNames); len(kf.kfuncs) == 0 {
+			return nil, fmt.Errorf("no kfunc in the kernel BTF")
+		}
+	}
+	if kf.mapFd < 0 {
+		if kf.mapFd = ffi.CreateMapArray(1); kf.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	kf.kfunc = kf.kfuncs[rand.SharedRNG.RandRange(0, uint64(len(kf.kfuncs)-1))]
+	kf.mistyped = -1
+	if len(kf.kfunc.Args) > 0 && rand.SharedRNG.OneOf(4) {
+		kf.mistyped = int(rand.SharedRNG.RandRange(0, uint64(len(kf.kfunc.Args)-1)))
+		if kf.kfunc.Args[kf.mistyped] == btf.KfuncArgIgnored {
+			kf.mistyped = -1
+		}
+	}
+
+	b := NewProgramBuilder().Mov64(R6, R1)
+	for offset := kfuncBufferOffset; offset < 0; offset += 8 {
+		b.StDW(R10, 0, int16(offset))
+	}
+	b.StW(R10, 0, kfuncKeyOffset).
+		LdMapByFd(R1, kf.mapFd).
+		Mov64(R2, R10).Add64(R2, kfuncKeyOffset).
+		Call(MapLookup).
+		JmpEQ(R0, 0, "out").
+		Mov64(R7, R0)
+	for i := range kf.kfunc.Args {
+		b.Insn(kf.argument(i)...)
+	}
+	return b.CallKfunc(int32(kf.kfunc.ID)).
+		Label("out").Mov64(R0, 0).Exit().
+		Build()
+}
+
+// ProgramType returns BPF_PROG_TYPE_SCHED_CLS, which has more kfuncs than
+// socket filters.
+func (kf *Kfuncs) ProgramType() int {
+	return units.ProgTypeSchedCls
+}
+
+// Decisions returns the kfunc of the last program and its mistyped argument
+// for the decision log.
+func (kf *Kfuncs) Decisions() []string {
+	res := []string{kf.kfunc.Name}
+	if kf.mistyped >= 0 {
+		res = append(res, fmt.Sprintf("mistyped %s argument %d", kfuncArgNames[kf.kfunc.Args[kf.mistyped]], kf.mistyped+1))
+	}
+	return res
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (kf *Kfuncs) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	kf.validProgramCount += 1
+	if kf.mistyped >= 0 {
+		logging.Infof("verifier accepted a call to %s with a mistyped %s argument %d\n", kf.kfunc.Name, kfuncArgNames[kf.kfunc.Args[kf.mistyped]], kf.mistyped+1)
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (kf *Kfuncs) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	// Reaching this point with a mistyped argument is already a bug.
+	return kf.mistyped < 0
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (kf *Kfuncs) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (kf *Kfuncs) IsFuzzingDone() bool {
+	return kf.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (kf *Kfuncs) Name() string {
+	return "kfuncs"
+}
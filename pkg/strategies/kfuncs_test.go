@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	"buzzer/pkg/btf/btf"
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// kfuncTypes returns the types of a kernel with one kfunc taking memory,
+// its size, a scalar and a pointer.
+func kfuncTypes() (btf.Types, error) {
+	b := btf.NewBuilder()
+	u32 := b.Int("unsigned int", 4, 0)
+	ptr := b.Pointer(btf.Void)
+	proto := b.FuncProto(u32,
+		btf.Param{Name: "mem", Type: ptr},
+		btf.Param{Name: "mem__sz", Type: u32},
+		btf.Param{Name: "flags", Type: u32},
+		btf.Param{Name: "obj", Type: ptr})
+	b.DeclTag(btf.KfuncTag, b.Func("bpf_kfunc", proto, btf.LinkageGlobal), -1)
+	return btf.Decode(b.Encode())
+}
+
+func TestKfuncsCallSites(t *testing.T) {
+	kf := NewKfuncsStrategy()
+	kf.loadTypes = kfuncTypes
+	kf.mapFd = 3
+	mistyped := false
+	for i := 0; i < 100; i++ {
+		prog, err := kf.GenerateProgram(nil)
+		if err != nil {
+			t.Fatalf("GenerateProgram() = %v, want nil error", err)
+		}
+		if _, err := EncodeInstructions(prog); err != nil {
+			t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+		}
+		if kf.ProgramType() != units.ProgTypeSchedCls {
+			t.Fatalf("ProgramType() = %d, want %d", kf.ProgramType(), units.ProgTypeSchedCls)
+		}
+
+		// The registers of the arguments as they are at the call.
+		set := make(map[epb.Reg]*epb.Instruction)
+		var call *epb.Instruction
+		for _, in := range prog.Instructions {
+			if in.GetJmpOpcode().GetOperationCode() == epb.JmpOperationCode_JmpCALL && in.SrcReg == PseudoKfuncCall {
+				call = in
+				break
+			}
+			if in.GetAluOpcode().GetOperationCode() == epb.AluOperationCode_AluMov {
+				set[in.DstReg] = in
+			}
+		}
+		if call == nil || call.Immediate != int32(kf.kfunc.ID) {
+			t.Fatalf("program calls %v, want kfunc %d", call, kf.kfunc.ID)
+		}
+		mistyped = mistyped || kf.mistyped >= 0
+		for arg := 0; arg < 4; arg++ {
+			mov := set[epb.Reg(int(R1)+arg)]
+			isPointer := mov.GetAluOpcode().GetSource() == epb.SrcOperand_RegSrc
+			if isPointer && mov.SrcReg == R6 || isPointer && mov.SrcReg == R7 {
+				// The context or the map value.
+			} else if !isPointer && mov.Immediate == 0 && kf.kfunc.Args[arg] == btf.KfuncArgPtr {
+				// NULL.
+				isPointer = true
+			}
+			if wantPointer := isPointerArg(kf.kfunc.Args[arg]) != (arg == kf.mistyped); isPointer != wantPointer {
+				t.Fatalf("argument %d of %v is set by %v, want a pointer: %v", arg+1, kf.Decisions(), mov, wantPointer)
+			}
+		}
+	}
+	if !mistyped {
+		t.Errorf("no call site was mistyped")
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Bounds of the number of elements of the hash maps.
+	keySpaceMinEntries = 4
+	keySpaceMaxEntries = 64
+
+	// Maximum number of map operations a generated program does.
+	keySpaceMaxOps = 8
+
+	// Elements of the result map: how many values read from the hash map
+	// were wrong and how many keys were present when they should not be,
+	// or the other way around.
+	keySpaceValueErrors    = 0
+	keySpacePresenceErrors = 1
+	keySpaceResultSize     = 2
+
+	// Stack offsets of the key and value passed to the map helpers.
+	keySpaceKeyOffset   = -4
+	keySpaceValueOffset = -16
+
+	// Flags of map_update_elem.
+	updateAny     = 0
+	updateNoExist = 1
+	updateExist   = 2
+)
+
+// keyState is what is known about the presence of a key in the hash map at
+// some point of the program.
+type keyState int
+
+const (
+	keyUnknown keyState = iota
+	keyPresent
+	keyAbsent
+)
+
+func NewMapKeySpaceStrategy() *MapKeySpace {
+	return &MapKeySpace{
+		isFinished: false,
+		mapFd:      -1,
+		resultFd:   -1,
+		patterns:   []units.KeyPattern{units.DenseKeys, units.SparseKeys, units.CollidingKeys},
+	}
+}
+
+// MapKeySpace is a strategy that targets the hash map implementations. Before
+// each execution the map is populated with keys that are dense, sparse or
+// that all collide in the same bucket, see units.KeyedMapOwner, then the
+// program looks keys up, updates and deletes them.
+//
+// Every value the program reads must be the one the driver or the program
+// stored, and keys must be present or absent as the operations done so far
+// imply. LRU maps can evict keys at any time, only absent keys are checked on
+// them.
+type MapKeySpace struct {
+	isFinished        bool
+	mapFd             int
+	resultFd          int
+	programCount      int
+	validProgramCount int
+	patterns          []units.KeyPattern
+
+	// State of the last generated program.
+	keys []uint32
+}
+
+// SetKeyPatterns restricts the patterns the hash maps are populated with.
+func (ks *MapKeySpace) SetKeyPatterns(patterns ...units.KeyPattern) {
+	ks.patterns = patterns
+}
+
+// keySpaceSlots returns how many slots `insns` take once encoded.
+func keySpaceSlots(insns []*epb.Instruction) int16 {
+	slots := 0
+	for _, insn := range insns {
+		slots += InstructionWidth(insn)
+	}
+	return int16(slots)
+}
+
+// keySpaceArguments returns the instructions that set up the map and key
+// arguments of a map helper call for `key`.
+func keySpaceArguments(key uint32) []*epb.Instruction {
+	return []*epb.Instruction{
+		StW(R10, int32(key), keySpaceKeyOffset),
+		Mov64(R1, R6),
+		Mov64(R2, R10),
+		Add64(R2, keySpaceKeyOffset),
+	}
+}
+
+// keySpaceCountError returns the instructions that increment the result
+// element `counter` points to.
+func keySpaceCountError(counter epb.Reg) []*epb.Instruction {
+	return []*epb.Instruction{
+		Mov64(R1, 1),
+		MemAdd64(counter, R1, 0),
+	}
+}
+
+// keySpaceLookup returns the instructions that look up `key`, check the value
+// found and whether it should have been found.
+func keySpaceLookup(key uint32, state keyState) []*epb.Instruction {
+	insn := append(keySpaceArguments(key), Call(MapLookup))
+
+	check := append([]*epb.Instruction{
+		LdDW(R1, R0, 0),
+		Mov64(R2, int64(units.KeyedMapValue(key))),
+		JmpEQ(R1, R2, 2),
+	}, keySpaceCountError(R9)...)
+	insn = append(insn, JmpEQ(R0, 0, keySpaceSlots(check)))
+	insn = append(insn, check...)
+
+	switch state {
+	case keyPresent:
+		insn = append(insn, JmpNE(R0, 0, 2))
+		insn = append(insn, keySpaceCountError(R8)...)
+	case keyAbsent:
+		insn = append(insn, JmpEQ(R0, 0, 2))
+		insn = append(insn, keySpaceCountError(R8)...)
+	}
+	return insn
+}
+
+// keySpaceUpdate returns the instructions that set `key` to its expected
+// value.
+func keySpaceUpdate(key uint32, flags int32) []*epb.Instruction {
+	insn := []*epb.Instruction{
+		Mov64(R3, int64(units.KeyedMapValue(key))),
+		StDW(R10, R3, keySpaceValueOffset),
+	}
+	insn = append(insn, keySpaceArguments(key)...)
+	return append(insn,
+		Mov64(R3, R10),
+		Add64(R3, keySpaceValueOffset),
+		Mov64(R4, flags),
+		Call(MapUpdate),
+	)
+}
+
+// updatedState returns the state of a key in state `state` once updated with
+// `flags`. Updates never remove a key from a hash map, but inserting one fails
+// if the map is full.
+func updatedState(state keyState, flags int32) keyState {
+	switch {
+	case state == keyPresent:
+		return keyPresent
+	case state == keyAbsent && flags == updateExist:
+		return keyAbsent
+	}
+	return keyUnknown
+}
+
+// resultElement returns the instructions that store a pointer to the element
+// `key` of the result map in `dst` and zero it, or exit if the lookup fails.
+func (ks *MapKeySpace) resultElement(key int32, dst epb.Reg) ([]*epb.Instruction, error) {
+	return InstructionSequence(
+		StW(R10, key, keySpaceKeyOffset),
+		LdMapByFd(R1, ks.resultFd),
+		Mov64(R2, R10),
+		Add64(R2, keySpaceKeyOffset),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+		Mov64(dst, R0),
+		StDW(dst, 0, 0),
+	)
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (ks *MapKeySpace) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	ks.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", ks.programCount, ks.validProgramCount)
+
+	if ks.resultFd < 0 {
+		ks.resultFd = ffi.CreateMapArray(keySpaceResultSize)
+		if ks.resultFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	// A new hash map every time, with a random type, size and pattern.
+	ffi.CloseFD(ks.mapFd)
+	maxEntries := rand.SharedRNG.RandRange(keySpaceMinEntries, keySpaceMaxEntries)
+	pattern := ks.patterns[rand.SharedRNG.RandRange(0, uint64(len(ks.patterns)-1))]
+	mapType := units.MapTypeHash
+	flags := uint32(0)
+	if rand.SharedRNG.OneOf(2) {
+		mapType = units.MapTypeLruHash
+		if rand.SharedRNG.OneOf(2) {
+			flags |= units.MapFlagNoCommonLru
+		}
+	}
+	if pattern == units.CollidingKeys {
+		flags |= units.MapFlagZeroSeed
+	}
+	ks.mapFd = ffi.CreateMapHash(mapType, maxEntries, flags)
+	if ks.mapFd < 0 {
+		return nil, mapCreationFailed
+	}
+	ks.keys = units.GenerateKeys(pattern, uint32(rand.SharedRNG.RandRange(1, maxEntries)), uint32(maxEntries))
+
+	states := make(map[uint32]keyState)
+	candidates := append([]uint32{}, ks.keys...)
+	for _, key := range ks.keys {
+		if mapType == units.MapTypeHash {
+			states[key] = keyPresent
+		}
+	}
+	// Keys the driver does not populate, they must be absent.
+	for len(candidates) < 2*len(ks.keys) {
+		key := uint32(rand.SharedRNG.RandRange(0, 0xffffffff))
+		if _, ok := states[key]; ok || mapType == units.MapTypeLruHash && containsKey(ks.keys, key) {
+			continue
+		}
+		states[key] = keyAbsent
+		candidates = append(candidates, key)
+	}
+
+	// R6 holds the hash map, R9 and R8 point to the value and presence
+	// error counters.
+	insn, err := ks.resultElement(keySpaceValueErrors, R9)
+	if err != nil {
+		return nil, err
+	}
+	presence, err := ks.resultElement(keySpacePresenceErrors, R8)
+	if err != nil {
+		return nil, err
+	}
+	insn = append(insn, presence...)
+	insn = append(insn, LdMapByFd(R6, ks.mapFd))
+
+	ops := rand.SharedRNG.RandRange(1, keySpaceMaxOps)
+	for i := uint64(0); i < ops; i++ {
+		key := candidates[rand.SharedRNG.RandRange(0, uint64(len(candidates)-1))]
+		switch rand.SharedRNG.RandRange(0, 3) {
+		case 0:
+			flags := int32(rand.SharedRNG.RandRange(updateAny, updateExist))
+			insn = append(insn, keySpaceUpdate(key, flags)...)
+			if mapType == units.MapTypeLruHash {
+				// Inserting might evict any other key.
+				for k, state := range states {
+					if state == keyPresent {
+						states[k] = keyUnknown
+					}
+				}
+			}
+			states[key] = updatedState(states[key], flags)
+		case 1:
+			insn = append(insn, keySpaceArguments(key)...)
+			insn = append(insn, Call(MapDelete))
+			states[key] = keyAbsent
+		default:
+			insn = append(insn, keySpaceLookup(key, states[key])...)
+		}
+	}
+
+	footer, err := InstructionSequence(
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &epb.Program{Instructions: append(insn, footer...)}, nil
+}
+
+// containsKey returns true if `key` is one of `keys`.
+func containsKey(keys []uint32, key uint32) bool {
+	for _, k := range keys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// KeyedMaps returns the hash map of the last generated program and the keys
+// it must be populated with.
+func (ks *MapKeySpace) KeyedMaps() []units.KeyedMap {
+	return []units.KeyedMap{{Fd: ks.mapFd, Keys: ks.keys}}
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (ks *MapKeySpace) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	ks.validProgramCount += 1
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (ks *MapKeySpace) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	results, err := ffi.GetMapElements(ks.resultFd, keySpaceResultSize)
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	valueErrors := results.Elements[keySpaceValueErrors]
+	presenceErrors := results.Elements[keySpacePresenceErrors]
+	if valueErrors != 0 || presenceErrors != 0 {
+		logging.Infof("hash map misbehaved: %d wrong values, %d keys wrongly present or absent\n", valueErrors, presenceErrors)
+		return false
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (ks *MapKeySpace) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (ks *MapKeySpace) IsFuzzingDone() bool {
+	return ks.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (ks *MapKeySpace) Name() string {
+	return "map_key_space"
+}
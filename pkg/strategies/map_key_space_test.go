@@ -0,0 +1,39 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+)
+
+func TestUpdatedState(t *testing.T) {
+	tests := []struct {
+		testName string
+		state    keyState
+		flags    int32
+		want     keyState
+	}{
+		{testName: "Present key replaced", state: keyPresent, flags: updateAny, want: keyPresent},
+		{testName: "Present key not inserted again", state: keyPresent, flags: updateNoExist, want: keyPresent},
+		{testName: "Absent key not replaced", state: keyAbsent, flags: updateExist, want: keyAbsent},
+		{testName: "Absent key inserted if there is room", state: keyAbsent, flags: updateAny, want: keyUnknown},
+		{testName: "Unknown key", state: keyUnknown, flags: updateExist, want: keyUnknown},
+	}
+	for _, c := range tests {
+		if got := updatedState(c.state, c.flags); got != c.want {
+			t.Errorf("%s: updatedState() = %v, want %v", c.testName, got, c.want)
+		}
+	}
+}
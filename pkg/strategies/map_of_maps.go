@@ -0,0 +1,300 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"fmt"
+	"path/filepath"
+)
+
+const (
+	// Number of inner maps, every outer map holds all of them.
+	mapOfMapsInnerMaps = 4
+
+	// Number of elements of the inner maps, arrays of 8 bytes values.
+	mapOfMapsInnerSize = 8
+)
+
+// mapOfMapsKind is how the generated program handles the inner map.
+type mapOfMapsKind int
+
+const (
+	// The result of both lookups is null checked and the inner value is
+	// written to within its bounds. The verifier must accept these
+	// programs and the marker must land in the inner map.
+	innerMapAccess mapOfMapsKind = iota
+
+	// The other kinds confuse the pointers of the lookups, the verifier
+	// must reject them.
+
+	// The inner map pointer is used without checking the outer lookup
+	// succeeded.
+	missingOuterNullCheck
+	// The inner value is written to without checking the inner lookup
+	// succeeded.
+	missingInnerNullCheck
+	// The inner map pointer is written to, as if it was a map value.
+	innerMapPointerWrite
+	// The inner map pointer is moved before being passed to the lookup.
+	innerMapPointerArithmetic
+	// The inner value is written to past its end.
+	innerValueOutOfBounds
+
+	numMapOfMapsKinds
+)
+
+var mapOfMapsKindNames = []string{
+	"inner map access",
+	"missing outer null check",
+	"missing inner null check",
+	"inner map pointer write",
+	"inner map pointer arithmetic",
+	"inner value out of bounds",
+}
+
+// mapOfMapsTypes are the types of the outer maps.
+var mapOfMapsTypes = []int{units.MapTypeArrayOfMaps, units.MapTypeHashOfMaps}
+
+func NewMapOfMapsStrategy() *MapOfMaps {
+	return &MapOfMaps{isFinished: false}
+}
+
+// MapOfMaps is a strategy that targets how the verifier tracks the pointers
+// to the inner maps of BPF_MAP_TYPE_ARRAY_OF_MAPS and HASH_OF_MAPS. Programs
+// look an inner map up in an outer map, look a value up in the inner map and
+// write a marker to it. Most programs do it correctly, the others use one of
+// the pointers unchecked, write to or move the inner map pointer or write
+// past the value, and must be rejected.
+//
+// After every execution the inner map must hold the marker, unless one of
+// the keys was missing.
+type MapOfMaps struct {
+	isFinished        bool
+	programCount      int
+	validProgramCount int
+
+	// pinDir, if set, is the bpffs directory the maps are pinned in.
+	pinDir string
+
+	innerFds []int
+	outerFds []int
+
+	// Kind, map and keys of the last generated program and the marker it
+	// writes.
+	kind     mapOfMapsKind
+	outer    int
+	outerKey uint32
+	innerKey uint32
+	marker   int32
+}
+
+// SetPinDir makes the strategy pin its maps in the bpffs directory `dir` and
+// reuse the ones already pinned there, e.g. by a previous run, so they can
+// be inspected with bpftool while fuzzing.
+func (mm *MapOfMaps) SetPinDir(dir string) {
+	mm.pinDir = dir
+}
+
+// openMap returns the map pinned as `name` in the pin directory, if any, or
+// the one `create` returns, pinned as `name`.
+func (mm *MapOfMaps) openMap(ffi *units.FFI, name string, create func() int) (int, error) {
+	if mm.pinDir == "" {
+		return create(), nil
+	}
+	path := filepath.Join(mm.pinDir, name)
+	if fd := ffi.GetPinnedObject(path); fd >= 0 {
+		return fd, nil
+	}
+	fd := create()
+	if fd >= 0 && ffi.PinObject(fd, path) < 0 {
+		ffi.CloseFD(fd)
+		return -1, fmt.Errorf("could not pin %s at %q", name, path)
+	}
+	return fd, nil
+}
+
+// createMaps creates the inner maps and the outer maps holding all of them.
+func (mm *MapOfMaps) createMaps(ffi *units.FFI) error {
+	for i := 0; i < mapOfMapsInnerMaps; i++ {
+		fd, err := mm.openMap(ffi, fmt.Sprintf("inner_%d", i), func() int {
+			return ffi.CreateMapArray(mapOfMapsInnerSize)
+		})
+		if err != nil {
+			return err
+		}
+		if fd < 0 {
+			return mapCreationFailed
+		}
+		mm.innerFds = append(mm.innerFds, fd)
+	}
+	for _, mapType := range mapOfMapsTypes {
+		fd, err := mm.openMap(ffi, fmt.Sprintf("outer_%d", mapType), func() int {
+			// Inner maps are passed by fd at creation, it is the
+			// template of the maps the outer one can hold.
+			return ffi.CreateMapOfMaps(mapType, mm.innerFds[0], mapOfMapsInnerMaps, 0)
+		})
+		if err != nil {
+			return err
+		}
+		if fd < 0 {
+			return mapCreationFailed
+		}
+		for key, innerFd := range mm.innerFds {
+			if ffi.SetInnerMap(fd, uint32(key), innerFd) < 0 {
+				return fmt.Errorf("could not add inner map %d to map of type %d", key, mapType)
+			}
+		}
+		mm.outerFds = append(mm.outerFds, fd)
+	}
+	return nil
+}
+
+// program returns the instructions of the last generated program.
+func (mm *MapOfMaps) program() (*epb.Program, error) {
+	b := NewProgramBuilder().
+		StW(R10, int32(mm.outerKey), -4).
+		Mov64(R2, R10).Add64(R2, -4).
+		LdMapByFd(R1, mm.outerFds[mm.outer]).
+		Call(MapLookup)
+	if mm.kind != missingOuterNullCheck {
+		b.JmpEQ(R0, 0, "out")
+	}
+	switch mm.kind {
+	case innerMapPointerWrite:
+		b.StW(R0, 0, 0)
+	case innerMapPointerArithmetic:
+		b.Add64(R0, int32(rand.SharedRNG.RandRange(1, 64)))
+	}
+	b.Mov64(R1, R0).
+		StW(R10, int32(mm.innerKey), -8).
+		Mov64(R2, R10).Add64(R2, -8).
+		Call(MapLookup)
+	if mm.kind != missingInnerNullCheck {
+		b.JmpEQ(R0, 0, "out")
+	}
+	offset := int16(0)
+	if mm.kind == innerValueOutOfBounds {
+		offset = 8
+	}
+	return b.StDW(R0, mm.marker, offset).
+		Label("out").Mov64(R0, 0).Exit().
+		Build()
+}
+
+// hit returns true if the last program finds the inner value it writes to.
+func (mm *MapOfMaps) hit() bool {
+	return mm.outerKey < mapOfMapsInnerMaps && mm.innerKey < mapOfMapsInnerSize
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (mm *MapOfMaps) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	mm.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", mm.programCount, mm.validProgramCount)
+
+	if mm.outerFds == nil {
+		if err := mm.createMaps(ffi); err != nil {
+			return nil, err
+		}
+	}
+
+	// Half of the programs access the inner map correctly so the verifier
+	// also goes past the pointer checks.
+	mm.kind = innerMapAccess
+	if rand.SharedRNG.OneOf(2) {
+		mm.kind = mapOfMapsKind(rand.SharedRNG.RandRange(1, uint64(numMapOfMapsKinds-1)))
+	}
+	mm.outer = int(rand.SharedRNG.RandRange(0, uint64(len(mm.outerFds)-1)))
+	// The keys are sometimes missing, the lookups then return null.
+	mm.outerKey = uint32(rand.SharedRNG.RandRange(0, mapOfMapsInnerMaps))
+	mm.innerKey = uint32(rand.SharedRNG.RandRange(0, mapOfMapsInnerSize))
+	mm.marker = int32(rand.SharedRNG.RandRange(1, 0x7fffffff))
+	return mm.program()
+}
+
+// Decisions returns the kind and the outer map of the last program for the
+// decision log.
+func (mm *MapOfMaps) Decisions() []string {
+	return []string{mapOfMapsKindNames[mm.kind], fmt.Sprintf("outer map type %d", mapOfMapsTypes[mm.outer])}
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (mm *MapOfMaps) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	mm.validProgramCount += 1
+	if mm.kind != innerMapAccess {
+		logging.Infof("verifier accepted a program with a %s\n", mapOfMapsKindNames[mm.kind])
+	}
+
+	// The program must find the inner value cleared.
+	if mm.hit() && ffi.SetMapElement(mm.innerFds[mm.outerKey], mm.innerKey, 0) < 0 {
+		logging.Warningf("could not clear element %d of inner map %d\n", mm.innerKey, mm.outerKey)
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (mm *MapOfMaps) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if mm.kind != innerMapAccess {
+		// Reaching this point is already a bug.
+		return false
+	}
+	if ffi.Maps != nil || !mm.hit() {
+		// Fake maps are never written to by programs.
+		return true
+	}
+	elements, err := ffi.GetMapElements(mm.innerFds[mm.outerKey], mapOfMapsInnerSize)
+	if err == nil && elements.GetErrorMessage() != "" {
+		err = fmt.Errorf("%s", elements.GetErrorMessage())
+	}
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	if got := elements.GetElements()[mm.innerKey]; got != uint64(mm.marker) {
+		logging.Infof("element %d of inner map %d is %#x, want %#x\n", mm.innerKey, mm.outerKey, got, mm.marker)
+		return false
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (mm *MapOfMaps) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (mm *MapOfMaps) IsFuzzingDone() bool {
+	return mm.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (mm *MapOfMaps) Name() string {
+	return "map_of_maps"
+}
@@ -0,0 +1,90 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"reflect"
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestMapOfMapsProgram(t *testing.T) {
+	tests := []struct {
+		testName   string
+		kind       mapOfMapsKind
+		nullChecks int
+		wantStore  int16
+	}{
+		{"Inner map access", innerMapAccess, 2, 0},
+		{"Missing outer null check", missingOuterNullCheck, 1, 0},
+		{"Missing inner null check", missingInnerNullCheck, 1, 0},
+		{"Inner value out of bounds", innerValueOutOfBounds, 2, 8},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			mm := &MapOfMaps{kind: tc.kind, outerFds: []int{5, 6}, outer: 1, outerKey: 2, innerKey: 3, marker: 42}
+			prog, err := mm.program()
+			if err != nil {
+				t.Fatalf("program() failed: %v", err)
+			}
+			nullChecks := 0
+			var store *epb.Instruction
+			for _, insn := range prog.Instructions {
+				if jmp, ok := insn.Opcode.(*epb.Instruction_JmpOpcode); ok && jmp.JmpOpcode.OperationCode == epb.JmpOperationCode_JmpJEQ {
+					nullChecks++
+				}
+				if Mnemonic(insn) == "st" && insn.DstReg == R0 {
+					store = insn
+				}
+			}
+			if nullChecks != tc.nullChecks {
+				t.Errorf("program() has %d null checks, want %d", nullChecks, tc.nullChecks)
+			}
+			if store == nil || store.Offset != int32(tc.wantStore) || store.Immediate != 42 {
+				t.Errorf("store to the inner value = %v, want the marker at offset %d", store, tc.wantStore)
+			}
+		})
+	}
+}
+
+func TestMapOfMapsPins(t *testing.T) {
+	ffi := &units.FFI{Maps: units.NewFakeMaps()}
+	first := NewMapOfMapsStrategy()
+	first.SetPinDir("/sys/fs/bpf/buzzer")
+	if err := first.createMaps(ffi); err != nil {
+		t.Fatalf("createMaps() failed: %v", err)
+	}
+	for _, fd := range first.outerFds {
+		if got := ffi.Maps.Keys(fd); !reflect.DeepEqual(got, []uint32{0, 1, 2, 3}) {
+			t.Errorf("Keys(%d) = %v, want every inner map", fd, got)
+		}
+	}
+	for _, fd := range append(first.innerFds, first.outerFds...) {
+		ffi.CloseFD(fd)
+	}
+
+	// A second run finds the maps pinned by the first one.
+	second := NewMapOfMapsStrategy()
+	second.SetPinDir("/sys/fs/bpf/buzzer")
+	if err := second.createMaps(ffi); err != nil {
+		t.Fatalf("createMaps() of the pinned maps failed: %v", err)
+	}
+	if got := len(ffi.Maps.Fds()); got != mapOfMapsInnerMaps+len(mapOfMapsTypes) {
+		t.Errorf("%d maps are open, want only the pinned ones", got)
+	}
+}
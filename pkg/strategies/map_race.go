@@ -0,0 +1,207 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// Number of elements of the map shared between user space and the
+	// programs.
+	mapRaceMapSize = 16
+
+	// How many times each valid program is executed while user space is
+	// writing to the map.
+	mapRaceExecutions = 64
+
+	// Maximum number of map lookups a generated program does.
+	mapRaceMaxLookups = 8
+)
+
+func NewMapRaceStrategy() *MapRace {
+	return &MapRace{isFinished: false, mapFd: -1}
+}
+
+// MapRace is a strategy that races user space writes to a map, done from a
+// separate goroutine, against executions of programs that read and update the
+// same map. Half of the time the map is also frozen (BPF_MAP_FREEZE) in the
+// middle of the run, after that point no write from user space should
+// succeed.
+type MapRace struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+
+	// writesAfterFreeze counts the user space writes that succeeded even
+	// though the map had already been frozen.
+	writesAfterFreeze int64
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (mr *MapRace) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	mr.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", mr.programCount, mr.validProgramCount)
+
+	// Frozen maps cannot be reused, so start over with a new map every time.
+	ffi.CloseFD(mr.mapFd)
+	mr.mapFd = ffi.CreateMapArray(mapRaceMapSize)
+	if mr.mapFd < 0 {
+		return nil, mapCreationFailed
+	}
+
+	// R6 holds the map pointer and R7 accumulates the values read from it.
+	insn, err := InstructionSequence(
+		LdMapByFd(R6, mr.mapFd),
+		Mov64(R7, 0),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	lookups := rand.SharedRNG.RandRange(1, mapRaceMaxLookups)
+	for i := uint64(0); i < lookups; i++ {
+		key := int32(rand.SharedRNG.RandRange(0, mapRaceMapSize-1))
+		lookup, err := InstructionSequence(
+			StW(R10, key, -4),
+			Mov64(R2, R10),
+			Add64(R2, -4),
+			Mov64(R1, R6),
+			Call(MapLookup),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Read the element and, sometimes, also update it from the program
+		// side.
+		access := []*epb.Instruction{
+			LdDW(R1, R0, 0),
+			Add64(R7, R1),
+		}
+		if rand.SharedRNG.OneOf(2) {
+			access = append(access, Mov64(R1, 1), MemAdd64(R0, R1, 0))
+		}
+
+		insn = append(insn, lookup...)
+		insn = append(insn, JmpEQ(R0, 0, int16(len(access))))
+		insn = append(insn, access...)
+	}
+
+	footer, err := InstructionSequence(
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &epb.Program{Instructions: append(insn, footer...)}, nil
+}
+
+// race executes the program described by `progFd` several times while a
+// goroutine keeps writing to the map from user space.
+func (mr *MapRace) race(ffi *units.FFI, progFd int64) {
+	freeze := rand.SharedRNG.OneOf(2)
+	freezeAt := int(rand.SharedRNG.RandRange(0, mapRaceExecutions-1))
+
+	var frozen, stop atomic.Bool
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// rand.SharedRNG is not safe for concurrent use, the writer just
+		// walks over the map instead.
+		for i := uint64(0); !stop.Load(); i++ {
+			wasFrozen := frozen.Load()
+			if ffi.SetMapElement(mr.mapFd, uint32(i%mapRaceMapSize), i) == 0 && wasFrozen {
+				atomic.AddInt64(&mr.writesAfterFreeze, 1)
+			}
+		}
+	}()
+
+	for i := 0; i < mapRaceExecutions; i++ {
+		if freeze && i == freezeAt && ffi.FreezeMap(mr.mapFd) == 0 {
+			frozen.Store(true)
+		}
+		if _, err := ffi.RunProgram(&fpb.ExecutionRequest{ProgFd: progFd}); err != nil {
+			logging.Warningf("RunProgram error: %v\n", err)
+			break
+		}
+	}
+
+	stop.Store(true)
+	wg.Wait()
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (mr *MapRace) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	mr.validProgramCount += 1
+	mr.race(ffi, verificationResult.ProgramFd)
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (mr *MapRace) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	writes := atomic.SwapInt64(&mr.writesAfterFreeze, 0)
+	if writes != 0 {
+		logging.Infof("%d user space writes succeeded on a frozen map\n", writes)
+		return false
+	}
+	return true
+}
+
+// SkipMinimization is true, reproducing a finding would race and freeze the
+// map of the program again.
+func (mr *MapRace) SkipMinimization() bool {
+	return true
+}
+
+// Maps returns the map shared between user space and the programs.
+func (mr *MapRace) Maps() map[int]uint64 {
+	return map[int]uint64{mr.mapFd: mapRaceMapSize}
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (mr *MapRace) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (mr *MapRace) IsFuzzingDone() bool {
+	return mr.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (mr *MapRace) Name() string {
+	return "map_race"
+}
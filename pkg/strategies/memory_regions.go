@@ -0,0 +1,167 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Size of the values of the map whose element programs access.
+	memoryRegionsValueSize = 64
+
+	// Offset of the map key on the stack.
+	memoryRegionsKeyOffset = -4
+
+	// Maximum number of memory accesses of a program.
+	memoryRegionsMaxAccesses = 8
+)
+
+func NewMemoryRegionsStrategy() *MemoryRegions {
+	return &MemoryRegions{isFinished: false, mapFd: -1}
+}
+
+// MemoryRegions is a strategy that loads from and stores to every region a
+// BPF_PROG_TYPE_SCHED_CLS program can reach: the stack, the __sk_buff
+// context, the packet data and a map value. Each access comes with the
+// bounds checks the verifier asks for, see ebpf.RandomMemoryAccess, and
+// some of them break a rule of their region on purpose. The verifier must
+// reject the programs with such an access, those it accepts are reported.
+type MemoryRegions struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+
+	// Accesses of the last generated program.
+	accesses []*MemoryAccess
+}
+
+// violations returns the rules the accesses of the last program break.
+func (mr *MemoryRegions) violations() []string {
+	var violations []string
+	for _, access := range mr.accesses {
+		if access.Violation != "" {
+			violations = append(violations, fmt.Sprintf("%v: %s", access.Region, access.Violation))
+		}
+	}
+	return violations
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (mr *MemoryRegions) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	mr.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", mr.programCount, mr.validProgramCount)
+
+	if mr.mapFd < 0 {
+		mr.mapFd = ffi.CreateMap(units.MapTypeArray, 4, memoryRegionsValueSize, 1, 0)
+		if mr.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	// R6 holds the context and R7 points to the only element of the
+	// map, the accesses only clobber R0-R5.
+	insn := []*epb.Instruction{
+		Mov64(R6, R1),
+		LdMapByFd(R1, mr.mapFd),
+		StW(R10, 0, memoryRegionsKeyOffset),
+		Mov64(R2, R10),
+		Add64(R2, memoryRegionsKeyOffset),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+		Mov64(R7, R0),
+	}
+	env := &MemoryEnvironment{
+		HasCtx:    true,
+		CtxReg:    R6,
+		ValueReg:  R7,
+		ValueSize: memoryRegionsValueSize,
+	}
+	mr.accesses = nil
+	for i := rand.SharedRNG.RandRange(1, memoryRegionsMaxAccesses); i > 0; i-- {
+		access, err := RandomMemoryAccess(env)
+		if err != nil {
+			return nil, err
+		}
+		mr.accesses = append(mr.accesses, access)
+		insn = append(insn, access.Instructions...)
+	}
+	insn = append(insn, Mov64(R0, 0), Exit())
+	return &epb.Program{Instructions: insn}, nil
+}
+
+// ProgramType returns the type the programs are loaded as.
+func (mr *MemoryRegions) ProgramType() int {
+	return units.ProgTypeSchedCls
+}
+
+// Decisions returns the regions the last program accessed for the
+// decision log.
+func (mr *MemoryRegions) Decisions() []string {
+	var decisions []string
+	for _, access := range mr.accesses {
+		decisions = append(decisions, access.Region.String())
+	}
+	return decisions
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (mr *MemoryRegions) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	mr.validProgramCount += 1
+	if violations := mr.violations(); len(violations) > 0 {
+		logging.Infof("verifier accepted a program with %s\n", strings.Join(violations, ", "))
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (mr *MemoryRegions) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	// Reaching this point with a violation is already a bug.
+	return len(mr.violations()) == 0
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (mr *MemoryRegions) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (mr *MemoryRegions) IsFuzzingDone() bool {
+	return mr.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (mr *MemoryRegions) Name() string {
+	return "memory_regions"
+}
@@ -0,0 +1,364 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"buzzer/pkg/corpus/corpus"
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/mutator/mutator"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	cpb "buzzer/proto/corpus_go_proto"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"fmt"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+const (
+	// Size of the map every program of the population uses.
+	mutationMapSize = 16
+
+	// Maximum number of mutations applied to a program before it is sent
+	// to the verifier.
+	mutationMaxMutations = 4
+
+	// Number of random instructions of the programs generated when the
+	// population is empty.
+	mutationRandomInstructions = 16
+)
+
+func NewMutationBasedStrategy() *MutationBased {
+	return &MutationBased{
+		isFinished:           false,
+		mapFd:                -1,
+		programType:          units.ProgTypeSocketFilter,
+		pq:                   NewPriorityQueue(),
+		coverageHashTable:    make(map[uint64]bool),
+		fingerprintHashTable: make(map[uint64]bool),
+	}
+}
+
+// MutationBased is a strategy that keeps a population of programs, seeded
+// from an existing corpus or generated from scratch, and produces variants
+// of them with the mutator package. Variants that reach new verifier
+// coverage are added back to the population.
+//
+// There is no strategy specific oracle, findings come from the checks
+// done by the control unit and from kernel splats. Like coverage_based, it
+// needs `-metrics_threshold=1` to get feedback for every program.
+type MutationBased struct {
+	isFinished           bool
+	mapFd                int
+	programType          int
+	programCount         int
+	validProgramCount    int
+	pq                   *PriorityQueue
+	coverageHashTable    map[uint64]bool
+	fingerprintHashTable map[uint64]bool
+
+	// Programs that have not been tried yet, they are sent to the
+	// verifier unmodified before any mutation happens.
+	seeds []*epb.Program
+
+	// Programs added to the population since the last TakeNewPrograms.
+	newPrograms []*epb.Program
+
+	// Parent of the last program, used as splice donor for the next one.
+	lastParent  *epb.Program
+	lastProgram []*epb.Instruction
+
+	// Trace the last program was mutated from, nil if it was a seed or a
+	// random program.
+	lastTrace *CoverageTrace
+
+	// How the last program was generated, see Decisions.
+	decisions []string
+
+	// Programs are retired from the population after this many fruitless
+	// mutations in a row, or after MAX_PROG_REUSE mutations if it is 0.
+	maxFruitless int
+
+	// Receives the retired programs, if not nil.
+	archive      *corpus.Writer
+	retiredCount int
+}
+
+// SetRetirement makes programs retire from the population once
+// `maxFruitless` mutations in a row did not reach new coverage, instead of
+// after a fixed number of mutations, and appends the retired programs to
+// `archive` if it is not nil. The archive is a corpus file that can seed a
+// later campaign.
+func (mb *MutationBased) SetRetirement(maxFruitless int, archive *corpus.Writer) {
+	mb.maxFruitless = maxFruitless
+	mb.archive = archive
+}
+
+// AddSeeds adds `programs` to the initial population, e.g. the programs of a
+// corpus from a previous campaign or the programs other workers found.
+func (mb *MutationBased) AddSeeds(programs ...*epb.Program) {
+	mb.seeds = append(mb.seeds, programs...)
+}
+
+// SetProgramType makes the programs of the population load as `progType`
+// instead of socket filters, e.g. as ProgTypeSchedCls when some of the seeds
+// are tc programs. The socket filters get a subset of the context and of the
+// helpers of the tc programs, so their seeds load as tc programs too.
+func (mb *MutationBased) SetProgramType(progType int) {
+	mb.programType = progType
+}
+
+// ProgramType returns the BPF_PROG_TYPE_* the programs are loaded as, see
+// SetProgramType.
+func (mb *MutationBased) ProgramType() int {
+	return mb.programType
+}
+
+// TakeNewPrograms returns the programs that reached new coverage since the
+// last call, so they can be shared with other workers.
+func (mb *MutationBased) TakeNewPrograms() []*epb.Program {
+	programs := mb.newPrograms
+	mb.newPrograms = nil
+	return programs
+}
+
+// Population returns the seeds that were not tried yet and the programs of
+// the population, so they can be saved in checkpoints.
+func (mb *MutationBased) Population() []*epb.Program {
+	programs := append([]*epb.Program{}, mb.seeds...)
+	for _, trace := range mb.pq.Traces() {
+		programs = append(programs, &epb.Program{Instructions: trace.Program})
+	}
+	return programs
+}
+
+// pointMapsTo returns a copy of `prog` where every map load uses `fd`.
+func pointMapsTo(prog *epb.Program, fd int) *epb.Program {
+	fds := make(map[int]int)
+	for _, insn := range prog.Instructions {
+		if insn.SrcReg == PseudoMapFD && InstructionWidth(insn) == 2 {
+			fds[int(insn.Immediate)] = fd
+		}
+	}
+	return RemapMapFds(prog, fds)
+}
+
+// isStale returns true if `trace` is due for retirement.
+func (mb *MutationBased) isStale(trace *CoverageTrace) bool {
+	if mb.maxFruitless > 0 {
+		return trace.FruitlessCount >= mb.maxFruitless
+	}
+	return trace.UsageCount >= MAX_PROG_REUSE
+}
+
+// retire drops `trace` from the population for good, appending it to the
+// archive if there is one.
+func (mb *MutationBased) retire(trace *CoverageTrace) {
+	mb.retiredCount += 1
+	if mb.archive == nil {
+		return
+	}
+	entry := &cpb.CorpusEntry{
+		Program:            &epb.Program{Instructions: trace.Program},
+		Seed:               rand.SharedSeed(),
+		Strategy:           mb.Name(),
+		IsValid:            true,
+		MapSizes:           map[int64]uint64{int64(mb.mapFd): mutationMapSize},
+		Mutations:          uint64(trace.UsageCount),
+		FruitlessMutations: uint64(trace.FruitlessCount),
+	}
+	if err := mb.archive.Write(entry); err != nil {
+		logging.Warningf("Archive write error: %v\n", err)
+	}
+}
+
+// nextParent returns the program of the population to mutate next, which
+// stays in the population with one more use. The stale programs found on
+// the way are retired. Returns nil if the population ends up empty.
+func (mb *MutationBased) nextParent() *CoverageTrace {
+	for !mb.pq.IsEmpty() {
+		trace := mb.pq.Pop()
+		if mb.isStale(trace) {
+			mb.retire(trace)
+			continue
+		}
+		trace.UsageCount += 1
+		mb.pq.Push(trace)
+		return trace
+	}
+	return nil
+}
+
+// randomProgram returns a program that looks up the first map element and
+// then runs random instructions.
+func (mb *MutationBased) randomProgram() *epb.Program {
+	insn, _ := InstructionSequence(
+		LdMapByFd(R1, mb.mapFd),
+		StW(R10, 0, -4),
+		Mov64(R2, R10),
+		Add64(R2, -4),
+		Call(MapLookup),
+		JmpNE(R0, 0, 1),
+		Exit(),
+	)
+	for i := 0; i < mutationRandomInstructions; i++ {
+		insn = append(insn, newRandomInstruction(uint64(mutationRandomInstructions-i-1)))
+	}
+	insn = append(insn, Mov64(R0, 0), Exit())
+	return &epb.Program{Instructions: insn}
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (mb *MutationBased) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	logging.Debugf("Program count: %d, Valid Programs: %d, Population: %d, Retired: %d\t\t\r", mb.programCount, mb.validProgramCount, mb.pq.Len(), mb.retiredCount)
+	mb.programCount += 1
+
+	if mb.mapFd < 0 {
+		mb.mapFd = ffi.CreateMapArray(mutationMapSize)
+		if mb.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	var prog *epb.Program
+	mb.lastTrace = nil
+	if len(mb.seeds) == 0 {
+		mb.lastTrace = mb.nextParent()
+	}
+	switch {
+	case len(mb.seeds) > 0:
+		prog = mb.seeds[0]
+		mb.seeds = mb.seeds[1:]
+		mb.decisions = []string{"seed"}
+	case mb.lastTrace == nil:
+		prog = mb.randomProgram()
+		mb.decisions = []string{"random program"}
+	default:
+		parent := &epb.Program{Instructions: mb.lastTrace.Program}
+		prog = parent
+		mutations := rand.SharedRNG.RandRange(1, mutationMaxMutations)
+		applied := 0
+		for i := uint64(0); i < mutations; i++ {
+			mutated, err := mutator.Mutate(prog, mb.lastParent)
+			if err == mutator.NoCandidates {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			prog = mutated
+			applied++
+		}
+		mb.lastParent = parent
+		mb.decisions = []string{
+			fmt.Sprintf("parent with coverage signature %#x", mb.lastTrace.CoverageSignature),
+			fmt.Sprintf("%d mutations", applied),
+		}
+	}
+
+	prog = pointMapsTo(prog, mb.mapFd)
+	mb.lastProgram = prog.Instructions
+	return prog, nil
+}
+
+// age records whether the last program reached new coverage on the trace it
+// was mutated from. Programs whose coverage was not collected don't count.
+func (mb *MutationBased) age(newCoverage bool) {
+	if mb.lastTrace == nil {
+		return
+	}
+	if newCoverage {
+		mb.lastTrace.FruitlessCount = 0
+	} else {
+		mb.lastTrace.FruitlessCount += 1
+	}
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (mb *MutationBased) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		mb.age(false)
+		return false
+	}
+	mb.validProgramCount += 1
+
+	if !verificationResult.DidCollectCoverage {
+		logging.Warningf("Failed to collect coverage %s\n\t\t\t\t", protobuf.MarshalTextString(verificationResult))
+		return true
+	}
+
+	newAddr := false
+	fingerPrint := uint64(0)
+	for _, addr := range verificationResult.CoverageAddress {
+		if !mb.coverageHashTable[addr] {
+			newAddr = true
+			mb.coverageHashTable[addr] = true
+		}
+		fingerPrint ^= addr
+	}
+	newFingerPrint := !mb.fingerprintHashTable[fingerPrint]
+	mb.fingerprintHashTable[fingerPrint] = true
+
+	mb.age(newAddr || newFingerPrint)
+	if newAddr || newFingerPrint {
+		mb.pq.Push(&CoverageTrace{
+			Program:           mb.lastProgram,
+			CoverageSignature: fingerPrint,
+			CoverageSize:      uint64(len(verificationResult.CoverageAddress)),
+			UsageCount:        0,
+		})
+		mb.newPrograms = append(mb.newPrograms, &epb.Program{Instructions: mb.lastProgram})
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (mb *MutationBased) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+// Decisions describes where the last program comes from for the decision
+// log.
+func (mb *MutationBased) Decisions() []string {
+	return mb.decisions
+}
+
+// Maps returns the map every program of the population uses.
+func (mb *MutationBased) Maps() map[int]uint64 {
+	return map[int]uint64{mb.mapFd: mutationMapSize}
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (mb *MutationBased) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (mb *MutationBased) IsFuzzingDone() bool {
+	return mb.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (mb *MutationBased) Name() string {
+	return "mutation_based"
+}
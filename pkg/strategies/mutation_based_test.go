@@ -0,0 +1,179 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"bytes"
+	"testing"
+
+	"buzzer/pkg/corpus/corpus"
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestMutationBasedPopulation(t *testing.T) {
+	seed := &epb.Program{Instructions: []*epb.Instruction{Mov64(R0, 0), Exit()}}
+	mb := NewMutationBasedStrategy()
+	mb.AddSeeds(seed)
+	h := units.NewStrategyHarness(mb)
+
+	tests := []struct {
+		testName       string
+		response       units.CannedResponse
+		wantPopulation int
+	}{
+		{
+			testName:       "Seed reaches new coverage",
+			response:       units.CannedResponse{Validation: units.VerifierAcceptance(0x10, 0x20)},
+			wantPopulation: 1,
+		},
+		{
+			testName:       "Rejected variant",
+			response:       units.CannedResponse{Validation: units.VerifierRejection("R0 !read_ok")},
+			wantPopulation: 1,
+		},
+		{
+			testName:       "Variant with the same coverage",
+			response:       units.CannedResponse{Validation: units.VerifierAcceptance(0x10, 0x20)},
+			wantPopulation: 1,
+		},
+		{
+			testName:       "Variant reaches new coverage",
+			response:       units.CannedResponse{Validation: units.VerifierAcceptance(0x10, 0x30)},
+			wantPopulation: 2,
+		},
+	}
+
+	for i, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			res, err := h.Step(tc.response)
+			if err != nil {
+				t.Fatalf("Step() = %v", err)
+			}
+			if i == 0 && !protobuf.Equal(res.Program, seed) {
+				t.Errorf("first program = %v, want the seed %v", res.Program, seed)
+			}
+			if res.Executed != tc.response.Validation.GetIsValid() {
+				t.Errorf("Executed = %v, want %v", res.Executed, tc.response.Validation.GetIsValid())
+			}
+			if got := mb.pq.Len(); got != tc.wantPopulation {
+				t.Errorf("population = %d, want %d", got, tc.wantPopulation)
+			}
+		})
+	}
+}
+
+func TestMutationBasedRetirement(t *testing.T) {
+	seed := &epb.Program{Instructions: []*epb.Instruction{Mov64(R0, 0), Exit()}}
+	var archive bytes.Buffer
+	mb := NewMutationBasedStrategy()
+	mb.SetRetirement(2, corpus.NewWriter(&archive))
+	mb.AddSeeds(seed)
+	h := units.NewStrategyHarness(mb)
+
+	tests := []struct {
+		testName string
+		response units.CannedResponse
+		// Fruitless mutations of the parent of the program, -1 if it has
+		// none.
+		wantFruitless  int
+		wantPopulation int
+		wantRetired    int
+	}{
+		{
+			testName:       "Seed reaches new coverage",
+			response:       units.CannedResponse{Validation: units.VerifierAcceptance(0x10, 0x20)},
+			wantFruitless:  -1,
+			wantPopulation: 1,
+		},
+		{
+			testName:       "Rejected variant",
+			response:       units.CannedResponse{Validation: units.VerifierRejection("R0 !read_ok")},
+			wantFruitless:  1,
+			wantPopulation: 1,
+		},
+		{
+			testName:       "Variant reaches new coverage",
+			response:       units.CannedResponse{Validation: units.VerifierAcceptance(0x10)},
+			wantFruitless:  0,
+			wantPopulation: 2,
+		},
+		{
+			testName:       "Seed is mutated again, the variant has less coverage",
+			response:       units.CannedResponse{Validation: units.VerifierRejection("R0 !read_ok")},
+			wantFruitless:  1,
+			wantPopulation: 2,
+		},
+		{
+			testName:       "Variant with the same coverage",
+			response:       units.CannedResponse{Validation: units.VerifierAcceptance(0x10, 0x20)},
+			wantFruitless:  2,
+			wantPopulation: 2,
+		},
+		{
+			testName:       "Seed is retired",
+			response:       units.CannedResponse{Validation: units.VerifierRejection("R0 !read_ok")},
+			wantFruitless:  1,
+			wantPopulation: 1,
+			wantRetired:    1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if _, err := h.Step(tc.response); err != nil {
+				t.Fatalf("Step() = %v", err)
+			}
+			fruitless := -1
+			if mb.lastTrace != nil {
+				fruitless = mb.lastTrace.FruitlessCount
+			}
+			if fruitless != tc.wantFruitless {
+				t.Errorf("fruitless mutations = %d, want %d", fruitless, tc.wantFruitless)
+			}
+			if got := mb.pq.Len(); got != tc.wantPopulation {
+				t.Errorf("population = %d, want %d", got, tc.wantPopulation)
+			}
+			if mb.retiredCount != tc.wantRetired {
+				t.Errorf("retired = %d, want %d", mb.retiredCount, tc.wantRetired)
+			}
+		})
+	}
+
+	entry, err := corpus.NewReader(&archive).Next()
+	if err != nil {
+		t.Fatalf("reading the archive: %v", err)
+	}
+	if !protobuf.Equal(entry.GetProgram(), seed) {
+		t.Errorf("archived program = %v, want the seed %v", entry.GetProgram(), seed)
+	}
+	if entry.GetMutations() != 4 || entry.GetFruitlessMutations() != 2 {
+		t.Errorf("archived mutations = %d, fruitless = %d, want 4 and 2", entry.GetMutations(), entry.GetFruitlessMutations())
+	}
+}
+
+func TestMutationBasedProgramType(t *testing.T) {
+	mb := NewMutationBasedStrategy()
+	if got := mb.ProgramType(); got != units.ProgTypeSocketFilter {
+		t.Errorf("ProgramType() = %d, want socket filters by default", got)
+	}
+	mb.SetProgramType(units.ProgTypeSchedCls)
+	if got := mb.ProgramType(); got != units.ProgTypeSchedCls {
+		t.Errorf("ProgramType() = %d after SetProgramType(), want %d", got, units.ProgTypeSchedCls)
+	}
+}
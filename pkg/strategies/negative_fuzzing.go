@@ -0,0 +1,91 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func NewNegativeFuzzingStrategy() *NegativeFuzzing {
+	return &NegativeFuzzing{isFinished: false}
+}
+
+// NegativeFuzzing is a strategy that generates programs with a single
+// instruction the kernel must refuse: reserved opcode bits or fields set,
+// invalid combinations of mode, size and class or registers that don't
+// exist. None of them is ever executed, the fuzzer reports the ones the
+// verifier accepts, see units.NegativeStrategy.
+type NegativeFuzzing struct {
+	isFinished        bool
+	programCount      int
+	validProgramCount int
+
+	// Invalid encoding of the last generated program.
+	last InvalidEncoding
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (nf *NegativeFuzzing) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	nf.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", nf.programCount, nf.validProgramCount)
+	nf.last = RandomInvalidEncoding()
+	return nf.last.Program, nil
+}
+
+// LastInvalidEncoding returns the invalid encoding of the last generated
+// program.
+func (nf *NegativeFuzzing) LastInvalidEncoding() InvalidEncoding {
+	return nf.last
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (nf *NegativeFuzzing) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if verificationResult.IsValid {
+		nf.validProgramCount += 1
+		logging.Infof("verifier accepted %s\n", nf.last.Name)
+	}
+	// Whatever the accepted instruction does, running it is not safe.
+	return false
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (nf *NegativeFuzzing) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (nf *NegativeFuzzing) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (nf *NegativeFuzzing) IsFuzzingDone() bool {
+	return nf.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (nf *NegativeFuzzing) Name() string {
+	return "negative_fuzzing"
+}
@@ -0,0 +1,44 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+)
+
+func TestNegativeFuzzingPrograms(t *testing.T) {
+	nf := NewNegativeFuzzingStrategy()
+	var _ units.NegativeStrategy = nf
+	h := units.NewStrategyHarness(nf)
+	for i := 0; i < 100; i++ {
+		res, err := h.Step(units.CannedResponse{Validation: units.VerifierAcceptance()})
+		if err != nil {
+			t.Fatalf("Step() error: %v", err)
+		}
+		if res.Executed {
+			t.Fatalf("program %d was executed", i)
+		}
+		last := nf.LastInvalidEncoding()
+		if res.Program != last.Program {
+			t.Fatalf("LastInvalidEncoding() is not the encoding of program %d", i)
+		}
+		if _, err := EncodeInstructions(res.Program); err != nil {
+			t.Errorf("%s: could not encode the program: %v", last.Name, err)
+		}
+	}
+}
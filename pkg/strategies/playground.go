@@ -2,10 +2,10 @@ package strategies
 
 import (
 	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
 	"buzzer/pkg/units/units"
 	epb "buzzer/proto/ebpf_go_proto"
 	fpb "buzzer/proto/ffi_go_proto"
-	"fmt"
 )
 
 func NewPlaygroundStrategy() *Playground {
@@ -34,7 +34,7 @@ func (pg *Playground) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
 // can also tell the fuzzer to continue with execution by returning true
 // or start over and generate a new program by returning false.
 func (pg *Playground) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
-	fmt.Println(verificationResult.VerifierLog)
+	logging.Infof("%s\n", verificationResult.VerifierLog)
 	pg.isFinished = true
 	return true
 }
@@ -48,7 +48,7 @@ func (pg *Playground) OnExecuteDone(ffi *units.FFI, executionResult *fpb.Executi
 // OnError is used to determine if the fuzzer should continue on errors.
 // true represents continue, false represents halt.
 func (pg *Playground) OnError(e error) bool {
-	fmt.Printf("error %v\n", e)
+	logging.Warningf("error %v\n", e)
 	return false
 }
 
@@ -2,12 +2,12 @@ package strategies
 
 import (
 	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
 	"buzzer/pkg/rand"
 	"buzzer/pkg/units/units"
 	epb "buzzer/proto/ebpf_go_proto"
 	fpb "buzzer/proto/ffi_go_proto"
 	"errors"
-	"fmt"
 )
 
 var (
@@ -30,7 +30,7 @@ type PointerArithmetic struct {
 // GenerateProgram should return the instructions to feed the verifier.
 func (pa *PointerArithmetic) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
 	pa.programCount += 1
-	fmt.Printf("Generated %d programs, %d were valid               \r", pa.programCount, pa.validProgramCount)
+	logging.Debugf("Generated %d programs, %d were valid               \r", pa.programCount, pa.validProgramCount)
 
 	// header contains the initialization of all registers with random values.
 	header, err := InstructionSequence(
@@ -129,7 +129,7 @@ func (pa *PointerArithmetic) GenerateProgram(ffi *units.FFI) (*epb.Program, erro
 // or start over and generate a new program by returning false.
 func (pa *PointerArithmetic) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
 	if pa.programCount%500 == 0 {
-		fmt.Println(verificationResult.VerifierLog)
+		logging.Infof("%s\n", verificationResult.VerifierLog)
 	}
 	if verificationResult.IsValid {
 		pa.validProgramCount += 1
@@ -142,17 +142,22 @@ func (pa *PointerArithmetic) OnVerifyDone(ffi *units.FFI, verificationResult *fp
 func (pa *PointerArithmetic) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
 	mapElements, err := ffi.GetMapElements(pa.mapFd, 2)
 	if err != nil {
-		fmt.Println(err)
+		logging.Warningf("%v\n", err)
 		return true
 	}
 
 	return mapElements.Elements[0] == mapElements.Elements[1]
 }
 
+// Maps returns the map the footer writes its control and test values to.
+func (pa *PointerArithmetic) Maps() map[int]uint64 {
+	return map[int]uint64{pa.mapFd: 2}
+}
+
 // OnError is used to determine if the fuzzer should continue on errors.
 // true represents continue, false represents halt.
 func (pa *PointerArithmetic) OnError(e error) bool {
-	fmt.Printf("error %v\n", e)
+	logging.Warningf("error %v\n", e)
 	return false
 }
 
@@ -0,0 +1,342 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Map layout: element 0 receives the value the probe returned,
+	// element 1 the marker written once the program ran and element 2 is
+	// the destination of the probes that write to a map value.
+	probeReadMapSize     = 3
+	probeReadRetvalKey   = 0
+	probeReadMarkerKey   = 1
+	probeReadDstKey      = 2
+	probeReadMarker      = 0x50524f4245
+	probeReadValueSize   = 8
+	probeReadKeyOffset   = -72
+	probeReadMaxStackDst = 64
+
+	// Scratch stack slot the pointers read from the task are stored to.
+	probeReadScratchSlot = -8
+
+	// The programs run on the syscall tracepoint of getppid, the fuzzer
+	// thread is the only one they are attached to.
+	probeReadTracepoint = "syscalls/sys_enter_getppid"
+
+	// Biggest value a helper returns as an error, -MAX_ERRNO.
+	probeReadMaxErrno = 4095
+)
+
+// probeReadHelpers are the helpers that read an unsafe pointer, see
+// ebpf.ArgUnsafePtr.
+var probeReadHelpers = []int32{ProbeRead, ProbeReadStr, ProbeReadUser, ProbeReadKernel, ProbeReadUserStr, ProbeReadKernelStr}
+
+// probeReadSource is where the pointer the probe reads comes from.
+type probeReadSource int
+
+const (
+	// The task_struct returned by bpf_get_current_task, at an offset.
+	taskSource probeReadSource = iota
+	// A pointer stored in the task_struct, read with a first probe.
+	taskFieldSource
+	// The context itself, at an offset.
+	ctxSource
+	// A field of the tracepoint record the context points to.
+	ctxFieldSource
+	// The stack of the program.
+	stackSource
+	// An arbitrary address, e.g. NULL or a user space one.
+	constantSource
+)
+
+func (s probeReadSource) String() string {
+	return []string{"task", "task field", "ctx", "ctx field", "stack", "constant"}[s]
+}
+
+// isStringProbe returns true if `helper` reads a NUL terminated string, it
+// returns the length of the string it copied.
+func isStringProbe(helper int32) bool {
+	return helper == ProbeReadStr || helper == ProbeReadUserStr || helper == ProbeReadKernelStr
+}
+
+// probeReadRetvalValid returns true if `ret` is something `helper` can
+// return when it was passed `size`: 0 or an error for the plain probes, the
+// length copied, including the NUL byte, or an error for the string ones.
+func probeReadRetvalValid(helper int32, size int64, ret int64) bool {
+	if ret < -probeReadMaxErrno {
+		return false
+	}
+	if ret <= 0 {
+		return true
+	}
+	return isStringProbe(helper) && ret <= size
+}
+
+func NewProbeReadsStrategy() *ProbeReads {
+	return &ProbeReads{isFinished: false, mapFd: -1}
+}
+
+// ProbeReads is a strategy for tracing programs, which run in a very different
+// verifier environment than socket filters. Its programs are attached to a
+// benign tracepoint, get kernel pointers from bpf_get_current_task, from the
+// context or from the task_struct and read them with the probe_read helpers
+// with fuzzed sizes and destinations. The verifier must reject the sizes that
+// don't fit the destination, for the other programs the value returned by the
+// helper is checked.
+type ProbeReads struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+
+	// State of the last generated program.
+	helper int32
+	source probeReadSource
+	// room is how many bytes the destination can receive, maxSize the
+	// biggest size the program can pass.
+	room    int64
+	maxSize int64
+	minSize int64
+}
+
+// mapLookup returns the lookup of `key` in the map, the program exits if it
+// fails. The pointer to the value ends up in R0.
+func (pr *ProbeReads) mapLookup(key int32) []*epb.Instruction {
+	return []*epb.Instruction{
+		LdMapByFd(R1, pr.mapFd),
+		StW(R10, key, probeReadKeyOffset),
+		Mov64(R2, R10),
+		Add64(R2, probeReadKeyOffset),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+	}
+}
+
+// randomOffset returns an offset from a pointer, mostly small and aligned.
+func randomOffset() int32 {
+	switch rand.SharedRNG.RandRange(0, 3) {
+	case 0:
+		return 0
+	case 1, 2:
+		return int32(rand.SharedRNG.RandRange(0, 512)) * 8
+	default:
+		return int32(rand.SharedRNG.RandInt())
+	}
+}
+
+// sourceInstructions returns the instructions that leave the pointer to read
+// from in R8. The context is in R6.
+func (pr *ProbeReads) sourceInstructions() []*epb.Instruction {
+	switch pr.source {
+	case taskSource:
+		return []*epb.Instruction{Call(GetCurrentTask), Mov64(R8, R0), Add64(R8, randomOffset())}
+	case taskFieldSource:
+		return []*epb.Instruction{
+			Call(GetCurrentTask),
+			Mov64(R3, R0),
+			Add64(R3, int32(rand.SharedRNG.RandRange(0, 512))*8),
+			Mov64(R1, R10),
+			Add64(R1, probeReadScratchSlot),
+			Mov64(R2, 8),
+			Call(ProbeReadKernel),
+			LdDW(R8, R10, probeReadScratchSlot),
+		}
+	case ctxSource:
+		return []*epb.Instruction{Mov64(R8, R6), Add64(R8, randomOffset())}
+	case ctxFieldSource:
+		return []*epb.Instruction{LdDW(R8, R6, int16(rand.SharedRNG.RandRange(0, 3))*8)}
+	case stackSource:
+		return []*epb.Instruction{Mov64(R8, R10), Add64(R8, -int32(rand.SharedRNG.RandRange(1, 512)))}
+	default:
+		addresses := []uint64{0, 1, 0x1000, 0x7fffffffe000, 0xffff800000000000, 0xffffffffff600000, ^uint64(0)}
+		address := addresses[rand.SharedRNG.RandRange(0, uint64(len(addresses)-1))]
+		return []*epb.Instruction{LdImm64(R8, address)}
+	}
+}
+
+// sizeInstructions returns the instructions that leave the size of the probe
+// in R7, either a constant or a random number bounded by a mask, and sets
+// minSize and maxSize.
+func (pr *ProbeReads) sizeInstructions() []*epb.Instruction {
+	if rand.SharedRNG.OneOf(3) {
+		masks := []int64{pr.room, pr.room + 1, 2*pr.room - 1, 0xff}
+		mask := masks[rand.SharedRNG.RandRange(0, uint64(len(masks)-1))]
+		pr.minSize, pr.maxSize = 0, mask
+		return []*epb.Instruction{Call(GetPrandomU32), Mov64(R7, R0), And64(R7, int32(mask))}
+	}
+
+	var size int64
+	switch rand.SharedRNG.RandRange(0, 4) {
+	case 0, 1:
+		size = int64(rand.SharedRNG.RandRange(0, uint64(pr.room)))
+	case 2:
+		size = pr.room + 1
+	case 3:
+		size = pr.room + int64(rand.SharedRNG.RandRange(2, 4096))
+	default:
+		size = -int64(rand.SharedRNG.RandRange(1, 1<<31))
+	}
+	pr.minSize, pr.maxSize = size, size
+	return []*epb.Instruction{Mov64(R7, int32(size))}
+}
+
+// violation returns true if the sizes the program can pass don't fit the
+// destination, the verifier must reject it.
+func (pr *ProbeReads) violation() bool {
+	return pr.minSize < 0 || pr.maxSize > pr.room
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (pr *ProbeReads) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	pr.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", pr.programCount, pr.validProgramCount)
+
+	if pr.mapFd < 0 {
+		pr.mapFd = ffi.CreateMapArray(probeReadMapSize)
+		if pr.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	pr.helper = probeReadHelpers[rand.SharedRNG.RandRange(0, uint64(len(probeReadHelpers)-1))]
+	pr.source = probeReadSource(rand.SharedRNG.RandRange(0, uint64(constantSource)))
+
+	// The destination is either the stack or a map value, R9 points to
+	// it.
+	insn := []*epb.Instruction{Mov64(R6, R1)}
+	if rand.SharedRNG.OneOf(2) {
+		offset := int32(rand.SharedRNG.RandRange(0, probeReadValueSize-1))
+		pr.room = probeReadValueSize - int64(offset)
+		insn = append(insn, pr.mapLookup(probeReadDstKey)...)
+		insn = append(insn, Mov64(R9, R0), Add64(R9, offset))
+	} else {
+		offset := int32(rand.SharedRNG.RandRange(1, probeReadMaxStackDst))
+		pr.room = int64(offset)
+		insn = append(insn, Mov64(R9, R10), Add64(R9, -offset))
+	}
+	insn = append(insn, pr.sourceInstructions()...)
+	insn = append(insn, pr.sizeInstructions()...)
+	insn = append(insn,
+		Mov64(R1, R9),
+		Mov64(R2, R7),
+		Mov64(R3, R8),
+		Call(pr.helper),
+		Mov64(R7, R0),
+	)
+
+	// Record the value returned and that the program ran.
+	insn = append(insn, pr.mapLookup(probeReadRetvalKey)...)
+	insn = append(insn, StDW(R0, R7, 0))
+	insn = append(insn, pr.mapLookup(probeReadMarkerKey)...)
+	insn = append(insn, LdImm64(R7, probeReadMarker), StDW(R0, R7, 0), Mov64(R0, 0), Exit())
+	prog, err := InstructionSequence(insn...)
+	if err != nil {
+		return nil, err
+	}
+	return &epb.Program{Instructions: prog}, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (pr *ProbeReads) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	pr.validProgramCount += 1
+	if pr.violation() {
+		logging.Infof("verifier accepted a %s of %d to %d bytes into %d bytes\n", GetBpfFuncName(pr.helper), pr.minSize, pr.maxSize, pr.room)
+	}
+
+	for key := uint32(0); key < probeReadMapSize; key++ {
+		if ffi.SetMapElement(pr.mapFd, key, 0) != 0 {
+			logging.Warningf("could not initialize the map\n")
+			return false
+		}
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (pr *ProbeReads) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if pr.violation() {
+		return false
+	}
+	if !executionResult.GetDidSucceed() {
+		logging.Warningf("could not trigger %s: %s\n", probeReadTracepoint, executionResult.GetErrorMessage())
+		return true
+	}
+	mapElements, err := ffi.GetMapElements(pr.mapFd, probeReadMapSize)
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	if mapElements.Elements[probeReadMarkerKey] != probeReadMarker {
+		// The tracepoint did not fire, nothing ran.
+		return true
+	}
+
+	ret := int64(mapElements.Elements[probeReadRetvalKey])
+	if !probeReadRetvalValid(pr.helper, pr.maxSize, ret) {
+		logging.Infof("%s from %s returned %d for at most %d bytes\n", GetBpfFuncName(pr.helper), pr.source, ret, pr.maxSize)
+		return false
+	}
+	return true
+}
+
+// Maps returns the map receiving the probe results.
+func (pr *ProbeReads) Maps() map[int]uint64 {
+	return map[int]uint64{pr.mapFd: probeReadMapSize}
+}
+
+// ProgramType returns BPF_PROG_TYPE_TRACEPOINT, socket filters can't call
+// the probe helpers.
+func (pr *ProbeReads) ProgramType() int {
+	return units.ProgTypeTracepoint
+}
+
+// Tracepoint returns the tracepoint the programs are attached to.
+func (pr *ProbeReads) Tracepoint() string {
+	return probeReadTracepoint
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (pr *ProbeReads) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (pr *ProbeReads) IsFuzzingDone() bool {
+	return pr.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (pr *ProbeReads) Name() string {
+	return "probe_read"
+}
@@ -0,0 +1,46 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+)
+
+func TestProbeReadRetvalValid(t *testing.T) {
+	tests := []struct {
+		testName string
+		helper   int32
+		size     int64
+		ret      int64
+		want     bool
+	}{
+		{testName: "Successful read", helper: ProbeReadKernel, size: 8, ret: 0, want: true},
+		{testName: "Fault", helper: ProbeReadKernel, size: 8, ret: -14, want: true},
+		{testName: "Plain read returning a length", helper: ProbeRead, size: 8, ret: 8, want: false},
+		{testName: "String length", helper: ProbeReadKernelStr, size: 8, ret: 8, want: true},
+		{testName: "String longer than the size", helper: ProbeReadUserStr, size: 8, ret: 9, want: false},
+		{testName: "Not an errno", helper: ProbeReadStr, size: 8, ret: -4096, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := probeReadRetvalValid(tc.helper, tc.size, tc.ret); got != tc.want {
+				t.Errorf("probeReadRetvalValid(%d, %d, %d) = %v, want %v", tc.helper, tc.size, tc.ret, got, tc.want)
+			}
+		})
+	}
+}
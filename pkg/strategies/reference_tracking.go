@@ -0,0 +1,374 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"fmt"
+	"strings"
+)
+
+const (
+	// Offset of the struct bpf_sock_tuple on the stack, big enough for the
+	// ipv6 variant, and the sizes of the ipv4 and ipv6 variants.
+	sockTupleOffset   = -40
+	sockTupleIPv4Size = 12
+	sockTupleIPv6Size = 36
+
+	// Size of the records reserved from the ring buffer, all of them fit
+	// in it at once.
+	referenceRecordSize = 8
+
+	// References are held in R6 to R8, R9 holds the context.
+	maxReferences = 3
+)
+
+// referenceFate is what the generated program does with a reference.
+type referenceFate int
+
+const (
+	// The reference is null checked and released.
+	releasedReference referenceFate = iota
+	// The reference is null checked and released through a copy.
+	copiedReference
+	// The reference is released on both paths of a random branch.
+	branchReleasedReference
+
+	// The other fates mishandle the reference, the verifier must reject
+	// the programs.
+
+	// The reference is never released.
+	leakedReference
+	// The reference is released twice.
+	doubleReleasedReference
+	// The reference is only released on one path of a random branch.
+	conditionallyReleasedReference
+	// The reference is released without being null checked.
+	uncheckedReference
+	// The register holding the reference is overwritten before the
+	// release.
+	overwrittenReference
+
+	numReferenceFates
+
+	// The fates before it handle the reference correctly.
+	numValidFates = leakedReference
+)
+
+var referenceFateNames = []string{
+	"released",
+	"released through a copy",
+	"released on both branches",
+	"leaked",
+	"double released",
+	"conditionally released",
+	"released unchecked",
+	"overwritten",
+}
+
+// reference is an object the generated program acquires.
+type reference struct {
+	helper *ReferenceHelper
+	fate   referenceFate
+
+	// Size of the tuple passed to the socket lookups.
+	tupleSize int32
+
+	// Whether the ring buffer record is discarded instead of submitted.
+	discard bool
+}
+
+func NewReferenceTrackingStrategy() *ReferenceTracking {
+	return &ReferenceTracking{isFinished: false, ringbufFd: -1}
+}
+
+// ReferenceTracking is a strategy that targets the reference tracking of the
+// verifier. Programs acquire up to maxReferences sockets, from
+// bpf_sk_lookup_tcp, bpf_sk_lookup_udp and bpf_skc_lookup_tcp, and ring
+// buffer records, from bpf_ringbuf_reserve, and hold all of them before
+// releasing them in any order. See ebpf.ReferenceHelpers.
+//
+// Most programs release every reference exactly once, the others leak,
+// double release, conditionally release or overwrite one of them, or release
+// it without checking it is not NULL, and must be rejected. The lookups are
+// of a zeroed tuple, they don't find anything but the verifier can't know.
+// After every execution, the ring buffer must hold one record per
+// reservation.
+//
+// Socket filters can't look sockets up, the programs are loaded as
+// BPF_PROG_TYPE_SCHED_CLS and executed with BPF_PROG_TEST_RUN.
+type ReferenceTracking struct {
+	isFinished        bool
+	ringbufFd         int
+	programCount      int
+	validProgramCount int
+
+	// References of the last generated program and the order the program
+	// releases them in.
+	references []reference
+	order      []int
+}
+
+// referenceRegister returns the register that holds reference `i`.
+func referenceRegister(i int) epb.Reg {
+	return epb.Reg(int(R6) + i)
+}
+
+// isValid returns true if the last program handles all its references
+// correctly.
+func (rt *ReferenceTracking) isValid() bool {
+	for _, ref := range rt.references {
+		if ref.fate >= numValidFates {
+			return false
+		}
+	}
+	return true
+}
+
+// acquire returns the call that acquires reference `i` into its register.
+func (rt *ReferenceTracking) acquire(i int) []*epb.Instruction {
+	ref := rt.references[i]
+	var insn []*epb.Instruction
+	if ref.helper.Acquire == RingbufReserve {
+		insn = []*epb.Instruction{
+			LdMapByFd(R1, rt.ringbufFd),
+			Mov64(R2, referenceRecordSize),
+			Mov64(R3, 0),
+		}
+	} else {
+		// The current network namespace, without flags.
+		insn = []*epb.Instruction{
+			Mov64(R1, R9),
+			Mov64(R2, R10),
+			Add64(R2, sockTupleOffset),
+			Mov64(R3, ref.tupleSize),
+			Mov64(R4, -1),
+			Mov64(R5, 0),
+		}
+	}
+	return append(insn, Call(ref.helper.Acquire), Mov64(referenceRegister(i), R0))
+}
+
+// release returns the release of reference `i`, whose pointer is in R1 if
+// `inR1` is true.
+func (rt *ReferenceTracking) release(i int, inR1 bool) []*epb.Instruction {
+	ref := rt.references[i]
+	var insn []*epb.Instruction
+	if !inR1 {
+		insn = append(insn, Mov64(R1, referenceRegister(i)))
+	}
+	if ref.helper.Acquire != RingbufReserve {
+		return append(insn, Call(SkRelease))
+	}
+	helper := int32(RingbufSubmit)
+	if ref.discard {
+		helper = RingbufDiscard
+	}
+	return append(insn, Mov64(R2, 0), Call(helper))
+}
+
+// nullChecked returns `insn` skipped when the register `reg` is NULL.
+func nullChecked(reg epb.Reg, insn []*epb.Instruction) []*epb.Instruction {
+	return append([]*epb.Instruction{JmpEQ(reg, 0, ringbufSlots(insn))}, insn...)
+}
+
+// randomBranch returns `taken` and `notTaken` on either side of a branch on
+// a random number.
+func randomBranch(taken []*epb.Instruction, notTaken []*epb.Instruction) []*epb.Instruction {
+	insn := []*epb.Instruction{Call(GetPrandomU32)}
+	if len(notTaken) == 0 {
+		insn = append(insn, JmpEQ(R0, 0, ringbufSlots(taken)))
+		return append(insn, taken...)
+	}
+	insn = append(insn, JmpEQ(R0, 0, ringbufSlots(taken)+1))
+	insn = append(insn, taken...)
+	insn = append(insn, Jmp(ringbufSlots(notTaken)))
+	return append(insn, notTaken...)
+}
+
+// handle returns the instructions that give reference `i` its fate.
+func (rt *ReferenceTracking) handle(i int) []*epb.Instruction {
+	reg := referenceRegister(i)
+	switch rt.references[i].fate {
+	case releasedReference:
+		return nullChecked(reg, rt.release(i, false))
+	case copiedReference:
+		return append([]*epb.Instruction{Mov64(R1, reg)}, nullChecked(R1, rt.release(i, true))...)
+	case branchReleasedReference:
+		return nullChecked(reg, randomBranch(rt.release(i, false), rt.release(i, false)))
+	case doubleReleasedReference:
+		return nullChecked(reg, append(rt.release(i, false), rt.release(i, false)...))
+	case conditionallyReleasedReference:
+		return nullChecked(reg, randomBranch(rt.release(i, false), nil))
+	case uncheckedReference:
+		return rt.release(i, false)
+	case overwrittenReference:
+		return append([]*epb.Instruction{Mov64(reg, 0)}, nullChecked(reg, rt.release(i, false))...)
+	}
+	// Leaked.
+	return nil
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (rt *ReferenceTracking) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	rt.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", rt.programCount, rt.validProgramCount)
+
+	if rt.ringbufFd < 0 {
+		rt.ringbufFd = ffi.CreateMap(units.MapTypeRingbuf, 0, 0, ringbufDataSize, 0)
+		if rt.ringbufFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	rt.references = nil
+	for i := rand.SharedRNG.RandRange(1, maxReferences); i > 0; i-- {
+		ref := reference{
+			helper:    ReferenceHelpers[rand.SharedRNG.RandRange(0, uint64(len(ReferenceHelpers)-1))],
+			fate:      referenceFate(rand.SharedRNG.RandRange(0, uint64(numValidFates-1))),
+			tupleSize: sockTupleIPv4Size,
+			discard:   rand.SharedRNG.OneOf(2),
+		}
+		if rand.SharedRNG.OneOf(2) {
+			ref.tupleSize = sockTupleIPv6Size
+		}
+		rt.references = append(rt.references, ref)
+	}
+	// Half of the programs handle their references correctly so the
+	// verifier also goes past the reference checks, the others mishandle
+	// one of them.
+	if rand.SharedRNG.OneOf(2) {
+		target := rand.SharedRNG.RandRange(0, uint64(len(rt.references)-1))
+		rt.references[target].fate = referenceFate(rand.SharedRNG.RandRange(uint64(numValidFates), uint64(numReferenceFates-1)))
+	}
+	rt.order = ringbufOrder(len(rt.references))
+
+	// The tuple is zeroed, the lookups read all of it.
+	insn := []*epb.Instruction{Mov64(R9, R1)}
+	for offset := int16(sockTupleOffset); offset < 0; offset += 8 {
+		insn = append(insn, StDW(R10, 0, offset))
+	}
+	for i := range rt.references {
+		insn = append(insn, rt.acquire(i)...)
+	}
+	for _, i := range rt.order {
+		insn = append(insn, rt.handle(i)...)
+	}
+	insn = append(insn, Mov64(R0, 0), Exit())
+	return &epb.Program{Instructions: insn}, nil
+}
+
+// ProgramType returns the type the programs are loaded as.
+func (rt *ReferenceTracking) ProgramType() int {
+	return units.ProgTypeSchedCls
+}
+
+// Decisions returns the helpers and fates of the references of the last
+// program, in the order they are released, for the decision log.
+func (rt *ReferenceTracking) Decisions() []string {
+	var decisions []string
+	for _, i := range rt.order {
+		ref := rt.references[i]
+		decisions = append(decisions, fmt.Sprintf("%s %s", ref.helper.Name, referenceFateNames[ref.fate]))
+	}
+	return decisions
+}
+
+// describe returns the mishandled references of the last program.
+func (rt *ReferenceTracking) describe() string {
+	var fates []string
+	for _, ref := range rt.references {
+		if ref.fate >= numValidFates {
+			fates = append(fates, fmt.Sprintf("%s reference %s", ref.helper.Name, referenceFateNames[ref.fate]))
+		}
+	}
+	return strings.Join(fates, ", ")
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (rt *ReferenceTracking) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	rt.validProgramCount += 1
+	if !rt.isValid() {
+		logging.Infof("verifier accepted a program with a %s\n", rt.describe())
+	}
+
+	// The program must start with an empty ring buffer.
+	if _, err := ffi.ConsumeRingbuf(rt.ringbufFd, ringbufDataSize); err != nil {
+		logging.Warningf("%v\n", err)
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (rt *ReferenceTracking) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if !rt.isValid() {
+		// Reaching this point is already a bug.
+		return false
+	}
+	consumed, err := ffi.ConsumeRingbuf(rt.ringbufFd, ringbufDataSize)
+	if err == nil && consumed.GetErrorMessage() != "" {
+		err = fmt.Errorf("%s", consumed.GetErrorMessage())
+	}
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	if ffi.Maps != nil {
+		// Fake ring buffers never have records.
+		return true
+	}
+
+	want := 0
+	for _, ref := range rt.references {
+		if ref.helper.Acquire == RingbufReserve {
+			want++
+		}
+	}
+	if got := len(consumed.GetRecords()); got != want {
+		logging.Infof("ring buffer has %d records, want %d\n", got, want)
+		return false
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (rt *ReferenceTracking) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (rt *ReferenceTracking) IsFuzzingDone() bool {
+	return rt.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (rt *ReferenceTracking) Name() string {
+	return "reference_tracking"
+}
@@ -0,0 +1,42 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+)
+
+// The leak oracle must report the programs that lose a reference on every
+// path, and only those.
+func TestReferenceTrackingLeaks(t *testing.T) {
+	rt := NewReferenceTrackingStrategy()
+	rt.ringbufFd = 3
+	for i := 0; i < 1000; i++ {
+		prog, err := rt.GenerateProgram(nil)
+		if err != nil {
+			t.Fatalf("GenerateProgram() failed: %v", err)
+		}
+		obvious := false
+		for _, ref := range rt.references {
+			obvious = obvious || ref.fate == leakedReference || ref.fate == overwrittenReference
+		}
+		leaks := ebpf.ObviousLeaks(prog)
+		if obvious != (len(leaks) > 0) {
+			t.Fatalf("ObviousLeaks() = %v for references %v", leaks, rt.Decisions())
+		}
+	}
+}
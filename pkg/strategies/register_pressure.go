@@ -0,0 +1,233 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Size of the value of the only element of the array map the inputs
+	// are read from and the results index into.
+	registerPressureValueSize = 128
+
+	// Register that holds the pointer to the map value, the chain works on
+	// all the registers below it.
+	registerPressureValueReg = R9
+
+	// Stack slots the chain spills to and fills from, below the map key.
+	registerPressureKeyOffset = -8
+	registerPressureSlots     = 8
+
+	// Bounds of the number of instructions of the dependency chain and
+	// maximum number of instructions a jump of the chain skips.
+	registerPressureMinChain = 16
+	registerPressureMaxChain = 96
+	registerPressureMaxSkip  = 4
+
+	// Maximum number of registers whose final value is used to access the
+	// map value.
+	registerPressureMaxAccesses = 3
+)
+
+func NewRegisterPressureStrategy() *RegisterPressure {
+	return &RegisterPressure{isFinished: false, mapFd: -1}
+}
+
+// RegisterPressure is a strategy that keeps R0-R8 live at the same time
+// with values read from a map, which the verifier knows nothing about, and
+// makes them depend on each other through a long chain of ALU
+// instructions, spills, fills and conditional jumps. At the end some of the
+// registers are masked and added to the map value pointer, so the verifier
+// has to mark them precise and backtrack through the whole chain, and
+// through every register and stack slot it depends on, see
+// mark_chain_precision.
+type RegisterPressure struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+}
+
+// registerPressureSlot returns the offset of the stack slot `slot`.
+func registerPressureSlot(slot uint64) int16 {
+	return registerPressureKeyOffset - 8 - 8*int16(slot)
+}
+
+// randomPressureReg returns one of the registers the chain works on.
+func randomPressureReg() epb.Reg {
+	return epb.Reg(rand.SharedRNG.RandRange(uint64(R0), uint64(registerPressureValueReg-1)))
+}
+
+// randomPressureStep returns an instruction of the dependency chain, which
+// has `remaining` instructions after it. Most instructions combine two
+// registers, the others spill, fill or skip a few of the next instructions
+// depending on a register.
+func randomPressureStep(remaining int) *epb.Instruction {
+	dst, src := randomPressureReg(), randomPressureReg()
+	switch rand.SharedRNG.RandRange(0, 9) {
+	case 0:
+		return StDW(R10, src, registerPressureSlot(rand.SharedRNG.RandRange(0, registerPressureSlots-1)))
+	case 1:
+		return LdDW(dst, R10, registerPressureSlot(rand.SharedRNG.RandRange(0, registerPressureSlots-1)))
+	case 2:
+		if remaining > 0 {
+			skip := int16(rand.SharedRNG.RandRange(1, uint64(min(remaining, registerPressureMaxSkip))))
+			if rand.SharedRNG.OneOf(2) {
+				return newRandomJump(dst, src, skip)
+			}
+			return newRandomJump(dst, int32(rand.SharedRNG.RandRange(0, 0xff)), skip)
+		}
+	case 3:
+		return Lsh64(dst, int32(rand.SharedRNG.RandRange(1, 7)))
+	case 4:
+		return Rsh64(dst, int32(rand.SharedRNG.RandRange(1, 7)))
+	}
+	switch rand.SharedRNG.RandRange(0, 5) {
+	case 0:
+		return Add64(dst, src)
+	case 1:
+		return Sub64(dst, src)
+	case 2:
+		return Xor64(dst, src)
+	case 3:
+		return Or64(dst, src)
+	case 4:
+		return And64(dst, src)
+	default:
+		return Add(dst, src)
+	}
+}
+
+// newRandomJump returns a random conditional jump of `skip` instructions
+// that compares `dst` with `src`.
+func newRandomJump[T Src](dst epb.Reg, src T, skip int16) *epb.Instruction {
+	jumps := []func(epb.Reg, T, int16) *epb.Instruction{
+		JmpEQ[T], JmpNE[T], JmpGT[T], JmpGE[T], JmpLT[T], JmpLE[T],
+		JmpSGT[T], JmpSGE[T], JmpSLT[T], JmpSLE[T], JmpSET[T],
+		JmpGT32[T], JmpSLT32[T],
+	}
+	return jumps[rand.SharedRNG.RandRange(0, uint64(len(jumps)-1))](dst, src, skip)
+}
+
+// registerPressureChain returns the body of a program: R0-R8 are read from
+// the map value registerPressureValueReg points to, combined by a chain of
+// `length` instructions and some of them are used to access the value.
+func registerPressureChain(length int) []*epb.Instruction {
+	var insn []*epb.Instruction
+	for reg := R0; reg < registerPressureValueReg; reg++ {
+		offset := int16(8 * rand.SharedRNG.RandRange(0, registerPressureValueSize/8-1))
+		insn = append(insn, LdDW(reg, registerPressureValueReg, offset))
+	}
+	// Fills never read uninitialized slots, even if the spill was skipped.
+	for slot := uint64(0); slot < registerPressureSlots; slot++ {
+		insn = append(insn, StDW(R10, randomPressureReg(), registerPressureSlot(slot)))
+	}
+	for i := length - 1; i >= 0; i-- {
+		insn = append(insn, randomPressureStep(i))
+	}
+	for i := rand.SharedRNG.RandRange(1, registerPressureMaxAccesses); i > 0; i-- {
+		reg := randomPressureReg()
+		insn = append(insn,
+			And64(reg, int32(registerPressureValueSize-1)),
+			Add64(reg, registerPressureValueReg),
+			LdB(reg, reg, 0),
+		)
+	}
+	return insn
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (rp *RegisterPressure) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	rp.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", rp.programCount, rp.validProgramCount)
+
+	// The map is shared by all the programs so they can be batched.
+	if rp.mapFd < 0 {
+		rp.mapFd = ffi.CreateMap(units.MapTypeArray, 4, registerPressureValueSize, 1, 0)
+		if rp.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	// The chain is the body, the lookup that gives it the value pointer
+	// is set up by the prologue.
+	prologue := []*epb.Instruction{
+		StW(R10, 0, registerPressureKeyOffset),
+		LdMapByFd(R1, rp.mapFd),
+		Mov64(R2, R10),
+		Add64(R2, registerPressureKeyOffset),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+		Mov64(registerPressureValueReg, R0),
+	}
+	length := int(rand.SharedRNG.RandRange(registerPressureMinChain, registerPressureMaxChain))
+	return &epb.Program{
+		Prologue:     prologue,
+		Instructions: registerPressureChain(length),
+		Epilogue:     []*epb.Instruction{Mov64(R0, 0), Exit()},
+	}, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (rp *RegisterPressure) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	rp.validProgramCount += 1
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+//
+// The chain has no expected result of its own, bugs show up as kernel
+// splats or through the oracles of the control unit.
+func (rp *RegisterPressure) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+// Batchable returns true, the programs only depend on the shared map and
+// the hooks don't read it.
+func (rp *RegisterPressure) Batchable() bool {
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (rp *RegisterPressure) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (rp *RegisterPressure) IsFuzzingDone() bool {
+	return rp.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (rp *RegisterPressure) Name() string {
+	return "register_pressure"
+}
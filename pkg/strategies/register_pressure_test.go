@@ -0,0 +1,61 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestRegisterPressureChain(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		insn := registerPressureChain(registerPressureMaxChain)
+		if _, err := EncodeInstructions(&epb.Program{Instructions: insn}); err != nil {
+			t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+		}
+		for index, in := range insn {
+			if IsRelativeJump(in) && (in.Offset < 0 || index+1+int(in.Offset) > len(insn)) {
+				t.Fatalf("instruction %d jumps outside of the chain: %v", index, in)
+			}
+			if in.GetAluOpcode() != nil && in.DstReg == registerPressureValueReg {
+				t.Fatalf("instruction %d overwrites the value pointer: %v", index, in)
+			}
+			if in.GetMemOpcode().GetInstructionClass() == epb.InsClass_InsClassStx && in.DstReg == R10 && in.Offset >= registerPressureKeyOffset {
+				t.Fatalf("instruction %d spills over the map key: %v", index, in)
+			}
+		}
+	}
+}
+
+func TestRegisterPressureProgramFrame(t *testing.T) {
+	rp := NewRegisterPressureStrategy()
+	prog, err := rp.GenerateProgram(&units.FFI{Maps: units.NewFakeMaps()})
+	if err != nil {
+		t.Fatalf("GenerateProgram() = %v, want nil error", err)
+	}
+	if len(prog.Prologue) == 0 || len(prog.Epilogue) == 0 {
+		t.Fatalf("GenerateProgram() = %v, want the lookup in the prologue and the exit in the epilogue", prog)
+	}
+	assembled, err := AssembleProgram(prog)
+	if err != nil {
+		t.Fatalf("AssembleProgram() = %v, want nil error", err)
+	}
+	if want := len(prog.Prologue) + len(prog.Instructions) + len(prog.Epilogue); len(assembled.Instructions) != want {
+		t.Errorf("assembled program has %d instructions, want %d", len(assembled.Instructions), want)
+	}
+}
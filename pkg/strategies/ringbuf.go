@@ -0,0 +1,423 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"fmt"
+)
+
+const (
+	// Size of the data area of the ring buffer, a power of 2 multiple of
+	// the page size.
+	ringbufDataSize = 4096
+
+	// Size of the header the kernel puts in front of every record,
+	// BPF_RINGBUF_HDR_SZ.
+	ringbufHeaderSize = 8
+
+	// Records are held in R6 to R9 until they are released.
+	ringbufMaxRecords = 4
+
+	// Size of the small records most programs reserve.
+	ringbufSmallRecord = 64
+)
+
+// ringbufKind is how the generated program handles its records.
+type ringbufKind int
+
+const (
+	// Every record is null checked, written to within its bounds and then
+	// submitted or discarded exactly once. The verifier must accept these
+	// programs and the consumer must find the records the program
+	// committed.
+	releasedRecords ringbufKind = iota
+
+	// The other kinds mishandle one of the records, the verifier must
+	// reject them.
+
+	// The record is never submitted nor discarded.
+	missingRelease
+	// The record is released twice.
+	doubleRelease
+	// The record is used without checking the reservation succeeded.
+	missingNullCheck
+	// The record is written to after being released.
+	useAfterRelease
+	// The record is written to past its end.
+	outOfBoundsWrite
+	// The size of the reservation is not a constant.
+	variableSize
+
+	numRingbufKinds
+)
+
+var ringbufKindNames = []string{
+	"released records",
+	"missing release",
+	"double release",
+	"missing null check",
+	"use after release",
+	"out of bounds write",
+	"variable size",
+}
+
+// ringbufRecord is a reservation of the generated program.
+type ringbufRecord struct {
+	size int32
+
+	// Flags of bpf_ringbuf_reserve, the reservation fails if they are not
+	// 0.
+	flags int32
+
+	// Whether the record is discarded instead of submitted and the flags of
+	// the release.
+	discard      bool
+	releaseFlags int32
+
+	// First byte of the record, if it has one.
+	marker byte
+}
+
+// ringbufRegister returns the register that holds record `i`.
+func ringbufRegister(i int) epb.Reg {
+	return epb.Reg(int(R6) + i)
+}
+
+// ringbufOrder returns the indexes up to `n` in a random order.
+func ringbufOrder(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		j := int(rand.SharedRNG.RandRange(0, uint64(i)))
+		order[i] = order[j]
+		order[j] = i
+	}
+	return order
+}
+
+// ringbufSlots returns how many slots `insns` take once encoded, nil being
+// the placeholder of a jump.
+func ringbufSlots(insns []*epb.Instruction) int16 {
+	slots := 0
+	for _, insn := range insns {
+		if insn == nil {
+			slots++
+			continue
+		}
+		slots += InstructionWidth(insn)
+	}
+	return int16(slots)
+}
+
+func NewRingbufStrategy() *Ringbuf {
+	return &Ringbuf{isFinished: false, ringbufFd: -1}
+}
+
+// Ringbuf is a strategy that targets the reference tracking of the verifier
+// and the ring buffer implementation. Programs reserve up to
+// ringbufMaxRecords records of varying sizes in a BPF_MAP_TYPE_RINGBUF,
+// holding all of them at once, and then submit or discard them in any order.
+// Most programs handle the records correctly, the others leak a record,
+// release it twice, use it unchecked or after its release, and must be
+// rejected.
+//
+// After every execution the ring buffer is consumed from user space, the
+// records must be the ones the program committed, in the order they were
+// reserved, with their size, content and discard flag. The reservations that
+// can't fit in the ring buffer must fail.
+type Ringbuf struct {
+	isFinished        bool
+	ringbufFd         int
+	programCount      int
+	validProgramCount int
+
+	// Kind and records of the last generated program and the record the
+	// kind applies to.
+	kind    ringbufKind
+	records []ringbufRecord
+	target  int
+}
+
+// randomRecord returns a reservation, mostly small ones but also some close
+// to or beyond the size of the ring buffer.
+func randomRecord() ringbufRecord {
+	record := ringbufRecord{
+		size:         int32(rand.SharedRNG.RandRange(0, ringbufSmallRecord)),
+		discard:      rand.SharedRNG.OneOf(3),
+		releaseFlags: []int32{0, RingbufNoWakeup, RingbufForceWakeup, RingbufNoWakeup | RingbufForceWakeup}[rand.SharedRNG.RandRange(0, 3)],
+		marker:       byte(rand.SharedRNG.RandInt()),
+	}
+	switch {
+	case rand.SharedRNG.OneOf(8):
+		record.size = int32(ringbufDataSize - ringbufHeaderSize*rand.SharedRNG.RandRange(0, 3))
+	case rand.SharedRNG.OneOf(8):
+		record.size = int32(rand.SharedRNG.RandRange(1, 2*ringbufDataSize))
+	}
+	if rand.SharedRNG.OneOf(10) {
+		record.flags = RingbufNoWakeup
+	}
+	return record
+}
+
+// committed returns how many of the records of the last program were
+// reserved when it ran: the reservations succeed until one does not fit in
+// the empty ring buffer or has flags, the program then releases the ones it
+// holds and stops.
+func (rb *Ringbuf) committed() int {
+	pos := int32(0)
+	for i, record := range rb.records {
+		length := (record.size + ringbufHeaderSize + 7) &^ 7
+		// The kernel refuses records that would fill the whole ring
+		// buffer, producer and consumer positions would look the same.
+		if record.flags != 0 || pos+length > ringbufDataSize-1 {
+			return i
+		}
+		pos += length
+	}
+	return len(rb.records)
+}
+
+// reserve returns the reservation of record `i`, whose pointer ends up in
+// its register.
+func (rb *Ringbuf) reserve(i int) []*epb.Instruction {
+	record := rb.records[i]
+	var insn []*epb.Instruction
+	if rb.kind == variableSize && i == rb.target {
+		insn = append(insn,
+			Call(GetPrandomU32),
+			Mov64(R2, R0),
+			And64(R2, int32(ringbufSmallRecord-1)))
+	} else {
+		insn = append(insn, Mov64(R2, record.size))
+	}
+	return append(insn,
+		LdMapByFd(R1, rb.ringbufFd),
+		Mov64(R3, record.flags),
+		Call(RingbufReserve),
+		Mov64(ringbufRegister(i), R0))
+}
+
+// release returns the submission or the discard of record `i`.
+func (rb *Ringbuf) release(i int) []*epb.Instruction {
+	record := rb.records[i]
+	helper := int32(RingbufSubmit)
+	if record.discard {
+		helper = RingbufDiscard
+	}
+	return []*epb.Instruction{
+		Mov64(R1, ringbufRegister(i)),
+		Mov64(R2, record.releaseFlags),
+		Call(helper),
+	}
+}
+
+// releaseAll returns the releases of the first `n` records, in a random
+// order.
+func (rb *Ringbuf) releaseAll(n int) []*epb.Instruction {
+	var insn []*epb.Instruction
+	for _, i := range ringbufOrder(n) {
+		insn = append(insn, rb.release(i)...)
+	}
+	return insn
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (rb *Ringbuf) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	rb.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", rb.programCount, rb.validProgramCount)
+
+	if rb.ringbufFd < 0 {
+		rb.ringbufFd = ffi.CreateMap(units.MapTypeRingbuf, 0, 0, ringbufDataSize, 0)
+		if rb.ringbufFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	// Half of the programs handle their records correctly so the verifier
+	// also goes past the reference checks.
+	rb.kind = releasedRecords
+	if rand.SharedRNG.OneOf(2) {
+		rb.kind = ringbufKind(rand.SharedRNG.RandRange(1, uint64(numRingbufKinds-1)))
+	}
+	rb.records = nil
+	for i := rand.SharedRNG.RandRange(1, ringbufMaxRecords); i > 0; i-- {
+		rb.records = append(rb.records, randomRecord())
+	}
+	rb.target = int(rand.SharedRNG.RandRange(0, uint64(len(rb.records)-1)))
+	if rb.kind == useAfterRelease || rb.kind == outOfBoundsWrite {
+		// The target record needs to be small, but not empty, so the
+		// program can write to it.
+		rb.records[rb.target].size = int32(rand.SharedRNG.RandRange(1, ringbufSmallRecord))
+		rb.records[rb.target].flags = 0
+	}
+
+	// Every record is reserved, checked and written to in turn, each
+	// failed reservation jumps to the releases of the records reserved
+	// before it:
+	//
+	//	reserve 0, if failed goto fail0
+	//	reserve 1, if failed goto fail1
+	//	release 0 and 1, goto end
+	//	fail1: release 0, goto end
+	//	fail0:
+	//	end: exit
+	var insn []*epb.Instruction
+	checks := make(map[int]int)
+	for i, record := range rb.records {
+		insn = append(insn, rb.reserve(i)...)
+		if rb.kind != missingNullCheck || i != rb.target {
+			checks[i] = len(insn)
+			insn = append(insn, nil)
+		}
+		switch {
+		case rb.kind == outOfBoundsWrite && i == rb.target:
+			insn = append(insn, StB(ringbufRegister(i), int32(record.marker), int16(record.size)))
+		case record.size > 0:
+			insn = append(insn, StB(ringbufRegister(i), int32(record.marker), 0))
+		}
+	}
+
+	success := rb.releaseAll(len(rb.records))
+	switch rb.kind {
+	case missingRelease:
+		// Without the submission or discard of the target.
+		success = nil
+		for _, i := range ringbufOrder(len(rb.records)) {
+			if i != rb.target {
+				success = append(success, rb.release(i)...)
+			}
+		}
+	case doubleRelease:
+		success = append(success, rb.release(rb.target)...)
+	case useAfterRelease:
+		success = append(success, StB(ringbufRegister(rb.target), 0, 0))
+	}
+	insn = append(insn, success...)
+
+	// The failure paths, from the last record to the first one.
+	ends := []int{}
+	failures := make(map[int]int)
+	for i := len(rb.records) - 1; i >= 0; i-- {
+		if _, ok := checks[i]; !ok {
+			continue
+		}
+		ends = append(ends, len(insn))
+		insn = append(insn, nil)
+		failures[i] = len(insn)
+		insn = append(insn, rb.releaseAll(i)...)
+	}
+	insn = append(insn, Mov64(R0, 0), Exit())
+	end := len(insn) - 2
+
+	for i, check := range checks {
+		insn[check] = JmpEQ(ringbufRegister(i), 0, ringbufSlots(insn[check+1:failures[i]]))
+	}
+	for _, jump := range ends {
+		insn[jump] = Jmp(ringbufSlots(insn[jump+1 : end]))
+	}
+	return &epb.Program{Instructions: insn}, nil
+}
+
+// Decisions returns the kind and the number of records of the last program
+// for the decision log.
+func (rb *Ringbuf) Decisions() []string {
+	return []string{ringbufKindNames[rb.kind], fmt.Sprintf("%d records", len(rb.records))}
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (rb *Ringbuf) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	rb.validProgramCount += 1
+	if rb.kind != releasedRecords {
+		logging.Infof("verifier accepted a program with a %s\n", ringbufKindNames[rb.kind])
+	}
+
+	// The program must start with an empty ring buffer.
+	if _, err := ffi.ConsumeRingbuf(rb.ringbufFd, ringbufDataSize); err != nil {
+		logging.Warningf("%v\n", err)
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (rb *Ringbuf) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if rb.kind != releasedRecords {
+		// Reaching this point is already a bug.
+		return false
+	}
+	consumed, err := ffi.ConsumeRingbuf(rb.ringbufFd, ringbufDataSize)
+	if err == nil && consumed.GetErrorMessage() != "" {
+		err = fmt.Errorf("%s", consumed.GetErrorMessage())
+	}
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	if ffi.Maps != nil {
+		// Fake ring buffers never have records.
+		return true
+	}
+
+	records := consumed.GetRecords()
+	if want := rb.committed(); len(records) != want {
+		logging.Infof("ring buffer has %d records, want %d\n", len(records), want)
+		return false
+	}
+	for i, got := range records {
+		want := rb.records[i]
+		if len(got.GetData()) != int(want.size) {
+			logging.Infof("record %d has %d bytes, want %d\n", i, len(got.GetData()), want.size)
+			return false
+		}
+		if got.GetDiscarded() != want.discard {
+			logging.Infof("record %d discarded = %v, want %v\n", i, got.GetDiscarded(), want.discard)
+			return false
+		}
+		if want.size > 0 && got.GetData()[0] != want.marker {
+			logging.Infof("record %d starts with %#x, want %#x\n", i, got.GetData()[0], want.marker)
+			return false
+		}
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (rb *Ringbuf) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (rb *Ringbuf) IsFuzzingDone() bool {
+	return rb.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (rb *Ringbuf) Name() string {
+	return "ringbuf"
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+)
+
+func TestCommittedRecords(t *testing.T) {
+	tests := []struct {
+		testName string
+		records  []ringbufRecord
+		want     int
+	}{
+		{
+			testName: "Small records",
+			records:  []ringbufRecord{{size: 1}, {size: 0}, {size: 64}},
+			want:     3,
+		},
+		{
+			testName: "Reservation with flags",
+			records:  []ringbufRecord{{size: 8}, {size: 8, flags: 1}, {size: 8}},
+			want:     1,
+		},
+		{
+			testName: "Record as big as the ring buffer",
+			records:  []ringbufRecord{{size: ringbufDataSize - ringbufHeaderSize}},
+			want:     0,
+		},
+		{
+			testName: "Largest record",
+			records:  []ringbufRecord{{size: ringbufDataSize - 2*ringbufHeaderSize}},
+			want:     1,
+		},
+		{
+			testName: "Ring buffer full",
+			records:  []ringbufRecord{{size: ringbufDataSize - 4*ringbufHeaderSize}, {size: 1}, {size: 1}},
+			want:     2,
+		},
+		{
+			testName: "Larger than the ring buffer",
+			records:  []ringbufRecord{{size: 2 * ringbufDataSize}},
+			want:     0,
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.testName, func(t *testing.T) {
+			rb := &Ringbuf{records: c.records}
+			if got := rb.committed(); got != c.want {
+				t.Errorf("committed() = %d, want %d", got, c.want)
+			}
+		})
+	}
+}
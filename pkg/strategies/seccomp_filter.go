@@ -0,0 +1,294 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"runtime"
+	"slices"
+	"syscall"
+
+	"buzzer/pkg/cbpf/cbpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+var (
+	seccompOnly = errors.New("seccomp_filter only generates cBPF filters")
+)
+
+const (
+	// Maximum number of instructions before the return of the generated
+	// filters.
+	seccompFilterMaxInstructions = 32
+
+	// Largest errno SeccompRetErrno can make a system call fail with,
+	// bigger values are capped to it.
+	seccompMaxErrno = 4095
+)
+
+// seccompAuditArch is the value of seccomp_data.arch for the architectures
+// buzzer knows about. On other architectures the filters never read it.
+var seccompAuditArch = map[string]uint32{
+	"amd64": 0xc000003e,
+	"arm64": 0xc00000b7,
+}
+
+// seccompOutcome is what the sandboxed process should observe.
+type seccompOutcome struct {
+	didSyscall    bool
+	syscallReturn int64
+}
+
+// expectedSeccompOutcome returns what a process whose filter returned `ret`
+// observes when issuing getppid, which should return `ppid`.
+func expectedSeccompOutcome(ret uint32, ppid int64) seccompOutcome {
+	data := int64(ret & cbpf.SeccompRetData)
+	switch cbpf.SeccompAction(ret) {
+	case cbpf.SeccompRetAllow, cbpf.SeccompRetLog:
+		return seccompOutcome{didSyscall: true, syscallReturn: ppid}
+	case cbpf.SeccompRetErrno:
+		return seccompOutcome{didSyscall: true, syscallReturn: -min(data, seccompMaxErrno)}
+	case cbpf.SeccompRetTrace, cbpf.SeccompRetUserNotif:
+		// There is no tracer nor listener, the kernel fails the
+		// system call instead.
+		return seccompOutcome{didSyscall: true, syscallReturn: -int64(syscall.ENOSYS)}
+	}
+	// Everything else kills the process with SIGSYS.
+	return seccompOutcome{didSyscall: false}
+}
+
+func NewSeccompFilterStrategy() *SeccompFilter {
+	return &SeccompFilter{isFinished: false}
+}
+
+// SeccompFilter is a strategy that installs random cBPF programs as the
+// seccomp filter of a sandboxed process, exercising the classic BPF checker
+// and the translation of seccomp filters to eBPF. The action the process
+// observes is compared with the one computed by interpreting the filter in
+// user space.
+//
+// Some filters contain an instruction seccomp does not allow or a jump out of
+// bounds, the kernel must refuse to install those.
+type SeccompFilter struct {
+	isFinished        bool
+	programCount      int
+	validProgramCount int
+
+	// State of the last generated filter.
+	filter        []cbpf.Instruction
+	data          cbpf.SeccompData
+	invalidReason string
+}
+
+// loadOffsets returns the offsets of seccomp_data the filters read. The
+// instruction pointer is left out as it cannot be predicted.
+func loadOffsets() []uint32 {
+	offsets := []uint32{cbpf.SeccompDataNr}
+	if _, ok := seccompAuditArch[runtime.GOARCH]; ok {
+		offsets = append(offsets, cbpf.SeccompDataArch)
+	}
+	for offset := uint32(cbpf.SeccompDataArgs); offset < cbpf.SeccompDataSize; offset += 4 {
+		offsets = append(offsets, offset)
+	}
+	return offsets
+}
+
+// randomSeccompInstruction returns a random instruction seccomp accepts,
+// followed by `remaining` instructions.
+func randomSeccompInstruction(state *classicFilterState, remaining int) cbpf.Instruction {
+	switch rand.SharedRNG.RandRange(0, 9) {
+	case 0:
+		offsets := loadOffsets()
+		return cbpf.LdAbs(cbpf.SizeW, offsets[rand.SharedRNG.RandRange(0, uint64(len(offsets)-1))])
+	case 1:
+		return cbpf.LdImm(uint32(rand.SharedRNG.RandInt()))
+	case 2:
+		return cbpf.LdxImm(uint32(rand.SharedRNG.RandInt()))
+	case 3:
+		return cbpf.Tax()
+	case 4:
+		return cbpf.Txa()
+	case 5:
+		return state.randomStore()
+	case 6:
+		if insn, ok := state.randomLoad(); ok {
+			return insn
+		}
+	case 7:
+		state.branched = true
+		return randomJump(remaining)
+	}
+	return randomAluInstruction(false)
+}
+
+// invalidSeccompInstruction returns an instruction seccomp must refuse along
+// with the reason why.
+func invalidSeccompInstruction() (cbpf.Instruction, string) {
+	switch rand.SharedRNG.RandRange(0, 6) {
+	case 0:
+		return cbpf.Mod(uint32(rand.SharedRNG.RandRange(1, 0xffffffff))), "modulo is not allowed"
+	case 1:
+		return cbpf.LdAbs(cbpf.SizeH, cbpf.SeccompDataNr), "half word load"
+	case 2:
+		return cbpf.LdAbs(cbpf.SizeB, cbpf.SeccompDataArch), "byte load"
+	case 3:
+		return cbpf.LdAbs(cbpf.SizeW, uint32(rand.SharedRNG.RandRange(0, cbpf.SeccompDataSize/4-1))*4+2), "unaligned load"
+	case 4:
+		return cbpf.LdAbs(cbpf.SizeW, uint32(rand.SharedRNG.RandRange(cbpf.SeccompDataSize, 0xffffffff))), "load past seccomp_data"
+	case 5:
+		return cbpf.Lsh(uint32(rand.SharedRNG.RandRange(32, 0xffffffff))), "shift by 32 or more"
+	}
+	return cbpf.Div(0), "division by zero constant"
+}
+
+// GenerateSeccompFilter should return the filter to install and the system
+// call the sandboxed process issues afterwards.
+func (sf *SeccompFilter) GenerateSeccompFilter(ffi *units.FFI) (*fpb.SeccompRequest, error) {
+	sf.programCount += 1
+	logging.Debugf("Generated %d filters, %d were installed               \r", sf.programCount, sf.validProgramCount)
+
+	sf.data = cbpf.SeccompData{
+		Nr:   syscall.SYS_GETPPID,
+		Arch: seccompAuditArch[runtime.GOARCH],
+	}
+	for i := range sf.data.Args {
+		sf.data.Args[i] = rand.SharedRNG.RandInt()
+	}
+
+	// Most random values are unknown actions that kill the process, turn
+	// half of them into a known one.
+	tail := []cbpf.Instruction{}
+	if rand.SharedRNG.OneOf(2) {
+		action := cbpf.SeccompActions[rand.SharedRNG.RandRange(0, uint64(len(cbpf.SeccompActions)-1))]
+		tail = append(tail, cbpf.And(uint32(cbpf.SeccompRetData)), cbpf.Or(action))
+	}
+	tail = append(tail, cbpf.Ret(cbpf.A))
+
+	state := newClassicFilterState()
+	sf.filter = nil
+	count := int(rand.SharedRNG.RandRange(0, seccompFilterMaxInstructions))
+	for i := 0; i < count; i++ {
+		sf.filter = append(sf.filter, randomSeccompInstruction(state, count-i-1+len(tail)))
+	}
+	sf.filter = append(sf.filter, tail...)
+
+	sf.invalidReason = ""
+	if rand.SharedRNG.OneOf(8) {
+		index := int(rand.SharedRNG.RandRange(0, uint64(len(sf.filter)-1)))
+		if rand.SharedRNG.OneOf(4) {
+			sf.filter = slices.Insert(sf.filter, index, cbpf.Ja(0))
+			violation := cbpf.PastEnd
+			sf.invalidReason = "jump past the end"
+			if rand.SharedRNG.OneOf(2) {
+				violation = cbpf.Backward
+				sf.invalidReason = "backward jump"
+			}
+			filter, err := cbpf.ViolateJump(sf.filter, index, violation)
+			if err != nil {
+				return nil, err
+			}
+			sf.filter = filter
+		} else {
+			var insn cbpf.Instruction
+			insn, sf.invalidReason = invalidSeccompInstruction()
+			sf.filter = slices.Insert(sf.filter, index, insn)
+		}
+	}
+
+	return &fpb.SeccompRequest{
+		Filter:      cbpf.EncodeInstructions(sf.filter),
+		SyscallNr:   int64(sf.data.Nr),
+		SyscallArgs: sf.data.Args[:],
+	}, nil
+}
+
+// OnSeccompDone should validate if the sandboxed process behaved like the
+// filter dictates, if that was not the case it should return false.
+func (sf *SeccompFilter) OnSeccompDone(ffi *units.FFI, result *fpb.SeccompResult) bool {
+	if sf.invalidReason != "" {
+		if result.FilterInstalled {
+			logging.Infof("seccomp installed a filter with an invalid instruction: %s\n", sf.invalidReason)
+			return false
+		}
+		return true
+	}
+	if !result.FilterInstalled {
+		logging.Infof("seccomp refused a valid filter: %s\n", result.ErrorMessage)
+		return false
+	}
+	sf.validProgramCount += 1
+
+	ret, err := cbpf.Run(sf.filter, sf.data.Bytes(), binary.NativeEndian)
+	if err != nil {
+		logging.Warningf("could not interpret the filter: %v\n", err)
+		return true
+	}
+	want := expectedSeccompOutcome(ret, int64(os.Getpid()))
+	got := seccompOutcome{didSyscall: result.DidSyscall, syscallReturn: result.SyscallReturn}
+	if !want.didSyscall {
+		// The return value of a system call that did not happen is
+		// meaningless.
+		got.syscallReturn = 0
+		if result.TermSignal != int32(syscall.SIGSYS) {
+			logging.Infof("filter returned %#x, process was killed by signal %d instead of SIGSYS\n", ret, result.TermSignal)
+			return false
+		}
+	}
+	if got != want {
+		logging.Infof("filter returned %#x, want %+v, got %+v\n", ret, want, got)
+		return false
+	}
+	return true
+}
+
+// GenerateProgram is not used, seccomp strategies generate cBPF filters
+// with GenerateSeccompFilter instead.
+func (sf *SeccompFilter) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	return nil, seccompOnly
+}
+
+// OnVerifyDone is not used, there is no eBPF program to verify.
+func (sf *SeccompFilter) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	return false
+}
+
+// OnExecuteDone is not used, there is no eBPF program to execute.
+func (sf *SeccompFilter) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (sf *SeccompFilter) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (sf *SeccompFilter) IsFuzzingDone() bool {
+	return sf.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (sf *SeccompFilter) Name() string {
+	return "seccomp_filter"
+}
@@ -0,0 +1,82 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"encoding/binary"
+	"syscall"
+	"testing"
+
+	"buzzer/pkg/cbpf/cbpf"
+	"buzzer/pkg/rand"
+)
+
+func TestExpectedSeccompOutcome(t *testing.T) {
+	const ppid = 1234
+	tests := []struct {
+		testName string
+		ret      uint32
+		want     seccompOutcome
+	}{
+		{testName: "Allow", ret: cbpf.SeccompRetAllow | 7, want: seccompOutcome{didSyscall: true, syscallReturn: ppid}},
+		{testName: "Log", ret: cbpf.SeccompRetLog, want: seccompOutcome{didSyscall: true, syscallReturn: ppid}},
+		{testName: "Errno", ret: cbpf.SeccompRetErrno | 13, want: seccompOutcome{didSyscall: true, syscallReturn: -13}},
+		{testName: "Errno zero", ret: cbpf.SeccompRetErrno, want: seccompOutcome{didSyscall: true, syscallReturn: 0}},
+		{testName: "Errno is capped", ret: cbpf.SeccompRetErrno | 0xffff, want: seccompOutcome{didSyscall: true, syscallReturn: -4095}},
+		{testName: "Trace without tracer", ret: cbpf.SeccompRetTrace, want: seccompOutcome{didSyscall: true, syscallReturn: -int64(syscall.ENOSYS)}},
+		{testName: "Trap", ret: cbpf.SeccompRetTrap, want: seccompOutcome{didSyscall: false}},
+		{testName: "Unknown action", ret: 0x00420000, want: seccompOutcome{didSyscall: false}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := expectedSeccompOutcome(tc.ret, ppid); got != tc.want {
+				t.Errorf("expectedSeccompOutcome(%#x) = %+v, want %+v", tc.ret, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSeccompFilter(t *testing.T) {
+	rand.SetSharedSeed(1)
+	sf := NewSeccompFilterStrategy()
+	for i := 0; i < 500; i++ {
+		req, err := sf.GenerateSeccompFilter(nil)
+		if err != nil {
+			t.Fatalf("GenerateSeccompFilter() = %v, want nil error", err)
+		}
+		if len(req.SyscallArgs) != 6 {
+			t.Fatalf("GenerateSeccompFilter() has %d arguments, want 6", len(req.SyscallArgs))
+		}
+		filter := cbpf.DecodeInstructions(req.Filter)
+		if last := filter[len(filter)-1]; last.Class() != cbpf.ClassRet {
+			t.Fatalf("last instruction is %#02x, want a return", last.Code)
+		}
+		if sf.invalidReason != "" {
+			continue
+		}
+		for _, insn := range filter {
+			if insn.Class() == cbpf.ClassAlu && insn.Operation() == cbpf.AluMod {
+				t.Fatalf("valid filter %v uses modulo", filter)
+			}
+		}
+		if err := cbpf.CheckJumps(filter); err != nil {
+			t.Fatalf("CheckJumps(%v) = %v, want nil error", filter, err)
+		}
+		if _, err := cbpf.Run(filter, sf.data.Bytes(), binary.NativeEndian); err != nil {
+			t.Fatalf("Run(%v) = %v, want nil error", filter, err)
+		}
+	}
+}
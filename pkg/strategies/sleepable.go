@@ -0,0 +1,353 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+
+	"buzzer/pkg/btf/btf"
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Map layout: element 0 receives the value copy_from_user returned,
+	// element 1 the marker written once the program ran and element 2 the
+	// first byte copied.
+	sleepableMapSize     = 3
+	sleepableRetvalKey   = 0
+	sleepableMarkerKey   = 1
+	sleepableDataKey     = 2
+	sleepableMarker      = 0x534c454550
+	sleepableKeyOffset   = -72
+	sleepableMaxStackDst = 64
+
+	// The context holds the address of a user buffer of this size filled
+	// with sleepableBufferByte.
+	sleepableBufferSize = 4096
+	sleepableBufferByte = 0x5a
+
+	// efault is -EFAULT, what copy_from_user returns when it can't copy,
+	// after zeroing the destination.
+	efault = -14
+)
+
+// sleepableKind is how the program calls copy_from_user.
+type sleepableKind int
+
+const (
+	// The program is sleepable, the verifier must accept the call if the
+	// size fits the destination.
+	sleepableCall sleepableKind = iota
+	// The program is not sleepable, the verifier must reject the call.
+	nonSleepableCall
+	// The program is sleepable but holds a spin lock around the call, the
+	// verifier must reject it.
+	lockedCall
+
+	numSleepableKinds
+)
+
+var sleepableKindNames = []string{"sleepable call", "non sleepable call", "call with a lock held"}
+
+// sleepableSource is where the user pointer copy_from_user reads comes from.
+type sleepableSource int
+
+const (
+	// The user buffer whose address is in the context, at an offset.
+	userBufferSource sleepableSource = iota
+	// NULL.
+	nullSource
+	// The stack of the program, a kernel address copy_from_user must
+	// refuse.
+	kernelSource
+	// An arbitrary address, e.g. an unmapped or non canonical one.
+	addressSource
+
+	numSleepableSources
+)
+
+var sleepableSourceNames = []string{"user buffer", "null", "kernel stack", "address"}
+
+func NewSleepableStrategy() *Sleepable {
+	buffer := make([]byte, sleepableBufferSize)
+	for i := range buffer {
+		buffer[i] = sleepableBufferByte
+	}
+	ctx := make([]byte, 8)
+	// The Go heap does not move, the address stays valid as long as the
+	// strategy holds the buffer.
+	binary.LittleEndian.PutUint64(ctx, uint64(uintptr(unsafe.Pointer(&buffer[0]))))
+	return &Sleepable{mapFd: -1, lockMapFd: -1, buffer: buffer, ctx: ctx}
+}
+
+// Sleepable is a strategy for sleepable programs, loaded with
+// BPF_F_SLEEPABLE, for which the verifier enforces distinct rules. The
+// programs are BPF_PROG_TYPE_SYSCALL, the type that can be sleepable without
+// being attached anywhere, and are test run on a context holding the address
+// of a user buffer. They call copy_from_user, which only sleepable programs
+// can call, on the buffer or on addresses it must refuse, with fuzzed sizes.
+//
+// Some programs are loaded without BPF_F_SLEEPABLE, or hold a spin lock
+// around the call, and the verifier must reject them. For the others the
+// value returned by the helper and the bytes it copied are checked.
+type Sleepable struct {
+	isFinished        bool
+	mapFd             int
+	lockMapFd         int
+	programCount      int
+	validProgramCount int
+
+	// buffer is the user memory the programs copy from, ctx holds its
+	// address.
+	buffer []byte
+	ctx    []byte
+
+	// State of the last generated program.
+	kind   sleepableKind
+	source sleepableSource
+	// room is how many bytes the destination on the stack can receive,
+	// size how many the program copies.
+	room int32
+	size int32
+}
+
+// lookup returns the lookup of `key` in `mapFd`, the program exits if it
+// fails. The pointer to the value ends up in R0.
+func (sp *Sleepable) lookup(mapFd int, key int32) []*epb.Instruction {
+	return []*epb.Instruction{
+		LdMapByFd(R1, mapFd),
+		StW(R10, key, sleepableKeyOffset),
+		Mov64(R2, R10),
+		Add64(R2, sleepableKeyOffset),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+	}
+}
+
+// sourceInstructions returns the instructions that leave the user pointer in
+// R8. The context is in R6.
+func (sp *Sleepable) sourceInstructions() []*epb.Instruction {
+	switch sp.source {
+	case userBufferSource:
+		offset := int32(rand.SharedRNG.RandRange(0, sleepableBufferSize-sleepableMaxStackDst))
+		return []*epb.Instruction{LdDW(R8, R6, 0), Add64(R8, offset)}
+	case nullSource:
+		return []*epb.Instruction{Mov64(R8, 0)}
+	case kernelSource:
+		return []*epb.Instruction{Mov64(R8, R10), Add64(R8, -sleepableMaxStackDst)}
+	default:
+		addresses := []uint64{1, 0x1000, 0x7fffffffe000, 0x800000000000, 0xffff800000000000, ^uint64(0)}
+		return []*epb.Instruction{LdImm64(R8, addresses[rand.SharedRNG.RandRange(0, uint64(len(addresses)-1))])}
+	}
+}
+
+// violation returns true if the verifier must reject the last program.
+func (sp *Sleepable) violation() bool {
+	return sp.kind != sleepableCall || sp.size > sp.room
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (sp *Sleepable) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	sp.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", sp.programCount, sp.validProgramCount)
+
+	if sp.mapFd < 0 {
+		sp.mapFd = ffi.CreateMapArray(sleepableMapSize)
+		if sp.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+	if sp.lockMapFd < 0 {
+		sp.lockMapFd = ffi.CreateMapWithBTF(units.MapTypeArray, 4, btf.SpinLockValueSize, 1, 0, btf.SpinLockMap())
+		if sp.lockMapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	// Most programs are sleepable and valid so the helper runs.
+	sp.kind = sleepableCall
+	if rand.SharedRNG.OneOf(4) {
+		sp.kind = sleepableKind(rand.SharedRNG.RandRange(1, uint64(numSleepableKinds-1)))
+	}
+	sp.source = sleepableSource(rand.SharedRNG.RandRange(0, uint64(numSleepableSources-1)))
+	sp.room = int32(rand.SharedRNG.RandRange(1, sleepableMaxStackDst))
+	sp.size = int32(rand.SharedRNG.RandRange(0, uint64(sp.room)))
+	if rand.SharedRNG.OneOf(4) {
+		sp.size = sp.room + int32(rand.SharedRNG.RandRange(1, sleepableMaxStackDst))
+	}
+
+	// R6 is the context, R8 the user pointer and R9 the destination on the
+	// stack. R7 points to the value holding the lock, if any.
+	insn := []*epb.Instruction{Mov64(R6, R1), Mov64(R9, R10), Add64(R9, -sp.room)}
+	insn = append(insn, sp.sourceInstructions()...)
+	if sp.kind == lockedCall {
+		insn = append(insn, sp.lookup(sp.lockMapFd, 0)...)
+		insn = append(insn, Mov64(R7, R0), Mov64(R1, R7), Add64(R1, btf.SpinLockOffset), Call(SpinLock))
+	}
+	insn = append(insn,
+		Mov64(R1, R9),
+		Mov64(R2, sp.size),
+		Mov64(R3, R8),
+		Call(CopyFromUser),
+		Mov64(R6, R0),
+	)
+	if sp.kind == lockedCall {
+		insn = append(insn, Mov64(R1, R7), Add64(R1, btf.SpinLockOffset), Call(SpinUnlock))
+	}
+
+	// Record the value returned, the first byte copied and that the
+	// program ran.
+	insn = append(insn, sp.lookup(sp.mapFd, sleepableRetvalKey)...)
+	insn = append(insn, StDW(R0, R6, 0))
+	if sp.size > 0 {
+		insn = append(insn, LdB(R7, R9, 0))
+		insn = append(insn, sp.lookup(sp.mapFd, sleepableDataKey)...)
+		insn = append(insn, StDW(R0, R7, 0))
+	}
+	insn = append(insn, sp.lookup(sp.mapFd, sleepableMarkerKey)...)
+	insn = append(insn, LdImm64(R7, sleepableMarker), StDW(R0, R7, 0), Mov64(R0, 0), Exit())
+	prog, err := InstructionSequence(insn...)
+	if err != nil {
+		return nil, err
+	}
+	return &epb.Program{Instructions: prog}, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (sp *Sleepable) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	sp.validProgramCount += 1
+	if sp.violation() {
+		logging.Infof("verifier accepted a %s of %d bytes into %d bytes\n", sleepableKindNames[sp.kind], sp.size, sp.room)
+	}
+
+	for key := uint32(0); key < sleepableMapSize; key++ {
+		if ffi.SetMapElement(sp.mapFd, key, 0) != 0 {
+			logging.Warningf("could not initialize the map\n")
+			return false
+		}
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (sp *Sleepable) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if sp.violation() {
+		return false
+	}
+	if !executionResult.GetDidSucceed() {
+		logging.Warningf("could not run the sleepable program: %s\n", executionResult.GetErrorMessage())
+		return true
+	}
+	mapElements, err := ffi.GetMapElements(sp.mapFd, sleepableMapSize)
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	return sp.checkCopy(mapElements.Elements)
+}
+
+// checkCopy returns false if the value copy_from_user returned, or the byte
+// it copied, recorded in `elements` is not possible for the source and size
+// of the last program.
+func (sp *Sleepable) checkCopy(elements []uint64) bool {
+	if elements[sleepableMarkerKey] != sleepableMarker {
+		// Nothing ran.
+		return true
+	}
+	ret := int64(elements[sleepableRetvalKey])
+	data := elements[sleepableDataKey]
+	switch {
+	case ret != 0 && ret != efault:
+		logging.Infof("copy_from_user of %d bytes from the %s returned %d\n", sp.size, sleepableSourceNames[sp.source], ret)
+		return false
+	case sp.size == 0:
+		return true
+	case ret == efault && data != 0:
+		logging.Infof("copy_from_user failed but left %#x in the destination\n", data)
+		return false
+	case sp.source == kernelSource && ret == 0:
+		logging.Infof("copy_from_user copied %d bytes from the kernel stack\n", sp.size)
+		return false
+	case sp.source == userBufferSource && (ret != 0 || data != sleepableBufferByte):
+		logging.Infof("copy_from_user of %d bytes from the user buffer returned %d and copied %#x\n", sp.size, ret, data)
+		return false
+	}
+	return true
+}
+
+// Maps returns the map receiving the copy_from_user results.
+func (sp *Sleepable) Maps() map[int]uint64 {
+	return map[int]uint64{sp.mapFd: sleepableMapSize}
+}
+
+// ProgramType returns BPF_PROG_TYPE_SYSCALL.
+func (sp *Sleepable) ProgramType() int {
+	return units.ProgTypeSyscall
+}
+
+// Sleepable returns true unless the last program checks that the verifier
+// refuses copy_from_user in non sleepable programs.
+func (sp *Sleepable) Sleepable() bool {
+	return sp.kind != nonSleepableCall
+}
+
+// TestRunContext returns the context holding the address of the user
+// buffer.
+func (sp *Sleepable) TestRunContext() []byte {
+	return sp.ctx
+}
+
+// Decisions returns how the last program calls copy_from_user for the
+// decision log.
+func (sp *Sleepable) Decisions() []string {
+	return []string{
+		sleepableKindNames[sp.kind],
+		fmt.Sprintf("from the %s", sleepableSourceNames[sp.source]),
+		fmt.Sprintf("%d bytes into %d bytes", sp.size, sp.room),
+	}
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (sp *Sleepable) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (sp *Sleepable) IsFuzzingDone() bool {
+	return sp.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (sp *Sleepable) Name() string {
+	return "sleepable"
+}
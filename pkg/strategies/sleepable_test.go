@@ -0,0 +1,158 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"encoding/binary"
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestSleepableProgramsCallCopyFromUser(t *testing.T) {
+	sp := NewSleepableStrategy()
+	sp.mapFd, sp.lockMapFd = 3, 4
+	seen := make(map[sleepableKind]bool)
+	for i := 0; i < 200; i++ {
+		prog, err := sp.GenerateProgram(nil)
+		if err != nil {
+			t.Fatalf("GenerateProgram() = %v, want nil error", err)
+		}
+		if _, err := EncodeInstructions(prog); err != nil {
+			t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+		}
+		seen[sp.kind] = true
+		calls := make(map[int32]int)
+		for _, in := range prog.Instructions {
+			if in.GetJmpOpcode().GetOperationCode() == epb.JmpOperationCode_JmpCALL {
+				calls[in.Immediate]++
+			}
+		}
+		if calls[CopyFromUser] != 1 {
+			t.Fatalf("program calls copy_from_user %d times, want 1", calls[CopyFromUser])
+		}
+		locked := sp.kind == lockedCall
+		if (calls[SpinLock] == 1) != locked || (calls[SpinUnlock] == 1) != locked {
+			t.Fatalf("%s takes %d locks, want %v", sleepableKindNames[sp.kind], calls[SpinLock], locked)
+		}
+		if sp.ProgramType() != units.ProgTypeSyscall || sp.Sleepable() != (sp.kind != nonSleepableCall) {
+			t.Fatalf("%s is of type %d, sleepable %v", sleepableKindNames[sp.kind], sp.ProgramType(), sp.Sleepable())
+		}
+	}
+	for kind := sleepableKind(0); kind < numSleepableKinds; kind++ {
+		if !seen[kind] {
+			t.Errorf("never generated a %s", sleepableKindNames[kind])
+		}
+	}
+}
+
+func TestSleepableContextPointsToTheBuffer(t *testing.T) {
+	sp := NewSleepableStrategy()
+	address := binary.LittleEndian.Uint64(sp.TestRunContext())
+	if address == 0 {
+		t.Fatalf("context holds NULL, want the address of the user buffer")
+	}
+	for i, b := range sp.buffer {
+		if b != sleepableBufferByte {
+			t.Fatalf("buffer[%d] = %#x, want %#x", i, b, sleepableBufferByte)
+		}
+	}
+}
+
+func TestSleepableCheckCopy(t *testing.T) {
+	failed := uint64(0xfffffffffffffff2)
+	tests := []struct {
+		testName string
+		source   sleepableSource
+		size     int32
+		elements []uint64
+		want     bool
+	}{
+		{
+			testName: "Did not run",
+			source:   userBufferSource,
+			size:     8,
+			elements: []uint64{1, 0, 0},
+			want:     true,
+		},
+		{
+			testName: "Copied from the user buffer",
+			source:   userBufferSource,
+			size:     8,
+			elements: []uint64{0, sleepableMarker, sleepableBufferByte},
+			want:     true,
+		},
+		{
+			testName: "Failed to copy from the user buffer",
+			source:   userBufferSource,
+			size:     8,
+			elements: []uint64{failed, sleepableMarker, 0},
+			want:     false,
+		},
+		{
+			testName: "Copied the wrong byte",
+			source:   userBufferSource,
+			size:     8,
+			elements: []uint64{0, sleepableMarker, 0x41},
+			want:     false,
+		},
+		{
+			testName: "Refused the kernel stack",
+			source:   kernelSource,
+			size:     8,
+			elements: []uint64{failed, sleepableMarker, 0},
+			want:     true,
+		},
+		{
+			testName: "Copied from the kernel stack",
+			source:   kernelSource,
+			size:     8,
+			elements: []uint64{0, sleepableMarker, 0},
+			want:     false,
+		},
+		{
+			testName: "Failed without zeroing",
+			source:   nullSource,
+			size:     8,
+			elements: []uint64{failed, sleepableMarker, 0x41},
+			want:     false,
+		},
+		{
+			testName: "Unexpected return value",
+			source:   addressSource,
+			size:     8,
+			elements: []uint64{1, sleepableMarker, 0},
+			want:     false,
+		},
+		{
+			testName: "Nothing to copy",
+			source:   kernelSource,
+			size:     0,
+			elements: []uint64{0, sleepableMarker, 0},
+			want:     true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			sp := NewSleepableStrategy()
+			sp.source, sp.size, sp.room = tc.source, tc.size, 64
+			if got := sp.checkCopy(tc.elements); got != tc.want {
+				t.Errorf("checkCopy(%v) = %v, want %v", tc.elements, got, tc.want)
+			}
+		})
+	}
+}
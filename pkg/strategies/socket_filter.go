@@ -0,0 +1,318 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"encoding/binary"
+	"errors"
+	"slices"
+
+	"buzzer/pkg/cbpf/cbpf"
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+var (
+	socketFilterOnly = errors.New("socket_filter only generates cBPF filters")
+)
+
+const (
+	// Maximum number of instructions before the return of the generated
+	// filters.
+	socketFilterMaxInstructions = 32
+
+	// Maximum size of the packets sent through the filters.
+	socketFilterMaxPacketSize = 64
+)
+
+// expectedReceivedLength returns how many bytes of a packet of length
+// `packetLen` are received when the filter returns `ret`, 0 means the packet
+// is dropped.
+func expectedReceivedLength(ret uint32, packetLen int) int64 {
+	return min(int64(ret), int64(packetLen))
+}
+
+func NewSocketFilterStrategy() *SocketFilter {
+	return &SocketFilter{isFinished: false}
+}
+
+// SocketFilter is a strategy that attaches random cBPF programs to a socket
+// with SO_ATTACH_FILTER and sends random packets through it. The kernel
+// migrates the filters to eBPF before running them, the part of each packet
+// the filter keeps is compared with the result of interpreting the filter in
+// user space.
+//
+// Some filters contain a jump out of bounds, the kernel must refuse to attach
+// those.
+//
+// When paired eBPF is enabled, the filters are also translated to eBPF and
+// attached natively with SO_ATTACH_BPF, both must keep the same part of the
+// packet. Findings then carry the two programs and a PoC for each one.
+type SocketFilter struct {
+	isFinished        bool
+	programCount      int
+	validProgramCount int
+
+	pairedEbpf bool
+
+	// State of the last generated filter.
+	filter        []cbpf.Instruction
+	packet        []byte
+	invalidReason string
+	translation   *epb.Program
+}
+
+// EnablePairedEbpf makes the strategy run the eBPF translation of every
+// valid filter alongside it.
+func (sf *SocketFilter) EnablePairedEbpf() {
+	sf.pairedEbpf = true
+}
+
+// PairedProgram returns the eBPF translation of the last generated filter,
+// nil if paired eBPF is disabled or the filter could not be translated.
+func (sf *SocketFilter) PairedProgram() *epb.Program {
+	return sf.translation
+}
+
+// randomLoadOffset returns an offset around the bounds of a packet of length
+// `packetLen`.
+func randomLoadOffset(packetLen int) uint32 {
+	return uint32(rand.SharedRNG.RandRange(0, uint64(packetLen+4)))
+}
+
+// randomLoadSize returns one of the three sizes of packet loads.
+func randomLoadSize() uint16 {
+	sizes := []uint16{cbpf.SizeW, cbpf.SizeH, cbpf.SizeB}
+	return sizes[rand.SharedRNG.RandRange(0, uint64(len(sizes)-1))]
+}
+
+// randomSocketFilterInstruction returns a random instruction the classic
+// checker accepts, followed by `remaining` instructions, for a filter that
+// receives packets of length `packetLen`.
+func randomSocketFilterInstruction(state *classicFilterState, remaining int, packetLen int) cbpf.Instruction {
+	switch rand.SharedRNG.RandRange(0, 13) {
+	case 0:
+		return cbpf.LdAbs(randomLoadSize(), randomLoadOffset(packetLen))
+	case 1:
+		return cbpf.LdInd(randomLoadSize(), randomLoadOffset(packetLen))
+	case 2:
+		return cbpf.LdxMsh(randomLoadOffset(packetLen))
+	case 3:
+		if rand.SharedRNG.OneOf(2) {
+			return cbpf.LdLen()
+		}
+		return cbpf.LdxLen()
+	case 4:
+		return cbpf.LdImm(uint32(rand.SharedRNG.RandInt()))
+	case 5:
+		// Small values of X keep indirect loads around the packet.
+		return cbpf.LdxImm(uint32(rand.SharedRNG.RandRange(0, uint64(packetLen))))
+	case 6:
+		return cbpf.Tax()
+	case 7:
+		return cbpf.Txa()
+	case 8:
+		return state.randomStore()
+	case 9:
+		if insn, ok := state.randomLoad(); ok {
+			return insn
+		}
+	case 10:
+		state.branched = true
+		return randomJump(remaining)
+	}
+	return randomAluInstruction(true)
+}
+
+// GenerateSocketFilter should return the filter to attach and the packet to
+// send through it.
+func (sf *SocketFilter) GenerateSocketFilter(ffi *units.FFI) (*fpb.SocketFilterRequest, error) {
+	sf.programCount += 1
+	logging.Debugf("Generated %d filters, %d were attached               \r", sf.programCount, sf.validProgramCount)
+
+	sf.packet = make([]byte, rand.SharedRNG.RandRange(1, socketFilterMaxPacketSize))
+	for i := range sf.packet {
+		sf.packet[i] = byte(rand.SharedRNG.RandInt())
+	}
+
+	// Random values of A almost always keep the whole packet, bring half
+	// of them around the packet length.
+	tail := []cbpf.Instruction{}
+	if rand.SharedRNG.OneOf(2) {
+		tail = append(tail, cbpf.And(uint32(2*socketFilterMaxPacketSize-1)))
+	}
+	tail = append(tail, cbpf.Ret(cbpf.A))
+
+	state := newClassicFilterState()
+	sf.filter = nil
+	count := int(rand.SharedRNG.RandRange(0, socketFilterMaxInstructions))
+	for i := 0; i < count; i++ {
+		sf.filter = append(sf.filter, randomSocketFilterInstruction(state, count-i-1+len(tail), len(sf.packet)))
+	}
+	sf.filter = append(sf.filter, tail...)
+
+	sf.invalidReason = ""
+	if rand.SharedRNG.OneOf(16) {
+		index := int(rand.SharedRNG.RandRange(0, uint64(len(sf.filter)-1)))
+		sf.filter = slices.Insert(sf.filter, index, cbpf.Ja(0))
+		violation := cbpf.PastEnd
+		sf.invalidReason = "jump past the end"
+		if rand.SharedRNG.OneOf(2) {
+			violation = cbpf.Backward
+			sf.invalidReason = "backward jump"
+		}
+		filter, err := cbpf.ViolateJump(sf.filter, index, violation)
+		if err != nil {
+			return nil, err
+		}
+		sf.filter = filter
+	}
+
+	sf.translation = nil
+	if sf.pairedEbpf && sf.invalidReason == "" {
+		translation, err := cbpf.ToEbpf(sf.filter)
+		if err != nil {
+			logging.Warningf("could not translate the filter to eBPF: %v\n", err)
+		} else {
+			sf.translation = translation
+		}
+	}
+
+	return &fpb.SocketFilterRequest{
+		Filter: cbpf.EncodeInstructions(sf.filter),
+		Packet: sf.packet,
+	}, nil
+}
+
+// OnSocketFilterDone should validate if the filter kept the part of the
+// packet it was expected to, if that was not the case it should return
+// false.
+func (sf *SocketFilter) OnSocketFilterDone(ffi *units.FFI, result *fpb.SocketFilterResult) bool {
+	if sf.invalidReason != "" {
+		if result.FilterAttached {
+			logging.Infof("kernel attached a filter with a %s\n", sf.invalidReason)
+			return false
+		}
+		return true
+	}
+	if !result.FilterAttached {
+		logging.Infof("kernel refused a valid filter: %s\n", result.ErrorMessage)
+		return false
+	}
+	sf.validProgramCount += 1
+	if result.ErrorMessage != "" {
+		logging.Warningf("could not receive the packet: %s\n", result.ErrorMessage)
+		return true
+	}
+
+	got := int64(0)
+	if result.Received {
+		got = result.ReceivedLength
+	}
+	if sf.translation != nil && !sf.checkTranslation(ffi, got) {
+		return false
+	}
+
+	ret, err := cbpf.Run(sf.filter, sf.packet, binary.BigEndian)
+	if err != nil {
+		logging.Warningf("could not interpret the filter: %v\n", err)
+		return true
+	}
+	want := expectedReceivedLength(ret, len(sf.packet))
+	if got != want {
+		logging.Infof("filter returned %#x, %d bytes of %d received, want %d\n", ret, got, len(sf.packet), want)
+		return false
+	}
+	return true
+}
+
+// checkTranslation attaches the eBPF translation of the filter, sends the
+// same packet through it and returns false if it did not keep
+// `classicLength` bytes like the classic filter did.
+func (sf *SocketFilter) checkTranslation(ffi *units.FFI, classicLength int64) bool {
+	encoded, err := ebpf.EncodeInstructions(sf.translation)
+	if err != nil {
+		logging.Warningf("could not encode the eBPF translation: %v\n", err)
+		return true
+	}
+	validation, err := ffi.LoadProgram(encoded)
+	if err != nil {
+		logging.Warningf("could not load the eBPF translation: %v\n", err)
+		return true
+	}
+	if !validation.GetIsValid() {
+		// The verifier is stricter than the classic checker, e.g. about
+		// the complexity of the program, this is not a finding.
+		logging.Infof("verifier refused the eBPF translation: %s\n", validation.GetBpfError())
+		return true
+	}
+	defer ffi.CloseFD(int(validation.GetProgramFd()))
+
+	result, err := ffi.RunSocketFilter(&fpb.SocketFilterRequest{
+		Packet: sf.packet,
+		ProgFd: validation.GetProgramFd(),
+	})
+	if err != nil || !result.FilterAttached || result.ErrorMessage != "" {
+		logging.Warningf("could not run the eBPF translation: %v %s\n", err, result.GetErrorMessage())
+		return true
+	}
+	got := int64(0)
+	if result.Received {
+		got = result.ReceivedLength
+	}
+	if got != classicLength {
+		logging.Infof("classic filter kept %d bytes of %d, its eBPF translation kept %d\n", classicLength, len(sf.packet), got)
+		return false
+	}
+	return true
+}
+
+// GenerateProgram is not used, socket filter strategies generate cBPF
+// filters with GenerateSocketFilter instead.
+func (sf *SocketFilter) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	return nil, socketFilterOnly
+}
+
+// OnVerifyDone is not used, there is no eBPF program to verify.
+func (sf *SocketFilter) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	return false
+}
+
+// OnExecuteDone is not used, there is no eBPF program to execute.
+func (sf *SocketFilter) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (sf *SocketFilter) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (sf *SocketFilter) IsFuzzingDone() bool {
+	return sf.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (sf *SocketFilter) Name() string {
+	return "socket_filter"
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	"buzzer/pkg/cbpf/cbpf"
+	"buzzer/pkg/rand"
+)
+
+func TestExpectedReceivedLength(t *testing.T) {
+	tests := []struct {
+		testName  string
+		ret       uint32
+		packetLen int
+		want      int64
+	}{
+		{testName: "Drop", ret: 0, packetLen: 10, want: 0},
+		{testName: "Truncate", ret: 3, packetLen: 10, want: 3},
+		{testName: "Whole packet", ret: 10, packetLen: 10, want: 10},
+		{testName: "More than the packet", ret: 0xffffffff, packetLen: 10, want: 10},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := expectedReceivedLength(tc.ret, tc.packetLen); got != tc.want {
+				t.Errorf("expectedReceivedLength(%#x, %d) = %d, want %d", tc.ret, tc.packetLen, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestGenerateSocketFilter(t *testing.T) {
+	rand.SetSharedSeed(1)
+	sf := NewSocketFilterStrategy()
+	for i := 0; i < 500; i++ {
+		req, err := sf.GenerateSocketFilter(nil)
+		if err != nil {
+			t.Fatalf("GenerateSocketFilter() = %v, want nil error", err)
+		}
+		if len(req.Packet) == 0 || len(req.Packet) > socketFilterMaxPacketSize {
+			t.Fatalf("GenerateSocketFilter() packet has %d bytes", len(req.Packet))
+		}
+		filter := cbpf.DecodeInstructions(req.Filter)
+		err = cbpf.CheckJumps(filter)
+		if sf.invalidReason != "" {
+			if err == nil {
+				t.Fatalf("CheckJumps(%v) = nil, want an error for a %s", filter, sf.invalidReason)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("CheckJumps(%v) = %v, want nil error", filter, err)
+		}
+		for _, insn := range filter {
+			if insn.Class() == cbpf.ClassAlu && insn.Source() == cbpf.SrcK && (insn.Operation() == cbpf.AluDiv || insn.Operation() == cbpf.AluMod) && insn.K == 0 {
+				t.Fatalf("valid filter %v divides by a zero constant", filter)
+			}
+		}
+	}
+}
+
+func TestGenerateSocketFilterPaired(t *testing.T) {
+	rand.SetSharedSeed(1)
+	sf := NewSocketFilterStrategy()
+	sf.EnablePairedEbpf()
+	for i := 0; i < 500; i++ {
+		if _, err := sf.GenerateSocketFilter(nil); err != nil {
+			t.Fatalf("GenerateSocketFilter() = %v, want nil error", err)
+		}
+		if sf.invalidReason != "" {
+			if sf.PairedProgram() != nil {
+				t.Fatalf("filter with a %s was translated to eBPF", sf.invalidReason)
+			}
+			continue
+		}
+		if sf.PairedProgram() == nil {
+			t.Fatalf("valid filter %v was not translated to eBPF", sf.filter)
+		}
+	}
+}
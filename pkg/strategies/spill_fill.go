@@ -0,0 +1,396 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Map layout: element 0 holds the input the program reads, element 1
+	// receives what the program reloaded from the stack.
+	spillFillMapSize = 2
+
+	// Size of the map values, an offset into element 0 beyond it is out
+	// of bounds.
+	spillFillValueSize = 8
+
+	// The spills and fills happen in the bytes right below R10.
+	spillFillRegionSize = 4 * StackSlotSize
+
+	// Offset of the map key on the stack, out of the way of the region.
+	spillFillKeyOffset = -spillFillRegionSize - StackSlotSize
+
+	// Maximum number of stores generated before the fill.
+	spillFillMaxStores = 8
+)
+
+// Sources of the bytes written to the stack, the immediates stored get the
+// following ones.
+const (
+	spillFillZeroSource = iota
+	spillFillPointerSource
+	spillFillConstantSource
+	spillFillInputSource
+)
+
+// spillFillKind is what the generated program does with the value it reloads
+// from the stack.
+type spillFillKind int
+
+const (
+	// The value is stored in element 1 and must be the one the stack
+	// model predicts.
+	fillValue spillFillKind = iota
+
+	// The value is dereferenced as a pointer, which the verifier must
+	// only allow if it is a pointer spilled whole.
+	fillPointer
+
+	// The value is used as an offset into element 0, which the verifier
+	// must only allow if it knows the value is within the element.
+	fillOffset
+)
+
+// spillFillSource is a value written to the stack.
+type spillFillSource struct {
+	kind  StackByteKind
+	value uint64
+
+	// input is true if the value is the one read from element 0, it is
+	// only known after the program was generated.
+	input bool
+}
+
+var spillFillSizes = []int{1, 2, 4, 8}
+
+func NewSpillFillStrategy() *SpillFill {
+	return &SpillFill{isFinished: false, mapFd: -1}
+}
+
+// SpillFill is a strategy that targets the tracking of spilled registers by
+// the verifier. It spills a map value pointer, a constant and an unknown
+// scalar to the stack with stores of every size, sometimes misaligned or
+// partially overlapping earlier ones, keeping track of every byte of the
+// stack in a StackModel. The program then reloads part of the stack and
+// either checks the value against the model, dereferences it as a pointer or
+// uses it as an offset into a map value.
+type SpillFill struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+
+	// State of the last generated program.
+	kind       spillFillKind
+	sources    []spillFillSource
+	filled     []StackByte
+	misaligned bool
+	input      uint64
+}
+
+// spillFillStore returns a store of `size` bytes of `src` at `offset` from
+// R10.
+func spillFillStore[T Src](size int, src T, offset int16) *epb.Instruction {
+	switch size {
+	case 1:
+		return StB(R10, src, offset)
+	case 2:
+		return StH(R10, src, offset)
+	case 4:
+		return StW(R10, src, offset)
+	default:
+		return StDW(R10, src, offset)
+	}
+}
+
+// spillFillLoad returns a load of `size` bytes at `offset` from R10 into
+// `dst`.
+func spillFillLoad(size int, dst epb.Reg, offset int16) *epb.Instruction {
+	switch size {
+	case 1:
+		return LdB(dst, R10, offset)
+	case 2:
+		return LdH(dst, R10, offset)
+	case 4:
+		return LdW(dst, R10, offset)
+	default:
+		return LdDW(dst, R10, offset)
+	}
+}
+
+// randomStackOffset returns an offset in the region naturally aligned for an
+// access of `size` bytes.
+func randomStackOffset(size int) int16 {
+	slot := rand.SharedRNG.RandRange(0, uint64(spillFillRegionSize/size-1))
+	return int16(-spillFillRegionSize + int(slot)*size)
+}
+
+// randomSpillFillConstant returns small values, which are valid offsets into
+// a map value or close to it, more often than random ones.
+func randomSpillFillConstant() uint64 {
+	if rand.SharedRNG.OneOf(2) {
+		return rand.SharedRNG.RandRange(0, 2*spillFillValueSize)
+	}
+	return uint64(int64(int32(rand.SharedRNG.RandInt())))
+}
+
+// filledValue returns the value a load of `bytes` reads and a mask of the
+// bits of it that are known, the bytes of pointers are not.
+func (sf *SpillFill) filledValue(bytes []StackByte) (uint64, uint64) {
+	value, mask := uint64(0), uint64(0)
+	for i, b := range bytes {
+		source := sf.sources[b.Source]
+		if source.kind == StackPointer {
+			continue
+		}
+		v := source.value
+		if source.input {
+			v = sf.input
+		}
+		value |= (v >> (8 * b.Index) & 0xff) << (8 * i)
+		mask |= 0xff << (8 * i)
+	}
+	return value, mask
+}
+
+// lookupElement returns the instructions that store a pointer to the map
+// element `key` in `dst`, or exit if the lookup fails.
+func (sf *SpillFill) lookupElement(key int32, dst epb.Reg) ([]*epb.Instruction, error) {
+	return InstructionSequence(
+		StW(R10, key, spillFillKeyOffset),
+		LdMapByFd(R1, sf.mapFd),
+		Mov64(R2, R10),
+		Add64(R2, spillFillKeyOffset),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+		Mov64(dst, R0),
+	)
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (sf *SpillFill) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	sf.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", sf.programCount, sf.validProgramCount)
+
+	if sf.mapFd < 0 {
+		sf.mapFd = ffi.CreateMapArray(spillFillMapSize)
+		if sf.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	constant := randomSpillFillConstant()
+	sf.sources = []spillFillSource{
+		spillFillZeroSource:     {kind: StackScalar, value: 0},
+		spillFillPointerSource:  {kind: StackPointer},
+		spillFillConstantSource: {kind: StackScalar, value: constant},
+		spillFillInputSource:    {kind: StackScalar, input: true},
+	}
+	sf.misaligned = false
+
+	// R6 points to element 0, R9 to element 1, R8 holds the input read
+	// from element 0 and R7 a constant.
+	insn, err := sf.lookupElement(0, R6)
+	if err != nil {
+		return nil, err
+	}
+	result, err := sf.lookupElement(1, R9)
+	if err != nil {
+		return nil, err
+	}
+	insn = append(insn, result...)
+	insn = append(insn, LdDW(R8, R6, 0), Mov64(R7, int32(constant)))
+
+	// The region starts zeroed so every load reads initialized bytes.
+	stack := &StackModel{}
+	for offset := int16(-spillFillRegionSize); offset < 0; offset += StackSlotSize {
+		insn = append(insn, StDW(R10, 0, offset))
+		if err := stack.Store(offset, StackSlotSize, StackScalar, spillFillZeroSource); err != nil {
+			return nil, err
+		}
+	}
+
+	registers := []epb.Reg{R6, R7, R8}
+	registerSources := []int{spillFillPointerSource, spillFillConstantSource, spillFillInputSource}
+	stores := int(rand.SharedRNG.RandRange(1, spillFillMaxStores))
+	for i := 0; i < stores; i++ {
+		size := spillFillSizes[rand.SharedRNG.RandRange(0, uint64(len(spillFillSizes)-1))]
+		offset := randomStackOffset(size)
+		if size > 1 && int(offset)+size < 0 && rand.SharedRNG.OneOf(32) {
+			offset += 1
+			sf.misaligned = true
+		}
+
+		which := int(rand.SharedRNG.RandRange(0, uint64(len(registers))))
+		if which == len(registers) {
+			imm := randomSpillFillConstant()
+			source := len(sf.sources)
+			sf.sources = append(sf.sources, spillFillSource{kind: StackScalar, value: imm})
+			insn = append(insn, spillFillStore(size, int32(imm), offset))
+			if err := stack.Store(offset, size, StackScalar, source); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		source := registerSources[which]
+		insn = append(insn, spillFillStore(size, registers[which], offset))
+		if err := stack.Store(offset, size, sf.sources[source].kind, source); err != nil {
+			return nil, err
+		}
+	}
+
+	sf.kind = spillFillKind(rand.SharedRNG.RandRange(0, 2))
+	size := StackSlotSize
+	if sf.kind != fillPointer {
+		size = spillFillSizes[rand.SharedRNG.RandRange(0, uint64(len(spillFillSizes)-1))]
+	}
+	offset := randomStackOffset(size)
+	if sf.kind == fillPointer && rand.SharedRNG.OneOf(2) {
+		// Most slots don't hold a whole pointer, look for one that does
+		// so the dereference is valid more often.
+		for slot := int16(-spillFillRegionSize); slot < 0; slot += StackSlotSize {
+			if stack.IsPointerFill(slot, size) {
+				offset = slot
+				break
+			}
+		}
+	}
+	sf.filled, err = stack.Load(offset, size)
+	if err != nil {
+		return nil, err
+	}
+	insn = append(insn, spillFillLoad(size, R1, offset))
+
+	switch sf.kind {
+	case fillValue:
+		insn = append(insn, StDW(R9, R1, 0))
+	case fillPointer:
+		if !stack.IsPointerFill(offset, size) {
+			// Dereferencing the value must be refused, remember it by
+			// dropping the pointer from what was filled.
+			sf.filled = nil
+		}
+		insn = append(insn, LdDW(R2, R1, 0), StDW(R9, R2, 0))
+	case fillOffset:
+		insn = append(insn,
+			Mov64(R2, R6),
+			Add64(R2, R1),
+			LdB(R3, R2, 0),
+			StDW(R9, R1, 0),
+		)
+	}
+
+	footer, err := InstructionSequence(
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &epb.Program{Instructions: append(insn, footer...)}, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (sf *SpillFill) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	sf.validProgramCount += 1
+	if sf.misaligned {
+		logging.Infof("verifier accepted a misaligned stack access\n")
+	}
+	if sf.kind == fillPointer && sf.filled == nil {
+		logging.Infof("verifier accepted a dereference of a stack slot that does not hold a whole pointer\n")
+	}
+
+	sf.input = rand.SharedRNG.RandInt()
+	if ffi.SetMapElement(sf.mapFd, 0, sf.input) != 0 || ffi.SetMapElement(sf.mapFd, 1, 0) != 0 {
+		logging.Warningf("could not initialize the map\n")
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (sf *SpillFill) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if sf.misaligned {
+		return false
+	}
+	mapElements, err := ffi.GetMapElements(sf.mapFd, spillFillMapSize)
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	got := mapElements.Elements[1]
+
+	switch sf.kind {
+	case fillPointer:
+		if sf.filled == nil {
+			logging.Infof("forged pointer read %#x, element 0 holds %#x\n", got, sf.input)
+			return false
+		}
+		if got != sf.input {
+			logging.Infof("filled pointer read %#x, want %#x\n", got, sf.input)
+			return false
+		}
+		return true
+	case fillOffset:
+		if got >= spillFillValueSize {
+			logging.Infof("verifier allowed an access at offset %d of a %d bytes map value\n", got, spillFillValueSize)
+			return false
+		}
+	}
+
+	want, mask := sf.filledValue(sf.filled)
+	if got&mask != want&mask {
+		logging.Infof("filled %#x, want %#x (mask %#x)\n", got, want, mask)
+		return false
+	}
+	return true
+}
+
+// Maps returns the map holding the input and the reloaded value.
+func (sf *SpillFill) Maps() map[int]uint64 {
+	return map[int]uint64{sf.mapFd: spillFillMapSize}
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (sf *SpillFill) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (sf *SpillFill) IsFuzzingDone() bool {
+	return sf.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (sf *SpillFill) Name() string {
+	return "spill_fill"
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+)
+
+func TestFilledValue(t *testing.T) {
+	sf := &SpillFill{
+		sources: []spillFillSource{
+			spillFillZeroSource:     {kind: StackScalar, value: 0},
+			spillFillPointerSource:  {kind: StackPointer},
+			spillFillConstantSource: {kind: StackScalar, value: 0x1122334455667788},
+			spillFillInputSource:    {kind: StackScalar, input: true},
+		},
+		input: 0xaabbccddeeff0011,
+	}
+
+	tests := []struct {
+		testName string
+		stores   func(sm *StackModel)
+		offset   int16
+		size     int
+		want     uint64
+		wantMask uint64
+	}{
+		{
+			testName: "Whole constant",
+			stores: func(sm *StackModel) {
+				sm.Store(-8, 8, StackScalar, spillFillConstantSource)
+			},
+			offset:   -8,
+			size:     8,
+			want:     0x1122334455667788,
+			wantMask: 0xffffffffffffffff,
+		},
+		{
+			testName: "Upper half of the input",
+			stores: func(sm *StackModel) {
+				sm.Store(-8, 8, StackScalar, spillFillInputSource)
+			},
+			offset:   -4,
+			size:     4,
+			want:     0xaabbccdd,
+			wantMask: 0xffffffff,
+		},
+		{
+			testName: "Constant partially overwritten by the input",
+			stores: func(sm *StackModel) {
+				sm.Store(-8, 8, StackScalar, spillFillConstantSource)
+				sm.Store(-6, 2, StackScalar, spillFillInputSource)
+			},
+			offset:   -8,
+			size:     8,
+			want:     0x11223344_0011_7788,
+			wantMask: 0xffffffffffffffff,
+		},
+		{
+			testName: "Pointer bytes are unknown",
+			stores: func(sm *StackModel) {
+				sm.Store(-8, 8, StackPointer, spillFillPointerSource)
+				sm.Store(-8, 2, StackScalar, spillFillConstantSource)
+			},
+			offset:   -8,
+			size:     8,
+			want:     0x7788,
+			wantMask: 0xffff,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			sm := &StackModel{}
+			tc.stores(sm)
+			bytes, err := sm.Load(tc.offset, tc.size)
+			if err != nil {
+				t.Fatalf("Load() = %v, want nil error", err)
+			}
+			got, mask := sf.filledValue(bytes)
+			if got != tc.want || mask != tc.wantMask {
+				t.Errorf("filledValue() = %#x, %#x, want %#x, %#x", got, mask, tc.want, tc.wantMask)
+			}
+		})
+	}
+}
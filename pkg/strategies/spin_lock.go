@@ -0,0 +1,349 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"buzzer/pkg/btf/btf"
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"math"
+)
+
+const (
+	// Offset of the map key on the stack.
+	spinLockKeyOffset = -4
+
+	// Maximum number of critical sections of a program and of counter
+	// updates in each of them.
+	spinLockMaxSections = 3
+	spinLockMaxUpdates  = 3
+)
+
+// spinLockKind is the shape of the lock and unlock calls of the generated
+// program.
+type spinLockKind int
+
+const (
+	// The lock is taken and released in turn around updates of the
+	// counter. The verifier must accept these programs and the counter
+	// must end up with the value the updates computed.
+	balancedLocks spinLockKind = iota
+
+	// The other kinds are unbalanced or misuse the lock, the verifier must
+	// reject them.
+
+	// The program exits with the lock held.
+	missingUnlock
+	// The lock is taken a second time before being released.
+	nestedLock
+	// The lock is released without being taken.
+	unlockWithoutLock
+	// The lock is only taken on one path, it is released on both.
+	conditionalLock
+	// The lock is only released on one path.
+	conditionalUnlock
+	// A helper is called with the lock held.
+	callWhileLocked
+	// The lock field is written to directly with the lock held.
+	lockFieldAccess
+	// bpf_spin_lock is given a pointer that is not to the lock field.
+	misplacedLock
+	// The lock is released through the pointer of another lookup of the
+	// same element, the verifier can't tell it is the same lock.
+	otherPointerUnlock
+
+	numSpinLockKinds
+)
+
+var spinLockKindNames = []string{
+	"balanced locks",
+	"missing unlock",
+	"nested lock",
+	"unlock without lock",
+	"conditional lock",
+	"conditional unlock",
+	"call while locked",
+	"lock field access",
+	"misplaced lock",
+	"other pointer unlock",
+}
+
+func NewSpinLockPairsStrategy() *SpinLockPairs {
+	return &SpinLockPairs{isFinished: false, mapFd: -1}
+}
+
+// SpinLockPairs is a strategy that targets the lock state tracking of the
+// verifier. Programs look up the only element of an array map whose values
+// hold a struct bpf_spin_lock, see btf.SpinLockMap, and call
+// bpf_spin_lock/bpf_spin_unlock on it around updates of the counter next to
+// the lock. Most programs pair the calls correctly, the others are
+// deliberately unbalanced or misuse the lock and must be rejected.
+//
+// Socket filters can't use bpf_spin_lock, the programs are loaded as
+// BPF_PROG_TYPE_SCHED_CLS and executed with BPF_PROG_TEST_RUN.
+//
+// The map needs BTF, which the maps the control unit creates to replay
+// corpora don't have, so programs of this strategy can't be replayed.
+type SpinLockPairs struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+
+	// State of the last generated program: its kind and, for balanced
+	// locks, the counter updates and the condition that skips some of
+	// them.
+	kind      spinLockKind
+	updates   [][]spinLockUpdate
+	condition uint32
+	input     uint32
+}
+
+// spinLockUpdate adds `value` to the counter, unless `conditional` is true
+// and the input, which was in the counter before any update, is greater than
+// the condition of the program.
+type spinLockUpdate struct {
+	value       int32
+	conditional bool
+}
+
+// spinLockCall returns the call to `helper`, bpf_spin_lock or
+// bpf_spin_unlock, on the lock of the value R6 points to plus `offset`.
+func spinLockCall(helper int32, offset int32) []*epb.Instruction {
+	insn := []*epb.Instruction{Mov64(R1, R6)}
+	if offset != 0 {
+		insn = append(insn, Add64(R1, offset))
+	}
+	return append(insn, Call(helper))
+}
+
+// lookup returns the lookup of the element of the map, its pointer ends up
+// in `dst`. The program exits if the lookup fails.
+func (sl *SpinLockPairs) lookup(dst epb.Reg) []*epb.Instruction {
+	return []*epb.Instruction{
+		LdMapByFd(R1, sl.mapFd),
+		StW(R10, 0, spinLockKeyOffset),
+		Mov64(R2, R10),
+		Add64(R2, spinLockKeyOffset),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+		Mov64(dst, R0),
+	}
+}
+
+// criticalSection returns the instructions of `updates` on the counter of
+// the value R6 points to, R7 holds the input and R9 is scratch.
+func (sl *SpinLockPairs) criticalSection(updates []spinLockUpdate) []*epb.Instruction {
+	var insn []*epb.Instruction
+	for _, update := range updates {
+		if update.conditional {
+			insn = append(insn, JmpGT32(R7, int32(sl.condition), 3))
+		}
+		insn = append(insn,
+			LdW(R9, R6, btf.SpinLockDataOffset),
+			Add64(R9, update.value),
+			StW(R6, R9, btf.SpinLockDataOffset))
+	}
+	return insn
+}
+
+// randomUpdates returns between 1 and spinLockMaxUpdates counter updates.
+func randomUpdates() []spinLockUpdate {
+	var updates []spinLockUpdate
+	for i := rand.SharedRNG.RandRange(1, spinLockMaxUpdates); i > 0; i-- {
+		updates = append(updates, spinLockUpdate{
+			value:       int32(rand.SharedRNG.RandInt()),
+			conditional: rand.SharedRNG.OneOf(3),
+		})
+	}
+	return updates
+}
+
+// expectedCounter returns the value of the counter after the updates of
+// the last program ran on `input`.
+func (sl *SpinLockPairs) expectedCounter(input uint32) uint32 {
+	counter := input
+	for _, section := range sl.updates {
+		for _, update := range section {
+			if update.conditional && input > sl.condition {
+				continue
+			}
+			counter += uint32(update.value)
+		}
+	}
+	return counter
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (sl *SpinLockPairs) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	sl.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", sl.programCount, sl.validProgramCount)
+
+	if sl.mapFd < 0 {
+		sl.mapFd = ffi.CreateMapWithBTF(units.MapTypeArray, 4, btf.SpinLockValueSize, 1, 0, btf.SpinLockMap())
+		if sl.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	// Half of the programs are balanced so the verifier also goes past
+	// the lock checks.
+	sl.kind = balancedLocks
+	if rand.SharedRNG.OneOf(2) {
+		sl.kind = spinLockKind(rand.SharedRNG.RandRange(1, uint64(numSpinLockKinds-1)))
+	}
+	// Nothing is greater than the maximum, the verifier would prune the
+	// skipped path and see a balanced program.
+	sl.condition = uint32(rand.SharedRNG.RandInt())
+	if sl.condition == math.MaxUint32 {
+		sl.condition--
+	}
+	sl.updates = nil
+
+	// R6 points to the value and R7 holds the input, the counter before
+	// any update, which the verifier knows nothing about.
+	insn := sl.lookup(R6)
+	if sl.kind == otherPointerUnlock {
+		insn = append(insn, sl.lookup(R8)...)
+	}
+	insn = append(insn, LdW(R7, R6, btf.SpinLockDataOffset))
+
+	// Every call gets its own instructions, programs don't share them.
+	lock := func() []*epb.Instruction { return spinLockCall(SpinLock, btf.SpinLockOffset) }
+	unlock := func() []*epb.Instruction { return spinLockCall(SpinUnlock, btf.SpinLockOffset) }
+	switch sl.kind {
+	case balancedLocks:
+		for i := rand.SharedRNG.RandRange(1, spinLockMaxSections); i > 0; i-- {
+			updates := randomUpdates()
+			sl.updates = append(sl.updates, updates)
+			insn = append(insn, lock()...)
+			insn = append(insn, sl.criticalSection(updates)...)
+			insn = append(insn, unlock()...)
+		}
+	case missingUnlock:
+		insn = append(insn, lock()...)
+		insn = append(insn, sl.criticalSection(randomUpdates())...)
+	case nestedLock:
+		insn = append(insn, lock()...)
+		insn = append(insn, lock()...)
+		insn = append(insn, unlock()...)
+		insn = append(insn, unlock()...)
+	case unlockWithoutLock:
+		insn = append(insn, sl.criticalSection(randomUpdates())...)
+		insn = append(insn, unlock()...)
+	case conditionalLock:
+		skipped := lock()
+		insn = append(insn, JmpGT32(R7, int32(sl.condition), int16(len(skipped))))
+		insn = append(insn, skipped...)
+		insn = append(insn, unlock()...)
+	case conditionalUnlock:
+		insn = append(insn, lock()...)
+		skipped := unlock()
+		insn = append(insn, JmpGT32(R7, int32(sl.condition), int16(len(skipped))))
+		insn = append(insn, skipped...)
+	case callWhileLocked:
+		insn = append(insn, lock()...)
+		insn = append(insn, Call(GetPrandomU32))
+		insn = append(insn, unlock()...)
+	case lockFieldAccess:
+		insn = append(insn, lock()...)
+		insn = append(insn, StW(R6, int32(rand.SharedRNG.RandInt()), btf.SpinLockOffset))
+		insn = append(insn, unlock()...)
+	case misplacedLock:
+		insn = append(insn, spinLockCall(SpinLock, btf.SpinLockDataOffset)...)
+		insn = append(insn, unlock()...)
+	case otherPointerUnlock:
+		insn = append(insn, lock()...)
+		insn = append(insn, Mov64(R1, R8), Call(SpinUnlock))
+	}
+	insn = append(insn, Mov64(R0, 0), Exit())
+	return &epb.Program{Instructions: insn}, nil
+}
+
+// ProgramType returns the type the programs are loaded as.
+func (sl *SpinLockPairs) ProgramType() int {
+	return units.ProgTypeSchedCls
+}
+
+// Decisions returns the kind of the last program for the decision log.
+func (sl *SpinLockPairs) Decisions() []string {
+	return []string{spinLockKindNames[sl.kind]}
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (sl *SpinLockPairs) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	sl.validProgramCount += 1
+	if sl.kind != balancedLocks {
+		logging.Infof("verifier accepted a program with %s\n", spinLockKindNames[sl.kind])
+	}
+
+	// The lock is in the low half of the element, updates leave it
+	// alone.
+	sl.input = uint32(rand.SharedRNG.RandInt())
+	if ffi.SetMapElement(sl.mapFd, 0, uint64(sl.input)<<(8*btf.SpinLockDataOffset)) != 0 {
+		logging.Warningf("could not initialize the map\n")
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (sl *SpinLockPairs) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if sl.kind != balancedLocks {
+		// Reaching this point is already a bug.
+		return false
+	}
+	mapElements, err := ffi.GetMapElements(sl.mapFd, 1)
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	got := uint32(mapElements.Elements[0] >> (8 * btf.SpinLockDataOffset))
+	if want := sl.expectedCounter(sl.input); got != want {
+		logging.Infof("counter is %#x after the critical sections, want %#x\n", got, want)
+		return false
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (sl *SpinLockPairs) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (sl *SpinLockPairs) IsFuzzingDone() bool {
+	return sl.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (sl *SpinLockPairs) Name() string {
+	return "spin_lock"
+}
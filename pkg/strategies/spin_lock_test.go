@@ -0,0 +1,59 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+)
+
+func TestExpectedCounter(t *testing.T) {
+	sl := &SpinLockPairs{
+		updates: [][]spinLockUpdate{
+			{{value: 1}, {value: 0x10, conditional: true}},
+			{{value: -2}},
+		},
+		condition: 100,
+	}
+
+	tests := []struct {
+		testName string
+		input    uint32
+		want     uint32
+	}{
+		{
+			testName: "Conditional update done",
+			input:    100,
+			want:     100 + 1 + 0x10 - 2,
+		},
+		{
+			testName: "Conditional update skipped",
+			input:    101,
+			want:     101 + 1 - 2,
+		},
+		{
+			testName: "Counter wraps around",
+			input:    0xffffffff,
+			want:     0xffffffff + 1 - 2,
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.testName, func(t *testing.T) {
+			if got := sl.expectedCounter(c.input); got != c.want {
+				t.Errorf("expectedCounter(%#x) = %#x, want %#x", c.input, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Map layout: element 0 is the value the spilled pointer points to,
+	// element 1 receives what the program reloaded from the stack.
+	stackConfusionMapSize = 2
+
+	// Stack slot where the pointer to element 0 is spilled.
+	stackConfusionSpillSlot = -8
+
+	// Stack slot right below the spill slot, overwriting it must not
+	// affect the spilled pointer.
+	stackConfusionNeighbourSlot = -16
+
+	// Offset of the map key on the stack, out of the way of both slots.
+	stackConfusionKeyOffset = -20
+)
+
+// stackConfusionKind is the shape of the generated program.
+type stackConfusionKind int
+
+const (
+	// Part of the spilled pointer is overwritten with a scalar and the
+	// slot is then reloaded and dereferenced. The verifier must reject
+	// these programs.
+	confusedSlot stackConfusionKind = iota
+
+	// The slot next to the spilled pointer is overwritten, the pointer
+	// is still valid and dereferencing it must read element 0.
+	neighbourSlot
+
+	// Part of the spilled pointer is overwritten and the slot is reloaded
+	// as a scalar, the overwritten bytes must be the ones written.
+	scalarReload
+)
+
+// overwriteBytes returns `original` with `size` bytes, starting at byte
+// `offset`, replaced by the low bytes of `value`.
+func overwriteBytes(original, value uint64, offset, size int) uint64 {
+	mask := uint64(1)<<(8*size) - 1
+	if size == 8 {
+		mask = ^uint64(0)
+	}
+	shift := 8 * offset
+	return original&^(mask<<shift) | (value&mask)<<shift
+}
+
+func NewStackConfusionStrategy() *StackConfusion {
+	return &StackConfusion{isFinished: false, mapFd: -1}
+}
+
+// StackConfusion is a strategy that spills a map value pointer to the stack,
+// partially overwrites the stack slot with a scalar and reloads it. The
+// verifier has to stop treating the slot as a pointer after the overwrite,
+// programs that use the reloaded value as a pointer must be rejected. For the
+// programs it accepts, the execution results are checked to confirm that no
+// pointer was forged.
+type StackConfusion struct {
+	isFinished        bool
+	mapFd             int
+	programCount      int
+	validProgramCount int
+
+	// State of the last generated program.
+	kind          stackConfusionKind
+	input         uint64
+	overwriteSize int
+	overwriteByte int
+	overwriteImm  int32
+	overwriteReg  bool
+}
+
+// overwriteInstruction returns the store that overwrites `size` bytes at
+// `offset`, either from R8 or from an immediate.
+func (sc *StackConfusion) overwriteInstruction(offset int16) *epb.Instruction {
+	switch sc.overwriteSize {
+	case 1:
+		if sc.overwriteReg {
+			return StB(R10, R8, offset)
+		}
+		return StB(R10, sc.overwriteImm, offset)
+	case 2:
+		if sc.overwriteReg {
+			return StH(R10, R8, offset)
+		}
+		return StH(R10, sc.overwriteImm, offset)
+	default:
+		if sc.overwriteReg {
+			return StW(R10, R8, offset)
+		}
+		return StW(R10, sc.overwriteImm, offset)
+	}
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (sc *StackConfusion) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	sc.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", sc.programCount, sc.validProgramCount)
+
+	if sc.mapFd < 0 {
+		sc.mapFd = ffi.CreateMapArray(stackConfusionMapSize)
+		if sc.mapFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	sc.kind = stackConfusionKind(rand.SharedRNG.RandRange(0, 2))
+	sizes := []int{1, 2, 4}
+	sc.overwriteSize = sizes[rand.SharedRNG.RandRange(0, uint64(len(sizes)-1))]
+	sc.overwriteByte = int(rand.SharedRNG.RandRange(0, uint64(8/sc.overwriteSize-1))) * sc.overwriteSize
+	sc.overwriteImm = int32(rand.SharedRNG.RandInt())
+	sc.overwriteReg = rand.SharedRNG.OneOf(2)
+
+	// R9 holds the map, R6 the pointer to element 0 and R8 the scalar read
+	// from it.
+	insn, err := InstructionSequence(
+		LdMapByFd(R9, sc.mapFd),
+		StW(R10, 0, stackConfusionKeyOffset),
+		Mov64(R1, R9),
+		Mov64(R2, R10),
+		Add64(R2, stackConfusionKeyOffset),
+		Call(MapLookup),
+		JmpNE(R0, 0, 2),
+		Mov64(R0, 0),
+		Exit(),
+		Mov64(R6, R0),
+		LdDW(R8, R6, 0),
+		StDW(R10, R6, stackConfusionSpillSlot),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	slot := int16(stackConfusionSpillSlot)
+	if sc.kind == neighbourSlot {
+		slot = stackConfusionNeighbourSlot
+	}
+
+	// Overwriting the pointer only on some paths must not fool the
+	// verifier either.
+	if sc.kind == confusedSlot && rand.SharedRNG.OneOf(2) {
+		insn = append(insn, JmpEQ(R8, int32(rand.SharedRNG.RandInt()), 1))
+	}
+	insn = append(insn, sc.overwriteInstruction(slot+int16(sc.overwriteByte)))
+	insn = append(insn, LdDW(R7, R10, stackConfusionSpillSlot))
+	if sc.kind != scalarReload {
+		insn = append(insn, LdDW(R7, R7, 0))
+	}
+
+	footer, err := InstructionSequence(
+		StW(R10, 1, stackConfusionKeyOffset),
+		Mov64(R1, R9),
+		Mov64(R2, R10),
+		Add64(R2, stackConfusionKeyOffset),
+		Call(MapLookup),
+		JmpEQ(R0, 0, 1),
+		StDW(R0, R7, 0),
+		Mov64(R0, 0),
+		Exit(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &epb.Program{Instructions: append(insn, footer...)}, nil
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (sc *StackConfusion) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	sc.validProgramCount += 1
+	if sc.kind == confusedSlot {
+		logging.Infof("verifier accepted a dereference of a partially overwritten pointer spill\n")
+	}
+
+	sc.input = rand.SharedRNG.RandInt()
+	if ffi.SetMapElement(sc.mapFd, 0, sc.input) != 0 || ffi.SetMapElement(sc.mapFd, 1, 0) != 0 {
+		logging.Warningf("could not initialize the map\n")
+		return false
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (sc *StackConfusion) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	mapElements, err := ffi.GetMapElements(sc.mapFd, stackConfusionMapSize)
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	got := mapElements.Elements[1]
+
+	switch sc.kind {
+	case confusedSlot:
+		// Reaching this point is already a bug, report what the forged
+		// pointer read.
+		logging.Infof("forged pointer read %#x, element 0 holds %#x\n", got, sc.input)
+		return false
+	case neighbourSlot:
+		if got != sc.input {
+			logging.Infof("spilled pointer read %#x, want %#x\n", got, sc.input)
+			return false
+		}
+	case scalarReload:
+		value := uint64(int64(sc.overwriteImm))
+		if sc.overwriteReg {
+			value = sc.input
+		}
+		if want := overwriteBytes(got, value, sc.overwriteByte, sc.overwriteSize); got != want {
+			logging.Infof("reloaded slot %#x, want bytes %d-%d to come from %#x\n", got, sc.overwriteByte, sc.overwriteByte+sc.overwriteSize-1, value)
+			return false
+		}
+	}
+	return true
+}
+
+// Maps returns the map the spilled pointer points to.
+func (sc *StackConfusion) Maps() map[int]uint64 {
+	return map[int]uint64{sc.mapFd: stackConfusionMapSize}
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (sc *StackConfusion) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (sc *StackConfusion) IsFuzzingDone() bool {
+	return sc.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (sc *StackConfusion) Name() string {
+	return "stack_confusion"
+}
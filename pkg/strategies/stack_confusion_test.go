@@ -0,0 +1,43 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+)
+
+func TestOverwriteBytes(t *testing.T) {
+	tests := []struct {
+		testName string
+		original uint64
+		value    uint64
+		offset   int
+		size     int
+		want     uint64
+	}{
+		{testName: "Lowest byte", original: 0x1122334455667788, value: 0xff, offset: 0, size: 1, want: 0x11223344556677ff},
+		{testName: "Highest half word", original: 0x1122334455667788, value: 0xabcd, offset: 6, size: 2, want: 0xabcd334455667788},
+		{testName: "Value is truncated", original: 0x1122334455667788, value: 0xdeadbeefcafe, offset: 4, size: 4, want: 0xbeefcafe55667788},
+		{testName: "Whole slot", original: 0x1122334455667788, value: 42, offset: 0, size: 8, want: 42},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := overwriteBytes(tc.original, tc.value, tc.offset, tc.size); got != tc.want {
+				t.Errorf("overwriteBytes() = %#x, want %#x", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,490 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"fmt"
+	"slices"
+
+	"buzzer/pkg/btf/btf"
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Number of elements of the map holding the timers, every program
+	// uses one of them.
+	timerMapSize = 4
+
+	// Most helper calls a program makes on its timer. Element i of the
+	// result map receives what call i returned, element timerMaxSteps the
+	// marker written once the program ran.
+	timerMaxSteps = 8
+	timerMarker   = 0x54494d4552
+
+	// Stack layout: the zeroed value the timer element is reset with, the
+	// key of the timer element and the key of the results.
+	timerValueOffset     = -btf.TimerValueSize
+	timerKeyOffset       = timerValueOffset - 4
+	timerResultKeyOffset = timerKeyOffset - 4
+
+	// Timers started for timerLongNsecs are still pending when the program
+	// returns, the ones started for less may already have fired.
+	timerShortNsecs = 1000
+	timerLongNsecs  = 10 * 1000 * 1000 * 1000
+
+	// A callback restarting its timer waits at least this long, so the
+	// timers that keep restarting don't starve the fuzzer.
+	timerRestartNsecs = 1000 * 1000
+
+	// timerBadFlag is not a BPF_F_TIMER_* flag.
+	timerBadFlag = 0x80
+
+	// Errors the timer helpers return.
+	errEBUSY = -16
+)
+
+// timerClocks are the clocks bpf_timer_init accepts.
+var timerClocks = []int32{0 /* CLOCK_REALTIME */, 1 /* CLOCK_MONOTONIC */, 7 /* CLOCK_BOOTTIME */}
+
+// timerOp is a call a program makes on its timer.
+type timerOp int
+
+const (
+	timerInit timerOp = iota
+	timerSetCallback
+	timerStart
+	timerCancel
+	// The program updates the element holding the timer, which cancels and
+	// frees the timer.
+	timerUpdate
+
+	numTimerOps
+)
+
+var timerOpNames = []string{"init", "set_callback", "start", "cancel", "update"}
+
+// timerStep is a call and its arguments: the clock of timerInit, the
+// nanoseconds and flags of timerStart.
+type timerStep struct {
+	op    timerOp
+	clock int32
+	nsecs uint64
+	flags int32
+}
+
+func (s timerStep) String() string {
+	switch s.op {
+	case timerInit:
+		return fmt.Sprintf("init(clock %d)", s.clock)
+	case timerStart:
+		return fmt.Sprintf("start(%dns, flags %#x)", s.nsecs, s.flags)
+	}
+	return timerOpNames[s.op]
+}
+
+// timerKind is how the program breaks the rules of the timer helpers.
+type timerKind int
+
+const (
+	// The program follows the rules, the verifier must accept it and the
+	// helpers must return what the state of the timer calls for.
+	validTimer timerKind = iota
+
+	// The other kinds break the rules, the verifier must reject them.
+
+	// The timer pointer is not at the offset of the struct bpf_timer.
+	timerMisaligned
+	// bpf_timer_init is given another map than the one holding the timer.
+	timerWrongMap
+	// The callback returns 1, timer callbacks must return 0.
+	timerCallbackReturn
+
+	numTimerKinds
+)
+
+var timerKindNames = []string{"valid timer", "misaligned timer", "wrong map", "callback return"}
+
+// timerPending is what is known about a timer being armed.
+type timerPending int
+
+const (
+	timerIdle timerPending = iota
+	timerArmed
+	// The timer was started for a short time, it may have fired, and its
+	// callback may have restarted it.
+	timerMaybeArmed
+)
+
+func NewTimersStrategy() *Timers {
+	return &Timers{isFinished: false, timerFd: -1, resultFd: -1}
+}
+
+// Timers is a strategy for BPF timers, a subsystem with a history of use
+// after free bugs. The programs reset an element of a map holding a struct
+// bpf_timer, then make a sequence of bpf_timer_init, set_callback, start and
+// cancel calls on it, and update the element, which frees the timer, in
+// between. Timers are started for short times so their callbacks race with
+// the calls that follow, and the callbacks restart their timer, which keeps
+// them racing with the programs that come next.
+//
+// Every call of a valid program must return what the state of the timer
+// calls for. Some programs break the rules of the helpers and must be
+// rejected.
+type Timers struct {
+	isFinished        bool
+	programCount      int
+	validProgramCount int
+
+	// timerFd is the map holding the timers, resultFd where the programs
+	// store what the calls returned.
+	timerFd  int
+	resultFd int
+
+	// State of the last generated program and its BTF.
+	kind       timerKind
+	key        int32
+	steps      []timerStep
+	restart    bool
+	programBTF *btf.Program
+}
+
+// randomTimerStep returns the call `op` on the timer with random arguments.
+func randomTimerStep(op timerOp) timerStep {
+	step := timerStep{op: op}
+	switch op {
+	case timerInit:
+		step.clock = timerClocks[rand.SharedRNG.RandRange(0, uint64(len(timerClocks)-1))]
+		if rand.SharedRNG.OneOf(8) {
+			step.clock = int32(rand.SharedRNG.RandRange(0, 16))
+		}
+	case timerStart:
+		step.nsecs = rand.SharedRNG.RandRange(0, timerShortNsecs)
+		if rand.SharedRNG.OneOf(2) {
+			step.nsecs = timerLongNsecs
+		}
+		if rand.SharedRNG.OneOf(8) {
+			step.flags = timerBadFlag
+		}
+	}
+	return step
+}
+
+// randomTimerSteps returns the calls of a program. Most start by initializing,
+// setting the callback of and starting the timer, the others are all random.
+func randomTimerSteps() []timerStep {
+	count := int(rand.SharedRNG.RandRange(1, timerMaxSteps))
+	steps := []timerStep{}
+	if !rand.SharedRNG.OneOf(4) {
+		steps = append(steps, randomTimerStep(timerInit), randomTimerStep(timerSetCallback), randomTimerStep(timerStart))
+	}
+	for len(steps) < count {
+		steps = append(steps, randomTimerStep(timerOp(rand.SharedRNG.RandRange(0, uint64(numTimerOps-1)))))
+	}
+	return steps
+}
+
+// hasStep returns true if the last program calls `op`.
+func (tm *Timers) hasStep(op timerOp) bool {
+	return slices.ContainsFunc(tm.steps, func(s timerStep) bool { return s.op == op })
+}
+
+// validClock returns true if bpf_timer_init accepts `clock`.
+func validClock(clock int32) bool {
+	return slices.Contains(timerClocks, clock)
+}
+
+// expected returns the values each call of the last program can return.
+func (tm *Timers) expected() [][]int64 {
+	initialized, callback, pending := false, false, timerIdle
+	res := [][]int64{}
+	for _, step := range tm.steps {
+		want := []int64{errEINVAL}
+		switch step.op {
+		case timerInit:
+			switch {
+			case !validClock(step.clock):
+			case initialized:
+				want = []int64{errEBUSY}
+			default:
+				want = []int64{0}
+				initialized = true
+			}
+		case timerSetCallback:
+			if initialized {
+				want = []int64{0}
+				callback = true
+			}
+		case timerStart:
+			if step.flags == 0 && initialized && callback {
+				want = []int64{0}
+				if step.nsecs == timerLongNsecs && pending != timerMaybeArmed {
+					pending = timerArmed
+				} else {
+					pending = timerMaybeArmed
+				}
+			}
+		case timerCancel:
+			if initialized {
+				switch pending {
+				case timerIdle:
+					want = []int64{0}
+				case timerArmed:
+					want = []int64{1}
+				default:
+					want = []int64{0, 1}
+				}
+				pending = timerIdle
+			}
+		case timerUpdate:
+			want = []int64{0}
+			initialized, callback, pending = false, false, timerIdle
+		}
+		res = append(res, want)
+	}
+	return res
+}
+
+// storeResult returns the store of `reg` at index `key` of the result map.
+func (tm *Timers) storeResult(b *ProgramBuilder, key int32, reg epb.Reg) *ProgramBuilder {
+	return b.StW(R10, key, timerResultKeyOffset).
+		Mov64(R2, R10).Add64(R2, timerResultKeyOffset).
+		LdMapByFd(R1, tm.resultFd).
+		Call(MapLookup).
+		JmpEQ(R0, 0, "out").
+		StDW(R0, reg, 0)
+}
+
+// reset returns the update of the timer element with zeroes, which frees
+// its timer.
+func (tm *Timers) reset(b *ProgramBuilder) *ProgramBuilder {
+	for offset := timerValueOffset; offset < 0; offset += 8 {
+		b.StDW(R10, 0, int16(offset))
+	}
+	return b.LdMapByFd(R1, tm.timerFd).
+		Mov64(R2, R10).Add64(R2, timerKeyOffset).
+		Mov64(R3, R10).Add64(R3, timerValueOffset).
+		Mov64(R4, 0).
+		Call(MapUpdate)
+}
+
+// step returns the call `s` on the timer. The timer element is in R7.
+func (tm *Timers) step(b *ProgramBuilder, s timerStep) *ProgramBuilder {
+	if s.op == timerUpdate {
+		return tm.reset(b)
+	}
+	b.Mov64(R1, R7)
+	if tm.kind == timerMisaligned {
+		b.Add64(R1, int32(rand.SharedRNG.RandRange(1, btf.TimerDataOffset/4))*4)
+	}
+	switch s.op {
+	case timerInit:
+		mapFd := tm.timerFd
+		if tm.kind == timerWrongMap {
+			mapFd = tm.resultFd
+		}
+		return b.LdMapByFd(R2, mapFd).Mov64(R3, s.clock).Call(TimerInit)
+	case timerSetCallback:
+		return b.LdFunc(R2, "callback").Call(TimerSetCallback)
+	case timerStart:
+		return b.LdImm64(R2, s.nsecs).Mov64(R3, s.flags).Call(TimerStart)
+	default:
+		return b.Call(TimerCancel)
+	}
+}
+
+// callback returns the callback of the timer, it counts its calls in the
+// timer element and may restart the timer. It gets the element in R3.
+func (tm *Timers) callback(b *ProgramBuilder) *ProgramBuilder {
+	b.LdW(R4, R3, btf.TimerDataOffset).
+		Add64(R4, 1).
+		StW(R3, R4, btf.TimerDataOffset)
+	if tm.restart {
+		b.Mov64(R1, R3).Add64(R1, btf.TimerOffset).
+			Mov64(R2, int32(rand.SharedRNG.RandRange(timerRestartNsecs, 100*timerRestartNsecs))).
+			Mov64(R3, 0).
+			Call(TimerStart)
+	}
+	ret := int32(0)
+	if tm.kind == timerCallbackReturn {
+		ret = 1
+	}
+	return b.Mov64(R0, ret).Exit()
+}
+
+// program returns the instructions of the last generated program.
+func (tm *Timers) program() (*epb.Program, error) {
+	b := NewProgramBuilder().StW(R10, tm.key, timerKeyOffset)
+	tm.reset(b).
+		LdMapByFd(R1, tm.timerFd).
+		Mov64(R2, R10).Add64(R2, timerKeyOffset).
+		Call(MapLookup).
+		JmpEQ(R0, 0, "out").
+		Mov64(R7, R0)
+	for i, s := range tm.steps {
+		tm.step(b, s).Mov64(R6, R0)
+		tm.storeResult(b, int32(i), R6)
+	}
+	b.LdImm64(R6, timerMarker)
+	tm.storeResult(b, timerMaxSteps, R6)
+	b.Label("out").Mov64(R0, 0).Exit()
+	// The callback is only part of the program if it is used, the verifier
+	// rejects unreachable functions.
+	if tm.hasStep(timerSetCallback) {
+		tm.callback(b.Label("callback"))
+	}
+	return b.Build()
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (tm *Timers) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	tm.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", tm.programCount, tm.validProgramCount)
+
+	if tm.timerFd < 0 {
+		tm.timerFd = ffi.CreateMapWithBTF(units.MapTypeArray, 4, btf.TimerValueSize, timerMapSize, 0, btf.TimerMap())
+		tm.resultFd = ffi.CreateMapArray(timerMaxSteps + 1)
+		if tm.timerFd < 0 || tm.resultFd < 0 {
+			return nil, mapCreationFailed
+		}
+	}
+
+	tm.key = int32(rand.SharedRNG.RandRange(0, timerMapSize-1))
+	tm.steps = randomTimerSteps()
+	tm.restart = rand.SharedRNG.OneOf(2)
+	tm.kind = validTimer
+	if rand.SharedRNG.OneOf(4) {
+		tm.kind = timerKind(rand.SharedRNG.RandRange(1, uint64(numTimerKinds-1)))
+	}
+	// Make sure the call breaking the rules is made.
+	switch tm.kind {
+	case timerMisaligned, timerWrongMap:
+		tm.steps[0] = randomTimerStep(timerInit)
+	case timerCallbackReturn:
+		if !tm.hasStep(timerSetCallback) {
+			tm.steps[0] = randomTimerStep(timerSetCallback)
+		}
+	}
+
+	prog, err := tm.program()
+	if err != nil {
+		return nil, err
+	}
+	// The verifier wants callbacks to be static functions described by
+	// BTF.
+	tm.programBTF, err = btf.ForProgram(prog)
+	if err != nil {
+		return nil, err
+	}
+	return prog, nil
+}
+
+// ProgramBTF returns the BTF of the last generated program.
+func (tm *Timers) ProgramBTF() *btf.Program {
+	return tm.programBTF
+}
+
+// Decisions returns the kind and the calls of the last program for the
+// decision log.
+func (tm *Timers) Decisions() []string {
+	res := []string{timerKindNames[tm.kind]}
+	for _, s := range tm.steps {
+		res = append(res, s.String())
+	}
+	if tm.restart {
+		res = append(res, "callback restarts the timer")
+	}
+	return res
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (tm *Timers) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	tm.validProgramCount += 1
+	if tm.kind != validTimer {
+		logging.Infof("verifier accepted a timer program with a %s\n", timerKindNames[tm.kind])
+	}
+	for key := uint32(0); key <= timerMaxSteps; key++ {
+		if ffi.SetMapElement(tm.resultFd, key, 0) != 0 {
+			logging.Warningf("could not clear the results\n")
+			return false
+		}
+	}
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (tm *Timers) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if tm.kind != validTimer {
+		// Reaching this point is already a bug.
+		return false
+	}
+	if ffi.Maps != nil {
+		// Fake maps are never written to by programs.
+		return true
+	}
+	mapElements, err := ffi.GetMapElements(tm.resultFd, timerMaxSteps+1)
+	if err == nil && mapElements.GetErrorMessage() != "" {
+		err = fmt.Errorf("%s", mapElements.GetErrorMessage())
+	}
+	if err != nil {
+		logging.Warningf("%v\n", err)
+		return true
+	}
+	return tm.checkResults(mapElements.GetElements())
+}
+
+// checkResults returns false if a call of the last program returned a value
+// the state of the timer doesn't allow.
+func (tm *Timers) checkResults(elements []uint64) bool {
+	if elements[timerMaxSteps] != timerMarker {
+		// The program did not run.
+		return true
+	}
+	for i, want := range tm.expected() {
+		if got := int64(elements[i]); !slices.Contains(want, got) {
+			logging.Infof("timer call %d, %s, returned %d, want one of %v\n", i, tm.steps[i], got, want)
+			return false
+		}
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (tm *Timers) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (tm *Timers) IsFuzzingDone() bool {
+	return tm.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (tm *Timers) Name() string {
+	return "timers"
+}
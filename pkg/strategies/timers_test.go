@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"reflect"
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestTimersProgramsCallTheTimerHelpers(t *testing.T) {
+	tm := NewTimersStrategy()
+	tm.timerFd, tm.resultFd = 3, 4
+	for i := 0; i < 200; i++ {
+		prog, err := tm.GenerateProgram(nil)
+		if err != nil {
+			t.Fatalf("GenerateProgram() = %v, want nil error", err)
+		}
+		if _, err := EncodeInstructions(prog); err != nil {
+			t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+		}
+		calls := make(map[int32]int)
+		for _, in := range prog.Instructions {
+			if in.GetJmpOpcode().GetOperationCode() == epb.JmpOperationCode_JmpCALL && in.SrcReg == R0 {
+				calls[in.Immediate]++
+			}
+		}
+		want := make(map[int32]int)
+		for _, s := range tm.steps {
+			want[[]int32{TimerInit, TimerSetCallback, TimerStart, TimerCancel, MapUpdate}[s.op]]++
+		}
+		if tm.restart && tm.hasStep(timerSetCallback) {
+			want[TimerStart]++
+		}
+		for _, id := range []int32{TimerInit, TimerSetCallback, TimerStart, TimerCancel} {
+			if calls[id] != want[id] {
+				t.Fatalf("program for %v calls helper %d %d times, want %d", tm.Decisions(), id, calls[id], want[id])
+			}
+		}
+		// The element is reset before the calls.
+		if calls[MapUpdate] != want[MapUpdate]+1 {
+			t.Fatalf("program for %v updates the timer %d times, want %d", tm.Decisions(), calls[MapUpdate], want[MapUpdate]+1)
+		}
+		if tm.programBTF == nil || len(tm.programBTF.FuncInfo) != 1+min(1, want[TimerSetCallback]) {
+			t.Fatalf("program for %v has BTF %v, want a function for the callback if there is one", tm.Decisions(), tm.programBTF)
+		}
+	}
+}
+
+func TestTimersExpected(t *testing.T) {
+	initialize := timerStep{op: timerInit, clock: 1}
+	callback := timerStep{op: timerSetCallback}
+	long := timerStep{op: timerStart, nsecs: timerLongNsecs}
+	short := timerStep{op: timerStart, nsecs: 10}
+	cancel := timerStep{op: timerCancel}
+	update := timerStep{op: timerUpdate}
+	tests := []struct {
+		testName string
+		steps    []timerStep
+		want     [][]int64
+	}{
+		{
+			testName: "Start and cancel",
+			steps:    []timerStep{initialize, callback, long, cancel, cancel},
+			want:     [][]int64{{0}, {0}, {0}, {1}, {0}},
+		},
+		{
+			testName: "Short timer",
+			steps:    []timerStep{initialize, callback, short, long, cancel},
+			want:     [][]int64{{0}, {0}, {0}, {0}, {0, 1}},
+		},
+		{
+			testName: "Not initialized",
+			steps:    []timerStep{callback, long, cancel, initialize, initialize},
+			want:     [][]int64{{errEINVAL}, {errEINVAL}, {errEINVAL}, {0}, {errEBUSY}},
+		},
+		{
+			testName: "No callback",
+			steps:    []timerStep{initialize, long, cancel},
+			want:     [][]int64{{0}, {errEINVAL}, {0}},
+		},
+		{
+			testName: "Bad arguments",
+			steps:    []timerStep{{op: timerInit, clock: 2}, initialize, callback, {op: timerStart, flags: timerBadFlag}},
+			want:     [][]int64{{errEINVAL}, {0}, {0}, {errEINVAL}},
+		},
+		{
+			testName: "Update frees the timer",
+			steps:    []timerStep{initialize, callback, long, update, cancel, initialize},
+			want:     [][]int64{{0}, {0}, {0}, {0}, {errEINVAL}, {0}},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			tm := NewTimersStrategy()
+			tm.steps = tc.steps
+			if got := tm.expected(); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("expected() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTimersCheckResults(t *testing.T) {
+	tm := NewTimersStrategy()
+	tm.steps = []timerStep{{op: timerInit, clock: 1}, {op: timerSetCallback}, {op: timerStart, nsecs: 10}, {op: timerCancel}}
+	results := func(values ...int64) []uint64 {
+		elements := make([]uint64, timerMaxSteps+1)
+		for i, v := range values {
+			elements[i] = uint64(v)
+		}
+		elements[timerMaxSteps] = timerMarker
+		return elements
+	}
+	if !tm.checkResults(results(0, 0, 0, 1)) || !tm.checkResults(results(0, 0, 0, 0)) {
+		t.Errorf("checkResults() = false for the values the calls can return")
+	}
+	if tm.checkResults(results(0, 0, errEINVAL, 0)) {
+		t.Errorf("checkResults() = true for a start that failed")
+	}
+	if !tm.checkResults(make([]uint64, timerMaxSteps+1)) {
+		t.Errorf("checkResults() = false for a program that did not run")
+	}
+}
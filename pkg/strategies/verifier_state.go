@@ -0,0 +1,125 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// scalarBounds is what the verifier tracks about a scalar register, as
+// printed in the verifier log with log_level 2.
+type scalarBounds struct {
+	umin, umax uint64
+	smin, smax int64
+
+	// var_off, the bits set in mask are unknown, the rest have the
+	// values of value.
+	value, mask uint64
+}
+
+// unknownScalar returns the bounds of a scalar the verifier knows nothing
+// about, fields missing from the log default to these values.
+func unknownScalar() scalarBounds {
+	return scalarBounds{
+		umin: 0,
+		umax: math.MaxUint64,
+		smin: math.MinInt64,
+		smax: math.MaxInt64,
+		mask: math.MaxUint64,
+	}
+}
+
+func constantScalar(v uint64) scalarBounds {
+	return scalarBounds{umin: v, umax: v, smin: int64(v), smax: int64(v), value: v}
+}
+
+// contains returns true if `v` is a value the verifier considers possible.
+func (sb scalarBounds) contains(v uint64) bool {
+	return v >= sb.umin && v <= sb.umax &&
+		int64(v) >= sb.smin && int64(v) <= sb.smax &&
+		v&^sb.mask == sb.value
+}
+
+func (sb scalarBounds) String() string {
+	return fmt.Sprintf("umin=%d umax=%d smin=%d smax=%d var_off=(%#x; %#x)", sb.umin, sb.umax, sb.smin, sb.smax, sb.value, sb.mask)
+}
+
+var (
+	// Matches both the old format (R1_w=inv(id=0,umin_value=1,...)) and
+	// the new one (R1_w=scalar(umin=1,...)), as well as constants
+	// (R1_w=inv5, R1_w=P5, R1_w=5).
+	registerStateRegexp = regexp.MustCompile(`\bR(\d+)(?:_[a-zA-Z]+)?=((?:invP?|scalar)\((?:[^()]|\([^()]*\))*\)|(?:invP?|P)?-?(?:0x)?[0-9a-f]+\b)`)
+	constantRegexp      = regexp.MustCompile(`^(?:invP?|P)?(-?(?:0x)?[0-9a-f]+)$`)
+	varOffRegexp        = regexp.MustCompile(`var_off=\((0x[0-9a-f]+); (0x[0-9a-f]+)\)`)
+)
+
+func parseScalarState(state string) (scalarBounds, bool) {
+	if m := constantRegexp.FindStringSubmatch(state); m != nil {
+		v, err := strconv.ParseInt(m[1], 0, 64)
+		if err != nil {
+			u, err := strconv.ParseUint(m[1], 0, 64)
+			if err != nil {
+				return scalarBounds{}, false
+			}
+			return constantScalar(u), true
+		}
+		return constantScalar(uint64(v)), true
+	}
+
+	sb := unknownScalar()
+	if m := varOffRegexp.FindStringSubmatch(state); m != nil {
+		sb.value, _ = strconv.ParseUint(m[1], 0, 64)
+		sb.mask, _ = strconv.ParseUint(m[2], 0, 64)
+	}
+	inner := varOffRegexp.ReplaceAllString(state[strings.Index(state, "(")+1:len(state)-1], "")
+	for _, field := range strings.Split(inner, ",") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "umin", "umin_value":
+			sb.umin, _ = strconv.ParseUint(value, 0, 64)
+		case "umax", "umax_value":
+			sb.umax, _ = strconv.ParseUint(value, 0, 64)
+		case "smin", "smin_value":
+			sb.smin, _ = strconv.ParseInt(value, 0, 64)
+		case "smax", "smax_value":
+			sb.smax, _ = strconv.ParseInt(value, 0, 64)
+		}
+	}
+	return sb, true
+}
+
+// parseRegisterBounds returns every scalar state of `reg` printed in the
+// verifier log `log`.
+func parseRegisterBounds(log string, reg epb.Reg) []scalarBounds {
+	result := []scalarBounds{}
+	for _, m := range registerStateRegexp.FindAllStringSubmatch(log, -1) {
+		if m[1] != strconv.Itoa(int(reg)) {
+			continue
+		}
+		if sb, ok := parseScalarState(m[2]); ok {
+			result = append(result, sb)
+		}
+	}
+	return result
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"math"
+	"testing"
+
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestParseRegisterBounds(t *testing.T) {
+	tests := []struct {
+		testName string
+		log      string
+		reg      epb.Reg
+		want     []scalarBounds
+	}{
+		{
+			testName: "New format",
+			log:      "12: (bf) r9 = r6 ; R6_w=scalar(umin=1,umax=9,var_off=(0x0; 0xf)) R9_w=scalar(smin=-2,smax=5,umax=7,var_off=(0x0; 0x7))",
+			reg:      epb.Reg_R9,
+			want: []scalarBounds{
+				{umin: 0, umax: 7, smin: -2, smax: 5, value: 0, mask: 7},
+			},
+		},
+		{
+			testName: "Old format",
+			log:      "12: R0=map_value(id=0,off=0,ks=4,vs=8,imm=0) R9_w=inv(id=0,umin_value=4,umax_value=12,var_off=(0x0; 0xf))",
+			reg:      epb.Reg_R9,
+			want: []scalarBounds{
+				{umin: 4, umax: 12, smin: math.MinInt64, smax: math.MaxInt64, value: 0, mask: 0xf},
+			},
+		},
+		{
+			testName: "Constants",
+			log:      "3: R9_w=inv5\n7: R9_w=P-1\n9: R9=4294967295",
+			reg:      epb.Reg_R9,
+			want: []scalarBounds{
+				constantScalar(5),
+				constantScalar(math.MaxUint64),
+				constantScalar(math.MaxUint32),
+			},
+		},
+		{
+			testName: "Other registers are ignored",
+			log:      "3: R1_w=inv5 R10=fp0",
+			reg:      epb.Reg_R9,
+			want:     []scalarBounds{},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			got := parseRegisterBounds(tc.log, tc.reg)
+			if len(got) != len(tc.want) {
+				t.Fatalf("parseRegisterBounds() = %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("parseRegisterBounds()[%d] = %v, want %v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestScalarBoundsContains(t *testing.T) {
+	sb := scalarBounds{umin: 2, umax: 14, smin: 2, smax: 14, value: 2, mask: 0xc}
+	tests := []struct {
+		testName string
+		v        uint64
+		want     bool
+	}{
+		{testName: "Value within bounds and var_off", v: 6, want: true},
+		{testName: "Value below umin", v: 1, want: false},
+		{testName: "Value above umax", v: 18, want: false},
+		{testName: "Value outside of var_off", v: 7, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := sb.contains(tc.v); got != tc.want {
+				t.Errorf("contains(%d) = %v, want %v", tc.v, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"fmt"
+)
+
+const (
+	// Maximum number of packet reads of a program.
+	xdpMaxReads = 8
+
+	// Offsets of data and data_end in struct xdp_md.
+	xdpDataOffset    = 0
+	xdpDataEndOffset = 4
+
+	// xdpMaxPacketOffset is the last packet offset the programs read at,
+	// the test run data and the packets of the XDP harness are longer.
+	xdpMaxPacketOffset = 56
+)
+
+// xdpActions are the actions the programs take, the ones the XDP harness
+// tells apart.
+var xdpActions = []int32{units.XdpDrop, units.XdpPass, units.XdpTx}
+
+func NewXdpStrategy() *Xdp {
+	return &Xdp{isFinished: false}
+}
+
+// Xdp is a strategy that generates BPF_PROG_TYPE_XDP programs. They fold
+// bytes of the packet, read after checking them against data_end, into R7
+// and pick their action from it, a quarter of them take the same action
+// regardless.
+//
+// The programs are meant for the XDP harness of the control unit, see
+// units.XdpHarness, which attaches them to a veth interface and tells their
+// action by where their packet shows up. Without it they are test run.
+type Xdp struct {
+	isFinished        bool
+	programCount      int
+	validProgramCount int
+
+	// State of the last generated program: the number of packet reads
+	// and, if the action does not depend on the packet, the action.
+	reads          int
+	constantAction bool
+	action         int32
+}
+
+// randomXdpAction returns one of xdpActions at random.
+func randomXdpAction() int32 {
+	return xdpActions[rand.SharedRNG.RandRange(0, uint64(len(xdpActions)-1))]
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (x *Xdp) GenerateProgram(ffi *units.FFI) (*epb.Program, error) {
+	x.programCount += 1
+	logging.Debugf("Generated %d programs, %d were valid               \r", x.programCount, x.validProgramCount)
+
+	// The action in R0 is also the one taken when the packet is too short
+	// for one of the reads.
+	x.constantAction = rand.SharedRNG.OneOf(4)
+	x.action = randomXdpAction()
+	b := NewProgramBuilder().
+		Mov64(R0, x.action).
+		LdW(R2, R1, xdpDataOffset).
+		LdW(R3, R1, xdpDataEndOffset).
+		Mov64(R7, 0)
+
+	x.reads = int(rand.SharedRNG.RandRange(1, xdpMaxReads))
+	for i := 0; i < x.reads; i++ {
+		offset := int16(rand.SharedRNG.RandRange(0, xdpMaxPacketOffset))
+		b.Mov64(R4, R2).Add64(R4, int32(offset)+8).JmpGT(R4, R3, "out")
+		switch rand.SharedRNG.RandRange(0, 3) {
+		case 0:
+			b.LdB(R5, R2, offset)
+		case 1:
+			b.LdH(R5, R2, offset)
+		case 2:
+			b.LdW(R5, R2, offset)
+		default:
+			b.LdDW(R5, R2, offset)
+		}
+		b.Xor64(R7, R5)
+	}
+
+	if !x.constantAction {
+		b.JmpGT(R7, int32(rand.SharedRNG.RandInt()), "out").Mov64(R0, randomXdpAction())
+	}
+	return b.Label("out").Exit().Build()
+}
+
+// ProgramType returns the type the programs are loaded as.
+func (x *Xdp) ProgramType() int {
+	return units.ProgTypeXdp
+}
+
+// Decisions returns the number of reads and the action of the last program
+// for the decision log.
+func (x *Xdp) Decisions() []string {
+	action := "action from the packet"
+	if x.constantAction {
+		action = fmt.Sprintf("constant action %s", units.XdpActionName(uint32(x.action)))
+	}
+	return []string{fmt.Sprintf("%d packet reads", x.reads), action}
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (x *Xdp) OnVerifyDone(ffi *units.FFI, verificationResult *fpb.ValidationResult) bool {
+	if !verificationResult.IsValid {
+		return false
+	}
+	x.validProgramCount += 1
+	return true
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+//
+// The XDP harness reports the action the packet went through, which for
+// programs with a constant action is the one they return, as is the return
+// value of a test run.
+func (x *Xdp) OnExecuteDone(ffi *units.FFI, executionResult *fpb.ExecutionResult) bool {
+	if !x.constantAction || !executionResult.GetDidSucceed() {
+		return true
+	}
+	if got := executionResult.GetRetval(); got != uint32(x.action) {
+		logging.Infof("XDP program with action %s got %s\n", units.XdpActionName(uint32(x.action)), units.XdpActionName(got))
+		return false
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (x *Xdp) OnError(e error) bool {
+	logging.Warningf("error %v\n", e)
+	return false
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (x *Xdp) IsFuzzingDone() bool {
+	return x.isFinished
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (x *Xdp) Name() string {
+	return "xdp"
+}
@@ -0,0 +1,93 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package strategies
+
+import (
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/units/units"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestXdpProgramsCheckTheirReads(t *testing.T) {
+	x := NewXdpStrategy()
+	for i := 0; i < 100; i++ {
+		prog, err := x.GenerateProgram(nil)
+		if err != nil {
+			t.Fatalf("GenerateProgram() = %v, want nil error", err)
+		}
+		if _, err := EncodeInstructions(prog); err != nil {
+			t.Fatalf("EncodeInstructions() = %v, want nil error", err)
+		}
+		if x.ProgramType() != units.ProgTypeXdp {
+			t.Fatalf("program of type %d, want XDP", x.ProgramType())
+		}
+		for index, in := range prog.Instructions {
+			if in.GetMemOpcode().GetInstructionClass() != epb.InsClass_InsClassLdx || in.SrcReg != R2 {
+				continue
+			}
+			check := prog.Instructions[index-1]
+			if check.GetJmpOpcode().GetOperationCode() != epb.JmpOperationCode_JmpJGT || check.DstReg != R4 || check.SrcReg != R3 {
+				t.Fatalf("packet read %d is not checked against data_end: %v", index, check)
+			}
+			if in.Offset > xdpMaxPacketOffset {
+				t.Fatalf("packet read %d at offset %d, want at most %d", index, in.Offset, xdpMaxPacketOffset)
+			}
+		}
+		if last := prog.Instructions[len(prog.Instructions)-1]; last.GetJmpOpcode().GetOperationCode() != epb.JmpOperationCode_JmpExit {
+			t.Fatalf("program ends with %v, want exit", last)
+		}
+	}
+}
+
+func TestXdpOnExecuteDone(t *testing.T) {
+	tests := []struct {
+		testName       string
+		constantAction bool
+		action         int32
+		execution      *fpb.ExecutionResult
+		want           bool
+	}{
+		{
+			testName:       "Action kept",
+			constantAction: true,
+			action:         units.XdpTx,
+			execution:      &fpb.ExecutionResult{DidSucceed: true, Retval: units.XdpTx},
+			want:           true,
+		},
+		{
+			testName:       "Action not kept",
+			constantAction: true,
+			action:         units.XdpDrop,
+			execution:      &fpb.ExecutionResult{DidSucceed: true, Retval: units.XdpPass},
+			want:           false,
+		},
+		{
+			testName:  "Action from the packet",
+			execution: &fpb.ExecutionResult{DidSucceed: true, Retval: units.XdpPass},
+			want:      true,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			x := &Xdp{constantAction: tc.constantAction, action: tc.action}
+			if got := x.OnExecuteDone(nil, tc.execution); got != tc.want {
+				t.Errorf("OnExecuteDone() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// ArtifactMetadata is the metadata.json of the artifacts of a finding.
+type ArtifactMetadata struct {
+	Description    string   `json:"description"`
+	Oracle         string   `json:"oracle,omitempty"`
+	Strategy       string   `json:"strategy"`
+	Worker         int      `json:"worker"`
+	Seed           int64    `json:"seed"`
+	Time           string   `json:"time"`
+	Kernel         string   `json:"kernel"`
+	Arch           string   `json:"arch"`
+	Privileges     string   `json:"privileges,omitempty"`
+	ProgramType    int      `json:"program_type"`
+	Instructions   int      `json:"instructions"`
+	Minimized      int      `json:"minimized_instructions,omitempty"`
+	RequiredGuards []int    `json:"required_guards,omitempty"`
+	SourceTags     []string `json:"source_tags,omitempty"`
+	Executed       bool     `json:"executed"`
+	Retval         uint32   `json:"retval,omitempty"`
+
+	// Files are the other files of the artifact directory.
+	Files []string `json:"files"`
+}
+
+// artifactBundle accumulates the files of the artifacts of a finding.
+type artifactBundle struct {
+	dir   string
+	files []string
+	errs  []error
+}
+
+// write writes the file `name` of the bundle.
+func (ab *artifactBundle) write(name string, content []byte) {
+	if err := os.WriteFile(filepath.Join(ab.dir, name), content, 0644); err != nil {
+		ab.errs = append(ab.errs, err)
+		return
+	}
+	ab.files = append(ab.files, name)
+}
+
+// writeProgram writes `prog` as a text proto, a disassembly, raw bytecode
+// and a C PoC, in files named after `name`.
+func (ab *artifactBundle) writeProgram(name string, prog *epb.Program, mapSizes map[int]uint64) {
+	ab.write(name+".textproto", []byte(ebpf.ToTextProto(prog)))
+	if listing, err := ebpf.Disassemble(prog); err == nil {
+		ab.write(name+".txt", []byte(listing))
+	} else {
+		ab.errs = append(ab.errs, err)
+	}
+	if words, err := ebpf.EncodeInstructions(prog); err == nil {
+		var bytecode []byte
+		for _, word := range words {
+			bytecode = binary.NativeEndian.AppendUint64(bytecode, word)
+		}
+		ab.write(name+".bin", bytecode)
+	} else {
+		ab.errs = append(ab.errs, err)
+	}
+	if source, err := ebpf.CPocSource(prog, mapSizes); err == nil {
+		ab.write(name+"_poc.c", []byte(source))
+	} else {
+		ab.errs = append(ab.errs, err)
+	}
+}
+
+// WriteArtifacts writes a new directory under `parent` with everything known
+// about `f`: its program as a text proto, disassembly, raw bytecode and C
+// PoC, the same for the minimized program, the complete verifier log, the
+// kernel log around it and `meta`. It returns the directory, which is also
+// returned along with the error if only some of the files were written.
+func WriteArtifacts(parent string, f *Finding, meta *ArtifactMetadata, mapSizes map[int]uint64, kernelLog []string) (string, error) {
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", err
+	}
+	dir, err := os.MkdirTemp(parent, time.Now().Format("20060102-150405-"))
+	if err != nil {
+		return "", err
+	}
+	ab := &artifactBundle{dir: dir}
+
+	if f.Program != nil {
+		ab.writeProgram("program", f.Program, mapSizes)
+	}
+	if f.MinimizedProgram != nil {
+		ab.writeProgram("minimized", f.MinimizedProgram, mapSizes)
+	}
+	if len(f.ClassicProgram) > 0 {
+		var listing strings.Builder
+		for i, insn := range f.ClassicProgram {
+			fmt.Fprintf(&listing, "%d: code %#02x jt %d jf %d k %#x\n", i, insn.Code, insn.Jt, insn.Jf, insn.K)
+		}
+		ab.write("classic_program.txt", []byte(listing.String()))
+	}
+
+	// The execution carries a copy of the validation log, the validation
+	// result is only left for the findings that never ran.
+	verifierLog := f.ExecutionResult.GetVerifierLog()
+	if verifierLog == "" {
+		verifierLog = f.ValidationResult.GetVerifierLog()
+	}
+	if verifierLog != "" {
+		ab.write("verifier.log", []byte(verifierLog))
+	}
+	if f.Splat != "" || len(kernelLog) > 0 {
+		var content strings.Builder
+		if f.Splat != "" {
+			fmt.Fprintf(&content, "%s\n\n", f.Splat)
+		}
+		for _, message := range kernelLog {
+			fmt.Fprintln(&content, message)
+		}
+		ab.write("kernel.log", []byte(content.String()))
+	}
+
+	meta.Files = ab.files
+	if content, err := json.MarshalIndent(meta, "", "  "); err == nil {
+		ab.write("metadata.json", append(content, '\n'))
+	} else {
+		ab.errs = append(ab.errs, err)
+	}
+	return dir, errors.Join(ab.errs...)
+}
+
+// artifactMetadata returns the metadata of the artifacts of `f`.
+func (cu *Control) artifactMetadata(f *Finding) *ArtifactMetadata {
+	meta := &ArtifactMetadata{
+		Description:    f.Description,
+		Oracle:         f.Oracle,
+		Strategy:       cu.strat.Name(),
+		Worker:         cu.Worker,
+		Seed:           rand.SharedSeed(),
+		Time:           time.Now().UTC().Format(time.RFC3339),
+		Kernel:         kernelRelease(),
+		Arch:           f.Arch,
+		Privileges:     f.Privileges,
+		ProgramType:    cu.programType(),
+		Instructions:   len(f.Program.GetInstructions()),
+		Minimized:      len(f.MinimizedProgram.GetInstructions()),
+		RequiredGuards: f.RequiredGuards,
+		Executed:       f.ExecutionResult != nil,
+		Retval:         f.ExecutionResult.GetRetval(),
+	}
+	for _, tag := range f.SourceTags {
+		meta.SourceTags = append(meta.SourceTags, tag.String())
+	}
+	return meta
+}
+
+// writeArtifacts writes the artifacts of `f` under ArtifactDir, if set, and
+// records their directory in `f`.
+func (cu *Control) writeArtifacts(f *Finding) {
+	if cu.ArtifactDir == "" {
+		return
+	}
+	var mapSizes map[int]uint64
+	if owner, ok := cu.strat.(MapOwner); ok {
+		mapSizes = owner.Maps()
+	}
+	var kernelLog []string
+	if cu.KernelLog != nil {
+		kernelLog = cu.KernelLog.Recent()
+	}
+	dir, err := WriteArtifacts(cu.ArtifactDir, f, cu.artifactMetadata(f), mapSizes, kernelLog)
+	if err != nil {
+		logging.Warningf("Artifact error: %v\n", err)
+	}
+	if dir != "" {
+		logging.Infof("Writing finding artifacts %q.\n", dir)
+		f.ArtifactDir = dir
+	}
+}
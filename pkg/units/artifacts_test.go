@@ -0,0 +1,102 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestWriteArtifacts(t *testing.T) {
+	prog, err := ebpf.InstructionSequence(ebpf.Mov64(ebpf.R0, 0), ebpf.Exit())
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &Finding{
+		Description:      "unexpected value",
+		Program:          &epb.Program{Instructions: prog},
+		ValidationResult: &fpb.ValidationResult{IsValid: true, VerifierLog: "short log"},
+		ExecutionResult:  &fpb.ExecutionResult{DidSucceed: true, VerifierLog: "complete log"},
+		Splat:            "BUG: KASAN: use-after-free",
+	}
+	meta := &ArtifactMetadata{Description: f.Description, Seed: 42}
+	parent := filepath.Join(t.TempDir(), "artifacts")
+	dir, err := WriteArtifacts(parent, f, meta, nil, []string{"before", "after"})
+	if err != nil {
+		t.Fatalf("WriteArtifacts() = %v, want nil error", err)
+	}
+	if filepath.Dir(dir) != parent {
+		t.Errorf("WriteArtifacts() wrote %q, want a directory in %q", dir, parent)
+	}
+
+	read := func(name string) string {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatalf("reading %s: %v", name, err)
+		}
+		return string(content)
+	}
+	if got := read("verifier.log"); got != "complete log" {
+		t.Errorf("verifier.log = %q, want the log of the execution", got)
+	}
+	if got := read("kernel.log"); !strings.HasPrefix(got, f.Splat) || !strings.HasSuffix(got, "before\nafter\n") {
+		t.Errorf("kernel.log = %q, want the splat and the kernel log", got)
+	}
+	words, err := ebpf.EncodeInstructions(f.Program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bytecode []byte
+	for _, word := range words {
+		bytecode = binary.NativeEndian.AppendUint64(bytecode, word)
+	}
+	if got := read("program.bin"); got != string(bytecode) {
+		t.Errorf("program.bin = %x, want the instructions in host byte order %x", got, bytecode)
+	}
+	if got, err := ebpf.FromTextProto(read("program.textproto")); err != nil || len(got.Instructions) != 2 {
+		t.Errorf("program.textproto = %v, %v, want the program", got, err)
+	}
+	if got := read("program_poc.c"); !strings.Contains(got, "main") {
+		t.Errorf("program_poc.c = %q, want a C PoC", got)
+	}
+
+	var got ArtifactMetadata
+	if err := json.Unmarshal([]byte(read("metadata.json")), &got); err != nil {
+		t.Fatalf("metadata.json: %v", err)
+	}
+	wantFiles := []string{"program.textproto", "program.txt", "program.bin", "program_poc.c", "verifier.log", "kernel.log"}
+	if got.Seed != 42 || got.Description != f.Description || !reflect.DeepEqual(got.Files, wantFiles) {
+		t.Errorf("metadata.json = %+v, want the metadata and the files %v", got, wantFiles)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != "metadata.json" && !slices.Contains(wantFiles, entry.Name()) {
+			t.Errorf("unexpected file %s", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// canBatch returns true if every program is loaded with the capabilities
+// of the fuzzer, the only privileges batches are loaded with. The other
+// privileges, picked per program, only go with the regular fuzzing loop.
+func (cu *Control) canBatch() bool {
+	if _, ok := cu.strat.(UnprivilegedStrategy); ok {
+		return false
+	}
+	for _, privileges := range cu.Privileges {
+		if privileges != LoadPrivileged {
+			return false
+		}
+	}
+	return true
+}
+
+// runBatchFuzzer is the fuzzing loop of the strategies that are batched,
+// see BatchSize. The programs of a batch reach the kernel in a single call to
+// the FFI, then the hooks of the strategy are called for each one in order.
+func (cu *Control) runBatchFuzzer() error {
+	for !cu.fuzzingDone() {
+		cu.syncSharedCorpus()
+		cu.checkpoint()
+		var progs []*epb.Program
+		batch := &fpb.BatchRequest{TestRun: cu.testRunRequest(-1)}
+		for len(progs) < cu.BatchSize && !cu.strat.IsFuzzingDone() {
+			prog, err := cu.generateProgram()
+			if err != nil {
+				logging.Warningf("Generate program error: %v\n", err)
+				if !cu.strat.OnError(err) {
+					return err
+				}
+				continue
+			}
+			cu.countProgram()
+
+			if reason := cu.filterProgram(prog); reason != nil {
+				logging.Infof("Dropping program: %v\n", reason)
+				if err := cu.countDrop(reason); err != nil {
+					return err
+				}
+				continue
+			}
+			encodedProg, err := ebpf.EncodeInstructions(prog)
+			if err != nil {
+				logging.Warningf("Encoding error: %v\n", err)
+				if !cu.strat.OnError(err) {
+					return err
+				}
+				continue
+			}
+			cu.announceProgram(prog)
+			progs = append(progs, prog)
+			batch.Programs = append(batch.Programs, &fpb.BatchProgram{
+				Instructions: encodedProg,
+				ProgType:     int32(cu.programType()),
+			})
+		}
+		if len(progs) == 0 {
+			continue
+		}
+
+		res, err := cu.ffi.RunBatch(batch)
+		if err != nil {
+			logging.Warningf("Batch error: %v\n", err)
+			if !cu.strat.OnError(err) {
+				return err
+			}
+			continue
+		}
+		// The splats are read before the hooks run, they belong to the
+		// batch rather than to the program judged first.
+		splats := cu.batchSplats()
+		for i, entry := range res.GetEntries() {
+			cu.judgeBatchEntry(progs[i], entry)
+		}
+		if len(splats) > 0 {
+			cu.attributeSplats(progs, res.GetEntries(), splats, func(i int) (*fpb.BatchEntry, []string, error) {
+				return cu.runAlone(batch, i)
+			})
+		}
+	}
+	return nil
+}
+
+// batchSplats returns the splats logged since the last check, see
+// checkKernelLog.
+func (cu *Control) batchSplats() []string {
+	if cu.KernelLog == nil {
+		return nil
+	}
+	splats, err := cu.KernelLog.Splats()
+	if err != nil {
+		logging.Warningf("Kernel log error: %v\n", err)
+	}
+	return splats
+}
+
+// runAlone runs the program `i` of `batch` again in a batch of its own and
+// returns what happened to it and the splats it logged.
+func (cu *Control) runAlone(batch *fpb.BatchRequest, i int) (*fpb.BatchEntry, []string, error) {
+	res, err := cu.ffi.RunBatch(&fpb.BatchRequest{
+		Programs: batch.Programs[i : i+1],
+		TestRun:  batch.TestRun,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(res.GetEntries()) != 1 {
+		return nil, nil, fmt.Errorf("the batch of program %d has %d entries", i, len(res.GetEntries()))
+	}
+	return res.GetEntries()[0], cu.batchSplats(), nil
+}
+
+// attributeSplats reports the `splats` logged while the batch of `progs`,
+// whose results are `entries`, ran. Nothing tells which program of the batch
+// triggered them, so unless it has a single one, the programs are run again
+// one at a time with `runAlone` and each splat they log alone is reported
+// with its program. The splats none of them logs again are reported with the
+// first program of the batch and say so.
+func (cu *Control) attributeSplats(progs []*epb.Program, entries []*fpb.BatchEntry, splats []string, runAlone func(int) (*fpb.BatchEntry, []string, error)) {
+	note := ""
+	if len(progs) > 1 {
+		attributed := false
+		for i, prog := range progs {
+			entry, logged, err := runAlone(i)
+			if err != nil {
+				logging.Warningf("Batch error: %v\n", err)
+				break
+			}
+			for _, splat := range logged {
+				cu.reportSplat(prog, entry.GetValidation(), splat, "")
+				attributed = true
+			}
+		}
+		if attributed {
+			return
+		}
+		note = fmt.Sprintf(" (in a batch of %d programs, none of them logs it alone)", len(progs))
+	}
+	var vres *fpb.ValidationResult
+	if len(entries) > 0 {
+		vres = entries[0].GetValidation()
+	}
+	for _, splat := range splats {
+		cu.reportSplat(progs[0], vres, splat, note)
+	}
+}
+
+// judgeBatchEntry passes what happened to `prog` in its batch to the
+// strategy, like the regular fuzzing loop does once the program ran. The
+// splats of the batch are checked separately, see attributeSplats.
+func (cu *Control) judgeBatchEntry(prog *epb.Program, entry *fpb.BatchEntry) {
+	vres := entry.GetValidation()
+	if IsTransientFailure(vres) {
+		// Batches are not retried, see TransientRetries.
+		logging.Infof("Dropping program: %s\n", vres.GetBpfError())
+		return
+	}
+	if vres.GetVerifierLogTruncated() {
+		logging.Infof("Dropping program: the verifier log does not fit in the biggest buffer\n")
+		return
+	}
+
+	if vres.GetIsValid() {
+		cu.stats.ValidPrograms++
+	}
+	if !cu.strat.OnVerifyDone(cu.ffi, vres) || !vres.GetIsValid() {
+		cu.recordCorpusEntry(prog, vres, nil)
+		return
+	}
+
+	exRes := entry.GetExecution()
+	cu.countExecution()
+	cu.recordCorpusEntry(prog, vres, exRes)
+	exRes.VerifierLog = vres.GetVerifierLog()
+	cu.inspectExecution(prog, vres, exRes, cu.oracles(nil))
+}
@@ -0,0 +1,176 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestJudgeBatchEntry(t *testing.T) {
+	hook := &collectingHook{}
+	cu := &Control{FindingHooks: []FindingHook{hook}}
+	cu.Init(&FFI{Maps: NewFakeMaps()}, nil, &returnStrategy{})
+	insn, err := ebpf.InstructionSequence(ebpf.Mov64(ebpf.R0, 1), ebpf.Exit())
+	if err != nil {
+		t.Fatalf("InstructionSequence() = %v", err)
+	}
+	prog := &epb.Program{Instructions: insn}
+
+	tests := []struct {
+		testName    string
+		entry       *fpb.BatchEntry
+		wantValid   int
+		wantFinding int
+	}{
+		{
+			testName:  "Rejected",
+			entry:     &fpb.BatchEntry{Validation: VerifierRejection("R0 !read_ok")},
+			wantValid: 0,
+		},
+		{
+			testName: "Transient failure",
+			entry: &fpb.BatchEntry{Validation: &fpb.ValidationResult{
+				BpfError: syscall.ENOMEM.Error(),
+				BpfErrno: int32(syscall.ENOMEM),
+			}},
+			wantValid: 0,
+		},
+		{
+			testName: "Truncated log",
+			entry: &fpb.BatchEntry{Validation: &fpb.ValidationResult{
+				BpfError:             syscall.ENOSPC.Error(),
+				BpfErrno:             int32(syscall.ENOSPC),
+				VerifierLogTruncated: true,
+			}},
+			wantValid: 0,
+		},
+		{
+			testName: "Expected result",
+			entry: &fpb.BatchEntry{
+				Validation: VerifierAcceptance(),
+				Execution:  &fpb.ExecutionResult{DidSucceed: true},
+			},
+			wantValid: 1,
+		},
+		{
+			testName: "Unexpected result",
+			entry: &fpb.BatchEntry{
+				Validation: VerifierAcceptance(),
+				Execution:  &fpb.ExecutionResult{DidSucceed: true, Retval: 1},
+			},
+			wantValid:   2,
+			wantFinding: 1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			cu.judgeBatchEntry(prog, tc.entry)
+			if got := cu.stats.ValidPrograms; got != tc.wantValid {
+				t.Errorf("valid programs = %d, want %d", got, tc.wantValid)
+			}
+			if got := len(hook.findings); got != tc.wantFinding {
+				t.Fatalf("findings = %d, want %d", got, tc.wantFinding)
+			}
+		})
+	}
+
+	finding := hook.findings[0]
+	for _, path := range finding.ReproPaths {
+		os.Remove(path)
+	}
+	if got, want := finding.ExecutionResult.GetVerifierLog(), VerifierAcceptance().VerifierLog; got != want {
+		t.Errorf("verifier log of the execution = %q, want %q", got, want)
+	}
+}
+
+func TestAttributeSplats(t *testing.T) {
+	splat := "WARNING: CPU: 0 PID: 1 at kernel/bpf/verifier.c:1 do_check+0x1/0x2"
+	progs := []*epb.Program{
+		{Instructions: []*epb.Instruction{ebpf.Mov64(ebpf.R0, 0), ebpf.Exit()}},
+		{Instructions: []*epb.Instruction{ebpf.Mov64(ebpf.R0, 1), ebpf.Exit()}},
+		{Instructions: []*epb.Instruction{ebpf.Mov64(ebpf.R0, 2), ebpf.Exit()}},
+	}
+	entries := []*fpb.BatchEntry{{Validation: VerifierAcceptance()}, {Validation: VerifierAcceptance()}, {Validation: VerifierAcceptance()}}
+
+	tests := []struct {
+		testName string
+		// culprit is the program that logs the splat alone, -1 if none.
+		culprit         int
+		wantProgram     int
+		wantDescription string
+	}{
+		{"Culprit found", 1, 1, "Kernel splat: " + splat},
+		{"No culprit", -1, 0, "Kernel splat: " + splat + " (in a batch of 3 programs, none of them logs it alone)"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			hook := &collectingHook{}
+			cu := &Control{FindingHooks: []FindingHook{hook}}
+			cu.Init(&FFI{Maps: NewFakeMaps()}, nil, &returnStrategy{})
+			var reruns []int
+			cu.attributeSplats(progs, entries, []string{splat}, func(i int) (*fpb.BatchEntry, []string, error) {
+				reruns = append(reruns, i)
+				if i == tc.culprit {
+					return entries[i], []string{splat}, nil
+				}
+				return entries[i], nil, nil
+			})
+			if len(reruns) != len(progs) {
+				t.Errorf("ran %v again, want every program", reruns)
+			}
+			if len(hook.findings) != 1 {
+				t.Fatalf("findings = %d, want 1", len(hook.findings))
+			}
+			f := hook.findings[0]
+			for _, path := range f.ReproPaths {
+				os.Remove(path)
+			}
+			if f.Program != progs[tc.wantProgram] || f.Description != tc.wantDescription {
+				t.Errorf("finding %q of program %v, want %q of program %d", f.Description, f.Program, tc.wantDescription, tc.wantProgram)
+			}
+		})
+	}
+}
+
+func TestCanBatch(t *testing.T) {
+	tests := []struct {
+		testName   string
+		strategy   Strategy
+		privileges []LoadPrivileges
+		want       bool
+	}{
+		{"Default privileges", &returnStrategy{}, nil, true},
+		{"Privileged", &returnStrategy{}, []LoadPrivileges{LoadPrivileged}, true},
+		{"Unprivileged", &returnStrategy{}, []LoadPrivileges{LoadPrivileged, LoadUnprivileged}, false},
+		{"Token", &returnStrategy{}, []LoadPrivileges{LoadWithToken}, false},
+		{"Unprivileged strategy", &unprivilegedStrategy{}, nil, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			cu := &Control{Privileges: tc.privileges}
+			cu.Init(&FFI{Maps: NewFakeMaps()}, nil, tc.strategy)
+			if got := cu.canBatch(); got != tc.want {
+				t.Errorf("canBatch() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+)
+
+const (
+	// How many of the last lines of the verifier log go in a bug report,
+	// the end of the log is where the verifier explains its decision.
+	maxReportLogLines = 30
+)
+
+// reportConfigs are the kernel config options that matter the most for eBPF
+// bugs, they are the only ones included in bug reports.
+var reportConfigs = []string{
+	"CONFIG_BPF_SYSCALL",
+	"CONFIG_BPF_JIT",
+	"CONFIG_BPF_JIT_ALWAYS_ON",
+	"CONFIG_BPF_UNPRIV_DEFAULT_OFF",
+	"CONFIG_BPF_LSM",
+	"CONFIG_DEBUG_INFO_BTF",
+	"CONFIG_KASAN",
+	"CONFIG_KCOV",
+	"CONFIG_LOCKDEP",
+	"CONFIG_PREEMPT",
+}
+
+// KernelInfo describes the kernel a finding was observed on.
+type KernelInfo struct {
+	Release string
+	Version string
+	Arch    string
+
+	// Config holds the kernel config options that were set, e.g.
+	// "CONFIG_BPF_JIT" -> "y", nil if the config is not available.
+	Config map[string]string
+}
+
+// parseKernelConfig returns the options set in a kernel .config file.
+func parseKernelConfig(r io.Reader) (map[string]string, error) {
+	config := make(map[string]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "CONFIG_") {
+			continue
+		}
+		if name, value, ok := strings.Cut(line, "="); ok {
+			config[name] = value
+		}
+	}
+	return config, scanner.Err()
+}
+
+// readKernelConfig reads the config of the running kernel from
+// /proc/config.gz, or from /boot if the kernel does not expose it.
+func readKernelConfig(release string) (map[string]string, error) {
+	if f, err := os.Open("/proc/config.gz"); err == nil {
+		defer f.Close()
+		r, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return parseKernelConfig(r)
+	}
+	f, err := os.Open(filepath.Join("/boot", "config-"+release))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseKernelConfig(f)
+}
+
+// CurrentKernelInfo returns the description of the running kernel. The
+// config might be missing if the kernel was built without IKCONFIG_PROC, in
+// which case the error explains why.
+func CurrentKernelInfo() (*KernelInfo, error) {
+	ki := &KernelInfo{
+		Release: kernelRelease(),
+		Version: "unknown",
+		Arch:    runtime.GOARCH,
+	}
+	if version, err := os.ReadFile("/proc/sys/kernel/version"); err == nil {
+		ki.Version = strings.TrimSpace(string(version))
+	}
+	config, err := readKernelConfig(ki.Release)
+	if err != nil {
+		return ki, fmt.Errorf("could not read the kernel config: %w", err)
+	}
+	ki.Config = config
+	return ki, nil
+}
+
+// verifierLogExcerpt returns the last maxReportLogLines lines of `log`.
+func verifierLogExcerpt(log string) string {
+	lines := strings.Split(strings.TrimRight(log, "\n"), "\n")
+	if len(lines) <= maxReportLogLines {
+		return strings.Join(lines, "\n")
+	}
+	omitted := len(lines) - maxReportLogLines
+	return fmt.Sprintf("[... %d lines omitted ...]\n%s", omitted, strings.Join(lines[omitted:], "\n"))
+}
+
+// writeReportSection writes a section of a bug report, `body` is indented
+// so it survives mail clients and Bugzilla alike.
+func writeReportSection(b *strings.Builder, title, body string) {
+	fmt.Fprintf(b, "%s:\n\n", title)
+	for _, line := range strings.Split(strings.TrimRight(body, "\n"), "\n") {
+		if line == "" {
+			b.WriteString("\n")
+			continue
+		}
+		fmt.Fprintf(b, "    %s\n", line)
+	}
+	b.WriteString("\n")
+}
+
+// FormatBugReport turns `f` into the body of a bug report ready to be sent to
+// the bpf mailing list or filed in the kernel Bugzilla. It includes the
+// kernel version and config highlights from `kernel`, the disassembly of the
+// program, an excerpt of the verifier log and the contents of the C
+// reproducers written for the finding, which must exist.
+func FormatBugReport(f *Finding, kernel *KernelInfo) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Subject: [BUG] bpf: %s\n\n", f.Description)
+	b.WriteString("Hello,\n\nThe following eBPF program triggers unexpected behavior, it was found by the buzzer fuzzer.\n\n")
+	writeReportSection(&b, "Description", f.Description)
+
+	if kernel != nil {
+		env := fmt.Sprintf("Release: %s\nVersion: %s\nArchitecture: %s", kernel.Release, kernel.Version, kernel.Arch)
+		writeReportSection(&b, "Kernel", env)
+		if kernel.Config != nil {
+			var configs []string
+			for _, name := range reportConfigs {
+				value, ok := kernel.Config[name]
+				if !ok {
+					value = "is not set"
+				}
+				configs = append(configs, fmt.Sprintf("%s=%s", name, value))
+			}
+			writeReportSection(&b, "Config highlights", strings.Join(configs, "\n"))
+		}
+	}
+
+	if len(f.SourceTags) > 0 {
+		var tags []string
+		for _, tag := range f.SourceTags {
+			tags = append(tags, tag.String())
+		}
+		writeReportSection(&b, "Candidate source locations", strings.Join(tags, "\n"))
+	}
+
+	if len(f.ClassicProgram) > 0 {
+		var listing []string
+		for i, insn := range f.ClassicProgram {
+			listing = append(listing, fmt.Sprintf("%d: code %#02x jt %d jf %d k %#x", i, insn.Code, insn.Jt, insn.Jf, insn.K))
+		}
+		writeReportSection(&b, "Classic BPF program", strings.Join(listing, "\n"))
+	}
+	program := f.Program
+	title := "Program"
+	if f.MinimizedProgram != nil {
+		program = f.MinimizedProgram
+		title = "Minimized program"
+	}
+	if program != nil {
+		listing, err := ebpf.Disassemble(program)
+		if err != nil {
+			listing = fmt.Sprintf("could not disassemble the program: %v", err)
+		}
+		writeReportSection(&b, title, listing)
+	}
+	if len(f.ValueTrace) > 0 {
+		var values []string
+		for _, value := range f.ValueTrace {
+			values = append(values, value.String())
+		}
+		writeReportSection(&b, "Runtime register values", strings.Join(values, "\n"))
+	}
+
+	if log := f.ValidationResult.GetVerifierLog(); log != "" {
+		writeReportSection(&b, "Verifier log excerpt", verifierLogExcerpt(log))
+	}
+
+	for _, path := range f.ReproPaths {
+		if filepath.Ext(path) != ".c" {
+			continue
+		}
+		source, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		writeReportSection(&b, fmt.Sprintf("C reproducer (%s)", filepath.Base(path)), string(source))
+	}
+	return b.String(), nil
+}
+
+// BugReportHook is a FindingHook that writes a bug report for every finding,
+// see FormatBugReport. The path of the report is added to the reproducer
+// paths so hooks that run after it also get it.
+type BugReportHook struct {
+	Kernel *KernelInfo
+}
+
+// OnFinding writes the bug report of `f` to a temporary file.
+func (bh *BugReportHook) OnFinding(f *Finding) error {
+	report, err := FormatBugReport(f, bh.Kernel)
+	if err != nil {
+		return err
+	}
+	file, err := os.CreateTemp("", "buzzer-report-*.txt")
+	if err != nil {
+		return err
+	}
+	logging.Infof("Writing bug report %q.\n", file.Name())
+	if _, err := file.WriteString(report); err != nil {
+		file.Close()
+		return err
+	}
+	f.ReproPaths = append(f.ReproPaths, file.Name())
+	return file.Close()
+}
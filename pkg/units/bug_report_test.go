@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestParseKernelConfig(t *testing.T) {
+	config, err := parseKernelConfig(strings.NewReader("#\n# General setup\n#\nCONFIG_BPF_JIT=y\n# CONFIG_KASAN is not set\nCONFIG_LOG_BUF_SHIFT=17\n"))
+	if err != nil {
+		t.Fatalf("parseKernelConfig() = %v, want nil error", err)
+	}
+	want := map[string]string{"CONFIG_BPF_JIT": "y", "CONFIG_LOG_BUF_SHIFT": "17"}
+	if len(config) != len(want) {
+		t.Errorf("parseKernelConfig() = %v, want %v", config, want)
+	}
+	for name, value := range want {
+		if config[name] != value {
+			t.Errorf("parseKernelConfig()[%s] = %q, want %q", name, config[name], value)
+		}
+	}
+}
+
+func TestVerifierLogExcerpt(t *testing.T) {
+	var lines []string
+	for i := 0; i < maxReportLogLines+5; i++ {
+		lines = append(lines, fmt.Sprintf("%d: (b7) r0 = 0", i))
+	}
+	got := verifierLogExcerpt(strings.Join(lines, "\n") + "\n")
+	if !strings.HasPrefix(got, "[... 5 lines omitted ...]\n5: (b7) r0 = 0\n") {
+		t.Errorf("verifierLogExcerpt() does not start at line 5:\n%s", got)
+	}
+	if !strings.HasSuffix(got, fmt.Sprintf("%d: (b7) r0 = 0", maxReportLogLines+4)) {
+		t.Errorf("verifierLogExcerpt() does not end with the last line:\n%s", got)
+	}
+}
+
+func TestFormatBugReport(t *testing.T) {
+	pocPath := filepath.Join(t.TempDir(), "ebpf-poc-1.c")
+	if err := os.WriteFile(pocPath, []byte("int main() {\n  return 0;\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := &Finding{
+		Description:      "Program produced unexpected results",
+		Program:          &epb.Program{Instructions: []*epb.Instruction{ebpf.Mov64(ebpf.R0, 0), ebpf.Mov64(ebpf.R1, 1), ebpf.Exit()}},
+		MinimizedProgram: &epb.Program{Instructions: []*epb.Instruction{ebpf.Mov64(ebpf.R0, 0), ebpf.Exit()}},
+		ValidationResult: &fpb.ValidationResult{VerifierLog: "0: (b7) r0 = 0\n1: (95) exit\nprocessed 2 insns\n"},
+		ValueTrace:       []TracedValue{{ValueTracePoint: ebpf.ValueTracePoint{Index: 0, Reg: ebpf.R0}}},
+		ReproPaths:       []string{"/tmp/ebpf-poc-1.json", pocPath},
+	}
+	kernel := &KernelInfo{
+		Release: "6.6.0",
+		Version: "#1 SMP",
+		Arch:    "amd64",
+		Config:  map[string]string{"CONFIG_BPF_JIT": "y"},
+	}
+
+	report, err := FormatBugReport(f, kernel)
+	if err != nil {
+		t.Fatalf("FormatBugReport() = %v, want nil error", err)
+	}
+	for _, want := range []string{
+		"Subject: [BUG] bpf: Program produced unexpected results\n",
+		"    Release: 6.6.0\n",
+		"    CONFIG_BPF_JIT=y\n",
+		"    CONFIG_KASAN=is not set\n",
+		"Minimized program:\n",
+		"Runtime register values:\n\n    instruction 0: r0 = 0x0\n",
+		"    processed 2 insns\n",
+		"C reproducer (ebpf-poc-1.c):\n\n    int main() {\n      return 0;\n    }\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("FormatBugReport() does not contain %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "r1 = 1") {
+		t.Errorf("FormatBugReport() contains the original program instead of the minimized one:\n%s", report)
+	}
+}
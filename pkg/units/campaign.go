@@ -0,0 +1,117 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"buzzer/pkg/logging/logging"
+)
+
+// CampaignPhase is a part of a campaign during which a single strategy
+// generates the programs.
+type CampaignPhase struct {
+	Strategy Strategy
+
+	// Duration is how long the phase lasts at most, it ends earlier if the
+	// strategy is done fuzzing.
+	Duration time.Duration
+}
+
+// PhaseStats are the statistics of a campaign phase.
+type PhaseStats struct {
+	Strategy      string
+	Elapsed       time.Duration
+	Programs      int
+	ValidPrograms int
+	Executions    int
+	Findings      int
+}
+
+func (ps PhaseStats) String() string {
+	return fmt.Sprintf("%s ran for %v: %d programs, %d valid, %d executions, %d findings", ps.Strategy, ps.Elapsed.Round(time.Second), ps.Programs, ps.ValidPrograms, ps.Executions, ps.Findings)
+}
+
+// ParseCampaignPhases parses phases written as comma separated
+// strategy:duration pairs, e.g. "playground:1h,coverage_based:4h", picking
+// the strategies among `strats` by name.
+func ParseCampaignPhases(spec string, strats []Strategy) ([]CampaignPhase, error) {
+	byName := make(map[string]Strategy)
+	for _, s := range strats {
+		byName[s.Name()] = s
+	}
+
+	var phases []CampaignPhase
+	for _, phase := range strings.Split(spec, ",") {
+		name, duration, found := strings.Cut(phase, ":")
+		if !found {
+			return nil, fmt.Errorf("phase %q is not a strategy:duration pair", phase)
+		}
+		s, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown strategy %q", name)
+		}
+		d, err := time.ParseDuration(duration)
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("phase %q does not last", phase)
+		}
+		phases = append(phases, CampaignPhase{Strategy: s, Duration: d})
+	}
+	return phases, nil
+}
+
+// fuzzingDone returns true once the strategy is done fuzzing, the current
+// campaign phase is over or a run limit is reached.
+func (cu *Control) fuzzingDone() bool {
+	if !cu.phaseDeadline.IsZero() && time.Now().After(cu.phaseDeadline) {
+		return true
+	}
+	if cu.Limits.Reached() {
+		return true
+	}
+	return cu.strat.IsFuzzingDone()
+}
+
+// RunCampaign runs the fuzzer with the strategy of every phase, one after the
+// other, and returns the statistics of the phases that ran.
+func (cu *Control) RunCampaign(phases []CampaignPhase) ([]PhaseStats, error) {
+	defer func() { cu.phaseDeadline = time.Time{} }()
+
+	var stats []PhaseStats
+	for i, phase := range phases {
+		logging.Infof("Starting campaign phase %d/%d: %s for %v\n", i+1, len(phases), phase.Strategy.Name(), phase.Duration)
+		cu.strat = phase.Strategy
+		cu.stats = PhaseStats{Strategy: phase.Strategy.Name()}
+		start := time.Now()
+		cu.phaseDeadline = start.Add(phase.Duration)
+
+		err := cu.RunFuzzer()
+		cu.stats.Elapsed = time.Since(start)
+		stats = append(stats, cu.stats)
+		logging.Infof("Campaign phase %d/%d done, %v\n", i+1, len(phases), cu.stats)
+		if err != nil {
+			return stats, err
+		}
+		if cu.Limits.Reached() {
+			break
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// generationFailure is returned by every GenerateProgram call of
+// idleStrategy.
+var generationFailure = errors.New("no program")
+
+// idleStrategy never generates a program, it is done after `programs`
+// attempts. Each attempt takes a millisecond.
+type idleStrategy struct {
+	name     string
+	programs int
+	attempts int
+}
+
+func (s *idleStrategy) GenerateProgram(ffi *FFI) (*epb.Program, error) {
+	s.attempts++
+	time.Sleep(time.Millisecond)
+	return nil, generationFailure
+}
+
+func (s *idleStrategy) OnVerifyDone(ffi *FFI, verificationResult *fpb.ValidationResult) bool {
+	return false
+}
+
+func (s *idleStrategy) OnExecuteDone(ffi *FFI, executionResult *fpb.ExecutionResult) bool {
+	return true
+}
+
+func (s *idleStrategy) OnError(e error) bool {
+	return true
+}
+
+func (s *idleStrategy) IsFuzzingDone() bool {
+	return s.attempts >= s.programs
+}
+
+func (s *idleStrategy) Name() string {
+	return s.name
+}
+
+func TestParseCampaignPhases(t *testing.T) {
+	generation := &idleStrategy{name: "generation"}
+	mutation := &idleStrategy{name: "mutation"}
+	strats := []Strategy{generation, mutation}
+
+	for _, c := range []struct {
+		testName string
+		spec     string
+		want     []CampaignPhase
+		wantErr  bool
+	}{
+		{
+			testName: "Curriculum",
+			spec:     "generation:1h,mutation:4h,generation:30m",
+			want: []CampaignPhase{
+				{Strategy: generation, Duration: time.Hour},
+				{Strategy: mutation, Duration: 4 * time.Hour},
+				{Strategy: generation, Duration: 30 * time.Minute},
+			},
+		},
+		{
+			testName: "Unknown strategy",
+			spec:     "chaos:1h",
+			wantErr:  true,
+		},
+		{
+			testName: "Missing duration",
+			spec:     "generation",
+			wantErr:  true,
+		},
+		{
+			testName: "Invalid duration",
+			spec:     "generation:forever",
+			wantErr:  true,
+		},
+		{
+			testName: "Empty phase",
+			spec:     "generation:0s",
+			wantErr:  true,
+		},
+	} {
+		t.Run(c.testName, func(t *testing.T) {
+			got, err := ParseCampaignPhases(c.spec, strats)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("ParseCampaignPhases(%q) error = %v, want error %v", c.spec, err, c.wantErr)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("ParseCampaignPhases(%q) = %v, want %v", c.spec, got, c.want)
+			}
+			for i := range got {
+				if got[i] != c.want[i] {
+					t.Errorf("phase %d = %v, want %v", i, got[i], c.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRunCampaign(t *testing.T) {
+	finite := &idleStrategy{name: "finite", programs: 3}
+	endless := &idleStrategy{name: "endless", programs: math.MaxInt}
+	cu := &Control{}
+	if err := cu.Init(&FFI{}, nil, finite); err != nil {
+		t.Fatalf("Init() = %v", err)
+	}
+
+	stats, err := cu.RunCampaign([]CampaignPhase{
+		{Strategy: finite, Duration: time.Hour},
+		{Strategy: endless, Duration: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("RunCampaign() = %v", err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("RunCampaign() returned %d phase statistics, want 2", len(stats))
+	}
+	if stats[0].Strategy != "finite" || stats[1].Strategy != "endless" {
+		t.Errorf("phases ran in order %s, %s, want finite, endless", stats[0].Strategy, stats[1].Strategy)
+	}
+	if finite.attempts != 3 {
+		t.Errorf("finite strategy generated %d programs, want 3", finite.attempts)
+	}
+	if endless.attempts == 0 || stats[1].Elapsed < 10*time.Millisecond {
+		t.Errorf("endless phase ran for %v with %d programs, want at least 10ms", stats[1].Elapsed, endless.attempts)
+	}
+	if !cu.phaseDeadline.IsZero() {
+		t.Errorf("phase deadline %v still set after the campaign", cu.phaseDeadline)
+	}
+}
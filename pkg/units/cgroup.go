@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"os"
+
+	"buzzer/pkg/rand"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Attach types of enum bpf_attach_type the CgroupHarness uses.
+	CgroupInetIngress    = 0
+	CgroupInetEgress     = 1
+	CgroupInetSockCreate = 2
+
+	// Values of retval of the executions on the CgroupHarness.
+	CgroupBlocked = 0
+	CgroupPassed  = 1
+
+	// DefaultCgroupCycles is how many times the programs are attached,
+	// replaced and detached by default.
+	DefaultCgroupCycles = 3
+)
+
+// cgroupAttachTypes are the attach types of the program types the
+// CgroupHarness executes, one is picked at random for every execution.
+var cgroupAttachTypes = map[int][]int32{
+	ProgTypeCgroupSkb:  {CgroupInetIngress, CgroupInetEgress},
+	ProgTypeCgroupSock: {CgroupInetSockCreate},
+}
+
+// CgroupHarness executes BPF_PROG_TYPE_CGROUP_SKB and CGROUP_SOCK programs
+// in a cgroup v2, see setup.CreateCgroup. Every execution attaches the
+// program behind a filler that lets everything through, has a process in the
+// cgroup send the test run data of the control unit to itself over UDP,
+// replaces the program with another filler and back with BPF_F_REPLACE,
+// sends the data again and detaches the program, for Cycles cycles. These
+// cycles rebuild the effective program arrays of the cgroup every time. The
+// execution result has CgroupPassed in retval if the last packet got through
+// and CgroupBlocked if the program refused it or the socket.
+type CgroupHarness struct {
+	// Path is the directory of the cgroup.
+	Path string
+
+	// Cycles is the number of attach, replace and detach cycles of every
+	// execution.
+	Cycles int
+}
+
+// NewCgroupHarness returns a CgroupHarness that attaches the programs to the
+// cgroup at `path` and goes through `cycles` cycles on every execution.
+func NewCgroupHarness(path string, cycles int) (*CgroupHarness, error) {
+	if cycles < 1 {
+		return nil, fmt.Errorf("cgroup harness needs at least one cycle, got %d", cycles)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a cgroup directory", path)
+	}
+	return &CgroupHarness{Path: path, Cycles: cycles}, nil
+}
+
+// IsCgroupProgramType returns true if the CgroupHarness executes programs of
+// type `progType`.
+func IsCgroupProgramType(progType int) bool {
+	_, ok := cgroupAttachTypes[progType]
+	return ok
+}
+
+// request returns the request that runs the program `progFd`, of type
+// `progType`, on `packet`.
+func (ch *CgroupHarness) request(progFd int64, progType int, packet []byte) *fpb.CgroupRequest {
+	attachTypes := cgroupAttachTypes[progType]
+	return &fpb.CgroupRequest{
+		ProgFd:     progFd,
+		ProgType:   int32(progType),
+		CgroupPath: ch.Path,
+		AttachType: attachTypes[rand.SharedRNG.RandRange(0, uint64(len(attachTypes)-1))],
+		Packet:     packet,
+		Cycles:     uint32(ch.Cycles),
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewCgroupHarness(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "cgroup.procs")
+	if err := os.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCgroupHarness(filepath.Join(dir, "missing"), 1); err == nil {
+		t.Errorf("NewCgroupHarness() on a missing cgroup did not return an error")
+	}
+	if _, err := NewCgroupHarness(file, 1); err == nil {
+		t.Errorf("NewCgroupHarness() on a file did not return an error")
+	}
+	if _, err := NewCgroupHarness(dir, 0); err == nil {
+		t.Errorf("NewCgroupHarness() without cycles did not return an error")
+	}
+	ch, err := NewCgroupHarness(dir, 2)
+	if err != nil || ch.Path != dir || ch.Cycles != 2 {
+		t.Errorf("NewCgroupHarness(%s, 2) = %v, %v", dir, ch, err)
+	}
+}
+
+func TestCgroupHarnessRequest(t *testing.T) {
+	ch := &CgroupHarness{Path: "/sys/fs/cgroup/buzzer", Cycles: 4}
+	for i := 0; i < 20; i++ {
+		req := ch.request(7, ProgTypeCgroupSkb, []byte{1, 2, 3})
+		if req.GetProgFd() != 7 || req.GetProgType() != ProgTypeCgroupSkb || req.GetCgroupPath() != ch.Path || req.GetCycles() != 4 {
+			t.Fatalf("request(7) = %v, want program 7 attached to %s for 4 cycles", req, ch.Path)
+		}
+		if at := req.GetAttachType(); at != CgroupInetIngress && at != CgroupInetEgress {
+			t.Errorf("CGROUP_SKB program attached with %d, want ingress or egress", at)
+		}
+		if got := len(req.GetPacket()); got != 3 {
+			t.Errorf("request(7) sends %d bytes, want 3", got)
+		}
+	}
+	if at := ch.request(7, ProgTypeCgroupSock, nil).GetAttachType(); at != CgroupInetSockCreate {
+		t.Errorf("CGROUP_SOCK program attached with %d, want sock create", at)
+	}
+}
+
+func TestIsCgroupProgramType(t *testing.T) {
+	for progType, want := range map[int]bool{
+		ProgTypeCgroupSkb:    true,
+		ProgTypeCgroupSock:   true,
+		ProgTypeSchedCls:     false,
+		ProgTypeSocketFilter: false,
+	} {
+		if got := IsCgroupProgramType(progType); got != want {
+			t.Errorf("IsCgroupProgramType(%d) = %v, want %v", progType, got, want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	cpb "buzzer/proto/corpus_go_proto"
+	epb "buzzer/proto/ebpf_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+// PopulationStrategy can optionally be implemented by CorpusSharer
+// strategies, so their population is saved in the checkpoints and given back
+// to them with AddSeeds when the campaign resumes.
+type PopulationStrategy interface {
+	CorpusSharer
+
+	// Population returns the programs of the population.
+	Population() []*epb.Program
+}
+
+// Checkpointer periodically saves the state of a campaign: the random number
+// generator, the coverage, and the statistics and population of every
+// worker. A campaign interrupted, e.g. by a reboot of the host, resumes from
+// the last checkpoint, see LoadCheckpoint and Control.Resume. It can be
+// shared by several workers.
+type Checkpointer struct {
+	// Path is the file the checkpoints are written to, each one replaces
+	// the previous one.
+	Path string
+
+	// Interval is how often every worker updates its part of the
+	// checkpoint.
+	Interval time.Duration
+
+	// Coverage, if set, has the coverage saved in the checkpoints.
+	Coverage *CoverageManager
+
+	mu      sync.Mutex
+	workers map[int64]*cpb.WorkerCheckpoint
+	updated map[int64]time.Time
+}
+
+// NewCheckpointer returns a Checkpointer that writes to `path` every
+// `interval`.
+func NewCheckpointer(path string, interval time.Duration, coverage *CoverageManager) *Checkpointer {
+	return &Checkpointer{
+		Path:     path,
+		Interval: interval,
+		Coverage: coverage,
+		workers:  make(map[int64]*cpb.WorkerCheckpoint),
+		updated:  make(map[int64]time.Time),
+	}
+}
+
+// due returns true if `worker` last updated its part more than Interval ago.
+// The first call starts the clock.
+func (c *Checkpointer) due(worker int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	last, ok := c.updated[worker]
+	if !ok {
+		c.updated[worker] = time.Now()
+		return false
+	}
+	return time.Since(last) >= c.Interval
+}
+
+// Update replaces the part of the worker of `w` and writes the checkpoint.
+func (c *Checkpointer) Update(w *cpb.WorkerCheckpoint) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.workers[w.GetWorker()] = w
+	c.updated[w.GetWorker()] = time.Now()
+
+	seed, draws := rand.SharedState()
+	checkpoint := &cpb.Checkpoint{
+		TimeUnixNano: time.Now().UnixNano(),
+		Seed:         seed,
+		RngDraws:     draws,
+	}
+	if c.Coverage != nil {
+		checkpoint.CoverageAddresses = c.Coverage.GetCoverageAddresses()
+		sort.Slice(checkpoint.CoverageAddresses, func(i, j int) bool {
+			return checkpoint.CoverageAddresses[i] < checkpoint.CoverageAddresses[j]
+		})
+	}
+	for _, worker := range c.workers {
+		checkpoint.Workers = append(checkpoint.Workers, worker)
+	}
+	sort.Slice(checkpoint.Workers, func(i, j int) bool {
+		return checkpoint.Workers[i].GetWorker() < checkpoint.Workers[j].GetWorker()
+	})
+	return writeCheckpoint(c.Path, checkpoint)
+}
+
+// writeCheckpoint writes `checkpoint` to a temporary file renamed to `path`,
+// a crash while writing leaves the previous checkpoint intact.
+func writeCheckpoint(path string, checkpoint *cpb.Checkpoint) error {
+	data, err := proto.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadCheckpoint reads the checkpoint written to `path` by a Checkpointer.
+func LoadCheckpoint(path string) (*cpb.Checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &cpb.Checkpoint{}
+	if err := proto.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return checkpoint, nil
+}
+
+// RestoreCoverage adds the coverage saved in `checkpoint` to `coverage`. The
+// random number generator is restored with rand.RestoreSharedState.
+func RestoreCoverage(checkpoint *cpb.Checkpoint, coverage *CoverageManager) error {
+	if len(checkpoint.GetCoverageAddresses()) == 0 {
+		return nil
+	}
+	_, err := coverage.ProcessCoverageAddresses(checkpoint.GetCoverageAddresses())
+	return err
+}
+
+// checkpoint updates the part of the control unit in Checkpoints, if it is
+// due.
+func (cu *Control) checkpoint() {
+	if cu.Checkpoints == nil || !cu.Checkpoints.due(int64(cu.Worker)) {
+		return
+	}
+	w := &cpb.WorkerCheckpoint{
+		Worker:        int64(cu.Worker),
+		Strategy:      cu.strat.Name(),
+		Programs:      int64(cu.stats.Programs),
+		ValidPrograms: int64(cu.stats.ValidPrograms),
+		Executions:    int64(cu.stats.Executions),
+		Findings:      int64(cu.stats.Findings),
+	}
+	if ps, ok := cu.strat.(PopulationStrategy); ok {
+		w.Population = ps.Population()
+	}
+	if err := cu.Checkpoints.Update(w); err != nil {
+		logging.Warningf("Checkpoint error: %v\n", err)
+	}
+}
+
+// Resume restores the statistics of the control unit and the population of
+// its strategy from its part of `checkpoint`. The population is only
+// restored if the strategy is the same.
+func (cu *Control) Resume(checkpoint *cpb.Checkpoint) {
+	for _, w := range checkpoint.GetWorkers() {
+		if w.GetWorker() != int64(cu.Worker) {
+			continue
+		}
+		cu.stats.Programs = int(w.GetPrograms())
+		cu.stats.ValidPrograms = int(w.GetValidPrograms())
+		cu.stats.Executions = int(w.GetExecutions())
+		cu.stats.Findings = int(w.GetFindings())
+		if ps, ok := cu.strat.(PopulationStrategy); ok && w.GetStrategy() == cu.strat.Name() {
+			ps.AddSeeds(w.GetPopulation()...)
+		}
+		// Keep the statistics of the previous run in the next
+		// checkpoint even if this one ends before it is due.
+		if cu.Checkpoints != nil {
+			cu.Checkpoints.mu.Lock()
+			cu.Checkpoints.workers[w.GetWorker()] = w
+			cu.Checkpoints.mu.Unlock()
+		}
+		logging.Infof("Resumed worker %d: %v\n", cu.Worker, cu.stats)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"path/filepath"
+	"testing"
+
+	"buzzer/pkg/rand"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// populationStrategy is a sharingStrategy whose population is its seeds.
+type populationStrategy struct {
+	sharingStrategy
+}
+
+func (s *populationStrategy) Population() []*epb.Program {
+	return s.seeds
+}
+
+func TestCheckpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoint")
+	checkpoints := NewCheckpointer(path, 0, nil)
+	strat := &populationStrategy{}
+	strat.name = "population"
+	strat.seeds = []*epb.Program{{}, {}}
+	cu := &Control{Checkpoints: checkpoints, Worker: 1}
+	cu.Init(&FFI{}, nil, strat)
+	cu.stats = PhaseStats{Programs: 10, ValidPrograms: 4, Executions: 3, Findings: 1}
+
+	rand.SetSharedSeed(42)
+	for i := 0; i < 100; i++ {
+		rand.SharedRNG.RandInt()
+	}
+	// The first call only starts the clock.
+	cu.checkpoint()
+	cu.checkpoint()
+	var want []uint64
+	for i := 0; i < 10; i++ {
+		want = append(want, rand.SharedRNG.RandInt())
+	}
+
+	checkpoint, err := LoadCheckpoint(path)
+	if err != nil {
+		t.Fatalf("LoadCheckpoint() = %v", err)
+	}
+	rand.RestoreSharedState(checkpoint.GetSeed(), checkpoint.GetRngDraws())
+	for i, w := range want {
+		if got := rand.SharedRNG.RandInt(); got != w {
+			t.Errorf("random number %d after restoring = %d, want %d", i, got, w)
+		}
+	}
+
+	restoredStrat := &populationStrategy{}
+	restoredStrat.name = "population"
+	restored := &Control{Worker: 1}
+	restored.Init(&FFI{}, nil, restoredStrat)
+	restored.Resume(checkpoint)
+	if restored.stats != cu.stats {
+		t.Errorf("restored stats = %v, want %v", restored.stats, cu.stats)
+	}
+	if got := len(restoredStrat.seeds); got != 2 {
+		t.Errorf("restored population has %d programs, want 2", got)
+	}
+
+	// The population of another strategy is not restored.
+	otherStrat := &populationStrategy{}
+	otherStrat.name = "other"
+	other := &Control{Worker: 1}
+	other.Init(&FFI{}, nil, otherStrat)
+	other.Resume(checkpoint)
+	if got := len(otherStrat.seeds); got != 0 {
+		t.Errorf("population of another strategy has %d programs, want 0", got)
+	}
+}
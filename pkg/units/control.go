@@ -18,8 +18,12 @@ package units
 import (
 	"errors"
 	"fmt"
+	"time"
 
+	"buzzer/pkg/btf/btf"
+	"buzzer/pkg/corpus/corpus"
 	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
 	epb "buzzer/proto/ebpf_go_proto"
 	fpb "buzzer/proto/ffi_go_proto"
 )
@@ -56,12 +60,280 @@ type Strategy interface {
 	Name() string
 }
 
+// BTFStrategy can optionally be implemented by strategies that load their
+// programs with BTF, see FFI.ValidateProgramWithBTF.
+type BTFStrategy interface {
+	// ProgramBTF returns the BTF of the last generated program, nil to
+	// load it without.
+	ProgramBTF() *btf.Program
+}
+
+// ProgramTypeStrategy can optionally be implemented by strategies whose
+// programs are not socket filters, e.g. because they call helpers socket
+// filters can't. Such programs are always executed with BPF_PROG_TEST_RUN.
+type ProgramTypeStrategy interface {
+	// ProgramType returns the BPF_PROG_TYPE_* the programs are loaded as,
+	// see ProgTypeSocketFilter.
+	ProgramType() int
+}
+
+// SleepableStrategy can optionally be implemented by strategies whose
+// programs can be loaded with BPF_F_SLEEPABLE, which lets them call the
+// helpers that may sleep, e.g. copy_from_user, and makes the verifier
+// enforce the rules of sleepable programs instead, e.g. on the maps and locks
+// they use. Only some program types can be sleepable, e.g.
+// BPF_PROG_TYPE_SYSCALL.
+type SleepableStrategy interface {
+	ProgramTypeStrategy
+
+	// Sleepable returns true if the last generated program must be loaded
+	// sleepable.
+	Sleepable() bool
+}
+
+// ContextStrategy can optionally be implemented by strategies whose programs
+// must be test run with a specific context, e.g. one holding the addresses
+// they read.
+type ContextStrategy interface {
+	// TestRunContext returns the context the last generated program is
+	// test run with.
+	TestRunContext() []byte
+}
+
+// TracepointStrategy can optionally be implemented by strategies whose
+// programs are BPF_PROG_TYPE_TRACEPOINT, which BPF_PROG_TEST_RUN does not
+// support. They are executed by attaching them to a tracepoint of the
+// fuzzer thread and calling getppid instead.
+type TracepointStrategy interface {
+	ProgramTypeStrategy
+
+	// Tracepoint returns the tracepoint the programs are attached to, e.g.
+	// syscalls/sys_enter_getppid, getppid must fire it.
+	Tracepoint() string
+}
+
+// BatchStrategy can optionally be implemented by strategies whose programs
+// can be loaded and executed in batches, see Control.BatchSize. Generating a
+// program must not close the maps of the previous ones, and the hooks of a
+// program are only called once the whole batch ran.
+type BatchStrategy interface {
+	// Batchable returns true if the programs of the strategy can be
+	// batched with its current configuration.
+	Batchable() bool
+}
+
 // Control directs the execution of the fuzzer.
 type Control struct {
-	strat Strategy
-	ffi   *FFI
-	cm    *CoverageManager
-	rdy   bool
+	// VerifierReloadCount is how many additional times every accepted
+	// program is loaded to check that the verifier always reaches the same
+	// conclusion about it. 0 disables the check.
+	VerifierReloadCount int
+
+	// LogLevelDifferential loads every program again at other verifier log
+	// levels, with and without statistics, and reports the programs whose
+	// verdict, xlated instructions or log summary change.
+	LogLevelDifferential bool
+
+	// ConcurrentExecutions is the number of threads that execute every
+	// accepted program at the same time before its regular execution. Values
+	// lower than 2 disable the concurrent stress run.
+	ConcurrentExecutions int
+
+	// MinimizeFindings makes the fuzzer shrink the programs that produce
+	// unexpected results and write a second, minimized, PoC for them.
+	MinimizeFindings bool
+
+	// ELFRepros also writes the programs of the findings as object files
+	// that bpftool and libbpf can load, see EncodeELF.
+	ELFRepros bool
+
+	// SyzRepros also writes the programs of the findings as syzkaller
+	// programs, see EncodeSyz.
+	SyzRepros bool
+
+	// ArtifactDir, if not empty, is where a directory with everything known
+	// about every finding is written, see WriteArtifacts.
+	ArtifactDir string
+
+	// ReduceGuards makes the fuzzer re-submit every accepted program with
+	// each of its guards removed, reporting the acceptances that should not
+	// happen, and strip the guards the verifier did not require from the
+	// reproducers of the findings.
+	ReduceGuards bool
+
+	// ReferenceLeaks reports the accepted programs that obviously leak a
+	// reference acquired from a helper, see ebpf.ObviousLeaks.
+	ReferenceLeaks bool
+
+	// FindingHooks are invoked, in order, for every finding.
+	FindingHooks []FindingHook
+
+	// Corpus, if not nil, receives every generated program along with its
+	// verdict and execution result.
+	Corpus *corpus.Writer
+
+	// DecisionLog, if not nil, receives every generated program along with
+	// its results and the decisions of the strategy that led to it.
+	DecisionLog *DecisionLog
+
+	// TransientRetries is how many times a program that fails to load
+	// because of a transient bpf() failure, e.g. EAGAIN or ENOMEM, is loaded
+	// again before it is dropped.
+	TransientRetries int
+
+	// TransientBackoff is the wait before the first retry of a transient
+	// failure, it doubles with every subsequent retry.
+	TransientBackoff time.Duration
+
+	// FixedMemoryLimits keeps the memory limits the fuzzer was started
+	// with, RLIMIT_MEMLOCK is not raised when loads fail for lack of memory.
+	FixedMemoryLimits bool
+
+	// NegativeSuite checks, before fuzzing starts, that the kernel refuses
+	// every invalid combination of mode, size and class of load and store
+	// instructions. Each accepted one is reported as a finding.
+	NegativeSuite bool
+
+	// TestRun makes the fuzzer execute accepted programs with
+	// BPF_PROG_TEST_RUN on TestRunData, instead of sending a packet through
+	// a socket they are attached to, which also gives strategies the value
+	// the programs returned.
+	TestRun bool
+
+	// TestRunData is the packet programs are test run on,
+	// DefaultTestRunData if empty.
+	TestRunData []byte
+
+	// TestRunCtx, if not empty, is the context programs start with, e.g. a
+	// struct __sk_buff.
+	TestRunCtx []byte
+
+	// TestRunRepeat is how many times the kernel runs the program for every
+	// test run, the reported duration is the average of the runs.
+	TestRunRepeat uint32
+
+	// BatchSize is how many programs of a BatchStrategy are loaded and test
+	// run per call to the FFI, see FFI.RunBatch. Batched programs are
+	// always test run, without coverage and without the checks that load
+	// them again, e.g. VerifierReloadCount. 0 or 1 disables batching.
+	BatchSize int
+
+	// MapDeltas snapshots the maps of MapOwner strategies around every
+	// execution and records the elements that changed in the execution
+	// result. The changes are checked against the expected ones of
+	// MapDeltaStrategy strategies.
+	MapDeltas bool
+
+	// HangTimeout, if not zero, is how long a program can take to load or
+	// to execute before it is interrupted and reported as a hang, see
+	// watch. Batched programs are not watched.
+	HangTimeout time.Duration
+
+	// InstructionFilter, if set, drops the generated programs that use an
+	// instruction it does not allow before they reach the verifier.
+	InstructionFilter *ebpf.InstructionFilter
+
+	// Arch, if set, is the architecture programs are generated for: the
+	// generated programs its JIT does not translate are dropped. Findings
+	// and corpus entries are tagged with the architecture buzzer runs on,
+	// which executed them.
+	Arch *ebpf.Arch
+
+	// Shared, if set, is the corpus the control unit exchanges programs
+	// and findings with the other workers of a parallel campaign, see
+	// RunWorkers. Worker identifies the control unit among them.
+	Shared *SharedCorpus
+	Worker int
+
+	// Limits, if set, stop the fuzzer once it executed or found enough, or
+	// ran long enough. They can be shared by several workers.
+	Limits *RunLimits
+
+	// Triage, if set, groups findings by signature and only reports the
+	// first one of every signature. It can be shared by several workers.
+	Triage *Triage
+
+	// KernelLog, if set, is checked after every load and execution, the
+	// kernel splats logged meanwhile are reported as findings of the
+	// program. It can be shared by several workers.
+	KernelLog *KernelLog
+
+	// ConsoleMarkers prints every eBPF program on the console before it is
+	// loaded, see corpus.ConsoleMarker, so the VM manager watching the
+	// console knows which one crashed the kernel.
+	ConsoleMarkers bool
+
+	// Triggers, if set, send the packets that execute the socket filter
+	// programs, one is picked at random for every execution. The programs
+	// get 4 bytes over a unix socket otherwise.
+	Triggers []Trigger
+
+	// Xdp, if set, executes the XDP programs on a veth pair instead of
+	// test running them.
+	Xdp *XdpHarness
+
+	// Oracles inspect every execution after the check of the strategy and
+	// the built-in oracles, any of them can flag it as a finding or veto
+	// it, see Oracle.
+	Oracles []Oracle
+
+	// Cgroup, if set, executes the cgroup programs in a cgroup with attach,
+	// replace and detach cycles instead of test running them.
+	Cgroup *CgroupHarness
+
+	// ValueTraces executes the programs of the findings of executions
+	// again, instrumented to record the value of every register they write,
+	// see ebpf.InstrumentValueTrace, and reports the values with the
+	// finding.
+	ValueTraces bool
+
+	// HelperCoverage executes the programs that ran successfully again,
+	// instrumented to tell which of their helper calls returned, and
+	// records the helpers no program called before in the metrics, see
+	// Metrics.CalledHelpers. Programs whose helpers were all called
+	// already are not executed again.
+	HelperCoverage bool
+
+	// Checkpoints, if set, periodically saves the state of the control
+	// unit so an interrupted campaign can resume, see Resume. It can be
+	// shared by several workers.
+	Checkpoints *Checkpointer
+
+	// Privileges are the ways programs can be loaded, one is picked at
+	// random for every generated program. Programs are loaded with the
+	// capabilities of the fuzzer if empty. Strategies can still ask for
+	// some of their programs to be unprivileged, see UnprivilegedStrategy.
+	Privileges []LoadPrivileges
+
+	// BpfToken is the fd of the BPF token the programs picked to be loaded
+	// with LoadWithToken go through, see FFI.CreateBpfToken.
+	BpfToken int
+
+	strat         Strategy
+	ffi           *FFI
+	cm            *CoverageManager
+	rdy           bool
+	memlockRaised bool
+
+	// interrupted is set, atomically, by the watchdog while it interrupts
+	// a hung load or execution, so the load is not retried.
+	interrupted uint32
+
+	// privileges are the ones picked for the last generated program.
+	privileges LoadPrivileges
+
+	// State of the campaign, see RunCampaign. The negative suite only runs
+	// before the first phase.
+	stats             PhaseStats
+	phaseDeadline     time.Time
+	negativeSuiteDone bool
+
+	// How many programs of Shared were already fetched.
+	sharedCursor int
+
+	// How many generated programs in a row were dropped before their
+	// verification, see countDrop.
+	consecutiveDrops int
 }
 
 // Init prepares the control unit to be used.
@@ -84,58 +356,275 @@ func (cu *Control) IsReady() bool {
 
 // RunFuzzer kickstars the fuzzer in the mode that was specified at Init time.
 func (cu *Control) RunFuzzer() error {
-	for !cu.strat.IsFuzzingDone() {
-		prog, err := cu.strat.GenerateProgram(cu.ffi)
+	if cu.NegativeSuite && !cu.negativeSuiteDone {
+		cu.negativeSuiteDone = true
+		if _, err := cu.runNegativeSuite(); err != nil {
+			return fmt.Errorf("negative suite: %w", err)
+		}
+	}
+	switch strat := cu.strat.(type) {
+	case SeccompStrategy:
+		return cu.runSeccompFuzzer(strat)
+	case SocketFilterStrategy:
+		return cu.runSocketFilterFuzzer(strat)
+	case BatchStrategy:
+		if cu.BatchSize > 1 && strat.Batchable() && cu.canBatch() {
+			return cu.runBatchFuzzer()
+		}
+	}
+	for !cu.fuzzingDone() {
+		cu.syncSharedCorpus()
+		cu.checkpoint()
+		prog, err := cu.generateProgram()
 		if err != nil {
-			fmt.Printf("Generate program error: %v\n", err)
+			logging.Warningf("Generate program error: %v\n", err)
 			if !cu.strat.OnError(err) {
 				return err
 			}
 			continue
 		}
+		cu.countProgram()
+
+		if reason := cu.filterProgram(prog); reason != nil {
+			logging.Infof("Dropping program: %v\n", reason)
+			if err := cu.countDrop(reason); err != nil {
+				return err
+			}
+			continue
+		}
 
 		encodedProg, err := ebpf.EncodeInstructions(prog)
 		if err != nil {
-			fmt.Printf("Encoding error: %v\n", err)
+			logging.Warningf("Encoding error: %v\n", err)
 			if !cu.strat.OnError(err) {
 				return err
 			}
 			continue
 		}
 
-		validationResult, err := cu.ffi.ValidateProgram(encodedProg)
+		cu.announceProgram(prog)
+		validationResult, err := cu.watchedValidate(prog, encodedProg)
+		var transient *TransientFailure
+		var hang *HangError
+		if errors.As(err, &transient) || errors.As(err, &hang) {
+			// The verifier never saw the program, or never finished
+			// with it, it is neither a rejection nor a corpus entry.
+			logging.Infof("Dropping program: %v\n", err)
+			continue
+		}
 		if err != nil {
-			fmt.Printf("Validation error: %v\n", err)
+			logging.Warningf("Validation error: %v\n", err)
 			if !cu.strat.OnError(err) {
 				return err
 			}
 			continue
 		}
+		if validationResult.VerifierLogTruncated {
+			// The load failed because of the size of the log, whatever
+			// the verifier thought of the program.
+			logging.Infof("Dropping program: the verifier log does not fit in the biggest buffer\n")
+			continue
+		}
+
+		cu.checkKernelLog(prog, validationResult)
+		cu.checkInvalidEncoding(prog, validationResult)
+		if validationResult.IsValid {
+			cu.stats.ValidPrograms++
+		}
+		if validationResult.IsValid && cu.VerifierReloadCount > 0 {
+			diff, err := cu.checkVerifierDeterminism(encodedProg, validationResult)
+			if err != nil {
+				logging.Warningf("Verifier determinism check error: %v\n", err)
+			} else if diff != "" {
+				cu.reportFinding(&Finding{
+					Description:      fmt.Sprintf("Verifier produced nondeterministic results, %s", diff),
+					Oracle:           OracleNondeterminism,
+					Program:          prog,
+					ValidationResult: validationResult,
+				})
+			}
+		}
+
+		if cu.LogLevelDifferential {
+			diff, err := cu.checkLogLevels(encodedProg, validationResult)
+			if err != nil {
+				logging.Warningf("Log level differential error: %v\n", err)
+			} else if diff != "" {
+				cu.reportFinding(&Finding{
+					Description:      fmt.Sprintf("Verifier log level altered verification, %s", diff),
+					Oracle:           OracleLogLevels,
+					Program:          prog,
+					ValidationResult: validationResult,
+				})
+			}
+		}
+
+		if validationResult.IsValid && cu.ReduceGuards {
+			_, suspicious := cu.checkGuards(prog)
+			for _, s := range suspicious {
+				cu.reportFinding(&Finding{
+					Description:      fmt.Sprintf("Verifier accepted a program without a required guard, %s", s),
+					Oracle:           OracleMissingGuard,
+					Program:          prog,
+					ValidationResult: validationResult,
+				})
+			}
+		}
+
+		if validationResult.IsValid && cu.ReferenceLeaks {
+			for _, index := range ebpf.ObviousLeaks(prog) {
+				cu.reportFinding(&Finding{
+					Description:      fmt.Sprintf("Verifier accepted a program that leaks the reference acquired at instruction %d", index),
+					Oracle:           OracleReferenceLeak,
+					Program:          prog,
+					ValidationResult: validationResult,
+				})
+			}
+		}
 
 		if !cu.strat.OnVerifyDone(cu.ffi, validationResult) || !validationResult.IsValid {
+			cu.recordCorpusEntry(prog, validationResult, nil)
 			cu.ffi.CloseFD(int(validationResult.ProgramFd))
 			continue
 		}
 
-		exReq := &fpb.ExecutionRequest{
-			ProgFd: validationResult.ProgramFd,
+		if cu.ConcurrentExecutions > 1 {
+			if err := cu.stressExecute(validationResult.ProgramFd); err != nil {
+				logging.Warningf("Concurrent execution error: %v\n", err)
+			}
+		}
+
+		// After the stress run, which also modifies the maps.
+		if err := cu.populateKeyedMaps(); err != nil {
+			logging.Warningf("Key space population error: %v\n", err)
 		}
 
-		exRes, err := cu.ffi.RunProgram(exReq)
+		exRes, mapsAfter, err := cu.executeWithMapDeltas(prog, validationResult.ProgramFd)
 		cu.ffi.CloseFD(int(validationResult.ProgramFd))
+		cu.checkKernelLog(prog, validationResult)
+		if errors.As(err, &hang) {
+			logging.Infof("Dropping program: %v\n", err)
+			cu.recordCorpusEntry(prog, validationResult, nil)
+			continue
+		}
 		if err != nil {
-			fmt.Printf("RunProgram error: %v\n", err)
+			logging.Warningf("RunProgram error: %v\n", err)
+			cu.recordCorpusEntry(prog, validationResult, nil)
 			if !cu.strat.OnError(err) {
 				return err
 			}
 			continue
 		}
+		cu.countExecution()
+		cu.recordCorpusEntry(prog, validationResult, exRes)
 
-		ok := cu.strat.OnExecuteDone(cu.ffi, exRes)
-		if !ok {
-			fmt.Println("Program produced unexpected results")
-			ebpf.GeneratePoc(prog)
+		// Corpus entries leave the verifier log out, it is only kept for
+		// the oracles and the triage.
+		exRes.VerifierLog = validationResult.VerifierLog
+		cu.inspectExecution(prog, validationResult, exRes, cu.oracles(mapsAfter))
+		if exRes.GetDidSucceed() {
+			cu.recordHelperCoverage(prog)
 		}
 	}
 	return nil
 }
+
+// reportUnexpectedResult reports the execution of `prog` the strategy did
+// not expect, minimized if MinimizeFindings or ReduceGuards are set and the
+// strategy does not opt out, see MinimizationOptOut.
+func (cu *Control) reportUnexpectedResult(prog *epb.Program, vres *fpb.ValidationResult, exRes *fpb.ExecutionResult) {
+	finding := &Finding{
+		Description:      "Program produced unexpected results",
+		Oracle:           OracleExecution,
+		Program:          prog,
+		ValidationResult: vres,
+		ExecutionResult:  exRes,
+	}
+	if cu.skipsMinimization() {
+		cu.reportFinding(finding)
+		return
+	}
+	if cu.MinimizeFindings {
+		finding.MinimizedProgram = cu.minimize(prog)
+	}
+	if cu.ReduceGuards {
+		reproducer := prog
+		if finding.MinimizedProgram != nil {
+			reproducer = finding.MinimizedProgram
+		}
+		finding.MinimizedProgram = cu.reduceGuards(reproducer, cu.reproduces)
+		finding.RequiredGuards, _ = cu.checkGuards(finding.MinimizedProgram)
+	}
+	cu.reportFinding(finding)
+}
+
+// generateProgram returns the next program of the strategy with its prologue
+// and epilogue spliced around its body, see ebpf.AssembleProgram, and picks
+// the privileges it is loaded with.
+func (cu *Control) generateProgram() (*epb.Program, error) {
+	prog, err := cu.strat.GenerateProgram(cu.ffi)
+	if err != nil {
+		return nil, err
+	}
+	cu.pickPrivileges()
+	return ebpf.AssembleProgram(prog)
+}
+
+// maxConsecutiveDrops is how many generated programs in a row can be dropped
+// before their verification until the fuzzer gives up: the filters leave the
+// strategy nothing it can generate.
+const maxConsecutiveDrops = 1000
+
+// filterProgram returns why `prog` must be dropped before its verification:
+// it uses an instruction the filter or the architecture does not allow, or it
+// is malformed. It returns nil if the program can be loaded.
+func (cu *Control) filterProgram(prog *epb.Program) error {
+	if i := cu.InstructionFilter.FirstBlocked(prog); i >= 0 {
+		return fmt.Errorf("instruction %d (%s) is blocked", i, ebpf.Mnemonic(prog.Instructions[i]))
+	}
+	if i := cu.Arch.FirstUnsupported(prog); i >= 0 {
+		return fmt.Errorf("instruction %d (%s) is not supported on %s", i, ebpf.Mnemonic(prog.Instructions[i]), cu.Arch)
+	}
+	if err := cu.malformed(prog); err != nil {
+		return fmt.Errorf("malformed: %v", err)
+	}
+	cu.consecutiveDrops = 0
+	return nil
+}
+
+// countDrop records that a program was dropped because of `reason`, see
+// filterProgram, and returns an error once maxConsecutiveDrops programs in a
+// row were.
+func (cu *Control) countDrop(reason error) error {
+	cu.consecutiveDrops++
+	if cu.consecutiveDrops >= maxConsecutiveDrops {
+		return fmt.Errorf("the last %d generated programs were dropped before their verification, the last one because %v: check allow_insns, block_insns and arch", cu.consecutiveDrops, reason)
+	}
+	return nil
+}
+
+// countProgram, countExecution and countFinding update the statistics of the
+// current campaign phase, the run limits and the metrics.
+func (cu *Control) countProgram() {
+	cu.stats.Programs++
+	cu.Limits.countProgram()
+	if cu.ffi.MetricsUnit != nil {
+		cu.ffi.MetricsUnit.RecordGeneratedProgram()
+	}
+}
+
+func (cu *Control) countExecution() {
+	cu.stats.Executions++
+	cu.Limits.countExecution()
+	if cu.ffi.MetricsUnit != nil {
+		cu.ffi.MetricsUnit.RecordExecution()
+	}
+}
+
+func (cu *Control) countFinding() {
+	cu.stats.Findings++
+	cu.Limits.countFinding()
+	if cu.ffi.MetricsUnit != nil {
+		cu.ffi.MetricsUnit.RecordOracleViolation()
+	}
+}
@@ -0,0 +1,276 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"buzzer/pkg/ebpf/ebpf"
+	cpb "buzzer/proto/control_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// orchestratorWorker is the worker the programs pushed through the
+// ControlService are published as, it is not the one of any control unit so
+// all of them fetch those programs.
+const orchestratorWorker = -1
+
+// maxPendingFindings is how many findings the ControlService keeps for the
+// orchestrator, the oldest ones are dropped when it does not pull them.
+const maxPendingFindings = 1024
+
+// ControlService implements the control.ControlService gRPC service, which
+// lets an external orchestrator drive a buzzer instance, e.g. one running
+// inside a VM, and harvest its findings.
+//
+// The service is a FindingHook: it only has the findings reported after it
+// was added to the FindingHooks of the control units.
+//
+// RunProgram loads and runs any program with the privileges of the fuzzer,
+// the clients must authenticate with Token, a client certificate checked by
+// TLS, or both.
+type ControlService struct {
+	cpb.UnimplementedControlServiceServer
+
+	// Token, if set, must be sent by the clients in the "authorization"
+	// metadata of every call as "Bearer <token>".
+	Token string
+
+	// TLS, if set, is the configuration the connections are served with.
+	// Clients must present a certificate signed by one of its ClientCAs if
+	// it has any, see LoadControlServiceTLS.
+	TLS *tls.Config
+
+	ffi    *FFI
+	shared *SharedCorpus
+
+	// Programs submitted with RunProgram are run one at a time.
+	runMu sync.Mutex
+
+	mu       sync.Mutex
+	findings []*cpb.Finding
+
+	// firstFinding is the cursor of findings[0], the findings before it
+	// were dropped, see maxPendingFindings.
+	firstFinding uint64
+}
+
+// NewControlService returns a service that runs the submitted programs with
+// `ffi`, reports the metrics of its MetricsUnit and publishes the pushed
+// programs to `shared`, the corpus of the workers.
+func NewControlService(ffi *FFI, shared *SharedCorpus) *ControlService {
+	return &ControlService{ffi: ffi, shared: shared}
+}
+
+// LoadControlServiceTLS returns the TLS configuration of a ControlService
+// that presents the certificate `certFile` with the key `keyFile` and, if
+// `clientCAFile` is not empty, requires the clients to present a certificate
+// signed by one of the CAs of that file.
+func LoadControlServiceTLS(certFile, keyFile, clientCAFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate in %s", clientCAFile)
+	}
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	return config, nil
+}
+
+// Serve serves the service on `lis` until it fails. It refuses to serve
+// clients that can't authenticate, without a Token nor client certificates.
+func (s *ControlService) Serve(lis net.Listener) error {
+	if s.Token == "" && (s.TLS == nil || s.TLS.ClientAuth != tls.RequireAndVerifyClientCert) {
+		return errors.New("the control service runs any program, it needs a token or client certificates")
+	}
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(s.authenticate)}
+	if s.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.TLS)))
+	}
+	server := grpc.NewServer(opts...)
+	cpb.RegisterControlServiceServer(server, s)
+	return server.Serve(lis)
+}
+
+// authenticate rejects the calls without the Token, client certificates are
+// checked by TLS before any call.
+func (s *ControlService) authenticate(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	if s.Token == "" {
+		return handler(ctx, req)
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	want := []byte("Bearer " + s.Token)
+	for _, got := range md.Get("authorization") {
+		if subtle.ConstantTimeCompare([]byte(got), want) == 1 {
+			return handler(ctx, req)
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "missing or invalid token")
+}
+
+// OnFinding keeps `f` until the orchestrator pulls it.
+func (s *ControlService) OnFinding(f *Finding) error {
+	finding := &cpb.Finding{
+		Description:      f.Description,
+		Oracle:           f.Oracle,
+		Splat:            f.Splat,
+		Program:          f.Program,
+		MinimizedProgram: f.MinimizedProgram,
+		ValidationResult: f.ValidationResult,
+		ReproPaths:       f.ReproPaths,
+		Arch:             f.Arch,
+		Privileges:       f.Privileges,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.findings = append(s.findings, finding)
+	if len(s.findings) > maxPendingFindings {
+		dropped := len(s.findings) - maxPendingFindings
+		s.findings = append([]*cpb.Finding(nil), s.findings[dropped:]...)
+		s.firstFinding += uint64(dropped)
+	}
+	return nil
+}
+
+// RunProgram loads the program of `req` and executes it if the verifier
+// accepts it.
+func (s *ControlService) RunProgram(_ context.Context, req *cpb.RunProgramRequest) (*cpb.RunProgramResponse, error) {
+	if len(req.GetProgram().GetInstructions()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "the program has no instructions")
+	}
+	prog, err := ebpf.AssembleProgram(req.GetProgram())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid program: %v", err)
+	}
+	encoded, err := ebpf.EncodeInstructions(prog)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid program: %v", err)
+	}
+	progType := int(req.GetProgramType())
+	if progType == 0 {
+		progType = ProgTypeSocketFilter
+	}
+
+	s.runMu.Lock()
+	defer s.runMu.Unlock()
+	vr, err := s.ffi.ValidateProgramOfType(encoded, progType, 0)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to load the program: %v", err)
+	}
+	res := &cpb.RunProgramResponse{ValidationResult: vr}
+	if !vr.GetIsValid() {
+		return res, nil
+	}
+	defer s.ffi.CloseFD(int(vr.GetProgramFd()))
+
+	if req.GetTestRun() || progType != ProgTypeSocketFilter {
+		data := req.GetData()
+		if len(data) == 0 {
+			data = DefaultTestRunData
+		}
+		res.ExecutionResult, err = s.ffi.TestRunProgram(&fpb.TestRunRequest{ProgFd: vr.GetProgramFd(), DataIn: data})
+	} else {
+		res.ExecutionResult, err = s.ffi.RunProgram(&fpb.ExecutionRequest{ProgFd: vr.GetProgramFd(), InputData: req.GetData()})
+	}
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to execute the program: %v", err)
+	}
+	s.ffi.MetricsUnit.RecordExecution()
+	return res, nil
+}
+
+// GetStats returns the counters of the metrics unit.
+func (s *ControlService) GetStats(context.Context, *cpb.GetStatsRequest) (*cpb.Stats, error) {
+	mc := s.ffi.MetricsUnit.metricsCollection
+	verified, valid, _ := mc.getCounters()
+	generated, executions, violations, start := mc.getLoopCounters()
+	stats := &cpb.Stats{
+		ProgramsGenerated: uint64(generated),
+		ProgramsVerified:  uint64(verified),
+		ValidPrograms:     uint64(valid),
+		Executions:        uint64(executions),
+		OracleViolations:  uint64(violations),
+		UptimeSeconds:     int64(time.Since(start).Seconds()),
+		CalledHelpers:     mc.getCalledHelpers(),
+	}
+	s.mu.Lock()
+	stats.Findings = s.firstFinding + uint64(len(s.findings))
+	s.mu.Unlock()
+	if s.shared != nil {
+		stats.SharedPrograms = uint64(s.shared.Len())
+	}
+	return stats, nil
+}
+
+// PullFindings returns the findings after the cursor of `req`, starting with
+// the oldest one still kept if some of them were dropped.
+func (s *ControlService) PullFindings(_ context.Context, req *cpb.PullFindingsRequest) (*cpb.PullFindingsResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := s.firstFinding + uint64(len(s.findings))
+	if req.GetCursor() > end {
+		return nil, status.Errorf(codes.OutOfRange, "cursor %d is past the %d findings", req.GetCursor(), end)
+	}
+	start := max(req.GetCursor(), s.firstFinding) - s.firstFinding
+	return &cpb.PullFindingsResponse{
+		Findings: s.findings[start:],
+		Cursor:   end,
+	}, nil
+}
+
+// PushCorpus publishes the programs of `req` to the shared corpus, the
+// strategies that keep a population add them the next time their worker
+// syncs.
+func (s *ControlService) PushCorpus(_ context.Context, req *cpb.PushCorpusRequest) (*cpb.PushCorpusResponse, error) {
+	if s.shared == nil {
+		return nil, status.Error(codes.FailedPrecondition, "the workers do not share a corpus")
+	}
+	res := &cpb.PushCorpusResponse{}
+	for _, entry := range req.GetEntries() {
+		if len(entry.GetProgram().GetInstructions()) == 0 {
+			continue
+		}
+		s.shared.Publish(orchestratorWorker, entry.GetProgram())
+		res.Accepted++
+	}
+	return res, nil
+}
@@ -0,0 +1,172 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	cpb "buzzer/proto/control_go_proto"
+	cppb "buzzer/proto/corpus_go_proto"
+	epb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// testToken is the token the test control services require.
+const testToken = "secret"
+
+// dialControlService serves `s` in memory with testToken and returns a client
+// connected to it that authenticates with `token`.
+func dialControlService(t *testing.T, s *ControlService, token string) cpb.ControlServiceClient {
+	t.Helper()
+	s.Token = testToken
+	lis := bufconn.Listen(1 << 20)
+	go s.Serve(lis)
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+			return invoker(metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token), method, req, reply, cc, opts...)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		lis.Close()
+	})
+	return cpb.NewControlServiceClient(conn)
+}
+
+func TestControlService(t *testing.T) {
+	mc := &MetricsCollection{
+		programsGenerated: 10,
+		programsVerified:  8,
+		validPrograms:     5,
+		executions:        5,
+		oracleViolations:  1,
+		startTime:         time.Now().Add(-time.Minute),
+		calledHelpers:     map[int32]bool{7: true, 1: true},
+	}
+	shared := NewSharedCorpus()
+	s := NewControlService(&FFI{MetricsUnit: &Metrics{metricsCollection: mc}}, shared)
+	client := dialControlService(t, s, testToken)
+	ctx := context.Background()
+
+	prog := &epb.Program{Instructions: []*epb.Instruction{Mov64(R0, 0), Exit()}}
+	s.OnFinding(&Finding{Description: "first", Oracle: OracleExecution, Program: prog})
+	s.OnFinding(&Finding{Description: "second", Oracle: OracleExecution, Program: prog, MinimizedProgram: prog})
+
+	pulled, err := client.PullFindings(ctx, &cpb.PullFindingsRequest{})
+	if err != nil {
+		t.Fatalf("PullFindings() failed: %v", err)
+	}
+	if len(pulled.Findings) != 2 || pulled.Cursor != 2 || pulled.Findings[0].Description != "first" || !protobuf.Equal(pulled.Findings[1].MinimizedProgram, prog) {
+		t.Errorf("PullFindings() = %v, want both findings", pulled)
+	}
+	s.OnFinding(&Finding{Description: "third", Oracle: OracleExecution, Program: prog})
+	pulled, err = client.PullFindings(ctx, &cpb.PullFindingsRequest{Cursor: pulled.Cursor})
+	if err != nil {
+		t.Fatalf("PullFindings() failed: %v", err)
+	}
+	if len(pulled.Findings) != 1 || pulled.Cursor != 3 || pulled.Findings[0].Description != "third" {
+		t.Errorf("PullFindings() = %v, want the third finding only", pulled)
+	}
+	if _, err := client.PullFindings(ctx, &cpb.PullFindingsRequest{Cursor: 4}); status.Code(err) != codes.OutOfRange {
+		t.Errorf("PullFindings() past the findings = %v, want OutOfRange", err)
+	}
+
+	pushed, err := client.PushCorpus(ctx, &cpb.PushCorpusRequest{Entries: []*cppb.CorpusEntry{{Program: prog}, {}}})
+	if err != nil {
+		t.Fatalf("PushCorpus() failed: %v", err)
+	}
+	if pushed.Accepted != 1 {
+		t.Errorf("PushCorpus() accepted %d entries, want the one with a program", pushed.Accepted)
+	}
+	if programs, _ := shared.Fetch(0, 0); len(programs) != 1 || !protobuf.Equal(programs[0], prog) {
+		t.Errorf("the workers fetched %v, want the pushed program", programs)
+	}
+
+	stats, err := client.GetStats(ctx, &cpb.GetStatsRequest{})
+	if err != nil {
+		t.Fatalf("GetStats() failed: %v", err)
+	}
+	want := &cpb.Stats{
+		ProgramsGenerated: 10,
+		ProgramsVerified:  8,
+		ValidPrograms:     5,
+		Executions:        5,
+		OracleViolations:  1,
+		Findings:          3,
+		SharedPrograms:    1,
+		UptimeSeconds:     stats.UptimeSeconds,
+		CalledHelpers:     []int32{1, 7},
+	}
+	if !protobuf.Equal(stats, want) || stats.UptimeSeconds < 60 {
+		t.Errorf("GetStats() = %v, want %v after a minute", stats, want)
+	}
+
+	if _, err := client.RunProgram(ctx, &cpb.RunProgramRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("RunProgram() without a program = %v, want InvalidArgument", err)
+	}
+}
+
+func TestControlServiceWithoutSharedCorpus(t *testing.T) {
+	s := NewControlService(&FFI{MetricsUnit: &Metrics{metricsCollection: &MetricsCollection{}}}, nil)
+	client := dialControlService(t, s, testToken)
+	req := &cpb.PushCorpusRequest{Entries: []*cppb.CorpusEntry{{Program: &epb.Program{Instructions: []*epb.Instruction{Exit()}}}}}
+	if _, err := client.PushCorpus(context.Background(), req); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("PushCorpus() = %v, want FailedPrecondition", err)
+	}
+}
+
+func TestControlServiceAuthentication(t *testing.T) {
+	s := NewControlService(&FFI{MetricsUnit: &Metrics{metricsCollection: &MetricsCollection{}}}, nil)
+	client := dialControlService(t, s, "guess")
+	if _, err := client.GetStats(context.Background(), &cpb.GetStatsRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetStats() with a wrong token = %v, want Unauthenticated", err)
+	}
+
+	open := NewControlService(&FFI{}, nil)
+	lis := bufconn.Listen(1 << 10)
+	defer lis.Close()
+	if err := open.Serve(lis); err == nil {
+		t.Errorf("Serve() without a token nor client certificates did not return an error")
+	}
+}
+
+func TestControlServiceDropsOldFindings(t *testing.T) {
+	s := NewControlService(&FFI{MetricsUnit: &Metrics{metricsCollection: &MetricsCollection{}}}, nil)
+	for i := 0; i < maxPendingFindings+2; i++ {
+		s.OnFinding(&Finding{Description: fmt.Sprint(i)})
+	}
+	pulled, err := s.PullFindings(context.Background(), &cpb.PullFindingsRequest{})
+	if err != nil {
+		t.Fatalf("PullFindings() failed: %v", err)
+	}
+	if len(pulled.Findings) != maxPendingFindings || pulled.Cursor != maxPendingFindings+2 || pulled.Findings[0].Description != "2" {
+		t.Errorf("PullFindings() = %d findings from %q up to %d, want %d from \"2\" up to %d", len(pulled.Findings), pulled.Findings[0].Description, pulled.Cursor, maxPendingFindings, maxPendingFindings+2)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+
+	"buzzer/pkg/btf/btf"
+	"buzzer/pkg/corpus/corpus"
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	cpb "buzzer/proto/corpus_go_proto"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// corpusEntry returns the corpus entry of `prog`, with the maps and BTF the
+// strategy generated it with.
+func (cu *Control) corpusEntry(prog *epb.Program, vres *fpb.ValidationResult, exRes *fpb.ExecutionResult) *cpb.CorpusEntry {
+	entry := &cpb.CorpusEntry{
+		Program:         prog,
+		Seed:            rand.SharedSeed(),
+		Strategy:        cu.strat.Name(),
+		IsValid:         vres.GetIsValid(),
+		BpfError:        vres.GetBpfError(),
+		ExecutionResult: exRes,
+		Arch:            hostArchName(),
+	}
+	if owner, ok := cu.strat.(MapOwner); ok {
+		entry.MapSizes = make(map[int64]uint64)
+		for fd, size := range owner.Maps() {
+			entry.MapSizes[int64(fd)] = size
+		}
+	}
+	if bs, ok := cu.strat.(BTFStrategy); ok {
+		if b := bs.ProgramBTF(); b != nil {
+			entry.Btf = &cpb.ProgramBTF{
+				Btf:      b.BTF,
+				FuncInfo: b.EncodeFuncInfo(),
+				LineInfo: b.EncodeLineInfo(),
+			}
+		}
+	}
+	return entry
+}
+
+// announceProgram prints `prog`, about to be loaded, on the console if
+// ConsoleMarkers is set. The line is reported, so it is printed regardless of
+// the log level and, like os.Stdout, it is not buffered: it is out before the
+// program reaches the kernel.
+func (cu *Control) announceProgram(prog *epb.Program) {
+	if !cu.ConsoleMarkers {
+		return
+	}
+	line, err := corpus.FormatConsoleLine(cu.corpusEntry(prog, nil, nil))
+	if err != nil {
+		logging.Warningf("Console marker error: %v\n", err)
+		return
+	}
+	logging.Reportf("%s\n", line)
+}
+
+// recordCorpusEntry appends `prog` and its results to the corpus and the
+// decision log, if they were configured. `exRes` is nil for programs that
+// were not executed.
+func (cu *Control) recordCorpusEntry(prog *epb.Program, vres *fpb.ValidationResult, exRes *fpb.ExecutionResult) {
+	if cu.Corpus == nil && cu.DecisionLog == nil {
+		return
+	}
+	entry := cu.corpusEntry(prog, vres, exRes)
+	if cu.Corpus != nil {
+		if err := cu.Corpus.Write(entry); err != nil {
+			logging.Warningf("Corpus write error: %v\n", err)
+		}
+	}
+	if cu.DecisionLog != nil {
+		var decisions []string
+		if ds, ok := cu.strat.(DecisionStrategy); ok {
+			decisions = ds.Decisions()
+		}
+		if err := cu.DecisionLog.Record(cu.Worker, entry, decisions); err != nil {
+			logging.Warningf("Decision log write error: %v\n", err)
+		}
+	}
+}
+
+// loadEntry loads the program of `entry` on fresh maps of the sizes it was
+// recorded with, and with its BTF if it has any. The returned function
+// closes the maps, it must be called even if loading fails.
+func (cu *Control) loadEntry(entry *cpb.CorpusEntry) (*fpb.ValidationResult, func(), error) {
+	fds := make(map[int]int)
+	closeMaps := func() {
+		for _, fd := range fds {
+			cu.ffi.CloseFD(fd)
+		}
+	}
+	for oldFd, size := range entry.GetMapSizes() {
+		fd := cu.ffi.CreateMapArray(size)
+		if fd < 0 {
+			return nil, closeMaps, fmt.Errorf("could not create map of size %d", size)
+		}
+		fds[int(oldFd)] = fd
+	}
+
+	encodedProg, err := ebpf.EncodeInstructions(ebpf.RemapMapFds(entry.GetProgram(), fds))
+	if err != nil {
+		return nil, closeMaps, err
+	}
+	var vres *fpb.ValidationResult
+	if b := entry.GetBtf(); b != nil {
+		vres, err = cu.loadProgramWithBTF(encodedProg, &btf.Program{
+			BTF:      b.GetBtf(),
+			FuncInfo: btf.DecodeFuncInfo(b.GetFuncInfo()),
+			LineInfo: btf.DecodeLineInfo(b.GetLineInfo()),
+		})
+	} else {
+		vres, err = cu.loadProgram(encodedProg)
+	}
+	return vres, closeMaps, err
+}
+
+// replayEntry loads and, if it was executed originally, runs the program of
+// `entry` on fresh maps. Returns a description of the first difference with
+// the recorded results or an empty string if there is none.
+func (cu *Control) replayEntry(entry *cpb.CorpusEntry) (string, error) {
+	vres, closeMaps, err := cu.loadEntry(entry)
+	defer closeMaps()
+	if err != nil {
+		return "", err
+	}
+	cu.checkKernelLog(entry.GetProgram(), vres)
+	if vres.GetIsValid() {
+		defer cu.ffi.CloseFD(int(vres.GetProgramFd()))
+	}
+	if vres.GetIsValid() != entry.GetIsValid() {
+		return fmt.Sprintf("verdict changed: is_valid %v != %v (%s)", entry.GetIsValid(), vres.GetIsValid(), vres.GetBpfError()), nil
+	}
+	if !vres.GetIsValid() || entry.GetExecutionResult() == nil {
+		return "", nil
+	}
+
+	exRes, err := cu.executeProgram(vres.GetProgramFd())
+	cu.checkKernelLog(entry.GetProgram(), vres)
+	if err != nil {
+		return "", err
+	}
+	if exRes.GetDidSucceed() != entry.GetExecutionResult().GetDidSucceed() {
+		return fmt.Sprintf("execution changed: did_succeed %v != %v", entry.GetExecutionResult().GetDidSucceed(), exRes.GetDidSucceed()), nil
+	}
+	// Only test runs report the value the program returned, the corpus must
+	// have been recorded with them too.
+	if cu.TestRun && exRes.GetRetval() != entry.GetExecutionResult().GetRetval() {
+		return fmt.Sprintf("execution changed: retval %#x != %#x", entry.GetExecutionResult().GetRetval(), exRes.GetRetval()), nil
+	}
+	return "", nil
+}
+
+// ReplayCorpus feeds every program of the corpus file at `path` to the
+// kernel again and reports the ones whose verdict or execution result
+// differs from what was recorded. Returns the number of differences.
+func (cu *Control) ReplayCorpus(path string) (int, error) {
+	entries, err := corpus.Load(path)
+	if err != nil {
+		return 0, err
+	}
+
+	differences := 0
+	for i, entry := range entries {
+		diff, err := cu.replayEntry(entry)
+		if err != nil {
+			logging.Warningf("Replay error on entry %d: %v\n", i, err)
+			continue
+		}
+		if diff != "" {
+			differences++
+			if arch := entry.GetArch(); arch != "" && arch != hostArchName() {
+				diff += fmt.Sprintf(" (recorded on %s)", arch)
+			}
+			logging.Reportf("Entry %d (strategy %s, seed %d): %s\n", i, entry.GetStrategy(), entry.GetSeed(), diff)
+		}
+	}
+	logging.Reportf("Replayed %d programs, %d differences\n", len(entries), differences)
+	return differences, nil
+}
@@ -20,6 +20,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"buzzer/pkg/logging/logging"
 )
 
 type CoverageInfo struct {
@@ -48,6 +50,26 @@ func (cm *CoverageManager) GetCoverageHistory() map[time.Time]int {
 	return cm.coverageHistory
 }
 
+// CoveredAddresses returns the number of distinct addresses observed so
+// far.
+func (cm *CoverageManager) CoveredAddresses() int {
+	cm.coverageLock.Lock()
+	defer cm.coverageLock.Unlock()
+	return len(cm.coverageCache)
+}
+
+// GetCoverageAddresses returns the addresses observed so far, in no
+// particular order.
+func (cm *CoverageManager) GetCoverageAddresses() []uint64 {
+	cm.coverageLock.Lock()
+	defer cm.coverageLock.Unlock()
+	addresses := make([]uint64, 0, len(cm.coverageCache))
+	for address := range cm.coverageCache {
+		addresses = append(addresses, address)
+	}
+	return addresses
+}
+
 // ProcessCoverageAddresses converts raw coverage hex addresses into line
 // numbers and files, it also caches the results.
 func (cm *CoverageManager) ProcessCoverageAddresses(cov []uint64) (map[uint64]string, error) {
@@ -89,7 +111,7 @@ func (cm *CoverageManager) ProcessCoverageAddresses(cov []uint64) (map[uint64]st
 
 	outString, err := cm.addressToLineFunction(inputString)
 	if err != nil {
-		fmt.Printf("addressToLine error: %v\n", err)
+		logging.Warningf("addressToLine error: %v\n", err)
 		return nil, err
 	}
 
@@ -0,0 +1,210 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"buzzer/pkg/corpus/corpus"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/rand"
+	"buzzer/pkg/setup/setup"
+	cpb "buzzer/proto/corpus_go_proto"
+)
+
+// DecisionStrategy can optionally be implemented by strategies to explain,
+// in the decision log, how they came up with their programs.
+type DecisionStrategy interface {
+	// Decisions describes how the last program was generated, e.g. the
+	// seed it comes from or the mutations that were applied.
+	Decisions() []string
+}
+
+// DecisionLog records the event stream of the fuzzing loop, see
+// DecisionEvent, so a segment of a campaign can be replayed exactly with
+// ReplayDecisionLog. A segment starts with the state of the kernel, the
+// sysctls that affect eBPF, followed by every program the kernel saw in
+// order. Once a segment is older than the window it is moved to `path`.prev
+// and a new one starts, so the log always covers the last one to two
+// windows of a campaign however long it runs.
+//
+// It can be shared by several workers.
+type DecisionLog struct {
+	mu     sync.Mutex
+	path   string
+	window time.Duration
+	start  time.Time
+	w      *corpus.Writer
+}
+
+// OpenDecisionLog starts a decision log at `path`, replacing the file that
+// might be there. A `window` of 0 never starts a new segment.
+func OpenDecisionLog(path string, window time.Duration) (*DecisionLog, error) {
+	dl := &DecisionLog{path: path, window: window}
+	if err := dl.startSegment(); err != nil {
+		return nil, err
+	}
+	return dl, nil
+}
+
+// startSegment creates the log file and writes the state the segment starts
+// from. dl.mu must be held.
+func (dl *DecisionLog) startSegment() error {
+	w, err := corpus.CreateWriter(dl.path)
+	if err != nil {
+		return err
+	}
+	dl.w = w
+	dl.start = time.Now()
+
+	start := &cpb.SegmentStart{
+		Seed:              rand.SharedSeed(),
+		StartTimeUnixNano: dl.start.UnixNano(),
+	}
+	if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil {
+		start.KernelRelease = strings.TrimSpace(string(release))
+	}
+	for path, value := range setup.ReadSysctls(setup.BpfSysctls) {
+		start.Sysctls = append(start.Sysctls, &cpb.Sysctl{Path: path, Value: value})
+	}
+	sort.Slice(start.Sysctls, func(i, j int) bool { return start.Sysctls[i].Path < start.Sysctls[j].Path })
+	return dl.w.Write(&cpb.DecisionEvent{
+		Event: &cpb.DecisionEvent_SegmentStart{SegmentStart: start},
+	})
+}
+
+// rotate moves the current segment to `path`.prev and starts a new one.
+// dl.mu must be held.
+func (dl *DecisionLog) rotate() error {
+	if err := dl.w.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(dl.path, dl.path+".prev"); err != nil {
+		return err
+	}
+	return dl.startSegment()
+}
+
+// Record appends `entry`, a program `worker` generated, and the `decisions`
+// that led to it to the log.
+func (dl *DecisionLog) Record(worker int, entry *cpb.CorpusEntry, decisions []string) error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	if dl.window > 0 && time.Since(dl.start) >= dl.window {
+		if err := dl.rotate(); err != nil {
+			return err
+		}
+	}
+	return dl.w.Write(&cpb.DecisionEvent{
+		OffsetNs:  time.Since(dl.start).Nanoseconds(),
+		Event:     &cpb.DecisionEvent_Program{Program: entry},
+		Worker:    int64(worker),
+		Decisions: decisions,
+	})
+}
+
+// Close flushes and closes the current segment.
+func (dl *DecisionLog) Close() error {
+	dl.mu.Lock()
+	defer dl.mu.Unlock()
+	return dl.w.Close()
+}
+
+// ReplayDecisionLog sends the programs of the decision log at `path` to the
+// kernel again, in the order they were recorded, after setting the sysctls
+// back to the values they had when the segment started. Several segments,
+// e.g. `path`.prev followed by `path`, can be concatenated into one file.
+// If `keepTiming` is true every program is loaded as long after the start of
+// its segment as it originally was.
+//
+// The programs whose verdict or execution result differs from what was
+// recorded are reported like with ReplayCorpus, the kernel splats they cause
+// like while fuzzing. Returns the number of differences.
+func (cu *Control) ReplayDecisionLog(path string, keepTiming bool) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var sysctls *setup.SysctlSetup
+	restoreSysctls := func() {
+		if sysctls == nil {
+			return
+		}
+		if err := sysctls.Restore(); err != nil {
+			logging.Warningf("Could not restore the sysctls: %v\n", err)
+		}
+		sysctls = nil
+	}
+	defer restoreSysctls()
+
+	r := corpus.NewReader(f)
+	var segmentStart time.Time
+	programs, differences := 0, 0
+	for {
+		event := &cpb.DecisionEvent{}
+		err := r.NextMessage(event)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return differences, err
+		}
+
+		if start := event.GetSegmentStart(); start != nil {
+			restoreSysctls()
+			values := make(map[string]string)
+			for _, sysctl := range start.GetSysctls() {
+				values[sysctl.GetPath()] = sysctl.GetValue()
+			}
+			sysctls, err = setup.SetSysctls(values)
+			if err != nil {
+				logging.Warningf("Could not set every sysctl of the segment: %v\n", err)
+			}
+			if release, err := os.ReadFile("/proc/sys/kernel/osrelease"); err == nil && strings.TrimSpace(string(release)) != start.GetKernelRelease() {
+				logging.Warningf("The segment was recorded on kernel %s\n", start.GetKernelRelease())
+			}
+			segmentStart = time.Now()
+			continue
+		}
+
+		entry := event.GetProgram()
+		if entry == nil {
+			continue
+		}
+		if keepTiming {
+			time.Sleep(time.Until(segmentStart.Add(time.Duration(event.GetOffsetNs()))))
+		}
+		programs++
+		diff, err := cu.replayEntry(entry)
+		if err != nil {
+			logging.Warningf("Replay error on program %d: %v\n", programs, err)
+			continue
+		}
+		if diff != "" {
+			differences++
+			logging.Reportf("Program %d (worker %d, strategy %s, %s): %s\n", programs, event.GetWorker(), entry.GetStrategy(), strings.Join(event.GetDecisions(), ", "), diff)
+		}
+	}
+	logging.Reportf("Replayed %d programs, %d differences\n", programs, differences)
+	return differences, nil
+}
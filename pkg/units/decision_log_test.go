@@ -0,0 +1,119 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"buzzer/pkg/corpus/corpus"
+	cpb "buzzer/proto/corpus_go_proto"
+)
+
+// readDecisionLog returns the events of the decision log at `path`.
+func readDecisionLog(t *testing.T, path string) []*cpb.DecisionEvent {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open(%s) = %v", path, err)
+	}
+	defer f.Close()
+	var events []*cpb.DecisionEvent
+	r := corpus.NewReader(f)
+	for {
+		event := &cpb.DecisionEvent{}
+		err := r.NextMessage(event)
+		if errors.Is(err, io.EOF) {
+			return events
+		}
+		if err != nil {
+			t.Fatalf("NextMessage() = %v", err)
+		}
+		events = append(events, event)
+	}
+}
+
+func TestDecisionLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "decisions")
+	dl, err := OpenDecisionLog(path, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenDecisionLog() = %v", err)
+	}
+	if err := dl.Record(0, &cpb.CorpusEntry{Strategy: "first"}, []string{"seed"}); err != nil {
+		t.Fatalf("Record() = %v", err)
+	}
+	// The segment is now older than the window, the next program starts a
+	// new one.
+	dl.start = dl.start.Add(-2 * time.Hour)
+	if err := dl.Record(1, &cpb.CorpusEntry{Strategy: "second"}, []string{"2 mutations"}); err != nil {
+		t.Fatalf("Record() = %v", err)
+	}
+	if err := dl.Close(); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+
+	tests := []struct {
+		testName      string
+		path          string
+		wantStrategy  string
+		wantWorker    int64
+		wantDecisions []string
+	}{
+		{
+			testName:      "Previous segment",
+			path:          path + ".prev",
+			wantStrategy:  "first",
+			wantWorker:    0,
+			wantDecisions: []string{"seed"},
+		},
+		{
+			testName:      "Current segment",
+			path:          path,
+			wantStrategy:  "second",
+			wantWorker:    1,
+			wantDecisions: []string{"2 mutations"},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			events := readDecisionLog(t, tc.path)
+			if len(events) != 2 {
+				t.Fatalf("got %d events, want 2", len(events))
+			}
+			if events[0].GetSegmentStart() == nil {
+				t.Errorf("first event = %v, want a segment start", events[0])
+			}
+			program := events[1]
+			if got := program.GetProgram().GetStrategy(); got != tc.wantStrategy {
+				t.Errorf("strategy = %q, want %q", got, tc.wantStrategy)
+			}
+			if program.GetWorker() != tc.wantWorker {
+				t.Errorf("worker = %d, want %d", program.GetWorker(), tc.wantWorker)
+			}
+			if !reflect.DeepEqual(program.GetDecisions(), tc.wantDecisions) {
+				t.Errorf("decisions = %v, want %v", program.GetDecisions(), tc.wantDecisions)
+			}
+			if program.GetOffsetNs() < 0 || time.Duration(program.GetOffsetNs()) > time.Hour {
+				t.Errorf("offset = %v, want within the window", time.Duration(program.GetOffsetNs()))
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"reflect"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// verifierOutput holds everything the verifier produced for a single load
+// of a program, this is what gets compared across attempts.
+type verifierOutput struct {
+	isValid     bool
+	bpfError    string
+	verifierLog string
+	xlated      []uint64
+}
+
+func (cu *Control) collectVerifierOutput(vres *fpb.ValidationResult) (*verifierOutput, error) {
+	out := &verifierOutput{
+		isValid:     vres.GetIsValid(),
+		bpfError:    vres.GetBpfError(),
+		verifierLog: vres.GetVerifierLog(),
+	}
+	if !vres.GetIsValid() {
+		return out, nil
+	}
+
+	info, err := cu.ffi.GetProgramInfo(int(vres.GetProgramFd()))
+	if err != nil {
+		return nil, err
+	}
+	if info.GetErrorMessage() != "" {
+		return nil, fmt.Errorf("could not get program info: %s", info.GetErrorMessage())
+	}
+	out.xlated = info.GetXlatedInstructions()
+	return out, nil
+}
+
+// diff returns a human readable description of the first difference between
+// `vo` and `other`, or an empty string if both are the same.
+func (vo *verifierOutput) diff(other *verifierOutput) string {
+	if vo.isValid != other.isValid {
+		return fmt.Sprintf("verdict changed: is_valid %v != %v", vo.isValid, other.isValid)
+	}
+	if vo.bpfError != other.bpfError {
+		return fmt.Sprintf("bpf error changed: %q != %q", vo.bpfError, other.bpfError)
+	}
+	if vo.verifierLog != other.verifierLog {
+		return "verifier log changed"
+	}
+	if !reflect.DeepEqual(vo.xlated, other.xlated) {
+		return fmt.Sprintf("xlated program changed: %d != %d instructions", len(vo.xlated), len(other.xlated))
+	}
+	return ""
+}
+
+// checkVerifierDeterminism loads `prog` VerifierReloadCount more times and
+// compares the verdict, verifier log and xlated instructions of every attempt
+// against the ones of the first load (`first`).
+//
+// Returns a description of the first difference found, or an empty string if
+// the verifier behaved the same way on every attempt.
+func (cu *Control) checkVerifierDeterminism(prog []uint64, first *fpb.ValidationResult) (string, error) {
+	want, err := cu.collectVerifierOutput(first)
+	if err != nil {
+		return "", err
+	}
+
+	for attempt := 1; attempt <= cu.VerifierReloadCount; attempt++ {
+		vres, err := cu.loadProgram(prog)
+		if err != nil {
+			return "", err
+		}
+		got, err := cu.collectVerifierOutput(vres)
+		if vres.GetIsValid() {
+			cu.ffi.CloseFD(int(vres.GetProgramFd()))
+		}
+		if err != nil {
+			return "", err
+		}
+		if d := want.diff(got); d != "" {
+			return fmt.Sprintf("attempt %d: %s", attempt, d), nil
+		}
+	}
+	return "", nil
+}
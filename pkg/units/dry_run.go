@@ -0,0 +1,76 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"io"
+
+	"buzzer/pkg/ebpf/ebpf"
+	"github.com/golang/protobuf/proto"
+)
+
+// DryRun makes the strategy generate `count` programs, or programs until it
+// is done if `count` is 0, and writes to `w` the disassembly, the encoding
+// and the proto of each one. Nothing is loaded: the control unit must have
+// been initialized with fake maps and the verifier hooks of the strategy are
+// never called, which is enough to iterate on the generation logic on
+// machines that can't load eBPF programs.
+func (cu *Control) DryRun(w io.Writer, count int) error {
+	switch cu.strat.(type) {
+	case SeccompStrategy, SocketFilterStrategy:
+		return fmt.Errorf("strategy %s runs its own loop and can't be dry run", cu.strat.Name())
+	}
+	if cu.ffi.Maps == nil {
+		return fmt.Errorf("dry runs need fake maps")
+	}
+	for n := 1; (count == 0 || n <= count) && !cu.strat.IsFuzzingDone(); n++ {
+		prog, err := cu.generateProgram()
+		if err != nil {
+			fmt.Fprintf(w, "Program %d: generate program error: %v\n", n, err)
+			if !cu.strat.OnError(err) {
+				return err
+			}
+			continue
+		}
+		if reason := cu.filterProgram(prog); reason != nil {
+			fmt.Fprintf(w, "Program %d: dropped, %v\n", n, reason)
+			if err := cu.countDrop(reason); err != nil {
+				return err
+			}
+			continue
+		}
+		encodedProg, err := ebpf.EncodeInstructions(prog)
+		if err != nil {
+			fmt.Fprintf(w, "Program %d: encoding error: %v\n", n, err)
+			if !cu.strat.OnError(err) {
+				return err
+			}
+			continue
+		}
+		listing, err := ebpf.Disassemble(prog)
+		if err != nil {
+			listing = fmt.Sprintf("disassembly error: %v\n", err)
+		}
+
+		fmt.Fprintf(w, "Program %d, %d instructions:\n%s", n, len(encodedProg), listing)
+		fmt.Fprintf(w, "Encoding:\n")
+		for i, slot := range encodedProg {
+			fmt.Fprintf(w, "%4d: %016x\n", i, slot)
+		}
+		fmt.Fprintf(w, "Proto:\n%s\n", proto.MarshalTextString(prog))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+)
+
+func TestDryRun(t *testing.T) {
+	s := &returnStrategy{idleStrategy{name: "return", programs: 100}}
+	cu := &Control{}
+	cu.Init(&FFI{Maps: NewFakeMaps()}, nil, s)
+
+	var out strings.Builder
+	if err := cu.DryRun(&out, 2); err != nil {
+		t.Fatalf("DryRun() = %v, want nil error", err)
+	}
+	if s.attempts != 2 {
+		t.Errorf("generated %d programs, want 2", s.attempts)
+	}
+	for _, want := range []string{
+		"Program 1, 2 instructions:\n   0: (b7) r0 = 0\n   1: (95) exit\n",
+		"Encoding:\n   0: 00000000000000b7\n   1: 0000000000000095\n",
+		"Program 2, 2 instructions:",
+		"Proto:\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("DryRun() output = %q, want it to contain %q", out.String(), want)
+		}
+	}
+
+	filter, err := ebpf.NewInstructionFilter("", "exit")
+	if err != nil {
+		t.Fatalf("NewInstructionFilter() = %v, want nil error", err)
+	}
+	cu.InstructionFilter = filter
+	out.Reset()
+	if err := cu.DryRun(&out, 1); err != nil {
+		t.Fatalf("DryRun() = %v, want nil error", err)
+	}
+	if want := "Program 1: dropped, instruction 1 (exit) is blocked\n"; out.String() != want {
+		t.Errorf("DryRun() output = %q, want %q", out.String(), want)
+	}
+
+	// Every program is dropped, the dry run gives up instead of looping.
+	dropped := &returnStrategy{idleStrategy{name: "return", programs: 2 * maxConsecutiveDrops}}
+	filtered := &Control{InstructionFilter: filter}
+	filtered.Init(&FFI{Maps: NewFakeMaps()}, nil, dropped)
+	if err := filtered.DryRun(io.Discard, 0); err == nil {
+		t.Errorf("DryRun() of a strategy whose programs are all dropped did not return an error")
+	}
+	if dropped.attempts != maxConsecutiveDrops {
+		t.Errorf("generated %d programs, want %d", dropped.attempts, maxConsecutiveDrops)
+	}
+
+	kernel := &Control{}
+	kernel.Init(&FFI{}, nil, s)
+	if err := kernel.DryRun(&out, 1); err == nil {
+		t.Errorf("DryRun() without fake maps did not return an error")
+	}
+}
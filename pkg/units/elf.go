@@ -0,0 +1,474 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"buzzer/pkg/btf/btf"
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+const (
+	// legacyMapsSection holds struct bpf_map_def definitions, the maps of
+	// the programs written before BTF.
+	legacyMapsSection = "maps"
+
+	// btfMapsSection holds the maps defined with the __uint and __type
+	// macros of libbpf, described by the .BTF section.
+	btfMapsSection = ".maps"
+
+	// textSection holds the functions the programs call.
+	textSection = ".text"
+
+	// callOpcode and wideLoadOpcode are BPF_JMP|BPF_CALL and
+	// BPF_LD|BPF_IMM|BPF_DW.
+	callOpcode     = 0x85
+	wideLoadOpcode = 0x18
+)
+
+// ELFMap is a map defined by an object file.
+type ELFMap struct {
+	Name       string
+	Type       int
+	KeySize    uint32
+	ValueSize  uint32
+	MaxEntries uint64
+	Flags      uint32
+}
+
+// ELFProgram is a program of an object file with the functions it calls
+// appended to it.
+type ELFProgram struct {
+	// Name is the name of the function, Section the name of its section,
+	// e.g. "socket".
+	Name    string
+	Section string
+
+	// ProgramType is the BPF_PROG_TYPE_* of the section.
+	ProgramType int
+
+	// Program loads the maps by their index in ELFObject.Maps instead of
+	// an fd, see LoadELF.
+	Program *epb.Program
+}
+
+// ELFObject is what ParseELF could import from an object file.
+type ELFObject struct {
+	Programs []*ELFProgram
+	Maps     []*ELFMap
+
+	// Skipped are the reasons the other programs could not be imported,
+	// by name, e.g. they use global data.
+	Skipped map[string]error
+}
+
+// sectionProgramTypes are the program types of the section names libbpf
+// recognizes that buzzer can load programs as, see ProgramTypeStrategy.
+var sectionProgramTypes = map[string]int{
+	"socket":     ProgTypeSocketFilter,
+	"classifier": ProgTypeSchedCls,
+	"tc":         ProgTypeSchedCls,
+	"tcx":        ProgTypeSchedCls,
+}
+
+// sectionProgramType returns the program type of the section `name`, e.g.
+// "tc/ingress", 0 if buzzer can't load it.
+func sectionProgramType(name string) int {
+	prefix, _, _ := strings.Cut(name, "/")
+	return sectionProgramTypes[prefix]
+}
+
+// elfSection is an executable section with its relocations by slot.
+type elfSection struct {
+	name   string
+	slots  []uint64
+	relocs map[int]elf.Symbol
+}
+
+// elfFunc is a function of an executable section, in slots.
+type elfFunc struct {
+	section    elf.SectionIndex
+	start, end int
+}
+
+// elfParser holds what the programs of an object file are imported from.
+type elfParser struct {
+	symbols  []elf.Symbol
+	sections map[elf.SectionIndex]*elfSection
+	maps     map[string]int
+}
+
+// ParseELF imports the programs and the maps of the BPF object file `r`, e.g.
+// one built with `clang -target bpf`. Each program gets the functions it
+// calls and the maps it loads become loads of their index in the Maps of the
+// result. The programs of a type buzzer can't load, or that need a
+// relocation other than against a map or a function, are skipped.
+func ParseELF(r io.ReaderAt) (*ELFObject, error) {
+	f, err := elf.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
+	if f.Machine != elf.EM_BPF {
+		return nil, fmt.Errorf("object file is for %v, not BPF", f.Machine)
+	}
+	if f.ByteOrder != binary.LittleEndian {
+		return nil, fmt.Errorf("only little endian object files are supported")
+	}
+	symbols, err := f.Symbols()
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &ELFObject{Skipped: make(map[string]error)}
+	p := &elfParser{
+		symbols:  symbols,
+		sections: make(map[elf.SectionIndex]*elfSection),
+		maps:     make(map[string]int),
+	}
+	if obj.Maps, err = parseMaps(f, symbols); err != nil {
+		return nil, err
+	}
+	for i, m := range obj.Maps {
+		p.maps[m.Name] = i
+	}
+
+	for i, s := range f.Sections {
+		if s.Type != elf.SHT_PROGBITS || s.Flags&elf.SHF_EXECINSTR == 0 {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+		es := &elfSection{name: s.Name, relocs: make(map[int]elf.Symbol)}
+		for off := 0; off+8 <= len(data); off += 8 {
+			es.slots = append(es.slots, binary.LittleEndian.Uint64(data[off:]))
+		}
+		p.sections[elf.SectionIndex(i)] = es
+	}
+	for _, s := range f.Sections {
+		es, ok := p.sections[elf.SectionIndex(s.Info)]
+		if s.Type != elf.SHT_REL || !ok {
+			continue
+		}
+		data, err := s.Data()
+		if err != nil {
+			return nil, err
+		}
+		// Elf64_Rel: r_offset, then r_info with the symbol index in the
+		// high word.
+		for off := 0; off+16 <= len(data); off += 16 {
+			symbol := int(binary.LittleEndian.Uint64(data[off+8:]) >> 32)
+			if symbol == 0 || symbol > len(symbols) {
+				return nil, fmt.Errorf("relocation in %s against the bad symbol %d", es.name, symbol)
+			}
+			// debug/elf drops the null symbol.
+			es.relocs[int(binary.LittleEndian.Uint64(data[off:])/8)] = symbols[symbol-1]
+		}
+	}
+
+	for _, sym := range symbols {
+		es, ok := p.sections[sym.Section]
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || !ok || es.name == textSection {
+			continue
+		}
+		progType := sectionProgramType(es.name)
+		if progType == 0 {
+			obj.Skipped[sym.Name] = fmt.Errorf("programs of section %q are not supported", es.name)
+			continue
+		}
+		prog, err := p.program(p.function(sym))
+		if err != nil {
+			obj.Skipped[sym.Name] = err
+			continue
+		}
+		obj.Programs = append(obj.Programs, &ELFProgram{
+			Name:        sym.Name,
+			Section:     es.name,
+			ProgramType: progType,
+			Program:     prog,
+		})
+	}
+	return obj, nil
+}
+
+// function returns the slots of the function `sym`.
+func (p *elfParser) function(sym elf.Symbol) elfFunc {
+	fn := elfFunc{section: sym.Section, start: int(sym.Value / 8), end: int((sym.Value + sym.Size) / 8)}
+	if sym.Size == 0 {
+		fn.end = len(p.sections[sym.Section].slots)
+	}
+	return fn
+}
+
+// functionAt returns the function of `section` that `slot` is part of.
+func (p *elfParser) functionAt(section elf.SectionIndex, slot int) (elfFunc, error) {
+	for _, sym := range p.symbols {
+		if sym.Section != section || elf.ST_TYPE(sym.Info) != elf.STT_FUNC {
+			continue
+		}
+		if fn := p.function(sym); fn.start <= slot && slot < fn.end {
+			return fn, nil
+		}
+	}
+	return elfFunc{}, fmt.Errorf("no function at instruction %d of %s", slot, p.sections[section].name)
+}
+
+// setSrcImm returns `slot` with its source register and immediate replaced.
+func setSrcImm(slot uint64, src epb.Reg, imm int32) uint64 {
+	slot = slot&^(0xf<<12) | uint64(src)<<12
+	return slot&0xffffffff | uint64(uint32(imm))<<32
+}
+
+// program returns the program made of `entry` followed by the functions it
+// calls, in the order they are called. The calls, and the loads of the
+// address of a function, are patched to point to where the functions land.
+func (p *elfParser) program(entry elfFunc) (*epb.Program, error) {
+	type fixup struct {
+		slot    int
+		section elf.SectionIndex
+		target  int
+	}
+	var (
+		slots   []uint64
+		fixups  []fixup
+		placed  = map[elfFunc]int{entry: 0}
+		pending = []elfFunc{entry}
+	)
+	reference := func(slot int, section elf.SectionIndex, target int) error {
+		fn, err := p.functionAt(section, target)
+		if err != nil {
+			return err
+		}
+		if _, ok := placed[fn]; !ok {
+			// Where it lands is only known once the functions before
+			// it are copied.
+			placed[fn] = -1
+			pending = append(pending, fn)
+		}
+		fixups = append(fixups, fixup{slot, section, target})
+		return nil
+	}
+
+	for len(pending) > 0 {
+		fn := pending[0]
+		pending = pending[1:]
+		placed[fn] = len(slots)
+		es := p.sections[fn.section]
+		for k := fn.start; k < fn.end; k++ {
+			slot := es.slots[k]
+			imm := int32(slot >> 32)
+			sym, relocated := es.relocs[k]
+			switch {
+			case uint8(slot) == callOpcode && epb.Reg(slot>>12&0xf) == ebpf.PseudoCall:
+				// The relocation, if any, is against the section of the
+				// callee or the callee itself.
+				section, target := fn.section, k+int(imm)+1
+				if relocated {
+					section, target = sym.Section, int(sym.Value/8)+int(imm)+1
+				}
+				if err := reference(len(slots), section, target); err != nil {
+					return nil, err
+				}
+			case relocated && uint8(slot) == wideLoadOpcode:
+				if _, ok := p.sections[sym.Section]; ok {
+					if err := reference(len(slots), sym.Section, int((sym.Value+uint64(imm))/8)); err != nil {
+						return nil, err
+					}
+					slot = setSrcImm(slot, ebpf.PseudoFunc, 0)
+					break
+				}
+				index, ok := p.maps[sym.Name]
+				if !ok {
+					return nil, fmt.Errorf("relocation against %q is not a map or a function", sym.Name)
+				}
+				slot = setSrcImm(slot, ebpf.PseudoMapFD, int32(index))
+				if k+1 < fn.end {
+					slots = append(slots, slot)
+					k++
+					slot = es.slots[k] & 0xffffffff
+				}
+			case relocated:
+				return nil, fmt.Errorf("relocation against %q at instruction %d of %s is not supported", sym.Name, k, es.name)
+			}
+			slots = append(slots, slot)
+		}
+	}
+
+	for _, f := range fixups {
+		fn, err := p.functionAt(f.section, f.target)
+		if err != nil {
+			return nil, err
+		}
+		slots[f.slot] = setSrcImm(slots[f.slot], epb.Reg(slots[f.slot]>>12&0xf), int32(placed[fn]+f.target-fn.start-f.slot-1))
+	}
+	return ebpf.DecodeInstructionsFor(slots, ebpf.LittleEndian)
+}
+
+// parseMaps returns the maps defined in the legacy and the BTF maps sections
+// of `f`.
+func parseMaps(f *elf.File, symbols []elf.Symbol) ([]*ELFMap, error) {
+	var maps []*ELFMap
+	var types btf.Types
+	for _, sym := range symbols {
+		if int(sym.Section) >= len(f.Sections) || elf.ST_TYPE(sym.Info) == elf.STT_SECTION || sym.Name == "" {
+			continue
+		}
+		s := f.Sections[sym.Section]
+		switch s.Name {
+		case legacyMapsSection:
+			data, err := s.Data()
+			if err != nil {
+				return nil, err
+			}
+			// struct bpf_map_def: type, key_size, value_size,
+			// max_entries and map_flags.
+			if sym.Value+16 > uint64(len(data)) {
+				return nil, fmt.Errorf("map %q is truncated", sym.Name)
+			}
+			def := data[sym.Value:]
+			m := &ELFMap{
+				Name:       sym.Name,
+				Type:       int(binary.LittleEndian.Uint32(def)),
+				KeySize:    binary.LittleEndian.Uint32(def[4:]),
+				ValueSize:  binary.LittleEndian.Uint32(def[8:]),
+				MaxEntries: uint64(binary.LittleEndian.Uint32(def[12:])),
+			}
+			if sym.Value+20 <= uint64(len(data)) {
+				m.Flags = binary.LittleEndian.Uint32(def[16:])
+			}
+			maps = append(maps, m)
+		case btfMapsSection:
+			if types == nil {
+				section := f.Section(".BTF")
+				if section == nil {
+					return nil, fmt.Errorf("map %q is defined without BTF", sym.Name)
+				}
+				blob, err := section.Data()
+				if err != nil {
+					return nil, err
+				}
+				if types, err = btf.Decode(blob); err != nil {
+					return nil, err
+				}
+			}
+			m, err := btfMap(types, sym.Name)
+			if err != nil {
+				return nil, err
+			}
+			maps = append(maps, m)
+		}
+	}
+	return maps, nil
+}
+
+// btfMap returns the map `name` of the .maps datasec of `types`. The integer
+// attributes are the number of elements of the array their member points
+// to, the key and the value are the type their member points to.
+func btfMap(types btf.Types, name string) (*ELFMap, error) {
+	datasec := types.Datasec(btfMapsSection)
+	if datasec == nil {
+		return nil, fmt.Errorf("no %s in the BTF", btfMapsSection)
+	}
+	var def *btf.Type
+	for _, v := range datasec.Vars {
+		if vt := types.Type(v.Type); vt != nil && vt.Kind == btf.KindVar && vt.Name == name {
+			def = types.Resolve(btf.TypeID(vt.SizeOrType))
+		}
+	}
+	if def == nil || def.Kind != btf.KindStruct {
+		return nil, fmt.Errorf("map %q is not a struct of the BTF", name)
+	}
+
+	m := &ELFMap{Name: name}
+	for _, member := range def.Members {
+		ptr := types.Resolve(member.Type)
+		if ptr == nil || ptr.Kind != btf.KindPtr {
+			return nil, fmt.Errorf("member %s of map %q is not a pointer", member.Name, name)
+		}
+		target := btf.TypeID(ptr.SizeOrType)
+		var nelems uint32
+		if array := types.Resolve(target); array != nil && array.Kind == btf.KindArray {
+			nelems = array.Nelems
+		}
+		switch member.Name {
+		case "type":
+			m.Type = int(nelems)
+		case "key_size":
+			m.KeySize = nelems
+		case "value_size":
+			m.ValueSize = nelems
+		case "max_entries":
+			m.MaxEntries = uint64(nelems)
+		case "map_flags":
+			m.Flags = nelems
+		case "key", "value":
+			size, ok := types.Size(target)
+			if !ok {
+				return nil, fmt.Errorf("%s of map %q has no size", member.Name, name)
+			}
+			if member.Name == "key" {
+				m.KeySize = size
+			} else {
+				m.ValueSize = size
+			}
+		}
+	}
+	return m, nil
+}
+
+// LoadELF imports the programs of the object file at `path`, see ParseELF,
+// and creates their maps. The programs load the maps by fd, the caller
+// closes the returned fds with ffi.CloseFD once it is done with them.
+func LoadELF(ffi *FFI, path string) ([]*ELFProgram, []int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	obj, err := ParseELF(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for name, err := range obj.Skipped {
+		logging.Infof("%s: skipped program %s: %v\n", path, name, err)
+	}
+
+	fds := make(map[int]int)
+	var mapFds []int
+	for i, m := range obj.Maps {
+		fd := ffi.CreateMap(m.Type, m.KeySize, m.ValueSize, m.MaxEntries, m.Flags)
+		if fd < 0 {
+			for _, fd := range mapFds {
+				ffi.CloseFD(fd)
+			}
+			return nil, nil, fmt.Errorf("%s: could not create map %s", path, m.Name)
+		}
+		fds[i] = fd
+		mapFds = append(mapFds, fd)
+	}
+	for _, prog := range obj.Programs {
+		prog.Program = ebpf.RemapMapFds(prog.Program, fds)
+	}
+	return obj.Programs, mapFds, nil
+}
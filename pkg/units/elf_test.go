@@ -0,0 +1,122 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+// testELF is built from testdata/elf/prog.ll.
+const testELF = "testdata/elf/prog.o"
+
+func TestParseELF(t *testing.T) {
+	f, err := os.Open(testELF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	obj, err := ParseELF(f)
+	if err != nil {
+		t.Fatalf("ParseELF() failed: %v", err)
+	}
+
+	wantMaps := []*ELFMap{
+		{Name: "counters", Type: MapTypeArray, KeySize: 4, ValueSize: 8, MaxEntries: 4},
+		{Name: "legacy", Type: MapTypeHash, KeySize: 4, ValueSize: 8, MaxEntries: 16},
+	}
+	if !reflect.DeepEqual(obj.Maps, wantMaps) {
+		t.Errorf("maps = %+v, want %+v", obj.Maps, wantMaps)
+	}
+	if _, ok := obj.Skipped["uses_rodata"]; !ok || len(obj.Skipped) != 1 {
+		t.Errorf("skipped = %v, want uses_rodata, which reads global data", obj.Skipped)
+	}
+
+	// inc of .text is appended to the programs that call it.
+	inc := func(b *ProgramBuilder) *ProgramBuilder {
+		return b.Label("inc").Mov64(R0, R1).Add64(R0, 1).Exit()
+	}
+	count := NewProgramBuilder().
+		Mov(R1, 0).StW(R10, R1, -4).Mov64(R2, R10).Add64(R2, -4).
+		LdMapByFd(R1, 0).Call(MapLookup).Mov64(R6, R0).JmpEQ(R6, 0, "legacy").
+		LdDW(R1, R6, 0).CallLocal("inc").StDW(R6, R0, 0).
+		Label("legacy").Mov64(R2, R10).Add64(R2, -4).
+		LdMapByFd(R1, 1).Call(MapLookup).Mov64(R1, R0).Mov(R0, 1).JmpNE(R1, 0, "out").
+		Mov(R0, 0).
+		Label("out").Exit()
+	tcInc := NewProgramBuilder().LdW(R1, R1, 0).CallLocal("inc").Exit()
+
+	tests := []struct {
+		name        string
+		section     string
+		programType int
+		builder     *ProgramBuilder
+	}{
+		{"count", "socket", ProgTypeSocketFilter, inc(count)},
+		{"tc_inc", "tc", ProgTypeSchedCls, inc(tcInc)},
+	}
+	if len(obj.Programs) != len(tests) {
+		t.Fatalf("ParseELF() = %d programs, want %d", len(obj.Programs), len(tests))
+	}
+	for i, tc := range tests {
+		want, err := tc.builder.Build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := obj.Programs[i]
+		if got.Name != tc.name || got.Section != tc.section || got.ProgramType != tc.programType {
+			t.Errorf("program %d = %s in %s of type %d, want %s in %s of type %d", i, got.Name, got.Section, got.ProgramType, tc.name, tc.section, tc.programType)
+		}
+		if !protobuf.Equal(got.Program, want) {
+			t.Errorf("%s = %v, want %v", tc.name, got.Program.Instructions, want.Instructions)
+		}
+	}
+}
+
+func TestLoadELF(t *testing.T) {
+	ffi := &FFI{Maps: NewFakeMaps()}
+	progs, mapFds, err := LoadELF(ffi, testELF)
+	if err != nil {
+		t.Fatalf("LoadELF() failed: %v", err)
+	}
+	if want := ffi.Maps.Fds(); !reflect.DeepEqual(mapFds, want) {
+		t.Errorf("LoadELF() returned the maps %v, want the created maps %v", mapFds, want)
+	}
+	var fds []int
+	for _, insn := range progs[0].Program.Instructions {
+		if mem, ok := insn.Opcode.(*epb.Instruction_MemOpcode); ok && mem.MemOpcode.InstructionClass == epb.InsClass_InsClassLd {
+			fds = append(fds, int(insn.Immediate))
+		}
+	}
+	if want := ffi.Maps.Fds(); !reflect.DeepEqual(fds, want) {
+		t.Errorf("maps loaded by fd %v, want the created maps %v", fds, want)
+	}
+
+	for _, fd := range mapFds {
+		ffi.CloseFD(fd)
+	}
+	if fds := ffi.Maps.Fds(); len(fds) != 0 {
+		t.Errorf("maps %v are still open after closing the returned ones", fds)
+	}
+
+	if _, _, err := LoadELF(ffi, "testdata/elf/prog.ll"); err == nil {
+		t.Errorf("LoadELF() of a text file succeeded")
+	}
+}
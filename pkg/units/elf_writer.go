@@ -0,0 +1,432 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+
+	"buzzer/pkg/btf/btf"
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+const (
+	// Relocation types of the BPF backend of LLVM, for the 64 bits
+	// immediate of a wide load and the 32 bits one of a call.
+	relBPF64_64 = 1
+	relBPF64_32 = 10
+
+	// licenseSection holds the license the programs are loaded with, like
+	// the programs buzzer loads itself they are GPL so they can call every
+	// helper.
+	licenseSection = "license"
+	elfLicense     = "GPL"
+)
+
+// programSections are the section names libbpf loads the program types
+// buzzer generates from.
+var programSections = map[int]string{
+	ProgTypeSocketFilter: "socket",
+	ProgTypeSchedCls:     "tc",
+	ProgTypeTracepoint:   "tracepoint",
+	ProgTypeXdp:          "xdp",
+	ProgTypeCgroupSkb:    "cgroup/skb",
+	ProgTypeCgroupSock:   "cgroup/sock",
+	ProgTypeSyscall:      "syscall",
+}
+
+// elfRelocation is a relocation of a slot against the symbol of a map, or of
+// .text for the calls.
+type elfRelocation struct {
+	slot int
+	typ  uint32
+	// mapIndex is -1 for the relocations against .text.
+	mapIndex int
+}
+
+// elfLayout is a program split between its section, for the instructions of
+// the entry function, and .text, for the functions it calls.
+type elfLayout struct {
+	entry       []uint64
+	entryRelocs []elfRelocation
+
+	// subprograms are the offsets in .text, in slots, of the functions
+	// with their sizes.
+	subprograms [][2]int
+}
+
+// subprogramStarts returns the slots of `slots` that are called, or whose
+// address is loaded, in increasing order.
+func subprogramStarts(slots []uint64) ([]int, error) {
+	targets := make(map[int]bool)
+	for i := 0; i < len(slots); i++ {
+		slot := slots[i]
+		src := epb.Reg(slot >> 12 & 0xf)
+		isCall := uint8(slot) == callOpcode && src == ebpf.PseudoCall
+		isFunc := uint8(slot) == wideLoadOpcode && src == ebpf.PseudoFunc
+		if isCall || isFunc {
+			target := i + int(int32(slot>>32)) + 1
+			if target <= 0 || target >= len(slots) {
+				return nil, fmt.Errorf("instruction %d calls %d, out of the program", i, target)
+			}
+			targets[target] = true
+		}
+		if uint8(slot) == wideLoadOpcode {
+			i++
+		}
+	}
+	var starts []int
+	for target := range targets {
+		starts = append(starts, target)
+	}
+	sort.Ints(starts)
+	return starts, nil
+}
+
+// relocate rewrites the loads of maps and the references to functions of
+// the slots `from` to `to` of `slots` the way clang emits them and returns
+// their relocations. The functions start at slot `first` of the program,
+// which lands at slot `textBase` of .text.
+func relocate(slots []uint64, from, to, first, textBase, numMaps int) ([]elfRelocation, error) {
+	var relocs []elfRelocation
+	for i := from; i < to; i++ {
+		slot := slots[i]
+		src := epb.Reg(slot >> 12 & 0xf)
+		imm := int(int32(slot >> 32))
+		switch {
+		case uint8(slot) == callOpcode && src == ebpf.PseudoCall && from < first:
+			// Calls of the entry function are relative to .text.
+			slots[i] = setSrcImm(slot, src, int32(textBase+i+imm+1-first-1))
+			relocs = append(relocs, elfRelocation{slot: i - from, typ: relBPF64_32, mapIndex: -1})
+		case uint8(slot) == wideLoadOpcode && src == ebpf.PseudoFunc:
+			// The immediate is the offset in bytes in .text.
+			slots[i] = setSrcImm(slot, 0, int32(8*(textBase+i+imm+1-first)))
+			relocs = append(relocs, elfRelocation{slot: i - from, typ: relBPF64_64, mapIndex: -1})
+		case uint8(slot) == wideLoadOpcode && src == ebpf.PseudoMapFD:
+			if imm < 0 || imm >= numMaps {
+				return nil, fmt.Errorf("instruction %d loads map %d, there are %d", i, imm, numMaps)
+			}
+			slots[i] = setSrcImm(slot, 0, 0)
+			relocs = append(relocs, elfRelocation{slot: i - from, typ: relBPF64_64, mapIndex: imm})
+		case uint8(slot) == wideLoadOpcode && src != 0:
+			return nil, fmt.Errorf("instruction %d is a wide load of source %d, libbpf can't relocate it", i, src)
+		}
+		if uint8(slot) == wideLoadOpcode {
+			i++
+		}
+	}
+	return relocs, nil
+}
+
+// elfStrings is a string table.
+type elfStrings struct {
+	data    []byte
+	offsets map[string]uint32
+}
+
+func (s *elfStrings) add(str string) uint32 {
+	if offset, ok := s.offsets[str]; ok {
+		return offset
+	}
+	offset := uint32(len(s.data))
+	s.data = append(append(s.data, str...), 0)
+	s.offsets[str] = offset
+	return offset
+}
+
+// elfOutSection is a section of an object file being written.
+type elfOutSection struct {
+	name   string
+	header elf.Section64
+	data   []byte
+}
+
+// mapsBTF returns the BTF of the definitions of `maps` in the .maps section,
+// as the __uint macro of libbpf declares them, with the size of a
+// definition.
+func mapsBTF(maps []*ELFMap) ([]byte, uint32) {
+	b := btf.NewBuilder()
+	intType := b.Int("int", 4, btf.IntSigned)
+	field := func(value uint32) btf.TypeID {
+		return b.Pointer(b.Array(intType, intType, value))
+	}
+	names := []string{"type", "key_size", "value_size", "max_entries", "map_flags"}
+	size := uint32(8 * len(names))
+
+	var vars []btf.SecInfo
+	for i, m := range maps {
+		values := []uint32{uint32(m.Type), m.KeySize, m.ValueSize, uint32(m.MaxEntries), m.Flags}
+		var members []btf.Member
+		for j, name := range names {
+			members = append(members, btf.Member{Name: name, Type: field(values[j]), Offset: uint32(64 * j)})
+		}
+		def := b.Struct("", size, members...)
+		vars = append(vars, btf.SecInfo{
+			Type:   b.Var(m.Name, def, btf.VarGlobalAllocated),
+			Offset: uint32(i) * size,
+			Size:   size,
+		})
+	}
+	b.Datasec(btfMapsSection, uint32(len(maps))*size, vars...)
+	return b.Encode(), size
+}
+
+// EncodeELF returns an object file with the programs and the maps of `obj`
+// that bpftool and libbpf can load, see ParseELF for the reverse. Each
+// program gets a section named after its type, e.g. "socket", with the
+// functions it calls in .text. The maps are defined in .maps and the license
+// is GPL.
+func EncodeELF(obj *ELFObject) ([]byte, error) {
+	var layouts []*elfLayout
+	var text []uint64
+	var textRelocs []elfRelocation
+	for _, prog := range obj.Programs {
+		if _, ok := programSections[prog.ProgramType]; !ok {
+			return nil, fmt.Errorf("program %s of type %d has no section libbpf knows", prog.Name, prog.ProgramType)
+		}
+		slots, err := ebpf.EncodeInstructionsFor(prog.Program, ebpf.LittleEndian)
+		if err != nil {
+			return nil, err
+		}
+		starts, err := subprogramStarts(slots)
+		if err != nil {
+			return nil, fmt.Errorf("program %s: %v", prog.Name, err)
+		}
+		first := len(slots)
+		if len(starts) > 0 {
+			first = starts[0]
+		}
+		textBase := len(text)
+
+		l := &elfLayout{}
+		if l.entryRelocs, err = relocate(slots, 0, first, first, textBase, len(obj.Maps)); err != nil {
+			return nil, fmt.Errorf("program %s: %v", prog.Name, err)
+		}
+		relocs, err := relocate(slots, first, len(slots), first, textBase, len(obj.Maps))
+		if err != nil {
+			return nil, fmt.Errorf("program %s: %v", prog.Name, err)
+		}
+		for _, r := range relocs {
+			r.slot += textBase
+			textRelocs = append(textRelocs, r)
+		}
+		l.entry = slots[:first]
+		for i, start := range starts {
+			end := len(slots)
+			if i+1 < len(starts) {
+				end = starts[i+1]
+			}
+			l.subprograms = append(l.subprograms, [2]int{textBase + start - first, end - start})
+		}
+		text = append(text, slots[first:]...)
+		layouts = append(layouts, l)
+	}
+
+	strs := &elfStrings{data: []byte{0}, offsets: map[string]uint32{"": 0}}
+	// The symbol table comes right after the string table so the
+	// relocation sections can link to it before it is filled.
+	const strtabIndex, symtabIndex = 1, 2
+	sections := []*elfOutSection{
+		{},
+		{name: ".strtab", header: elf.Section64{Type: uint32(elf.SHT_STRTAB), Addralign: 1}},
+		{name: ".symtab", header: elf.Section64{Type: uint32(elf.SHT_SYMTAB), Link: strtabIndex, Addralign: 8, Entsize: 24}},
+	}
+	add := func(name string, typ elf.SectionType, flags elf.SectionFlag, align uint64, data []byte) int {
+		sections = append(sections, &elfOutSection{
+			name:   name,
+			header: elf.Section64{Type: uint32(typ), Flags: uint64(flags), Addralign: align},
+			data:   data,
+		})
+		return len(sections) - 1
+	}
+	encode := func(slots []uint64) []byte {
+		data := make([]byte, 0, 8*len(slots))
+		for _, slot := range slots {
+			data = binary.LittleEndian.AppendUint64(data, slot)
+		}
+		return data
+	}
+
+	// The symbol table starts with the null symbol and the local symbols,
+	// the section symbol of .text is the first one of them.
+	symbols := []elf.Sym64{{}}
+	var globals []elf.Sym64
+	symbol := func(name string, typ elf.SymType, bind elf.SymBind, section int, value, size uint64) elf.Sym64 {
+		return elf.Sym64{Name: strs.add(name), Info: elf.ST_INFO(bind, typ), Shndx: uint16(section), Value: value, Size: size}
+	}
+	relocated := make(map[int][]elfRelocation)
+	for i, l := range layouts {
+		prog := obj.Programs[i]
+		section := add(programSections[prog.ProgramType], elf.SHT_PROGBITS, elf.SHF_ALLOC|elf.SHF_EXECINSTR, 8, encode(l.entry))
+		relocated[section] = l.entryRelocs
+		globals = append(globals, symbol(prog.Name, elf.STT_FUNC, elf.STB_GLOBAL, section, 0, uint64(8*len(l.entry))))
+	}
+	if len(text) > 0 {
+		section := add(textSection, elf.SHT_PROGBITS, elf.SHF_ALLOC|elf.SHF_EXECINSTR, 8, encode(text))
+		relocated[section] = textRelocs
+		symbols = append(symbols, symbol("", elf.STT_SECTION, elf.STB_LOCAL, section, 0, 0))
+		for i, l := range layouts {
+			for j, sub := range l.subprograms {
+				name := fmt.Sprintf("%s_%d", obj.Programs[i].Name, j+1)
+				symbols = append(symbols, symbol(name, elf.STT_FUNC, elf.STB_LOCAL, section, uint64(8*sub[0]), uint64(8*sub[1])))
+			}
+		}
+	}
+	license := add(licenseSection, elf.SHT_PROGBITS, elf.SHF_ALLOC|elf.SHF_WRITE, 1, append([]byte(elfLicense), 0))
+	globals = append(globals, symbol("_license", elf.STT_OBJECT, elf.STB_GLOBAL, license, 0, uint64(len(elfLicense)+1)))
+	firstGlobal := len(symbols)
+	mapSymbols := make([]int, len(obj.Maps))
+	if len(obj.Maps) > 0 {
+		blob, size := mapsBTF(obj.Maps)
+		maps := add(btfMapsSection, elf.SHT_PROGBITS, elf.SHF_ALLOC|elf.SHF_WRITE, 8, make([]byte, len(obj.Maps)*int(size)))
+		for i, m := range obj.Maps {
+			mapSymbols[i] = firstGlobal + len(globals)
+			globals = append(globals, symbol(m.Name, elf.STT_OBJECT, elf.STB_GLOBAL, maps, uint64(i)*uint64(size), uint64(size)))
+		}
+		add(".BTF", elf.SHT_PROGBITS, 0, 4, blob)
+	}
+	symbols = append(symbols, globals...)
+
+	for section := range sections {
+		relocs := relocated[section]
+		if len(relocs) == 0 {
+			continue
+		}
+		var data []byte
+		for _, r := range relocs {
+			sym := 1
+			if r.mapIndex >= 0 {
+				sym = mapSymbols[r.mapIndex]
+			}
+			data = binary.LittleEndian.AppendUint64(data, uint64(8*r.slot))
+			data = binary.LittleEndian.AppendUint64(data, uint64(sym)<<32|uint64(r.typ))
+		}
+		rel := add(".rel"+sections[section].name, elf.SHT_REL, elf.SHF_INFO_LINK, 8, data)
+		sections[rel].header.Link = symtabIndex
+		sections[rel].header.Info = uint32(section)
+		sections[rel].header.Entsize = 16
+	}
+
+	var symtab bytes.Buffer
+	for _, sym := range symbols {
+		binary.Write(&symtab, binary.LittleEndian, sym)
+	}
+	sections[symtabIndex].data = symtab.Bytes()
+	sections[symtabIndex].header.Info = uint32(firstGlobal)
+	for _, s := range sections[1:] {
+		s.header.Name = strs.add(s.name)
+	}
+	sections[strtabIndex].data = strs.data
+
+	// The header, the contents of the sections and their headers.
+	var out bytes.Buffer
+	out.Write(make([]byte, 64))
+	for _, s := range sections[1:] {
+		for out.Len()%8 != 0 {
+			out.WriteByte(0)
+		}
+		s.header.Off = uint64(out.Len())
+		s.header.Size = uint64(len(s.data))
+		out.Write(s.data)
+	}
+	for out.Len()%8 != 0 {
+		out.WriteByte(0)
+	}
+	header := elf.Header64{
+		Type:      uint16(elf.ET_REL),
+		Machine:   uint16(elf.EM_BPF),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     uint64(out.Len()),
+		Ehsize:    64,
+		Shentsize: 64,
+		Shnum:     uint16(len(sections)),
+		Shstrndx:  1,
+	}
+	copy(header.Ident[:], elf.ELFMAG)
+	header.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	header.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	header.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+	for _, s := range sections {
+		binary.Write(&out, binary.LittleEndian, s.header)
+	}
+	blob := out.Bytes()
+	var encoded bytes.Buffer
+	binary.Write(&encoded, binary.LittleEndian, header)
+	copy(blob, encoded.Bytes())
+	return blob, nil
+}
+
+// ELFObjectOf returns an object with `program`, of type `progType`, whose map
+// loads are replaced by loads of array maps like the ones the C PoCs
+// create: `mapSizes` gives the number of elements of each one of them
+// indexed by the fd the fuzzer used, 1 if missing.
+func ELFObjectOf(program *epb.Program, progType int, mapSizes map[int]uint64) *ELFObject {
+	obj := &ELFObject{}
+	indexes := make(map[int]int)
+	for _, insn := range program.Instructions {
+		mem, ok := insn.Opcode.(*epb.Instruction_MemOpcode)
+		if !ok || mem.MemOpcode.InstructionClass != epb.InsClass_InsClassLd || insn.SrcReg != ebpf.PseudoMapFD {
+			continue
+		}
+		fd := int(insn.Immediate)
+		if _, ok := indexes[fd]; ok {
+			continue
+		}
+		size, ok := mapSizes[fd]
+		if !ok {
+			size = 1
+		}
+		indexes[fd] = len(obj.Maps)
+		obj.Maps = append(obj.Maps, &ELFMap{
+			Name:       fmt.Sprintf("map_%d", len(obj.Maps)),
+			Type:       MapTypeArray,
+			KeySize:    4,
+			ValueSize:  8,
+			MaxEntries: size,
+		})
+	}
+	obj.Programs = []*ELFProgram{{
+		Name:        "buzzer_prog",
+		Section:     programSections[progType],
+		ProgramType: progType,
+		Program:     ebpf.RemapMapFds(program, indexes),
+	}}
+	return obj
+}
+
+// GenerateELF writes the object file of `obj`, see EncodeELF, to a temporary
+// file and returns its path.
+func GenerateELF(obj *ELFObject) (string, error) {
+	blob, err := EncodeELF(obj)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "ebpf-poc-*.o")
+	if err != nil {
+		return "", err
+	}
+	logging.Infof("Writing ELF PoC %q.\n", f.Name())
+	_, err = f.Write(blob)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return f.Name(), err
+}
@@ -0,0 +1,139 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestEncodeELF(t *testing.T) {
+	blob, err := os.ReadFile(testELF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clang, err := ParseELF(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err := NewProgramBuilder().
+		LdMapByFd(R1, 1).CallLocal("f").Exit().
+		Label("f").CallLocal("g").Add64(R0, 1).Exit().
+		Label("g").LdMapByFd(R1, 0).Mov64(R0, 1).Exit().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	maps := []*ELFMap{
+		{Name: "a", Type: MapTypeArray, KeySize: 4, ValueSize: 8, MaxEntries: 2},
+		{Name: "b", Type: MapTypeHash, KeySize: 4, ValueSize: 16, MaxEntries: 8, Flags: MapFlagNoCommonLru},
+	}
+
+	tests := []struct {
+		testName string
+		obj      *ELFObject
+		wantErr  bool
+	}{
+		{
+			testName: "Object built by clang",
+			obj:      clang,
+		},
+		{
+			testName: "Functions calling functions",
+			obj: &ELFObject{
+				Programs: []*ELFProgram{{Name: "nested", Section: "tc", ProgramType: ProgTypeSchedCls, Program: nested}},
+				Maps:     maps,
+			},
+		},
+		{
+			testName: "Program type without a section",
+			obj: &ELFObject{
+				Programs: []*ELFProgram{{Name: "nested", ProgramType: 2, Program: nested}},
+				Maps:     maps,
+			},
+			wantErr: true,
+		},
+		{
+			testName: "Load of an undefined map",
+			obj: &ELFObject{
+				Programs: []*ELFProgram{{Name: "nested", Section: "tc", ProgramType: ProgTypeSchedCls, Program: nested}},
+				Maps:     maps[:1],
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			blob, err := EncodeELF(tc.obj)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("EncodeELF() succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("EncodeELF() failed: %v", err)
+			}
+			got, err := ParseELF(bytes.NewReader(blob))
+			if err != nil {
+				t.Fatalf("ParseELF() of the encoded object failed: %v", err)
+			}
+			if !reflect.DeepEqual(got.Maps, tc.obj.Maps) {
+				t.Errorf("maps = %+v, want %+v", got.Maps, tc.obj.Maps)
+			}
+			if len(got.Skipped) != 0 || len(got.Programs) != len(tc.obj.Programs) {
+				t.Fatalf("programs = %d, skipped %v, want %d", len(got.Programs), got.Skipped, len(tc.obj.Programs))
+			}
+			for i, want := range tc.obj.Programs {
+				prog := got.Programs[i]
+				if prog.Name != want.Name || prog.Section != want.Section || prog.ProgramType != want.ProgramType {
+					t.Errorf("program %d = %s in %s, want %s in %s", i, prog.Name, prog.Section, want.Name, want.Section)
+				}
+				if !protobuf.Equal(prog.Program, want.Program) {
+					t.Errorf("%s = %v, want %v", want.Name, prog.Program.Instructions, want.Program.Instructions)
+				}
+			}
+		})
+	}
+}
+
+func TestELFObjectOf(t *testing.T) {
+	prog, err := NewProgramBuilder().
+		LdMapByFd(R1, 7).LdMapByFd(R2, 5).LdMapByFd(R3, 7).Mov64(R0, 0).Exit().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj := ELFObjectOf(prog, ProgTypeSchedCls, map[int]uint64{5: 4})
+
+	wantMaps := []*ELFMap{
+		{Name: "map_0", Type: MapTypeArray, KeySize: 4, ValueSize: 8, MaxEntries: 1},
+		{Name: "map_1", Type: MapTypeArray, KeySize: 4, ValueSize: 8, MaxEntries: 4},
+	}
+	if !reflect.DeepEqual(obj.Maps, wantMaps) {
+		t.Errorf("maps = %+v, want %+v", obj.Maps, wantMaps)
+	}
+	want := &epb.Program{Instructions: []*epb.Instruction{
+		LdMapByFd(R1, 0), LdMapByFd(R2, 1), LdMapByFd(R3, 0), Mov64(R0, 0), Exit(),
+	}}
+	if len(obj.Programs) != 1 || obj.Programs[0].Section != "tc" || !protobuf.Equal(obj.Programs[0].Program, want) {
+		t.Errorf("programs = %v, want %v in tc", obj.Programs, want)
+	}
+}
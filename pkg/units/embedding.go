@@ -0,0 +1,261 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"fmt"
+
+	"buzzer/pkg/btf/btf"
+	"buzzer/pkg/corpus/corpus"
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+var (
+	// NotConfiguredError is returned by a Fuzzer used before Configure.
+	NotConfiguredError = errors.New("Fuzzer is not configured")
+
+	// UnexpectedResultError is returned by Fuzzer.Report when the result
+	// does not match the stage of the last program, e.g. an execution
+	// result for a program that was never validated.
+	UnexpectedResultError = errors.New("result does not match the stage of the program")
+)
+
+// FuzzerConfig configures a Fuzzer.
+type FuzzerConfig struct {
+	// Strategy generates the programs and checks their results. Strategies
+	// that drive classic BPF, see SeccompStrategy and SocketFilterStrategy,
+	// run their own loop and can't be embedded.
+	Strategy Strategy
+
+	// FakeMaps makes the strategy use maps emulated in memory, for drivers
+	// that don't load the programs in the process that embeds the fuzzer.
+	// Map fds in the programs are then meaningless to the kernel.
+	FakeMaps bool
+
+	// InstructionFilter, if set, drops the generated programs that use an
+	// instruction it does not allow.
+	InstructionFilter *ebpf.InstructionFilter
+
+	// FindingHooks are invoked, in order, for every finding.
+	FindingHooks []FindingHook
+
+	// Triage, if set, only reports the first finding of every signature.
+	Triage *Triage
+
+	// Corpus, if not nil, receives every program along with the results
+	// the driver reported.
+	Corpus *corpus.Writer
+}
+
+// Program is a program a Fuzzer generated, along with what the driver needs
+// to load it like buzzer would.
+type Program struct {
+	Program *epb.Program
+
+	// Encoded is the program as passed to BPF_PROG_LOAD.
+	Encoded []uint64
+
+	// ProgramType is the BPF_PROG_TYPE_* to load it as, see
+	// ProgramTypeStrategy.
+	ProgramType int
+
+	// BTF to load the program with, nil to load it without.
+	BTF *btf.Program
+}
+
+// Result is what the kernel did with the last program of a Fuzzer, only one
+// of the fields is set per report.
+type Result struct {
+	// Validation is the verdict of the verifier, reported first.
+	Validation *fpb.ValidationResult
+
+	// Execution is the result of the execution, only reported if the
+	// feedback to the validation asked for it.
+	Execution *fpb.ExecutionResult
+}
+
+// Feedback is the answer of a Fuzzer to a Result.
+type Feedback struct {
+	// Execute is true if the strategy wants the program executed, the
+	// driver then reports the execution result.
+	Execute bool
+
+	// Finding is set if the result is a finding, it was already reported
+	// like while fuzzing, i.e. to the finding hooks.
+	Finding *Finding
+}
+
+// fuzzerStage is where the last program of a Fuzzer is in its lifecycle.
+type fuzzerStage int
+
+const (
+	stageIdle fuzzerStage = iota
+	stageGenerated
+	stageValidated
+)
+
+// Fuzzer exposes the program generation and oracles of buzzer to drivers
+// that own the execution loop, e.g. a syzkaller pseudo-syscall or a research
+// harness. The driver calls Next, loads the program, reports the verdict
+// with Report, executes the program if asked to and reports the execution
+// result:
+//
+//	for {
+//		prog, err := f.Next()
+//		vres := load(prog)
+//		feedback, err := f.Report(&Result{Validation: vres})
+//		if feedback.Execute {
+//			feedback, err = f.Report(&Result{Execution: run(vres.ProgramFd)})
+//		}
+//	}
+//
+// A driver can call Next before the last program was fully reported, to
+// drop it. A Fuzzer must not be used by several goroutines at once.
+type Fuzzer struct {
+	cu    *Control
+	stage fuzzerStage
+
+	prog *epb.Program
+	vres *fpb.ValidationResult
+}
+
+// NewFuzzer returns a Fuzzer that needs to be configured.
+func NewFuzzer() *Fuzzer {
+	return &Fuzzer{}
+}
+
+// Configure prepares the fuzzer to generate programs with the strategy of
+// `config`, dropping the last program if there is one.
+func (f *Fuzzer) Configure(config FuzzerConfig) error {
+	switch config.Strategy.(type) {
+	case nil:
+		return NilStrategyError
+	case SeccompStrategy, SocketFilterStrategy:
+		return fmt.Errorf("strategy %s runs its own loop and can't be embedded", config.Strategy.Name())
+	}
+	ffi := &FFI{}
+	if config.FakeMaps {
+		ffi.Maps = NewFakeMaps()
+	}
+	cu := &Control{
+		InstructionFilter: config.InstructionFilter,
+		FindingHooks:      config.FindingHooks,
+		Triage:            config.Triage,
+		Corpus:            config.Corpus,
+	}
+	if err := cu.Init(ffi, nil, config.Strategy); err != nil {
+		return err
+	}
+	f.cu = cu
+	f.stage = stageIdle
+	return nil
+}
+
+// FFI returns the FFI the strategy uses, e.g. to read the maps it created.
+func (f *Fuzzer) FFI() *FFI {
+	if f.cu == nil {
+		return nil
+	}
+	return f.cu.ffi
+}
+
+// IsDone returns true once the strategy is done fuzzing.
+func (f *Fuzzer) IsDone() bool {
+	return f.cu != nil && f.cu.strat.IsFuzzingDone()
+}
+
+// Next generates a program. Programs that fail to generate or encode are
+// skipped as long as the strategy wants to continue on errors.
+func (f *Fuzzer) Next() (*Program, error) {
+	if f.cu == nil {
+		return nil, NotConfiguredError
+	}
+	cu := f.cu
+	f.stage = stageIdle
+	for !cu.strat.IsFuzzingDone() {
+		prog, err := cu.generateProgram()
+		if err != nil {
+			if !cu.strat.OnError(err) {
+				return nil, err
+			}
+			continue
+		}
+		cu.countProgram()
+		if cu.InstructionFilter.FirstBlocked(prog) >= 0 {
+			continue
+		}
+		encoded, err := ebpf.EncodeInstructions(prog)
+		if err != nil {
+			if !cu.strat.OnError(err) {
+				return nil, err
+			}
+			continue
+		}
+
+		res := &Program{Program: prog, Encoded: encoded, ProgramType: cu.programType()}
+		if bs, ok := cu.strat.(BTFStrategy); ok {
+			res.BTF = bs.ProgramBTF()
+		}
+		f.prog = prog
+		f.vres = nil
+		f.stage = stageGenerated
+		return res, nil
+	}
+	return nil, fmt.Errorf("strategy %s is done fuzzing", cu.strat.Name())
+}
+
+// Report passes `result`, what the kernel did with the last program, to the
+// strategy and returns whether the program should be executed and the
+// finding it caused, if any.
+func (f *Fuzzer) Report(result *Result) (*Feedback, error) {
+	if f.cu == nil {
+		return nil, NotConfiguredError
+	}
+	cu := f.cu
+	switch {
+	case result.Validation != nil && f.stage == stageGenerated:
+		vres := result.Validation
+		f.vres = vres
+		if !cu.strat.OnVerifyDone(cu.ffi, vres) || !vres.GetIsValid() {
+			cu.recordCorpusEntry(f.prog, vres, nil)
+			f.stage = stageIdle
+			return &Feedback{}, nil
+		}
+		f.stage = stageValidated
+		return &Feedback{Execute: true}, nil
+
+	case result.Execution != nil && f.stage == stageValidated:
+		f.stage = stageIdle
+		cu.countExecution()
+		cu.recordCorpusEntry(f.prog, f.vres, result.Execution)
+		result.Execution.VerifierLog = f.vres.GetVerifierLog()
+		if cu.strat.OnExecuteDone(cu.ffi, result.Execution) {
+			return &Feedback{}, nil
+		}
+		finding := &Finding{
+			Description:      "Program produced unexpected results",
+			Oracle:           OracleExecution,
+			Program:          f.prog,
+			ValidationResult: f.vres,
+			ExecutionResult:  result.Execution,
+		}
+		cu.reportFinding(finding)
+		return &Feedback{Finding: finding}, nil
+	}
+	return nil, UnexpectedResultError
+}
@@ -0,0 +1,171 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// returnStrategy generates programs that return 0, it expects them to.
+type returnStrategy struct {
+	idleStrategy
+}
+
+func (s *returnStrategy) GenerateProgram(ffi *FFI) (*epb.Program, error) {
+	s.attempts++
+	insn, err := ebpf.InstructionSequence(ebpf.Mov64(ebpf.R0, 0), ebpf.Exit())
+	return &epb.Program{Instructions: insn}, err
+}
+
+func (s *returnStrategy) OnVerifyDone(ffi *FFI, verificationResult *fpb.ValidationResult) bool {
+	return true
+}
+
+func (s *returnStrategy) OnExecuteDone(ffi *FFI, executionResult *fpb.ExecutionResult) bool {
+	return executionResult.GetRetval() == 0
+}
+
+// collectingHook keeps the findings it is invoked with.
+type collectingHook struct {
+	findings []*Finding
+}
+
+func (h *collectingHook) OnFinding(f *Finding) error {
+	h.findings = append(h.findings, f)
+	return nil
+}
+
+func TestFuzzer(t *testing.T) {
+	f := NewFuzzer()
+	if _, err := f.Next(); !errors.Is(err, NotConfiguredError) {
+		t.Fatalf("Next() before Configure() error = %v, want %v", err, NotConfiguredError)
+	}
+	if err := f.Configure(FuzzerConfig{}); !errors.Is(err, NilStrategyError) {
+		t.Fatalf("Configure() without strategy error = %v, want %v", err, NilStrategyError)
+	}
+
+	hook := &collectingHook{}
+	if err := f.Configure(FuzzerConfig{
+		Strategy:     &returnStrategy{idleStrategy{name: "return", programs: 3}},
+		FakeMaps:     true,
+		FindingHooks: []FindingHook{hook},
+	}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+
+	tests := []struct {
+		testName    string
+		validation  *fpb.ValidationResult
+		execution   *fpb.ExecutionResult
+		wantExecute bool
+		wantFinding bool
+	}{
+		{
+			testName:   "Rejected",
+			validation: VerifierRejection("R0 !read_ok"),
+		},
+		{
+			testName:    "Expected result",
+			validation:  VerifierAcceptance(),
+			execution:   &fpb.ExecutionResult{DidSucceed: true},
+			wantExecute: true,
+		},
+		{
+			testName:    "Unexpected result",
+			validation:  VerifierAcceptance(),
+			execution:   &fpb.ExecutionResult{DidSucceed: true, Retval: 1},
+			wantExecute: true,
+			wantFinding: true,
+		},
+	}
+
+	for _, c := range tests {
+		t.Run(c.testName, func(t *testing.T) {
+			prog, err := f.Next()
+			if err != nil {
+				t.Fatalf("Next() error = %v", err)
+			}
+			if len(prog.Encoded) != 2 || prog.ProgramType != ProgTypeSocketFilter {
+				t.Errorf("Next() = %d instructions of type %d, want 2 of type %d", len(prog.Encoded), prog.ProgramType, ProgTypeSocketFilter)
+			}
+			if _, err := f.Report(&Result{Execution: &fpb.ExecutionResult{}}); !errors.Is(err, UnexpectedResultError) {
+				t.Errorf("Report() of an execution before the validation error = %v, want %v", err, UnexpectedResultError)
+			}
+
+			feedback, err := f.Report(&Result{Validation: c.validation})
+			if err != nil {
+				t.Fatalf("Report() of the validation error = %v", err)
+			}
+			if feedback.Execute != c.wantExecute {
+				t.Fatalf("Report() of the validation Execute = %v, want %v", feedback.Execute, c.wantExecute)
+			}
+			if !feedback.Execute {
+				return
+			}
+
+			feedback, err = f.Report(&Result{Execution: c.execution})
+			if err != nil {
+				t.Fatalf("Report() of the execution error = %v", err)
+			}
+			if got := feedback.Finding != nil; got != c.wantFinding {
+				t.Errorf("Report() of the execution Finding = %v, want one: %v", feedback.Finding, c.wantFinding)
+			}
+			if feedback.Finding != nil {
+				for _, path := range feedback.Finding.ReproPaths {
+					os.Remove(path)
+				}
+			}
+		})
+	}
+
+	if len(hook.findings) != 1 || hook.findings[0].Oracle != OracleExecution {
+		t.Errorf("finding hook got %v, want the unexpected result", hook.findings)
+	}
+	if len(hook.findings) == 1 && hook.findings[0].ExecutionResult.GetVerifierLog() != VerifierAcceptance().VerifierLog {
+		t.Errorf("execution result of the finding = %v, want the verifier log", hook.findings[0].ExecutionResult)
+	}
+	if !f.IsDone() {
+		t.Errorf("IsDone() = false after every program of the strategy")
+	}
+	if _, err := f.Next(); err == nil {
+		t.Errorf("Next() once the strategy is done error = nil")
+	}
+}
+
+func TestFuzzerValidateProgram(t *testing.T) {
+	f := NewFuzzer()
+	if err := f.Configure(FuzzerConfig{Strategy: &returnStrategy{idleStrategy{name: "return", programs: 1}}}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	prog, err := f.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	// The FFI of an embedded fuzzer has no metrics, whether the kernel
+	// accepts the program depends on the privileges of the test.
+	vres, err := f.FFI().ValidateProgram(prog.Encoded)
+	if err != nil {
+		t.Skipf("ValidateProgram() error = %v, the kernel could not be reached", err)
+	}
+	if vres == nil {
+		t.Errorf("ValidateProgram() = nil, want a result")
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// hasRetval returns true if the executions of the programs of the strategy
+// report the value R0 held when they exited, only test runs do.
+func (cu *Control) hasRetval() bool {
+	if _, ok := cu.strat.(TracepointStrategy); ok {
+		return false
+	}
+	if cu.Xdp != nil && cu.programType() == ProgTypeXdp {
+		return false
+	}
+	if cu.Cgroup != nil && IsCgroupProgramType(cu.programType()) {
+		return false
+	}
+	return cu.TestRun || cu.programType() != ProgTypeSocketFilter
+}
+
+// exitCodeOracle flags the execution of an arithmetic only program, see
+// ebpf.EvaluateAlu, that returned another value than the one computed in Go.
+// Such a mismatch is a miscompilation of the JIT or a bug of the
+// interpreter.
+type exitCodeOracle struct {
+	cu *Control
+}
+
+func (eo *exitCodeOracle) Name() string {
+	return OracleExitCode
+}
+
+func (eo *exitCodeOracle) Inspect(prog *epb.Program, exRes *fpb.ExecutionResult) Verdict {
+	if !exRes.GetDidSucceed() || !eo.cu.hasRetval() {
+		return Pass
+	}
+	want, err := ebpf.EvaluateAlu(prog)
+	if err != nil {
+		return Pass
+	}
+	// The return value of test runs is 32 bits wide.
+	if exRes.GetRetval() == uint32(want) {
+		return Pass
+	}
+	return Flag("Program returned %#x, want %#x", exRes.GetRetval(), uint32(want))
+}
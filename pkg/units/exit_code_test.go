@@ -0,0 +1,96 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestCheckExitCode(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	arithmetic := &epb.Program{Instructions: []*epb.Instruction{
+		ebpf.Mov64(ebpf.R0, -1),
+		ebpf.Add(ebpf.R0, 3),
+		ebpf.Exit(),
+	}}
+	memory := &epb.Program{Instructions: []*epb.Instruction{
+		ebpf.LdDW(ebpf.R0, ebpf.R10, -8),
+		ebpf.Exit(),
+	}}
+
+	tests := []struct {
+		testName    string
+		testRun     bool
+		prog        *epb.Program
+		execution   *fpb.ExecutionResult
+		wantFinding bool
+	}{
+		{
+			testName:    "Expected value",
+			testRun:     true,
+			prog:        arithmetic,
+			execution:   &fpb.ExecutionResult{DidSucceed: true, Retval: 2},
+			wantFinding: false,
+		},
+		{
+			testName:    "Miscomputed value",
+			testRun:     true,
+			prog:        arithmetic,
+			execution:   &fpb.ExecutionResult{DidSucceed: true, Retval: 3},
+			wantFinding: true,
+		},
+		{
+			testName:    "Execution without a return value",
+			testRun:     false,
+			prog:        arithmetic,
+			execution:   &fpb.ExecutionResult{DidSucceed: true},
+			wantFinding: false,
+		},
+		{
+			testName:    "Failed execution",
+			testRun:     true,
+			prog:        arithmetic,
+			execution:   &fpb.ExecutionResult{DidSucceed: false},
+			wantFinding: false,
+		},
+		{
+			testName:    "Program that accesses memory",
+			testRun:     true,
+			prog:        memory,
+			execution:   &fpb.ExecutionResult{DidSucceed: true, Retval: 3},
+			wantFinding: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			hook := &collectingHook{}
+			cu := &Control{FindingHooks: []FindingHook{hook}, TestRun: tc.testRun}
+			cu.Init(&FFI{Maps: NewFakeMaps()}, nil, &returnStrategy{})
+			cu.inspectExecution(tc.prog, VerifierAcceptance(), tc.execution, []Oracle{&exitCodeOracle{cu: cu}})
+			if got := len(hook.findings) > 0; got != tc.wantFinding {
+				t.Fatalf("finding reported = %v, want %v", got, tc.wantFinding)
+			}
+			if tc.wantFinding && hook.findings[0].Oracle != OracleExitCode {
+				t.Errorf("Oracle = %v, want %v", hook.findings[0].Oracle, OracleExitCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"sort"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// fakeMapFdBase is the first fd handed out by FakeMaps, far above the fds a
+// process usually has open so fake and real fds never get mixed up.
+const fakeMapFdBase = 1 << 20
+
+// fakeMap is a map emulated by FakeMaps.
+type fakeMap struct {
+	array      bool
+	maxEntries uint64
+	elements   map[uint32]uint64
+	frozen     bool
+}
+
+// FakeMaps emulates in memory the maps an FFI creates, so strategies can run
+// without a kernel. Array elements start at 0, like in the kernel, and hash
+// maps refuse new keys once full. The elements of maps of maps are the fds
+// of their inner maps.
+type FakeMaps struct {
+	nextFd int
+	maps   map[int]*fakeMap
+
+	// pins are the maps pinned by path, they survive the close of their
+	// fds.
+	pins map[string]*fakeMap
+}
+
+// NewFakeMaps returns an emulation without any map.
+func NewFakeMaps() *FakeMaps {
+	return &FakeMaps{nextFd: fakeMapFdBase, maps: make(map[int]*fakeMap), pins: make(map[string]*fakeMap)}
+}
+
+func (fm *FakeMaps) create(array bool, maxEntries uint64) int {
+	return fm.open(&fakeMap{array: array, maxEntries: maxEntries, elements: make(map[uint32]uint64)})
+}
+
+// open returns a new fd of `m`.
+func (fm *FakeMaps) open(m *fakeMap) int {
+	fd := fm.nextFd
+	fm.nextFd++
+	fm.maps[fd] = m
+	return fd
+}
+
+func (fm *FakeMaps) pin(fd int, path string) int {
+	m, ok := fm.maps[fd]
+	if _, pinned := fm.pins[path]; !ok || pinned {
+		return -1
+	}
+	fm.pins[path] = m
+	return 0
+}
+
+func (fm *FakeMaps) getPinned(path string) int {
+	m, ok := fm.pins[path]
+	if !ok {
+		return -1
+	}
+	return fm.open(m)
+}
+
+func (fm *FakeMaps) close(fd int) {
+	delete(fm.maps, fd)
+}
+
+func (fm *FakeMaps) lookup(fd int, key uint32) (uint64, bool) {
+	m, ok := fm.maps[fd]
+	if !ok {
+		return 0, false
+	}
+	if m.array {
+		return m.elements[key], uint64(key) < m.maxEntries
+	}
+	value, ok := m.elements[key]
+	return value, ok
+}
+
+func (fm *FakeMaps) getElements(fd int, mapSize uint64) *fpb.MapElements {
+	res := &fpb.MapElements{}
+	for key := uint64(0); key < mapSize; key++ {
+		value, ok := fm.lookup(fd, uint32(key))
+		if !ok {
+			return &fpb.MapElements{ErrorMessage: "No such file or directory"}
+		}
+		res.Elements = append(res.Elements, value)
+	}
+	return res
+}
+
+func (fm *FakeMaps) update(fd int, key uint32, value uint64) int {
+	m, ok := fm.maps[fd]
+	if !ok || m.frozen {
+		return -1
+	}
+	if m.array && uint64(key) >= m.maxEntries {
+		return -1
+	}
+	if _, present := m.elements[key]; !m.array && !present && uint64(len(m.elements)) >= m.maxEntries {
+		return -1
+	}
+	m.elements[key] = value
+	return 0
+}
+
+func (fm *FakeMaps) updateInner(fd int, key uint32, innerFd int) int {
+	if _, ok := fm.maps[innerFd]; !ok {
+		return -1
+	}
+	return fm.update(fd, key, uint64(innerFd))
+}
+
+func (fm *FakeMaps) delete(fd int, key uint32) int {
+	m, ok := fm.maps[fd]
+	if !ok || m.array || m.frozen {
+		return -1
+	}
+	if _, present := m.elements[key]; !present {
+		return -1
+	}
+	delete(m.elements, key)
+	return 0
+}
+
+func (fm *FakeMaps) clear(fd int) int {
+	m, ok := fm.maps[fd]
+	if !ok || m.array || m.frozen {
+		return -1
+	}
+	deleted := len(m.elements)
+	m.elements = make(map[uint32]uint64)
+	return deleted
+}
+
+func (fm *FakeMaps) freeze(fd int) int {
+	m, ok := fm.maps[fd]
+	if !ok {
+		return -1
+	}
+	m.frozen = true
+	return 0
+}
+
+// Keys returns the keys present in the map described by `fd`, in increasing
+// order. Every key of an array map is present.
+func (fm *FakeMaps) Keys(fd int) []uint32 {
+	m, ok := fm.maps[fd]
+	if !ok {
+		return nil
+	}
+	var keys []uint32
+	if m.array {
+		for key := uint64(0); key < m.maxEntries; key++ {
+			keys = append(keys, uint32(key))
+		}
+		return keys
+	}
+	for key := range m.elements {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// Fds returns the fds of the maps that are open, in increasing order.
+func (fm *FakeMaps) Fds() []int {
+	var fds []int
+	for fd := range fm.maps {
+		fds = append(fds, fd)
+	}
+	sort.Ints(fds)
+	return fds
+}
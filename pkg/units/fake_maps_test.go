@@ -0,0 +1,103 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFakeMaps(t *testing.T) {
+	ffi := &FFI{Maps: NewFakeMaps()}
+
+	array := ffi.CreateMapArray(2)
+	if got := ffi.SetMapElement(array, 1, 42); got != 0 {
+		t.Errorf("SetMapElement(array, 1) = %d, want 0", got)
+	}
+	if got := ffi.SetMapElement(array, 2, 42); got != -1 {
+		t.Errorf("SetMapElement(array, 2) = %d, want -1", got)
+	}
+	elements, err := ffi.GetMapElements(array, 2)
+	if err != nil || !reflect.DeepEqual(elements.GetElements(), []uint64{0, 42}) {
+		t.Errorf("GetMapElements(array) = %v, %v, want [0 42]", elements.GetElements(), err)
+	}
+
+	hash := ffi.CreateMapHash(MapTypeHash, 2, 0)
+	for _, key := range []uint32{7, 3} {
+		if got := ffi.SetMapElement(hash, key, uint64(key)); got != 0 {
+			t.Errorf("SetMapElement(hash, %d) = %d, want 0", key, got)
+		}
+	}
+	if got := ffi.SetMapElement(hash, 5, 5); got != -1 {
+		t.Errorf("SetMapElement() on a full hash map = %d, want -1", got)
+	}
+	if got := ffi.Maps.Keys(hash); !reflect.DeepEqual(got, []uint32{3, 7}) {
+		t.Errorf("Keys(hash) = %v, want [3 7]", got)
+	}
+	if got := ffi.DeleteMapElement(hash, 3); got != 0 {
+		t.Errorf("DeleteMapElement(hash, 3) = %d, want 0", got)
+	}
+	if elements, _ := ffi.GetMapElements(hash, 1); elements.GetErrorMessage() == "" {
+		t.Errorf("GetMapElements() of a missing key = %v, want an error message", elements)
+	}
+	if got := ffi.ClearMap(hash); got != 1 {
+		t.Errorf("ClearMap(hash) = %d, want 1", got)
+	}
+
+	if got := ffi.FreezeMap(array); got != 0 {
+		t.Errorf("FreezeMap(array) = %d, want 0", got)
+	}
+	if got := ffi.SetMapElement(array, 0, 1); got != -1 {
+		t.Errorf("SetMapElement() on a frozen map = %d, want -1", got)
+	}
+
+	ffi.CloseFD(array)
+	if got := ffi.Maps.Fds(); !reflect.DeepEqual(got, []int{hash}) {
+		t.Errorf("Fds() = %v, want [%d]", got, hash)
+	}
+}
+
+func TestFakeMapsOfMaps(t *testing.T) {
+	ffi := &FFI{Maps: NewFakeMaps()}
+
+	inner := ffi.CreateMapArray(4)
+	outer := ffi.CreateMapOfMaps(MapTypeHashOfMaps, inner, 2, 0)
+	if got := ffi.SetInnerMap(outer, 9, inner); got != 0 {
+		t.Errorf("SetInnerMap(outer, 9) = %d, want 0", got)
+	}
+	if got := ffi.SetInnerMap(outer, 1, inner+100); got != -1 {
+		t.Errorf("SetInnerMap() of a closed map = %d, want -1", got)
+	}
+	if got := ffi.Maps.Keys(outer); !reflect.DeepEqual(got, []uint32{9}) {
+		t.Errorf("Keys(outer) = %v, want [9]", got)
+	}
+
+	if got := ffi.PinObject(inner, "/sys/fs/bpf/inner"); got != 0 {
+		t.Errorf("PinObject(inner) = %d, want 0", got)
+	}
+	if got := ffi.PinObject(outer, "/sys/fs/bpf/inner"); got != -1 {
+		t.Errorf("PinObject() at a used path = %d, want -1", got)
+	}
+	ffi.SetMapElement(inner, 3, 42)
+	ffi.CloseFD(inner)
+	pinned := ffi.GetPinnedObject("/sys/fs/bpf/inner")
+	elements, err := ffi.GetMapElements(pinned, 4)
+	if err != nil || !reflect.DeepEqual(elements.GetElements(), []uint64{0, 0, 0, 42}) {
+		t.Errorf("GetMapElements() of the pinned map = %v, %v, want [0 0 0 42]", elements.GetElements(), err)
+	}
+	if got := ffi.GetPinnedObject("/sys/fs/bpf/missing"); got != -1 {
+		t.Errorf("GetPinnedObject() of a missing pin = %d, want -1", got)
+	}
+}
@@ -22,18 +22,45 @@ package units
 //  size_t size;
 //};
 //struct bpf_result ffi_load_bpf_program(void* prog_buff, size_t size, int coverage_enabled, unsigned long coverage_size);
+//struct bpf_result ffi_load_bpf_program_of_type(void* prog_buff, size_t size, int prog_type, uint32_t prog_flags, int coverage_enabled, unsigned long coverage_size);
+//struct bpf_result ffi_load_bpf_program_with_btf(void* prog_buff, size_t size, void* btf, size_t btf_size, void* func_info, uint32_t func_info_cnt, void* line_info, uint32_t line_info_cnt, int coverage_enabled, unsigned long coverage_size);
+//struct bpf_result ffi_load_bpf_program_with_log_level(void* prog_buff, size_t size, uint32_t log_level);
 //struct bpf_result ffi_execute_bpf_program(void* serialized_proto, size_t length);
+//struct bpf_result ffi_test_run_bpf_program(void* serialized_proto, size_t length);
+//struct bpf_result ffi_run_batch(void* serialized_proto, size_t length);
 //struct bpf_result ffi_get_map_elements(int map_fd, uint64_t map_size);
+//struct bpf_result ffi_get_map_value(int map_fd, uint32_t key, uint64_t words);
+//struct bpf_result ffi_consume_ringbuf(int map_fd, uint64_t size);
 //int ffi_create_bpf_map(size_t size);
+//int ffi_create_hash_map(int map_type, size_t max_entries, uint32_t flags);
+//int ffi_create_map(int map_type, uint32_t key_size, uint32_t value_size, size_t max_entries, uint32_t flags);
+//int ffi_create_map_with_btf(int map_type, uint32_t key_size, uint32_t value_size, size_t max_entries, uint32_t flags, void *btf, size_t btf_size, uint32_t key_type_id, uint32_t value_type_id);
+//int ffi_create_map_of_maps(int map_type, int inner_map_fd, size_t max_entries, uint32_t flags);
 //void ffi_close_fd(int fd);
 //int ffi_update_map_element(int map_fd, int key, uint64_t value);
+//int ffi_update_map_of_maps(int map_fd, int key, int inner_map_fd);
+//int ffi_delete_map_element(int map_fd, int key);
+//int ffi_clear_map(int map_fd);
+//struct bpf_result ffi_get_program_info(int prog_fd);
+//int ffi_get_pinned_object(const char* path);
+//int ffi_pin_object(int fd, const char* path);
+//int ffi_freeze_map(int map_fd);
+//struct bpf_result ffi_run_seccomp_filter(void* serialized_proto, size_t length);
+//struct bpf_result ffi_run_socket_filter(void* serialized_proto, size_t length);
+//struct bpf_result ffi_run_xdp(void* serialized_proto, size_t length);
+//struct bpf_result ffi_run_cgroup(void* serialized_proto, size_t length);
+//int ffi_create_bpf_token(void);
+//void ffi_set_thread_bpf_token(int token_fd);
 import "C"
 
 import (
 	"encoding/base64"
 	"fmt"
+	"runtime"
+	"syscall"
 	"unsafe"
 
+	"buzzer/pkg/btf/btf"
 	fpb "buzzer/proto/ffi_go_proto"
 	"github.com/golang/protobuf/proto"
 )
@@ -102,9 +129,73 @@ func mapElementsProtoFromStruct(s *C.struct_bpf_result) (*fpb.MapElements, error
 	return res, nil
 }
 
+func ringbufRecordsProtoFromStruct(s *C.struct_bpf_result) (*fpb.RingbufRecords, error) {
+	data, err := protoDataFromStruct(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := &fpb.RingbufRecords{}
+	if err := proto.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func programInfoProtoFromStruct(s *C.struct_bpf_result) (*fpb.ProgramInfo, error) {
+	data, err := protoDataFromStruct(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := &fpb.ProgramInfo{}
+	if err := proto.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func seccompProtoFromStruct(s *C.struct_bpf_result) (*fpb.SeccompResult, error) {
+	data, err := protoDataFromStruct(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := &fpb.SeccompResult{}
+	if err := proto.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+func socketFilterProtoFromStruct(s *C.struct_bpf_result) (*fpb.SocketFilterResult, error) {
+	data, err := protoDataFromStruct(s)
+
+	if err != nil {
+		return nil, err
+	}
+
+	res := &fpb.SocketFilterResult{}
+	if err := proto.Unmarshal(data, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
 // FFI is the unit that will talk to ebpf and run/validate programs.
 type FFI struct {
 	MetricsUnit *Metrics
+
+	// Maps, if not nil, emulates the maps in memory instead of creating
+	// them in the kernel, see StrategyHarness.
+	Maps *FakeMaps
 }
 
 // ValidateProgram passes the program through the bpf verifier without executing
@@ -114,20 +205,127 @@ func (e *FFI) ValidateProgram(prog []uint64) (*fpb.ValidationResult, error) {
 	if len(prog) == 0 {
 		return nil, fmt.Errorf("cannot run empty program")
 	}
-	shouldCollect, coverageSize := e.MetricsUnit.ShouldGetCoverage()
+	shouldCollect, coverageSize := e.shouldGetCoverage()
 	cbool := 0
 	if shouldCollect {
 		cbool = 1
 	}
 	bpfVerifyResult := C.ffi_load_bpf_program(unsafe.Pointer(&prog[0]), C.ulong(len(prog)) /*enable_coverage=*/, C.int(cbool) /*coverage_size=*/, C.ulong(coverageSize))
-	res, err := validationProtoFromStruct(&bpfVerifyResult)
+	return e.recordValidation(&bpfVerifyResult)
+}
+
+// ValidateProgramOfType is ValidateProgram with the program loaded as
+// `progType`, e.g. ProgTypeSchedCls, instead of a socket filter, with the
+// BPF_F_* `progFlags`, e.g. ProgFlagSleepable.
+func (e *FFI) ValidateProgramOfType(prog []uint64, progType int, progFlags uint32) (*fpb.ValidationResult, error) {
+	if len(prog) == 0 {
+		return nil, fmt.Errorf("cannot run empty program")
+	}
+	shouldCollect, coverageSize := e.shouldGetCoverage()
+	cbool := 0
+	if shouldCollect {
+		cbool = 1
+	}
+	bpfVerifyResult := C.ffi_load_bpf_program_of_type(unsafe.Pointer(&prog[0]), C.ulong(len(prog)), C.int(progType), C.uint32_t(progFlags), C.int(cbool), C.ulong(coverageSize))
+	return e.recordValidation(&bpfVerifyResult)
+}
+
+// ValidateProgramWithBTF is ValidateProgram with the program loaded along
+// with the BTF of `b`, the coverage includes the parsing of the BTF blob. If
+// the kernel refuses the blob the program is not loaded, the result has the
+// BTF log and a bpf_error starting with "BTF: ".
+func (e *FFI) ValidateProgramWithBTF(prog []uint64, b *btf.Program) (*fpb.ValidationResult, error) {
+	if len(prog) == 0 {
+		return nil, fmt.Errorf("cannot run empty program")
+	}
+	shouldCollect, coverageSize := e.shouldGetCoverage()
+	cbool := 0
+	if shouldCollect {
+		cbool = 1
+	}
+	// cgo does not allow passing pointers to empty slices.
+	pointer := func(data []byte) unsafe.Pointer {
+		if len(data) == 0 {
+			return nil
+		}
+		return unsafe.Pointer(&data[0])
+	}
+	funcInfo, lineInfo := b.EncodeFuncInfo(), b.EncodeLineInfo()
+	bpfVerifyResult := C.ffi_load_bpf_program_with_btf(unsafe.Pointer(&prog[0]), C.ulong(len(prog)),
+		pointer(b.BTF), C.ulong(len(b.BTF)),
+		pointer(funcInfo), C.uint32_t(len(b.FuncInfo)),
+		pointer(lineInfo), C.uint32_t(len(b.LineInfo)),
+		C.int(cbool), C.ulong(coverageSize))
+	return e.recordValidation(&bpfVerifyResult)
+}
+
+// recordValidation reconstructs the result of a load done for a validation
+// and records it in the metrics.
+func (e *FFI) recordValidation(bpfVerifyResult *C.struct_bpf_result) (*fpb.ValidationResult, error) {
+	res, err := validationProtoFromStruct(bpfVerifyResult)
 	if err != nil {
 		return nil, err
 	}
-	e.MetricsUnit.RecordVerificationResults(res)
+	e.recordResult(res)
 	return res, nil
 }
 
+// shouldGetCoverage returns whether to collect the coverage of the next load
+// and its size. Without a MetricsUnit, e.g. when the fuzzer is embedded, no
+// coverage is collected.
+func (e *FFI) shouldGetCoverage() (bool, uint64) {
+	if e.MetricsUnit == nil {
+		return false, 0
+	}
+	return e.MetricsUnit.ShouldGetCoverage()
+}
+
+// recordResult records the result of a load in the metrics, if there are
+// any.
+func (e *FFI) recordResult(res *fpb.ValidationResult) {
+	if e.MetricsUnit == nil {
+		return
+	}
+	if IsTransientFailure(res) {
+		e.MetricsUnit.RecordTransientFailure(res)
+		return
+	}
+	e.MetricsUnit.RecordVerificationResults(res)
+}
+
+// LoadProgram passes the program through the bpf verifier like
+// ValidateProgram does, but without collecting coverage or recording
+// metrics. This is meant for loading a program that was already validated
+// once.
+func (e *FFI) LoadProgram(prog []uint64) (*fpb.ValidationResult, error) {
+	if len(prog) == 0 {
+		return nil, fmt.Errorf("cannot run empty program")
+	}
+	bpfVerifyResult := C.ffi_load_bpf_program(unsafe.Pointer(&prog[0]), C.ulong(len(prog)) /*enable_coverage=*/, C.int(0) /*coverage_size=*/, C.ulong(0))
+	return validationProtoFromStruct(&bpfVerifyResult)
+}
+
+// LoadProgramOfType is LoadProgram with the program loaded as `progType`
+// with `progFlags`.
+func (e *FFI) LoadProgramOfType(prog []uint64, progType int, progFlags uint32) (*fpb.ValidationResult, error) {
+	if len(prog) == 0 {
+		return nil, fmt.Errorf("cannot run empty program")
+	}
+	bpfVerifyResult := C.ffi_load_bpf_program_of_type(unsafe.Pointer(&prog[0]), C.ulong(len(prog)), C.int(progType), C.uint32_t(progFlags) /*enable_coverage=*/, C.int(0) /*coverage_size=*/, C.ulong(0))
+	return validationProtoFromStruct(&bpfVerifyResult)
+}
+
+// LoadProgramWithLogLevel is LoadProgram with the verifier log at
+// `logLevel`, a combination of the BPF_LOG_* flags. 0 loads the program
+// without a log.
+func (e *FFI) LoadProgramWithLogLevel(prog []uint64, logLevel uint32) (*fpb.ValidationResult, error) {
+	if len(prog) == 0 {
+		return nil, fmt.Errorf("cannot run empty program")
+	}
+	bpfVerifyResult := C.ffi_load_bpf_program_with_log_level(unsafe.Pointer(&prog[0]), C.ulong(len(prog)), C.uint32_t(logLevel))
+	return validationProtoFromStruct(&bpfVerifyResult)
+}
+
 // RunProgram Runs the ebpf program and returns the execution results.
 func (e *FFI) RunProgram(executionRequest *fpb.ExecutionRequest) (*fpb.ExecutionResult, error) {
 	serializedProto, err := proto.Marshal(executionRequest)
@@ -138,25 +336,288 @@ func (e *FFI) RunProgram(executionRequest *fpb.ExecutionRequest) (*fpb.Execution
 	return executionProtoFromStruct(&res)
 }
 
+// TestRunProgram runs the ebpf program with BPF_PROG_TEST_RUN on the data of
+// `testRunRequest` and returns the execution results, including the value
+// the program returned.
+func (e *FFI) TestRunProgram(testRunRequest *fpb.TestRunRequest) (*fpb.ExecutionResult, error) {
+	serializedProto, err := proto.Marshal(testRunRequest)
+	if err != nil {
+		return nil, err
+	}
+	res := C.ffi_test_run_bpf_program(unsafe.Pointer(&serializedProto[0]), C.ulong(len(serializedProto)))
+	return executionProtoFromStruct(&res)
+}
+
+// RunBatch loads the programs of `batchRequest` and test runs the ones the
+// verifier accepts in a single call, which saves the round trip and the
+// allocations of every program. Coverage is not collected, the validations
+// are recorded in the metrics.
+func (e *FFI) RunBatch(batchRequest *fpb.BatchRequest) (*fpb.BatchResult, error) {
+	if e.Maps != nil {
+		return nil, fmt.Errorf("batches of programs can only run in the kernel")
+	}
+	serializedProto, err := proto.Marshal(batchRequest)
+	if err != nil {
+		return nil, err
+	}
+	res := C.ffi_run_batch(unsafe.Pointer(&serializedProto[0]), C.ulong(len(serializedProto)))
+	data, err := protoDataFromStruct(&res)
+	if err != nil {
+		return nil, err
+	}
+	result := &fpb.BatchResult{}
+	if err := proto.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	if result.GetErrorMessage() != "" {
+		return nil, fmt.Errorf("%s", result.GetErrorMessage())
+	}
+	for _, entry := range result.GetEntries() {
+		e.recordResult(entry.GetValidation())
+	}
+	return result, nil
+}
+
 // CreateMapArray creates an ebpf map of type array and returns its fd.
 // -1 means error.
 func (e *FFI) CreateMapArray(size uint64) int {
+	if e.Maps != nil {
+		return e.Maps.create(true, size)
+	}
 	return int(C.ffi_create_bpf_map(C.ulong(size)))
 }
 
+// CreateMapHash creates an ebpf map of type `mapType`, one of the hash map
+// types, with room for `maxEntries` elements and returns its fd. Keys and
+// values have the same size as in the maps created by CreateMapArray.
+// -1 means error.
+func (e *FFI) CreateMapHash(mapType int, maxEntries uint64, flags uint32) int {
+	if e.Maps != nil {
+		return e.Maps.create(false, maxEntries)
+	}
+	return int(C.ffi_create_hash_map(C.int(mapType), C.ulong(maxEntries), C.uint32_t(flags)))
+}
+
+// CreateMap creates an ebpf map of any type with keys of `keySize` bytes and
+// values of `valueSize` bytes and returns its fd. Fake maps only model the
+// array like ones, where a key is an index.
+// -1 means error.
+func (e *FFI) CreateMap(mapType int, keySize, valueSize uint32, maxEntries uint64, flags uint32) int {
+	if e.Maps != nil {
+		return e.Maps.create(mapType == MapTypeArray || mapType == MapTypeProgArray, maxEntries)
+	}
+	return int(C.ffi_create_map(C.int(mapType), C.uint32_t(keySize), C.uint32_t(valueSize), C.ulong(maxEntries), C.uint32_t(flags)))
+}
+
+// CreateMapWithBTF is CreateMap with the keys and values described by `b`,
+// e.g. to have a struct bpf_spin_lock in the values. Fake maps ignore the
+// BTF.
+// -1 means error.
+func (e *FFI) CreateMapWithBTF(mapType int, keySize, valueSize uint32, maxEntries uint64, flags uint32, b *btf.Map) int {
+	if e.Maps != nil {
+		return e.Maps.create(mapType == MapTypeArray || mapType == MapTypeProgArray, maxEntries)
+	}
+	return int(C.ffi_create_map_with_btf(C.int(mapType), C.uint32_t(keySize), C.uint32_t(valueSize), C.ulong(maxEntries), C.uint32_t(flags),
+		unsafe.Pointer(&b.BTF[0]), C.ulong(len(b.BTF)), C.uint32_t(b.KeyType), C.uint32_t(b.ValueType)))
+}
+
+// CreateMapOfMaps creates a map of type `mapType`, MapTypeArrayOfMaps or
+// MapTypeHashOfMaps, whose values are maps like `innerFd` and returns its
+// fd.
+// -1 means error.
+func (e *FFI) CreateMapOfMaps(mapType int, innerFd int, maxEntries uint64, flags uint32) int {
+	if e.Maps != nil {
+		return e.Maps.create(mapType == MapTypeArrayOfMaps, maxEntries)
+	}
+	return int(C.ffi_create_map_of_maps(C.int(mapType), C.int(innerFd), C.ulong(maxEntries), C.uint32_t(flags)))
+}
+
 // CloseFD closes the provided file descriptor.
 func (e *FFI) CloseFD(fd int) {
+	if e.Maps != nil {
+		e.Maps.close(fd)
+		return
+	}
 	C.ffi_close_fd(C.int(fd))
 }
 
 // GetMapElements fetches the map elements of the given fd.
 func (e *FFI) GetMapElements(fd int, mapSize uint64) (*fpb.MapElements, error) {
+	if e.Maps != nil {
+		return e.Maps.getElements(fd, mapSize), nil
+	}
 	res := C.ffi_get_map_elements(C.int(fd), C.ulong(mapSize))
 	return mapElementsProtoFromStruct(&res)
 }
 
+// GetMapValue fetches the value of the element `key` of the map `fd`, which
+// is `words` 64 bit words long, one element per word. Values of fake maps are
+// single words, see GetMapElements.
+func (e *FFI) GetMapValue(fd int, key uint32, words uint64) (*fpb.MapElements, error) {
+	if e.Maps != nil {
+		return &fpb.MapElements{ErrorMessage: "fake maps only hold single word values"}, nil
+	}
+	res := C.ffi_get_map_value(C.int(fd), C.uint32_t(key), C.ulong(words))
+	return mapElementsProtoFromStruct(&res)
+}
+
+// ConsumeRingbuf reads the records the programs committed to the ring buffer
+// `fd`, whose data area has `size` bytes, since the last call. Fake maps
+// never have records.
+func (e *FFI) ConsumeRingbuf(fd int, size uint64) (*fpb.RingbufRecords, error) {
+	if e.Maps != nil {
+		return &fpb.RingbufRecords{}, nil
+	}
+	res := C.ffi_consume_ringbuf(C.int(fd), C.ulong(size))
+	return ringbufRecordsProtoFromStruct(&res)
+}
+
 // SetMapElement sets the elemnt specified by `key` to `value` in the map
 // described by `fd`
 func (e *FFI) SetMapElement(fd int, key uint32, value uint64) int {
+	if e.Maps != nil {
+		return e.Maps.update(fd, key, value)
+	}
 	return int(C.ffi_update_map_element(C.int(fd), C.int(key), C.ulong(value)))
 }
+
+// SetInnerMap sets the element specified by `key` of the map of maps
+// described by `fd` to the map `innerFd`. -1 means error.
+func (e *FFI) SetInnerMap(fd int, key uint32, innerFd int) int {
+	if e.Maps != nil {
+		return e.Maps.updateInner(fd, key, innerFd)
+	}
+	return int(C.ffi_update_map_of_maps(C.int(fd), C.int(key), C.int(innerFd)))
+}
+
+// DeleteMapElement deletes the element specified by `key` from the hash map
+// described by `fd`. -1 means error.
+func (e *FFI) DeleteMapElement(fd int, key uint32) int {
+	if e.Maps != nil {
+		return e.Maps.delete(fd, key)
+	}
+	return int(C.ffi_delete_map_element(C.int(fd), C.int(key)))
+}
+
+// ClearMap deletes every element of the hash map described by `fd` and
+// returns how many there were. -1 means error.
+func (e *FFI) ClearMap(fd int) int {
+	if e.Maps != nil {
+		return e.Maps.clear(fd)
+	}
+	return int(C.ffi_clear_map(C.int(fd)))
+}
+
+// FreezeMap makes the map described by `fd` read only from user space.
+// -1 means error.
+func (e *FFI) FreezeMap(fd int) int {
+	if e.Maps != nil {
+		return e.Maps.freeze(fd)
+	}
+	return int(C.ffi_freeze_map(C.int(fd)))
+}
+
+// GetProgramInfo fetches the information the kernel has about the loaded
+// program described by `fd`, e.g. the xlated instructions.
+func (e *FFI) GetProgramInfo(fd int) (*fpb.ProgramInfo, error) {
+	res := C.ffi_get_program_info(C.int(fd))
+	return programInfoProtoFromStruct(&res)
+}
+
+// GetPinnedObject opens the eBPF object pinned at `path` in a bpffs and
+// returns its fd, -1 means error.
+func (e *FFI) GetPinnedObject(path string) int {
+	if e.Maps != nil {
+		return e.Maps.getPinned(path)
+	}
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	return int(C.ffi_get_pinned_object(cpath))
+}
+
+// PinObject pins the eBPF object `fd`, e.g. a map, at `path` in a bpffs so it
+// outlives the process. -1 means error.
+func (e *FFI) PinObject(fd int, path string) int {
+	if e.Maps != nil {
+		return e.Maps.pin(fd, path)
+	}
+	cpath := C.CString(path)
+	defer C.free(unsafe.Pointer(cpath))
+	return int(C.ffi_pin_object(C.int(fd), cpath))
+}
+
+// RunSeccompFilter installs the cBPF filter of `seccompRequest` in a
+// sandboxed child process, lets it issue the requested system call and
+// returns what happened to it.
+func (e *FFI) RunSeccompFilter(seccompRequest *fpb.SeccompRequest) (*fpb.SeccompResult, error) {
+	if len(seccompRequest.Filter) == 0 {
+		return nil, fmt.Errorf("cannot install empty filter")
+	}
+	serializedProto, err := proto.Marshal(seccompRequest)
+	if err != nil {
+		return nil, err
+	}
+	res := C.ffi_run_seccomp_filter(unsafe.Pointer(&serializedProto[0]), C.ulong(len(serializedProto)))
+	return seccompProtoFromStruct(&res)
+}
+
+// RunSocketFilter attaches the cBPF filter of `socketFilterRequest` to a
+// socket, sends the packet of the request through it and returns what was
+// received.
+func (e *FFI) RunSocketFilter(socketFilterRequest *fpb.SocketFilterRequest) (*fpb.SocketFilterResult, error) {
+	if len(socketFilterRequest.Filter) == 0 {
+		return nil, fmt.Errorf("cannot attach empty filter")
+	}
+	serializedProto, err := proto.Marshal(socketFilterRequest)
+	if err != nil {
+		return nil, err
+	}
+	res := C.ffi_run_socket_filter(unsafe.Pointer(&serializedProto[0]), C.ulong(len(serializedProto)))
+	return socketFilterProtoFromStruct(&res)
+}
+
+// RunXdp attaches the XDP program of `xdpRequest` to its interface, injects
+// the packet of the request on the peer and returns where it showed up, see
+// XdpHarness.
+func (e *FFI) RunXdp(xdpRequest *fpb.XdpRequest) (*fpb.ExecutionResult, error) {
+	serializedProto, err := proto.Marshal(xdpRequest)
+	if err != nil {
+		return nil, err
+	}
+	res := C.ffi_run_xdp(unsafe.Pointer(&serializedProto[0]), C.ulong(len(serializedProto)))
+	return executionProtoFromStruct(&res)
+}
+
+// RunCgroup attaches the cgroup program of `cgroupRequest` to its cgroup,
+// replaces and detaches it in cycles with traffic sent from inside the cgroup
+// in between and returns whether the last packet got through, see
+// CgroupHarness.
+func (e *FFI) RunCgroup(cgroupRequest *fpb.CgroupRequest) (*fpb.ExecutionResult, error) {
+	serializedProto, err := proto.Marshal(cgroupRequest)
+	if err != nil {
+		return nil, err
+	}
+	res := C.ffi_run_cgroup(unsafe.Pointer(&serializedProto[0]), C.ulong(len(serializedProto)))
+	return executionProtoFromStruct(&res)
+}
+
+// CreateBpfToken creates a BPF token, on kernels 6.9 and later, from a bpffs
+// delegating every command and type, mounted in a new user namespace, and
+// returns its fd.
+func (e *FFI) CreateBpfToken() (int, error) {
+	fd := int(C.ffi_create_bpf_token())
+	if fd < 0 {
+		return -1, syscall.Errno(-fd)
+	}
+	return fd, nil
+}
+
+// WithBpfToken runs `f` on a thread whose program loads go through the BPF
+// token `tokenFd`.
+func (e *FFI) WithBpfToken(tokenFd int, f func() error) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	C.ffi_set_thread_bpf_token(C.int(tokenFd))
+	defer C.ffi_set_thread_bpf_token(-1)
+	return f()
+}
@@ -0,0 +1,290 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"buzzer/pkg/cbpf/cbpf"
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// Finding is a program for which buzzer observed something unexpected.
+type Finding struct {
+	// Description is a human readable explanation of what was observed.
+	Description string
+
+	// Oracle is the check that reported the finding, e.g. OracleExecution.
+	Oracle string
+
+	// Splat is the kernel splat, e.g. a KASAN report, that came with the
+	// finding, if any.
+	Splat string
+
+	// Program is the program that produced the finding.
+	Program *epb.Program
+
+	// ClassicProgram is the cBPF program that produced the finding, set
+	// instead of Program by the strategies that fuzz classic BPF. Those
+	// strategies can also set Program to its eBPF translation.
+	ClassicProgram []cbpf.Instruction
+
+	// MinimizedProgram is a smaller version of Program that still produces
+	// the finding, nil if the finding was not minimized.
+	MinimizedProgram *epb.Program
+
+	// RequiredGuards are the indexes of the conditional jumps of
+	// MinimizedProgram the verifier needs to accept it, only set when guard
+	// reduction is enabled.
+	RequiredGuards []int
+
+	// Arch is the architecture the finding was observed on, e.g. "arm64".
+	Arch string
+
+	// Privileges are the privileges Program was loaded with, e.g.
+	// "unprivileged", see LoadPrivileges.
+	Privileges string
+
+	// ValidationResult is what the verifier said about Program.
+	ValidationResult *fpb.ValidationResult
+
+	// ExecutionResult is what running Program returned, with the verifier
+	// log, nil if the finding happened before the execution.
+	ExecutionResult *fpb.ExecutionResult
+
+	// ValueTrace is the last value of every register the program wrote in
+	// an execution, only set with Control.ValueTraces.
+	ValueTrace []TracedValue
+
+	// SourceTags are the kernel source locations that are likely involved
+	// in the finding.
+	SourceTags []SourceTag
+
+	// ReproPaths are the files written to reproduce the finding.
+	ReproPaths []string
+
+	// ArtifactDir is the directory holding everything known about the
+	// finding, only set with Control.ArtifactDir, see WriteArtifacts.
+	ArtifactDir string
+}
+
+// FindingHook is invoked every time the fuzzer reports a finding, after all
+// the reproducer files have been written. It allows to plug custom handling
+// of findings, e.g. filing bugs or uploading the reproducers somewhere.
+type FindingHook interface {
+	OnFinding(f *Finding) error
+}
+
+// CommandHook is a FindingHook that runs an external command for every
+// finding. The paths of the reproducer files are passed as arguments and the
+// description of the finding as the BUZZER_FINDING environment variable.
+type CommandHook struct {
+	Path string
+	Args []string
+}
+
+// OnFinding runs the command and waits for it to finish.
+func (ch *CommandHook) OnFinding(f *Finding) error {
+	cmd := exec.Command(ch.Path, append(append([]string{}, ch.Args...), f.ReproPaths...)...)
+	cmd.Env = append(os.Environ(), "BUZZER_FINDING="+f.Description)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// writeRepros writes the JSON and C PoCs of `prog`, its object file if
+// ELFRepros is set and its syzkaller program if SyzRepros is, and records
+// their paths in `f`.
+func (cu *Control) writeRepros(f *Finding, prog *epb.Program) {
+	path, err := ebpf.GeneratePoc(prog)
+	if err != nil {
+		logging.Warningf("PoC generation error: %v\n", err)
+	} else {
+		f.ReproPaths = append(f.ReproPaths, path)
+	}
+
+	var mapSizes map[int]uint64
+	if owner, ok := cu.strat.(MapOwner); ok {
+		mapSizes = owner.Maps()
+	}
+	path, err = ebpf.GenerateCPoc(prog, mapSizes)
+	if err != nil {
+		logging.Warningf("C PoC generation error: %v\n", err)
+	} else {
+		f.ReproPaths = append(f.ReproPaths, path)
+	}
+
+	if cu.ELFRepros {
+		path, err = GenerateELF(ELFObjectOf(prog, cu.programType(), mapSizes))
+		if err != nil {
+			logging.Warningf("ELF PoC generation error: %v\n", err)
+		} else {
+			f.ReproPaths = append(f.ReproPaths, path)
+		}
+	}
+
+	if cu.SyzRepros {
+		path, err = GenerateSyz(ELFObjectOf(prog, cu.programType(), mapSizes))
+		if err != nil {
+			logging.Warningf("Syzkaller PoC generation error: %v\n", err)
+		} else {
+			f.ReproPaths = append(f.ReproPaths, path)
+		}
+	}
+}
+
+// reportFinding tags `f` with candidate kernel source locations, prints it
+// along with the disassembly of the (minimized if available) program, writes
+// a JSON and a standalone C PoC for it, and its artifacts, and then runs the
+// finding hooks.
+// Findings already reported, see claimFinding, are ignored.
+func (cu *Control) reportFinding(f *Finding) {
+	bucket, ok := cu.claimFinding(f)
+	if !ok {
+		return
+	}
+	cu.countFinding()
+	f.SourceTags = cu.tagSources(f.ValidationResult)
+	f.Arch = hostArchName()
+	f.Privileges = cu.programPrivileges().String()
+
+	var out strings.Builder
+	fmt.Fprintln(&out, f.Description)
+	fmt.Fprintf(&out, "\tarchitecture: %s\n", f.Arch)
+	fmt.Fprintf(&out, "\tprivileges: %s\n", f.Privileges)
+	for _, tag := range f.SourceTags {
+		fmt.Fprintf(&out, "\tcandidate source: %s\n", tag)
+	}
+
+	program := f.Program
+	if f.MinimizedProgram != nil {
+		program = f.MinimizedProgram
+	}
+	if listing, err := ebpf.Disassemble(program); err == nil {
+		fmt.Fprint(&out, listing)
+	}
+	for _, index := range f.RequiredGuards {
+		fmt.Fprintf(&out, "\trequired guard: instruction %d\n", index)
+	}
+	if cu.ValueTraces && f.ExecutionResult != nil {
+		trace, err := cu.traceValues(program)
+		if err != nil {
+			logging.Warningf("Value trace error: %v\n", err)
+		}
+		f.ValueTrace = trace
+		for _, value := range f.ValueTrace {
+			fmt.Fprintf(&out, "\ttrace: %s\n", value)
+		}
+	}
+	logging.Reportf("%s", out.String())
+
+	cu.writeRepros(f, f.Program)
+	if f.MinimizedProgram != nil {
+		logging.Reportf("Minimized reproducer from %d to %d instructions\n", len(f.Program.Instructions), len(f.MinimizedProgram.Instructions))
+		cu.writeRepros(f, f.MinimizedProgram)
+	}
+	cu.writeArtifacts(f)
+
+	cu.storeFinding(f, bucket)
+	cu.runFindingHooks(f)
+}
+
+// reportClassicFinding prints `f`, a finding about a cBPF program along with
+// its eBPF translation if any, writes the C PoCs returned by `generatePocs`
+// and its artifacts and then runs the finding hooks. Findings already reported, see
+// claimFinding, are ignored.
+func (cu *Control) reportClassicFinding(f *Finding, generatePocs ...func() (string, error)) {
+	bucket, ok := cu.claimFinding(f)
+	if !ok {
+		return
+	}
+	cu.countFinding()
+	f.Arch = hostArchName()
+	var out strings.Builder
+	fmt.Fprintln(&out, f.Description)
+	fmt.Fprintf(&out, "\tarchitecture: %s\n", f.Arch)
+	for i, insn := range f.ClassicProgram {
+		fmt.Fprintf(&out, "\t%d: code %#02x jt %d jf %d k %#x\n", i, insn.Code, insn.Jt, insn.Jf, insn.K)
+	}
+	if f.Program != nil {
+		if listing, err := ebpf.Disassemble(f.Program); err == nil {
+			fmt.Fprintln(&out, "eBPF translation:")
+			fmt.Fprint(&out, listing)
+		}
+	}
+	logging.Reportf("%s", out.String())
+
+	for _, generatePoc := range generatePocs {
+		path, err := generatePoc()
+		if err != nil {
+			logging.Warningf("C PoC generation error: %v\n", err)
+		} else {
+			f.ReproPaths = append(f.ReproPaths, path)
+		}
+	}
+	cu.writeArtifacts(f)
+	cu.storeFinding(f, bucket)
+	cu.runFindingHooks(f)
+}
+
+// claimFinding returns false if `f` must not be reported because the triage
+// already has a finding with the same signature or, without triage, another
+// worker reported the same finding. The bucket of `f` is nil without triage.
+func (cu *Control) claimFinding(f *Finding) (*TriageBucket, bool) {
+	if cu.Triage != nil {
+		bucket, first := cu.Triage.Classify(f, cu.strat.Name())
+		if !first {
+			logging.Infof("Duplicate finding %s, seen %d times\n", bucket.Signature.Hash(), bucket.Count)
+		}
+		return &bucket, first
+	}
+	if cu.Shared != nil && !cu.Shared.ClaimFinding(f.Description) {
+		return nil, false
+	}
+	return nil, true
+}
+
+// storeFinding moves the reproducers of `f` to the directory of its triage
+// bucket, if there is one.
+func (cu *Control) storeFinding(f *Finding, bucket *TriageBucket) {
+	if bucket == nil {
+		return
+	}
+	logging.Reportf("Finding signature %s: %s\n", bucket.Signature.Hash(), bucket.Signature)
+	if err := cu.Triage.store(f, bucket.Signature); err != nil {
+		logging.Warningf("Triage error: %v\n", err)
+	}
+}
+
+// hostArchName returns the name of the architecture buzzer runs on, the one
+// findings and corpus entries are found on whatever Arch is.
+func hostArchName() string {
+	return ebpf.HostArch("").Name
+}
+
+// runFindingHooks invokes every finding hook with `f`.
+func (cu *Control) runFindingHooks(f *Finding) {
+	for _, hook := range cu.FindingHooks {
+		if err := hook.OnFinding(f); err != nil {
+			logging.Warningf("Finding hook error: %v\n", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestFindingsTaggedWithHostArch(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	host := ebpf.HostArch("")
+	var foreign *ebpf.Arch
+	for _, name := range ebpf.ArchNames() {
+		if name != host.Name {
+			foreign, _ = ebpf.LookupArch(name, "")
+			break
+		}
+	}
+	hook := &collectingHook{}
+	cu := &Control{FindingHooks: []FindingHook{hook}, Arch: foreign}
+	cu.Init(&FFI{Maps: NewFakeMaps()}, nil, &returnStrategy{})
+	insn, err := ebpf.InstructionSequence(ebpf.Mov64(ebpf.R0, 1), ebpf.Exit())
+	if err != nil {
+		t.Fatalf("InstructionSequence() = %v", err)
+	}
+	prog := &epb.Program{Instructions: insn}
+
+	cu.reportFinding(&Finding{Description: "Unexpected result", Oracle: OracleExecution, Program: prog})
+	if len(hook.findings) != 1 {
+		t.Fatalf("%d findings, want 1", len(hook.findings))
+	}
+	if got := hook.findings[0].Arch; got != host.Name {
+		t.Errorf("finding arch = %q, want %q, generated for %s", got, host.Name, foreign.Name)
+	}
+	if got := cu.corpusEntry(prog, nil, nil).GetArch(); got != host.Name {
+		t.Errorf("corpus entry arch = %q, want %q, generated for %s", got, host.Name, foreign.Name)
+	}
+}
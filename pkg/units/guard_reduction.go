@@ -0,0 +1,181 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// isGuard returns true if `insn` is a conditional jump.
+func isGuard(insn *epb.Instruction) bool {
+	jmp, ok := insn.Opcode.(*epb.Instruction_JmpOpcode)
+	return ok && ebpf.IsConditional(jmp.JmpOpcode.OperationCode)
+}
+
+// guardVariants returns the two ways of removing the guard at `index`:
+// `fallThrough` never takes the jump and `taken` always does.
+func guardVariants(prog *epb.Program, index int) (fallThrough, taken *epb.Program, err error) {
+	fallThrough, err = ebpf.RemoveInstruction(prog, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	taken, err = ebpf.ReplaceInstruction(prog, index, ebpf.Jmp(int16(prog.Instructions[index].Offset)))
+	if err != nil {
+		return nil, nil, err
+	}
+	return fallThrough, taken, nil
+}
+
+// isNullCheck returns true if the guard at `index` compares the result of a
+// map lookup against 0. If so, `nonNullTaken` tells whether the non NULL path
+// is the one that takes the jump.
+func isNullCheck(prog *epb.Program, index int) (isCheck bool, nonNullTaken bool) {
+	if index == 0 {
+		return false, false
+	}
+	call := prog.Instructions[index-1]
+	if jmp, ok := call.Opcode.(*epb.Instruction_JmpOpcode); !ok || jmp.JmpOpcode.OperationCode != epb.JmpOperationCode_JmpCALL || call.Immediate != int32(ebpf.MapLookup) {
+		return false, false
+	}
+	guard := prog.Instructions[index]
+	jmp := guard.Opcode.(*epb.Instruction_JmpOpcode).JmpOpcode
+	if guard.DstReg != epb.Reg_R0 || jmp.Source != epb.SrcOperand_Immediate || guard.Immediate != 0 {
+		return false, false
+	}
+	switch jmp.OperationCode {
+	case epb.JmpOperationCode_JmpJNE:
+		return true, true
+	case epb.JmpOperationCode_JmpJEQ:
+		return true, false
+	}
+	return false, false
+}
+
+// dereferencesR0 returns true if, starting at `index` and until the first
+// jump or redefinition of R0, some instruction accesses memory through R0.
+func dereferencesR0(prog *epb.Program, index int) bool {
+	for _, insn := range prog.Instructions[index:] {
+		switch op := insn.Opcode.(type) {
+		case *epb.Instruction_MemOpcode:
+			switch op.MemOpcode.InstructionClass {
+			case epb.InsClass_InsClassLdx:
+				if insn.SrcReg == epb.Reg_R0 {
+					return true
+				}
+				if insn.DstReg == epb.Reg_R0 {
+					return false
+				}
+			case epb.InsClass_InsClassSt, epb.InsClass_InsClassStx:
+				if insn.DstReg == epb.Reg_R0 {
+					return true
+				}
+			default:
+				if insn.DstReg == epb.Reg_R0 {
+					return false
+				}
+			}
+		case *epb.Instruction_AluOpcode:
+			if insn.DstReg == epb.Reg_R0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// isAccepted loads `prog` only to get the verdict of the verifier.
+func (cu *Control) isAccepted(prog *epb.Program) bool {
+	encodedProg, err := ebpf.EncodeInstructions(prog)
+	if err != nil {
+		return false
+	}
+	vres, err := cu.loadProgram(encodedProg)
+	if err != nil {
+		return false
+	}
+	if vres.GetIsValid() {
+		cu.ffi.CloseFD(int(vres.GetProgramFd()))
+	}
+	return vres.GetIsValid()
+}
+
+// checkGuards re-submits `prog`, which the verifier accepted, once per guard
+// and way of removing it. Guards whose removal, in both ways, is still
+// accepted were not required by the verifier.
+//
+// Returns the indexes of the required guards and a description of the
+// suspicious acceptances: map lookup results dereferenced without a NULL
+// check.
+func (cu *Control) checkGuards(prog *epb.Program) (required []int, suspicious []string) {
+	for i, insn := range prog.Instructions {
+		if !isGuard(insn) {
+			continue
+		}
+		fallThrough, taken, err := guardVariants(prog, i)
+		if err != nil {
+			continue
+		}
+		fallThroughOk := cu.isAccepted(fallThrough)
+		takenOk := cu.isAccepted(taken)
+		if !fallThroughOk || !takenOk {
+			required = append(required, i)
+		}
+
+		isCheck, nonNullTaken := isNullCheck(prog, i)
+		if !isCheck {
+			continue
+		}
+		if nonNullTaken && takenOk {
+			target, ok := ebpf.JumpTarget(prog, i)
+			if ok && dereferencesR0(prog, target) {
+				suspicious = append(suspicious, fmt.Sprintf("NULL check at instruction %d can be skipped", i))
+			}
+		}
+		if !nonNullTaken && fallThroughOk && dereferencesR0(prog, i+1) {
+			suspicious = append(suspicious, fmt.Sprintf("NULL check at instruction %d can be removed", i))
+		}
+	}
+	return required, suspicious
+}
+
+// reduceGuards removes, one by one, the guards of `prog` that are not needed
+// for `keep` to still return true. Each guard is first removed and, if that
+// does not work, replaced by an unconditional jump.
+func (cu *Control) reduceGuards(prog *epb.Program, keep func(*epb.Program) bool) *epb.Program {
+	current := prog
+	// Walk backwards so the indexes of the guards still pending are not
+	// shifted by the removals.
+	for i := len(current.Instructions) - 1; i >= 0; i-- {
+		if !isGuard(current.Instructions[i]) {
+			continue
+		}
+		fallThrough, taken, err := guardVariants(current, i)
+		if err != nil {
+			continue
+		}
+		for _, candidate := range []*epb.Program{fallThrough, taken} {
+			if keep(candidate) {
+				current = candidate
+				break
+			}
+		}
+	}
+	return current
+}
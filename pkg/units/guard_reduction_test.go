@@ -0,0 +1,89 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+func TestGuardVariants(t *testing.T) {
+	prog := &epb.Program{Instructions: []*epb.Instruction{
+		ebpf.JmpGT(ebpf.R1, 10, 1),
+		ebpf.Mov64(ebpf.R0, 1),
+		ebpf.Exit(),
+	}}
+	fallThrough, taken, err := guardVariants(prog, 0)
+	if err != nil {
+		t.Fatalf("guardVariants() = %v, want nil error", err)
+	}
+
+	wantFallThrough := &epb.Program{Instructions: []*epb.Instruction{ebpf.Mov64(ebpf.R0, 1), ebpf.Exit()}}
+	if !protobuf.Equal(fallThrough, wantFallThrough) {
+		t.Errorf("guardVariants() fallThrough = %v, want %v", fallThrough, wantFallThrough)
+	}
+	wantTaken := &epb.Program{Instructions: []*epb.Instruction{ebpf.Jmp(1), ebpf.Mov64(ebpf.R0, 1), ebpf.Exit()}}
+	if !protobuf.Equal(taken, wantTaken) {
+		t.Errorf("guardVariants() taken = %v, want %v", taken, wantTaken)
+	}
+}
+
+func TestIsNullCheck(t *testing.T) {
+	tests := []struct {
+		testName         string
+		guard            *epb.Instruction
+		wantCheck        bool
+		wantNonNullTaken bool
+	}{
+		{testName: "Jump if not NULL", guard: ebpf.JmpNE(ebpf.R0, 0, 1), wantCheck: true, wantNonNullTaken: true},
+		{testName: "Jump if NULL", guard: ebpf.JmpEQ(ebpf.R0, 0, 1), wantCheck: true, wantNonNullTaken: false},
+		{testName: "Other register", guard: ebpf.JmpNE(ebpf.R1, 0, 1), wantCheck: false},
+		{testName: "Other constant", guard: ebpf.JmpNE(ebpf.R0, 1, 1), wantCheck: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			prog := &epb.Program{Instructions: []*epb.Instruction{ebpf.Call(ebpf.MapLookup), tc.guard, ebpf.Exit()}}
+			isCheck, nonNullTaken := isNullCheck(prog, 1)
+			if isCheck != tc.wantCheck || (isCheck && nonNullTaken != tc.wantNonNullTaken) {
+				t.Errorf("isNullCheck() = %v, %v, want %v, %v", isCheck, nonNullTaken, tc.wantCheck, tc.wantNonNullTaken)
+			}
+		})
+	}
+}
+
+func TestDereferencesR0(t *testing.T) {
+	tests := []struct {
+		testName string
+		insns    []*epb.Instruction
+		want     bool
+	}{
+		{testName: "Store through R0", insns: []*epb.Instruction{ebpf.Mov64(ebpf.R1, 0), ebpf.StDW(ebpf.R0, 1, 0)}, want: true},
+		{testName: "Load through R0", insns: []*epb.Instruction{ebpf.LdDW(ebpf.R1, ebpf.R0, 0)}, want: true},
+		{testName: "R0 redefined first", insns: []*epb.Instruction{ebpf.Mov64(ebpf.R0, 0), ebpf.StDW(ebpf.R0, 1, 0)}, want: false},
+		{testName: "Exit first", insns: []*epb.Instruction{ebpf.Exit(), ebpf.StDW(ebpf.R0, 1, 0)}, want: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			if got := dereferencesR0(&epb.Program{Instructions: tc.insns}, 0); got != tc.want {
+				t.Errorf("dereferencesR0() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// helperName returns the name of the helper `id`, or its ID if buzzer doesn't
+// know about it.
+func helperName(id int32) string {
+	if hp := ebpf.HelperPrototypeByID(id); hp != nil {
+		return hp.Name
+	}
+	return fmt.Sprintf("%d", id)
+}
+
+// uncalledHelperPoints returns the helper calls of `prog` whose helper no
+// program called so far according to `mu`.
+func uncalledHelperPoints(prog *epb.Program, mu *Metrics) []ebpf.ValueTracePoint {
+	var points []ebpf.ValueTracePoint
+	for _, point := range ebpf.HelperCallPoints(prog) {
+		if !mu.HelperCalled(prog.Instructions[point.Index].Immediate) {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// recordHelperCoverage executes `prog` again, instrumented to tell which of
+// its calls to helpers no program called so far returned, and records them
+// in the metrics, see HelperCoverage. Helpers that don't return when they
+// succeed, like tail calls, are only recorded when they fail.
+func (cu *Control) recordHelperCoverage(prog *epb.Program) {
+	mu := cu.ffi.MetricsUnit
+	if !cu.HelperCoverage || mu == nil {
+		return
+	}
+	points := uncalledHelperPoints(prog, mu)
+	if len(points) == 0 {
+		return
+	}
+	trace, err := cu.traceValuesAt(prog, points)
+	if err != nil {
+		logging.Warningf("Helper coverage error: %v\n", err)
+		return
+	}
+	for _, value := range trace {
+		id := prog.Instructions[value.Index].Immediate
+		if mu.RecordHelperCall(id) {
+			logging.Infof("Helper %s called for the first time, %d helpers called so far\n", helperName(id), len(mu.CalledHelpers()))
+		}
+	}
+}
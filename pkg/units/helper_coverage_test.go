@@ -0,0 +1,50 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+func TestUncalledHelperPoints(t *testing.T) {
+	mu := &Metrics{metricsCollection: &MetricsCollection{}}
+	prog := &epb.Program{Instructions: []*epb.Instruction{
+		Call(GetPrandomU32),
+		Call(KtimeGetNs),
+		Mov64(R0, 0),
+		Exit(),
+	}}
+
+	if got := uncalledHelperPoints(prog, mu); len(got) != 2 {
+		t.Fatalf("uncalledHelperPoints() = %v, want both calls", got)
+	}
+	if !mu.RecordHelperCall(KtimeGetNs) {
+		t.Errorf("RecordHelperCall() = false for the first call")
+	}
+	if mu.RecordHelperCall(KtimeGetNs) {
+		t.Errorf("RecordHelperCall() = true for the second call")
+	}
+	got := uncalledHelperPoints(prog, mu)
+	if len(got) != 1 || got[0].Index != 0 {
+		t.Errorf("uncalledHelperPoints() = %v, want the call to get_prandom_u32 only", got)
+	}
+	mu.RecordHelperCall(GetPrandomU32)
+	if got := mu.CalledHelpers(); len(got) != 2 || got[0] != KtimeGetNs || got[1] != GetPrandomU32 {
+		t.Errorf("CalledHelpers() = %v, want [%d %d]", got, KtimeGetNs, GetPrandomU32)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"math/bits"
+
+	"buzzer/pkg/rand"
+)
+
+const (
+	// Map types of include/uapi/linux/bpf.h that can be populated by the
+	// key space driver.
+	MapTypeHash    = 1
+	MapTypeLruHash = 9
+
+	// Other map types of include/uapi/linux/bpf.h, see FFI.CreateMap.
+	MapTypeArray       = 2
+	MapTypeProgArray   = 3
+	MapTypeArrayOfMaps = 12
+	MapTypeHashOfMaps  = 13
+	MapTypeRingbuf     = 27
+
+	// MapFlagZeroSeed is BPF_F_ZERO_SEED, it makes the kernel hash the keys
+	// of a hash map without a random seed so collisions can be predicted.
+	MapFlagZeroSeed = 1 << 6
+
+	// MapFlagNoCommonLru is BPF_F_NO_COMMON_LRU, it gives each CPU its own
+	// LRU list.
+	MapFlagNoCommonLru = 1 << 1
+
+	// Program types of include/uapi/linux/bpf.h, see ProgramTypeStrategy.
+	ProgTypeSocketFilter = 1
+	ProgTypeSchedCls     = 3
+	ProgTypeTracepoint   = 5
+	ProgTypeXdp          = 6
+	ProgTypeCgroupSkb    = 8
+	ProgTypeCgroupSock   = 9
+	ProgTypeSyscall      = 31
+
+	// ProgFlagSleepable is BPF_F_SLEEPABLE, see SleepableStrategy.
+	ProgFlagSleepable = 1 << 4
+
+	// jhashInitVal is JHASH_INITVAL of include/linux/jhash.h.
+	jhashInitVal = 0xdeadbeef
+)
+
+// KeyPattern is how the keys a hash map is populated with are distributed.
+type KeyPattern int
+
+const (
+	// DenseKeys are consecutive, starting at 0.
+	DenseKeys KeyPattern = iota
+
+	// SparseKeys are random and spread over the whole key space.
+	SparseKeys
+
+	// CollidingKeys all fall in the same bucket of the map, provided it was
+	// created with MapFlagZeroSeed.
+	CollidingKeys
+)
+
+var keyPatternNames = map[KeyPattern]string{
+	DenseKeys:     "dense",
+	SparseKeys:    "sparse",
+	CollidingKeys: "colliding",
+}
+
+func (p KeyPattern) String() string {
+	if name, ok := keyPatternNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("KeyPattern(%d)", int(p))
+}
+
+// ParseKeyPattern returns the pattern called `name`.
+func ParseKeyPattern(name string) (KeyPattern, error) {
+	for p, n := range keyPatternNames {
+		if n == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown key pattern %q", name)
+}
+
+// KeyedMap is a hash map whose keys are populated before every execution.
+type KeyedMap struct {
+	Fd   int
+	Keys []uint32
+}
+
+// KeyedMapOwner can optionally be implemented by strategies whose programs
+// use hash maps. Before each execution, the maps are emptied and populated
+// with their keys, every key is set to KeyedMapValue of itself.
+type KeyedMapOwner interface {
+	// KeyedMaps returns the hash maps used by the last generated program.
+	KeyedMaps() []KeyedMap
+}
+
+// KeyedMapValue returns the value the driver stores at `key`.
+func KeyedMapValue(key uint32) uint64 {
+	return uint64(key)*0x9e3779b97f4a7c15 + 1
+}
+
+// jhashFinal is __jhash_final of include/linux/jhash.h.
+func jhashFinal(a, b, c uint32) uint32 {
+	c ^= b
+	c -= bits.RotateLeft32(b, 14)
+	a ^= c
+	a -= bits.RotateLeft32(c, 11)
+	b ^= a
+	b -= bits.RotateLeft32(a, 25)
+	c ^= b
+	c -= bits.RotateLeft32(b, 16)
+	a ^= c
+	a -= bits.RotateLeft32(c, 4)
+	b ^= a
+	b -= bits.RotateLeft32(a, 14)
+	c ^= b
+	c -= bits.RotateLeft32(b, 24)
+	return c
+}
+
+// keyHash returns the hash of a 4 byte `key` in a hash map created with
+// MapFlagZeroSeed, see htab_map_hash.
+func keyHash(key uint32) uint32 {
+	initial := uint32(jhashInitVal + 1<<2)
+	return jhashFinal(initial+key, initial, initial)
+}
+
+// keyBucket returns the bucket of `key` in a hash map created with
+// MapFlagZeroSeed, the kernel rounds the number of buckets up to a power of
+// two.
+func keyBucket(key uint32, maxEntries uint32) uint32 {
+	buckets := uint32(1)
+	for buckets < maxEntries {
+		buckets <<= 1
+	}
+	return keyHash(key) & (buckets - 1)
+}
+
+// GenerateKeys returns `count` distinct keys distributed according to
+// `pattern` for a hash map with room for `maxEntries` elements.
+func GenerateKeys(pattern KeyPattern, count, maxEntries uint32) []uint32 {
+	keys := make([]uint32, 0, count)
+	seen := make(map[uint32]bool)
+	switch pattern {
+	case DenseKeys:
+		for key := uint32(0); key < count; key++ {
+			keys = append(keys, key)
+		}
+	case SparseKeys:
+		for uint32(len(keys)) < count {
+			key := uint32(rand.SharedRNG.RandRange(0, 0xffffffff))
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	case CollidingKeys:
+		key := uint32(rand.SharedRNG.RandRange(0, 0xffffffff))
+		bucket := keyBucket(key, maxEntries)
+		for ; uint32(len(keys)) < count; key++ {
+			if keyBucket(key, maxEntries) == bucket {
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// populateKeyedMaps empties the hash maps of the strategy, if it implements
+// KeyedMapOwner, and populates them with their keys.
+func (cu *Control) populateKeyedMaps() error {
+	owner, ok := cu.strat.(KeyedMapOwner)
+	if !ok {
+		return nil
+	}
+	for _, m := range owner.KeyedMaps() {
+		if cu.ffi.ClearMap(m.Fd) < 0 {
+			return fmt.Errorf("could not clear map %d", m.Fd)
+		}
+		for _, key := range m.Keys {
+			if cu.ffi.SetMapElement(m.Fd, key, KeyedMapValue(key)) < 0 {
+				return fmt.Errorf("could not set key %#x of map %d", key, m.Fd)
+			}
+		}
+	}
+	return nil
+}
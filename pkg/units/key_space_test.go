@@ -0,0 +1,87 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+)
+
+func TestKeyHash(t *testing.T) {
+	// Computed with jhash2() of include/linux/jhash.h and a zero seed.
+	tests := []struct {
+		key  uint32
+		want uint32
+	}{
+		{key: 0, want: 0x049396b8},
+		{key: 1, want: 0x72a82a9b},
+		{key: 0xdeadbeef, want: 0x79e3d207},
+		{key: 0xffffffff, want: 0x9b11c75b},
+	}
+	for _, c := range tests {
+		if got := keyHash(c.key); got != c.want {
+			t.Errorf("keyHash(%#x) = %#x, want %#x", c.key, got, c.want)
+		}
+	}
+}
+
+func TestGenerateKeys(t *testing.T) {
+	tests := []struct {
+		testName   string
+		pattern    KeyPattern
+		count      uint32
+		maxEntries uint32
+	}{
+		{testName: "Dense", pattern: DenseKeys, count: 16, maxEntries: 16},
+		{testName: "Sparse", pattern: SparseKeys, count: 16, maxEntries: 32},
+		{testName: "Colliding", pattern: CollidingKeys, count: 8, maxEntries: 50},
+	}
+
+	for _, c := range tests {
+		keys := GenerateKeys(c.pattern, c.count, c.maxEntries)
+		if uint32(len(keys)) != c.count {
+			t.Errorf("%s: GenerateKeys() returned %d keys, want %d", c.testName, len(keys), c.count)
+			continue
+		}
+		seen := make(map[uint32]bool)
+		for i, key := range keys {
+			if seen[key] {
+				t.Errorf("%s: GenerateKeys() returned %#x twice", c.testName, key)
+			}
+			seen[key] = true
+			switch c.pattern {
+			case DenseKeys:
+				if key != uint32(i) {
+					t.Errorf("%s: GenerateKeys()[%d] = %#x, want %#x", c.testName, i, key, i)
+				}
+			case CollidingKeys:
+				if keyBucket(key, c.maxEntries) != keyBucket(keys[0], c.maxEntries) {
+					t.Errorf("%s: key %#x is in bucket %d, want %d", c.testName, key, keyBucket(key, c.maxEntries), keyBucket(keys[0], c.maxEntries))
+				}
+			}
+		}
+	}
+}
+
+func TestParseKeyPattern(t *testing.T) {
+	for _, p := range []KeyPattern{DenseKeys, SparseKeys, CollidingKeys} {
+		got, err := ParseKeyPattern(p.String())
+		if err != nil || got != p {
+			t.Errorf("ParseKeyPattern(%q) = %v, %v, want %v", p.String(), got, err, p)
+		}
+	}
+	if _, err := ParseKeyPattern("clustered"); err == nil {
+		t.Errorf("ParseKeyPattern(\"clustered\") = nil error, want an error")
+	}
+}
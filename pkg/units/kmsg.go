@@ -0,0 +1,242 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// DefaultKernelLogPath is where the kernel exposes its log records.
+	DefaultKernelLogPath = "/dev/kmsg"
+
+	// Records of /dev/kmsg are at most this long, longer ones are truncated
+	// by the kernel.
+	kmsgRecordSize = 8192
+
+	// Splats longer than this many lines are truncated, the first lines
+	// are the ones that identify them.
+	maxSplatLines = 200
+
+	// How many of the last messages read are kept for the artifacts of the
+	// findings, see Recent.
+	maxRecentMessages = 200
+
+	// How many checks in a row can read nothing new before an unfinished
+	// splat is reported as it is, some splats have no end marker.
+	maxIdleSplatChecks = 3
+)
+
+var (
+	// splatStart matches the first line of the kernel splats, e.g.
+	// "BUG: KASAN: slab-out-of-bounds in ..." or "UBSAN: shift-out-of-bounds
+	// in ...".
+	splatStart = regexp.MustCompile(`^(BUG: |UBSAN: |WARNING: |kernel BUG at |general protection fault|Oops: |Kernel panic)`)
+
+	// splatEnd matches the last line of the kernel splats.
+	splatEnd = regexp.MustCompile(`^(---\[ end |={20,}$)`)
+)
+
+// KernelLog follows the kernel log, /dev/kmsg, to catch the splats, e.g.
+// KASAN, UBSAN or WARN reports, programs trigger without crashing the
+// machine. It only sees the records logged after it was opened. It can be
+// shared by several control units.
+type KernelLog struct {
+	mu sync.Mutex
+	fd int
+
+	// recent are the last messages read.
+	recent []string
+
+	// pending is the splat whose end marker was not read yet, splats from
+	// deferred contexts, e.g. RCU callbacks, can be logged across two
+	// checks. idleChecks is how many checks in a row read nothing since.
+	pending    []string
+	idleChecks int
+}
+
+// OpenKernelLog starts following the kernel log at `path`, usually
+// DefaultKernelLogPath.
+func OpenKernelLog(path string) (*KernelLog, error) {
+	fd, err := syscall.Open(path, syscall.O_RDONLY|syscall.O_NONBLOCK|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	// Skip the records logged before the fuzzer started.
+	if _, err := syscall.Seek(fd, 0, io.SeekEnd); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("seeking %s: %w", path, err)
+	}
+	return &KernelLog{fd: fd}, nil
+}
+
+// Close stops following the kernel log.
+func (kl *KernelLog) Close() error {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	return syscall.Close(kl.fd)
+}
+
+// kmsgMessage returns the text of the /dev/kmsg `record`, e.g.
+// "6,1234,5678,-;message\n SUBSYSTEM=...", without its prefix nor the
+// dictionary lines that follow it.
+func kmsgMessage(record []byte) string {
+	_, message, found := bytes.Cut(record, []byte(";"))
+	if !found {
+		return ""
+	}
+	message, _, _ = bytes.Cut(message, []byte("\n"))
+	return string(message)
+}
+
+// Messages returns the messages logged since the last call, without
+// waiting for new ones.
+func (kl *KernelLog) Messages() ([]string, error) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	return kl.messages()
+}
+
+// messages is Messages with the lock held.
+func (kl *KernelLog) messages() ([]string, error) {
+	var messages []string
+	buf := make([]byte, kmsgRecordSize)
+	for {
+		// Every read returns exactly one record.
+		n, err := syscall.Read(kl.fd, buf)
+		switch {
+		case errors.Is(err, syscall.EAGAIN):
+			kl.keepRecent(messages)
+			return messages, nil
+		case errors.Is(err, syscall.EPIPE):
+			// The kernel overwrote records before they were read, the
+			// next read continues with the oldest one left.
+			continue
+		case err != nil:
+			kl.keepRecent(messages)
+			return messages, err
+		case n == 0:
+			kl.keepRecent(messages)
+			return messages, nil
+		}
+		messages = append(messages, kmsgMessage(buf[:n]))
+	}
+}
+
+// keepRecent adds `messages` to the recent ones, dropping the oldest past
+// maxRecentMessages.
+func (kl *KernelLog) keepRecent(messages []string) {
+	kl.recent = append(kl.recent, messages...)
+	if extra := len(kl.recent) - maxRecentMessages; extra > 0 {
+		kl.recent = append([]string(nil), kl.recent[extra:]...)
+	}
+}
+
+// Recent returns the last messages read, up to maxRecentMessages, the
+// context of the splats.
+func (kl *KernelLog) Recent() []string {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	return append([]string(nil), kl.recent...)
+}
+
+// extractSplats returns the splats among `messages`, each one as the lines
+// from its first line to its end marker. A splat whose end marker is not
+// among `messages` is kept for the next call, unless it has not grown for
+// maxIdleSplatChecks calls.
+func (kl *KernelLog) extractSplats(messages []string) []string {
+	var splats []string
+	current := kl.pending
+	flush := func() {
+		if len(current) > 0 {
+			splats = append(splats, strings.Join(current, "\n"))
+		}
+		current = nil
+	}
+	for _, message := range messages {
+		if splatStart.MatchString(message) {
+			flush()
+			current = []string{message}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if len(current) < maxSplatLines {
+			current = append(current, message)
+		}
+		if splatEnd.MatchString(message) {
+			flush()
+		}
+	}
+
+	if current == nil || len(messages) > 0 {
+		kl.idleChecks = 0
+	} else if kl.idleChecks++; kl.idleChecks >= maxIdleSplatChecks {
+		kl.idleChecks = 0
+		flush()
+	}
+	kl.pending = current
+	return splats
+}
+
+// Splats returns the splats logged since the last call.
+func (kl *KernelLog) Splats() ([]string, error) {
+	kl.mu.Lock()
+	defer kl.mu.Unlock()
+	messages, err := kl.messages()
+	return kl.extractSplats(messages), err
+}
+
+// checkKernelLog reports the splats logged since the last check as findings
+// of `prog`, the program loaded or executed last, whose verification gave
+// `vres`. With several workers sharing the kernel log, the splats go to the
+// program of the worker that checks first.
+func (cu *Control) checkKernelLog(prog *epb.Program, vres *fpb.ValidationResult) {
+	if cu.KernelLog == nil {
+		return
+	}
+	splats, err := cu.KernelLog.Splats()
+	if err != nil {
+		logging.Warningf("Kernel log error: %v\n", err)
+	}
+	for _, splat := range splats {
+		cu.reportSplat(prog, vres, splat, "")
+	}
+}
+
+// reportSplat reports `splat` as a finding of `prog`, whose verification
+// gave `vres`, with `note` appended to its description.
+func (cu *Control) reportSplat(prog *epb.Program, vres *fpb.ValidationResult, splat, note string) {
+	title, _, _ := strings.Cut(splat, "\n")
+	cu.reportFinding(&Finding{
+		Description:      fmt.Sprintf("Kernel splat: %s%s", title, note),
+		Oracle:           OracleKernelSplat,
+		Splat:            splat,
+		Program:          prog,
+		ValidationResult: vres,
+	})
+}
@@ -0,0 +1,143 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestKmsgMessage(t *testing.T) {
+	for _, c := range []struct {
+		testName string
+		record   string
+		want     string
+	}{
+		{
+			testName: "Plain record",
+			record:   "6,1234,5678,-;random: crng init done\n",
+			want:     "random: crng init done",
+		},
+		{
+			testName: "Record with dictionary",
+			record:   "4,99,100,-,caller=T42;WARNING: CPU: 0 PID: 42 at kernel/bpf/verifier.c:1 f+0x1/0x2\n SUBSYSTEM=bpf\n",
+			want:     "WARNING: CPU: 0 PID: 42 at kernel/bpf/verifier.c:1 f+0x1/0x2",
+		},
+		{
+			testName: "Malformed record",
+			record:   "garbage",
+		},
+	} {
+		if got := kmsgMessage([]byte(c.record)); got != c.want {
+			t.Errorf("%s: kmsgMessage() = %q, want %q", c.testName, got, c.want)
+		}
+	}
+}
+
+func TestExtractSplats(t *testing.T) {
+	separator := "=================================================================="
+	for _, c := range []struct {
+		testName string
+		messages []string
+		want     []string
+	}{
+		{
+			testName: "KASAN report between separators",
+			messages: []string{
+				"eth0: link up",
+				separator,
+				"BUG: KASAN: slab-out-of-bounds in bpf_map_lookup_elem+0x12/0x40",
+				"Read of size 8 at addr ffff888000000000",
+				separator,
+				"eth0: link down",
+			},
+			want: []string{
+				"BUG: KASAN: slab-out-of-bounds in bpf_map_lookup_elem+0x12/0x40\nRead of size 8 at addr ffff888000000000\n" + separator,
+			},
+		},
+		{
+			testName: "Two warnings",
+			messages: []string{
+				"WARNING: CPU: 0 PID: 1 at kernel/bpf/verifier.c:1 do_check+0x1/0x2",
+				"RIP: 0010:do_check+0x1/0x2",
+				"---[ end trace 0000000000000000 ]---",
+				"WARNING: CPU: 1 PID: 2 at kernel/bpf/core.c:2 bpf_prog_run+0x3/0x4",
+			},
+			// The second one is kept until its end marker is read.
+			want: []string{
+				"WARNING: CPU: 0 PID: 1 at kernel/bpf/verifier.c:1 do_check+0x1/0x2\nRIP: 0010:do_check+0x1/0x2\n---[ end trace 0000000000000000 ]---",
+			},
+		},
+		{
+			testName: "No splat",
+			messages: []string{"random: crng init done", separator},
+		},
+	} {
+		kl := &KernelLog{}
+		if got := kl.extractSplats(c.messages); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: extractSplats() = %q, want %q", c.testName, got, c.want)
+		}
+	}
+}
+
+func TestExtractSplitSplat(t *testing.T) {
+	kl := &KernelLog{}
+	first := []string{
+		"BUG: KASAN: use-after-free in bpf_prog_free_deferred+0x1/0x2",
+		"Read of size 8 at addr ffff888000000000",
+	}
+	if got := kl.extractSplats(first); len(got) != 0 {
+		t.Fatalf("extractSplats() of the first half = %q, want no splat", got)
+	}
+	second := []string{
+		"Call Trace:",
+		"==================================================================",
+		"eth0: link down",
+	}
+	want := []string{strings.Join(append(first, second[:2]...), "\n")}
+	if got := kl.extractSplats(second); !reflect.DeepEqual(got, want) {
+		t.Errorf("extractSplats() of the second half = %q, want %q", got, want)
+	}
+
+	// A splat without an end marker is reported once nothing is logged
+	// for a while.
+	unfinished := "WARNING: CPU: 1 PID: 2 at kernel/bpf/core.c:2 bpf_prog_run+0x3/0x4"
+	kl.extractSplats([]string{unfinished})
+	for i := 1; i < maxIdleSplatChecks; i++ {
+		if got := kl.extractSplats(nil); len(got) != 0 {
+			t.Fatalf("extractSplats() after %d idle checks = %q, want no splat", i, got)
+		}
+	}
+	if got := kl.extractSplats(nil); !reflect.DeepEqual(got, []string{unfinished}) {
+		t.Errorf("extractSplats() after %d idle checks = %q, want %q", maxIdleSplatChecks, got, unfinished)
+	}
+}
+
+func TestKeepRecent(t *testing.T) {
+	kl := &KernelLog{}
+	kl.keepRecent([]string{"a", "b"})
+	if got, want := kl.Recent(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Recent() = %q, want %q", got, want)
+	}
+	for i := 0; i < maxRecentMessages; i++ {
+		kl.keepRecent([]string{fmt.Sprint(i)})
+	}
+	got := kl.Recent()
+	if len(got) != maxRecentMessages || got[0] != "0" || got[len(got)-1] != fmt.Sprint(maxRecentMessages-1) {
+		t.Errorf("Recent() = %d messages from %q to %q, want the last %d", len(got), got[0], got[len(got)-1], maxRecentMessages)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"syscall"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// Flags of the log_level attribute of BPF_PROG_LOAD.
+const (
+	logLevel1     = 1
+	logLevel2     = 2
+	logLevelStats = 4
+)
+
+// differentialLogLevels are the log levels programs are loaded again with to
+// be compared with the regular load, which uses logLevel2.
+var differentialLogLevels = []uint32{
+	0,
+	logLevel1,
+	logLevelStats,
+	logLevel1 | logLevelStats,
+	logLevel2 | logLevelStats,
+}
+
+// verifierLogSummary is what the verifier logs of a program must agree on,
+// whatever the log level.
+type verifierLogSummary struct {
+	// errorLine is the error the program was rejected with.
+	errorLine string
+
+	// processed is the line with the number of processed instructions and
+	// states, stackDepth the one with the stack depth of every subprogram
+	// that is only printed with logLevelStats.
+	processed  string
+	stackDepth string
+}
+
+// summarizeVerifierLog extracts the summary of `log`. The error, if any, is
+// the last line before the statistics.
+func summarizeVerifierLog(log string) verifierLogSummary {
+	var summary verifierLogSummary
+	inStats := false
+	for _, line := range strings.Split(log, "\n") {
+		switch {
+		case strings.HasPrefix(line, "verification time "):
+			inStats = true
+		case strings.HasPrefix(line, "stack depth "):
+			inStats = true
+			summary.stackDepth = line
+		case strings.HasPrefix(line, "processed "):
+			inStats = true
+			summary.processed = line
+		case line != "" && !inStats:
+			summary.errorLine = line
+		}
+	}
+	return summary
+}
+
+// compareLogLevels returns a description of the first inconsistency between
+// `want`, the result of the regular load, and `got`, the result of the load
+// at `level`, or an empty string if there is none.
+func compareLogLevels(want, got *fpb.ValidationResult, level uint32) string {
+	if want.GetIsValid() != got.GetIsValid() || want.GetBpfErrno() != got.GetBpfErrno() {
+		return fmt.Sprintf("log_level %d changed the verdict: is_valid %v (%s) != %v (%s)", level, want.GetIsValid(), want.GetBpfError(), got.GetIsValid(), got.GetBpfError())
+	}
+	if level == 0 {
+		return ""
+	}
+	wantSummary := summarizeVerifierLog(want.GetVerifierLog())
+	gotSummary := summarizeVerifierLog(got.GetVerifierLog())
+	if wantSummary.processed != gotSummary.processed {
+		return fmt.Sprintf("log_level %d changed the statistics: %q != %q", level, wantSummary.processed, gotSummary.processed)
+	}
+	if !want.GetIsValid() && wantSummary.errorLine != gotSummary.errorLine {
+		return fmt.Sprintf("log_level %d changed the error: %q != %q", level, wantSummary.errorLine, gotSummary.errorLine)
+	}
+	return ""
+}
+
+// checkLogLevels loads `prog` again at every differentialLogLevels and
+// compares the verdicts and verifier logs with the ones of the regular load
+// (`first`), along with the xlated instructions of accepted programs.
+//
+// Returns a description of the first inconsistency found, or an empty string
+// if there is none or a log did not fit in the buffer.
+func (cu *Control) checkLogLevels(prog []uint64, first *fpb.ValidationResult) (string, error) {
+	if first.GetBpfErrno() == int32(syscall.ENOSPC) {
+		return "", nil
+	}
+	want, err := cu.collectVerifierOutput(first)
+	if err != nil {
+		return "", err
+	}
+
+	stackDepth := ""
+	for _, level := range differentialLogLevels {
+		vres, err := cu.retryTransient(func() (*fpb.ValidationResult, error) {
+			return cu.withStrategyPrivileges(func() (*fpb.ValidationResult, error) {
+				return cu.ffi.LoadProgramWithLogLevel(prog, level)
+			})
+		})
+		if err != nil {
+			return "", err
+		}
+		got, err := cu.collectVerifierOutput(vres)
+		if vres.GetIsValid() {
+			cu.ffi.CloseFD(int(vres.GetProgramFd()))
+		}
+		if err != nil {
+			return "", err
+		}
+		if vres.GetBpfErrno() == int32(syscall.ENOSPC) {
+			continue
+		}
+
+		if d := compareLogLevels(first, vres, level); d != "" {
+			return d, nil
+		}
+		if !reflect.DeepEqual(want.xlated, got.xlated) {
+			return fmt.Sprintf("log_level %d changed the xlated program: %d != %d instructions", level, len(want.xlated), len(got.xlated)), nil
+		}
+		if level&logLevelStats == 0 {
+			continue
+		}
+		depth := summarizeVerifierLog(vres.GetVerifierLog()).stackDepth
+		if stackDepth != "" && depth != stackDepth {
+			return fmt.Sprintf("log_level %d changed the stack depth: %q != %q", level, stackDepth, depth), nil
+		}
+		stackDepth = depth
+	}
+	return "", nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"strings"
+	"syscall"
+	"testing"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	level2RejectionLog = `func#0 @0
+0: R1=ctx() R10=fp0
+0: (b7) r1 = 0                        ; R1=0
+1: (95) exit
+R0 !read_ok
+processed 2 insns (limit 1000000) max_states_per_insn 0 total_states 0 peak_states 0 mark_read 0
+`
+	statsRejectionLog = `R0 !read_ok
+verification time 6 usec
+stack depth 0
+processed 2 insns (limit 1000000) max_states_per_insn 0 total_states 0 peak_states 0 mark_read 0
+`
+)
+
+func rejection(log string) *fpb.ValidationResult {
+	return &fpb.ValidationResult{VerifierLog: log, BpfError: syscall.EACCES.Error(), BpfErrno: int32(syscall.EACCES)}
+}
+
+func TestSummarizeVerifierLog(t *testing.T) {
+	got := summarizeVerifierLog(statsRejectionLog)
+	want := verifierLogSummary{
+		errorLine:  "R0 !read_ok",
+		processed:  "processed 2 insns (limit 1000000) max_states_per_insn 0 total_states 0 peak_states 0 mark_read 0",
+		stackDepth: "stack depth 0",
+	}
+	if got != want {
+		t.Errorf("summarizeVerifierLog() = %+v, want %+v", got, want)
+	}
+	if got := summarizeVerifierLog(level2RejectionLog); got.errorLine != want.errorLine || got.processed != want.processed {
+		t.Errorf("summarizeVerifierLog() at level 2 = %+v, want %+v without stack depth", got, want)
+	}
+}
+
+func TestCompareLogLevels(t *testing.T) {
+	accepted := &fpb.ValidationResult{IsValid: true, VerifierLog: "processed 2 insns (limit 1000000) max_states_per_insn 0 total_states 0 peak_states 0 mark_read 0\n"}
+	for _, c := range []struct {
+		testName string
+		want     *fpb.ValidationResult
+		got      *fpb.ValidationResult
+		level    uint32
+		wantDiff string
+	}{
+		{
+			testName: "Same rejection with statistics",
+			want:     rejection(level2RejectionLog),
+			got:      rejection(statsRejectionLog),
+			level:    logLevelStats,
+		},
+		{
+			testName: "Acceptance without log",
+			want:     accepted,
+			got:      &fpb.ValidationResult{IsValid: true},
+			level:    0,
+		},
+		{
+			testName: "Verdict changed",
+			want:     rejection(level2RejectionLog),
+			got:      accepted,
+			level:    logLevel1,
+			wantDiff: "changed the verdict",
+		},
+		{
+			testName: "Error changed",
+			want:     rejection(level2RejectionLog),
+			got:      rejection(strings.Replace(statsRejectionLog, "R0 !read_ok", "R1 !read_ok", 1)),
+			level:    logLevelStats,
+			wantDiff: "changed the error",
+		},
+		{
+			testName: "Statistics changed",
+			want:     accepted,
+			got:      &fpb.ValidationResult{IsValid: true, VerifierLog: "processed 3 insns (limit 1000000) max_states_per_insn 0 total_states 0 peak_states 0 mark_read 0\n"},
+			level:    logLevel1,
+			wantDiff: "changed the statistics",
+		},
+	} {
+		diff := compareLogLevels(c.want, c.got, c.level)
+		if (c.wantDiff == "") != (diff == "") || !strings.Contains(diff, c.wantDiff) {
+			t.Errorf("%s: compareLogLevels() = %q, want %q", c.testName, diff, c.wantDiff)
+		}
+	}
+}
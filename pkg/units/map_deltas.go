@@ -0,0 +1,159 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"sort"
+
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// MapDeltaStrategy can optionally be implemented by MapOwner strategies that
+// know how the executions of their programs change the maps. With
+// Control.MapDeltas set, every execution whose changes differ is reported.
+type MapDeltaStrategy interface {
+	MapOwner
+
+	// ExpectedMapDeltas returns the elements the last executed program
+	// should have changed, only their MapFd, Key and After matter. The
+	// elements that are not listed must stay unchanged.
+	ExpectedMapDeltas() []*fpb.MapElementDelta
+}
+
+// mapSnapshot holds the elements of array maps, by file descriptor.
+type mapSnapshot map[int][]uint64
+
+// snapshotMaps returns the elements of the maps of the strategy, nil if it
+// does not implement MapOwner.
+func (cu *Control) snapshotMaps() (mapSnapshot, error) {
+	owner, ok := cu.strat.(MapOwner)
+	if !ok {
+		return nil, nil
+	}
+	snapshot := make(mapSnapshot)
+	for fd, size := range owner.Maps() {
+		elements, err := cu.ffi.GetMapElements(fd, size)
+		if err != nil {
+			return nil, fmt.Errorf("map %d: %w", fd, err)
+		}
+		snapshot[fd] = elements.GetElements()
+	}
+	return snapshot, nil
+}
+
+// diffMaps returns the elements that differ from `before` to `after`, by
+// file descriptor and key. Maps missing from either snapshot are ignored.
+func diffMaps(before, after mapSnapshot) []*fpb.MapElementDelta {
+	fds := make([]int, 0, len(before))
+	for fd := range before {
+		fds = append(fds, fd)
+	}
+	sort.Ints(fds)
+
+	var deltas []*fpb.MapElementDelta
+	for _, fd := range fds {
+		for key, value := range before[fd] {
+			if key >= len(after[fd]) || after[fd][key] == value {
+				continue
+			}
+			deltas = append(deltas, &fpb.MapElementDelta{
+				MapFd:  int64(fd),
+				Key:    uint32(key),
+				Before: value,
+				After:  after[fd][key],
+			})
+		}
+	}
+	return deltas
+}
+
+// unexpectedDelta returns a description of the first difference between the
+// changes an execution made, `deltas`, and the `expected` ones, or an empty
+// string if there is none. `after` holds the maps once the execution ran.
+func unexpectedDelta(expected, deltas []*fpb.MapElementDelta, after mapSnapshot) string {
+	type element struct {
+		fd  int64
+		key uint32
+	}
+	listed := make(map[element]bool)
+	for _, want := range expected {
+		listed[element{want.GetMapFd(), want.GetKey()}] = true
+		values := after[int(want.GetMapFd())]
+		if int(want.GetKey()) >= len(values) {
+			return fmt.Sprintf("element %d of map %d does not exist", want.GetKey(), want.GetMapFd())
+		}
+		if got := values[want.GetKey()]; got != want.GetAfter() {
+			return fmt.Sprintf("element %d of map %d is %#x, want %#x", want.GetKey(), want.GetMapFd(), got, want.GetAfter())
+		}
+	}
+	for _, delta := range deltas {
+		if !listed[element{delta.GetMapFd(), delta.GetKey()}] {
+			return fmt.Sprintf("element %d of map %d changed from %#x to %#x", delta.GetKey(), delta.GetMapFd(), delta.GetBefore(), delta.GetAfter())
+		}
+	}
+	return ""
+}
+
+// executeWithMapDeltas is watchedExecute that, with MapDeltas set, also
+// records in the result the changes the execution made to the maps of the
+// strategy. It returns the maps once the execution ran, nil when they were
+// not snapshotted.
+func (cu *Control) executeWithMapDeltas(prog *epb.Program, progFd int64) (*fpb.ExecutionResult, mapSnapshot, error) {
+	if !cu.MapDeltas {
+		exRes, err := cu.watchedExecute(prog, progFd)
+		return exRes, nil, err
+	}
+	before, err := cu.snapshotMaps()
+	if err != nil {
+		logging.Warningf("Map snapshot error: %v\n", err)
+	}
+	exRes, err := cu.watchedExecute(prog, progFd)
+	if err != nil || before == nil {
+		return exRes, nil, err
+	}
+	after, err := cu.snapshotMaps()
+	if err != nil {
+		logging.Warningf("Map snapshot error: %v\n", err)
+		return exRes, nil, nil
+	}
+	exRes.MapDeltas = diffMaps(before, after)
+	return exRes, after, nil
+}
+
+// mapDeltaOracle flags the execution of a program of a MapDeltaStrategy
+// that changed its maps, which were in the state `after` once it was done,
+// otherwise than expected.
+type mapDeltaOracle struct {
+	cu    *Control
+	after mapSnapshot
+}
+
+func (mo *mapDeltaOracle) Name() string {
+	return OracleMapDelta
+}
+
+func (mo *mapDeltaOracle) Inspect(prog *epb.Program, exRes *fpb.ExecutionResult) Verdict {
+	mds, ok := mo.cu.strat.(MapDeltaStrategy)
+	if !ok || mo.after == nil {
+		return Pass
+	}
+	if diff := unexpectedDelta(mds.ExpectedMapDeltas(), exRes.GetMapDeltas(), mo.after); diff != "" {
+		return Flag("Program changed its maps unexpectedly, %s", diff)
+	}
+	return Pass
+}
@@ -0,0 +1,109 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	fpb "buzzer/proto/ffi_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+// mapOwnerStrategy is an idleStrategy whose programs use `maps`.
+type mapOwnerStrategy struct {
+	idleStrategy
+	maps map[int]uint64
+}
+
+func (s *mapOwnerStrategy) Maps() map[int]uint64 {
+	return s.maps
+}
+
+func TestMapDeltas(t *testing.T) {
+	ffi := &FFI{Maps: NewFakeMaps()}
+	first := ffi.CreateMapArray(3)
+	second := ffi.CreateMapArray(1)
+	cu := &Control{}
+	cu.Init(ffi, nil, &mapOwnerStrategy{maps: map[int]uint64{first: 3, second: 1}})
+
+	before, err := cu.snapshotMaps()
+	if err != nil {
+		t.Fatalf("snapshotMaps() = %v", err)
+	}
+	ffi.SetMapElement(first, 2, 7)
+	ffi.SetMapElement(second, 0, 9)
+	after, err := cu.snapshotMaps()
+	if err != nil {
+		t.Fatalf("snapshotMaps() = %v", err)
+	}
+
+	deltas := diffMaps(before, after)
+	want := []*fpb.MapElementDelta{
+		{MapFd: int64(first), Key: 2, Before: 0, After: 7},
+		{MapFd: int64(second), Key: 0, Before: 0, After: 9},
+	}
+	if len(deltas) != len(want) {
+		t.Fatalf("diffMaps() = %v, want %v", deltas, want)
+	}
+	for i := range want {
+		if !proto.Equal(deltas[i], want[i]) {
+			t.Errorf("diffMaps()[%d] = %v, want %v", i, deltas[i], want[i])
+		}
+	}
+
+	tests := []struct {
+		testName string
+		expected []*fpb.MapElementDelta
+		wantDiff string
+	}{
+		{
+			testName: "Expected",
+			expected: want,
+		},
+		{
+			testName: "Unchanged element with the expected value",
+			expected: append([]*fpb.MapElementDelta{{MapFd: int64(first), Key: 0, After: 0}}, want...),
+		},
+		{
+			testName: "Unexpected change",
+			expected: want[:1],
+			wantDiff: "element 0 of map",
+		},
+		{
+			testName: "Wrong value",
+			expected: []*fpb.MapElementDelta{want[0], {MapFd: int64(second), Key: 0, After: 8}},
+			wantDiff: "is 0x9, want 0x8",
+		},
+		{
+			testName: "Missing element",
+			expected: []*fpb.MapElementDelta{{MapFd: int64(second), Key: 1}},
+			wantDiff: "does not exist",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			diff := unexpectedDelta(tc.expected, deltas, after)
+			if (tc.wantDiff == "") != (diff == "") || !strings.Contains(diff, tc.wantDiff) {
+				t.Errorf("unexpectedDelta() = %q, want %q", diff, tc.wantDiff)
+			}
+		})
+	}
+
+	if got := diffMaps(before, before); !reflect.DeepEqual(got, []*fpb.MapElementDelta(nil)) {
+		t.Errorf("diffMaps() of the same snapshot = %v, want none", got)
+	}
+}
@@ -15,6 +15,7 @@
 package units
 
 import (
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -33,6 +34,22 @@ type MetricsCollection struct {
 	coverageManager   *CoverageManager
 	latestVerifierLog string
 	verifierVerdicts  map[string]int
+
+	// transientFailures counts the loads that failed for reasons unrelated
+	// to the program, e.g. ENOMEM, by error message. Those are not
+	// verdicts and are not counted as verified programs.
+	transientFailures map[string]int
+	droppedPrograms   int
+
+	// Counters of the fuzzing loop, see Control, and when they started.
+	programsGenerated int
+	executions        int
+	oracleViolations  int
+	startTime         time.Time
+
+	// calledHelpers are the helpers the programs called at runtime, see
+	// Control.HelperCoverage.
+	calledHelpers map[int32]bool
 }
 
 func (mc *MetricsCollection) recordVerifiedProgram() {
@@ -47,12 +64,114 @@ func (mc *MetricsCollection) recordValidProgram() {
 	mc.validPrograms++
 }
 
+// recordTransientFailure counts a load that failed with `bpfError` without
+// reaching a verdict, undoing the verified program recorded before it.
+func (mc *MetricsCollection) recordTransientFailure(bpfError string) {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	if mc.transientFailures == nil {
+		mc.transientFailures = make(map[string]int)
+	}
+	mc.transientFailures[bpfError]++
+	mc.programsVerified--
+}
+
+func (mc *MetricsCollection) recordGeneratedProgram() {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	mc.programsGenerated++
+}
+
+func (mc *MetricsCollection) recordExecution() {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	mc.executions++
+}
+
+func (mc *MetricsCollection) recordOracleViolation() {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	mc.oracleViolations++
+}
+
+func (mc *MetricsCollection) recordDroppedProgram() {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	mc.droppedPrograms++
+}
+
+// recordHelperCall records that a program called the helper `id` at runtime
+// and returns true if none did before.
+func (mc *MetricsCollection) recordHelperCall(id int32) bool {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	if mc.calledHelpers == nil {
+		mc.calledHelpers = make(map[int32]bool)
+	}
+	if mc.calledHelpers[id] {
+		return false
+	}
+	mc.calledHelpers[id] = true
+	return true
+}
+
+func (mc *MetricsCollection) helperCalled(id int32) bool {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	return mc.calledHelpers[id]
+}
+
+// getCalledHelpers returns the helpers the programs called at runtime,
+// sorted by ID.
+func (mc *MetricsCollection) getCalledHelpers() []int32 {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	helpers := make([]int32, 0, len(mc.calledHelpers))
+	for id := range mc.calledHelpers {
+		helpers = append(helpers, id)
+	}
+	sort.Slice(helpers, func(i, j int) bool { return helpers[i] < helpers[j] })
+	return helpers
+}
+
+// getTransientCounters returns a copy of the transient failures and the
+// number of programs dropped after running out of retries.
+func (mc *MetricsCollection) getTransientCounters() (map[string]int, int) {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	failures := make(map[string]int, len(mc.transientFailures))
+	for bpfError, count := range mc.transientFailures {
+		failures[bpfError] = count
+	}
+	return failures, mc.droppedPrograms
+}
+
 func (mc *MetricsCollection) getProgramsVerified() int {
 	mc.metricsLock.Lock()
 	defer mc.metricsLock.Unlock()
 	return mc.programsVerified
 }
 
+// getCounters returns the number of verified and valid programs and a copy
+// of the verifier verdicts.
+func (mc *MetricsCollection) getCounters() (int, int, map[string]int) {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	verdicts := make(map[string]int, len(mc.verifierVerdicts))
+	for verdict, count := range mc.verifierVerdicts {
+		verdicts[verdict] = count
+	}
+	return mc.programsVerified, mc.validPrograms, verdicts
+}
+
+// getLoopCounters returns the number of generated programs, executions and
+// oracle violations of the fuzzing loop, and when it started.
+func (mc *MetricsCollection) getLoopCounters() (int, int, int, time.Time) {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	return mc.programsGenerated, mc.executions, mc.oracleViolations, mc.startTime
+}
+
 func (mc *MetricsCollection) getCoverageHistory() map[time.Time]int {
 	mc.metricsLock.Lock()
 	defer mc.metricsLock.Unlock()
@@ -99,11 +218,15 @@ func (mc *MetricsCollection) processVerifierLog(vres *fpb.ValidationResult) {
 		return
 	}
 
-	logSplits := strings.Split(log, "\n")
+	logSplits := strings.Split(strings.TrimRight(log, "\n"), "\n")
 
-	// The verifier error is in the second to last line of the log.
-	// There is an extra new line at the end, this is why the -3
-	verifierError := logSplits[len(logSplits)-3]
+	// The verifier error is in the second to last line of the log, the last
+	// one has the statistics. The log of a BTF blob the kernel refused can
+	// be a single line with the error alone, e.g. "hdr_len not found".
+	verifierError := logSplits[len(logSplits)-1]
+	if len(logSplits) > 1 {
+		verifierError = logSplits[len(logSplits)-2]
+	}
 
 	if _, ok := mc.verifierVerdicts[verifierError]; !ok {
 		mc.verifierVerdicts[verifierError] = 1
@@ -118,6 +241,18 @@ func (mc *MetricsCollection) getLatestLog() string {
 	return mc.latestVerifierLog
 }
 
+// getRejectionClasses returns the number of rejections of every class of
+// verifier errors, see errorClass.
+func (mc *MetricsCollection) getRejectionClasses() map[string]int {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+	classes := make(map[string]int)
+	for verdict, count := range mc.verifierVerdicts {
+		classes[errorClass(verdict)] += count
+	}
+	return classes
+}
+
 func (mc *MetricsCollection) getVerifierVerdicts() map[string]int {
 	mc.metricsLock.Lock()
 	defer mc.metricsLock.Unlock()
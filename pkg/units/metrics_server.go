@@ -272,5 +272,6 @@ func (ms *MetricsServer) serve() {
 	http.HandleFunc("/fileCoverage", ms.handleFileCoverage)
 	http.HandleFunc("/latestLog", ms.handleLatestLog)
 	http.HandleFunc("/verifierErrors", ms.handleVerifierErrors)
+	http.HandleFunc("/metrics", ms.handlePrometheus)
 	http.ListenAndServe(fmt.Sprintf("%s:%d", ms.host, ms.port), nil)
 }
@@ -18,9 +18,12 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"buzzer/pkg/logging/logging"
 	fpb "buzzer/proto/ffi_go_proto"
 )
 
@@ -89,7 +92,7 @@ func (mu *Metrics) validationResultProcessingRoutine() {
 		}
 		_, err := mu.metricsCollection.coverageManager.ProcessCoverageAddresses(vres.GetCoverageAddress())
 		if err != nil {
-			fmt.Printf("%q\n", err)
+			logging.Warningf("%q\n", err)
 		}
 		mu.metricsCollection.processVerifierLog(vres)
 	}
@@ -124,6 +127,110 @@ func (mu *Metrics) RecordVerificationResults(vr *fpb.ValidationResult) {
 	mu.enqueueValidationResult(vr)
 }
 
+// RecordTransientFailure records that the program of `vr` could not be
+// loaded because of a transient failure, so it is neither counted as
+// verified nor as rejected by the verifier.
+func (mu *Metrics) RecordTransientFailure(vr *fpb.ValidationResult) {
+	mu.metricsCollection.recordTransientFailure(vr.GetBpfError())
+}
+
+// RecordDroppedProgram records that a program was given up on after every
+// retry of its load failed transiently.
+func (mu *Metrics) RecordDroppedProgram() {
+	mu.metricsCollection.recordDroppedProgram()
+}
+
+// RecordGeneratedProgram records that the strategy generated a program or a
+// filter.
+func (mu *Metrics) RecordGeneratedProgram() {
+	mu.metricsCollection.recordGeneratedProgram()
+}
+
+// RecordExecution records that a program or a filter was executed.
+func (mu *Metrics) RecordExecution() {
+	mu.metricsCollection.recordExecution()
+}
+
+// RecordOracleViolation records a finding, a program that did not behave
+// like the verifier or the strategy expected.
+func (mu *Metrics) RecordOracleViolation() {
+	mu.metricsCollection.recordOracleViolation()
+}
+
+// RecordHelperCall records that a program called the helper `id` at
+// runtime, it returns true if none did before.
+func (mu *Metrics) RecordHelperCall(id int32) bool {
+	return mu.metricsCollection.recordHelperCall(id)
+}
+
+// HelperCalled returns true if a program called the helper `id` at runtime
+// so far.
+func (mu *Metrics) HelperCalled(id int32) bool {
+	return mu.metricsCollection.helperCalled(id)
+}
+
+// CalledHelpers returns the helpers the programs called at runtime so far,
+// sorted by ID.
+func (mu *Metrics) CalledHelpers() []int32 {
+	return mu.metricsCollection.getCalledHelpers()
+}
+
+// StatsLine returns a one line summary of the campaign so far, printed
+// periodically so quiet runs still show their progress.
+func (mu *Metrics) StatsLine() string {
+	verified, valid, _ := mu.metricsCollection.getCounters()
+	generated, executions, violations, start := mu.metricsCollection.getLoopCounters()
+	elapsed := time.Since(start)
+	return fmt.Sprintf("Stats: %d generated, %d verified, %d valid, %d executions (%.0f/s), %d oracle violations in %v", generated, verified, valid, executions, float64(executions)/elapsed.Seconds(), violations, elapsed.Round(time.Second))
+}
+
+// histogramBarWidth is the width of the bar of a class of verifier errors
+// all the rejections are in, see RejectionHistogram.
+const histogramBarWidth = 30
+
+// RejectionHistogram returns the `top` most frequent classes of verifier
+// errors so far and their share of the rejections, one per line, so strategy
+// authors can see what most of their programs die on. The other classes are
+// summed up in a last line. It returns an empty string before the first
+// rejection.
+func (mu *Metrics) RejectionHistogram(top int) string {
+	classes := mu.metricsCollection.getRejectionClasses()
+	total := 0
+	names := make([]string, 0, len(classes))
+	for class, count := range classes {
+		names = append(names, class)
+		total += count
+	}
+	if total == 0 {
+		return ""
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if classes[names[i]] != classes[names[j]] {
+			return classes[names[i]] > classes[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Verifier rejections: %d in %d classes\n", total, len(names))
+	line := func(class string, count int) {
+		bar := strings.Repeat("#", count*histogramBarWidth/total)
+		fmt.Fprintf(&b, "  %5.1f%% %8d %-*s %s\n", float64(count)*100/float64(total), count, histogramBarWidth, bar, class)
+	}
+	other := 0
+	for i, class := range names {
+		if i < top {
+			line(class, classes[class])
+		} else {
+			other += classes[class]
+		}
+	}
+	if other > 0 {
+		line(fmt.Sprintf("(%d other classes)", len(names)-top), other)
+	}
+	return b.String()
+}
+
 func (mu *Metrics) init() {
 	if _, err := os.Stat("/sys/kernel/debug/kcov"); errors.Is(err, os.ErrNotExist) {
 		mu.isKCovSupported = false
@@ -137,6 +244,7 @@ func NewMetricsUnit(threshold int, kcovSize uint64, vmLinuxPath, sourceFilesPath
 	mc := &MetricsCollection{
 		coverageManager:  cm,
 		verifierVerdicts: make(map[string]int),
+		startTime:        time.Now(),
 	}
 	ms := &MetricsServer{
 		host:              metricsServerAddr,
@@ -15,7 +15,9 @@
 package units
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	fpb "buzzer/proto/ffi_go_proto"
 )
@@ -66,3 +68,86 @@ func TestMetrics(t *testing.T) {
 		t.Errorf("len(metricsUnit.validationResultQueue) = %d, want %d", len(metricsUnit.validationResultQueue), 1)
 	}
 }
+
+func TestStatsLine(t *testing.T) {
+	mu := &Metrics{
+		metricsCollection: &MetricsCollection{
+			verifierVerdicts: make(map[string]int),
+			startTime:        time.Now(),
+		},
+	}
+	mu.RecordGeneratedProgram()
+	mu.RecordGeneratedProgram()
+	mu.RecordExecution()
+	mu.RecordOracleViolation()
+	line := mu.StatsLine()
+	for _, want := range []string{"Stats: 2 generated", "1 executions", "1 oracle violations"} {
+		if !strings.Contains(line, want) {
+			t.Errorf("StatsLine() = %q, want it to contain %q", line, want)
+		}
+	}
+}
+
+func TestRejectionHistogram(t *testing.T) {
+	mu := &Metrics{
+		metricsCollection: &MetricsCollection{
+			verifierVerdicts: make(map[string]int),
+		},
+	}
+	if got := mu.RejectionHistogram(10); got != "" {
+		t.Errorf("RejectionHistogram() before any rejection = %q, want \"\"", got)
+	}
+
+	mu.metricsCollection.verifierVerdicts["R1 pointer arithmetic on map_value prohibited"] = 6
+	mu.metricsCollection.verifierVerdicts["R2 pointer arithmetic on map_value prohibited"] = 2
+	mu.metricsCollection.verifierVerdicts["invalid read from stack R10 off=-8 size=8"] = 1
+	mu.metricsCollection.verifierVerdicts["R0 !read_ok"] = 1
+	lines := strings.Split(strings.TrimSuffix(mu.RejectionHistogram(1), "\n"), "\n")
+	want := []string{
+		"Verifier rejections: 10 in 3 classes",
+		"80.0%",
+		"RN pointer arithmetic on map_value prohibited",
+		"20.0%",
+		"(2 other classes)",
+	}
+	if len(lines) != 3 {
+		t.Fatalf("RejectionHistogram() = %q, want 3 lines", lines)
+	}
+	got := strings.Join(lines, "\n")
+	for _, w := range want {
+		if !strings.Contains(got, w) {
+			t.Errorf("RejectionHistogram() = %q, want it to contain %q", got, w)
+		}
+	}
+	if strings.Index(got, "80.0%") > strings.Index(got, "20.0%") {
+		t.Errorf("RejectionHistogram() = %q, want the most frequent class first", got)
+	}
+}
+
+func TestProcessVerifierLog(t *testing.T) {
+	tests := []struct {
+		testName string
+		log      string
+		want     string
+	}{
+		{
+			testName: "verifier log",
+			log:      "0: (b7) r0 = 0\nR0 !read_ok\nprocessed 1 insns (limit 1000000)\n",
+			want:     "R0 !read_ok",
+		},
+		{
+			testName: "short BTF log",
+			log:      "hdr_len not found\n",
+			want:     "hdr_len not found",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			mc := &MetricsCollection{verifierVerdicts: make(map[string]int)}
+			mc.processVerifierLog(&fpb.ValidationResult{VerifierLog: tc.log})
+			if mc.verifierVerdicts[tc.want] != 1 {
+				t.Errorf("processVerifierLog(%q) verdicts = %v, want %q once", tc.log, mc.verifierVerdicts, tc.want)
+			}
+		})
+	}
+}
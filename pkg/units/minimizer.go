@@ -0,0 +1,166 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// Upper bound on how many candidate programs the minimizer will try
+	// for a single finding.
+	maxMinimizationAttempts = 2000
+)
+
+// MinimizationOptOut can optionally be implemented by strategies whose
+// findings cannot be reproduced by loading and executing the program again,
+// e.g. because OnVerifyDone needs the coverage only RunFuzzer collects, or
+// has side effects on the maps that must not be repeated. Their findings are
+// reported as they are.
+type MinimizationOptOut interface {
+	// SkipMinimization returns true if the findings of the strategy should
+	// neither be minimized nor have their guards reduced.
+	SkipMinimization() bool
+}
+
+// skipsMinimization returns true if the strategy opted out of minimization.
+func (cu *Control) skipsMinimization() bool {
+	optOut, ok := cu.strat.(MinimizationOptOut)
+	return ok && optOut.SkipMinimization()
+}
+
+// resetMaps zeroes the elements of the maps of the strategy, if it
+// implements MapOwner, so a candidate is judged on what it writes and not on
+// what the previous executions left in the maps.
+func (cu *Control) resetMaps() error {
+	owner, ok := cu.strat.(MapOwner)
+	if !ok {
+		return nil
+	}
+	for fd, size := range owner.Maps() {
+		for key := uint64(0); key < size; key++ {
+			if cu.ffi.SetMapElement(fd, uint32(key), 0) < 0 {
+				return fmt.Errorf("could not reset element %d of map %d", key, fd)
+			}
+		}
+	}
+	return nil
+}
+
+// reproduces loads and executes `prog` the same way RunFuzzer does and returns
+// true if the strategy still flags the execution as unexpected.
+func (cu *Control) reproduces(prog *epb.Program) bool {
+	encodedProg, err := ebpf.EncodeInstructions(prog)
+	if err != nil {
+		return false
+	}
+
+	// Maps the strategy cannot reset, e.g. frozen ones, would judge the
+	// candidate on stale contents, it does not count as a reproduction.
+	if err := cu.resetMaps(); err != nil {
+		logging.Infof("Cannot reproduce the finding: %v\n", err)
+		return false
+	}
+
+	vres, err := cu.loadProgram(encodedProg)
+	if err != nil {
+		return false
+	}
+	defer cu.ffi.CloseFD(int(vres.GetProgramFd()))
+	if !cu.strat.OnVerifyDone(cu.ffi, vres) || !vres.GetIsValid() {
+		return false
+	}
+	if err := cu.populateKeyedMaps(); err != nil {
+		return false
+	}
+
+	exRes, err := cu.executeProgram(vres.GetProgramFd())
+	if err != nil {
+		return false
+	}
+	exRes.VerifierLog = vres.GetVerifierLog()
+	return !cu.strat.OnExecuteDone(cu.ffi, exRes)
+}
+
+// neutralized returns the instruction that replaces `insn` when trying to
+// simplify it instead of removing it, or nil if there is nothing simpler.
+func neutralized(insn *epb.Instruction) *epb.Instruction {
+	var dst epb.Reg
+	switch c := insn.Opcode.(type) {
+	case *epb.Instruction_AluOpcode:
+		dst = insn.DstReg
+	case *epb.Instruction_MemOpcode:
+		// Only loads write to a register.
+		if c.MemOpcode.InstructionClass != epb.InsClass_InsClassLd && c.MemOpcode.InstructionClass != epb.InsClass_InsClassLdx {
+			return nil
+		}
+		dst = insn.DstReg
+	default:
+		return nil
+	}
+
+	mov := ebpf.Mov64(dst, 0)
+	if proto.Equal(insn, mov) {
+		return nil
+	}
+	return mov
+}
+
+// minimize shrinks `prog` while the strategy keeps reporting it as a finding.
+// Each instruction is first removed and, if the finding goes away, replaced
+// by a MOV of zero to its destination register. The passes are repeated until
+// no more instructions can be dropped or simplified.
+//
+// If the original program does not reproduce the finding, it is returned
+// untouched.
+func (cu *Control) minimize(prog *epb.Program) *epb.Program {
+	attempts := 1
+	if !cu.reproduces(prog) {
+		return prog
+	}
+
+	current := prog
+	for changed := true; changed && attempts < maxMinimizationAttempts; {
+		changed = false
+		// Walk backwards so removing an instruction doesn't shift the ones
+		// still pending in this pass.
+		for i := len(current.Instructions) - 1; i >= 0 && attempts < maxMinimizationAttempts; i-- {
+			candidates := [][]*epb.Instruction{nil}
+			if mov := neutralized(current.Instructions[i]); mov != nil {
+				candidates = append(candidates, []*epb.Instruction{mov})
+			}
+
+			for _, replacement := range candidates {
+				candidate, err := ebpf.ReplaceInstruction(current, i, replacement...)
+				if err != nil {
+					continue
+				}
+				attempts++
+				if cu.reproduces(candidate) {
+					current = candidate
+					changed = true
+					break
+				}
+			}
+		}
+	}
+	return current
+}
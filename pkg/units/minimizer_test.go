@@ -0,0 +1,86 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// controlValueStrategy flags the executions that leave different values in
+// the two elements of its map, like pointer_arithmetic.
+type controlValueStrategy struct {
+	returnStrategy
+	mapFd int
+}
+
+func (s *controlValueStrategy) OnExecuteDone(ffi *FFI, executionResult *fpb.ExecutionResult) bool {
+	elements, err := ffi.GetMapElements(s.mapFd, 2)
+	if err != nil {
+		return true
+	}
+	return elements.Elements[0] == elements.Elements[1]
+}
+
+func (s *controlValueStrategy) Maps() map[int]uint64 {
+	return map[int]uint64{s.mapFd: 2}
+}
+
+func TestResetMaps(t *testing.T) {
+	ffi := &FFI{Maps: NewFakeMaps()}
+	strat := &controlValueStrategy{mapFd: ffi.CreateMapArray(2)}
+	cu := &Control{}
+	cu.Init(ffi, nil, strat)
+
+	// The execution of the original program wrote the control and the
+	// test values, the candidate that drops both stores writes nothing.
+	ffi.SetMapElement(strat.mapFd, 0, 0xCAFE)
+	ffi.SetMapElement(strat.mapFd, 1, 0xCAFE+8)
+	if strat.OnExecuteDone(ffi, &fpb.ExecutionResult{}) {
+		t.Fatalf("OnExecuteDone() = true with the contents of the original execution")
+	}
+	if err := cu.resetMaps(); err != nil {
+		t.Fatalf("resetMaps() = %v, want nil error", err)
+	}
+	if !strat.OnExecuteDone(ffi, &fpb.ExecutionResult{}) {
+		t.Errorf("OnExecuteDone() = false after resetMaps(), the candidate reproduces from stale contents")
+	}
+
+	ffi.FreezeMap(strat.mapFd)
+	if err := cu.resetMaps(); err == nil {
+		t.Errorf("resetMaps() of a frozen map = nil error, want an error")
+	}
+}
+
+func TestSkipsMinimization(t *testing.T) {
+	cu := &Control{}
+	cu.Init(&FFI{Maps: NewFakeMaps()}, nil, &returnStrategy{})
+	if cu.skipsMinimization() {
+		t.Errorf("skipsMinimization() = true for a strategy that does not opt out")
+	}
+	cu.Init(&FFI{Maps: NewFakeMaps()}, nil, &optOutStrategy{})
+	if !cu.skipsMinimization() {
+		t.Errorf("skipsMinimization() = false for a strategy that opts out")
+	}
+}
+
+type optOutStrategy struct {
+	returnStrategy
+}
+
+func (s *optOutStrategy) SkipMinimization() bool {
+	return true
+}
@@ -0,0 +1,100 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"fmt"
+
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// NegativeStrategy can optionally be implemented by strategies whose programs
+// have an invalid encoding on purpose, see ebpf.RandomInvalidEncoding. Their
+// programs skip ebpf.Validate and every one of them the verifier accepts is
+// reported as a finding. Kernel splats logged while rejecting them are
+// reported like for any other program when Control.KernelLog is set.
+type NegativeStrategy interface {
+	// LastInvalidEncoding returns the invalid encoding of the last
+	// generated program.
+	LastInvalidEncoding() ebpf.InvalidEncoding
+}
+
+// malformed returns why ebpf.Validate rejects `prog`, nil if the program is
+// well formed or comes from a NegativeStrategy.
+func (cu *Control) malformed(prog *epb.Program) error {
+	if _, ok := cu.strat.(NegativeStrategy); ok {
+		return nil
+	}
+	return ebpf.Validate(prog)
+}
+
+// checkInvalidEncoding reports `prog` if it comes from a NegativeStrategy and
+// the verifier accepted it.
+func (cu *Control) checkInvalidEncoding(prog *epb.Program, vres *fpb.ValidationResult) {
+	strat, ok := cu.strat.(NegativeStrategy)
+	if !ok || !vres.GetIsValid() {
+		return
+	}
+	invalid := strat.LastInvalidEncoding()
+	cu.reportFinding(&Finding{
+		Description:      fmt.Sprintf("Kernel accepted an invalid instruction encoding %s at index %d", invalid.Name, invalid.Index),
+		Oracle:           OracleInvalidEncoding,
+		Program:          prog,
+		ValidationResult: vres,
+	})
+}
+
+// runNegativeSuite loads a program for every invalid combination of mode,
+// size and class of load and store instructions and reports a finding for
+// each one the kernel accepts.
+//
+// Returns the number of invalid encodings that were accepted.
+func (cu *Control) runNegativeSuite() (int, error) {
+	suite := ebpf.InvalidMemoryEncodings()
+	accepted := 0
+	dropped := 0
+	for _, invalid := range suite {
+		encodedProg, err := ebpf.EncodeInstructions(invalid.Program)
+		if err != nil {
+			return accepted, err
+		}
+		vres, err := cu.loadProgram(encodedProg)
+		var transient *TransientFailure
+		if errors.As(err, &transient) {
+			dropped++
+			continue
+		}
+		if err != nil {
+			return accepted, err
+		}
+		if !vres.GetIsValid() {
+			continue
+		}
+		cu.ffi.CloseFD(int(vres.GetProgramFd()))
+		accepted++
+		cu.reportFinding(&Finding{
+			Description:      fmt.Sprintf("Kernel accepted an invalid memory instruction encoding %s at index %d", invalid.Name, invalid.Index),
+			Oracle:           OracleInvalidEncoding,
+			Program:          invalid.Program,
+			ValidationResult: vres,
+		})
+	}
+	logging.Reportf("Negative suite: %d invalid encodings, %d accepted, %d dropped\n", len(suite), accepted, dropped)
+	return accepted, nil
+}
@@ -0,0 +1,94 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// negativeStrategy is a NegativeStrategy that always generates the same
+// invalid encoding.
+type negativeStrategy struct {
+	idleStrategy
+	invalid ebpf.InvalidEncoding
+}
+
+func (s *negativeStrategy) GenerateProgram(ffi *FFI) (*epb.Program, error) {
+	s.attempts++
+	return s.invalid.Program, nil
+}
+
+func (s *negativeStrategy) LastInvalidEncoding() ebpf.InvalidEncoding {
+	return s.invalid
+}
+
+func TestCheckInvalidEncoding(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	invalid := ebpf.InvalidMemoryEncodings()[0]
+
+	tests := []struct {
+		testName      string
+		strategy      Strategy
+		validation    *fpb.ValidationResult
+		wantMalformed bool
+		wantFinding   bool
+	}{
+		{
+			testName:    "Rejected invalid encoding",
+			strategy:    &negativeStrategy{invalid: invalid},
+			validation:  VerifierRejection("unknown opcode"),
+			wantFinding: false,
+		},
+		{
+			testName:    "Accepted invalid encoding",
+			strategy:    &negativeStrategy{invalid: invalid},
+			validation:  VerifierAcceptance(),
+			wantFinding: true,
+		},
+		{
+			testName:      "Program of another strategy",
+			strategy:      &returnStrategy{},
+			validation:    VerifierAcceptance(),
+			wantMalformed: true,
+			wantFinding:   false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			hook := &collectingHook{}
+			cu := &Control{FindingHooks: []FindingHook{hook}}
+			cu.Init(&FFI{Maps: NewFakeMaps()}, nil, tc.strategy)
+			prog := &epb.Program{Instructions: []*epb.Instruction{ebpf.Mov64(ebpf.R0, 0), ebpf.Exit()}}
+			prog.Instructions[0].DstReg = epb.Reg(12)
+
+			if err := cu.malformed(prog); (err != nil) != tc.wantMalformed {
+				t.Errorf("malformed() = %v, want malformed %v", err, tc.wantMalformed)
+			}
+			cu.checkInvalidEncoding(prog, tc.validation)
+			if got := len(hook.findings) > 0; got != tc.wantFinding {
+				t.Fatalf("finding reported = %v, want %v", got, tc.wantFinding)
+			}
+			if tc.wantFinding && hook.findings[0].Oracle != OracleInvalidEncoding {
+				t.Errorf("Oracle = %v, want %v", hook.findings[0].Oracle, OracleInvalidEncoding)
+			}
+		})
+	}
+}
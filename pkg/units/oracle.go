@@ -0,0 +1,145 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// Verdict is what an Oracle makes of an execution.
+type Verdict struct {
+	// Flagged reports the execution as a finding of the oracle.
+	Flagged bool
+
+	// Veto means the execution can't be judged, e.g. it raced with
+	// something else, the flags of the other oracles are dropped.
+	Veto bool
+
+	// Description says what is wrong with the execution.
+	Description string
+}
+
+// Pass is the verdict of an oracle with nothing to say about an execution.
+var Pass = Verdict{}
+
+// Flag returns the verdict reporting an execution as a finding described by
+// `format`.
+func Flag(format string, args ...any) Verdict {
+	return Verdict{Flagged: true, Description: fmt.Sprintf(format, args...)}
+}
+
+// Veto returns the verdict dropping the findings of an execution that can't
+// be judged, for the reason described by `format`.
+func Veto(format string, args ...any) Verdict {
+	return Verdict{Veto: true, Description: fmt.Sprintf(format, args...)}
+}
+
+// Oracle checks the executions of the programs the verifier accepted. Every
+// execution goes through several of them, the check of the strategy, the
+// built-in ones and those of Control.Oracles, each of them can flag it as a
+// finding or veto it.
+type Oracle interface {
+	// Name identifies the oracle in the findings it reports, see
+	// Finding.Oracle.
+	Name() string
+
+	// Inspect returns the verdict on the execution `exRes` of `prog`. The
+	// verifier log of the program is in exRes.VerifierLog.
+	Inspect(prog *epb.Program, exRes *fpb.ExecutionResult) Verdict
+}
+
+// funcOracle is an Oracle made of a name and a function, see NewOracle.
+type funcOracle struct {
+	name    string
+	inspect func(prog *epb.Program, exRes *fpb.ExecutionResult) Verdict
+}
+
+// NewOracle returns the oracle `name` whose verdicts come from `inspect`.
+func NewOracle(name string, inspect func(prog *epb.Program, exRes *fpb.ExecutionResult) Verdict) Oracle {
+	return &funcOracle{name: name, inspect: inspect}
+}
+
+func (fo *funcOracle) Name() string {
+	return fo.name
+}
+
+func (fo *funcOracle) Inspect(prog *epb.Program, exRes *fpb.ExecutionResult) Verdict {
+	return fo.inspect(prog, exRes)
+}
+
+// strategyOracle is the check of the executions done by the strategy, see
+// Strategy.OnExecuteDone.
+type strategyOracle struct {
+	cu *Control
+}
+
+func (so *strategyOracle) Name() string {
+	return OracleExecution
+}
+
+func (so *strategyOracle) Inspect(prog *epb.Program, exRes *fpb.ExecutionResult) Verdict {
+	if so.cu.strat.OnExecuteDone(so.cu.ffi, exRes) {
+		return Pass
+	}
+	return Flag("Program produced unexpected results")
+}
+
+// oracles returns the oracles of an execution after which the maps were in
+// the state `after`, nil if they were not snapshotted: the check of the
+// strategy, the built-in oracles and then Oracles.
+func (cu *Control) oracles(after mapSnapshot) []Oracle {
+	builtin := []Oracle{
+		&strategyOracle{cu: cu},
+		&exitCodeOracle{cu: cu},
+		&mapDeltaOracle{cu: cu, after: after},
+	}
+	return append(builtin, cu.Oracles...)
+}
+
+// inspectExecution runs `oracles` on the execution `exRes` of `prog` and
+// reports the findings they flag, unless one of them vetoes the execution.
+// The findings of the strategy are minimized, see reportUnexpectedResult.
+func (cu *Control) inspectExecution(prog *epb.Program, vres *fpb.ValidationResult, exRes *fpb.ExecutionResult, oracles []Oracle) {
+	var flagged []Oracle
+	var verdicts []Verdict
+	for _, oracle := range oracles {
+		verdict := oracle.Inspect(prog, exRes)
+		if verdict.Veto {
+			logging.Infof("Oracle %s vetoed the execution: %s\n", oracle.Name(), verdict.Description)
+			return
+		}
+		if verdict.Flagged {
+			flagged = append(flagged, oracle)
+			verdicts = append(verdicts, verdict)
+		}
+	}
+	for i, oracle := range flagged {
+		if _, ok := oracle.(*strategyOracle); ok {
+			cu.reportUnexpectedResult(prog, vres, exRes)
+			continue
+		}
+		cu.reportFinding(&Finding{
+			Description:      verdicts[i].Description,
+			Oracle:           oracle.Name(),
+			Program:          prog,
+			ValidationResult: vres,
+			ExecutionResult:  exRes,
+		})
+	}
+}
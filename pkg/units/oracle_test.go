@@ -0,0 +1,98 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"sort"
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// verdictOracle returns the oracle `name` whose verdict is always `verdict`.
+func verdictOracle(name string, verdict Verdict) Oracle {
+	return NewOracle(name, func(*epb.Program, *fpb.ExecutionResult) Verdict {
+		return verdict
+	})
+}
+
+func TestInspectExecution(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir())
+
+	prog := &epb.Program{Instructions: []*epb.Instruction{ebpf.Mov64(ebpf.R0, 0), ebpf.Exit()}}
+	tests := []struct {
+		testName  string
+		execution *fpb.ExecutionResult
+		oracles   []Oracle
+		want      []string
+	}{
+		{
+			testName:  "Nothing flagged",
+			execution: &fpb.ExecutionResult{DidSucceed: true},
+			oracles:   []Oracle{verdictOracle("range", Pass)},
+		},
+		{
+			testName:  "Every flag is a finding",
+			execution: &fpb.ExecutionResult{DidSucceed: true, Retval: 1},
+			oracles: []Oracle{
+				verdictOracle("range", Flag("out of range")),
+				verdictOracle("differential", Pass),
+				verdictOracle("crash", Flag("crashed")),
+			},
+			want: []string{"crash", OracleExecution, "range"},
+		},
+		{
+			testName:  "Veto drops the flags",
+			execution: &fpb.ExecutionResult{DidSucceed: true, Retval: 1},
+			oracles: []Oracle{
+				verdictOracle("range", Flag("out of range")),
+				verdictOracle("differential", Veto("raced")),
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			hook := &collectingHook{}
+			cu := &Control{FindingHooks: []FindingHook{hook}, Oracles: tc.oracles}
+			cu.Init(&FFI{Maps: NewFakeMaps()}, nil, &returnStrategy{})
+			cu.inspectExecution(prog, VerifierAcceptance(), tc.execution, cu.oracles(nil))
+			var got []string
+			for _, f := range hook.findings {
+				got = append(got, f.Oracle)
+			}
+			sort.Strings(got)
+			if len(got) != len(tc.want) {
+				t.Fatalf("findings of %v, want %v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("findings of %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestFlagDescription(t *testing.T) {
+	if v := Flag("returned %d", 3); !v.Flagged || v.Veto || v.Description != "returned 3" {
+		t.Errorf("Flag() = %+v, want a flag described as returned 3", v)
+	}
+	if v := Veto("raced"); v.Flagged || !v.Veto || v.Description != "raced" {
+		t.Errorf("Veto() = %+v, want a veto described as raced", v)
+	}
+}
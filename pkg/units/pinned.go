@@ -0,0 +1,227 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	// kallsymsPath lists the addresses of the kernel symbols, the calls of
+	// xlated programs point to the helpers by address.
+	kallsymsPath = "/proc/kallsyms"
+
+	// callBaseSymbol is the symbol the immediates of xlated helper calls are
+	// relative to.
+	callBaseSymbol = "__bpf_call_base"
+
+	// auxReg is BPF_REG_AX, the register the verifier uses in the
+	// instructions it adds to a program, e.g. to sanitize pointer
+	// arithmetic. It can't be used by loaded programs.
+	auxReg = epb.Reg(11)
+)
+
+// helperAliases are the helpers whose implementation is not named after them.
+var helperAliases = map[string]int32{
+	"bpf_user_rnd_u32":   ebpf.GetPrandomU32,
+	"bpf_get_raw_cpu_id": ebpf.GetSmpProcessorId,
+}
+
+// parseKallsyms returns the name of the symbols of `r`, in the format of
+// /proc/kallsyms, by address. Only the symbols helpers can be called
+// through are kept.
+func parseKallsyms(r io.Reader) (map[uint64]string, error) {
+	symbols := make(map[uint64]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		name := fields[2]
+		if name != callBaseSymbol && !strings.Contains(name, "bpf_") && !strings.Contains(name, "map_") {
+			continue
+		}
+		address, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil || address == 0 {
+			continue
+		}
+		symbols[address] = name
+	}
+	return symbols, scanner.Err()
+}
+
+// helperBySymbol returns the helper implemented by the kernel function
+// `name`. The verifier replaces the calls to the map helpers with direct
+// calls to the implementation of the map type, e.g. array_map_lookup_elem,
+// those are matched by suffix.
+func helperBySymbol(name string) *ebpf.HelperPrototype {
+	if id, ok := helperAliases[name]; ok {
+		return ebpf.HelperPrototypeByID(id)
+	}
+	for _, hp := range ebpf.HelperPrototypes {
+		if name == "bpf_"+hp.Name {
+			return hp
+		}
+	}
+	for _, hp := range ebpf.HelperPrototypes {
+		if strings.HasPrefix(hp.Name, "map_") && strings.HasSuffix(name, "_"+hp.Name) {
+			return hp
+		}
+	}
+	return nil
+}
+
+// usesAuxReg returns true if `i` uses BPF_REG_AX.
+func usesAuxReg(i *epb.Instruction) bool {
+	return i.DstReg >= auxReg || i.SrcReg >= auxReg
+}
+
+// inlinedArrayLookupEnd returns the index of the last instruction of the
+// array map lookup the verifier inlined at `index`, see array_map_gen_lookup:
+//
+//	r1 += offsetof(struct bpf_array, value)
+//	r0 = *(u32 *)(r2 +0)
+//	if r0 >= max_entries goto pc+n
+//	...
+//	r0 += r1
+//	goto pc+1
+//	r0 = 0
+func inlinedArrayLookupEnd(insns []*epb.Instruction, index int) (int, bool) {
+	if index+3 >= len(insns) {
+		return 0, false
+	}
+	add, ok := insns[index].Opcode.(*epb.Instruction_AluOpcode)
+	if !ok || add.AluOpcode.OperationCode != epb.AluOperationCode_AluAdd || add.AluOpcode.Source != epb.SrcOperand_Immediate || insns[index].DstReg != epb.Reg_R1 {
+		return 0, false
+	}
+	if !proto.Equal(insns[index+1], ebpf.LdW(epb.Reg_R0, epb.Reg_R2, 0)) {
+		return 0, false
+	}
+	bound, ok := insns[index+2].Opcode.(*epb.Instruction_JmpOpcode)
+	if !ok || bound.JmpOpcode.OperationCode != epb.JmpOperationCode_JmpJGE || insns[index+2].DstReg != epb.Reg_R0 || insns[index+2].Offset < 2 {
+		return 0, false
+	}
+	end := index + 3 + int(insns[index+2].Offset)
+	if end >= len(insns) || !proto.Equal(insns[end-1], ebpf.Jmp(1)) || !proto.Equal(insns[end], ebpf.Mov64(epb.Reg_R0, 0)) {
+		return 0, false
+	}
+	return end, true
+}
+
+// foldInlinedLookups replaces the array map lookups the verifier inlined with
+// calls to the helper, the inlined code only verifies in the kernel.
+func foldInlinedLookups(program *epb.Program) (*epb.Program, error) {
+	var err error
+	for i := len(program.Instructions) - 1; i >= 0; i-- {
+		end, ok := inlinedArrayLookupEnd(program.Instructions, i)
+		if !ok {
+			continue
+		}
+		for j := end; j > i; j-- {
+			if program, err = ebpf.RemoveInstruction(program, j); err != nil {
+				return nil, err
+			}
+		}
+		if program, err = ebpf.ReplaceInstruction(program, i, ebpf.Call(ebpf.MapLookup)); err != nil {
+			return nil, err
+		}
+	}
+	return program, nil
+}
+
+// ProgramFromXlated decodes the xlated instructions of a loaded program into
+// a program that can be loaded again, e.g. to use it as a mutation seed.
+//
+// The verifier rewrites programs as it loads them, so this is a best effort:
+// helper calls are resolved with `symbols`, see parseKallsyms, and the ones
+// that can't be become `r0 = 0`. The instructions the verifier added that use
+// BPF_REG_AX are removed and the inlined array map lookups are folded back
+// into helper calls. Map loads hold the id of the map instead of an fd.
+func ProgramFromXlated(xlated []uint64, symbols map[uint64]string) (*epb.Program, error) {
+	program, err := ebpf.DecodeInstructions(xlated)
+	if err != nil {
+		return nil, err
+	}
+
+	var callBase uint64
+	for address, name := range symbols {
+		if name == callBaseSymbol {
+			callBase = address
+		}
+	}
+	for i := len(program.Instructions) - 1; i >= 0; i-- {
+		insn := program.Instructions[i]
+		if usesAuxReg(insn) {
+			if program, err = ebpf.RemoveInstruction(program, i); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		jmp, ok := insn.Opcode.(*epb.Instruction_JmpOpcode)
+		if !ok || jmp.JmpOpcode.OperationCode != epb.JmpOperationCode_JmpCALL || insn.SrcReg == ebpf.PseudoCall {
+			continue
+		}
+		var hp *ebpf.HelperPrototype
+		if callBase != 0 {
+			hp = helperBySymbol(symbols[callBase+uint64(int64(insn.Immediate))])
+		}
+		if hp != nil {
+			insn.Immediate = hp.ID
+			insn.SrcReg = epb.Reg_R0
+		} else {
+			program.Instructions[i] = ebpf.Mov64(epb.Reg_R0, 0)
+		}
+	}
+	return foldInlinedLookups(program)
+}
+
+// LoadPinnedProgram returns the program pinned at `path` in a bpffs, see
+// ProgramFromXlated. Helper calls are only resolved if the kernel shows
+// the addresses of its symbols and the xlated calls to root.
+func LoadPinnedProgram(ffi *FFI, path string) (*epb.Program, error) {
+	fd := ffi.GetPinnedObject(path)
+	if fd < 0 {
+		return nil, fmt.Errorf("could not open the program pinned at %q", path)
+	}
+	defer ffi.CloseFD(fd)
+
+	info, err := ffi.GetProgramInfo(fd)
+	if err != nil {
+		return nil, err
+	}
+	if info.GetErrorMessage() != "" {
+		return nil, fmt.Errorf("could not get the xlated instructions of %q: %s", path, info.GetErrorMessage())
+	}
+
+	var symbols map[uint64]string
+	if f, err := os.Open(kallsymsPath); err == nil {
+		symbols, err = parseKallsyms(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ProgramFromXlated(info.GetXlatedInstructions(), symbols)
+}
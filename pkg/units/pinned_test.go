@@ -0,0 +1,126 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"strings"
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	protobuf "github.com/golang/protobuf/proto"
+)
+
+const testKallsyms = `ffffffff81000000 T __bpf_call_base
+ffffffff81000100 T bpf_ktime_get_ns
+ffffffff81000200 t array_map_lookup_elem
+ffffffff81000300 T some_bpf_function
+ffffffff81000400 T do_sys_open
+ffffffff81000500 T bpf_user_rnd_u32
+0000000000000000 T bpf_get_prandom_u32
+`
+
+func TestParseKallsyms(t *testing.T) {
+	symbols, err := parseKallsyms(strings.NewReader(testKallsyms))
+	if err != nil {
+		t.Fatalf("parseKallsyms() = %v, want nil error", err)
+	}
+	want := map[uint64]string{
+		0xffffffff81000000: "__bpf_call_base",
+		0xffffffff81000100: "bpf_ktime_get_ns",
+		0xffffffff81000200: "array_map_lookup_elem",
+		0xffffffff81000300: "some_bpf_function",
+		0xffffffff81000500: "bpf_user_rnd_u32",
+	}
+	if len(symbols) != len(want) {
+		t.Errorf("parseKallsyms() = %v, want %v", symbols, want)
+	}
+	for address, name := range want {
+		if symbols[address] != name {
+			t.Errorf("parseKallsyms()[%#x] = %q, want %q", address, symbols[address], name)
+		}
+	}
+}
+
+func TestProgramFromXlated(t *testing.T) {
+	symbols, err := parseKallsyms(strings.NewReader(testKallsyms))
+	if err != nil {
+		t.Fatalf("parseKallsyms() = %v", err)
+	}
+	xlated, err := EncodeInstructions(&epb.Program{Instructions: []*epb.Instruction{
+		JmpEQ(R1, 0, 3),
+		Call(0x100),
+		Mov64(auxReg, 1),
+		Add64(R0, auxReg),
+		Call(0x200),
+		Call(0x300),
+		Call(0x500),
+		// Inlined array map lookup.
+		Add64(R1, 312),
+		LdW(R0, R2, 0),
+		JmpGE(R0, 2, 3),
+		Lsh64(R0, 3),
+		Add64(R0, R1),
+		Jmp(1),
+		Mov64(R0, 0),
+		Exit(),
+	}})
+	if err != nil {
+		t.Fatalf("EncodeInstructions() = %v", err)
+	}
+
+	tests := []struct {
+		testName string
+		symbols  map[uint64]string
+		want     []*epb.Instruction
+	}{
+		{
+			testName: "Resolved calls",
+			symbols:  symbols,
+			want: []*epb.Instruction{
+				JmpEQ(R1, 0, 1),
+				Call(KtimeGetNs),
+				Call(MapLookup),
+				Mov64(R0, 0),
+				Call(GetPrandomU32),
+				Call(MapLookup),
+				Exit(),
+			},
+		},
+		{
+			testName: "Without symbols",
+			want: []*epb.Instruction{
+				JmpEQ(R1, 0, 1),
+				Mov64(R0, 0),
+				Mov64(R0, 0),
+				Mov64(R0, 0),
+				Mov64(R0, 0),
+				Call(MapLookup),
+				Exit(),
+			},
+		},
+	}
+
+	for _, c := range tests {
+		got, err := ProgramFromXlated(xlated, c.symbols)
+		if err != nil {
+			t.Errorf("%s: ProgramFromXlated() = %v, want nil error", c.testName, err)
+			continue
+		}
+		if want := (&epb.Program{Instructions: c.want}); !protobuf.Equal(got, want) {
+			t.Errorf("%s: ProgramFromXlated() = %v, want %v", c.testName, got, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// labelEscaper escapes the characters the Prometheus text format does not
+// allow in label values.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// writeMetric writes the help, type and samples of a metric in the
+// Prometheus text format. `samples` maps the label sets, already formatted,
+// to their values.
+func writeMetric(w io.Writer, name, kind, help string, samples map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+	labels := make([]string, 0, len(samples))
+	for l := range samples {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		fmt.Fprintf(w, "%s%s %v\n", name, l, samples[l])
+	}
+}
+
+// writePrometheus writes the counters of `mc` in the Prometheus text format,
+// rates are computed since `now`.
+func (mc *MetricsCollection) writePrometheus(w io.Writer, now time.Time) {
+	mc.metricsLock.Lock()
+	defer mc.metricsLock.Unlock()
+
+	writeMetric(w, "buzzer_programs_generated_total", "counter", "Programs and filters generated by the strategy.", map[string]float64{"": float64(mc.programsGenerated)})
+	writeMetric(w, "buzzer_verifier_accepts_total", "counter", "Programs the verifier accepted.", map[string]float64{"": float64(mc.validPrograms)})
+	writeMetric(w, "buzzer_verifier_rejects_total", "counter", "Programs the verifier rejected.", map[string]float64{"": float64(mc.programsVerified - mc.validPrograms)})
+
+	classes := make(map[string]float64)
+	for verdict, count := range mc.verifierVerdicts {
+		classes[fmt.Sprintf("{class=\"%s\"}", labelEscaper.Replace(errorClass(verdict)))] += float64(count)
+	}
+	writeMetric(w, "buzzer_verifier_errors_total", "counter", "Verifier rejections by error class, the numbers of the messages are masked.", classes)
+
+	writeMetric(w, "buzzer_executions_total", "counter", "Programs and filters executed.", map[string]float64{"": float64(mc.executions)})
+	writeMetric(w, "buzzer_oracle_violations_total", "counter", "Programs that did not behave like the verifier or the strategy expected.", map[string]float64{"": float64(mc.oracleViolations)})
+	writeMetric(w, "buzzer_dropped_programs_total", "counter", "Programs given up on after their loads kept failing transiently.", map[string]float64{"": float64(mc.droppedPrograms)})
+
+	// kcov reports the addresses the programs went through, not the edges
+	// between them.
+	coverage := 0
+	if mc.coverageManager != nil {
+		coverage = mc.coverageManager.CoveredAddresses()
+	}
+	writeMetric(w, "buzzer_covered_addresses", "gauge", "Distinct kernel addresses kcov reported for all the programs so far.", map[string]float64{"": float64(coverage)})
+
+	writeMetric(w, "buzzer_helpers_called", "gauge", "Helper functions the programs called at runtime at least once.", map[string]float64{"": float64(len(mc.calledHelpers))})
+
+	rate := 0.0
+	if elapsed := now.Sub(mc.startTime).Seconds(); !mc.startTime.IsZero() && elapsed > 0 {
+		rate = float64(mc.executions) / elapsed
+	}
+	writeMetric(w, "buzzer_executions_per_second", "gauge", "Average executions per second since the fuzzer started.", map[string]float64{"": rate})
+}
+
+func (ms *MetricsServer) handlePrometheus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	ms.metricsCollection.writePrometheus(w, time.Now())
+}
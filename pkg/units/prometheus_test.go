@@ -0,0 +1,64 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	start := time.Unix(1000, 0)
+	mc := &MetricsCollection{
+		programsVerified: 10,
+		validPrograms:    4,
+		verifierVerdicts: map[string]int{
+			"R1 invalid mem access 'scalar'": 2,
+			"R3 invalid mem access 'scalar'": 1,
+			"math between \"pkt\" pointers":  3,
+		},
+		programsGenerated: 12,
+		executions:        20,
+		oracleViolations:  1,
+		startTime:         start,
+		calledHelpers:     map[int32]bool{1: true, 7: true},
+		coverageManager: &CoverageManager{
+			coverageCache:   map[uint64]string{0x10: "verifier.c:1", 0x20: "verifier.c:2", 0x30: "verifier.c:2"},
+			lastMaxCoverage: 5,
+		},
+	}
+
+	var out strings.Builder
+	mc.writePrometheus(&out, start.Add(10*time.Second))
+	got := out.String()
+
+	for _, want := range []string{
+		"# TYPE buzzer_programs_generated_total counter\nbuzzer_programs_generated_total 12\n",
+		"buzzer_verifier_accepts_total 4\n",
+		"buzzer_verifier_rejects_total 6\n",
+		"buzzer_verifier_errors_total{class=\"RN invalid mem access 'scalar'\"} 3\n",
+		"buzzer_verifier_errors_total{class=\"math between \\\"pkt\\\" pointers\"} 3\n",
+		"buzzer_executions_total 20\n",
+		"buzzer_oracle_violations_total 1\n",
+		"buzzer_covered_addresses 3\n",
+		"# TYPE buzzer_helpers_called gauge\nbuzzer_helpers_called 2\n",
+		"# TYPE buzzer_executions_per_second gauge\nbuzzer_executions_per_second 2\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("writePrometheus() output is missing %q, got:\n%s", want, got)
+		}
+	}
+}
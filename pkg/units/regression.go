@@ -0,0 +1,175 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// RegressionCase is a program written by hand, e.g. with
+// ebpf.ProgramBuilder, along with what the kernel must do with it.
+type RegressionCase struct {
+	Name    string
+	Program *epb.Program
+
+	// ProgramType is the BPF_PROG_TYPE_* to load the program as, socket
+	// filter if 0.
+	ProgramType int
+
+	// WantValid is true if the verifier must accept the program.
+	WantValid bool
+
+	// Check, if set, verifies the execution of the accepted program, e.g.
+	// its return value, see WantRetval, or the maps it wrote to.
+	Check func(ffi *FFI, res *fpb.ExecutionResult) error
+}
+
+// WantRetval returns a RegressionCase.Check that the program returned
+// `retval`. Only executions with BPF_PROG_TEST_RUN have a return value, see
+// Control.TestRun.
+func WantRetval(retval uint32) func(*FFI, *fpb.ExecutionResult) error {
+	return func(ffi *FFI, res *fpb.ExecutionResult) error {
+		if res.GetRetval() != retval {
+			return fmt.Errorf("program returned %#x, want %#x", res.GetRetval(), retval)
+		}
+		return nil
+	}
+}
+
+// RegressionStrategy runs hand written programs, in order, through the same
+// pipeline as the generated ones: the oracles of Control apply to them and
+// the accepted programs that must be rejected, or whose execution fails its
+// check, are reported as findings. The failed cases, including the rejected
+// programs that must be accepted, are also kept for the test that runs them:
+//
+//	strat := units.NewRegressionStrategy(cases...)
+//	cu := &units.Control{TestRun: true}
+//	cu.Init(&units.FFI{}, nil, strat)
+//	if err := cu.RunFuzzer(); err != nil { ... }
+//	for _, err := range strat.Failures() { t.Error(err) }
+type RegressionStrategy struct {
+	cases    []*RegressionCase
+	next     int
+	failures []error
+}
+
+// NewRegressionStrategy returns a strategy that runs `cases` once.
+func NewRegressionStrategy(cases ...*RegressionCase) *RegressionStrategy {
+	return &RegressionStrategy{cases: cases}
+}
+
+// current returns the case of the last generated program.
+func (rs *RegressionStrategy) current() *RegressionCase {
+	if rs.next == 0 {
+		return nil
+	}
+	return rs.cases[rs.next-1]
+}
+
+// fail records a failure of the current case.
+func (rs *RegressionStrategy) fail(format string, args ...any) {
+	err := fmt.Errorf("%s: %s", rs.current().Name, fmt.Sprintf(format, args...))
+	logging.Warningf("%v\n", err)
+	rs.failures = append(rs.failures, err)
+}
+
+// Failures returns the failures of the cases run so far.
+func (rs *RegressionStrategy) Failures() []error {
+	return rs.failures
+}
+
+// GenerateProgram should return the instructions to feed the verifier.
+func (rs *RegressionStrategy) GenerateProgram(ffi *FFI) (*epb.Program, error) {
+	if rs.next >= len(rs.cases) {
+		return nil, fmt.Errorf("all the regression cases ran")
+	}
+	rs.next++
+	return rs.current().Program, nil
+}
+
+// ProgramType returns the type the current program is loaded as.
+func (rs *RegressionStrategy) ProgramType() int {
+	if c := rs.current(); c != nil && c.ProgramType != 0 {
+		return c.ProgramType
+	}
+	return ProgTypeSocketFilter
+}
+
+// Decisions returns the name of the current case for the decision log.
+func (rs *RegressionStrategy) Decisions() []string {
+	return []string{rs.current().Name}
+}
+
+// OnVerifyDone process the results from the verifier. Here the strategy
+// can also tell the fuzzer to continue with execution by returning true
+// or start over and generate a new program by returning false.
+func (rs *RegressionStrategy) OnVerifyDone(ffi *FFI, verificationResult *fpb.ValidationResult) bool {
+	c := rs.current()
+	switch {
+	case verificationResult.IsValid && !c.WantValid:
+		// Executed anyway so the acceptance is reported as a finding.
+		rs.fail("verifier accepted the program, want a rejection")
+	case !verificationResult.IsValid && c.WantValid:
+		rs.fail("verifier rejected the program: %s", verificationResult.GetBpfError())
+	}
+	return verificationResult.IsValid
+}
+
+// OnExecuteDone should validate if the program behaved like the
+// verifier expected, if that was not the case it should return false.
+func (rs *RegressionStrategy) OnExecuteDone(ffi *FFI, executionResult *fpb.ExecutionResult) bool {
+	c := rs.current()
+	if !c.WantValid {
+		return false
+	}
+	if !executionResult.GetDidSucceed() {
+		rs.fail("execution failed: %s", executionResult.GetErrorMessage())
+		return true
+	}
+	if c.Check == nil {
+		return true
+	}
+	if err := c.Check(ffi, executionResult); err != nil {
+		rs.fail("%v", err)
+		return false
+	}
+	return true
+}
+
+// OnError is used to determine if the fuzzer should continue on errors.
+// true represents continue, false represents halt.
+func (rs *RegressionStrategy) OnError(e error) bool {
+	if rs.current() == nil {
+		return false
+	}
+	// The other cases still run.
+	rs.fail("%v", e)
+	return true
+}
+
+// IsFuzzingDone if true, buzzer will break out of the main fuzzing loop
+// and return normally.
+func (rs *RegressionStrategy) IsFuzzingDone() bool {
+	return rs.next >= len(rs.cases)
+}
+
+// Name is used to select the strategy based on a command line flag.
+func (rs *RegressionStrategy) Name() string {
+	return "regression"
+}
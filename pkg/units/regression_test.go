@@ -0,0 +1,124 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestRegressionStrategy(t *testing.T) {
+	prog, err := NewProgramBuilder().Mov64(R0, 1).Exit().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	retval := func(r uint32) func(*FFI, *epb.Program) *fpb.ExecutionResult {
+		return func(*FFI, *epb.Program) *fpb.ExecutionResult {
+			return &fpb.ExecutionResult{DidSucceed: true, Retval: r}
+		}
+	}
+
+	tests := []struct {
+		testName     string
+		c            *RegressionCase
+		response     CannedResponse
+		wantExecuted bool
+		wantExpected bool
+		wantFailure  bool
+	}{
+		{
+			testName:     "Accepted with the expected return value",
+			c:            &RegressionCase{WantValid: true, Check: WantRetval(1)},
+			response:     CannedResponse{Validation: VerifierAcceptance(), Execute: retval(1)},
+			wantExecuted: true,
+			wantExpected: true,
+		},
+		{
+			testName:     "Accepted with another return value",
+			c:            &RegressionCase{WantValid: true, Check: WantRetval(1)},
+			response:     CannedResponse{Validation: VerifierAcceptance(), Execute: retval(0)},
+			wantExecuted: true,
+			wantFailure:  true,
+		},
+		{
+			testName:    "Rejected but must be accepted",
+			c:           &RegressionCase{WantValid: true},
+			response:    CannedResponse{Validation: VerifierRejection("R0 !read_ok")},
+			wantFailure: true,
+		},
+		{
+			testName:     "Accepted but must be rejected",
+			c:            &RegressionCase{},
+			response:     CannedResponse{Validation: VerifierAcceptance()},
+			wantExecuted: true,
+			wantFailure:  true,
+		},
+		{
+			testName: "Rejected",
+			c:        &RegressionCase{},
+			response: CannedResponse{Validation: VerifierRejection("R0 !read_ok")},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			tc.c.Name = tc.testName
+			tc.c.Program = prog
+			strat := NewRegressionStrategy(tc.c)
+			h := NewStrategyHarness(strat)
+			res, err := h.Step(tc.response)
+			if err != nil {
+				t.Fatalf("Step() failed: %v", err)
+			}
+			if res.Program != prog {
+				t.Errorf("strategy generated %v, want the program of the case", res.Program)
+			}
+			if res.Executed != tc.wantExecuted || res.Expected != tc.wantExpected {
+				t.Errorf("executed = %v, expected = %v, want %v, %v", res.Executed, res.Expected, tc.wantExecuted, tc.wantExpected)
+			}
+			if got := len(strat.Failures()) > 0; got != tc.wantFailure {
+				t.Errorf("failures = %v, want failure %v", strat.Failures(), tc.wantFailure)
+			}
+			if !strat.IsFuzzingDone() {
+				t.Errorf("IsFuzzingDone() = false after the only case")
+			}
+		})
+	}
+}
+
+// TestRegressionStrategyDocumentedSetup runs the cases the way the
+// documentation of RegressionStrategy does, with a bare FFI.
+func TestRegressionStrategyDocumentedSetup(t *testing.T) {
+	prog, err := NewProgramBuilder().Mov64(R0, 1).Exit().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	strat := NewRegressionStrategy(&RegressionCase{Name: "return 1", Program: prog, WantValid: true, Check: WantRetval(1)})
+	cu := &Control{TestRun: true}
+	if err := cu.Init(&FFI{}, nil, strat); err != nil {
+		t.Fatal(err)
+	}
+	// Whether the case passes depends on the privileges of the test, it
+	// must run to completion either way.
+	if err := cu.RunFuzzer(); err != nil {
+		t.Fatalf("RunFuzzer() error = %v", err)
+	}
+	if !strat.IsFuzzingDone() {
+		t.Errorf("IsFuzzingDone() = false after RunFuzzer()")
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	cpb "buzzer/proto/corpus_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+// ReplayRun is the outcome of one replay of a program: the verifier results,
+// whose log is at BPF_LOG_LEVEL2, and the execution results if the verifier
+// accepted the program.
+type ReplayRun struct {
+	Validation *fpb.ValidationResult
+	Execution  *fpb.ExecutionResult
+}
+
+// outcome returns what runs of a reproducible program agree on: the verdict,
+// the error and how the execution went.
+func (r *ReplayRun) outcome() string {
+	if !r.Validation.GetIsValid() {
+		return fmt.Sprintf("rejected: %s", r.Validation.GetBpfError())
+	}
+	if r.Execution == nil {
+		return "accepted, not executed"
+	}
+	if !r.Execution.GetDidSucceed() {
+		return fmt.Sprintf("accepted, execution failed: %s", r.Execution.GetErrorMessage())
+	}
+	return fmt.Sprintf("accepted, returned %#x", r.Execution.GetRetval())
+}
+
+// ReplayProgram loads the program of `entry` `runs` times, on fresh maps
+// every time, and executes it whenever the verifier accepts it. Kernel
+// splats are reported like for fuzzed programs if KernelLog is set.
+func (cu *Control) ReplayProgram(entry *cpb.CorpusEntry, runs int) ([]*ReplayRun, error) {
+	var results []*ReplayRun
+	for i := 0; i < runs; i++ {
+		run, err := cu.replayRun(entry)
+		if err != nil {
+			return results, fmt.Errorf("run %d: %w", i, err)
+		}
+		results = append(results, run)
+	}
+	return results, nil
+}
+
+// replayRun is one run of ReplayProgram.
+func (cu *Control) replayRun(entry *cpb.CorpusEntry) (*ReplayRun, error) {
+	vres, closeMaps, err := cu.loadEntry(entry)
+	defer closeMaps()
+	if err != nil {
+		return nil, err
+	}
+	cu.checkKernelLog(entry.GetProgram(), vres)
+	run := &ReplayRun{Validation: vres}
+	if !vres.GetIsValid() {
+		return run, nil
+	}
+	defer cu.ffi.CloseFD(int(vres.GetProgramFd()))
+	run.Execution, err = cu.executeProgram(vres.GetProgramFd())
+	cu.checkKernelLog(entry.GetProgram(), vres)
+	return run, err
+}
+
+// WriteReplay writes the results of every run of `runs` to `w`, as text
+// protos with the verifier log printed on its own, followed by how many
+// times each outcome happened.
+func WriteReplay(w io.Writer, runs []*ReplayRun) {
+	for i, run := range runs {
+		validation := proto.Clone(run.Validation).(*fpb.ValidationResult)
+		validation.VerifierLog = ""
+		fmt.Fprintf(w, "Run %d, %s\n", i, run.outcome())
+		fmt.Fprintf(w, "validation_result {\n%s}\n", indent(proto.MarshalTextString(validation)))
+		if run.Execution != nil {
+			fmt.Fprintf(w, "execution_result {\n%s}\n", indent(proto.MarshalTextString(run.Execution)))
+		}
+		fmt.Fprintf(w, "Verifier log:\n%s\n", run.Validation.GetVerifierLog())
+	}
+	fmt.Fprint(w, summarizeReplay(runs))
+}
+
+// summarizeReplay returns how many of `runs` had each outcome, in the order
+// they first happened, and if the program reproduced.
+func summarizeReplay(runs []*ReplayRun) string {
+	var outcomes []string
+	counts := make(map[string]int)
+	for _, run := range runs {
+		o := run.outcome()
+		if counts[o] == 0 {
+			outcomes = append(outcomes, o)
+		}
+		counts[o]++
+	}
+	var b strings.Builder
+	for _, o := range outcomes {
+		fmt.Fprintf(&b, "%d/%d runs %s\n", counts[o], len(runs), o)
+	}
+	if len(outcomes) > 1 {
+		b.WriteString("The runs did not all have the same outcome\n")
+	}
+	return b.String()
+}
+
+// indent indents every line of `text` by two spaces.
+func indent(text string) string {
+	lines := strings.SplitAfter(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = "  " + line
+		}
+	}
+	return strings.Join(lines, "")
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestWriteReplay(t *testing.T) {
+	accepted := &ReplayRun{
+		Validation: &fpb.ValidationResult{IsValid: true, VerifierLog: "processed 2 insns"},
+		Execution:  &fpb.ExecutionResult{DidSucceed: true, Retval: 1},
+	}
+	rejected := &ReplayRun{
+		Validation: &fpb.ValidationResult{IsValid: false, BpfError: "invalid argument"},
+	}
+
+	tests := []struct {
+		testName string
+		runs     []*ReplayRun
+		want     []string
+		dontWant []string
+	}{
+		{
+			testName: "Reproducible",
+			runs:     []*ReplayRun{accepted, accepted},
+			want: []string{
+				"Run 1, accepted, returned 0x1",
+				"execution_result {\n  did_succeed: true\n  retval: 1\n}",
+				"Verifier log:\nprocessed 2 insns\n",
+				"2/2 runs accepted, returned 0x1",
+			},
+			dontWant: []string{"did not all have the same outcome", "verifier_log"},
+		},
+		{
+			testName: "Flaky",
+			runs:     []*ReplayRun{accepted, rejected, accepted},
+			want: []string{
+				"Run 1, rejected: invalid argument",
+				"2/3 runs accepted, returned 0x1\n1/3 runs rejected: invalid argument",
+				"did not all have the same outcome",
+			},
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			var b bytes.Buffer
+			WriteReplay(&b, tc.runs)
+			for _, want := range tc.want {
+				if !strings.Contains(b.String(), want) {
+					t.Errorf("WriteReplay() = %q, want it to contain %q", b.String(), want)
+				}
+			}
+			for _, dontWant := range tc.dontWant {
+				if strings.Contains(b.String(), dontWant) {
+					t.Errorf("WriteReplay() = %q, don't want it to contain %q", b.String(), dontWant)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RunLimits bound a fuzzing run, e.g. to run buzzer as a CI job against a
+// release candidate kernel, and count what it did for the summary printed
+// at exit. The workers share them and all stop once a limit is reached.
+// Limits left at zero don't apply.
+type RunLimits struct {
+	MaxExecutions int
+	MaxFindings   int
+	MaxRuntime    time.Duration
+
+	mu         sync.Mutex
+	start      time.Time
+	programs   int
+	executions int
+	findings   int
+	stopReason string
+}
+
+// NewRunLimits returns limits whose runtime starts now.
+func NewRunLimits(maxExecutions, maxFindings int, maxRuntime time.Duration) *RunLimits {
+	return &RunLimits{
+		MaxExecutions: maxExecutions,
+		MaxFindings:   maxFindings,
+		MaxRuntime:    maxRuntime,
+		start:         time.Now(),
+	}
+}
+
+// countProgram, countExecution and countFinding count towards the limits,
+// they do nothing on nil limits.
+func (rl *RunLimits) countProgram() {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.programs++
+}
+
+func (rl *RunLimits) countExecution() {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.executions++
+}
+
+func (rl *RunLimits) countFinding() {
+	if rl == nil {
+		return
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.findings++
+}
+
+// Reached returns true once one of the limits is reached, it stays reached
+// afterwards. Nil limits are never reached.
+func (rl *RunLimits) Reached() bool {
+	if rl == nil {
+		return false
+	}
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	if rl.stopReason != "" {
+		return true
+	}
+	switch {
+	case rl.MaxExecutions > 0 && rl.executions >= rl.MaxExecutions:
+		rl.stopReason = fmt.Sprintf("reached %d executions", rl.MaxExecutions)
+	case rl.MaxFindings > 0 && rl.findings >= rl.MaxFindings:
+		rl.stopReason = fmt.Sprintf("reached %d findings", rl.MaxFindings)
+	case rl.MaxRuntime > 0 && time.Since(rl.start) >= rl.MaxRuntime:
+		rl.stopReason = fmt.Sprintf("ran for %v", rl.MaxRuntime)
+	}
+	return rl.stopReason != ""
+}
+
+// Summary returns what the run did and why it stopped.
+func (rl *RunLimits) Summary() string {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	reason := rl.stopReason
+	if reason == "" {
+		reason = "the strategy was done"
+	}
+	return fmt.Sprintf("Run summary: %d programs, %d executions, %d findings in %v, stopped because %s", rl.programs, rl.executions, rl.findings, time.Since(rl.start).Round(time.Second), reason)
+}
@@ -0,0 +1,108 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunLimits(t *testing.T) {
+	tests := []struct {
+		testName   string
+		limits     *RunLimits
+		executions int
+		findings   int
+		want       bool
+		wantReason string
+	}{
+		{
+			testName:   "No limits",
+			limits:     NewRunLimits(0, 0, 0),
+			executions: 1000,
+			findings:   10,
+			want:       false,
+			wantReason: "the strategy was done",
+		},
+		{
+			testName:   "Below the limits",
+			limits:     NewRunLimits(10, 2, time.Hour),
+			executions: 9,
+			findings:   1,
+			want:       false,
+		},
+		{
+			testName:   "Executions",
+			limits:     NewRunLimits(10, 0, 0),
+			executions: 10,
+			want:       true,
+			wantReason: "reached 10 executions",
+		},
+		{
+			testName:   "First finding",
+			limits:     NewRunLimits(0, 1, 0),
+			findings:   1,
+			want:       true,
+			wantReason: "reached 1 findings",
+		},
+		{
+			testName:   "Runtime",
+			limits:     NewRunLimits(0, 0, time.Nanosecond),
+			want:       true,
+			wantReason: "ran for 1ns",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			for i := 0; i < tc.executions; i++ {
+				tc.limits.countExecution()
+			}
+			for i := 0; i < tc.findings; i++ {
+				tc.limits.countFinding()
+			}
+			time.Sleep(time.Millisecond)
+			if got := tc.limits.Reached(); got != tc.want {
+				t.Errorf("Reached() = %v, want %v", got, tc.want)
+			}
+			if summary := tc.limits.Summary(); !strings.Contains(summary, tc.wantReason) {
+				t.Errorf("Summary() = %q, want it to contain %q", summary, tc.wantReason)
+			}
+		})
+	}
+}
+
+func TestRunLimitsStopFuzzer(t *testing.T) {
+	s := &returnStrategy{idleStrategy: idleStrategy{programs: 1000}}
+	cu := &Control{Limits: NewRunLimits(0, 0, 0)}
+	cu.Init(&FFI{Maps: NewFakeMaps()}, nil, s)
+	if cu.fuzzingDone() {
+		t.Fatalf("fuzzingDone() = true without reaching any limit")
+	}
+	cu.Limits.MaxExecutions = 3
+	for i := 0; i < 3; i++ {
+		cu.countExecution()
+	}
+	if !cu.fuzzingDone() {
+		t.Errorf("fuzzingDone() = false after reaching the execution limit")
+	}
+
+	var nilLimits *RunLimits
+	nilLimits.countExecution()
+	if nilLimits.Reached() {
+		t.Errorf("Reached() = true on nil limits")
+	}
+}
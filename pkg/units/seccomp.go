@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/cbpf/cbpf"
+	"buzzer/pkg/logging/logging"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// SeccompStrategy is implemented by the strategies that fuzz cBPF seccomp
+// filters instead of eBPF programs. RunFuzzer hands them over to
+// runSeccompFuzzer, which never calls their eBPF specific methods.
+type SeccompStrategy interface {
+	Strategy
+
+	// GenerateSeccompFilter should return the filter to install and the
+	// system call the sandboxed process issues afterwards.
+	GenerateSeccompFilter(ffi *FFI) (*fpb.SeccompRequest, error)
+
+	// OnSeccompDone should validate if the sandboxed process behaved like
+	// the filter dictates, if that was not the case it should return false.
+	OnSeccompDone(ffi *FFI, result *fpb.SeccompResult) bool
+}
+
+// runSeccompFuzzer is the main fuzzing loop of seccomp strategies.
+func (cu *Control) runSeccompFuzzer(strat SeccompStrategy) error {
+	for !cu.fuzzingDone() {
+		req, err := strat.GenerateSeccompFilter(cu.ffi)
+		if err != nil {
+			logging.Warningf("Generate filter error: %v\n", err)
+			if !strat.OnError(err) {
+				return err
+			}
+			continue
+		}
+		cu.countProgram()
+
+		res, err := cu.ffi.RunSeccompFilter(req)
+		if err != nil {
+			logging.Warningf("RunSeccompFilter error: %v\n", err)
+			if !strat.OnError(err) {
+				return err
+			}
+			continue
+		}
+		cu.countExecution()
+
+		if !strat.OnSeccompDone(cu.ffi, res) {
+			cu.reportSeccompFinding(&Finding{
+				Description:    "Seccomp filter produced unexpected results",
+				Oracle:         OracleExecution,
+				ClassicProgram: cbpf.DecodeInstructions(req.Filter),
+			}, req)
+		}
+	}
+	return nil
+}
+
+// reportSeccompFinding reports `f` with a C PoC that installs its filter and
+// issues the system call of `req`.
+func (cu *Control) reportSeccompFinding(f *Finding, req *fpb.SeccompRequest) {
+	var args [6]uint64
+	copy(args[:], req.SyscallArgs)
+	cu.reportClassicFinding(f, func() (string, error) {
+		return cbpf.GenerateSeccompCPoc(f.ClassicProgram, args)
+	})
+}
@@ -0,0 +1,95 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"buzzer/pkg/cbpf/cbpf"
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// SocketFilterStrategy is implemented by the strategies that fuzz cBPF socket
+// filters instead of eBPF programs. RunFuzzer hands them over to
+// runSocketFilterFuzzer, which never calls their eBPF specific methods.
+type SocketFilterStrategy interface {
+	Strategy
+
+	// GenerateSocketFilter should return the filter to attach and the
+	// packet to send through it.
+	GenerateSocketFilter(ffi *FFI) (*fpb.SocketFilterRequest, error)
+
+	// OnSocketFilterDone should validate if the filter kept the part of
+	// the packet it was expected to, if that was not the case it should
+	// return false.
+	OnSocketFilterDone(ffi *FFI, result *fpb.SocketFilterResult) bool
+}
+
+// PairedSocketFilterStrategy is implemented by the socket filter strategies
+// that also run an eBPF translation of their filters. The findings about
+// their filters carry both programs, along with a PoC for each one of them.
+type PairedSocketFilterStrategy interface {
+	SocketFilterStrategy
+
+	// PairedProgram returns the eBPF translation of the last generated
+	// filter, nil if there is none.
+	PairedProgram() *epb.Program
+}
+
+// runSocketFilterFuzzer is the main fuzzing loop of socket filter
+// strategies.
+func (cu *Control) runSocketFilterFuzzer(strat SocketFilterStrategy) error {
+	for !cu.fuzzingDone() {
+		req, err := strat.GenerateSocketFilter(cu.ffi)
+		if err != nil {
+			logging.Warningf("Generate filter error: %v\n", err)
+			if !strat.OnError(err) {
+				return err
+			}
+			continue
+		}
+		cu.countProgram()
+
+		res, err := cu.ffi.RunSocketFilter(req)
+		if err != nil {
+			logging.Warningf("RunSocketFilter error: %v\n", err)
+			if !strat.OnError(err) {
+				return err
+			}
+			continue
+		}
+		cu.countExecution()
+
+		if !strat.OnSocketFilterDone(cu.ffi, res) {
+			f := &Finding{
+				Description:    "Socket filter produced unexpected results",
+				Oracle:         OracleExecution,
+				ClassicProgram: cbpf.DecodeInstructions(req.Filter),
+			}
+			pocs := []func() (string, error){func() (string, error) {
+				return cbpf.GenerateSocketFilterCPoc(f.ClassicProgram, req.Packet)
+			}}
+			if paired, ok := strat.(PairedSocketFilterStrategy); ok && paired.PairedProgram() != nil {
+				f.Program = paired.PairedProgram()
+				pocs = append(pocs, func() (string, error) {
+					return ebpf.GenerateSocketFilterCPoc(f.Program, nil, req.Packet)
+				})
+			}
+			cu.reportClassicFinding(f, pocs...)
+		}
+	}
+	return nil
+}
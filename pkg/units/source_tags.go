@@ -0,0 +1,132 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// How many of the last covered source lines are attached to a finding.
+	maxCoverageTags = 3
+)
+
+// SourceTag is a kernel source location that is likely involved in a
+// finding.
+type SourceTag struct {
+	// Function is the kernel function, empty if unknown.
+	Function string
+
+	// Location is either a source file or a file:line pair.
+	Location string
+
+	// Reason explains where the tag comes from.
+	Reason string
+}
+
+func (st SourceTag) String() string {
+	if st.Function == "" {
+		return fmt.Sprintf("%s (%s)", st.Location, st.Reason)
+	}
+	return fmt.Sprintf("%s() at %s (%s)", st.Function, st.Location, st.Reason)
+}
+
+// verifierErrorSource maps a verifier error message to the function of
+// kernel/bpf/verifier.c that emits it.
+type verifierErrorSource struct {
+	pattern  *regexp.Regexp
+	function string
+}
+
+var verifierErrorSources = []verifierErrorSource{
+	{regexp.MustCompile(`R\d+ !read_ok`), "check_reg_arg"},
+	{regexp.MustCompile(`frame pointer is read only`), "check_reg_arg"},
+	{regexp.MustCompile(`invalid mem access`), "check_mem_access"},
+	{regexp.MustCompile(`invalid access to map value`), "check_map_access"},
+	{regexp.MustCompile(`math between .* pointer and|pointer arithmetic .* prohibited|unbounded min value|makes .* pointer be out of bounds`), "adjust_ptr_min_max_vals"},
+	{regexp.MustCompile(`invalid (read|write) from stack|invalid stack off`), "check_stack_access_within_bounds"},
+	{regexp.MustCompile(`invalid indirect read from stack`), "check_stack_range_initialized"},
+	{regexp.MustCompile(`misaligned .*access`), "check_ptr_alignment"},
+	{regexp.MustCompile(`unreachable insn|back-edge from insn`), "check_cfg"},
+	{regexp.MustCompile(`jump out of range`), "check_subprogs"},
+	{regexp.MustCompile(`invalid bpf_ld_imm64 insn|unrecognized bpf_ld_imm64 insn`), "resolve_pseudo_ldimm64"},
+	{regexp.MustCompile(`invalid func|unknown func`), "check_helper_call"},
+	{regexp.MustCompile(`R\d+ type=.* expected=`), "check_reg_type"},
+	{regexp.MustCompile(`Unreleased reference`), "check_reference_leak"},
+	{regexp.MustCompile(`infinite loop detected`), "is_state_visited"},
+	{regexp.MustCompile(`R0 leaks addr as return value|At program exit the register R0`), "check_return_code"},
+	{regexp.MustCompile(`unknown opcode|uses reserved fields|program is too large`), "do_check"},
+}
+
+// tagVerifierLog looks for the last verifier error in `log` and returns the
+// verifier function that most likely produced it, or nil if the log has no
+// known error.
+func tagVerifierLog(log string) *SourceTag {
+	lines := strings.Split(log, "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		for _, source := range verifierErrorSources {
+			if source.pattern.MatchString(lines[i]) {
+				return &SourceTag{
+					Function: source.function,
+					Location: "kernel/bpf/verifier.c",
+					Reason:   fmt.Sprintf("verifier error %q", strings.TrimSpace(lines[i])),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// tagCoverage returns the last maxCoverageTags distinct source lines the
+// kernel covered while verifying the program, in the order they were hit.
+func (cu *Control) tagCoverage(vres *fpb.ValidationResult) []SourceTag {
+	if cu.cm == nil || !vres.GetDidCollectCoverage() {
+		return nil
+	}
+	cov := vres.GetCoverageAddress()
+	lines, err := cu.cm.ProcessCoverageAddresses(cov)
+	if err != nil {
+		return nil
+	}
+
+	tags := []SourceTag{}
+	seen := make(map[string]bool)
+	for i := len(cov) - 1; i >= 0 && len(tags) < maxCoverageTags; i-- {
+		line, ok := lines[cov[i]]
+		if !ok || seen[line] {
+			continue
+		}
+		seen[line] = true
+		tags = append([]SourceTag{{Location: line, Reason: "kcov"}}, tags...)
+	}
+	return tags
+}
+
+// tagSources combines the verifier log and, when it was collected, the kcov
+// coverage of `vres` into a list of candidate kernel source locations.
+func (cu *Control) tagSources(vres *fpb.ValidationResult) []SourceTag {
+	if vres == nil {
+		return nil
+	}
+	tags := []SourceTag{}
+	if tag := tagVerifierLog(vres.GetVerifierLog()); tag != nil {
+		tags = append(tags, *tag)
+	}
+	return append(tags, cu.tagCoverage(vres)...)
+}
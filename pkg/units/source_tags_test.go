@@ -0,0 +1,80 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestTagVerifierLog(t *testing.T) {
+	tests := []struct {
+		testName     string
+		log          string
+		wantFunction string
+	}{
+		{
+			testName:     "Uninitialized register",
+			log:          "0: (bf) r0 = r2\nR2 !read_ok\nprocessed 1 insns",
+			wantFunction: "check_reg_arg",
+		},
+		{
+			testName:     "Last error wins",
+			log:          "invalid mem access 'scalar'\nmath between map_value pointer and register with unbounded min value is not allowed",
+			wantFunction: "adjust_ptr_min_max_vals",
+		},
+		{
+			testName:     "Accepted program",
+			log:          "0: (b7) r0 = 0\n1: (95) exit\nprocessed 2 insns",
+			wantFunction: "",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			tag := tagVerifierLog(tc.log)
+			got := ""
+			if tag != nil {
+				got = tag.Function
+			}
+			if got != tc.wantFunction {
+				t.Errorf("tagVerifierLog() function = %q, want %q", got, tc.wantFunction)
+			}
+		})
+	}
+}
+
+func TestTagCoverage(t *testing.T) {
+	cm := NewCoverageManager(func(inputString string) (string, error) {
+		return "/src/kernel/bpf/verifier.c:10\n/src/kernel/bpf/verifier.c:20\n/src/kernel/bpf/syscall.c:30\n/src/kernel/bpf/verifier.c:40\n", nil
+	})
+	cu := &Control{cm: cm}
+	vres := &fpb.ValidationResult{
+		DidCollectCoverage: true,
+		CoverageAddress:    []uint64{0x1, 0x2, 0x3, 0x4},
+	}
+
+	tags := cu.tagCoverage(vres)
+	want := []string{"verifier.c:20", "syscall.c:30", "verifier.c:40"}
+	if len(tags) != len(want) {
+		t.Fatalf("tagCoverage() returned %d tags, want %d", len(tags), len(want))
+	}
+	for i, tag := range tags {
+		if tag.Location != want[i] {
+			t.Errorf("tags[%d].Location = %q, want %q", i, tag.Location, want[i])
+		}
+	}
+}
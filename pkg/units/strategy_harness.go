@@ -0,0 +1,136 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"syscall"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// CannedResponse is what the kernel answers to a program generated in a
+// StrategyHarness.
+type CannedResponse struct {
+	// Validation is passed to OnVerifyDone, see VerifierAcceptance and
+	// VerifierRejection.
+	Validation *fpb.ValidationResult
+
+	// Execute emulates the execution of `prog` when the program is valid
+	// and the strategy wants it executed, e.g. writing to the fake maps of
+	// `ffi`. Its result is passed to OnExecuteDone. If nil the execution
+	// succeeds without any side effect.
+	Execute func(ffi *FFI, prog *epb.Program) *fpb.ExecutionResult
+}
+
+// StepResult is what happened to a program generated in a StrategyHarness.
+type StepResult struct {
+	Program *epb.Program
+
+	// Executed is true if OnVerifyDone asked for the program to be
+	// executed, Expected is what OnExecuteDone returned then.
+	Executed bool
+	Expected bool
+}
+
+// StrategyHarness feeds canned verifier and execution results to the hooks
+// of a strategy so its feedback loop can be tested without a kernel. The
+// maps of the strategy are emulated in memory, strategies that load or run
+// programs themselves still need a kernel.
+type StrategyHarness struct {
+	Strategy Strategy
+	FFI      *FFI
+
+	cu *Control
+}
+
+// NewStrategyHarness returns a harness for `strat` with no map.
+func NewStrategyHarness(strat Strategy) *StrategyHarness {
+	ffi := &FFI{Maps: NewFakeMaps()}
+	cu := &Control{}
+	cu.Init(ffi, nil, strat)
+	return &StrategyHarness{Strategy: strat, FFI: ffi, cu: cu}
+}
+
+// Step makes the strategy generate a program and answers it with
+// `response`, like the fuzzing loop of Control does.
+func (h *StrategyHarness) Step(response CannedResponse) (*StepResult, error) {
+	prog, err := h.Strategy.GenerateProgram(h.FFI)
+	if err != nil {
+		return nil, err
+	}
+	if prog, err = ebpf.AssembleProgram(prog); err != nil {
+		return nil, err
+	}
+	res := &StepResult{Program: prog}
+	vres := response.Validation
+	if vres == nil {
+		return nil, fmt.Errorf("no validation result for the program")
+	}
+	if !h.Strategy.OnVerifyDone(h.FFI, vres) || !vres.GetIsValid() {
+		return res, nil
+	}
+
+	if err := h.cu.populateKeyedMaps(); err != nil {
+		return nil, err
+	}
+	exRes := &fpb.ExecutionResult{DidSucceed: true}
+	if response.Execute != nil {
+		exRes = response.Execute(h.FFI, prog)
+	}
+	res.Executed = true
+	res.Expected = h.Strategy.OnExecuteDone(h.FFI, exRes)
+	return res, nil
+}
+
+// Run calls Step with every response in order and returns the results.
+func (h *StrategyHarness) Run(responses ...CannedResponse) ([]*StepResult, error) {
+	var results []*StepResult
+	for i, response := range responses {
+		res, err := h.Step(response)
+		if err != nil {
+			return results, fmt.Errorf("step %d: %w", i, err)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+// VerifierAcceptance returns the result of a program the verifier accepted,
+// with the kcov addresses `coverage` if there are any.
+func VerifierAcceptance(coverage ...uint64) *fpb.ValidationResult {
+	return &fpb.ValidationResult{
+		IsValid:            true,
+		VerifierLog:        "processed 2 insns (limit 1000000) max_states_per_insn 0 total_states 0 peak_states 0 mark_read 0\n",
+		ProgramFd:          -1,
+		DidCollectCoverage: len(coverage) > 0,
+		CoverageSize:       int64(len(coverage)),
+		CoverageAddress:    coverage,
+	}
+}
+
+// VerifierRejection returns the result of a program the verifier rejected
+// with the error `verdict`, e.g. "R0 !read_ok". The log ends like the ones
+// of the kernel, with the verdict on the second to last line.
+func VerifierRejection(verdict string) *fpb.ValidationResult {
+	return &fpb.ValidationResult{
+		VerifierLog: fmt.Sprintf("0: (95) exit\n%s\nprocessed 1 insns (limit 1000000) max_states_per_insn 0 total_states 0 peak_states 0 mark_read 0\n", verdict),
+		ProgramFd:   -1,
+		BpfError:    syscall.EACCES.Error(),
+		BpfErrno:    int32(syscall.EACCES),
+	}
+}
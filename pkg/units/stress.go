@@ -0,0 +1,121 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"buzzer/pkg/logging/logging"
+)
+
+const (
+	// How many times each goroutine executes the program during a
+	// concurrent execution stress run.
+	stressExecutionsPerThread = 32
+)
+
+// MapOwner can optionally be implemented by strategies whose programs use
+// array maps. The control unit then mutates the maps from user space during
+// concurrent execution stress runs, snapshots them around the executions for
+// the map deltas, resets them before reproducing a finding, and records their
+// sizes in the corpus entries and the PoCs.
+type MapOwner interface {
+	// Maps returns the file descriptors of the array maps used by the
+	// last generated program, mapped to their number of elements.
+	Maps() map[int]uint64
+}
+
+// stressExecute runs the program described by `progFd` from
+// ConcurrentExecutions goroutines at the same time, each one locked to its own
+// OS thread so the executions can land on different CPUs. Meanwhile, if the
+// strategy implements MapOwner, another goroutine keeps writing to the
+// writable maps of the program; their original contents are restored at the
+// end.
+//
+// Returns the errors of all the executions that failed.
+func (cu *Control) stressExecute(progFd int64) error {
+	var stop atomic.Bool
+	var mutatorWg sync.WaitGroup
+	if owner, ok := cu.strat.(MapOwner); ok {
+		maps := make(map[int]uint64)
+
+		// Strategies set up the maps for the regular execution that
+		// follows, put their contents back once the run is over.
+		for fd, size := range owner.Maps() {
+			elements, err := cu.ffi.GetMapElements(fd, size)
+			if err != nil {
+				return err
+			}
+			values := elements.GetElements()
+			// Frozen maps, e.g. by map_race, refuse every write from
+			// user space, so the program changes them for good.
+			if len(values) > 0 && cu.ffi.SetMapElement(fd, 0, values[0]) < 0 {
+				logging.Infof("Map %d is not writable, its contents will not be restored after the stress run\n", fd)
+				continue
+			}
+			maps[fd] = size
+			defer func(fd int, values []uint64) {
+				for key, value := range values {
+					if cu.ffi.SetMapElement(fd, uint32(key), value) < 0 {
+						logging.Warningf("Could not restore element %d of map %d after the stress run\n", key, fd)
+						return
+					}
+				}
+			}(fd, values)
+		}
+
+		mutatorWg.Add(1)
+		go func() {
+			defer mutatorWg.Done()
+			for value := uint64(0); !stop.Load(); value++ {
+				for fd, size := range maps {
+					if size == 0 {
+						continue
+					}
+					cu.ffi.SetMapElement(fd, uint32(value%size), value)
+				}
+			}
+		}()
+	}
+
+	var errMutex sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+	for i := 0; i < cu.ConcurrentExecutions; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runtime.LockOSThread()
+			defer runtime.UnlockOSThread()
+			for j := 0; j < stressExecutionsPerThread; j++ {
+				_, err := cu.executeProgram(progFd)
+				if err != nil {
+					errMutex.Lock()
+					errs = append(errs, err)
+					errMutex.Unlock()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	stop.Store(true)
+	mutatorWg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,57 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"buzzer/pkg/logging/logging"
+)
+
+func TestStressExecuteRestoresMaps(t *testing.T) {
+	ffi := &FFI{Maps: NewFakeMaps()}
+	writable := ffi.CreateMapArray(3)
+	frozen := ffi.CreateMapArray(2)
+	for key, value := range []uint64{4, 5, 6} {
+		ffi.SetMapElement(writable, uint32(key), value)
+	}
+	ffi.SetMapElement(frozen, 1, 8)
+	ffi.FreezeMap(frozen)
+	cu := &Control{}
+	cu.Init(ffi, nil, &mapOwnerStrategy{maps: map[int]uint64{writable: 3, frozen: 2}})
+	var console strings.Builder
+	logging.SetDefault(logging.NewLogger(&console, logging.Debug, 0, nil))
+	defer logging.SetDefault(logging.NewLogger(os.Stdout, logging.Debug, 0, nil))
+
+	if err := cu.stressExecute(0); err != nil {
+		t.Fatalf("stressExecute() = %v", err)
+	}
+	if got, _ := ffi.GetMapElements(writable, 3); !reflect.DeepEqual(got.GetElements(), []uint64{4, 5, 6}) {
+		t.Errorf("writable map = %v, want its contents restored", got.GetElements())
+	}
+	if got, _ := ffi.GetMapElements(frozen, 2); !reflect.DeepEqual(got.GetElements(), []uint64{0, 8}) {
+		t.Errorf("frozen map = %v, want it untouched", got.GetElements())
+	}
+	if want := fmt.Sprintf("Map %d is not writable", frozen); !strings.Contains(console.String(), want) {
+		t.Errorf("log = %q, want it to tell %q", console.String(), want)
+	}
+	if strings.Contains(console.String(), "Could not restore") {
+		t.Errorf("log = %q, want no failed restore", console.String())
+	}
+}
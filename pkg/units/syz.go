@@ -0,0 +1,150 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+
+	"buzzer/pkg/ebpf/ebpf"
+	"buzzer/pkg/logging/logging"
+)
+
+const (
+	// syzDataOffset is where syzkaller maps the memory the arguments of a
+	// program point to, on amd64. Every argument gets its own chunk of
+	// syzChunk bytes or more.
+	syzDataOffset = 0x7f0000000000
+	syzChunk      = 0x40
+
+	// Commands of bpf(2) and the sizes of their attributes syzkaller passes.
+	syzMapCreate    = 0x0
+	syzProgLoad     = 0x5
+	syzProgTestRun  = 0xa
+	syzMapAttrSize  = 0x48
+	syzProgAttrSize = 0x90
+	syzTestAttrSize = 0x48
+)
+
+// syzWriter writes a syz program, allocating the memory its arguments point
+// to and the resources its calls return.
+type syzWriter struct {
+	b         strings.Builder
+	next      uint64
+	resources int
+}
+
+// alloc returns the address of `size` bytes of argument memory.
+func (w *syzWriter) alloc(size int) string {
+	addr := syzDataOffset + w.next
+	w.next += (uint64(size) + syzChunk - 1) / syzChunk * syzChunk
+	return fmt.Sprintf("&(0x%x)", addr)
+}
+
+// call writes a call and returns the resource it returns, if `ret`.
+func (w *syzWriter) call(ret bool, format string, args ...any) string {
+	var r string
+	if ret {
+		r = fmt.Sprintf("r%d", w.resources)
+		w.resources++
+		w.b.WriteString(r + " = ")
+	}
+	fmt.Fprintf(&w.b, format+"\n", args...)
+	return r
+}
+
+// syzInstructions returns the @raw value of syzkaller's bpf_instructions for
+// `slots`, the loads of map indexes become loads of the `maps` resources.
+func syzInstructions(slots []uint64, maps []string) (string, error) {
+	var insns []string
+	for i := 0; i < len(slots); i++ {
+		slot := slots[i]
+		code, dst, src := uint8(slot), uint8(slot>>8)&0xf, uint8(slot>>12)&0xf
+		off, imm := uint16(slot>>16), uint32(slot>>32)
+		if code == wideLoadOpcode && src == uint8(ebpf.PseudoMapFD) {
+			if int(imm) >= len(maps) || i+1 >= len(slots) {
+				return "", fmt.Errorf("instruction %d loads undefined map %d", i, imm)
+			}
+			// bpf_insn_map_fd covers both slots of the load.
+			insns = append(insns, fmt.Sprintf("@map_fd={0x%x, 0x%x, 0x%x, 0x0, %s}", code, dst, src, maps[imm]))
+			i++
+			continue
+		}
+		insns = append(insns, fmt.Sprintf("@generic={0x%x, 0x%x, 0x%x, 0x%x, 0x%x}", code, dst, src, off, imm))
+	}
+	return "@raw=[" + strings.Join(insns, ", ") + "]", nil
+}
+
+// EncodeSyz returns the syzkaller program that creates the maps of `obj`,
+// loads each one of its programs with bpf$PROG_LOAD and test runs it on
+// DefaultTestRunData, so the reproducers of buzzer can go through the triage
+// automation of syzkaller, e.g. syz-repro and syz-prog2c.
+func EncodeSyz(obj *ELFObject) ([]byte, error) {
+	w := &syzWriter{}
+	w.b.WriteString("# Generated by buzzer, run with syz-execprog or convert to C with syz-prog2c.\n")
+	var maps []string
+	for _, m := range obj.Maps {
+		attr := fmt.Sprintf("@base={0x%x, 0x%x, 0x%x, 0x%x, 0x%x}", m.Type, m.KeySize, m.ValueSize, m.MaxEntries, m.Flags)
+		maps = append(maps, w.call(true, "bpf$MAP_CREATE(0x%x, %s=%s, 0x%x)", syzMapCreate, w.alloc(syzMapAttrSize), attr, syzMapAttrSize))
+	}
+	for _, prog := range obj.Programs {
+		slots, err := ebpf.EncodeInstructionsFor(prog.Program, ebpf.LittleEndian)
+		if err != nil {
+			return nil, fmt.Errorf("encoding %s: %w", prog.Name, err)
+		}
+		insns, err := syzInstructions(slots, maps)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", prog.Name, err)
+		}
+		// The fields of bpf_prog_t up to the line info, the others are
+		// zero: type, ninsn, insns, license, loglev, logsize, log,
+		// kern_version, flags, prog_name, prog_ifindex,
+		// expected_attach_type, btf_fd, func_info_rec_size, func_info,
+		// func_info_cnt, line_info_rec_size, line_info, line_info_cnt.
+		attrAddr := w.alloc(syzProgAttrSize)
+		attr := fmt.Sprintf("{0x%x, 0x%x, %s=%s, %s='%s\\x00', 0x0, 0x0, 0x0, 0x0, 0x0, '\\x00', 0x0, 0x0, 0xffffffffffffffff, 0x8, 0x0, 0x0, 0x10, 0x0, 0x0}",
+			prog.ProgramType, len(slots), w.alloc(8*len(slots)), insns, w.alloc(len(elfLicense)+1), elfLicense)
+		fd := w.call(true, "bpf$PROG_LOAD(0x%x, %s=%s, 0x%x)", syzProgLoad, attrAddr, attr, syzProgAttrSize)
+
+		// prog, retval, size_in, size_out, data_in, data_out, repeat,
+		// duration.
+		testAddr := w.alloc(syzTestAttrSize)
+		test := fmt.Sprintf("{%s, 0x0, 0x%x, 0x0, %s=\"%s\", 0x0, 0x1, 0x0}",
+			fd, len(DefaultTestRunData), w.alloc(len(DefaultTestRunData)), hex.EncodeToString(DefaultTestRunData))
+		w.call(false, "bpf$BPF_PROG_TEST_RUN(0x%x, %s=%s, 0x%x)", syzProgTestRun, testAddr, test, syzTestAttrSize)
+	}
+	return []byte(w.b.String()), nil
+}
+
+// GenerateSyz writes the syzkaller program of `obj`, see EncodeSyz, to a
+// temporary file and returns its path.
+func GenerateSyz(obj *ELFObject) (string, error) {
+	prog, err := EncodeSyz(obj)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "ebpf-poc-*.syz")
+	if err != nil {
+		return "", err
+	}
+	logging.Infof("Writing syzkaller PoC %q.\n", f.Name())
+	_, err = f.Write(prog)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return f.Name(), err
+}
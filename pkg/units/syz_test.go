@@ -0,0 +1,51 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"strings"
+	"testing"
+
+	. "buzzer/pkg/ebpf/ebpf"
+)
+
+func TestEncodeSyz(t *testing.T) {
+	prog, err := NewProgramBuilder().
+		LdMapByFd(R1, 7).Mov64(R0, -8).StDW(R10, R0, -8).Exit().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := EncodeSyz(ELFObjectOf(prog, ProgTypeSchedCls, map[int]uint64{7: 4}))
+	if err != nil {
+		t.Fatalf("EncodeSyz() failed: %v", err)
+	}
+	want := []string{
+		"# Generated by buzzer, run with syz-execprog or convert to C with syz-prog2c.",
+		"r0 = bpf$MAP_CREATE(0x0, &(0x7f0000000000)=@base={0x2, 0x4, 0x8, 0x4, 0x0}, 0x48)",
+		"r1 = bpf$PROG_LOAD(0x5, &(0x7f0000000080)={0x3, 0x5, &(0x7f0000000140)=@raw=[" +
+			"@map_fd={0x18, 0x1, 0x1, 0x0, r0}, @generic={0xb7, 0x0, 0x0, 0x0, 0xfffffff8}, @generic={0x7b, 0xa, 0x0, 0xfff8, 0x0}, @generic={0x95, 0x0, 0x0, 0x0, 0x0}" +
+			"], &(0x7f0000000180)='GPL\\x00', 0x0, 0x0, 0x0, 0x0, 0x0, '\\x00', 0x0, 0x0, 0xffffffffffffffff, 0x8, 0x0, 0x0, 0x10, 0x0, 0x0}, 0x90)",
+		"bpf$BPF_PROG_TEST_RUN(0xa, &(0x7f00000001c0)={r1, 0x0, 0x40, 0x0, &(0x7f0000000240)=\"" + strings.Repeat("aa", 64) + "\", 0x0, 0x1, 0x0}, 0x48)",
+	}
+	if got := strings.Split(strings.TrimSpace(string(got)), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("EncodeSyz() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	undefined := ELFObjectOf(prog, ProgTypeSchedCls, nil)
+	undefined.Maps = nil
+	if _, err := EncodeSyz(undefined); err == nil {
+		t.Errorf("EncodeSyz() of a program loading an undefined map succeeded")
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"buzzer/pkg/logging/logging"
+)
+
+// TelemetryReport is the payload periodically pushed to the telemetry
+// endpoint. It only contains aggregated numbers: no programs, verifier logs,
+// host names or addresses ever leave the machine.
+type TelemetryReport struct {
+	// CampaignID is a random identifier generated at startup, it lets the
+	// collector group the reports of one campaign without identifying the
+	// host it runs on.
+	CampaignID    string `json:"campaign_id"`
+	BuzzerVersion string `json:"buzzer_version"`
+	KernelRelease string `json:"kernel_release"`
+	Strategy      string `json:"strategy"`
+
+	UptimeSeconds     int64   `json:"uptime_seconds"`
+	ProgramsVerified  int     `json:"programs_verified"`
+	ValidPrograms     int     `json:"valid_programs"`
+	ProgramsPerSecond float64 `json:"programs_per_second"`
+	CoveredAddresses  int     `json:"covered_addresses"`
+
+	// ErrorClasses counts the verifier errors seen so far, the numbers in
+	// the messages (registers, offsets, sizes) are masked so the classes
+	// don't leak program details.
+	ErrorClasses map[string]int `json:"error_classes"`
+
+	// TransientFailures counts the loads that failed without a verdict,
+	// e.g. because of ENOMEM, masked like ErrorClasses. DroppedPrograms is
+	// how many programs were given up on after retrying them.
+	TransientFailures map[string]int `json:"transient_failures"`
+	DroppedPrograms   int            `json:"dropped_programs"`
+}
+
+// TelemetryExporter pushes a TelemetryReport to a user configured endpoint
+// every `interval`. Telemetry is opt-in, the exporter is only created when an
+// endpoint is given.
+type TelemetryExporter struct {
+	endpoint string
+	interval time.Duration
+	client   *http.Client
+
+	metricsCollection *MetricsCollection
+	campaignID        string
+	strategy          string
+	startTime         time.Time
+}
+
+var numbersRegexp = regexp.MustCompile(`[0-9]+`)
+
+// errorClass masks the numbers of a verifier error message.
+func errorClass(verdict string) string {
+	return numbersRegexp.ReplaceAllString(strings.TrimSpace(verdict), "N")
+}
+
+func buzzerVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+func kernelRelease() string {
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err != nil {
+		return "unknown"
+	}
+	return strings.TrimSpace(string(release))
+}
+
+func newCampaignID() string {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
+
+// NewTelemetryExporter creates an exporter that reports the metrics of `mu`
+// to `endpoint`.
+func NewTelemetryExporter(endpoint string, interval time.Duration, strategy string, mu *Metrics) *TelemetryExporter {
+	return &TelemetryExporter{
+		endpoint:          endpoint,
+		interval:          interval,
+		client:            &http.Client{Timeout: 30 * time.Second},
+		metricsCollection: mu.metricsCollection,
+		campaignID:        newCampaignID(),
+		strategy:          strategy,
+		startTime:         time.Now(),
+	}
+}
+
+func (te *TelemetryExporter) buildReport() *TelemetryReport {
+	verified, valid, verdicts := te.metricsCollection.getCounters()
+	uptime := time.Since(te.startTime)
+
+	report := &TelemetryReport{
+		CampaignID:       te.campaignID,
+		BuzzerVersion:    buzzerVersion(),
+		KernelRelease:    kernelRelease(),
+		Strategy:         te.strategy,
+		UptimeSeconds:    int64(uptime.Seconds()),
+		ProgramsVerified: verified,
+		ValidPrograms:    valid,
+		ErrorClasses:     make(map[string]int),
+	}
+	if uptime.Seconds() > 0 {
+		report.ProgramsPerSecond = float64(verified) / uptime.Seconds()
+	}
+	if te.metricsCollection.coverageManager != nil {
+		report.CoveredAddresses = te.metricsCollection.coverageManager.CoveredAddresses()
+	}
+	for verdict, count := range verdicts {
+		report.ErrorClasses[errorClass(verdict)] += count
+	}
+	failures, dropped := te.metricsCollection.getTransientCounters()
+	report.TransientFailures = make(map[string]int)
+	for failure, count := range failures {
+		report.TransientFailures[errorClass(failure)] += count
+	}
+	report.DroppedPrograms = dropped
+	return report
+}
+
+func (te *TelemetryExporter) push() error {
+	payload, err := json.Marshal(te.buildReport())
+	if err != nil {
+		return err
+	}
+	resp, err := te.client.Post(te.endpoint, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Start pushes the reports from a separate goroutine until the process
+// exits. Failures are logged and otherwise ignored, telemetry should never
+// interrupt a campaign.
+func (te *TelemetryExporter) Start() {
+	go func() {
+		for {
+			time.Sleep(te.interval)
+			if err := te.push(); err != nil {
+				logging.Warningf("Telemetry error: %v\n", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,67 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTelemetryPush(t *testing.T) {
+	var got TelemetryReport
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if err := json.NewDecoder(req.Body).Decode(&got); err != nil {
+			t.Errorf("could not decode report: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	mc := &MetricsCollection{
+		programsVerified: 10,
+		validPrograms:    4,
+		coverageManager:  NewCoverageManager(nil),
+		verifierVerdicts: map[string]int{
+			"R1 !read_ok":                       2,
+			"R7 !read_ok":                       3,
+			"invalid stack off=-520 size=8":     1,
+			"math between fp pointer and R2 is": 4,
+		},
+	}
+	te := NewTelemetryExporter(server.URL, time.Minute, "playground", &Metrics{metricsCollection: mc})
+
+	if err := te.push(); err != nil {
+		t.Fatalf("push() = %v, want nil error", err)
+	}
+
+	if got.Strategy != "playground" || got.ProgramsVerified != 10 || got.ValidPrograms != 4 {
+		t.Errorf("report = %+v, want playground strategy with 10 verified and 4 valid programs", got)
+	}
+	wantClasses := map[string]int{
+		"RN !read_ok":                       5,
+		"invalid stack off=-N size=N":       1,
+		"math between fp pointer and RN is": 4,
+	}
+	if len(got.ErrorClasses) != len(wantClasses) {
+		t.Fatalf("ErrorClasses = %v, want %v", got.ErrorClasses, wantClasses)
+	}
+	for class, count := range wantClasses {
+		if got.ErrorClasses[class] != count {
+			t.Errorf("ErrorClasses[%q] = %d, want %d", class, got.ErrorClasses[class], count)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// ethHeaderLength is ETH_HLEN, BPF_PROG_TEST_RUN refuses to run socket
+// filters on shorter packets.
+const ethHeaderLength = 14
+
+// DefaultTestRunData is the packet programs are test run on when no other is
+// given: the bytes socket executions send, repeated to fill a minimal
+// ethernet frame.
+var DefaultTestRunData = bytes.Repeat([]byte{0xAA}, 64)
+
+// ParseTestRunData decodes the hex encoded packet `s` programs are test run
+// on.
+func ParseTestRunData(s string) ([]byte, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < ethHeaderLength {
+		return nil, fmt.Errorf("test run data is %d bytes long, it must be at least %d", len(data), ethHeaderLength)
+	}
+	return data, nil
+}
+
+// testRunRequest returns the request that test runs the program `progFd` on
+// the data and context of `cu`, or of the strategy if it has one. Syscall
+// programs only get the context, they run once.
+func (cu *Control) testRunRequest(progFd int64) *fpb.TestRunRequest {
+	ctx := cu.TestRunCtx
+	if cs, ok := cu.strat.(ContextStrategy); ok {
+		ctx = cs.TestRunContext()
+	}
+	if cu.programType() == ProgTypeSyscall {
+		return &fpb.TestRunRequest{ProgFd: progFd, CtxIn: ctx}
+	}
+	data := cu.TestRunData
+	if len(data) == 0 {
+		data = DefaultTestRunData
+	}
+	return &fpb.TestRunRequest{
+		ProgFd: progFd,
+		DataIn: data,
+		CtxIn:  ctx,
+		Repeat: cu.TestRunRepeat,
+	}
+}
+
+// programType returns the type the programs of the strategy are loaded as.
+func (cu *Control) programType() int {
+	if ps, ok := cu.strat.(ProgramTypeStrategy); ok {
+		return ps.ProgramType()
+	}
+	return ProgTypeSocketFilter
+}
+
+// programFlags returns the BPF_F_* flags the last generated program is loaded
+// with.
+func (cu *Control) programFlags() uint32 {
+	if ss, ok := cu.strat.(SleepableStrategy); ok && ss.Sleepable() {
+		return ProgFlagSleepable
+	}
+	return 0
+}
+
+// executeProgram runs the program `progFd`, with BPF_PROG_TEST_RUN if TestRun
+// is set or the program is not a socket filter, by sending packets through
+// a socket it is attached to otherwise, see Triggers. Tracepoint programs
+// are attached to their tracepoint instead, see TracepointStrategy, and XDP
+// programs to the Xdp harness if there is one, the same goes for cgroup
+// programs and the Cgroup harness.
+func (cu *Control) executeProgram(progFd int64) (*fpb.ExecutionResult, error) {
+	if ts, ok := cu.strat.(TracepointStrategy); ok {
+		return cu.ffi.RunProgram(&fpb.ExecutionRequest{ProgFd: progFd, Tracepoint: ts.Tracepoint()})
+	}
+	if cu.Xdp != nil && cu.programType() == ProgTypeXdp {
+		return cu.ffi.RunXdp(cu.Xdp.request(progFd, cu.testRunRequest(progFd).GetDataIn()))
+	}
+	if cu.Cgroup != nil && IsCgroupProgramType(cu.programType()) {
+		return cu.ffi.RunCgroup(cu.Cgroup.request(progFd, cu.programType(), cu.testRunRequest(progFd).GetDataIn()))
+	}
+	if cu.TestRun || cu.programType() != ProgTypeSocketFilter {
+		return cu.ffi.TestRunProgram(cu.testRunRequest(progFd))
+	}
+	return cu.ffi.RunProgram(cu.socketRequest(progFd))
+}
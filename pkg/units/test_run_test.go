@@ -0,0 +1,88 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"bytes"
+	"testing"
+
+	fpb "buzzer/proto/ffi_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+func TestParseTestRunData(t *testing.T) {
+	for _, c := range []struct {
+		testName string
+		s        string
+		want     []byte
+		wantErr  bool
+	}{
+		{
+			testName: "Ethernet header",
+			s:        "ffffffffffff0000000000000800",
+			want:     []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, 0, 0, 0x08, 0},
+		},
+		{
+			testName: "Shorter than an ethernet header",
+			s:        "aabb",
+			wantErr:  true,
+		},
+		{
+			testName: "Not hex",
+			s:        "zz",
+			wantErr:  true,
+		},
+	} {
+		t.Run(c.testName, func(t *testing.T) {
+			got, err := ParseTestRunData(c.s)
+			if (err != nil) != c.wantErr {
+				t.Fatalf("ParseTestRunData(%q) error = %v, want error %v", c.s, err, c.wantErr)
+			}
+			if !bytes.Equal(got, c.want) {
+				t.Errorf("ParseTestRunData(%q) = %x, want %x", c.s, got, c.want)
+			}
+		})
+	}
+}
+
+func TestTestRunRequest(t *testing.T) {
+	for _, c := range []struct {
+		testName string
+		cu       *Control
+		want     *fpb.TestRunRequest
+	}{
+		{
+			testName: "Default data",
+			cu:       &Control{TestRun: true},
+			want:     &fpb.TestRunRequest{ProgFd: 3, DataIn: DefaultTestRunData},
+		},
+		{
+			testName: "Configured data, context and repeat",
+			cu: &Control{
+				TestRun:       true,
+				TestRunData:   []byte{1, 2, 3},
+				TestRunCtx:    []byte{4},
+				TestRunRepeat: 10,
+			},
+			want: &fpb.TestRunRequest{ProgFd: 3, DataIn: []byte{1, 2, 3}, CtxIn: []byte{4}, Repeat: 10},
+		},
+	} {
+		t.Run(c.testName, func(t *testing.T) {
+			if got := c.cu.testRunRequest(3); !proto.Equal(got, c.want) {
+				t.Errorf("testRunRequest(3) = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+; prog.o is built from this file with:
+;
+;   llc -march=bpfel -mcpu=v3 -filetype=obj -O2 prog.ll -o prog.o
+;
+; Equivalent of:
+;
+;   struct {
+;           __uint(type, BPF_MAP_TYPE_ARRAY);
+;           __type(key, __u32);
+;           __type(value, __u64);
+;           __uint(max_entries, 4);
+;   } counters SEC(".maps");
+;
+;   struct bpf_map_def SEC("maps") legacy = {
+;           .type = BPF_MAP_TYPE_HASH, .key_size = 4, .value_size = 8,
+;           .max_entries = 16,
+;   };
+;
+;   const volatile __u64 limit = 7;
+;
+;   static __noinline __u64 inc(__u64 v) { return v + 1; }
+;
+;   SEC("socket") int count(struct __sk_buff *skb) { ... }
+;   SEC("tc") int tc_inc(struct __sk_buff *skb) { return inc(skb->len); }
+;   SEC("socket") int uses_rodata(struct __sk_buff *skb) { return limit; }
+target datalayout = "e-m:e-p:64:64-i64:64-i128:128-n32:64-S128"
+target triple = "bpf"
+
+%struct.anon = type { [2 x i32]*, i32*, i64*, [4 x i32]* }
+%struct.bpf_map_def = type { i32, i32, i32, i32, i32 }
+
+@counters = dso_local global %struct.anon zeroinitializer, section ".maps", align 8, !dbg !0
+@legacy = dso_local global %struct.bpf_map_def { i32 1, i32 4, i32 8, i32 16, i32 0 }, section "maps", align 4
+@limit = dso_local constant i64 7, align 8
+@llvm.used = appending global [3 x i8*] [i8* bitcast (%struct.anon* @counters to i8*), i8* bitcast (%struct.bpf_map_def* @legacy to i8*), i8* bitcast (i32 (i8*)* @count to i8*)], section "llvm.metadata"
+
+define internal i64 @inc(i64 %v) noinline !dbg !40 {
+  %r = add i64 %v, 1
+  ret i64 %r
+}
+
+define dso_local i32 @count(i8* %skb) section "socket" !dbg !41 {
+  %key = alloca i32, align 4
+  store i32 0, i32* %key, align 4
+  %k = bitcast i32* %key to i8*
+  %v = call i8* inttoptr (i64 1 to i8* (i8*, i8*)*)(i8* bitcast (%struct.anon* @counters to i8*), i8* %k)
+  %isnull = icmp eq i8* %v, null
+  br i1 %isnull, label %legacy, label %update
+
+update:
+  %p = bitcast i8* %v to i64*
+  %old = load i64, i64* %p, align 8
+  %new = call i64 @inc(i64 %old), !dbg !50
+  store i64 %new, i64* %p, align 8
+  br label %legacy
+
+legacy:
+  %w = call i8* inttoptr (i64 1 to i8* (i8*, i8*)*)(i8* bitcast (%struct.bpf_map_def* @legacy to i8*), i8* %k)
+  %isnull2 = icmp eq i8* %w, null
+  %ret = select i1 %isnull2, i32 0, i32 1
+  ret i32 %ret
+}
+
+define dso_local i32 @tc_inc(i8* %skb) section "tc" !dbg !42 {
+  %p = bitcast i8* %skb to i32*
+  %len = load i32, i32* %p, align 4
+  %l = zext i32 %len to i64
+  %r = call i64 @inc(i64 %l), !dbg !51
+  %t = trunc i64 %r to i32
+  ret i32 %t
+}
+
+define dso_local i32 @uses_rodata(i8* %skb) section "socket" !dbg !43 {
+  %v = load volatile i64, i64* @limit, align 8
+  %t = trunc i64 %v to i32
+  ret i32 %t
+}
+
+!llvm.dbg.cu = !{!2}
+!llvm.module.flags = !{!30, !31}
+
+!0 = !DIGlobalVariableExpression(var: !1, expr: !DIExpression())
+!1 = distinct !DIGlobalVariable(name: "counters", scope: !2, file: !3, line: 1, type: !5, isLocal: false, isDefinition: true)
+!2 = distinct !DICompileUnit(language: DW_LANG_C99, file: !3, producer: "buzzer", isOptimized: true, runtimeVersion: 0, emissionKind: FullDebug, globals: !4)
+!3 = !DIFile(filename: "prog.c", directory: "/")
+!4 = !{!0}
+!5 = distinct !DICompositeType(tag: DW_TAG_structure_type, file: !3, line: 1, size: 256, elements: !6)
+!6 = !{!7, !13, !17, !21}
+!7 = !DIDerivedType(tag: DW_TAG_member, name: "type", scope: !5, file: !3, line: 2, baseType: !8, size: 64)
+!8 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: !9, size: 64)
+!9 = !DICompositeType(tag: DW_TAG_array_type, baseType: !10, size: 64, elements: !11)
+!10 = !DIBasicType(name: "int", size: 32, encoding: DW_ATE_signed)
+!11 = !{!12}
+!12 = !DISubrange(count: 2)
+!13 = !DIDerivedType(tag: DW_TAG_member, name: "key", scope: !5, file: !3, line: 3, baseType: !14, size: 64, offset: 64)
+!14 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: !15, size: 64)
+!15 = !DIDerivedType(tag: DW_TAG_typedef, name: "__u32", file: !3, baseType: !16)
+!16 = !DIBasicType(name: "unsigned int", size: 32, encoding: DW_ATE_unsigned)
+!17 = !DIDerivedType(tag: DW_TAG_member, name: "value", scope: !5, file: !3, line: 4, baseType: !18, size: 64, offset: 128)
+!18 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: !19, size: 64)
+!19 = !DIDerivedType(tag: DW_TAG_typedef, name: "__u64", file: !3, baseType: !20)
+!20 = !DIBasicType(name: "unsigned long long", size: 64, encoding: DW_ATE_unsigned)
+!21 = !DIDerivedType(tag: DW_TAG_member, name: "max_entries", scope: !5, file: !3, line: 5, baseType: !22, size: 64, offset: 192)
+!22 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: !23, size: 64)
+!23 = !DICompositeType(tag: DW_TAG_array_type, baseType: !10, size: 128, elements: !24)
+!24 = !{!25}
+!25 = !DISubrange(count: 4)
+!30 = !{i32 7, !"Dwarf Version", i32 5}
+!31 = !{i32 2, !"Debug Info Version", i32 3}
+!44 = !DISubroutineType(types: !45)
+!45 = !{!20, !20}
+!46 = !DISubroutineType(types: !47)
+!47 = !{!10, !48}
+!48 = !DIDerivedType(tag: DW_TAG_pointer_type, baseType: null, size: 64)
+!40 = distinct !DISubprogram(name: "inc", scope: !3, file: !3, line: 10, type: !44, spFlags: DISPFlagLocalToUnit | DISPFlagDefinition | DISPFlagOptimized, unit: !2)
+!41 = distinct !DISubprogram(name: "count", scope: !3, file: !3, line: 20, type: !46, spFlags: DISPFlagDefinition | DISPFlagOptimized, unit: !2)
+!42 = distinct !DISubprogram(name: "tc_inc", scope: !3, file: !3, line: 30, type: !46, spFlags: DISPFlagDefinition | DISPFlagOptimized, unit: !2)
+!43 = distinct !DISubprogram(name: "uses_rodata", scope: !3, file: !3, line: 40, type: !46, spFlags: DISPFlagDefinition | DISPFlagOptimized, unit: !2)
+!50 = !DILocation(line: 22, scope: !41)
+!51 = !DILocation(line: 31, scope: !42)
@@ -0,0 +1,141 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"buzzer/pkg/btf/btf"
+	"buzzer/pkg/logging/logging"
+	"buzzer/pkg/setup/setup"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// maxTransientBackoff caps the wait between two loads of the same program.
+const maxTransientBackoff = time.Second
+
+// transientErrnos are the bpf() failures caused by the state of the system
+// rather than by the program, loading it again later can succeed.
+var transientErrnos = map[syscall.Errno]bool{
+	syscall.EAGAIN: true,
+	syscall.EBUSY:  true,
+	syscall.EINTR:  true,
+	syscall.ENOMEM: true,
+}
+
+// IsTransientFailure returns true if the program of `vres` was not loaded
+// because of a transient failure, the verifier did not judge it.
+func IsTransientFailure(vres *fpb.ValidationResult) bool {
+	return !vres.GetIsValid() && transientErrnos[syscall.Errno(vres.GetBpfErrno())]
+}
+
+// TransientFailure is returned when a program could not be loaded after
+// retrying every transient failure.
+type TransientFailure struct {
+	BpfError string
+	Attempts int
+}
+
+func (e *TransientFailure) Error() string {
+	return fmt.Sprintf("program could not be loaded after %d attempts: %s", e.Attempts, e.BpfError)
+}
+
+// retryTransient calls `load` again while the program fails to load because
+// of a transient failure, up to TransientRetries times, waiting
+// TransientBackoff before the first retry and twice as long before each
+// subsequent one. The first EPERM or ENOMEM also raises RLIMIT_MEMLOCK.
+// A load the watchdog interrupted fails with EINTR or EAGAIN, it is not
+// retried and the watchdog reports the hang.
+func (cu *Control) retryTransient(load func() (*fpb.ValidationResult, error)) (*fpb.ValidationResult, error) {
+	backoff := cu.TransientBackoff
+	for attempt := 1; ; attempt++ {
+		vres, err := cu.maybeRaiseMemlock(load)
+		if err != nil || !IsTransientFailure(vres) || atomic.LoadUint32(&cu.interrupted) != 0 {
+			return vres, err
+		}
+		if attempt > cu.TransientRetries {
+			if cu.ffi.MetricsUnit != nil {
+				cu.ffi.MetricsUnit.RecordDroppedProgram()
+			}
+			return nil, &TransientFailure{BpfError: vres.GetBpfError(), Attempts: attempt}
+		}
+		time.Sleep(backoff)
+		backoff = min(2*backoff, maxTransientBackoff)
+	}
+}
+
+// maybeRaiseMemlock calls `load` and, the first time it fails with EPERM or
+// ENOMEM, raises RLIMIT_MEMLOCK and calls it again.
+func (cu *Control) maybeRaiseMemlock(load func() (*fpb.ValidationResult, error)) (*fpb.ValidationResult, error) {
+	vres, err := load()
+	if err != nil || cu.memlockRaised || cu.FixedMemoryLimits || vres.GetIsValid() {
+		return vres, err
+	}
+	errno := syscall.Errno(vres.GetBpfErrno())
+	if errno != syscall.EPERM && errno != syscall.ENOMEM {
+		return vres, nil
+	}
+	cu.memlockRaised = true
+	if err := setup.RaiseMemlock(); err != nil {
+		logging.Warningf("Could not raise RLIMIT_MEMLOCK: %v\n", err)
+		return vres, nil
+	}
+	logging.Infof("Raised RLIMIT_MEMLOCK after a load failed with %v\n", errno)
+	return load()
+}
+
+// validateProgram is ValidateProgram with transient failures retried and
+// the privileges the strategy asks for.
+func (cu *Control) validateProgram(prog []uint64) (*fpb.ValidationResult, error) {
+	return cu.retryTransient(func() (*fpb.ValidationResult, error) {
+		return cu.withStrategyPrivileges(func() (*fpb.ValidationResult, error) {
+			if bs, ok := cu.strat.(BTFStrategy); ok {
+				if b := bs.ProgramBTF(); b != nil {
+					return cu.ffi.ValidateProgramWithBTF(prog, b)
+				}
+			}
+			if progType, progFlags := cu.programType(), cu.programFlags(); progType != ProgTypeSocketFilter || progFlags != 0 {
+				return cu.ffi.ValidateProgramOfType(prog, progType, progFlags)
+			}
+			return cu.ffi.ValidateProgram(prog)
+		})
+	})
+}
+
+// loadProgram is LoadProgram with transient failures retried and the
+// privileges the strategy asks for.
+func (cu *Control) loadProgram(prog []uint64) (*fpb.ValidationResult, error) {
+	return cu.retryTransient(func() (*fpb.ValidationResult, error) {
+		return cu.withStrategyPrivileges(func() (*fpb.ValidationResult, error) {
+			if progType, progFlags := cu.programType(), cu.programFlags(); progType != ProgTypeSocketFilter || progFlags != 0 {
+				return cu.ffi.LoadProgramOfType(prog, progType, progFlags)
+			}
+			return cu.ffi.LoadProgram(prog)
+		})
+	})
+}
+
+// loadProgramWithBTF is ValidateProgramWithBTF with transient failures
+// retried and the privileges the strategy asks for.
+func (cu *Control) loadProgramWithBTF(prog []uint64, b *btf.Program) (*fpb.ValidationResult, error) {
+	return cu.retryTransient(func() (*fpb.ValidationResult, error) {
+		return cu.withStrategyPrivileges(func() (*fpb.ValidationResult, error) {
+			return cu.ffi.ValidateProgramWithBTF(prog, b)
+		})
+	})
+}
@@ -0,0 +1,141 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"syscall"
+	"testing"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestIsTransientFailure(t *testing.T) {
+	for _, c := range []struct {
+		testName string
+		vres     *fpb.ValidationResult
+		want     bool
+	}{
+		{
+			testName: "Valid program",
+			vres:     &fpb.ValidationResult{IsValid: true},
+			want:     false,
+		},
+		{
+			testName: "Verifier rejection",
+			vres:     &fpb.ValidationResult{BpfErrno: int32(syscall.EACCES)},
+			want:     false,
+		},
+		{
+			testName: "Invalid argument",
+			vres:     &fpb.ValidationResult{BpfErrno: int32(syscall.EINVAL)},
+			want:     false,
+		},
+		{
+			testName: "Out of memory",
+			vres:     &fpb.ValidationResult{BpfErrno: int32(syscall.ENOMEM)},
+			want:     true,
+		},
+		{
+			testName: "Try again",
+			vres:     &fpb.ValidationResult{BpfErrno: int32(syscall.EAGAIN)},
+			want:     true,
+		},
+	} {
+		t.Run(c.testName, func(t *testing.T) {
+			if got := IsTransientFailure(c.vres); got != c.want {
+				t.Errorf("IsTransientFailure(%v) = %v, want %v", c.vres, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRetryTransient(t *testing.T) {
+	for _, c := range []struct {
+		testName     string
+		failures     int
+		wantAttempts int
+		wantDropped  bool
+	}{
+		{
+			testName:     "No failure",
+			failures:     0,
+			wantAttempts: 1,
+		},
+		{
+			testName:     "Failures within the retries",
+			failures:     2,
+			wantAttempts: 3,
+		},
+		{
+			testName:     "Out of retries",
+			failures:     10,
+			wantAttempts: 3,
+			wantDropped:  true,
+		},
+	} {
+		t.Run(c.testName, func(t *testing.T) {
+			mc := &MetricsCollection{}
+			cu := &Control{
+				TransientRetries: 2,
+				ffi:              &FFI{MetricsUnit: &Metrics{metricsCollection: mc}},
+			}
+			attempts := 0
+			vres, err := cu.retryTransient(func() (*fpb.ValidationResult, error) {
+				attempts++
+				if attempts <= c.failures {
+					return &fpb.ValidationResult{BpfErrno: int32(syscall.EAGAIN)}, nil
+				}
+				return &fpb.ValidationResult{IsValid: true}, nil
+			})
+
+			if attempts != c.wantAttempts {
+				t.Errorf("load called %d times, want %d", attempts, c.wantAttempts)
+			}
+			_, dropped := mc.getTransientCounters()
+			var transient *TransientFailure
+			if c.wantDropped {
+				if !errors.As(err, &transient) || transient.Attempts != c.wantAttempts {
+					t.Errorf("retryTransient() error = %v, want a TransientFailure after %d attempts", err, c.wantAttempts)
+				}
+				if dropped != 1 {
+					t.Errorf("dropped programs = %d, want 1", dropped)
+				}
+				return
+			}
+			if err != nil || !vres.GetIsValid() {
+				t.Errorf("retryTransient() = %v, %v, want a valid program", vres, err)
+			}
+			if dropped != 0 {
+				t.Errorf("dropped programs = %d, want 0", dropped)
+			}
+		})
+	}
+}
+
+func TestRecordTransientFailure(t *testing.T) {
+	mc := &MetricsCollection{}
+	mc.recordVerifiedProgram()
+	mc.recordVerifiedProgram()
+	mc.recordTransientFailure("Cannot allocate memory")
+
+	if got := mc.getProgramsVerified(); got != 1 {
+		t.Errorf("getProgramsVerified() = %d, want 1", got)
+	}
+	failures, _ := mc.getTransientCounters()
+	if failures["Cannot allocate memory"] != 1 || len(failures) != 1 {
+		t.Errorf("getTransientCounters() = %v, want one memory allocation failure", failures)
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"buzzer/pkg/logging/logging"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// Oracles that report findings, see Finding.Oracle.
+const (
+	// OracleExecution is the check of the execution results done by the
+	// strategy.
+	OracleExecution = "execution"
+
+	// OracleNondeterminism is the comparison of the verifier results of
+	// several loads of the same program.
+	OracleNondeterminism = "verifier_nondeterminism"
+
+	// OracleMissingGuard is the removal of the guards the verifier should
+	// require.
+	OracleMissingGuard = "missing_guard"
+
+	// OracleInvalidEncoding is the negative suite of invalid instruction
+	// encodings.
+	OracleInvalidEncoding = "invalid_encoding"
+
+	// OracleLogLevels is the comparison of the verifier results of loads
+	// at different log levels.
+	OracleLogLevels = "log_levels"
+
+	// OracleKernelSplat is the kernel log, see KernelLog.
+	OracleKernelSplat = "kernel_splat"
+
+	// OracleReferenceLeak is the search of the references acquired from
+	// helpers that accepted programs never release, see ebpf.ObviousLeaks.
+	OracleReferenceLeak = "reference_leak"
+
+	// OracleMapDelta is the comparison of the changes executions made to
+	// the maps with the expected ones, see MapDeltaStrategy.
+	OracleMapDelta = "map_delta"
+
+	// OracleHang is the watchdog of the loads and executions, see
+	// Control.HangTimeout.
+	OracleHang = "hang"
+
+	// OracleExitCode is the comparison of the value arithmetic only
+	// programs return with the one computed in Go, see ebpf.EvaluateAlu.
+	OracleExitCode = "exit_code"
+)
+
+// Patterns of the kernel splat lines that name the function the splat
+// happened in, in order of preference.
+var splatFunctionPatterns = []*regexp.Regexp{
+	// BUG: KASAN: slab-out-of-bounds in bpf_check+0x1234/0x5678
+	regexp.MustCompile(`BUG: \w+: [\w-]+ in (\w+)`),
+	// WARNING: CPU: 0 PID: 1 at kernel/bpf/verifier.c:123 do_check+0x12/0x34
+	regexp.MustCompile(`WARNING: .* at \S+ (\w+)\+0x`),
+	// RIP: 0010:adjust_ptr_min_max_vals+0x12/0x34
+	regexp.MustCompile(`RIP: \w+:(\w+)\+0x`),
+}
+
+// splatFunction returns the kernel function the first splat of `log`, e.g.
+// the output of dmesg, happened in, or an empty string if there is none.
+func splatFunction(log string) string {
+	for _, pattern := range splatFunctionPatterns {
+		if match := pattern.FindStringSubmatch(log); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// verifierError returns the error the verifier rejected the program of `vres`
+// with, or an empty string if it was accepted.
+func verifierError(vres *fpb.ValidationResult) string {
+	if vres == nil || vres.GetIsValid() {
+		return ""
+	}
+	lines := strings.Split(strings.TrimRight(vres.GetVerifierLog(), "\n"), "\n")
+	if len(lines) < 2 {
+		return ""
+	}
+	// Like in processVerifierLog, the last line has the statistics.
+	return strings.TrimSpace(lines[len(lines)-2])
+}
+
+// Signature identifies the bug behind a finding. It only depends on what
+// stays the same when the bug is hit by different programs.
+type Signature struct {
+	// Oracle is the check that reported the finding, e.g. OracleExecution.
+	Oracle string
+
+	// Strategy is the name of the strategy that generated the program.
+	Strategy string
+
+	// VerifierError is the class of the error the verifier rejected the
+	// program with, see errorClass, empty if it was accepted.
+	VerifierError string
+
+	// SplatFunction is the kernel function of the splat the program
+	// triggered, empty if there was none.
+	SplatFunction string
+
+	// Description is the description of the finding with its numbers
+	// masked, see descriptionClass.
+	Description string
+
+	// Execution is how the execution of the program went wrong, see
+	// executionClass, empty if it was not executed.
+	Execution string
+}
+
+// descriptionNumbers matches the numbers of a description, hexadecimal ones
+// first so their digits are masked along with them.
+var descriptionNumbers = regexp.MustCompile(`0x[0-9a-fA-F]+|[0-9]+`)
+
+// descriptionClass masks the numbers of the description of a finding, e.g.
+// the instruction indexes and the addresses, which change from a program to
+// the other.
+func descriptionClass(description string) string {
+	return descriptionNumbers.ReplaceAllString(strings.TrimSpace(description), "N")
+}
+
+// executionClass returns how the execution of `exRes` went: the class of
+// its error if it failed, see errorClass, or the range of the value it
+// returned: zero, small values like the booleans and the lengths, or large
+// ones like the bits of a pointer.
+func executionClass(exRes *fpb.ExecutionResult) string {
+	switch {
+	case exRes == nil:
+		return ""
+	case !exRes.GetDidSucceed():
+		return "error " + errorClass(exRes.GetErrorMessage())
+	case exRes.GetRetval() == 0:
+		return "retval zero"
+	case exRes.GetRetval() < 0x10000:
+		return "retval small"
+	default:
+		return "retval large"
+	}
+}
+
+// findingSignature returns the signature of `f`, found by `strategy`.
+func findingSignature(f *Finding, strategy string) Signature {
+	sig := Signature{
+		Oracle:        f.Oracle,
+		Strategy:      strategy,
+		SplatFunction: splatFunction(f.Splat),
+		Description:   descriptionClass(f.Description),
+		Execution:     executionClass(f.ExecutionResult),
+	}
+	if verr := verifierError(f.ValidationResult); verr != "" {
+		sig.VerifierError = errorClass(verr)
+	}
+	return sig
+}
+
+// Hash returns a short stable identifier of the signature, usable as a file
+// name.
+func (s Signature) Hash() string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{s.Oracle, s.Strategy, s.VerifierError, s.SplatFunction, s.Description, s.Execution}, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+func (s Signature) String() string {
+	res := fmt.Sprintf("oracle=%s strategy=%s", s.Oracle, s.Strategy)
+	if s.VerifierError != "" {
+		res += fmt.Sprintf(" verifier_error=%q", s.VerifierError)
+	}
+	if s.SplatFunction != "" {
+		res += " splat=" + s.SplatFunction
+	}
+	if s.Description != "" {
+		res += fmt.Sprintf(" description=%q", s.Description)
+	}
+	if s.Execution != "" {
+		res += fmt.Sprintf(" execution=%q", s.Execution)
+	}
+	return res
+}
+
+// TriageBucket gathers the findings that share a signature.
+type TriageBucket struct {
+	Signature Signature
+
+	// Count is how many findings had the signature.
+	Count int
+
+	// First is the finding that was reported, the others were dropped.
+	First *Finding
+}
+
+// Triage classifies findings by signature so only the first finding of each
+// signature is reported, the later ones are only counted. It can be shared
+// by several control units.
+type Triage struct {
+	// Dir, if not empty, is where the reproducers of every signature are
+	// kept, in a subdirectory named after its hash along with a
+	// signature.txt file describing it.
+	Dir string
+
+	mu      sync.Mutex
+	buckets map[string]*TriageBucket
+}
+
+// NewTriage returns a triage without any finding that keeps reproducers in
+// `dir`, if not empty.
+func NewTriage(dir string) *Triage {
+	return &Triage{Dir: dir, buckets: make(map[string]*TriageBucket)}
+}
+
+// Classify adds `f`, found by `strategy`, to the bucket of its signature.
+// Returns a copy of the bucket and whether `f` is the first finding in it.
+func (t *Triage) Classify(f *Finding, strategy string) (TriageBucket, bool) {
+	sig := findingSignature(f, strategy)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	bucket, ok := t.buckets[sig.Hash()]
+	if !ok {
+		bucket = &TriageBucket{Signature: sig, First: f}
+		t.buckets[sig.Hash()] = bucket
+	}
+	bucket.Count++
+	if t.Dir != "" && ok {
+		if err := t.writeSignature(bucket); err != nil {
+			logging.Warningf("Triage error: %v\n", err)
+		}
+	}
+	return *bucket, !ok
+}
+
+// Buckets returns a copy of every bucket, the most common signatures first.
+func (t *Triage) Buckets() []TriageBucket {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var res []TriageBucket
+	for _, bucket := range t.buckets {
+		res = append(res, *bucket)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Count != res[j].Count {
+			return res[i].Count > res[j].Count
+		}
+		return res[i].Signature.Hash() < res[j].Signature.Hash()
+	})
+	return res
+}
+
+func (t *Triage) bucketDir(sig Signature) string {
+	return filepath.Join(t.Dir, sig.Hash())
+}
+
+// writeSignature describes `bucket` in its directory. Must be called with
+// the lock held.
+func (t *Triage) writeSignature(bucket *TriageBucket) error {
+	if err := os.MkdirAll(t.bucketDir(bucket.Signature), 0755); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("%s\ncount=%d\ndescription=%s\n", bucket.Signature, bucket.Count, bucket.First.Description)
+	return os.WriteFile(filepath.Join(t.bucketDir(bucket.Signature), "signature.txt"), []byte(content), 0644)
+}
+
+// store moves the reproducers of `f`, the first finding with the signature
+// `sig`, to the directory of its bucket and updates f.ReproPaths accordingly.
+func (t *Triage) store(f *Finding, sig Signature) error {
+	if t.Dir == "" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.writeSignature(t.buckets[sig.Hash()]); err != nil {
+		return err
+	}
+	for i, path := range f.ReproPaths {
+		dest := filepath.Join(t.bucketDir(sig), filepath.Base(path))
+		if err := moveFile(path, dest); err != nil {
+			return err
+		}
+		f.ReproPaths[i] = dest
+	}
+	return nil
+}
+
+// moveFile moves `src` to `dest`, copying it if they are on different file
+// systems, e.g. a tmpfs and the disk.
+func moveFile(src, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return nil
+	}
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(dest, data, 0644); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,158 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestSplatFunction(t *testing.T) {
+	for _, c := range []struct {
+		testName string
+		log      string
+		want     string
+	}{
+		{
+			testName: "KASAN report",
+			log:      "[   12.3] ==================\n[   12.3] BUG: KASAN: slab-out-of-bounds in bpf_map_lookup_elem+0x12/0x40\n[   12.3] Read of size 8\n",
+			want:     "bpf_map_lookup_elem",
+		},
+		{
+			testName: "Warning",
+			log:      "WARNING: CPU: 1 PID: 42 at kernel/bpf/verifier.c:1234 mark_reg_unknown+0x1a/0x30\nRIP: 0010:mark_reg_unknown+0x1a/0x30\n",
+			want:     "mark_reg_unknown",
+		},
+		{
+			testName: "Oops",
+			log:      "BUG: unable to handle page fault for address: ffff\nRIP: 0010:___bpf_prog_run+0x1234/0x4000\n",
+			want:     "___bpf_prog_run",
+		},
+		{
+			testName: "No splat",
+			log:      "random: crng init done\n",
+		},
+	} {
+		if got := splatFunction(c.log); got != c.want {
+			t.Errorf("%s: splatFunction() = %q, want %q", c.testName, got, c.want)
+		}
+	}
+}
+
+func TestTriage(t *testing.T) {
+	dir := t.TempDir()
+	triage := NewTriage(dir)
+
+	rejected := func(verdict string) *Finding {
+		return &Finding{Oracle: OracleExecution, ValidationResult: VerifierRejection(verdict)}
+	}
+	if _, first := triage.Classify(rejected("R1 invalid mem access 'scalar'"), "playground"); !first {
+		t.Errorf("Classify() of the first finding = false, want true")
+	}
+	// Registers and offsets don't change the signature.
+	if bucket, first := triage.Classify(rejected("R3 invalid mem access 'scalar'"), "playground"); first || bucket.Count != 2 {
+		t.Errorf("Classify() of a duplicate = %v, count %d, want false, count 2", first, bucket.Count)
+	}
+	for _, f := range []struct {
+		finding  *Finding
+		strategy string
+	}{
+		{rejected("R1 invalid mem access 'scalar'"), "pointer_arithmetic"},
+		{&Finding{Oracle: OracleExecution, Splat: "BUG: KASAN: use-after-free in htab_map_update_elem+0x1/0x2"}, "playground"},
+		{&Finding{Oracle: OracleNondeterminism, ValidationResult: VerifierAcceptance()}, "playground"},
+	} {
+		if _, first := triage.Classify(f.finding, f.strategy); !first {
+			t.Errorf("Classify(%+v) = false, want a new signature", f)
+		}
+	}
+	if got := len(triage.Buckets()); got != 4 {
+		t.Errorf("len(Buckets()) = %d, want 4", got)
+	}
+	if top := triage.Buckets()[0]; top.Count != 2 || top.Signature.VerifierError != "RN invalid mem access 'scalar'" {
+		t.Errorf("Buckets()[0] = %+v, want the invalid mem access seen twice", top)
+	}
+
+	repro := filepath.Join(t.TempDir(), "ebpf-poc-1.c")
+	if err := os.WriteFile(repro, []byte("int main() {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f := &Finding{Description: "Program produced unexpected results", Oracle: OracleMissingGuard, ReproPaths: []string{repro}}
+	bucket, _ := triage.Classify(f, "playground")
+	if err := triage.store(f, bucket.Signature); err != nil {
+		t.Fatalf("store() = %v", err)
+	}
+	want := filepath.Join(dir, bucket.Signature.Hash(), "ebpf-poc-1.c")
+	if f.ReproPaths[0] != want {
+		t.Errorf("ReproPaths = %v, want [%s]", f.ReproPaths, want)
+	}
+	if _, err := os.Stat(want); err != nil {
+		t.Errorf("reproducer was not moved: %v", err)
+	}
+	signature, err := os.ReadFile(filepath.Join(dir, bucket.Signature.Hash(), "signature.txt"))
+	if err != nil || !strings.Contains(string(signature), "oracle=missing_guard strategy=playground description=\"Program produced unexpected results\"\ncount=1\n") {
+		t.Errorf("signature.txt = %q, %v", signature, err)
+	}
+}
+
+func TestFindingSignature(t *testing.T) {
+	executed := func(exRes *fpb.ExecutionResult) *Finding {
+		return &Finding{Description: "Program produced unexpected results", Oracle: OracleExecution, ExecutionResult: exRes}
+	}
+	tests := []struct {
+		testName string
+		a, b     *Finding
+		wantSame bool
+	}{
+		{
+			testName: "Different small return values",
+			a:        executed(&fpb.ExecutionResult{DidSucceed: true, Retval: 1}),
+			b:        executed(&fpb.ExecutionResult{DidSucceed: true, Retval: 42}),
+			wantSame: true,
+		},
+		{
+			testName: "Leaked pointer",
+			a:        executed(&fpb.ExecutionResult{DidSucceed: true, Retval: 1}),
+			b:        executed(&fpb.ExecutionResult{DidSucceed: true, Retval: 0xffff8880}),
+		},
+		{
+			testName: "Failed execution",
+			a:        executed(&fpb.ExecutionResult{DidSucceed: true}),
+			b:        executed(&fpb.ExecutionResult{ErrorMessage: "bpf_prog_test_run failed: 524"}),
+		},
+		{
+			testName: "Different instruction indexes",
+			a:        &Finding{Description: "Verifier accepted a program that leaks the reference acquired at instruction 3", Oracle: OracleReferenceLeak},
+			b:        &Finding{Description: "Verifier accepted a program that leaks the reference acquired at instruction 12", Oracle: OracleReferenceLeak},
+			wantSame: true,
+		},
+		{
+			testName: "Different oracle verdicts",
+			a:        &Finding{Description: "R0 is a pointer", Oracle: "leak"},
+			b:        &Finding{Description: "R0 exceeds the map value", Oracle: "leak"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			a, b := findingSignature(tc.a, "playground"), findingSignature(tc.b, "playground")
+			if same := a.Hash() == b.Hash(); same != tc.wantSame {
+				t.Errorf("signatures %s and %s are the same: %v, want %v", a, b, same, tc.wantSame)
+			}
+		})
+	}
+}
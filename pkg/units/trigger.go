@@ -0,0 +1,128 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"buzzer/pkg/rand"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// Trigger builds the requests that execute socket filter programs, by sending
+// packets through a socket they are attached to, see Control.Triggers.
+type Trigger interface {
+	// Request returns the request that executes the program `progFd`.
+	Request(progFd int64) *fpb.ExecutionRequest
+
+	// Name identifies the trigger, see ParseTriggers.
+	Name() string
+}
+
+// triggerPayloadSizes are the sizes the payloads of the packet triggers are
+// picked from, from a single byte to more than a page.
+var triggerPayloadSizes = []int{1, 4, 14, 64, 576, 1500, 4096, 9000}
+
+// triggerSockets are the sockets of ParseTriggers by name.
+var triggerSockets = map[string]fpb.TriggerSocket{
+	"unix": fpb.TriggerSocket_UnixDgram,
+	"udp":  fpb.TriggerSocket_UdpIpv4,
+	"udp6": fpb.TriggerSocket_UdpIpv6,
+	"tcp":  fpb.TriggerSocket_TcpIpv4,
+	"tcp6": fpb.TriggerSocket_TcpIpv6,
+}
+
+// PacketTrigger sends a payload of random bytes through Socket, split in
+// Fragments packets or TCP segments. The programs attached to the UDP and TCP
+// sockets see the transport header before the payload.
+type PacketTrigger struct {
+	Socket    fpb.TriggerSocket
+	Fragments int
+
+	// Sizes the size of the payload is picked from, triggerPayloadSizes
+	// if empty.
+	Sizes []int
+}
+
+func (pt *PacketTrigger) Request(progFd int64) *fpb.ExecutionRequest {
+	sizes := pt.Sizes
+	if len(sizes) == 0 {
+		sizes = triggerPayloadSizes
+	}
+	size := sizes[rand.SharedRNG.RandRange(0, uint64(len(sizes)-1))]
+	payload := make([]byte, (size+7)/8*8)
+	for i := 0; i < len(payload); i += 8 {
+		binary.LittleEndian.PutUint64(payload[i:], rand.SharedRNG.RandInt())
+	}
+	return &fpb.ExecutionRequest{
+		ProgFd:    progFd,
+		InputData: payload[:size],
+		Socket:    pt.Socket,
+		Fragments: uint32(pt.Fragments),
+	}
+}
+
+func (pt *PacketTrigger) Name() string {
+	for name, socket := range triggerSockets {
+		if socket != pt.Socket {
+			continue
+		}
+		if pt.Fragments > 1 {
+			return fmt.Sprintf("%s:%d", name, pt.Fragments)
+		}
+		return name
+	}
+	return pt.Socket.String()
+}
+
+// ParseTriggers parses comma separated triggers: a socket among unix, udp,
+// udp6, tcp and tcp6, optionally followed by the number of fragments the
+// payload is split into, e.g. "udp,tcp6:4".
+func ParseTriggers(spec string) ([]Trigger, error) {
+	var triggers []Trigger
+	for _, trigger := range strings.Split(spec, ",") {
+		name, fragments, found := strings.Cut(trigger, ":")
+		socket, ok := triggerSockets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown trigger socket %q", name)
+		}
+		pt := &PacketTrigger{Socket: socket, Fragments: 1}
+		if found {
+			n, err := strconv.Atoi(fragments)
+			if err != nil {
+				return nil, fmt.Errorf("trigger %q: %w", trigger, err)
+			}
+			if n < 1 {
+				return nil, fmt.Errorf("trigger %q has no fragments", trigger)
+			}
+			pt.Fragments = n
+		}
+		triggers = append(triggers, pt)
+	}
+	return triggers, nil
+}
+
+// socketRequest returns the request that executes the socket filter
+// `progFd` with one of the Triggers picked at random, or with 4 bytes sent
+// over a unix socket if there are none.
+func (cu *Control) socketRequest(progFd int64) *fpb.ExecutionRequest {
+	if len(cu.Triggers) == 0 {
+		return &fpb.ExecutionRequest{ProgFd: progFd}
+	}
+	return cu.Triggers[rand.SharedRNG.RandRange(0, uint64(len(cu.Triggers)-1))].Request(progFd)
+}
@@ -0,0 +1,78 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestParseTriggers(t *testing.T) {
+	for _, c := range []struct {
+		spec    string
+		want    []string
+		wantErr bool
+	}{
+		{spec: "unix", want: []string{"unix"}},
+		{spec: "udp,tcp6:4", want: []string{"udp", "tcp6:4"}},
+		{spec: "udp6:1", want: []string{"udp6"}},
+		{spec: "sctp", wantErr: true},
+		{spec: "tcp:0", wantErr: true},
+		{spec: "tcp:many", wantErr: true},
+	} {
+		triggers, err := ParseTriggers(c.spec)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("ParseTriggers(%q) did not return an error", c.spec)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseTriggers(%q) = %v", c.spec, err)
+			continue
+		}
+		var got []string
+		for _, trigger := range triggers {
+			got = append(got, trigger.Name())
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("ParseTriggers(%q) = %v, want %v", c.spec, got, c.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != c.want[i] {
+				t.Errorf("ParseTriggers(%q) = %v, want %v", c.spec, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPacketTriggerRequest(t *testing.T) {
+	pt := &PacketTrigger{Socket: fpb.TriggerSocket_TcpIpv6, Fragments: 3, Sizes: []int{13}}
+	req := pt.Request(7)
+	if req.GetProgFd() != 7 || req.GetSocket() != fpb.TriggerSocket_TcpIpv6 || req.GetFragments() != 3 {
+		t.Errorf("Request(7) = %v, want program 7 sent over TCP over IPv6 in 3 fragments", req)
+	}
+	if got := len(req.GetInputData()); got != 13 {
+		t.Errorf("Request(7) sends %d bytes, want 13", got)
+	}
+
+	cu := &Control{}
+	if req := cu.socketRequest(7); req.GetSocket() != fpb.TriggerSocket_UnixDgram || len(req.GetInputData()) != 0 {
+		t.Errorf("socketRequest(7) without triggers = %v, want the default unix socket request", req)
+	}
+}
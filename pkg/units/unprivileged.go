@@ -0,0 +1,136 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"strings"
+
+	"buzzer/pkg/rand"
+	"buzzer/pkg/setup/setup"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+// LoadPrivileges are the privileges a program is loaded with, which decide
+// how strict the verifier is with it.
+type LoadPrivileges int
+
+const (
+	// LoadPrivileged loads programs with the capabilities of the fuzzer.
+	LoadPrivileged LoadPrivileges = iota
+
+	// LoadUnprivileged loads programs without the capabilities that make
+	// them privileged: the verifier refuses pointer leaks and applies the
+	// Spectre mitigations, the paths most exploitable bugs were reachable
+	// from. The unprivileged_bpf_disabled sysctl must allow it, see
+	// setup.AllowUnprivilegedBpf.
+	LoadUnprivileged
+
+	// LoadWithToken loads programs without those capabilities too, but
+	// through a BPF token, on kernels 6.9 and later, which delegates them
+	// from a user namespace the way container runtimes do. See
+	// Control.BpfToken.
+	LoadWithToken
+)
+
+var loadPrivilegesNames = []string{"privileged", "unprivileged", "token"}
+
+func (lp LoadPrivileges) String() string {
+	if lp < LoadPrivileged || lp > LoadWithToken {
+		return fmt.Sprintf("privileges(%d)", int(lp))
+	}
+	return loadPrivilegesNames[lp]
+}
+
+// ParseLoadPrivileges parses `list`, comma separated privileges among
+// privileged, unprivileged and token.
+func ParseLoadPrivileges(list string) ([]LoadPrivileges, error) {
+	var privileges []LoadPrivileges
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		found := false
+		for i, privilegesName := range loadPrivilegesNames {
+			if name == privilegesName {
+				privileges = append(privileges, LoadPrivileges(i))
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown privileges %q, must be one of %s", name, strings.Join(loadPrivilegesNames, ", "))
+		}
+	}
+	return privileges, nil
+}
+
+// UnprivilegedStrategy is implemented by the strategies that want some of
+// their programs verified as if an unprivileged user loaded them, which
+// turns on the Spectre mitigations of the verifier. The
+// unprivileged_bpf_disabled sysctl must allow it, see
+// setup.AllowUnprivilegedBpf.
+type UnprivilegedStrategy interface {
+	Strategy
+
+	// Unprivileged returns true if the last generated program must be
+	// loaded without privileges.
+	Unprivileged() bool
+}
+
+// pickPrivileges picks the privileges the next program is loaded with among
+// cu.Privileges.
+func (cu *Control) pickPrivileges() {
+	switch len(cu.Privileges) {
+	case 0:
+		cu.privileges = LoadPrivileged
+	case 1:
+		cu.privileges = cu.Privileges[0]
+	default:
+		cu.privileges = cu.Privileges[rand.SharedRNG.RandRange(0, uint64(len(cu.Privileges)-1))]
+	}
+}
+
+// programPrivileges returns the privileges the last generated program is
+// loaded with.
+func (cu *Control) programPrivileges() LoadPrivileges {
+	if us, ok := cu.strat.(UnprivilegedStrategy); ok && us.Unprivileged() {
+		return LoadUnprivileged
+	}
+	return cu.privileges
+}
+
+// withStrategyPrivileges runs `load` with the privileges of the last
+// generated program, see programPrivileges.
+func (cu *Control) withStrategyPrivileges(load func() (*fpb.ValidationResult, error)) (*fpb.ValidationResult, error) {
+	privileges := cu.programPrivileges()
+	if privileges == LoadPrivileged {
+		return load()
+	}
+	var vres *fpb.ValidationResult
+	var err error
+	capErr := setup.WithoutBpfCapabilities(func() error {
+		if privileges == LoadWithToken {
+			return cu.ffi.WithBpfToken(cu.BpfToken, func() error {
+				vres, err = load()
+				return nil
+			})
+		}
+		vres, err = load()
+		return nil
+	})
+	if capErr != nil {
+		return nil, capErr
+	}
+	return vres, err
+}
@@ -0,0 +1,72 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+)
+
+// unprivilegedStrategy asks for every program to be loaded unprivileged.
+type unprivilegedStrategy struct {
+	idleStrategy
+}
+
+func (s *unprivilegedStrategy) Unprivileged() bool {
+	return true
+}
+
+func TestParseLoadPrivileges(t *testing.T) {
+	privileges, err := ParseLoadPrivileges("privileged, unprivileged,token")
+	if err != nil {
+		t.Fatalf("ParseLoadPrivileges() error: %v", err)
+	}
+	want := []LoadPrivileges{LoadPrivileged, LoadUnprivileged, LoadWithToken}
+	if len(privileges) != len(want) {
+		t.Fatalf("ParseLoadPrivileges() = %v, want %v", privileges, want)
+	}
+	for i := range want {
+		if privileges[i] != want[i] {
+			t.Errorf("privileges[%d] = %v, want %v", i, privileges[i], want[i])
+		}
+	}
+	if _, err := ParseLoadPrivileges("root"); err == nil {
+		t.Errorf("ParseLoadPrivileges(root) succeeded, want an error")
+	}
+}
+
+func TestPickPrivileges(t *testing.T) {
+	cu := &Control{strat: &idleStrategy{}}
+	cu.pickPrivileges()
+	if got := cu.programPrivileges(); got != LoadPrivileged {
+		t.Errorf("programPrivileges() without privileges = %v, want privileged", got)
+	}
+
+	cu.Privileges = []LoadPrivileges{LoadUnprivileged, LoadWithToken}
+	picked := map[LoadPrivileges]bool{}
+	for i := 0; i < 100; i++ {
+		cu.pickPrivileges()
+		picked[cu.programPrivileges()] = true
+	}
+	if len(picked) != 2 || picked[LoadPrivileged] {
+		t.Errorf("picked privileges = %v, want unprivileged and token", picked)
+	}
+
+	cu.Privileges = []LoadPrivileges{LoadWithToken}
+	cu.strat = &unprivilegedStrategy{}
+	cu.pickPrivileges()
+	if got := cu.programPrivileges(); got != LoadUnprivileged {
+		t.Errorf("programPrivileges() of an unprivileged strategy = %v, want unprivileged", got)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"strings"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// TracedValue is the value a register had at runtime right after the
+// instruction of a trace point, see Control.ValueTraces.
+type TracedValue struct {
+	ebpf.ValueTracePoint
+	Value uint64
+}
+
+func (tv TracedValue) String() string {
+	return fmt.Sprintf("instruction %d: r%d = %#x", tv.Index, tv.Reg, tv.Value)
+}
+
+// parseValueTrace returns the values of the points of `points` that were
+// reached, out of the words of the trace map value `words`.
+func parseValueTrace(points []ebpf.ValueTracePoint, words []uint64) []TracedValue {
+	var trace []TracedValue
+	for i, point := range points {
+		slot := i * ebpf.ValueTraceSlotSize / 8
+		if slot+1 >= len(words) || words[slot+1] == 0 {
+			continue
+		}
+		trace = append(trace, TracedValue{ValueTracePoint: point, Value: words[slot]})
+	}
+	return trace
+}
+
+// traceValues loads `prog` instrumented to record the value of every register
+// it writes and executes it the same way RunFuzzer does, then returns the
+// last value of every point that was reached, in program order.
+func (cu *Control) traceValues(prog *epb.Program) ([]TracedValue, error) {
+	return cu.traceValuesAt(prog, ebpf.ValueTracePoints(prog))
+}
+
+// traceValuesAt is traceValues with only the points of `points` traced.
+func (cu *Control) traceValuesAt(prog *epb.Program, points []ebpf.ValueTracePoint) ([]TracedValue, error) {
+	if len(points) == 0 {
+		return nil, nil
+	}
+	words := uint64(len(points) * ebpf.ValueTraceSlotSize / 8)
+	mapFd := cu.ffi.CreateMap(MapTypeArray, 4, uint32(words*8), 1, 0)
+	if mapFd < 0 {
+		return nil, fmt.Errorf("could not create the trace map")
+	}
+	defer cu.ffi.CloseFD(mapFd)
+
+	instrumented, err := ebpf.InstrumentValueTrace(prog, mapFd, points)
+	if err != nil {
+		return nil, err
+	}
+	encodedProg, err := ebpf.EncodeInstructions(instrumented)
+	if err != nil {
+		return nil, err
+	}
+	vres, err := cu.loadProgram(encodedProg)
+	if err != nil {
+		return nil, err
+	}
+	defer cu.ffi.CloseFD(int(vres.GetProgramFd()))
+	if !vres.GetIsValid() {
+		log := strings.Split(strings.TrimSpace(vres.GetVerifierLog()), "\n")
+		return nil, fmt.Errorf("the verifier rejected the instrumented program: %s", log[len(log)-1])
+	}
+	if _, err := cu.executeProgram(vres.GetProgramFd()); err != nil {
+		return nil, err
+	}
+
+	value, err := cu.ffi.GetMapValue(mapFd, 0, words)
+	if err != nil {
+		return nil, err
+	}
+	if value.GetErrorMessage() != "" {
+		return nil, fmt.Errorf("could not read the trace map: %s", value.GetErrorMessage())
+	}
+	return parseValueTrace(points, value.GetElements()), nil
+}
@@ -0,0 +1,43 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"reflect"
+	"testing"
+
+	"buzzer/pkg/ebpf/ebpf"
+)
+
+func TestParseValueTrace(t *testing.T) {
+	points := []ebpf.ValueTracePoint{{Index: 0, Reg: ebpf.R1}, {Index: 3, Reg: ebpf.R0}, {Index: 4, Reg: ebpf.R2}}
+	// The second point was not reached, its value is left out even if the
+	// register was 0.
+	words := []uint64{7, 1, 0, 0, 0xffff, 1}
+	want := []TracedValue{
+		{ValueTracePoint: points[0], Value: 7},
+		{ValueTracePoint: points[2], Value: 0xffff},
+	}
+	got := parseValueTrace(points, words)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseValueTrace() = %v, want %v", got, want)
+	}
+	if got, want := got[1].String(), "instruction 4: r2 = 0xffff"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := parseValueTrace(points, words[:3]); len(got) != 1 {
+		t.Errorf("parseValueTrace() of a short trace = %v, want the first point only", got)
+	}
+}
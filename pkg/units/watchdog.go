@@ -0,0 +1,169 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// ExitHang is the exit status of buzzer when a load or an execution
+	// did not return even after being interrupted. Whatever supervises
+	// buzzer, e.g. the init of the VM image, is expected to start it again.
+	ExitHang = 3
+
+	// Once the watchdog goes off, the stuck thread is interrupted every
+	// hangInterruptInterval for up to hangGracePeriod.
+	hangInterruptInterval = 100 * time.Millisecond
+	hangGracePeriod       = 10 * time.Second
+)
+
+// HangError is returned when a load or an execution did not finish within
+// Control.HangTimeout. Its results, if it eventually returned, are
+// meaningless.
+type HangError struct {
+	Operation string
+	Timeout   time.Duration
+}
+
+func (e *HangError) Error() string {
+	return fmt.Sprintf("%s did not finish within %v", e.Operation, e.Timeout)
+}
+
+// watch calls `call`, which loads or executes `prog`, under a watchdog. If
+// it does not return within HangTimeout, the thread running it is
+// interrupted until it does: the verifier and BPF_PROG_TEST_RUN give up as
+// soon as a signal is pending. The program is then reported as a hang
+// finding and a HangError returned. If the thread is still stuck after
+// hangGracePeriod, the hang is reported and buzzer exits with ExitHang
+// rather than wedging the whole campaign.
+func (cu *Control) watch(prog *epb.Program, operation string, call func() error) error {
+	if cu.HangTimeout <= 0 {
+		return call()
+	}
+	// The cgo calls of the goroutine must stay on the thread that is
+	// interrupted.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	done := make(chan struct{})
+	hung := make(chan bool)
+	go cu.watchdog(prog, operation, syscall.Gettid(), done, hung)
+	err := call()
+	close(done)
+	wasHung := <-hung
+	atomic.StoreUint32(&cu.interrupted, 0)
+	if !wasHung {
+		return err
+	}
+	cu.reportHang(prog, operation)
+	return &HangError{Operation: operation, Timeout: cu.HangTimeout}
+}
+
+// watchdog waits for `done` to be closed by the thread `tid`, interrupting it
+// once HangTimeout elapsed. It then sends on `hung` whether it had to.
+func (cu *Control) watchdog(prog *epb.Program, operation string, tid int, done <-chan struct{}, hung chan<- bool) {
+	timer := time.NewTimer(cu.HangTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		hung <- false
+		return
+	case <-timer.C:
+	}
+
+	logging.Warningf("Watchdog: %s did not finish within %v, interrupting it\n", operation, cu.HangTimeout)
+	atomic.StoreUint32(&cu.interrupted, 1)
+	ticker := time.NewTicker(hangInterruptInterval)
+	defer ticker.Stop()
+	deadline := time.After(hangGracePeriod)
+	for {
+		// SIGURG is what the Go runtime preempts goroutines with, its
+		// handler ignores the extra ones.
+		if err := syscall.Tgkill(os.Getpid(), tid, syscall.SIGURG); err != nil {
+			logging.Warningf("Watchdog interrupt error: %v\n", err)
+		}
+		select {
+		case <-done:
+			hung <- true
+			return
+		case <-ticker.C:
+		case <-deadline:
+			// The stuck thread does not touch the control unit
+			// while it is in the kernel.
+			logging.Warningf("Watchdog: %s is stuck in the kernel, exiting\n", operation)
+			cu.reportHang(prog, operation)
+			os.Exit(ExitHang)
+		}
+	}
+}
+
+// reportHang reports `prog` as a hang finding, `operation` on it never
+// finished.
+func (cu *Control) reportHang(prog *epb.Program, operation string) {
+	if cu.ffi.MetricsUnit != nil {
+		cu.ffi.MetricsUnit.RecordDroppedProgram()
+	}
+	cu.reportFinding(&Finding{
+		Description: fmt.Sprintf("Program hung, %s did not finish within %v", operation, cu.HangTimeout),
+		Oracle:      OracleHang,
+		Program:     prog,
+	})
+}
+
+// watchedValidate is validateProgram under the watchdog. The program is
+// closed if it loaded after hanging, and not loaded again if the interrupt
+// made it fail transiently.
+func (cu *Control) watchedValidate(prog *epb.Program, encodedProg []uint64) (*fpb.ValidationResult, error) {
+	var vres *fpb.ValidationResult
+	err := cu.watch(prog, "load", func() error {
+		var err error
+		vres, err = cu.validateProgram(encodedProg)
+		return err
+	})
+	var hang *HangError
+	if errors.As(err, &hang) {
+		if vres.GetIsValid() {
+			cu.ffi.CloseFD(int(vres.GetProgramFd()))
+		}
+		return nil, err
+	}
+	return vres, err
+}
+
+// watchedExecute is executeProgram under the watchdog.
+func (cu *Control) watchedExecute(prog *epb.Program, progFd int64) (*fpb.ExecutionResult, error) {
+	var exRes *fpb.ExecutionResult
+	err := cu.watch(prog, "execution", func() error {
+		var err error
+		exRes, err = cu.executeProgram(progFd)
+		return err
+	})
+	var hang *HangError
+	if errors.As(err, &hang) {
+		return nil, err
+	}
+	return exRes, err
+}
@@ -0,0 +1,115 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"buzzer/pkg/ebpf/ebpf"
+	epb "buzzer/proto/ebpf_go_proto"
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+func TestWatch(t *testing.T) {
+	hook := &collectingHook{}
+	cu := &Control{FindingHooks: []FindingHook{hook}, HangTimeout: 50 * time.Millisecond}
+	cu.Init(&FFI{Maps: NewFakeMaps()}, nil, &returnStrategy{})
+	insn, err := ebpf.InstructionSequence(ebpf.Mov64(ebpf.R0, 0), ebpf.Exit())
+	if err != nil {
+		t.Fatalf("InstructionSequence() = %v", err)
+	}
+	prog := &epb.Program{Instructions: insn}
+
+	callErr := errors.New("call error")
+	if err := cu.watch(prog, "load", func() error { return callErr }); err != callErr {
+		t.Errorf("watch() of a quick call = %v, want %v", err, callErr)
+	}
+	if len(hook.findings) != 0 {
+		t.Fatalf("watch() of a quick call reported %d findings", len(hook.findings))
+	}
+
+	err = cu.watch(prog, "execution", func() error {
+		time.Sleep(4 * cu.HangTimeout)
+		return nil
+	})
+	var hang *HangError
+	if !errors.As(err, &hang) || hang.Operation != "execution" {
+		t.Errorf("watch() of a slow call = %v, want a HangError of the execution", err)
+	}
+	if len(hook.findings) != 1 {
+		t.Fatalf("watch() of a slow call reported %d findings, want 1", len(hook.findings))
+	}
+	finding := hook.findings[0]
+	for _, path := range finding.ReproPaths {
+		os.Remove(path)
+	}
+	if finding.Oracle != OracleHang || finding.Program != prog {
+		t.Errorf("finding = %q of %v, want a hang of the program", finding.Oracle, finding.Program)
+	}
+}
+
+func TestWatchHungLoadNotRetried(t *testing.T) {
+	hook := &collectingHook{}
+	mc := &MetricsCollection{}
+	cu := &Control{
+		FindingHooks:     []FindingHook{hook},
+		HangTimeout:      50 * time.Millisecond,
+		TransientRetries: 5,
+		TransientBackoff: time.Millisecond,
+	}
+	cu.Init(&FFI{Maps: NewFakeMaps(), MetricsUnit: &Metrics{metricsCollection: mc}}, nil, &returnStrategy{})
+	prog := &epb.Program{}
+
+	// The load hangs until it is interrupted, then fails like an
+	// interrupted verifier does.
+	loads := 0
+	err := cu.watch(prog, "load", func() error {
+		_, err := cu.retryTransient(func() (*fpb.ValidationResult, error) {
+			loads++
+			for atomic.LoadUint32(&cu.interrupted) == 0 {
+				time.Sleep(time.Millisecond)
+			}
+			return &fpb.ValidationResult{BpfErrno: int32(syscall.EINTR)}, nil
+		})
+		return err
+	})
+	for _, finding := range hook.findings {
+		for _, path := range finding.ReproPaths {
+			os.Remove(path)
+		}
+	}
+
+	var hang *HangError
+	if !errors.As(err, &hang) {
+		t.Errorf("watch() = %v, want a HangError", err)
+	}
+	if loads != 1 {
+		t.Errorf("load called %d times, want 1", loads)
+	}
+	if len(hook.findings) != 1 {
+		t.Errorf("watch() reported %d findings, want 1", len(hook.findings))
+	}
+	if _, dropped := mc.getTransientCounters(); dropped != 1 {
+		t.Errorf("dropped programs = %d, want 1", dropped)
+	}
+	if atomic.LoadUint32(&cu.interrupted) != 0 {
+		t.Errorf("interrupted still set after watch() returned")
+	}
+}
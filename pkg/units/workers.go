@@ -0,0 +1,138 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"buzzer/pkg/logging/logging"
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// CorpusSharer is implemented by the strategies that keep a population of
+// programs, so the workers of a parallel campaign can give each other the
+// programs that reached new coverage.
+type CorpusSharer interface {
+	// TakeNewPrograms returns the programs added to the population since
+	// the last call.
+	TakeNewPrograms() []*epb.Program
+
+	// AddSeeds adds programs found by other workers to the population.
+	AddSeeds(programs ...*epb.Program)
+}
+
+type sharedProgram struct {
+	worker  int
+	program *epb.Program
+}
+
+// SharedCorpus is what the workers of a parallel campaign, see RunWorkers,
+// have in common: the programs their strategies found interesting and the
+// findings that were already reported.
+type SharedCorpus struct {
+	mu       sync.Mutex
+	programs []sharedProgram
+	findings map[string]bool
+}
+
+// NewSharedCorpus returns an empty corpus.
+func NewSharedCorpus() *SharedCorpus {
+	return &SharedCorpus{findings: make(map[string]bool)}
+}
+
+// Publish adds `programs`, found by `worker`, to the corpus.
+func (sc *SharedCorpus) Publish(worker int, programs ...*epb.Program) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, prog := range programs {
+		sc.programs = append(sc.programs, sharedProgram{worker: worker, program: prog})
+	}
+}
+
+// Fetch returns the programs that workers other than `worker` published
+// after the first `cursor` ones, and the cursor to pass the next time.
+func (sc *SharedCorpus) Fetch(worker, cursor int) ([]*epb.Program, int) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	var res []*epb.Program
+	for _, shared := range sc.programs[cursor:] {
+		if shared.worker != worker {
+			res = append(res, shared.program)
+		}
+	}
+	return res, len(sc.programs)
+}
+
+// Len returns how many programs were published.
+func (sc *SharedCorpus) Len() int {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return len(sc.programs)
+}
+
+// ClaimFinding returns true the first time it is called with `description`
+// and false afterwards, so a finding several workers run into is only
+// reported once.
+func (sc *SharedCorpus) ClaimFinding(description string) bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.findings[description] {
+		return false
+	}
+	sc.findings[description] = true
+	return true
+}
+
+// syncSharedCorpus publishes the programs the strategy added to its
+// population since the last call and seeds it with the ones the other
+// workers published.
+func (cu *Control) syncSharedCorpus() {
+	sharer, ok := cu.strat.(CorpusSharer)
+	if cu.Shared == nil || !ok {
+		return
+	}
+	cu.Shared.Publish(cu.Worker, sharer.TakeNewPrograms()...)
+	var programs []*epb.Program
+	programs, cu.sharedCursor = cu.Shared.Fetch(cu.Worker, cu.sharedCursor)
+	if len(programs) > 0 {
+		sharer.AddSeeds(programs...)
+	}
+}
+
+// RunWorkers calls `run` with each control unit of `workers` in its own
+// goroutine and waits for all of them to return. Each control unit needs its
+// own FFI and strategy, they can share a SharedCorpus. Returns the errors of
+// the workers that failed.
+func RunWorkers(workers []*Control, run func(cu *Control) error) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(workers))
+	for i, cu := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := run(cu); err != nil {
+				errs[i] = fmt.Errorf("worker %d: %w", i, err)
+				if len(workers) > 1 {
+					// The others keep going, possibly forever.
+					logging.Warningf("Worker %d stopped: %v\n", i, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,104 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	epb "buzzer/proto/ebpf_go_proto"
+)
+
+// sharingStrategy is an idleStrategy with a population that can be shared.
+type sharingStrategy struct {
+	idleStrategy
+	newPrograms []*epb.Program
+	seeds       []*epb.Program
+}
+
+func (s *sharingStrategy) TakeNewPrograms() []*epb.Program {
+	programs := s.newPrograms
+	s.newPrograms = nil
+	return programs
+}
+
+func (s *sharingStrategy) AddSeeds(programs ...*epb.Program) {
+	s.seeds = append(s.seeds, programs...)
+}
+
+func TestSharedCorpus(t *testing.T) {
+	shared := NewSharedCorpus()
+	progs := []*epb.Program{{}, {}, {}}
+	strats := []*sharingStrategy{
+		{newPrograms: progs[:2]},
+		{newPrograms: progs[2:]},
+	}
+	var workers []*Control
+	for i, strat := range strats {
+		cu := &Control{Shared: shared, Worker: i}
+		cu.Init(&FFI{}, nil, strat)
+		workers = append(workers, cu)
+	}
+
+	workers[0].syncSharedCorpus()
+	workers[1].syncSharedCorpus()
+	workers[0].syncSharedCorpus()
+	// Nothing new the second time.
+	workers[1].syncSharedCorpus()
+
+	if got := shared.Len(); got != 3 {
+		t.Errorf("Len() = %d, want 3", got)
+	}
+	if got := strats[0].seeds; len(got) != 1 || got[0] != progs[2] {
+		t.Errorf("worker 0 seeds = %v, want the program of worker 1", got)
+	}
+	if got := strats[1].seeds; len(got) != 2 || got[0] != progs[0] || got[1] != progs[1] {
+		t.Errorf("worker 1 seeds = %v, want the programs of worker 0", got)
+	}
+
+	if !shared.ClaimFinding("R1 invalid mem access") {
+		t.Errorf("ClaimFinding() of a new finding = false, want true")
+	}
+	if shared.ClaimFinding("R1 invalid mem access") {
+		t.Errorf("ClaimFinding() of a reported finding = true, want false")
+	}
+}
+
+func TestRunWorkers(t *testing.T) {
+	var workers []*Control
+	for i := 0; i < 4; i++ {
+		cu := &Control{Worker: i}
+		cu.Init(&FFI{}, nil, &idleStrategy{programs: 5})
+		workers = append(workers, cu)
+	}
+
+	failure := errors.New("no kernel")
+	err := RunWorkers(workers, func(cu *Control) error {
+		if cu.Worker == 2 {
+			return failure
+		}
+		return cu.RunFuzzer()
+	})
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), "worker 2") {
+		t.Errorf("RunWorkers() = %v, want the failure of worker 2", err)
+	}
+	for i, cu := range workers {
+		attempts := cu.strat.(*idleStrategy).attempts
+		if want := 5; i != 2 && attempts != want {
+			t.Errorf("worker %d made %d attempts, want %d", i, attempts, want)
+		}
+	}
+}
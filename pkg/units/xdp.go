@@ -0,0 +1,99 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	fpb "buzzer/proto/ffi_go_proto"
+)
+
+const (
+	// Actions of enum xdp_action the XdpHarness tells apart.
+	XdpDrop = 1
+	XdpPass = 2
+	XdpTx   = 3
+
+	// defaultXdpTimeout is how long the XdpHarness waits for a packet
+	// before considering it dropped.
+	defaultXdpTimeout = 50 * time.Millisecond
+)
+
+// XdpHarness executes XDP programs on a veth pair, see setup.CreateVethPair.
+// The programs are attached to one end and the packet they run on, the test
+// run data of the control unit, is injected on the other. Where the packet
+// shows up tells the action the program took: the execution result has
+// XdpPass in retval if it reached the end the program is attached to, XdpTx
+// if it came back on the other one and XdpDrop if it was seen on neither,
+// which covers XDP_ABORTED and XDP_REDIRECT too. data_out is the packet as
+// it was seen.
+type XdpHarness struct {
+	Ifindex     int
+	PeerIfindex int
+
+	// Native attaches the programs to the veth driver instead of in
+	// generic mode.
+	Native bool
+
+	// Timeout is how long to wait for the packet to show up.
+	Timeout time.Duration
+}
+
+// NewXdpHarness returns an XdpHarness that attaches the programs to the
+// interface `iface` and injects the packets on `peer`.
+func NewXdpHarness(iface, peer string, native bool) (*XdpHarness, error) {
+	attached, err := net.InterfaceByName(iface)
+	if err != nil {
+		return nil, err
+	}
+	injected, err := net.InterfaceByName(peer)
+	if err != nil {
+		return nil, err
+	}
+	return &XdpHarness{
+		Ifindex:     attached.Index,
+		PeerIfindex: injected.Index,
+		Native:      native,
+		Timeout:     defaultXdpTimeout,
+	}, nil
+}
+
+// request returns the request that runs the program `progFd` on `packet`.
+func (xh *XdpHarness) request(progFd int64, packet []byte) *fpb.XdpRequest {
+	return &fpb.XdpRequest{
+		ProgFd:      progFd,
+		Ifindex:     int32(xh.Ifindex),
+		PeerIfindex: int32(xh.PeerIfindex),
+		Packet:      packet,
+		Native:      xh.Native,
+		TimeoutMs:   uint32(xh.Timeout.Milliseconds()),
+	}
+}
+
+// XdpActionName returns the name of the action `retval` of an execution on
+// the XdpHarness.
+func XdpActionName(retval uint32) string {
+	switch retval {
+	case XdpDrop:
+		return "XDP_DROP"
+	case XdpPass:
+		return "XDP_PASS"
+	case XdpTx:
+		return "XDP_TX"
+	}
+	return fmt.Sprintf("unknown action %d", retval)
+}
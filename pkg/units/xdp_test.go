@@ -0,0 +1,50 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package units
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNewXdpHarnessUnknownInterface(t *testing.T) {
+	if _, err := NewXdpHarness("buzzer-none0", "buzzer-none1", false); err == nil {
+		t.Errorf("NewXdpHarness() on missing interfaces did not return an error")
+	}
+}
+
+func TestXdpHarnessRequest(t *testing.T) {
+	xh := &XdpHarness{Ifindex: 4, PeerIfindex: 5, Native: true, Timeout: 20 * time.Millisecond}
+	req := xh.request(7, []byte{1, 2, 3})
+	if req.GetProgFd() != 7 || req.GetIfindex() != 4 || req.GetPeerIfindex() != 5 || !req.GetNative() || req.GetTimeoutMs() != 20 {
+		t.Errorf("request(7) = %v, want program 7 attached natively to 4 and fed from 5 for 20ms", req)
+	}
+	if got := len(req.GetPacket()); got != 3 {
+		t.Errorf("request(7) injects %d bytes, want 3", got)
+	}
+}
+
+func TestXdpActionName(t *testing.T) {
+	for retval, want := range map[uint32]string{
+		XdpDrop: "XDP_DROP",
+		XdpPass: "XDP_PASS",
+		XdpTx:   "XDP_TX",
+		9:       "unknown action 9",
+	} {
+		if got := XdpActionName(retval); got != want {
+			t.Errorf("XdpActionName(%d) = %q, want %q", retval, got, want)
+		}
+	}
+}
@@ -0,0 +1,356 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package vm boots a kernel in QEMU with buzzer running inside and restarts
+// it every time the kernel crashes or hangs, so a crash does not end the
+// campaign. The console of the VM is watched to detect the crashes, and the
+// program buzzer announced last, see corpus.ConsoleMarker, is saved along
+// with the report.
+package vm
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"buzzer/pkg/corpus/corpus"
+	cpb "buzzer/proto/corpus_go_proto"
+)
+
+const (
+	// DefaultQEMU is the binary that runs the VM when the config does not
+	// name one.
+	DefaultQEMU = "qemu-system-x86_64"
+
+	// DefaultHangTimeout is how long the console can stay silent before
+	// the VM is considered hung. buzzer announces every program it loads,
+	// a healthy VM prints much more often than that.
+	DefaultHangTimeout = 3 * time.Minute
+
+	// baseCmdline turns every oops and warning into a panic, so the VM
+	// stops right after the first report, and makes QEMU exit, instead of
+	// rebooting, on a panic.
+	baseCmdline = "console=ttyS0 root=/dev/vda rw earlyprintk=serial oops=panic panic_on_warn=1 panic=-1 net.ifnames=0"
+
+	// Once a crash is detected, the console is read for this long, or
+	// until QEMU exits, to get the rest of the report.
+	reportGrace = 10 * time.Second
+
+	// Reports longer than this many lines are truncated, and only the last
+	// lines of the console are kept.
+	maxReportLines  = 200
+	maxConsoleLines = 1000
+)
+
+var (
+	// crashStart matches the console lines that start a kernel report,
+	// crashes as well as hangs the kernel detects itself.
+	crashStart = regexp.MustCompile(`(Kernel panic|BUG: |kernel BUG at|general protection fault|Oops: |KASAN: |UBSAN: |WARNING: CPU|Unable to handle kernel|soft lockup|hard LOCKUP|detected stalls|blocked for more than)`)
+
+	// consolePrefix matches the timestamp and caller id the kernel puts
+	// in front of its messages, e.g. "[   12.345678][ T123] ".
+	consolePrefix = regexp.MustCompile(`^(\[[^\]]*\]\s*)+`)
+)
+
+// Config describes the VM and how it is watched.
+type Config struct {
+	// QEMU is the binary that runs the VM, DefaultQEMU if empty.
+	QEMU string
+
+	// Kernel is the kernel image booted, e.g. a bzImage, and Image the
+	// disk image with the root file system. The image must start buzzer
+	// with --console_markers, its output going to the console. Writes to
+	// the image are discarded, every boot starts from the same state.
+	Kernel string
+	Image  string
+
+	// Init, if set, is the program the kernel starts instead of the init
+	// of the image, e.g. a script that starts buzzer.
+	Init string
+
+	// Cmdline is appended to the kernel command line.
+	Cmdline string
+
+	MemoryMB int
+	CPUs     int
+	KVM      bool
+
+	// ExtraArgs are appended to the arguments of QEMU, e.g. to forward the
+	// port of the control service of buzzer to the host.
+	ExtraArgs []string
+
+	// HangTimeout is how long the console can stay silent before the VM is
+	// considered hung, DefaultHangTimeout if zero.
+	HangTimeout time.Duration
+
+	// CrashDir is where the crashes are saved, each one in its own
+	// directory.
+	CrashDir string
+
+	// OnCrash, if set, is called with every crash once it was saved.
+	OnCrash func(c *Crash)
+}
+
+// Crash is a crash or a hang of the kernel of the VM.
+type Crash struct {
+	// Title is the first line of the report, without its timestamp, or a
+	// description of the hang if the console went silent.
+	Title string
+
+	// Hang is set if the VM was killed because its console went silent.
+	Hang bool
+
+	// Report is the report of the kernel, from its first line, and Console
+	// the last lines of the console, both without the program markers.
+	Report  string
+	Console string
+
+	// Entry is the program buzzer announced last, likely the one that
+	// crashed the kernel, nil if it did not announce any.
+	Entry *cpb.CorpusEntry
+
+	// Dir is where the crash was saved.
+	Dir string
+}
+
+// Manager boots the VM of its config again and again.
+type Manager struct {
+	cfg Config
+}
+
+// NewManager returns a manager of the VM described by `cfg`.
+func NewManager(cfg Config) *Manager {
+	if cfg.QEMU == "" {
+		cfg.QEMU = DefaultQEMU
+	}
+	if cfg.HangTimeout == 0 {
+		cfg.HangTimeout = DefaultHangTimeout
+	}
+	return &Manager{cfg: cfg}
+}
+
+// qemuArgs returns the arguments QEMU is started with.
+func (m *Manager) qemuArgs() []string {
+	cmdline := baseCmdline
+	if m.cfg.Init != "" {
+		cmdline += " init=" + m.cfg.Init
+	}
+	if m.cfg.Cmdline != "" {
+		cmdline += " " + m.cfg.Cmdline
+	}
+	args := []string{
+		"-kernel", m.cfg.Kernel,
+		"-append", cmdline,
+		"-drive", fmt.Sprintf("file=%s,format=raw,if=virtio", m.cfg.Image),
+		"-snapshot",
+		"-display", "none",
+		"-serial", "stdio",
+		"-no-reboot",
+	}
+	if m.cfg.MemoryMB > 0 {
+		args = append(args, "-m", strconv.Itoa(m.cfg.MemoryMB))
+	}
+	if m.cfg.CPUs > 0 {
+		args = append(args, "-smp", strconv.Itoa(m.cfg.CPUs))
+	}
+	if m.cfg.KVM {
+		args = append(args, "-enable-kvm", "-cpu", "host")
+	}
+	return append(args, m.cfg.ExtraArgs...)
+}
+
+// Run boots the VM and, every time its kernel crashes or hangs, saves the
+// crash and boots it again. Returns when `ctx` is done, with a nil error, or
+// when the VM stops on its own: nil if QEMU exited cleanly, e.g. because the
+// campaign was over and the guest powered off, an error otherwise.
+func (m *Manager) Run(ctx context.Context) error {
+	for boot := 1; ctx.Err() == nil; boot++ {
+		fmt.Printf("Booting the VM, boot %d\n", boot)
+		crash, err := m.boot(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if crash == nil {
+			fmt.Printf("The VM shut down\n")
+			return nil
+		}
+		if err := m.saveCrash(crash); err != nil {
+			return fmt.Errorf("saving the crash %q: %w", crash.Title, err)
+		}
+		fmt.Printf("VM crashed: %s, saved in %s\n", crash.Title, crash.Dir)
+		if m.cfg.OnCrash != nil {
+			m.cfg.OnCrash(crash)
+		}
+	}
+	return nil
+}
+
+// boot runs the VM once, until its kernel crashes or hangs, or QEMU exits.
+// Returns the crash, nil if QEMU exited cleanly.
+func (m *Manager) boot(ctx context.Context) (*Crash, error) {
+	cmd := exec.CommandContext(ctx, m.cfg.QEMU, m.qemuArgs()...)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting %s: %w", m.cfg.QEMU, err)
+	}
+
+	lines := make(chan string)
+	go readLines(out, lines)
+	crash, console := watchConsole(lines, m.cfg.HangTimeout)
+	// QEMU is still running if the kernel hung or is still printing its
+	// report.
+	cmd.Process.Kill()
+	for range lines {
+	}
+	err = cmd.Wait()
+	if crash != nil || ctx.Err() != nil {
+		return crash, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w, last console lines:\n%s", m.cfg.QEMU, err, console)
+	}
+	return nil, nil
+}
+
+// readLines sends the lines of `r` to `lines` and closes it at the end of
+// `r`.
+func readLines(r io.Reader, lines chan<- string) {
+	defer close(lines)
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" {
+			lines <- strings.TrimRight(line, "\r\n")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// watchConsole reads the console `lines` until a crash report, a silence
+// longer than `hangTimeout` or the end of the lines. Returns the crash, nil
+// if the lines ended without one, and the last lines of the console.
+func watchConsole(lines <-chan string, hangTimeout time.Duration) (*Crash, string) {
+	var console, report []string
+	var entry *cpb.CorpusEntry
+	var crash *Crash
+	timer := time.NewTimer(hangTimeout)
+	defer timer.Stop()
+	reset := func(d time.Duration) {
+		if !timer.Stop() {
+			// The timer fired while a line was handled.
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(d)
+	}
+	finish := func() (*Crash, string) {
+		tail := strings.Join(console, "\n")
+		if crash != nil {
+			crash.Entry = entry
+			crash.Report = strings.Join(report, "\n")
+			crash.Console = tail
+		}
+		return crash, tail
+	}
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return finish()
+			}
+			if e, ok := corpus.ParseConsoleLine(line); ok {
+				// The program markers are written by the fuzzer,
+				// not the kernel, so they stay out of the console.
+				// Until a report starts, each one records the
+				// program being run and, like any other line,
+				// shows the VM is alive. Once the report started,
+				// the programs announced by other CPUs are not the
+				// culprits.
+				if crash == nil {
+					entry = e
+					reset(hangTimeout)
+				}
+				break
+			}
+			if console = append(console, line); len(console) > maxConsoleLines {
+				console = console[1:]
+			}
+			if crash == nil && crashStart.MatchString(line) {
+				crash = &Crash{Title: consolePrefix.ReplaceAllString(line, "")}
+				reset(reportGrace)
+			}
+			if crash != nil && len(report) < maxReportLines {
+				report = append(report, line)
+			}
+			if crash == nil {
+				reset(hangTimeout)
+			}
+		case <-timer.C:
+			if crash == nil {
+				crash = &Crash{Title: fmt.Sprintf("no output from the VM for %s", hangTimeout), Hang: true}
+			}
+			return finish()
+		}
+	}
+}
+
+// saveCrash writes the report, the console and the program of `crash` to a
+// new directory of CrashDir. The program is written as a corpus file, it can
+// be replayed with --replay_corpus.
+func (m *Manager) saveCrash(crash *Crash) error {
+	if err := os.MkdirAll(m.cfg.CrashDir, 0755); err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp(m.cfg.CrashDir, "crash-*")
+	if err != nil {
+		return err
+	}
+	crash.Dir = dir
+	report := crash.Title + "\n"
+	if crash.Report != "" {
+		report += "\n" + crash.Report + "\n"
+	}
+	err = errors.Join(
+		os.WriteFile(filepath.Join(dir, "report"), []byte(report), 0644),
+		os.WriteFile(filepath.Join(dir, "console"), []byte(crash.Console+"\n"), 0644),
+	)
+	if err != nil || crash.Entry == nil {
+		return err
+	}
+	w, err := corpus.CreateWriter(filepath.Join(dir, "program"))
+	if err != nil {
+		return err
+	}
+	return errors.Join(w.Write(crash.Entry), w.Close())
+}
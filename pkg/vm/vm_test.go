@@ -0,0 +1,254 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"buzzer/pkg/corpus/corpus"
+	cpb "buzzer/proto/corpus_go_proto"
+	epb "buzzer/proto/ebpf_go_proto"
+	"github.com/golang/protobuf/proto"
+)
+
+func testEntry(imm int32) *cpb.CorpusEntry {
+	return &cpb.CorpusEntry{
+		Program:  &epb.Program{Instructions: []*epb.Instruction{{DstReg: epb.Reg_R0, Immediate: imm}}},
+		Strategy: "playground",
+	}
+}
+
+func markerLine(t *testing.T, entry *cpb.CorpusEntry) string {
+	t.Helper()
+	line, err := corpus.FormatConsoleLine(entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return line
+}
+
+func TestWatchConsole(t *testing.T) {
+	first, second := markerLine(t, testEntry(1)), markerLine(t, testEntry(2))
+	tests := []struct {
+		testName  string
+		lines     []string
+		open      bool
+		wantTitle string
+		wantHang  bool
+		wantEntry *cpb.CorpusEntry
+	}{
+		{
+			testName: "Clean shutdown",
+			lines:    []string{"[    0.000000] Linux version 6.9.0", first, "reboot: Power down"},
+		},
+		{
+			testName:  "Crash after two programs",
+			lines:     []string{first, second, "[   12.345678][ T123] BUG: KASAN: slab-out-of-bounds in bpf_check", "Call Trace:", "[   12.400000] Kernel panic - not syncing: KASAN: panic_on_warn set"},
+			wantTitle: "BUG: KASAN: slab-out-of-bounds in bpf_check",
+			wantEntry: testEntry(2),
+		},
+		{
+			testName:  "Program announced during the report",
+			lines:     []string{first, "[   12.345678] general protection fault, probably for non-canonical address", second},
+			wantTitle: "general protection fault, probably for non-canonical address",
+			wantEntry: testEntry(1),
+		},
+		{
+			testName:  "Silent console",
+			lines:     []string{first},
+			open:      true,
+			wantTitle: "no output from the VM for 50ms",
+			wantHang:  true,
+			wantEntry: testEntry(1),
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			lines := make(chan string, len(tc.lines))
+			for _, line := range tc.lines {
+				lines <- line
+			}
+			if !tc.open {
+				close(lines)
+			}
+			crash, console := watchConsole(lines, 50*time.Millisecond)
+			if strings.Contains(console, corpus.ConsoleMarker) {
+				t.Errorf("console = %q, want it without the program markers", console)
+			}
+			if tc.wantTitle == "" {
+				if crash != nil {
+					t.Errorf("watchConsole() = %+v, want no crash", crash)
+				}
+				return
+			}
+			if crash == nil {
+				t.Fatalf("watchConsole() = no crash, want %q", tc.wantTitle)
+			}
+			if crash.Title != tc.wantTitle || crash.Hang != tc.wantHang {
+				t.Errorf("crash = %q, hang %v, want %q, hang %v", crash.Title, crash.Hang, tc.wantTitle, tc.wantHang)
+			}
+			if !proto.Equal(crash.Entry, tc.wantEntry) {
+				t.Errorf("crash entry = %v, want %v", crash.Entry, tc.wantEntry)
+			}
+		})
+	}
+}
+
+func TestWatchConsoleMarkers(t *testing.T) {
+	// The VM only announces programs, for longer than the hang timeout
+	// in total but never silent for that long.
+	var markers []string
+	for i := int32(0); i < 6; i++ {
+		markers = append(markers, markerLine(t, testEntry(i)))
+	}
+	lines := make(chan string)
+	go func() {
+		for _, marker := range markers {
+			lines <- marker
+			time.Sleep(20 * time.Millisecond)
+		}
+		close(lines)
+	}()
+	if crash, _ := watchConsole(lines, 50*time.Millisecond); crash != nil {
+		t.Errorf("watchConsole() = %+v, want no crash", crash)
+	}
+}
+
+// fakeManager returns a manager of testdata/fake_qemu, which prints
+// `console` and exits with `exit` or hangs.
+func fakeManager(t *testing.T, console []string, exit string) *Manager {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "console")
+	if err := os.WriteFile(path, []byte(strings.Join(console, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FAKE_CONSOLE", path)
+	t.Setenv("FAKE_EXIT", exit)
+	return NewManager(Config{
+		QEMU:        "testdata/fake_qemu",
+		Kernel:      "bzImage",
+		Image:       "image",
+		HangTimeout: 100 * time.Millisecond,
+		CrashDir:    filepath.Join(t.TempDir(), "crashes"),
+	})
+}
+
+func TestRun(t *testing.T) {
+	marker := markerLine(t, testEntry(7))
+	tests := []struct {
+		testName  string
+		console   []string
+		exit      string
+		wantCrash string
+		wantErr   bool
+	}{
+		{
+			testName:  "Panic",
+			console:   []string{marker, "[    3.000000] Kernel panic - not syncing: Fatal exception"},
+			exit:      "0",
+			wantCrash: "Kernel panic - not syncing: Fatal exception",
+		},
+		{
+			testName:  "Hang",
+			console:   []string{marker},
+			exit:      "hang",
+			wantCrash: "no output from the VM for 100ms",
+		},
+		{
+			testName: "Guest powered off",
+			console:  []string{marker, "reboot: Power down"},
+			exit:     "0",
+		},
+		{
+			testName: "QEMU failed",
+			console:  []string{"qemu-system-x86_64: -kernel bzImage: could not open kernel file"},
+			exit:     "1",
+			wantErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.testName, func(t *testing.T) {
+			m := fakeManager(t, tc.console, tc.exit)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var crashes []*Crash
+			m.cfg.OnCrash = func(c *Crash) {
+				crashes = append(crashes, c)
+				// Restarted once.
+				if len(crashes) == 2 {
+					cancel()
+				}
+			}
+			err := m.Run(ctx)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Run() = %v, want error %v", err, tc.wantErr)
+			}
+			if tc.wantCrash == "" {
+				if len(crashes) != 0 {
+					t.Errorf("crashes = %v, want none", crashes)
+				}
+				return
+			}
+			if len(crashes) != 2 || crashes[0].Title != tc.wantCrash {
+				t.Fatalf("crashes = %v, want %q twice", crashes, tc.wantCrash)
+			}
+			report, err := os.ReadFile(filepath.Join(crashes[0].Dir, "report"))
+			if err != nil || !strings.HasPrefix(string(report), tc.wantCrash) {
+				t.Errorf("report = %q, %v, want it to start with %q", report, err, tc.wantCrash)
+			}
+			entries, err := corpus.Load(filepath.Join(crashes[0].Dir, "program"))
+			if err != nil || len(entries) != 1 || !proto.Equal(entries[0], testEntry(7)) {
+				t.Errorf("saved programs = %v, %v, want the announced one", entries, err)
+			}
+		})
+	}
+}
+
+func TestQEMUArgs(t *testing.T) {
+	m := NewManager(Config{
+		Kernel:    "bzImage",
+		Image:     "bullseye.img",
+		Init:      "/root/run_buzzer.sh",
+		Cmdline:   "kasan.fault=panic",
+		MemoryMB:  2048,
+		CPUs:      2,
+		KVM:       true,
+		ExtraArgs: []string{"-net", "user,hostfwd=tcp::8090-:8090"},
+	})
+	got := strings.Join(m.qemuArgs(), " ")
+	for _, want := range []string{
+		"-kernel bzImage",
+		"init=/root/run_buzzer.sh kasan.fault=panic",
+		"file=bullseye.img,",
+		"-snapshot",
+		"-no-reboot",
+		"-m 2048 -smp 2 -enable-kvm",
+		"-net user,hostfwd=tcp::8090-:8090",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("qemuArgs() = %q, want it to contain %q", got, want)
+		}
+	}
+	if m.cfg.QEMU != DefaultQEMU || m.cfg.HangTimeout != DefaultHangTimeout {
+		t.Errorf("config = %+v, want the default QEMU and hang timeout", m.cfg)
+	}
+}
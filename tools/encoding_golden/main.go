@@ -0,0 +1,52 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// encoding_golden writes the golden encodings of every eBPF instruction
+// builder, used by the encoder tests to catch unintended encoding changes.
+//
+// Regenerate the golden file after an intentional change with:
+//
+//	bazel run //tools/encoding_golden -- -output=$PWD/pkg/ebpf/testdata/encoding_golden.json
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"buzzer/pkg/ebpf/ebpf"
+)
+
+var output = flag.String("output", "", "File to write the golden encodings to, stdout if empty")
+
+func main() {
+	flag.Parse()
+	cases, err := ebpf.GoldenEncodings()
+	if err != nil {
+		log.Fatalf("failed to encode instructions: %v", err)
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatalf("failed to create %s: %v", *output, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := ebpf.WriteGoldenEncodings(w, cases); err != nil {
+		log.Fatalf("failed to write golden encodings: %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2024 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// vm_manager boots a kernel in QEMU with buzzer running inside and boots it
+// again every time the kernel crashes or hangs, saving the program that was
+// running and the report of the kernel.
+//
+// The image must start buzzer with --console_markers, e.g. from the script
+// given as --init:
+//
+//	bazel run //tools/vm_manager -- --kernel=$PWD/bzImage --image=$PWD/bullseye.img --init=/root/run_buzzer.sh --crash_dir=$PWD/crashes --kvm
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"buzzer/pkg/vm/vm"
+)
+
+var (
+	qemu        = flag.String("qemu", vm.DefaultQEMU, "QEMU binary that runs the VM")
+	kernel      = flag.String("kernel", "", "Kernel image to boot, e.g. a bzImage")
+	image       = flag.String("image", "", "Disk image with the root file system, writes to it are discarded")
+	initPath    = flag.String("init", "", "Program the kernel starts instead of the init of the image, e.g. a script that starts buzzer")
+	cmdline     = flag.String("cmdline", "", "Appended to the kernel command line")
+	memory      = flag.Int("memory", 2048, "Memory of the VM, in MB")
+	cpus        = flag.Int("cpus", 2, "Number of CPUs of the VM")
+	kvm         = flag.Bool("kvm", false, "Use KVM")
+	qemuArgs    = flag.String("qemu_args", "", "Space separated arguments appended to the ones of QEMU, e.g. to forward the port of the control service")
+	hangTimeout = flag.Duration("hang_timeout", vm.DefaultHangTimeout, "How long the console can stay silent before the VM is considered hung")
+	crashDir    = flag.String("crash_dir", "crashes", "Directory the crashes are saved to, each one in its own directory")
+)
+
+func main() {
+	flag.Parse()
+	if *kernel == "" || *image == "" {
+		log.Fatalf("kernel and image are required")
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m := vm.NewManager(vm.Config{
+		QEMU:        *qemu,
+		Kernel:      *kernel,
+		Image:       *image,
+		Init:        *initPath,
+		Cmdline:     *cmdline,
+		MemoryMB:    *memory,
+		CPUs:        *cpus,
+		KVM:         *kvm,
+		ExtraArgs:   strings.Fields(*qemuArgs),
+		HangTimeout: *hangTimeout,
+		CrashDir:    *crashDir,
+	})
+	if err := m.Run(ctx); err != nil {
+		log.Fatal(err)
+	}
+}